@@ -21,6 +21,7 @@ import (
 	"github.com/wavetermdev/waveterm/pkg/panichandler"
 	"github.com/wavetermdev/waveterm/pkg/remote/conncontroller"
 	"github.com/wavetermdev/waveterm/pkg/service"
+	"github.com/wavetermdev/waveterm/pkg/shellexec"
 	"github.com/wavetermdev/waveterm/pkg/telemetry"
 	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
 	"github.com/wavetermdev/waveterm/pkg/wavebase"
@@ -95,10 +96,17 @@ func stdinReadWatch() {
 func configWatcher() {
 	watcher := wconfig.GetWatcher()
 	if watcher != nil {
+		watcher.RegisterUpdateHandler(applyShellSettings)
 		watcher.Start()
 	}
 }
 
+// called with the initial settings and again every time they change
+func applyShellSettings(fullConfig wconfig.FullConfigType) {
+	settings := fullConfig.Settings
+	shellexec.SetWarmPool(shellexec.WarmPoolOpts{Size: settings.TermWarmPool, ConfigKey: settings.TermLocalShellPath})
+}
+
 func telemetryLoop() {
 	var nextSend int64
 	time.Sleep(InitialTelemetryWait)
@@ -206,6 +214,41 @@ func clearTempFiles() error {
 	return nil
 }
 
+// the shells a previous wavesrv left running are kept (they may be doing something the user
+// wants), adopting them makes them show up in the shellexec audit (and shellproclist lists them as orphaned).
+// returns the session ids of the adopted shells
+func adoptOrphanedShells() []string {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelFn()
+	orphans, err := shellexec.FindOrphanedShells(ctx)
+	if err != nil {
+		log.Printf("error finding orphaned shells: %v\n", err)
+		return nil
+	}
+	for _, orphan := range orphans {
+		log.Printf("found orphaned shell %s (block %s, pid %d, alive:%v), %d processes left\n", orphan.SessionId, orphan.BlockId, orphan.Pid, orphan.ShellAlive, len(orphan.Procs))
+	}
+	result := shellexec.HandleOrphanedShells(orphans, shellexec.OrphanOpts{Adopt: true})
+	return result.Adopted
+}
+
+// opt-in (metrics:listenaddr), read at startup
+func startMetricsServer() {
+	settings := wconfig.GetWatcher().GetFullConfig().Settings
+	if settings.MetricsListenAddr == "" {
+		return
+	}
+	metricsListener, err := web.MakeMetricsListener(settings.MetricsListenAddr)
+	if err != nil {
+		log.Printf("error creating metrics listener: %v\n", err)
+		return
+	}
+	go func() {
+		defer panichandler.PanicHandler("RunMetricsServer")
+		web.RunMetricsServer(metricsListener, settings.MetricsBearerToken)
+	}()
+}
+
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 	log.SetPrefix("[wavesrv] ")
@@ -286,6 +329,17 @@ func main() {
 		log.Printf("error clearing temp files: %v\n", err)
 		return
 	}
+	err = shellexec.EnableSubreaper()
+	if err != nil && err != shellexec.ErrSubreaperNotSupported {
+		log.Printf("error enabling the shell subreaper: %v\n", err)
+	}
+	adopted := adoptOrphanedShells()
+	shellexec.InitProcStore()
+	// (after the adoption, the adopted shells may still be using theirs)
+	err = shellexec.SweepScratchDirs(adopted)
+	if err != nil {
+		log.Printf("error removing old scratch dirs: %v\n", err)
+	}
 
 	createMainWshClient()
 	installShutdownSignalHandlers()
@@ -304,6 +358,7 @@ func main() {
 		return
 	}
 	go web.RunWebSocketServer(wsListener)
+	startMetricsServer()
 	unixListener, err := web.MakeUnixListener()
 	if err != nil {
 		log.Printf("error creating unix listener: %v\n", err)
@@ -0,0 +1,9 @@
+CREATE TABLE db_shellproc (
+    sessionid varchar(128) PRIMARY KEY,
+    blockid varchar(36) NOT NULL,
+    pid int NOT NULL,
+    ptypath varchar(100) NOT NULL,
+    cwd text NOT NULL,
+    startts bigint NOT NULL,
+    ownerpid int NOT NULL
+);
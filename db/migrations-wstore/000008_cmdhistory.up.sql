@@ -0,0 +1,12 @@
+CREATE TABLE db_cmdhistory (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    blockid varchar(36) NOT NULL,
+    connname varchar(300) NOT NULL,
+    sessionid varchar(128) NOT NULL,
+    command text NOT NULL,
+    cwd text NOT NULL,
+    exitcode int,
+    startts bigint NOT NULL,
+    durationms bigint NOT NULL
+);
+CREATE INDEX db_cmdhistory_blockid ON db_cmdhistory (blockid);
@@ -27,6 +27,11 @@ class RpcApiType {
         return client.wshRpcCall("blockinfo", data, opts);
     }
 
+    // command "cmdhistoryquery" [call]
+    CmdHistoryQueryCommand(client: WshClient, data: CmdHistoryQuery, opts?: RpcOpts): Promise<CmdHistoryEntry[]> {
+        return client.wshRpcCall("cmdhistoryquery", data, opts);
+    }
+
     // command "connconnect" [call]
     ConnConnectCommand(client: WshClient, data: ConnRequest, opts?: RpcOpts): Promise<void> {
         return client.wshRpcCall("connconnect", data, opts);
@@ -287,6 +292,11 @@ class RpcApiType {
         return client.wshRpcCall("setview", data, opts);
     }
 
+    // command "shellproclist" [call]
+    ShellProcListCommand(client: WshClient, opts?: RpcOpts): Promise<ShellProcInfo[]> {
+        return client.wshRpcCall("shellproclist", null, opts);
+    }
+
     // command "streamcpudata" [responsestream]
 	StreamCpuDataCommand(client: WshClient, data: CpuDataRequest, opts?: RpcOpts): AsyncGenerator<TimeSeriesData, void, boolean> {
         return client.wshRpcStream("streamcpudata", data, opts);
@@ -38,6 +38,7 @@ declare global {
         blocks?: {[key: string]: number};
         wshcmds?: {[key: string]: number};
         conn?: {[key: string]: number};
+        shellstartup?: {[key: string]: number};
     };
 
     // wshrpc.AiMessageData
@@ -60,6 +61,7 @@ declare global {
         shellprocstatus?: string;
         shellprocconnname?: string;
         shellprocexitcode: number;
+        shellprochealth?: HealthStatus;
     };
 
     // waveobj.BlockDef
@@ -98,6 +100,28 @@ declare global {
         newactivetabid?: string;
     };
 
+    // wshrpc.CmdHistoryEntry
+    type CmdHistoryEntry = {
+        id: number;
+        blockid?: string;
+        connname?: string;
+        sessionid: string;
+        command: string;
+        cwd?: string;
+        exitcode?: number;
+        startts: number;
+        durationms: number;
+    };
+
+    // wshrpc.CmdHistoryQuery
+    type CmdHistoryQuery = {
+        blockid?: string;
+        connname?: string;
+        contains?: string;
+        beforeid?: number;
+        limit?: number;
+    };
+
     // wshrpc.CommandAppendIJsonData
     type CommandAppendIJsonData = {
         zoneid: string;
@@ -392,6 +416,17 @@ declare global {
         data64: string;
     };
 
+    // shellexec.HealthStatus
+    type HealthStatus = {
+        state: string;
+        healthy: boolean;
+        procalive: boolean;
+        zombie?: boolean;
+        ptyreadable: boolean;
+        ptywritable: boolean;
+        error?: string;
+    };
+
     // waveobj.LayoutActionData
     type LayoutActionData = {
         actiontype: string;
@@ -454,6 +489,10 @@ declare global {
         "cmd:nowsh"?: boolean;
         "cmd:args"?: string[];
         "cmd:shell"?: boolean;
+        "cmd:historyscope"?: string;
+        "cmd:elevate"?: boolean;
+        "cmd:elevatetool"?: string;
+        "cmd:termtype"?: string;
         "ai:*"?: boolean;
         "ai:preset"?: string;
         "ai:apitype"?: string;
@@ -487,6 +526,7 @@ declare global {
         "term:localshellpath"?: string;
         "term:localshellopts"?: string[];
         "term:scrollback"?: number;
+        "term:cmdhistory"?: boolean;
         "term:vdomblockid"?: string;
         "term:vdomtoolbarblockid"?: string;
         "vdom:*"?: boolean;
@@ -623,6 +663,8 @@ declare global {
         "term:localshellopts"?: string[];
         "term:scrollback"?: number;
         "term:copyonselect"?: boolean;
+        "term:warmpool"?: number;
+        "term:cmdhistory"?: boolean;
         "editor:minimapenabled"?: boolean;
         "editor:stickyscrollenabled"?: boolean;
         "editor:wordwrap"?: boolean;
@@ -658,11 +700,27 @@ declare global {
         "window:magnifiedblockblursecondarypx"?: number;
         "telemetry:*"?: boolean;
         "telemetry:enabled"?: boolean;
+        "metrics:*"?: boolean;
+        "metrics:listenaddr"?: string;
+        "metrics:bearertoken"?: string;
         "conn:*"?: boolean;
         "conn:askbeforewshinstall"?: boolean;
         "conn:wshenabled"?: boolean;
     };
 
+    // wshrpc.ShellProcInfo
+    type ShellProcInfo = {
+        sessionid: string;
+        blockid?: string;
+        connname?: string;
+        pid?: number;
+        ptypath?: string;
+        cwd?: string;
+        startts: number;
+        ownerpid: number;
+        orphaned?: boolean;
+    };
+
     // waveobj.StickerClickOptsType
     type StickerClickOptsType = {
         sendinput?: string;
@@ -21,7 +21,9 @@ import (
 	"github.com/wavetermdev/waveterm/pkg/remote"
 	"github.com/wavetermdev/waveterm/pkg/remote/conncontroller"
 	"github.com/wavetermdev/waveterm/pkg/shellexec"
+	"github.com/wavetermdev/waveterm/pkg/telemetry"
 	"github.com/wavetermdev/waveterm/pkg/util/envutil"
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
 	"github.com/wavetermdev/waveterm/pkg/util/utilfn"
 	"github.com/wavetermdev/waveterm/pkg/wavebase"
 	"github.com/wavetermdev/waveterm/pkg/waveobj"
@@ -88,6 +90,9 @@ type BlockControllerRuntimeStatus struct {
 	ShellProcStatus   string `json:"shellprocstatus,omitempty"`
 	ShellProcConnName string `json:"shellprocconnname,omitempty"`
 	ShellProcExitCode int    `json:"shellprocexitcode"`
+
+	// set while the shell is running, see shellexec.HealthStatus.Disconnected
+	ShellProcHealth *shellexec.HealthStatus `json:"shellprochealth,omitempty"`
 }
 
 func (bc *BlockController) WithLock(f func()) {
@@ -98,6 +103,7 @@ func (bc *BlockController) WithLock(f func()) {
 
 func (bc *BlockController) GetRuntimeStatus() *BlockControllerRuntimeStatus {
 	var rtn BlockControllerRuntimeStatus
+	var shellProc *shellexec.ShellProc
 	bc.WithLock(func() {
 		bc.StatusVersion++
 		rtn.Version = bc.StatusVersion
@@ -107,7 +113,15 @@ func (bc *BlockController) GetRuntimeStatus() *BlockControllerRuntimeStatus {
 			rtn.ShellProcConnName = bc.ShellProc.ConnName
 		}
 		rtn.ShellProcExitCode = bc.ShellProcExitCode
+		if bc.ShellProcStatus == Status_Running {
+			shellProc = bc.ShellProc
+		}
 	})
+	if shellProc != nil {
+		// (reads the process table, not done under the lock)
+		health := shellProc.HealthCheck()
+		rtn.ShellProcHealth = &health
+	}
 	return &rtn
 }
 
@@ -316,82 +330,18 @@ func (bc *BlockController) DoRunShellCommand(rc *RunShellOpts, blockMeta waveobj
 	} else {
 		return fmt.Errorf("unknown controller type %q", bc.ControllerType)
 	}
-	var shellProc *shellexec.ShellProc
-	if strings.HasPrefix(remoteName, "wsl://") {
-		wslName := strings.TrimPrefix(remoteName, "wsl://")
-		credentialCtx, cancelFunc := context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancelFunc()
-
-		wslConn := wsl.GetWslConn(credentialCtx, wslName, false)
-		connStatus := wslConn.DeriveConnStatus()
-		if connStatus.Status != conncontroller.Status_Connected {
-			return fmt.Errorf("not connected, cannot start shellproc")
-		}
-
-		// create jwt
-		if !blockMeta.GetBool(waveobj.MetaKey_CmdNoWsh, false) {
-			jwtStr, err := wshutil.MakeClientJWTToken(wshrpc.RpcContext{TabId: bc.TabId, BlockId: bc.BlockId, Conn: wslConn.GetName()}, wslConn.GetDomainSocketName())
-			if err != nil {
-				return fmt.Errorf("error making jwt token: %w", err)
-			}
-			cmdOpts.Env[wshutil.WaveJwtTokenVarName] = jwtStr
-		}
-		shellProc, err = shellexec.StartWslShellProc(ctx, rc.TermSize, cmdStr, cmdOpts, wslConn)
-		if err != nil {
-			return err
-		}
-	} else if remoteName != "" {
-		credentialCtx, cancelFunc := context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancelFunc()
-
-		opts, err := remote.ParseOpts(remoteName)
-		if err != nil {
-			return err
-		}
-		conn := conncontroller.GetConn(credentialCtx, opts, false, &wshrpc.ConnKeywords{})
-		connStatus := conn.DeriveConnStatus()
-		if connStatus.Status != conncontroller.Status_Connected {
-			return fmt.Errorf("not connected, cannot start shellproc")
-		}
-		if !blockMeta.GetBool(waveobj.MetaKey_CmdNoWsh, false) {
-			jwtStr, err := wshutil.MakeClientJWTToken(wshrpc.RpcContext{TabId: bc.TabId, BlockId: bc.BlockId, Conn: conn.Opts.String()}, conn.GetDomainSocketName())
-			if err != nil {
-				return fmt.Errorf("error making jwt token: %w", err)
-			}
-			cmdOpts.Env[wshutil.WaveJwtTokenVarName] = jwtStr
-		}
-		if !conn.WshEnabled.Load() {
-			shellProc, err = shellexec.StartRemoteShellProcNoWsh(rc.TermSize, cmdStr, cmdOpts, conn)
-			if err != nil {
-				return err
-			}
-		} else {
-			shellProc, err = shellexec.StartRemoteShellProc(rc.TermSize, cmdStr, cmdOpts, conn)
-			if err != nil {
-				conn.WithLock(func() {
-					conn.WshError = err.Error()
-				})
-				conn.WshEnabled.Store(false)
-				log.Printf("error starting remote shell proc with wsh: %v", err)
-				log.Print("attempting install without wsh")
-				shellProc, err = shellexec.StartRemoteShellProcNoWsh(rc.TermSize, cmdStr, cmdOpts, conn)
-				if err != nil {
-					return err
-				}
-			}
-		}
-		if err != nil {
-			return err
-		}
-	} else {
-		// local terminal
-		if !blockMeta.GetBool(waveobj.MetaKey_CmdNoWsh, false) {
-			jwtStr, err := wshutil.MakeClientJWTToken(wshrpc.RpcContext{TabId: bc.TabId, BlockId: bc.BlockId}, wavebase.GetDomainSocketName())
-			if err != nil {
-				return fmt.Errorf("error making jwt token: %w", err)
-			}
-			cmdOpts.Env[wshutil.WaveJwtTokenVarName] = jwtStr
-		}
+	cmdOpts.BlockId = bc.BlockId
+	cmdOpts.TermType = blockMeta.GetString(waveobj.MetaKey_CmdTermType, "")
+	cmdOpts.HistoryScope = blockMeta.GetString(waveobj.MetaKey_CmdHistoryScope, "")
+	if !shellutil.IsValidHistoryScope(cmdOpts.HistoryScope) {
+		log.Printf("invalid %s %q for block %s, using shared history\n", waveobj.MetaKey_CmdHistoryScope, cmdOpts.HistoryScope, bc.BlockId)
+		cmdOpts.HistoryScope = shellutil.HistoryScope_Shared
+	}
+	// off by default, the commands can hold secrets
+	if blockMeta.GetBool(waveobj.MetaKey_TermCmdHistory, wconfig.GetWatcher().GetFullConfig().Settings.TermCmdHistory) {
+		cmdOpts.History = shellexec.DefaultCommandHistory()
+	}
+	if shellexec.IsLocalConn(remoteName) {
 		settings := wconfig.GetWatcher().GetFullConfig().Settings
 		if settings.TermLocalShellPath != "" {
 			cmdOpts.ShellPath = settings.TermLocalShellPath
@@ -405,10 +355,17 @@ func (bc *BlockController) DoRunShellCommand(rc *RunShellOpts, blockMeta waveobj
 		if len(blockMeta.GetStringList(waveobj.MetaKey_TermLocalShellOpts)) > 0 {
 			cmdOpts.ShellOpts = append([]string{}, blockMeta.GetStringList(waveobj.MetaKey_TermLocalShellOpts)...)
 		}
-		shellProc, err = shellexec.StartShellProc(rc.TermSize, cmdStr, cmdOpts)
-		if err != nil {
-			return err
-		}
+		cmdOpts.Elevate = blockMeta.GetBool(waveobj.MetaKey_CmdElevate, false)
+		cmdOpts.ElevateTool = blockMeta.GetString(waveobj.MetaKey_CmdElevateTool, "")
+		cmdOpts.MeasurePromptReady = wavebase.IsDevMode()
+	}
+	startOpts := shellexec.BackendStartOpts{TermSize: rc.TermSize, CmdStr: cmdStr, CmdOpts: cmdOpts}
+	if !blockMeta.GetBool(waveobj.MetaKey_CmdNoWsh, false) {
+		startOpts.RpcContext = &wshrpc.RpcContext{TabId: bc.TabId, BlockId: bc.BlockId}
+	}
+	shellProc, err := shellexec.StartConnShellProc(ctx, remoteName, startOpts)
+	if err != nil {
+		return err
 	}
 	bc.UpdateControllerAndSendUpdate(func() bool {
 		bc.ShellProc = shellProc
@@ -423,28 +380,38 @@ func (bc *BlockController) DoRunShellCommand(rc *RunShellOpts, blockMeta waveobj
 	wshProxy := wshutil.MakeRpcProxy()
 	wshProxy.SetRpcContext(&wshrpc.RpcContext{TabId: bc.TabId, BlockId: bc.BlockId})
 	wshutil.DefaultRouter.RegisterRoute(wshutil.MakeControllerRouteId(bc.BlockId), wshProxy, true)
-	ptyBuffer := wshutil.MakePtyBuffer(wshutil.WaveOSCPrefix, shellProc.Cmd, wshProxy.FromRemoteCh)
+	ptyBuffer := wshutil.MakePtyBuffer(wshutil.WaveOSCPrefix, shellProc, wshProxy.FromRemoteCh)
 	go func() {
 		// handles regular output from the pty (goes to the blockfile and xterm)
 		defer panichandler.PanicHandler("blockcontroller:shellproc-pty-read-loop")
 		defer func() {
-			log.Printf("[shellproc] pty-read loop done\n")
+			log.Printf("[shellproc %s] pty-read loop done\n", shellProc.SessionID())
 			shellProc.Close()
 			bc.WithLock(func() {
 				// so no other events are sent
 				bc.ShellInputCh = nil
 			})
-			shellProc.Cmd.Wait()
-			exitCode := shellProc.Cmd.ExitCode()
+			shellProc.Wait() // the exit status is set by the wait loop
+			exitStatus, _ := shellProc.ExitStatus()
+			exitCode := shellProc.ExitCode()
 			termMsg := fmt.Sprintf("\r\nprocess finished with exit code = %d\r\n\r\n", exitCode)
+			if exitStatus.Signal != "" || exitStatus.OOMKilled {
+				termMsg = fmt.Sprintf("\r\nprocess finished with exit code = %d (%s)\r\n\r\n", exitStatus.ExitCode, shellexec.ExplainExit(exitStatus))
+			}
 			HandleAppendBlockFile(bc.BlockId, BlockFile_Term, []byte(termMsg))
 			// to stop the inputCh loop
 			time.Sleep(100 * time.Millisecond)
 			close(shellInputCh) // don't use bc.ShellInputCh (it's nil)
 		}()
 		buf := make([]byte, 4096)
+		sentStartup := false
 		for {
 			nr, err := ptyBuffer.Read(buf)
+			if nr > 0 && !sentStartup {
+				// the first output has been read by now, so the startup timings are complete (except PromptReady)
+				sentStartup = true
+				telemetry.GoUpdateActivityWrap(wshrpc.ActivityUpdate{ShellStartup: shellProc.StartupTimings().TelemetryBuckets()}, "shellstartup")
+			}
 			if nr > 0 {
 				err := HandleAppendBlockFile(bc.BlockId, BlockFile_Term, buf[:nr])
 				if err != nil {
@@ -466,14 +433,27 @@ func (bc *BlockController) DoRunShellCommand(rc *RunShellOpts, blockMeta waveobj
 		defer panichandler.PanicHandler("blockcontroller:shellproc-input-loop")
 		for ic := range shellInputCh {
 			if len(ic.InputData) > 0 {
-				shellProc.Cmd.Write(ic.InputData)
+				shellProc.Write(ic.InputData)
+			}
+			if ic.SigName == "SIGINT" {
+				if _, err := shellProc.Interrupt(); err != nil {
+					log.Printf("error interrupting shell: %v\n", err)
+				}
+			} else if ic.SigName != "" {
+				sig, err := shellexec.ParseSignal(ic.SigName)
+				if err == nil {
+					err = shellProc.SendSignal(sig)
+				}
+				if err != nil {
+					log.Printf("error sending %s to shell: %v\n", ic.SigName, err)
+				}
 			}
 			if ic.TermSize != nil {
 				err = setTermSize(ctx, bc.BlockId, *ic.TermSize)
 				if err != nil {
 					log.Printf("error setting pty size: %v\n", err)
 				}
-				err = shellProc.Cmd.SetSize(ic.TermSize.Rows, ic.TermSize.Cols)
+				err = shellProc.SetTermSize(*ic.TermSize)
 				if err != nil {
 					log.Printf("error setting pty size: %v\n", err)
 				}
@@ -493,23 +473,37 @@ func (bc *BlockController) DoRunShellCommand(rc *RunShellOpts, blockMeta waveobj
 	}()
 	go func() {
 		defer panichandler.PanicHandler("blockcontroller:shellproc-wait-loop")
-		// wait for the shell to finish
-		var exitCode int
-		defer func() {
-			wshutil.DefaultRouter.UnregisterRoute(wshutil.MakeControllerRouteId(bc.BlockId))
-			bc.UpdateControllerAndSendUpdate(func() bool {
-				if bc.ShellProcStatus == Status_Running {
-					bc.ShellProcStatus = Status_Done
-				}
-				bc.ShellProcExitCode = exitCode
-				return true
-			})
-			log.Printf("[shellproc] shell process wait loop done\n")
-		}()
-		waitErr := shellProc.Cmd.Wait()
-		exitCode = shellProc.Cmd.ExitCode()
-		shellProc.SetWaitErrorAndSignalDone(waitErr)
+		// wait for the shell to finish (the exit is handled by the OnExit hook)
+		shellProc.WaitProcess()
+		log.Printf("[shellproc %s] shell process wait loop done\n", shellProc.SessionID())
+	}()
+	// called after the shell's final output
+	shellProc.OnExit(func(shellexec.WaitResult) {
+		exitCode := shellProc.ExitCode()
+		wshutil.DefaultRouter.UnregisterRoute(wshutil.MakeControllerRouteId(bc.BlockId))
+		bc.UpdateControllerAndSendUpdate(func() bool {
+			if bc.ShellProcStatus == Status_Running {
+				bc.ShellProcStatus = Status_Done
+			}
+			bc.ShellProcExitCode = exitCode
+			return true
+		})
 		go checkCloseOnExit(bc.BlockId, exitCode)
+	})
+	go func() {
+		defer panichandler.PanicHandler("blockcontroller:shellproc-event-loop")
+		// the channel is closed after the exit event
+		for event := range shellProc.Events() {
+			switch event.Kind {
+			case shellexec.EventKind_OutputStalled:
+				if event.OutputStall.Stalled {
+					log.Printf("[shellproc %s] output stalled at offset %d\n", shellProc.SessionID(), event.OutputStall.Offset)
+				} else {
+					log.Printf("[shellproc %s] output resumed after %dms\n", shellProc.SessionID(), event.OutputStall.DurationMs)
+				}
+			}
+		}
+		log.Printf("[shellproc %s] shell process event loop done\n", shellProc.SessionID())
 	}()
 	return nil
 }
@@ -697,7 +691,7 @@ func (bc *BlockController) StopShellProc(shouldWait bool) {
 	}
 	bc.ShellProc.Close()
 	if shouldWait {
-		doneCh := bc.ShellProc.DoneCh
+		doneCh := bc.ShellProc.Done()
 		<-doneCh
 	}
 }
@@ -811,7 +805,7 @@ func StopBlockControllerAndSetStatus(blockId string, newStatus string) {
 	}
 	if bc.getShellProc() != nil {
 		bc.ShellProc.Close()
-		<-bc.ShellProc.DoneCh
+		<-bc.ShellProc.Done()
 		bc.UpdateControllerAndSendUpdate(func() bool {
 			bc.ShellProcStatus = newStatus
 			return true
@@ -17,6 +17,7 @@ import (
 	"strings"
 
 	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -334,9 +335,7 @@ func GetHomeDir(client *ssh.Client) string {
 }
 
 func IsPowershell(shellPath string) bool {
-	// get the base path, and then check contains
-	shellBase := filepath.Base(shellPath)
-	return strings.Contains(shellBase, "powershell") || strings.Contains(shellBase, "pwsh")
+	return shellutil.DetectFamily(shellPath) == shellutil.ShellFamily_Pwsh
 }
 
 func NormalizeConfigPattern(pattern string) string {
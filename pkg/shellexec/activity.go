@@ -0,0 +1,60 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+const (
+	Activity_Idle    = "idle"    // the shell is at its prompt
+	Activity_Busy    = "busy"    // a command is running
+	Activity_Unknown = "unknown" // can't tell (no foreground process group to check and no OSC 133 marks)
+	Activity_Exited  = "exited"
+)
+
+// ShellActivity is returned by ShellProc.Activity
+type ShellActivity struct {
+	State          string `json:"state"`                    // Activity_*
+	ForegroundPgid int    `json:"foregroundpgid,omitempty"` // local shells, the pty's foreground process group
+	FromMarks      bool   `json:"frommarks,omitempty"`      // decided by the shell's OSC 133 marks
+}
+
+// Activity reports whether the shell is idle at its prompt or running a command.  Local shells
+// compare the pty's foreground process group with the shell's pid (the shell is the session
+// leader, so its pgid is its pid): a job control shell puts each command in its own process group
+// and makes it the foreground, and takes the foreground back when the command is done.  So a
+// command the shell runs in-process (a builtin like `read`) counts as idle, and a shell without
+// job control (not interactive) always looks idle.  Remote shells (and windows, where there are no
+// process groups) go by the OSC 133 marks (see EventKind_CommandStart) if the shell sends them.
+// Unlike LikelyAtPrompt there is no idle window, a shell that is still drawing its prompt is
+// already idle
+func (sp *ShellProc) Activity() ShellActivity {
+	if sp.shellGone() {
+		return ShellActivity{State: Activity_Exited}
+	}
+	if pid := sp.localPid(); pid > 0 {
+		var pgid int
+		err := sp.withPtyFd(func(fd uintptr) error {
+			var err error
+			pgid, err = getForegroundPgid(fd)
+			return err
+		})
+		if err == nil && pgid == pid {
+			return ShellActivity{State: Activity_Idle, ForegroundPgid: pgid}
+		}
+		if err == nil {
+			return ShellActivity{State: Activity_Busy, ForegroundPgid: pgid}
+		}
+	}
+	sawMarks, inCommand := sp.output.commandState()
+	if !sawMarks {
+		return ShellActivity{State: Activity_Unknown}
+	}
+	if inCommand {
+		return ShellActivity{State: Activity_Busy, FromMarks: true}
+	}
+	return ShellActivity{State: Activity_Idle, FromMarks: true}
+}
+
+// IsBusy is true if Activity reports Activity_Busy
+func (sp *ShellProc) IsBusy() bool {
+	return sp.Activity().State == Activity_Busy
+}
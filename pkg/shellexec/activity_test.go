@@ -0,0 +1,60 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+func waitActivity(t *testing.T, sp *ShellProc, state string) ShellActivity {
+	t.Helper()
+	var activity ShellActivity
+	waitUntil(t, fmt.Sprintf("activity %q", state), func() bool {
+		activity = sp.Activity()
+		return activity.State == state
+	})
+	return activity
+}
+
+func TestActivity(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no process groups on windows")
+	}
+	sp := startTestShellProc(t, "", CommandOptsType{})
+	oc := collectOutput(sp)
+	sp.Write([]byte("echo sta''rted\r"))
+	oc.waitFor(t, "started")
+	waitActivity(t, sp, Activity_Idle)
+	sp.Write([]byte("sleep 30\r"))
+	if activity := waitActivity(t, sp, Activity_Busy); activity.ForegroundPgid == sp.localPid() || activity.FromMarks {
+		t.Errorf("unexpected activity %+v", activity)
+	}
+	if !sp.IsBusy() {
+		t.Errorf("expected IsBusy while sleep is running")
+	}
+	if _, err := sp.Interrupt(); err != nil {
+		t.Fatalf("error interrupting: %v", err)
+	}
+	waitActivity(t, sp, Activity_Idle)
+	sp.Write([]byte("exit\r"))
+	<-oc.Done
+	waitActivity(t, sp, Activity_Exited)
+}
+
+func TestActivityFromMarks(t *testing.T) {
+	sp := startTestShellProc(t, `printf '\033]133;C\007marked\n'; read x; printf '\033]133;D;0\007done\n'; read y`, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "marked")
+	sawMarks, inCommand := sp.output.commandState()
+	if !sawMarks || !inCommand {
+		t.Errorf("expected the marks to say a command is running")
+	}
+	sp.Write([]byte("x\n"))
+	oc.waitFor(t, "done")
+	if sawMarks, inCommand = sp.output.commandState(); !sawMarks || inCommand {
+		t.Errorf("expected the marks to say the command ended")
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package shellexec
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func makeFifo(path string) error {
+	return fmt.Errorf("fifos are not supported on %s", runtime.GOOS)
+}
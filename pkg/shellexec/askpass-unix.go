@@ -0,0 +1,14 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func makeFifo(path string) error {
+	return unix.Mkfifo(path, 0600)
+}
@@ -0,0 +1,208 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+	"github.com/wavetermdev/waveterm/pkg/wavebase"
+)
+
+const (
+	askpassScriptName = "askpass.sh"
+	askpassReqName    = "req"
+	askpassRespName   = "resp"
+)
+
+// how long a response waits for the helper to open its end of the resp fifo
+const askpassRespondTimeout = 2 * time.Second
+
+// sudo -A runs this (as us) with its prompt as $1 every time it needs the password.  the prompt goes
+// to the bridge, the answer comes back on stdout (an empty read is a cancel, sudo then fails)
+const askpassScriptTemplate = `#!/bin/sh
+{ printf '%%s' "$1" | tr '\n' ' '; echo; } > %[1]s || exit 1
+IFS= read -r secret < %[2]s || exit 1
+printf '%%s\n' "$secret"
+`
+
+// AuthRequest is a password prompt from an ElevateAskpass shell (ShellEvent.AuthRequest), answer it
+// with ShellProc.RespondAuth or CancelAuth
+type AuthRequest struct {
+	Id      string `json:"id"`
+	Tool    string `json:"tool"`    // ElevateTool_Sudo
+	Prompt  string `json:"prompt"`  // the tool's prompt (e.g. "[sudo] password for mike: ")
+	Attempt int    `json:"attempt"` // 1 for the first prompt, the tool asks again after a wrong password
+}
+
+// askpassBridge connects sudo's askpass helper (a script in a private dir) to AuthRequired events
+// through two fifos: the helper writes its prompt to req and reads the answer from resp
+type askpassBridge struct {
+	Tool       string
+	Dir        string
+	ScriptPath string
+	ReqFile    *os.File // opened read/write so it never sees eof between helper runs
+	Lock       *sync.Mutex
+	Pending    *AuthRequest
+	RespCh     chan *string // nil is a cancel
+	CloseOnce  *sync.Once
+}
+
+func makeAskpassBridge(tool string) (*askpassBridge, error) {
+	baseDir := filepath.Join(wavebase.GetWaveDataDir(), elevateEnvDir)
+	if err := os.MkdirAll(baseDir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating elevate dir: %w", err)
+	}
+	dir, err := os.MkdirTemp(baseDir, "askpass-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating askpass dir: %w", err)
+	}
+	bridge := &askpassBridge{
+		Tool:       tool,
+		Dir:        dir,
+		ScriptPath: filepath.Join(dir, askpassScriptName),
+		Lock:       &sync.Mutex{},
+		CloseOnce:  &sync.Once{},
+	}
+	if err := bridge.setup(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return bridge, nil
+}
+
+func (b *askpassBridge) setup() error {
+	reqPath, respPath := filepath.Join(b.Dir, askpassReqName), filepath.Join(b.Dir, askpassRespName)
+	for _, fifoPath := range []string{reqPath, respPath} {
+		if err := makeFifo(fifoPath); err != nil {
+			return fmt.Errorf("error creating askpass fifo: %w", err)
+		}
+	}
+	quote := shellutil.ShellFamily_PosixSh.Quote
+	script := fmt.Sprintf(askpassScriptTemplate, quote(reqPath), quote(respPath))
+	if err := os.WriteFile(b.ScriptPath, []byte(script), 0700); err != nil {
+		return fmt.Errorf("error writing askpass helper: %w", err)
+	}
+	reqFile, err := os.OpenFile(reqPath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("error opening askpass fifo: %w", err)
+	}
+	b.ReqFile = reqFile
+	return nil
+}
+
+func (b *askpassBridge) close() {
+	b.CloseOnce.Do(func() {
+		if b.ReqFile != nil {
+			b.ReqFile.Close()
+		}
+		os.RemoveAll(b.Dir)
+	})
+}
+
+// publishes an AuthRequired event for each prompt and passes the answer back, until the shell exits
+func (b *askpassBridge) run(sp *ShellProc) {
+	defer panichandler.PanicHandler("ShellProc:askpass")
+	reader := bufio.NewReader(b.ReqFile)
+	for attempt := 1; ; attempt++ {
+		prompt, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		req := &AuthRequest{Id: strconv.Itoa(attempt), Tool: b.Tool, Prompt: strings.TrimSuffix(prompt, "\n"), Attempt: attempt}
+		respCh := make(chan *string, 1)
+		b.Lock.Lock()
+		b.Pending, b.RespCh = req, respCh
+		b.Lock.Unlock()
+		sp.events.publish(ShellEvent{Kind: EventKind_AuthRequired, AuthRequest: req})
+		var secret *string
+		select {
+		case secret = <-respCh:
+		case <-sp.DoneCh:
+			return
+		}
+		if err := b.respond(secret); err != nil {
+			sp.logf("warning: cannot answer %s's password prompt: %v\n", b.Tool, err)
+		}
+	}
+}
+
+// writes secret to the helper (it is blocked opening resp), closing without it is the cancel
+func (b *askpassBridge) respond(secret *string) error {
+	respPath := filepath.Join(b.Dir, askpassRespName)
+	deadline := shellClock.Now().Add(askpassRespondTimeout)
+	for {
+		respFile, err := os.OpenFile(respPath, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err == nil {
+			defer respFile.Close()
+			if secret == nil {
+				return nil
+			}
+			_, err = respFile.WriteString(*secret + "\n")
+			return err
+		}
+		if !errors.Is(err, syscall.ENXIO) || shellClock.Now().After(deadline) {
+			return err
+		}
+		// the helper hasn't opened its end yet
+		<-shellClock.After(10 * time.Millisecond)
+	}
+}
+
+func (b *askpassBridge) answer(id string, secret *string) error {
+	b.Lock.Lock()
+	defer b.Lock.Unlock()
+	if b.Pending == nil || b.Pending.Id != id {
+		return fmt.Errorf("no pending auth request %q", id)
+	}
+	b.RespCh <- secret
+	b.Pending, b.RespCh = nil, nil
+	return nil
+}
+
+func (sp *ShellProc) askpass() (*askpassBridge, error) {
+	if sp.elevate == nil || sp.elevate.Askpass == nil {
+		return nil, fmt.Errorf("shell was not started with ElevateAskpass")
+	}
+	return sp.elevate.Askpass, nil
+}
+
+// PendingAuth returns the password prompt waiting for an answer, nil if there is none
+func (sp *ShellProc) PendingAuth() *AuthRequest {
+	bridge, err := sp.askpass()
+	if err != nil {
+		return nil
+	}
+	bridge.Lock.Lock()
+	defer bridge.Lock.Unlock()
+	return bridge.Pending
+}
+
+// RespondAuth answers the AuthRequest with this id, the secret is handed to the tool and not kept
+func (sp *ShellProc) RespondAuth(id string, secret string) error {
+	bridge, err := sp.askpass()
+	if err != nil {
+		return err
+	}
+	return bridge.answer(id, &secret)
+}
+
+// CancelAuth refuses the AuthRequest with this id (the tool then exits, see ExitStatus.ElevateFailed)
+func (sp *ShellProc) CancelAuth(id string) error {
+	bridge, err := sp.askpass()
+	if err != nil {
+		return err
+	}
+	return bridge.answer(id, nil)
+}
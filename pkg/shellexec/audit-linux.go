@@ -0,0 +1,79 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package shellexec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var bootTimeOnce = &sync.Once{}
+var bootTime time.Time
+
+// btime from /proc/stat (zero if it can't be read, ages are then left out)
+func getBootTime() time.Time {
+	bootTimeOnce.Do(func() {
+		data, err := os.ReadFile("/proc/stat")
+		if err != nil {
+			return
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			valStr, found := strings.CutPrefix(scanner.Text(), "btime ")
+			if !found {
+				continue
+			}
+			if secs, err := strconv.ParseInt(strings.TrimSpace(valStr), 10, 64); err == nil {
+				bootTime = time.Unix(secs, 0)
+			}
+			return
+		}
+	})
+	return bootTime
+}
+
+func makeAuditProc(pid int, stat procStat) auditProc {
+	rtn := auditProc{Pid: pid, Ppid: stat.Ppid, Sid: stat.Sid, Comm: stat.Comm, Zombie: stat.State == 'Z'}
+	if boot := getBootTime(); !boot.IsZero() {
+		rtn.StartTime = boot.Add(time.Duration(stat.StartTicks) * time.Second / procClockTicks)
+	}
+	return rtn
+}
+
+func listAuditProcs() ([]auditProc, error) {
+	stats, err := readAllProcStats()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the process table: %w", err)
+	}
+	rtn := make([]auditProc, 0, len(stats))
+	for pid, stat := range stats {
+		rtn = append(rtn, makeAuditProc(pid, stat))
+	}
+	return rtn, nil
+}
+
+func lookupAuditProc(pid int) (auditProc, bool) {
+	stat, err := readProcStat(pid)
+	if err != nil {
+		return auditProc{}, false
+	}
+	return makeAuditProc(pid, stat), true
+}
+
+// the tty on the process's stdin ("" if it's not a tty), for a shell we started that's its pty
+func lookupTtyPath(pid int) string {
+	path, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/0", pid))
+	if err != nil || !strings.HasPrefix(path, "/dev/") {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,80 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package shellexec
+
+import (
+	"os/exec"
+	"regexp"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var bgPidRe = regexp.MustCompile(`bg=\[(\d+)\]`)
+
+func TestAuditOrphaned(t *testing.T) {
+	// the background sleep ignores the SIGHUP it gets when the session leader exits, and doesn't hold the pty open.
+	// the shell only exits once the trap is set (it reports its pid after that)
+	sp := startTestShellProc(t, `(trap '' HUP; echo "bg=[$BASHPID]"; exec sleep 30 </dev/null >/dev/null 2>&1) & read y`, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "]")
+	sp.Write([]byte("\n"))
+	waitExitStatus(t, sp)
+	m := bgPidRe.FindStringSubmatch(oc.String())
+	if m == nil {
+		t.Fatalf("no background pid in output %q", oc.String())
+	}
+	bgPid, _ := strconv.Atoi(m[1])
+	t.Cleanup(func() { syscall.Kill(bgPid, syscall.SIGKILL) })
+
+	finding := waitAuditClass(t, bgPid, AuditClass_Orphaned)
+	if finding.Sid != sp.localPid() || finding.Action != AuditAction_Signal {
+		t.Errorf("unexpected orphan finding %+v (shell pid %d)", finding, sp.localPid())
+	}
+	result := AuditFix(AuditReport{Findings: []AuditFinding{finding}}, AuditFixOpts{SignalOrphans: true})
+	if len(result.Signaled) != 1 || result.Signaled[0] != bgPid {
+		t.Fatalf("expected pid %d to be signaled, got %+v", bgPid, result)
+	}
+	deadline := time.Now().Add(testWaitTimeout)
+	for time.Now().Before(deadline) {
+		// it is someone else's child now, it is gone (or a zombie) once it got the signal
+		if proc, ok := lookupAuditProc(bgPid); !ok || proc.Zombie {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("orphan %d is still running after being signaled", bgPid)
+}
+
+func TestAuditUnknownSession(t *testing.T) {
+	// a session leader we started without a ShellProc
+	cmd := exec.Command(requireBinary(t, "sleep"), "30")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("error starting sleep: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	finding := waitAuditClass(t, cmd.Process.Pid, AuditClass_UnknownSession)
+	if finding.Action != AuditAction_Investigate {
+		t.Errorf("unknown sessions should only be investigated, got %+v", finding)
+	}
+	if result := AuditFix(AuditReport{Findings: []AuditFinding{finding}}, AuditFixOpts{Reap: true, SignalOrphans: true}); len(result.Signaled)+len(result.Reaped) != 0 {
+		t.Errorf("AuditFix should not touch an unknown session, got %+v", result)
+	}
+}
+
+func TestAuditLostPty(t *testing.T) {
+	// the shell closes its side of the pty, the read loop ends (EIO) while the shell keeps running
+	sp := startTestShellProc(t, "exec </dev/null >/dev/null 2>&1; sleep 30", CommandOptsType{})
+	finding := waitAuditClass(t, sp.localPid(), AuditClass_UnknownSession)
+	if finding.Action != AuditAction_Investigate {
+		t.Errorf("unexpected finding for a shell whose pty was lost %+v", finding)
+	}
+}
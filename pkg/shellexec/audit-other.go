@@ -0,0 +1,74 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package shellexec
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// session ids aren't available here (Sid is 0), only our children and the shells themselves are audited
+func makeAuditProc(proc *process.Process) (auditProc, error) {
+	ppid, err := proc.Ppid()
+	if err != nil {
+		return auditProc{}, err
+	}
+	rtn := auditProc{Pid: int(proc.Pid), Ppid: int(ppid)}
+	rtn.Comm, _ = proc.Name()
+	if statuses, err := proc.Status(); err == nil {
+		rtn.Zombie = slices.Contains(statuses, process.Zombie)
+	}
+	if createTime, err := proc.CreateTime(); err == nil {
+		rtn.StartTime = time.UnixMilli(createTime)
+	}
+	return rtn, nil
+}
+
+func listAuditProcs() ([]auditProc, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the process table: %w", err)
+	}
+	rtn := make([]auditProc, 0, len(procs))
+	for _, proc := range procs {
+		auditProc, err := makeAuditProc(proc)
+		if err != nil {
+			// exited mid-scan
+			continue
+		}
+		rtn = append(rtn, auditProc)
+	}
+	return rtn, nil
+}
+
+func lookupAuditProc(pid int) (auditProc, bool) {
+	proc, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return auditProc{}, false
+	}
+	rtn, err := makeAuditProc(proc)
+	return rtn, err == nil
+}
+
+// the process's controlling tty, gopsutil can't find it on every platform ("" then)
+func lookupTtyPath(pid int) string {
+	proc, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return ""
+	}
+	tty, err := proc.Terminal()
+	if err != nil || tty == "" {
+		return ""
+	}
+	if !strings.HasPrefix(tty, "/dev/") {
+		tty = "/dev/" + tty
+	}
+	return tty
+}
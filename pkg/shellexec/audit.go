@@ -0,0 +1,205 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const (
+	AuditClass_Healthy        = "healthy"
+	AuditClass_Zombie         = "zombie"         // a child of ours that exited and was never reaped
+	AuditClass_Orphaned       = "orphaned"       // left behind in the session of a shell that has exited
+	AuditClass_UnknownSession = "unknownsession" // a session we don't track, or whose pty we lost
+)
+
+const (
+	AuditAction_None        = "none"
+	AuditAction_Wait        = "wait"        // our wait loop should reap it shortly
+	AuditAction_Reap        = "reap"        // AuditFix with Reap
+	AuditAction_Signal      = "signal"      // AuditFix with SignalOrphans
+	AuditAction_Investigate = "investigate" // not ours to fix automatically
+)
+
+// AuditFinding is one process found by Audit
+type AuditFinding struct {
+	Class     string        `json:"class"` // AuditClass_*
+	Pid       int           `json:"pid"`
+	Ppid      int           `json:"ppid"`
+	Sid       int           `json:"sid,omitempty"`       // 0 if not known on this platform
+	SessionId string        `json:"sessionid,omitempty"` // the shellproc's SessionId, for processes in (or the shell of) a session we created
+	Comm      string        `json:"comm,omitempty"`
+	StartTime time.Time     `json:"starttime"` // also used by AuditFix to detect pid reuse
+	Age       time.Duration `json:"age"`
+	Reason    string        `json:"reason"`
+	Action    string        `json:"action"` // AuditAction_*
+}
+
+// AuditReport is a snapshot of the shell related processes of this process
+type AuditReport struct {
+	Ts       int64          `json:"ts"`
+	OurPid   int            `json:"ourpid"`
+	Findings []AuditFinding `json:"findings"` // sorted by pid
+}
+
+// Count returns the number of findings of class
+func (r AuditReport) Count(class string) int {
+	var rtn int
+	for _, finding := range r.Findings {
+		if finding.Class == class {
+			rtn++
+		}
+	}
+	return rtn
+}
+
+// a row of the os process table
+type auditProc struct {
+	Pid       int
+	Ppid      int
+	Sid       int // 0 if unknown
+	Comm      string
+	Zombie    bool
+	StartTime time.Time
+}
+
+// Audit cross-references the shells we started (and the sessions they created)
+// against the os process table: our direct children, and every process in a
+// session one of our local shells created.  Session ids are only available on
+// linux, elsewhere only our children and the shells themselves are found.
+// Findings are only a snapshot, processes can come and go while the table is read.
+func Audit() (AuditReport, error) {
+	procs, err := listAuditProcs()
+	if err != nil {
+		return AuditReport{}, err
+	}
+	now := shellClock.Now()
+	report := auditProcs(procs, shellRegistry.snapshot(), os.Getpid(), now)
+	inUse := make(map[int]bool)
+	for _, finding := range report.Findings {
+		inUse[finding.Sid] = true
+		inUse[finding.Pid] = true
+	}
+	shellRegistry.pruneEnded(inUse)
+	return report, nil
+}
+
+func auditProcs(procs []auditProc, sessions map[int]shellSession, ourPid int, now time.Time) AuditReport {
+	report := AuditReport{Ts: now.UnixMilli(), OurPid: ourPid}
+	for _, proc := range procs {
+		if proc.Pid == ourPid {
+			continue
+		}
+		sess, inSession := sessions[proc.Sid]
+		if !inSession || proc.Sid == 0 {
+			// without session ids we can still match the shells themselves
+			sess, inSession = sessions[proc.Pid]
+		}
+		isChild := proc.Ppid == ourPid
+		if !inSession && !isChild {
+			continue
+		}
+		finding := AuditFinding{Pid: proc.Pid, Ppid: proc.Ppid, Sid: proc.Sid, SessionId: sess.SessionId, Comm: proc.Comm, StartTime: proc.StartTime}
+		if !proc.StartTime.IsZero() && now.After(proc.StartTime) {
+			finding.Age = now.Sub(proc.StartTime)
+		}
+		classifyAuditProc(&finding, proc, sess, inSession, isChild, now)
+		report.Findings = append(report.Findings, finding)
+	}
+	sort.Slice(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Pid < report.Findings[j].Pid
+	})
+	return report
+}
+
+func classifyAuditProc(finding *AuditFinding, proc auditProc, sess shellSession, inSession bool, isChild bool, now time.Time) {
+	isShell := inSession && sess.Sid == proc.Pid
+	switch {
+	case proc.Zombie && isChild && isShell && sess.Proc != nil:
+		finding.Class, finding.Action = AuditClass_Zombie, AuditAction_Wait
+		finding.Reason = "the shell has exited, its ShellProc has not reaped it yet"
+	case proc.Zombie && isChild:
+		finding.Class, finding.Action = AuditClass_Zombie, AuditAction_Reap
+		finding.Reason = "exited but was never reaped"
+	case !inSession:
+		finding.Class, finding.Action = AuditClass_UnknownSession, AuditAction_Investigate
+		finding.Reason = "a child of ours that is not in a session we created"
+	case sess.Proc == nil:
+		finding.Class, finding.Action = AuditClass_Orphaned, AuditAction_Signal
+		finding.Reason = fmt.Sprintf("its shell (pid %d) exited %v ago", sess.Sid, now.Sub(sess.EndedAt).Round(time.Second))
+	case sess.Proc.outputEnded():
+		finding.Class, finding.Action = AuditClass_UnknownSession, AuditAction_Investigate
+		finding.Reason = fmt.Sprintf("the pty of its shell (pid %d) is no longer being read", sess.Sid)
+	default:
+		finding.Class, finding.Action = AuditClass_Healthy, AuditAction_None
+		if isShell {
+			finding.Reason = "a running shell"
+		} else {
+			finding.Reason = fmt.Sprintf("in the session of a running shell (pid %d)", sess.Sid)
+		}
+	}
+}
+
+// the output loop has stopped reading the pty (the process may still be running)
+func (sp *ShellProc) outputEnded() bool {
+	sp.outputBuf.CVar.L.Lock()
+	defer sp.outputBuf.CVar.L.Unlock()
+	return sp.outputBuf.Err != nil
+}
+
+// AuditFixOpts says which findings AuditFix may act on, nothing is done by default
+type AuditFixOpts struct {
+	Reap          bool           // reap AuditAction_Reap zombies
+	SignalOrphans bool           // signal AuditAction_Signal processes
+	Signal        syscall.Signal // defaults to SIGTERM
+}
+
+// AuditFixResult lists the pids AuditFix acted on
+type AuditFixResult struct {
+	Reaped   []int    `json:"reaped,omitempty"`
+	Signaled []int    `json:"signaled,omitempty"`
+	Skipped  []int    `json:"skipped,omitempty"` // gone, or the pid was reused since the audit
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// AuditFix reaps the zombies and signals the orphans in report, as allowed by opts.
+// Each process is checked again first (it must still be there, with the same start
+// time), so a stale report can't hit a pid that has been reused.
+func AuditFix(report AuditReport, opts AuditFixOpts) AuditFixResult {
+	var result AuditFixResult
+	sig := opts.Signal
+	if sig == 0 {
+		sig = syscall.SIGTERM
+	}
+	for _, finding := range report.Findings {
+		reap := opts.Reap && finding.Action == AuditAction_Reap
+		signal := opts.SignalOrphans && finding.Action == AuditAction_Signal
+		if !reap && !signal {
+			continue
+		}
+		current, ok := lookupAuditProc(finding.Pid)
+		if !ok || !current.StartTime.Equal(finding.StartTime) || (reap && !current.Zombie) {
+			result.Skipped = append(result.Skipped, finding.Pid)
+			continue
+		}
+		var err error
+		if reap {
+			err = reapZombie(finding.Pid)
+		} else {
+			err = signalPid(finding.Pid, sig)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("pid %d: %v", finding.Pid, err))
+		} else if reap {
+			result.Reaped = append(result.Reaped, finding.Pid)
+		} else {
+			result.Signaled = append(result.Signaled, finding.Pid)
+		}
+	}
+	return result
+}
@@ -0,0 +1,73 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"testing"
+)
+
+// polls Audit until pid is found with class, returns the finding
+func waitAuditClass(t *testing.T, pid int, class string) AuditFinding {
+	t.Helper()
+	var rtn AuditFinding
+	waitUntil(t, fmt.Sprintf("pid %d to be %s", pid, class), func() bool {
+		report, err := Audit()
+		if err != nil {
+			t.Fatalf("audit error: %v", err)
+		}
+		for _, finding := range report.Findings {
+			if finding.Pid == pid && finding.Class == class {
+				rtn = finding
+				return true
+			}
+		}
+		return false
+	})
+	return rtn
+}
+
+func TestAuditHealthyShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no audit on windows")
+	}
+	sp := startTestShellProc(t, "sleep 30", CommandOptsType{})
+	finding := waitAuditClass(t, sp.localPid(), AuditClass_Healthy)
+	if finding.Action != AuditAction_None || finding.Age < 0 || finding.StartTime.IsZero() {
+		t.Errorf("unexpected finding for a running shell %+v", finding)
+	}
+}
+
+func TestAuditZombie(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no audit on windows")
+	}
+	// started and never waited for
+	cmd := exec.Command(requireBinary(t, "true"))
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("error starting true: %v", err)
+	}
+	pid := cmd.Process.Pid
+	finding := waitAuditClass(t, pid, AuditClass_Zombie)
+	if finding.Action != AuditAction_Reap {
+		t.Errorf("expected the zombie to be reapable, got %+v", finding)
+	}
+	report := AuditReport{Findings: []AuditFinding{finding}}
+	if result := AuditFix(report, AuditFixOpts{}); len(result.Reaped) != 0 {
+		t.Errorf("AuditFix should do nothing unless asked, got %+v", result)
+	}
+	result := AuditFix(report, AuditFixOpts{Reap: true})
+	if len(result.Reaped) != 1 || result.Reaped[0] != pid {
+		t.Fatalf("expected pid %d to be reaped, got %+v", pid, result)
+	}
+	if _, ok := lookupAuditProc(pid); ok {
+		t.Errorf("the zombie is still in the process table")
+	}
+	// a second fix with the stale report must not touch anything
+	if result := AuditFix(report, AuditFixOpts{Reap: true}); len(result.Skipped) != 1 {
+		t.Errorf("expected the stale finding to be skipped, got %+v", result)
+	}
+}
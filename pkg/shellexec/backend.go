@@ -0,0 +1,238 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/remote"
+	"github.com/wavetermdev/waveterm/pkg/remote/conncontroller"
+	"github.com/wavetermdev/waveterm/pkg/wavebase"
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+	"github.com/wavetermdev/waveterm/pkg/wshrpc"
+	"github.com/wavetermdev/waveterm/pkg/wshutil"
+	"github.com/wavetermdev/waveterm/pkg/wsl"
+)
+
+const (
+	Backend_Local  = "local"
+	Backend_Ssh    = "ssh"
+	Backend_Wsl    = "wsl"
+	Backend_Docker = "docker"
+	Backend_Podman = "podman"
+	Backend_K8s    = "k8s"
+)
+
+// how long the ssh and wsl backends wait for their connection (it may be asking for credentials)
+const connCredentialTimeout = 60 * time.Second
+
+// BackendStartOpts is what a Backend gets from StartConnShellProc
+type BackendStartOpts struct {
+	Target   string // the connection string without its "scheme://" (e.g. "user@host" for ssh)
+	TermSize waveobj.TermSize
+	CmdStr   string
+	CmdOpts  CommandOptsType
+
+	// if set, the backend adds a jwt for it (with its connection's name and socket) to CmdOpts.Env so
+	// wsh works in the shell.  nil for no wsh (backends whose shells can't reach a socket ignore it)
+	RpcContext *wshrpc.RpcContext
+}
+
+// Backend starts shells on one kind of connection (registered with RegisterBackend for a scheme).
+// everything after the start is the ShellProc's, the same for every backend: SetTermSize resizes,
+// Signal, Wait and Close (or CloseGraceful) go through the ConnInterface the backend started
+type Backend interface {
+	Start(ctx context.Context, opts BackendStartOpts) (*ShellProc, error)
+}
+
+// BackendFunc lets a function be a Backend
+type BackendFunc func(ctx context.Context, opts BackendStartOpts) (*ShellProc, error)
+
+func (fn BackendFunc) Start(ctx context.Context, opts BackendStartOpts) (*ShellProc, error) {
+	return fn(ctx, opts)
+}
+
+var backendsLock = &sync.Mutex{}
+var backends = map[string]Backend{
+	Backend_Local:  BackendFunc(startLocalBackend),
+	Backend_Ssh:    BackendFunc(startSshBackend),
+	Backend_Wsl:    BackendFunc(startWslBackend),
+	Backend_Docker: containerBackend{Tool: ContainerTool_Docker},
+	Backend_Podman: containerBackend{Tool: ContainerTool_Podman},
+	Backend_K8s:    BackendFunc(startK8sBackend),
+}
+
+// RegisterBackend adds (or replaces) the backend for connection strings starting with "scheme://"
+func RegisterBackend(scheme string, backend Backend) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+	backends[scheme] = backend
+}
+
+// Backends returns the registered schemes, sorted
+func Backends() []string {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+	rtn := make([]string, 0, len(backends))
+	for scheme := range backends {
+		rtn = append(rtn, scheme)
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+// ParseConnString splits a connection string into its scheme and target.  empty (or "local") is a
+// local shell, "scheme://target" picks a registered backend and anything else is an ssh connection
+// (e.g. "user@host:2222")
+func ParseConnString(connStr string) (string, string) {
+	if connStr == "" || connStr == Backend_Local {
+		return Backend_Local, ""
+	}
+	if scheme, target, ok := strings.Cut(connStr, "://"); ok {
+		return scheme, target
+	}
+	return Backend_Ssh, connStr
+}
+
+// IsLocalConn is true for connection strings that run a local shell
+func IsLocalConn(connStr string) bool {
+	scheme, _ := ParseConnString(connStr)
+	return scheme == Backend_Local
+}
+
+// StartConnShellProc starts a shell with the backend for connStr (see ParseConnString)
+func StartConnShellProc(ctx context.Context, connStr string, opts BackendStartOpts) (*ShellProc, error) {
+	scheme, target := ParseConnString(connStr)
+	backendsLock.Lock()
+	backend := backends[scheme]
+	backendsLock.Unlock()
+	if backend == nil {
+		return nil, fmt.Errorf("no backend for connection %q", connStr)
+	}
+	opts.Target = target
+	return backend.Start(ctx, opts)
+}
+
+// Signal sends sig to the shell (local, supervised and ssh shells).  see SignalForeground for
+// the command the shell is running
+func (sp *ShellProc) Signal(sig syscall.Signal) error {
+	sender, ok := sp.Cmd.(signaler)
+	if !ok {
+		return fmt.Errorf("cannot send a signal to this shell")
+	}
+	if err := sender.sendSignal(sig); err != nil {
+		return err
+	}
+	sp.publishSignaled(sig, SignalTarget_Shell, sp.localPid())
+	return nil
+}
+
+func addJwtToken(cmdOpts *CommandOptsType, rpcCtx *wshrpc.RpcContext, connName string, sockName string) error {
+	if rpcCtx == nil {
+		return nil
+	}
+	tokenCtx := *rpcCtx
+	tokenCtx.Conn = connName
+	jwtStr, err := wshutil.MakeClientJWTToken(tokenCtx, sockName)
+	if err != nil {
+		return fmt.Errorf("error making jwt token: %w", err)
+	}
+	if cmdOpts.Env == nil {
+		cmdOpts.Env = make(map[string]string)
+	}
+	cmdOpts.Env[wshutil.WaveJwtTokenVarName] = jwtStr
+	return nil
+}
+
+func startLocalBackend(ctx context.Context, opts BackendStartOpts) (*ShellProc, error) {
+	if err := addJwtToken(&opts.CmdOpts, opts.RpcContext, "", wavebase.GetDomainSocketName()); err != nil {
+		return nil, err
+	}
+	return StartShellProc(opts.TermSize, opts.CmdStr, opts.CmdOpts)
+}
+
+// falls back to a shell without wsh if wsh can't be started on the connection
+func startSshBackend(ctx context.Context, opts BackendStartOpts) (*ShellProc, error) {
+	credentialCtx, cancelFn := context.WithTimeout(context.Background(), connCredentialTimeout)
+	defer cancelFn()
+	connOpts, err := remote.ParseOpts(opts.Target)
+	if err != nil {
+		return nil, err
+	}
+	conn := conncontroller.GetConn(credentialCtx, connOpts, false, &wshrpc.ConnKeywords{})
+	if conn.DeriveConnStatus().Status != conncontroller.Status_Connected {
+		return nil, fmt.Errorf("not connected, cannot start shellproc")
+	}
+	if err := addJwtToken(&opts.CmdOpts, opts.RpcContext, conn.Opts.String(), conn.GetDomainSocketName()); err != nil {
+		return nil, err
+	}
+	if !conn.WshEnabled.Load() {
+		return StartRemoteShellProcNoWsh(opts.TermSize, opts.CmdStr, opts.CmdOpts, conn)
+	}
+	sp, err := StartRemoteShellProc(opts.TermSize, opts.CmdStr, opts.CmdOpts, conn)
+	if err == nil {
+		return sp, nil
+	}
+	conn.WithLock(func() {
+		conn.WshError = err.Error()
+	})
+	conn.WshEnabled.Store(false)
+	log.Printf("error starting remote shell proc with wsh: %v", err)
+	log.Print("attempting install without wsh")
+	return StartRemoteShellProcNoWsh(opts.TermSize, opts.CmdStr, opts.CmdOpts, conn)
+}
+
+func startWslBackend(ctx context.Context, opts BackendStartOpts) (*ShellProc, error) {
+	credentialCtx, cancelFn := context.WithTimeout(context.Background(), connCredentialTimeout)
+	defer cancelFn()
+	wslConn := wsl.GetWslConn(credentialCtx, opts.Target, false)
+	if wslConn.DeriveConnStatus().Status != conncontroller.Status_Connected {
+		return nil, fmt.Errorf("not connected, cannot start shellproc")
+	}
+	if err := addJwtToken(&opts.CmdOpts, opts.RpcContext, wslConn.GetName(), wslConn.GetDomainSocketName()); err != nil {
+		return nil, err
+	}
+	return StartWslShellProc(ctx, opts.TermSize, opts.CmdStr, opts.CmdOpts, wslConn)
+}
+
+// "docker://container" and "podman://container", no wsh (the container can't reach our socket)
+type containerBackend struct {
+	Tool string
+}
+
+func (cb containerBackend) Start(ctx context.Context, opts BackendStartOpts) (*ShellProc, error) {
+	opts.CmdOpts.Container = opts.Target
+	opts.CmdOpts.ContainerTool = cb.Tool
+	return StartShellProc(opts.TermSize, opts.CmdStr, opts.CmdOpts)
+}
+
+// "k8s://pod", "k8s://namespace/pod" or "k8s://namespace/pod/container", no wsh
+func startK8sBackend(ctx context.Context, opts BackendStartOpts) (*ShellProc, error) {
+	pod, err := parsePodTarget(opts.Target)
+	if err != nil {
+		return nil, err
+	}
+	opts.CmdOpts.Pod = pod
+	return StartShellProc(opts.TermSize, opts.CmdStr, opts.CmdOpts)
+}
+
+func parsePodTarget(target string) (*PodTarget, error) {
+	parts := strings.Split(target, "/")
+	switch len(parts) {
+	case 1:
+		return &PodTarget{Name: parts[0]}, nil
+	case 2:
+		return &PodTarget{Namespace: parts[0], Name: parts[1]}, nil
+	case 3:
+		return &PodTarget{Namespace: parts[0], Name: parts[1], Container: parts[2]}, nil
+	}
+	return nil, fmt.Errorf("invalid pod %q (expected [namespace/]pod[/container])", target)
+}
@@ -0,0 +1,91 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+func TestParseConnString(t *testing.T) {
+	tests := []struct {
+		connStr string
+		scheme  string
+		target  string
+	}{
+		{"", Backend_Local, ""},
+		{"local", Backend_Local, ""},
+		{"user@host:2222", Backend_Ssh, "user@host:2222"},
+		{"wsl://Ubuntu", Backend_Wsl, "Ubuntu"},
+		{"docker://box", Backend_Docker, "box"},
+		{"k8s://prod/web-0/app", Backend_K8s, "prod/web-0/app"},
+	}
+	for _, test := range tests {
+		if scheme, target := ParseConnString(test.connStr); scheme != test.scheme || target != test.target {
+			t.Errorf("ParseConnString(%q) = %q, %q; want %q, %q", test.connStr, scheme, target, test.scheme, test.target)
+		}
+	}
+	if !IsLocalConn("") || IsLocalConn("docker://box") {
+		t.Errorf("unexpected IsLocalConn")
+	}
+}
+
+func TestParsePodTarget(t *testing.T) {
+	pod, err := parsePodTarget("prod/web-0/app")
+	if err != nil || *pod != (PodTarget{Namespace: "prod", Name: "web-0", Container: "app"}) {
+		t.Errorf("unexpected pod %+v %v", pod, err)
+	}
+	if pod, err := parsePodTarget("web-0"); err != nil || *pod != (PodTarget{Name: "web-0"}) {
+		t.Errorf("unexpected pod %+v %v", pod, err)
+	}
+	if _, err := parsePodTarget("a/b/c/d"); err == nil {
+		t.Errorf("expected an error for too many parts")
+	}
+}
+
+func TestRegisterBackend(t *testing.T) {
+	errFake := errors.New("fake backend")
+	var gotOpts BackendStartOpts
+	RegisterBackend("fake", BackendFunc(func(ctx context.Context, opts BackendStartOpts) (*ShellProc, error) {
+		gotOpts = opts
+		return nil, errFake
+	}))
+	t.Cleanup(func() {
+		backendsLock.Lock()
+		delete(backends, "fake")
+		backendsLock.Unlock()
+	})
+	if !slices.Contains(Backends(), "fake") || !slices.Contains(Backends(), Backend_Local) {
+		t.Errorf("unexpected backends %q", Backends())
+	}
+	_, err := StartConnShellProc(context.Background(), "fake://thing", BackendStartOpts{CmdStr: "true"})
+	if !errors.Is(err, errFake) || gotOpts.Target != "thing" || gotOpts.CmdStr != "true" {
+		t.Errorf("expected the fake backend to get the target, got %+v %v", gotOpts, err)
+	}
+	if _, err := StartConnShellProc(context.Background(), "nosuch://thing", BackendStartOpts{}); err == nil {
+		t.Errorf("expected an error for an unknown scheme")
+	}
+}
+
+func TestLocalBackend(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a posix shell command")
+	}
+	opts := BackendStartOpts{TermSize: waveobj.TermSize{Rows: 24, Cols: 80}, CmdStr: "echo from-backend; exit 4"}
+	sp, err := StartConnShellProc(context.Background(), "", opts)
+	if err != nil {
+		t.Fatalf("error starting local shell: %v", err)
+	}
+	t.Cleanup(sp.Close)
+	oc := collectOutput(sp)
+	oc.waitFor(t, "from-backend")
+	if status := waitExitStatus(t, sp); status.ExitCode != 4 {
+		t.Errorf("expected exit code 4, got %+v", status)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"sync"
+)
+
+// larger buffers are not put back (a huge burst shouldn't pin its memory in the pool)
+const maxPooledOutputBufSize = 2 * outputBulkReadBufSize
+
+// the output loop's read buffers (small and bulk, see readBufSizer) and output buffers, shared by all shellprocs so short-lived shells
+// (RunSimpleCmdInPty, Run) don't each allocate their own.  nothing downstream of the output loop
+// keeps a reference to them (io.Writers must not retain the data they are given)
+var readBufPool = sync.Pool{New: func() any {
+	buf := make([]byte, outputReadBufSize)
+	return &buf
+}}
+
+var outputBufPool = sync.Pool{New: func() any {
+	return &bytes.Buffer{}
+}}
+
+// see readBufSizer
+var bulkReadBufPool = sync.Pool{New: func() any {
+	buf := make([]byte, outputBulkReadBufSize)
+	return &buf
+}}
+
+func getReadBuf() *[]byte {
+	return readBufPool.Get().(*[]byte)
+}
+
+func putReadBuf(buf *[]byte) {
+	if len(*buf) == outputBulkReadBufSize {
+		bulkReadBufPool.Put(buf)
+		return
+	}
+	readBufPool.Put(buf)
+}
+
+// readBufSizer switches the output loop to a bulk sized read buffer while reads fill the buffer
+// (a command producing output as fast as it can), and back once the output is interactive again,
+// so an idle shell only holds a small one.  linux ptys return at most ~4k per read, ssh sessions and
+// other platforms' ptys can return more
+type readBufSizer struct {
+	Buf        *[]byte
+	SmallReads int // consecutive reads that would have fit the small buffer
+}
+
+// how many small reads before the bulk buffer is given back
+const bulkReadBufIdleReads = 64
+
+func (rs *readBufSizer) buf() []byte {
+	return *rs.Buf
+}
+
+func (rs *readBufSizer) update(nr int) {
+	if len(*rs.Buf) < outputBulkReadBufSize {
+		if nr == len(*rs.Buf) {
+			putReadBuf(rs.Buf)
+			rs.Buf = bulkReadBufPool.Get().(*[]byte)
+			rs.SmallReads = 0
+		}
+		return
+	}
+	if nr > outputReadBufSize {
+		rs.SmallReads = 0
+		return
+	}
+	rs.SmallReads++
+	if rs.SmallReads >= bulkReadBufIdleReads {
+		putReadBuf(rs.Buf)
+		rs.Buf = getReadBuf()
+		rs.SmallReads = 0
+	}
+}
+
+func (rs *readBufSizer) release() {
+	putReadBuf(rs.Buf)
+	rs.Buf = nil
+}
+
+func getOutputBuf() *bytes.Buffer {
+	outBuf := outputBufPool.Get().(*bytes.Buffer)
+	outBuf.Reset()
+	return outBuf
+}
+
+func putOutputBuf(outBuf *bytes.Buffer) {
+	if outBuf.Cap() > maxPooledOutputBufSize {
+		return
+	}
+	outputBufPool.Put(outBuf)
+}
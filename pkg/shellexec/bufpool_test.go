@@ -0,0 +1,107 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+// runs the output loop to the end, returns everything it wrote
+func runTestOutputLoop(src io.Reader) []byte {
+	var output bytes.Buffer
+	runOutputLoop(src, makeOutputPipeline(realClock{}, makeOutputHandler(makeEventHub()), &output, &output), func(error) {})
+	return output.Bytes()
+}
+
+func TestOutputLoopPooledBuffers(t *testing.T) {
+	inputs := [][]byte{
+		bytes.Repeat([]byte("plain ascii line 0123456789\r\n"), 2000),
+		bytes.Repeat([]byte(multibyteOutput+"\r\n"), 500),
+		[]byte("short"),
+	}
+	wg := &sync.WaitGroup{}
+	for round := 0; round < 4; round++ {
+		for _, input := range inputs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if output := runTestOutputLoop(bytes.NewReader(input)); !bytes.Equal(output, input) {
+					t.Errorf("output does not match the input (%d bytes, got %d)", len(input), len(output))
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func benchmarkOutputThroughput(b *testing.B, line string) {
+	data := bytes.Repeat([]byte(line), 16*1024)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runOutputLoop(bytes.NewReader(data), makeOutputPipeline(realClock{}, makeOutputHandler(makeEventHub()), io.Discard, io.Discard), func(error) {})
+	}
+}
+
+// a `cat largefile` style workload, compare allocs/op to see what the output loop allocates per read
+func BenchmarkOutputThroughputAscii(b *testing.B) {
+	benchmarkOutputThroughput(b, "lorem ipsum dolor sit amet, consectetur adipiscing elit 0123456789\r\n")
+}
+
+// reads end in the middle of a rune, so the utf8 chunker holds bytes back on most of them
+func BenchmarkOutputThroughputUtf8(b *testing.B) {
+	benchmarkOutputThroughput(b, "héllo wörld ünïcödé 日本語のテキスト 0123456789\r\n")
+}
+
+func TestReadBufSizer(t *testing.T) {
+	sizer := &readBufSizer{Buf: getReadBuf()}
+	defer sizer.release()
+	sizer.update(100)
+	if len(sizer.buf()) != outputReadBufSize {
+		t.Fatalf("a short read should keep the small buffer, got %d", len(sizer.buf()))
+	}
+	sizer.update(outputReadBufSize)
+	if len(sizer.buf()) != outputBulkReadBufSize {
+		t.Fatalf("a full read should switch to the bulk buffer, got %d", len(sizer.buf()))
+	}
+	for idx := 0; idx < bulkReadBufIdleReads-1; idx++ {
+		sizer.update(10)
+	}
+	sizer.update(outputReadBufSize + 1)
+	for idx := 0; idx < bulkReadBufIdleReads-1; idx++ {
+		sizer.update(10)
+	}
+	if len(sizer.buf()) != outputBulkReadBufSize {
+		t.Fatalf("a large read should keep the bulk buffer")
+	}
+	sizer.update(10)
+	if len(sizer.buf()) != outputReadBufSize {
+		t.Fatalf("expected the small buffer back after %d small reads, got %d", bulkReadBufIdleReads, len(sizer.buf()))
+	}
+}
+
+// a reader that returns at most Max bytes per read (like a linux pty)
+type maxReader struct {
+	Src io.Reader
+	Max int
+}
+
+func (r maxReader) Read(p []byte) (int, error) {
+	return r.Src.Read(p[:min(len(p), r.Max)])
+}
+
+// linux ptys return at most 4095 bytes per read, the loop stays on the small buffer
+func BenchmarkOutputThroughputPtyReads(b *testing.B) {
+	data := bytes.Repeat([]byte("lorem ipsum dolor sit amet, consectetur adipiscing elit 0123456789\r\n"), 16*1024)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runOutputLoop(maxReader{Src: bytes.NewReader(data), Max: outputReadBufSize - 1}, makeOutputPipeline(realClock{}, makeOutputHandler(makeEventHub()), io.Discard, io.Discard), func(error) {})
+	}
+}
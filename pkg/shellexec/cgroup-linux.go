@@ -0,0 +1,350 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package shellexec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	cgroup2Root          = "/sys/fs/cgroup"
+	cgroupCpuPeriod      = 100000 // usecs, the kernel default for cpu.max
+	cgroupMinCpuQuota    = 1000   // usecs, the kernel minimum for cpu.max
+	systemdScopeWait     = time.Second
+	systemdRunTimeout    = 2 * time.Second
+	shellCgroupPrefix    = "waveshell-"
+	systemdScopeSuffix   = ".scope"
+	cgroupNoLimit        = "max"
+	systemdNoMemoryLimit = "infinity"
+	systemdNoTasksLimit  = "infinity"
+)
+
+// shellCgroup is a transient cgroup (v2) that holds a single shell and all of its descendants.
+// it is either created directly in cgroupfs (when we have permission) or as a systemd scope
+// (systemd-run --scope), in which case systemd owns it and cleans it up.
+type shellCgroup struct {
+	Path string   // absolute path of the cgroup dir
+	Dir  *os.File // open until the shell is started (passed as SysProcAttr.CgroupFD)
+	Unit string   // set for systemd scopes
+
+	SessionId string // for logging
+}
+
+var systemdRunOnce = &sync.Once{}
+var systemdRunPath string // set if systemd-run works (probed once)
+
+// setupShellCgroup sets ecmd to start in a new cgroup if cmdOpts asks for one.
+// this is all best effort, failures are logged and the shell starts without (some of) the limits.
+// returns the limits the cgroup couldn't take (for setShellRlimits)
+func setupShellCgroup(cmdOpts CommandOptsType, ecmd *exec.Cmd) (*shellCgroup, CgroupLimits) {
+	limits := cmdOptsLimits(cmdOpts)
+	if !cmdOpts.ScopedCgroup && !limits.isSet() {
+		return nil, CgroupLimits{}
+	}
+	cg, err := makeShellCgroup(cmdOpts.CgroupParent, ecmd)
+	if err == nil {
+		cg.SessionId = cmdOpts.SessionID
+		if err = cg.setLimits(limits); err == nil {
+			return cg, CgroupLimits{}
+		}
+		if !systemdRunAvailable() {
+			shellLogf(cmdOpts.SessionID, "warning: cannot set limits for shell cgroup, starting without them: %v\n", err)
+			return cg, limits
+		}
+		cg.discard(ecmd)
+	}
+	if systemdRunAvailable() {
+		cg = makeSystemdScope(ecmd, limits)
+		if cg != nil {
+			cg.SessionId = cmdOpts.SessionID
+		}
+		return cg, CgroupLimits{}
+	}
+	shellLogf(cmdOpts.SessionID, "warning: cannot start shell in its own cgroup, starting without limits: %v\n", err)
+	return nil, limits
+}
+
+// returns the cgroup v2 dir of the current process
+func currentCgroupDir() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroup2Root, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup v2 is not mounted at %s", cgroup2Root)
+	}
+	return pidCgroupDir(os.Getpid())
+}
+
+func pidCgroupDir(pid int) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", fmt.Errorf("cannot read cgroup of pid %d: %w", pid, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if cgPath, found := strings.CutPrefix(line, "0::"); found {
+			return filepath.Join(cgroup2Root, cgPath), nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v2 entry for pid %d", pid)
+}
+
+// makeShellCgroup creates a new cgroup under parentDir (defaults to our own
+// cgroup) and sets ecmd to start in it.  Dir must be closed once ecmd is started.
+func makeShellCgroup(parentDir string, ecmd *exec.Cmd) (*shellCgroup, error) {
+	if parentDir == "" {
+		var err error
+		parentDir, err = currentCgroupDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// memory.events and the limits need the controllers enabled in the parent.  this fails if the
+	// parent has processes of its own (usually the case for our own cgroup), in which case setting
+	// limits fails and OOM detection falls back to the kernel log
+	for _, controller := range []string{"memory", "cpu", "pids"} {
+		writeCgroupFile(parentDir, "cgroup.subtree_control", "+"+controller)
+	}
+	cgPath := filepath.Join(parentDir, shellCgroupPrefix+uuid.NewString())
+	if err := os.Mkdir(cgPath, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create shell cgroup: %w", err)
+	}
+	dir, err := os.Open(cgPath)
+	if err != nil {
+		os.Remove(cgPath)
+		return nil, fmt.Errorf("cannot open shell cgroup: %w", err)
+	}
+	if ecmd.SysProcAttr == nil {
+		ecmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	ecmd.SysProcAttr.UseCgroupFD = true
+	ecmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	return &shellCgroup{Path: cgPath, Dir: dir}, nil
+}
+
+// undoes makeShellCgroup (before ecmd is started)
+func (cg *shellCgroup) discard(ecmd *exec.Cmd) {
+	ecmd.SysProcAttr.UseCgroupFD = false
+	ecmd.SysProcAttr.CgroupFD = 0
+	cg.remove()
+}
+
+// cgroupfs files must be written without O_CREATE
+func writeCgroupFile(cgPath string, name string, value string) error {
+	fd, err := os.OpenFile(filepath.Join(cgPath, name), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	_, err = fd.WriteString(value)
+	return err
+}
+
+func formatMemoryMax(limitBytes int64) string {
+	if limitBytes <= 0 {
+		return cgroupNoLimit
+	}
+	return strconv.FormatInt(limitBytes, 10)
+}
+
+func formatPidsMax(maxProcs int64) string {
+	if maxProcs <= 0 {
+		return cgroupNoLimit
+	}
+	return strconv.FormatInt(maxProcs, 10)
+}
+
+func formatCpuMax(cpus float64) string {
+	if cpus <= 0 {
+		return fmt.Sprintf("%s %d", cgroupNoLimit, cgroupCpuPeriod)
+	}
+	quota := max(int64(math.Round(cpus*cgroupCpuPeriod)), cgroupMinCpuQuota)
+	return fmt.Sprintf("%d %d", quota, cgroupCpuPeriod)
+}
+
+func (cg *shellCgroup) setLimits(limits CgroupLimits) error {
+	if cg.Unit != "" {
+		return setSystemdScopeLimits(cg.Unit, limits)
+	}
+	files := []struct {
+		name    string
+		value   string
+		isLimit bool
+	}{
+		{"memory.max", formatMemoryMax(limits.MemoryLimitBytes), limits.MemoryLimitBytes > 0},
+		{"cpu.max", formatCpuMax(limits.CPUQuota), limits.CPUQuota > 0},
+		{"pids.max", formatPidsMax(limits.MaxProcs), limits.MaxProcs > 0},
+	}
+	for _, file := range files {
+		err := writeCgroupFile(cg.Path, file.name, file.value)
+		if err != nil && !file.isLimit && errors.Is(err, fs.ErrNotExist) {
+			// no controller, but also nothing to limit
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot set %s: %w", file.name, err)
+		}
+	}
+	return nil
+}
+
+func systemdUserArgs() []string {
+	if os.Geteuid() == 0 {
+		return nil
+	}
+	return []string{"--user"}
+}
+
+// systemd-run --scope needs a running (user) service manager
+func systemdRunAvailable() bool {
+	systemdRunOnce.Do(func() {
+		path, err := exec.LookPath("systemd-run")
+		if err != nil {
+			return
+		}
+		ctx, cancelFn := context.WithTimeout(context.Background(), systemdRunTimeout)
+		defer cancelFn()
+		args := append(systemdUserArgs(), "--scope", "--quiet", "--collect", "true")
+		if err := exec.CommandContext(ctx, path, args...).Run(); err != nil {
+			log.Printf("systemd-run --scope is not available: %v\n", err)
+			return
+		}
+		systemdRunPath = path
+	})
+	return systemdRunPath != ""
+}
+
+// systemd properties for limits, unset limits are reset
+func systemdLimitProps(limits CgroupLimits) []string {
+	memoryMax := systemdNoMemoryLimit
+	if limits.MemoryLimitBytes > 0 {
+		memoryMax = strconv.FormatInt(limits.MemoryLimitBytes, 10)
+	}
+	cpuQuota := ""
+	if limits.CPUQuota > 0 {
+		cpuQuota = fmt.Sprintf("%d%%", int(math.Ceil(limits.CPUQuota*100)))
+	}
+	tasksMax := systemdNoTasksLimit
+	if limits.MaxProcs > 0 {
+		tasksMax = strconv.FormatInt(limits.MaxProcs, 10)
+	}
+	return []string{"MemoryMax=" + memoryMax, "CPUQuota=" + cpuQuota, "TasksMax=" + tasksMax}
+}
+
+// rewrites ecmd to run through systemd-run --scope (which execs the shell, so the pid doesn't change)
+func makeSystemdScope(ecmd *exec.Cmd, limits CgroupLimits) *shellCgroup {
+	unit := shellCgroupPrefix + uuid.NewString() + systemdScopeSuffix
+	args := append([]string{"systemd-run"}, systemdUserArgs()...)
+	args = append(args, "--scope", "--quiet", "--collect", "--unit="+unit, "-p", "MemoryAccounting=yes")
+	for _, prop := range systemdLimitProps(limits) {
+		args = append(args, "-p", prop)
+	}
+	args = append(args, "--", ecmd.Path)
+	args = append(args, ecmd.Args[1:]...)
+	ecmd.Path = systemdRunPath
+	ecmd.Args = args
+	return &shellCgroup{Unit: unit}
+}
+
+func setSystemdScopeLimits(unit string, limits CgroupLimits) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), systemdRunTimeout)
+	defer cancelFn()
+	args := append(systemdUserArgs(), "set-property", "--runtime", unit)
+	args = append(args, systemdLimitProps(limits)...)
+	output, err := exec.CommandContext(ctx, "systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl set-property %s: %w (%s)", unit, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// called once the shell has started.  for systemd scopes this waits for systemd-run
+// to move itself into the scope so that Path is known (needed for OOM detection).
+func (cg *shellCgroup) started(pid int) {
+	cg.closeDir()
+	if cg.Unit == "" {
+		return
+	}
+	deadline := shellClock.Now().Add(systemdScopeWait)
+	for shellClock.Now().Before(deadline) {
+		cgPath, err := pidCgroupDir(pid)
+		if err != nil {
+			return
+		}
+		if filepath.Base(cgPath) == cg.Unit {
+			cg.Path = cgPath
+			return
+		}
+		<-shellClock.After(5 * time.Millisecond)
+	}
+	shellLogf(cg.SessionId, "warning: shell did not move into systemd scope %s\n", cg.Unit)
+}
+
+func (cg *shellCgroup) closeDir() {
+	if cg.Dir != nil {
+		cg.Dir.Close()
+		cg.Dir = nil
+	}
+}
+
+// returns the oom_kill count from memory.events (which includes descendant cgroups), ok is false
+// if the memory controller is not enabled for this cgroup
+func (cg *shellCgroup) oomKills() (int, bool) {
+	if cg.Path == "" {
+		return 0, false
+	}
+	data, err := os.ReadFile(filepath.Join(cg.Path, "memory.events"))
+	if err != nil {
+		return 0, false
+	}
+	return parseMemoryEventsOomKills(data)
+}
+
+func parseMemoryEventsOomKills(data []byte) (int, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		name, valStr, found := strings.Cut(scanner.Text(), " ")
+		if !found || name != "oom_kill" {
+			continue
+		}
+		val, err := strconv.Atoi(valStr)
+		if err != nil {
+			return 0, false
+		}
+		return val, true
+	}
+	return 0, false
+}
+
+// the cgroup path as the kernel reports it (relative to the cgroup v2 root)
+func (cg *shellCgroup) kernelPath() string {
+	return strings.TrimPrefix(cg.Path, cgroup2Root)
+}
+
+// remove removes the cgroup, this fails (and is logged) if processes started by the shell are still
+// running in it.  systemd scopes are removed by systemd once they are empty.
+func (cg *shellCgroup) remove() {
+	cg.closeDir()
+	if cg.Unit != "" {
+		return
+	}
+	if err := os.Remove(cg.Path); err != nil {
+		shellLogf(cg.SessionId, "cannot remove shell cgroup %s: %v\n", cg.Path, err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package shellexec
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// set to a delegated cgroup v2 dir (one we can create cgroups in, with the memory and cpu
+// controllers available) to run the live cgroup tests, defaults to our own cgroup
+const testCgroupParentEnvVar = "WAVETERM_TEST_CGROUP_PARENT"
+
+func testCgroupParent(t *testing.T) string {
+	if parentDir := os.Getenv(testCgroupParentEnvVar); parentDir != "" {
+		return parentDir
+	}
+	parentDir, err := currentCgroupDir()
+	if err != nil {
+		t.Skipf("no cgroup v2 (set %s to a delegated cgroup): %v", testCgroupParentEnvVar, err)
+	}
+	return parentDir
+}
+
+func readCgroupFile(t *testing.T, cgPath string, name string) string {
+	data, err := os.ReadFile(filepath.Join(cgPath, name))
+	if err != nil {
+		t.Fatalf("error reading %s: %v", name, err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestParseMemoryEvents(t *testing.T) {
+	oomKills, ok := parseMemoryEventsOomKills([]byte("low 0\nhigh 0\nmax 12\noom 1\noom_kill 2\noom_group_kill 0\n"))
+	if !ok || oomKills != 2 {
+		t.Errorf("expected 2 oom kills, got %d (ok=%v)", oomKills, ok)
+	}
+	if _, ok := parseMemoryEventsOomKills([]byte("low 0\n")); ok {
+		t.Errorf("missing oom_kill should not be ok")
+	}
+}
+
+// uses a plain dir in place of cgroupfs
+func TestCgroupSetLimitsFiles(t *testing.T) {
+	cg := &shellCgroup{Path: t.TempDir()}
+	for _, name := range []string{"memory.max", "cpu.max", "pids.max"} {
+		os.WriteFile(filepath.Join(cg.Path, name), []byte("max\n"), 0644)
+	}
+	if err := cg.setLimits(CgroupLimits{MemoryLimitBytes: 4 << 30, CPUQuota: 2, MaxProcs: 64}); err != nil {
+		t.Fatalf("setLimits error: %v", err)
+	}
+	if val := readCgroupFile(t, cg.Path, "memory.max"); val != "4294967296" {
+		t.Errorf("unexpected memory.max %q", val)
+	}
+	if val := readCgroupFile(t, cg.Path, "cpu.max"); val != "200000 100000" {
+		t.Errorf("unexpected cpu.max %q", val)
+	}
+	if val := readCgroupFile(t, cg.Path, "pids.max"); val != "64" {
+		t.Errorf("unexpected pids.max %q", val)
+	}
+	if err := cg.setLimits(CgroupLimits{}); err != nil {
+		t.Fatalf("setLimits error: %v", err)
+	}
+	if val := readCgroupFile(t, cg.Path, "memory.max"); val != "max" {
+		t.Errorf("memory limit should be removed, got %q", val)
+	}
+	if val := readCgroupFile(t, cg.Path, "cpu.max"); val != "max 100000" {
+		t.Errorf("cpu limit should be removed, got %q", val)
+	}
+	if val := readCgroupFile(t, cg.Path, "pids.max"); val != "max" {
+		t.Errorf("process limit should be removed, got %q", val)
+	}
+	// no controllers is only an error if there is something to limit
+	noCtl := &shellCgroup{Path: t.TempDir()}
+	if err := noCtl.setLimits(CgroupLimits{}); err != nil {
+		t.Errorf("removing limits without controllers should not fail: %v", err)
+	}
+	if err := noCtl.setLimits(CgroupLimits{MemoryLimitBytes: 1 << 20}); err == nil {
+		t.Errorf("a memory limit without the memory controller should fail")
+	}
+}
+
+func TestCgroupLimitFormats(t *testing.T) {
+	if val := formatCpuMax(0.001); val != "1000 100000" {
+		t.Errorf("tiny quotas should be raised to the kernel minimum, got %q", val)
+	}
+	props := systemdLimitProps(CgroupLimits{MemoryLimitBytes: 1 << 30, CPUQuota: 1.5, MaxProcs: 100})
+	if strings.Join(props, " ") != "MemoryMax=1073741824 CPUQuota=150% TasksMax=100" {
+		t.Errorf("unexpected systemd props %q", props)
+	}
+	props = systemdLimitProps(CgroupLimits{})
+	if strings.Join(props, " ") != "MemoryMax=infinity CPUQuota= TasksMax=infinity" {
+		t.Errorf("unexpected systemd reset props %q", props)
+	}
+}
+
+func TestCgroupLimitsLive(t *testing.T) {
+	parentDir := testCgroupParent(t)
+	cmdOpts := CommandOptsType{CgroupParent: parentDir, MemoryLimitBytes: 256 * 1024 * 1024, CPUQuota: 0.5}
+	sp := startTestShellProc(t, "read x", cmdOpts)
+	if sp.cgroup == nil || sp.cgroup.Path == "" {
+		t.Skipf("no cgroup with limits available under %s", parentDir)
+	}
+	procs := strings.Fields(readCgroupFile(t, sp.cgroup.Path, "cgroup.procs"))
+	if !slices.Contains(procs, strconv.Itoa(sp.localPid())) {
+		t.Errorf("shell pid %d not in cgroup.procs %q", sp.localPid(), procs)
+	}
+	if _, err := os.Stat(filepath.Join(sp.cgroup.Path, "memory.max")); err != nil {
+		t.Skipf("memory controller not available for %s", sp.cgroup.Path)
+	}
+	if val := readCgroupFile(t, sp.cgroup.Path, "memory.max"); val != "268435456" {
+		t.Errorf("unexpected memory.max %q", val)
+	}
+	if err := sp.SetLimits(CgroupLimits{MemoryLimitBytes: 128 * 1024 * 1024}); err != nil {
+		t.Fatalf("SetLimits error: %v", err)
+	}
+	if val := readCgroupFile(t, sp.cgroup.Path, "memory.max"); val != "134217728" {
+		t.Errorf("unexpected memory.max after SetLimits %q", val)
+	}
+	if val := readCgroupFile(t, sp.cgroup.Path, "cpu.max"); !strings.HasPrefix(val, "max") {
+		t.Errorf("cpu limit should be removed after SetLimits, got %q", val)
+	}
+}
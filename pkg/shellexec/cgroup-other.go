@@ -0,0 +1,31 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package shellexec
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+type shellCgroup struct {
+	Path string
+}
+
+// the limits are all left to setShellRlimits
+func setupShellCgroup(cmdOpts CommandOptsType, ecmd *exec.Cmd) (*shellCgroup, CgroupLimits) {
+	if cmdOpts.ScopedCgroup {
+		shellLogf(cmdOpts.SessionID, "warning: cgroups are only supported on linux, starting shell without one\n")
+	}
+	return nil, cmdOptsLimits(cmdOpts)
+}
+
+func (cg *shellCgroup) setLimits(limits CgroupLimits) error {
+	return fmt.Errorf("cgroups are only supported on linux")
+}
+
+func (cg *shellCgroup) started(pid int) {}
+
+func (cg *shellCgroup) remove() {}
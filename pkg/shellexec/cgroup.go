@@ -0,0 +1,37 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+)
+
+// CgroupLimits are resource limits for a shell and all of its descendants (linux only)
+type CgroupLimits struct {
+	MemoryLimitBytes int64   `json:"memorylimitbytes,omitempty"` // <= 0 for no limit
+	CPUQuota         float64 `json:"cpuquota,omitempty"`         // in cpus (e.g. 2 is "at most 2 cpus"), <= 0 for no limit
+	MaxProcs         int64   `json:"maxprocs,omitempty"`         // processes and threads (pids.max), <= 0 for no limit
+}
+
+func (limits CgroupLimits) isSet() bool {
+	return limits.MemoryLimitBytes > 0 || limits.CPUQuota > 0 || limits.MaxProcs > 0
+}
+
+func cmdOptsLimits(cmdOpts CommandOptsType) CgroupLimits {
+	return CgroupLimits{MemoryLimitBytes: cmdOpts.MemoryLimitBytes, CPUQuota: cmdOpts.CPUQuota, MaxProcs: cmdOpts.MaxProcs}
+}
+
+// SetLimits changes the limits of a shell that was started in its own cgroup (ScopedCgroup,
+// MemoryLimitBytes, CPUQuota or MaxProcs).  Zero values remove a limit.
+func (sp *ShellProc) SetLimits(limits CgroupLimits) error {
+	if sp.cgroup == nil {
+		return fmt.Errorf("shell is not running in its own cgroup")
+	}
+	select {
+	case <-sp.DoneCh:
+		return fmt.Errorf("shell has exited")
+	default:
+	}
+	return sp.cgroup.setLimits(limits)
+}
@@ -0,0 +1,104 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+const (
+	CheckpointInterval = 64 * 1024 // a checkpoint is recorded every CheckpointInterval bytes of output
+	CheckpointWindow   = 4 * 1024  // each checkpoint hashes the CheckpointWindow bytes before it
+	MaxCheckpoints     = 256
+)
+
+type outputCheckpoint struct {
+	Offset int64
+	Hash   uint64
+}
+
+// checkpointTracker records periodic (offset, hash) pairs for the output stream
+// so reconnecting clients can cheaply verify that their state matches ours.
+type checkpointTracker struct {
+	Lock        *sync.Mutex
+	Window      []byte // the last CheckpointWindow bytes
+	Total       int64
+	Checkpoints []outputCheckpoint
+}
+
+func makeCheckpointTracker() *checkpointTracker {
+	return &checkpointTracker{Lock: &sync.Mutex{}, Window: make([]byte, 0, 2*CheckpointWindow)}
+}
+
+// CheckpointHash is the hash clients must compute over the CheckpointWindow
+// bytes of output that precede a checkpoint offset (or all of the output if
+// the offset is smaller than CheckpointWindow).
+func CheckpointHash(data []byte) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write(data)
+	return hasher.Sum64()
+}
+
+// must hold Lock
+func (ct *checkpointTracker) appendWindow(data []byte) {
+	ct.Window = append(ct.Window, data...)
+	if len(ct.Window) > CheckpointWindow {
+		// compact in place (cap is 2x the window, so this is amortized)
+		ct.Window = append(ct.Window[:0], ct.Window[len(ct.Window)-CheckpointWindow:]...)
+	}
+}
+
+func (ct *checkpointTracker) Write(data []byte) (int, error) {
+	ct.Lock.Lock()
+	defer ct.Lock.Unlock()
+	n := len(data)
+	for len(data) > 0 {
+		untilNext := CheckpointInterval - int(ct.Total%CheckpointInterval)
+		if len(data) < untilNext {
+			ct.appendWindow(data)
+			ct.Total += int64(len(data))
+			break
+		}
+		if untilNext > CheckpointWindow {
+			// only the tail of this piece can end up in the window
+			ct.Window = ct.Window[:0]
+			ct.Total += int64(untilNext - CheckpointWindow)
+			data = data[untilNext-CheckpointWindow:]
+			untilNext = CheckpointWindow
+		}
+		ct.appendWindow(data[:untilNext])
+		ct.Total += int64(untilNext)
+		data = data[untilNext:]
+		ct.Checkpoints = append(ct.Checkpoints, outputCheckpoint{Offset: ct.Total, Hash: CheckpointHash(ct.Window)})
+		if len(ct.Checkpoints) > MaxCheckpoints {
+			ct.Checkpoints = ct.Checkpoints[len(ct.Checkpoints)-MaxCheckpoints:]
+		}
+	}
+	return n, nil
+}
+
+func (ct *checkpointTracker) validate(offset int64, clientHash uint64) (bool, int64) {
+	ct.Lock.Lock()
+	defer ct.Lock.Unlock()
+	// index of the first checkpoint with Offset > offset
+	idx := sort.Search(len(ct.Checkpoints), func(i int) bool {
+		return ct.Checkpoints[i].Offset > offset
+	})
+	if idx == 0 {
+		return false, -1
+	}
+	nearest := ct.Checkpoints[idx-1]
+	return nearest.Offset == offset && nearest.Hash == clientHash, nearest.Offset
+}
+
+// ValidateOffset checks a reconnecting client's claimed state.  offset must be a
+// checkpoint offset (a multiple of CheckpointInterval) and clientHash the
+// CheckpointHash of the client's CheckpointWindow bytes before it.  If ok is
+// false the client should do a full redraw from the scrollback (or validate
+// again at nearestCheckpoint, which is -1 if no retained checkpoint is <= offset).
+func (sp *ShellProc) ValidateOffset(offset int64, clientHash uint64) (ok bool, nearestCheckpoint int64) {
+	return sp.checkpoints.validate(offset, clientHash)
+}
@@ -0,0 +1,58 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCheckpointValidate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 5*CheckpointInterval+1234)
+	rng.Read(data)
+	ct := makeCheckpointTracker()
+	for pos := 0; pos < len(data); {
+		chunkLen := min(len(data)-pos, 1+rng.Intn(20000))
+		ct.Write(data[pos : pos+chunkLen])
+		pos += chunkLen
+	}
+	if len(ct.Checkpoints) != 5 {
+		t.Fatalf("expected 5 checkpoints, got %d", len(ct.Checkpoints))
+	}
+	for idx := 1; idx <= 5; idx++ {
+		offset := int64(idx * CheckpointInterval)
+		clientHash := CheckpointHash(data[offset-CheckpointWindow : offset])
+		ok, nearest := ct.validate(offset, clientHash)
+		if !ok || nearest != offset {
+			t.Errorf("checkpoint %d failed validation (nearest=%d)", offset, nearest)
+		}
+		ok, _ = ct.validate(offset, clientHash+1)
+		if ok {
+			t.Errorf("checkpoint %d validated with the wrong hash", offset)
+		}
+	}
+	ok, nearest := ct.validate(2*CheckpointInterval+100, 0)
+	if ok || nearest != 2*CheckpointInterval {
+		t.Errorf("non-checkpoint offset: ok=%v nearest=%d", ok, nearest)
+	}
+	ok, nearest = ct.validate(100, 0)
+	if ok || nearest != -1 {
+		t.Errorf("offset before the first checkpoint: ok=%v nearest=%d", ok, nearest)
+	}
+}
+
+func TestCheckpointsBounded(t *testing.T) {
+	ct := makeCheckpointTracker()
+	chunk := make([]byte, 1024*1024)
+	for idx := 0; idx < (MaxCheckpoints*CheckpointInterval)/len(chunk)+4; idx++ {
+		ct.Write(chunk)
+	}
+	if len(ct.Checkpoints) != MaxCheckpoints {
+		t.Fatalf("expected %d checkpoints, got %d", MaxCheckpoints, len(ct.Checkpoints))
+	}
+	if len(ct.Window) > CheckpointWindow {
+		t.Fatalf("window grew to %d bytes", len(ct.Window))
+	}
+}
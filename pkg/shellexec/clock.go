@@ -0,0 +1,42 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"time"
+)
+
+// clock is used by shellexec's timers so tests can substitute a fake clock
+// (testutil.FakeClock satisfies this interface)
+type clock interface {
+	Now() time.Time
+	// NewTimer returns the timer channel and a function to stop the timer
+	NewTimer(d time.Duration) (<-chan time.Time, func() bool)
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls fn in its own goroutine after d, returns a function to stop the timer
+	AfterFunc(d time.Duration, fn func()) func() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	timer := time.NewTimer(d)
+	return timer.C, timer.Stop
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, fn func()) func() bool {
+	return time.AfterFunc(d, fn).Stop
+}
+
+// shellprocs capture the clock when they are created.  this is only meant to
+// be swapped by tests (before starting any shellprocs), it is not synchronized.
+var shellClock clock = realClock{}
@@ -0,0 +1,122 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"github.com/wavetermdev/waveterm/pkg/wshrpc"
+	"github.com/wavetermdev/waveterm/pkg/wstore"
+)
+
+const (
+	DefaultCommandHistoryMax = 10000 // entries kept, the oldest are dropped
+	DefaultHistoryQueryLimit = 100
+	historyWriteTimeout      = 2 * time.Second
+)
+
+// CommandHistory is the store of the commands run in our shells (CommandOptsType.History), kept in
+// wstore (db_cmdhistory).  commands come from the shell integration's marks (see CommandMark.CommandLine),
+// so commands the shell doesn't mark (or that start with a space) are not recorded.  it is separate from
+// the shells' own history files (see shellutil.HistoryScope_PerBlock) and outlives them.  commands can
+// hold secrets, so shells only record into it when term:cmdhistory is set (see blockcontroller)
+type CommandHistory struct {
+	MaxEntries int
+}
+
+var defaultHistory = &CommandHistory{MaxEntries: DefaultCommandHistoryMax}
+
+// DefaultCommandHistory returns the history in wstore (which must be initialized before it's used)
+func DefaultCommandHistory() *CommandHistory {
+	return defaultHistory
+}
+
+// Add records entry (with the next id) and returns it, once there are more than MaxEntries the oldest are dropped
+func (ch *CommandHistory) Add(ctx context.Context, entry wshrpc.CmdHistoryEntry) (wshrpc.CmdHistoryEntry, error) {
+	return wstore.WithTxRtn(ctx, func(tx *wstore.TxWrap) (wshrpc.CmdHistoryEntry, error) {
+		query := `INSERT INTO db_cmdhistory (blockid, connname, sessionid, command, cwd, exitcode, startts, durationms)
+                                     VALUES (      ?,        ?,         ?,       ?,   ?,        ?,       ?,          ?)`
+		result := tx.Exec(query, entry.BlockId, entry.ConnName, entry.SessionId, entry.Command, entry.Cwd, entry.ExitCode, entry.StartTs, entry.DurationMs)
+		id, err := result.LastInsertId()
+		if err != nil {
+			return entry, err
+		}
+		entry.Id = id
+		if ch.MaxEntries > 0 {
+			tx.Exec(`DELETE FROM db_cmdhistory WHERE id <= ?`, id-int64(ch.MaxEntries))
+		}
+		return entry, nil
+	})
+}
+
+// Query returns the entries matching q, newest first
+func (ch *CommandHistory) Query(ctx context.Context, q wshrpc.CmdHistoryQuery) ([]wshrpc.CmdHistoryEntry, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultHistoryQueryLimit
+	}
+	var conds []string
+	var args []interface{}
+	if q.BlockId != "" {
+		conds = append(conds, "blockid = ?")
+		args = append(args, q.BlockId)
+	}
+	if q.ConnName != nil {
+		conds = append(conds, "connname = ?")
+		args = append(args, *q.ConnName)
+	}
+	if q.BeforeId > 0 {
+		conds = append(conds, "id < ?")
+		args = append(args, q.BeforeId)
+	}
+	if q.Contains != "" {
+		// (instr is case sensitive, unlike LIKE)
+		conds = append(conds, "instr(command, ?) > 0")
+		args = append(args, q.Contains)
+	}
+	query := `SELECT id, blockid, connname, sessionid, command, cwd, exitcode, startts, durationms FROM db_cmdhistory`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+	return wstore.WithTxRtn(ctx, func(tx *wstore.TxWrap) ([]wshrpc.CmdHistoryEntry, error) {
+		var rtn []wshrpc.CmdHistoryEntry
+		tx.Select(&rtn, query, args...)
+		return rtn, nil
+	})
+}
+
+// records the commands the shell marks as ended, subscribed before the output loop starts so no command is missed
+func (sp *ShellProc) startHistoryRecorder(history *CommandHistory, blockId string) {
+	events, unsub := sp.SubscribeEvents(DefaultEventBufferSize)
+	go func() {
+		defer panichandler.PanicHandler("ShellProc:history")
+		defer unsub()
+		for event := range events {
+			if event.Kind != EventKind_CommandEnd || event.Command == nil || event.CommandResult == nil || event.Command.CommandLine == "" {
+				continue
+			}
+			entry := wshrpc.CmdHistoryEntry{
+				BlockId:    blockId,
+				ConnName:   sp.ConnName,
+				SessionId:  sp.sessionId,
+				Command:    event.Command.CommandLine,
+				Cwd:        event.CommandResult.Cwd,
+				ExitCode:   event.CommandResult.ExitCode,
+				StartTs:    event.CommandResult.StartTs,
+				DurationMs: event.CommandResult.DurationMs,
+			}
+			ctx, cancelFn := context.WithTimeout(context.Background(), historyWriteTimeout)
+			_, err := history.Add(ctx, entry)
+			cancelFn()
+			if err != nil {
+				sp.logf("warning: cannot record command history: %v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,130 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/wshrpc"
+	"github.com/wavetermdev/waveterm/pkg/wstore"
+)
+
+func TestUnescapeCommandLine(t *testing.T) {
+	tests := map[string]string{
+		`ls -la`:              "ls -la",
+		`echo a\x3bb\\c`:      `echo a;b\c`,
+		`for x\x0ado\x09y`:    "for x\ndo\ty",
+		`bad \xzz and \q \x4`: `bad \xzz and \q \x4`,
+		`trailing \`:          `trailing \`,
+	}
+	for escaped, expected := range tests {
+		if got := unescapeCommandLine(escaped); got != expected {
+			t.Errorf("unescape %q: expected %q, got %q", escaped, expected, got)
+		}
+	}
+}
+
+// a fresh wstore (see initTestProcStore) with an empty history
+func initTestCmdHistory(t *testing.T) {
+	t.Helper()
+	initTestProcStore(t)
+	t.Cleanup(func() {
+		wstore.WithTx(context.Background(), func(tx *wstore.TxWrap) error {
+			tx.Exec(`DELETE FROM db_cmdhistory`)
+			return nil
+		})
+	})
+}
+
+func TestCommandHistoryStore(t *testing.T) {
+	initTestCmdHistory(t)
+	ctx, cancelFn := context.WithTimeout(context.Background(), testWaitTimeout)
+	defer cancelFn()
+	history := &CommandHistory{MaxEntries: 3}
+	blocks := []string{"block-a", "block-b", "block-a", "block-b", "block-a"}
+	var lastId int64
+	for idx, blockId := range blocks {
+		connName := ""
+		if blockId == "block-b" {
+			connName = "user@host"
+		}
+		entry, err := history.Add(ctx, wshrpc.CmdHistoryEntry{BlockId: blockId, ConnName: connName, Command: fmt.Sprintf("cmd-%d", idx)})
+		if err != nil {
+			t.Fatalf("error adding entry: %v", err)
+		}
+		if entry.Id <= lastId {
+			t.Fatalf("expected increasing ids, got %d after %d", entry.Id, lastId)
+		}
+		lastId = entry.Id
+	}
+	query := func(q wshrpc.CmdHistoryQuery) []wshrpc.CmdHistoryEntry {
+		t.Helper()
+		entries, err := history.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("error querying history: %v", err)
+		}
+		return entries
+	}
+	// only the newest 3 are kept
+	if entries := query(wshrpc.CmdHistoryQuery{}); len(entries) != 3 || entries[0].Command != "cmd-4" || entries[2].Command != "cmd-2" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+	if entries := query(wshrpc.CmdHistoryQuery{BlockId: "block-a"}); len(entries) != 2 || entries[1].Command != "cmd-2" {
+		t.Errorf("unexpected block-a entries %+v", entries)
+	}
+	local := ""
+	if entries := query(wshrpc.CmdHistoryQuery{ConnName: &local, Limit: 1}); len(entries) != 1 || entries[0].Command != "cmd-4" {
+		t.Errorf("unexpected local entries %+v", entries)
+	}
+	if entries := query(wshrpc.CmdHistoryQuery{Contains: "-3", BeforeId: lastId - 1}); len(entries) != 0 {
+		t.Errorf("expected no entries with -3 before cmd-3, got %+v", entries)
+	}
+	if entries := query(wshrpc.CmdHistoryQuery{Contains: "CMD"}); len(entries) != 0 {
+		t.Errorf("expected contains to be case sensitive, got %+v", entries)
+	}
+}
+
+// runs commands in an interactive bash and checks what our integration's marks recorded
+func TestCommandHistoryCapture(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no bash on windows")
+	}
+	initTestCmdHistory(t)
+	history := &CommandHistory{}
+	sp := startTestShellProc(t, "", CommandOptsType{BlockId: "history-capture", History: history})
+	oc := collectOutput(sp)
+	for _, cmd := range []string{`echo "one;two"`, "sh -c 'exit 3'", "", " echo hidden", "false"} {
+		fmt.Fprintf(sp.Cmd, "%s\r", cmd)
+		time.Sleep(50 * time.Millisecond)
+	}
+	deadline := time.Now().Add(testWaitTimeout)
+	var entries []wshrpc.CmdHistoryEntry
+	for time.Now().Before(deadline) {
+		var err error
+		entries, err = history.Query(context.Background(), wshrpc.CmdHistoryQuery{BlockId: "history-capture"})
+		if err != nil {
+			t.Fatalf("error querying history: %v", err)
+		}
+		if len(entries) >= 3 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %+v (output %q)", entries, oc.String())
+	}
+	expected := []struct {
+		Command  string
+		ExitCode int
+	}{{"false", 1}, {"sh -c 'exit 3'", 3}, {`echo "one;two"`, 0}}
+	for idx, entry := range entries {
+		if entry.Command != expected[idx].Command || entry.ExitCode == nil || *entry.ExitCode != expected[idx].ExitCode || entry.SessionId != sp.SessionID() {
+			t.Errorf("entry %d: expected %+v, got %+v", idx, expected[idx], entry)
+		}
+	}
+}
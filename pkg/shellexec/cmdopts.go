@@ -0,0 +1,181 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"maps"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+)
+
+type CommandOptsType struct {
+	Interactive bool              `json:"interactive,omitempty"`
+	Login       bool              `json:"login,omitempty"`
+	Cwd         string            `json:"cwd,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+
+	// the shell to run instead of the detected one (shellutil.DetectLocalShellPath, or the remote's shell), ShellOpts
+	// go before our own flags.  blocks set these from term:localshellpath and term:localshellopts
+	ShellPath string   `json:"shellPath,omitempty"`
+	ShellOpts []string `json:"shellOpts,omitempty"`
+
+	// local shells only, tried in order when the shell doesn't exist (see ShellFallback), FallbackShell_Env
+	// is $SHELL.  nil uses $SHELL, /bin/zsh, /bin/bash then /bin/sh (pwsh, powershell then cmd on windows),
+	// empty never falls back
+	FallbackShells []string `json:"fallbackShells,omitempty"`
+
+	// output coalescing (see coalesceBuffer), zero values use the defaults, a negative delay disables coalescing
+	OutputFlushSize  int           `json:"outputFlushSize,omitempty"`
+	OutputFlushDelay time.Duration `json:"outputFlushDelay,omitempty"`
+	ScrollbackSize   int           `json:"scrollbackSize,omitempty"` // bytes of output retained for search and replay, zero uses the default
+
+	// local shells only, which of our (inherited) env vars the shell gets, nil passes them all (the vars
+	// we set and Env are not filtered)
+	EnvFilter *shellutil.EnvFilter `json:"envFilter,omitempty"`
+
+	// local shells only, start from an empty env instead of ours: the shell only gets Env and the vars we
+	// need (TERM, LANG, WAVETERM_*, the integration and history vars), not even PATH or HOME
+	CleanEnv bool `json:"cleanEnv,omitempty"`
+
+	// env validation (see shellutil.CheckEnv), values over MaxEnvValueSize are logged (or dropped)
+	MaxEnvValueSize  int  `json:"maxEnvValueSize,omitempty"`
+	DropOversizedEnv bool `json:"dropOversizedEnv,omitempty"`
+
+	// TERM for the shell (shellutil.DefaultTermType if empty), e.g. "dumb" for commands whose output is
+	// logged or "tmux-256color".  for ssh shells this is the pty request's term type
+	TermType string `json:"termType,omitempty"`
+
+	// local shells only, BlockId is required for HistoryScope_PerBlock
+	HistoryScope string `json:"historyScope,omitempty"` // shellutil.HistoryScope_*
+	BlockId      string `json:"blockId,omitempty"`
+
+	// records the commands the shell runs (with BlockId and the connection, see CommandHistory), nil for none
+	History *CommandHistory `json:"-"`
+
+	// exit after IdleExit without input (and output, if IdleCountOutput), a warning is shown IdleExitGrace before exiting
+	IdleExit        time.Duration `json:"idleExit,omitempty"`
+	IdleExitGrace   time.Duration `json:"idleExitGrace,omitempty"`
+	IdleCountOutput bool          `json:"idleCountOutput,omitempty"`
+
+	// output idle time before the shell is considered likely at its prompt (see ShellProc.LikelyAtPrompt),
+	// zero uses the default, negative disables the detector
+	PromptIdleWindow time.Duration `json:"promptIdleWindow,omitempty"`
+
+	// how often EventKind_IOStats is published while the shell reads or writes, zero uses
+	// DefaultIOStatsInterval, negative disables the events (ShellProc.IOStats still works)
+	IOStatsInterval time.Duration `json:"ioStatsInterval,omitempty"`
+
+	// start the shell through a trampoline that waits for ShellProc.Release() before exec'ing the shell (local shells only)
+	StartSuspended bool `json:"startSuspended,omitempty"`
+
+	// start the shell in its own transient cgroup (linux, cgroup v2) under CgroupParent (defaults to our own cgroup),
+	// this lets OOM kills of any of its descendants be detected.  setting a limit implies ScopedCgroup, if the
+	// cgroup can't be created directly a systemd scope is used.  see CgroupLimits (and ShellProc.SetLimits).
+	// without a cgroup the memory and process limits fall back to rlimits on the shell (see setShellRlimits)
+	ScopedCgroup     bool    `json:"scopedCgroup,omitempty"`
+	CgroupParent     string  `json:"cgroupParent,omitempty"`
+	MemoryLimitBytes int64   `json:"memoryLimitBytes,omitempty"`
+	CPUQuota         float64 `json:"cpuQuota,omitempty"`
+	MaxProcs         int64   `json:"maxProcs,omitempty"`
+
+	// local shells only, the shell's nice value (MinNice to MaxNice, higher runs at a lower cpu priority), for
+	// background or batch blocks.  set right after the shell starts (like the rlimits) and inherited by what it
+	// runs, going below our own nice value needs privileges.  on windows it picks a priority class instead
+	Nice int `json:"nice,omitempty"`
+
+	// run the shell as root through ElevateTool (ElevateTool_Sudo, the default, or ElevateTool_Doas), local shells
+	// on linux and macos only.  elevated shells keep root's own history (HistoryScope is ignored).  see elevateShellCmd
+	Elevate     bool   `json:"elevate,omitempty"`
+	ElevateTool string `json:"elevateTool,omitempty"`
+
+	// with Elevate and sudo, sudo asks for the password through an askpass helper of ours instead of on the
+	// pty: each prompt is an EventKind_AuthRequired event, answered with ShellProc.RespondAuth (or CancelAuth).
+	// non-interactive commands can then be elevated too.  the helper replaces any SUDO_ASKPASS in Env
+	ElevateAskpass bool `json:"elevateAskpass,omitempty"`
+
+	// run the shell as another local user (SysProcAttr.Credential, so we must be privileged), local shells on linux
+	// and macos only.  Gid and Groups default to the user's own (from the user database), HOME, USER and LOGNAME
+	// are set for the user and the shell starts in their home if Cwd isn't usable.  the user keeps their own
+	// history (HistoryScope is ignored), our shell integration files must be readable by them.  can't be combined
+	// with Elevate or Supervise
+	Uid    *uint32  `json:"uid,omitempty"`
+	Gid    *uint32  `json:"gid,omitempty"`
+	Groups []uint32 `json:"groups,omitempty"`
+
+	// debug option, have the integration hooks print a marker before the first prompt so StartupTimings has
+	// PromptReady (integrated bash, zsh and fish shells only), the timings are logged when it arrives
+	MeasurePromptReady bool `json:"measurePromptReady,omitempty"`
+
+	// local shells only (linux and macos), termios settings applied to the pty before the process starts.
+	// mostly for StartArgvProc programs, shells (and line editors like readline) set their own modes
+	TtyInit *TtyInitOpts `json:"ttyInit,omitempty"`
+
+	// start cold even if the warm pool is enabled (see SetWarmPool)
+	NoWarmPool bool `json:"noWarmPool,omitempty"`
+
+	// identifies the session in logs, events, the registry (GetBySessionID) and the scratch dir name, and is
+	// exported to the shell as WAVETERM_SESSION_ID.  minted by the Start* functions if empty, a caller supplied
+	// id must pass ValidateSessionId and not be in use
+	SessionID string `json:"sessionId,omitempty"`
+
+	// which escape sequences are stripped from the output (SanitizeProfile_*), defaults to Trusted for local shells
+	// and Standard for remote and wsl shells.  stripped sequences are counted (ShellProc.SanitizeStats), and logged
+	// if LogSanitized is set
+	SanitizeProfile string `json:"sanitizeProfile,omitempty"`
+	LogSanitized    bool   `json:"logSanitized,omitempty"`
+
+	// how Close shuts the shell down: ShutdownSignals (names like "SIGHUP", DefaultShutdownSignals if nil) are sent
+	// in order, each waiting its share of ShutdownTimeout (DefaultGracefulKillWait if zero) for the shell to exit,
+	// then it is killed (SIGKILL).  wsl shells only get KillGraceful
+	ShutdownSignals []string      `json:"shutdownSignals,omitempty"`
+	ShutdownTimeout time.Duration `json:"shutdownTimeout,omitempty"`
+
+	// local shells only, Close also kills (SIGKILL) whatever is left of the shell's process tree once the shell
+	// is gone: its descendants and, on linux, anything else in its session (background jobs, nohup'd commands).
+	// on windows the final kill terminates the shell's job object (see startInPty) instead of just the shell
+	KillTree bool `json:"killTree,omitempty"`
+
+	// local shells only (not on windows), open the pty in packet mode (TIOCPKT) so flow control (^S/^Q) and
+	// tty flushes are reported as EventKind_PtyControl events
+	PacketMode bool `json:"packetMode,omitempty"`
+
+	// local shells on windows only, run the shell in this wsl distribution (wsl.exe -d) instead.  ShellPath is
+	// then a path inside the distro (empty runs the distro user's default shell, as a login shell) and Cwd may
+	// be a windows path (translated, C:\dir is /mnt/c/dir) or a linux one.  Env and our own vars are passed in
+	// through WSLENV.  there is no shell integration (and HistoryScope is ignored)
+	WslDistro string `json:"wslDistro,omitempty"`
+
+	// local shells only, run the shell in this (running) container with "docker exec -it" (or podman, ContainerTool
+	// is a name or a path, docker by default).  ShellPath is then a path in the container (the container's /bin/sh
+	// by default) and Cwd too (empty uses the container's workdir).  Env and our own vars are passed with "-e".
+	// there is no shell integration, ExitStatus.ContainerFailed is set if the exec itself failed
+	Container     string `json:"container,omitempty"`
+	ContainerTool string `json:"containerTool,omitempty"`
+
+	// local shells only, run the shell in a kubernetes pod with "kubectl exec -i -t" (see PodTarget).  like
+	// Container, ShellPath and Cwd are paths in the pod's container (its /bin/sh by default).  Env and our own
+	// vars are passed through env(1), so they are on the command line.  there is no shell integration
+	Pod *PodTarget `json:"pod,omitempty"`
+
+	// wall-clock limit on the shell (zero for none), counted from the start.  once it is reached the shell is
+	// closed (see ShutdownSignals), Wait then returns a *CommandTimeoutError (errors.Is ErrCommandTimeout) and
+	// ExitStatus.TimedOut is set
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// relaunch the command (local shells only, cmdStr or the StartArgvProc argv must be set) in the same pty when it exits, see SupervisorOpts
+	Supervise *SupervisorOpts `json:"supervise,omitempty"`
+}
+
+// WithShellOption returns cmdOpts set up to run opt (see shellutil.DiscoverWindowsShells), vars already in
+// cmdOpts.Env win over the ones opt needs
+func WithShellOption(cmdOpts CommandOptsType, opt shellutil.ShellOption) CommandOptsType {
+	cmdOpts.ShellPath = opt.ShellPath
+	if len(opt.Env) > 0 {
+		env := maps.Clone(opt.Env)
+		maps.Copy(env, cmdOpts.Env)
+		cmdOpts.Env = env
+	}
+	return cmdOpts
+}
@@ -0,0 +1,142 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultOutputFlushSize  = 16 * 1024
+	DefaultOutputFlushDelay = 5 * time.Millisecond
+	MaxCoalesceBufferSize   = 256 * 1024 // writers block when the reader falls this far behind
+)
+
+// coalesceBuffer sits between the pty read loop and the reader of the
+// ShellProc.  Small pty reads are accumulated and only released to the reader
+// once FlushSize bytes are buffered or FlushDelay has passed since the first
+// unread byte arrived (whichever comes first).  Once the stream ends everything
+// is released immediately.
+type coalesceBuffer struct {
+	CVar       *sync.Cond
+	DataBuf    *bytes.Buffer
+	FlushSize  int
+	FlushDelay time.Duration // <= 0 disables coalescing
+	FlushReady bool
+	Clock      clock
+	StopTimer  func() bool // set while the flush timer is running
+	Err        error       // set when the stream ends (io.EOF or a read error)
+	Discard    error       // set by discard, Read returns it and nothing is buffered any more
+}
+
+func makeCoalesceBuffer(clk clock, flushSize int, flushDelay time.Duration) *coalesceBuffer {
+	if flushSize <= 0 {
+		flushSize = DefaultOutputFlushSize
+	}
+	return &coalesceBuffer{
+		CVar:       sync.NewCond(&sync.Mutex{}),
+		DataBuf:    &bytes.Buffer{},
+		FlushSize:  flushSize,
+		FlushDelay: flushDelay,
+		Clock:      clk,
+	}
+}
+
+// must hold CVar.L
+func (cb *coalesceBuffer) stopTimer() {
+	if cb.StopTimer != nil {
+		cb.StopTimer()
+		cb.StopTimer = nil
+	}
+}
+
+func (cb *coalesceBuffer) onTimer() {
+	cb.CVar.L.Lock()
+	defer cb.CVar.L.Unlock()
+	cb.StopTimer = nil
+	if cb.DataBuf.Len() > 0 {
+		cb.FlushReady = true
+		cb.CVar.Broadcast()
+	}
+}
+
+func (cb *coalesceBuffer) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	cb.CVar.L.Lock()
+	defer cb.CVar.L.Unlock()
+	if cb.Discard != nil {
+		return len(data), nil
+	}
+	// only wait if buffer is currently over max size, otherwise allow this append to go through
+	for cb.DataBuf.Len() > MaxCoalesceBufferSize && cb.Err == nil && cb.Discard == nil {
+		cb.CVar.Wait()
+	}
+	cb.DataBuf.Write(data)
+	if cb.FlushDelay <= 0 || cb.DataBuf.Len() >= cb.FlushSize {
+		cb.FlushReady = true
+		cb.stopTimer()
+		cb.CVar.Broadcast()
+		return len(data), nil
+	}
+	if !cb.FlushReady && cb.StopTimer == nil {
+		cb.StopTimer = cb.Clock.AfterFunc(cb.FlushDelay, cb.onTimer)
+	}
+	return len(data), nil
+}
+
+// setErr marks the end of the stream, all buffered data is released immediately
+func (cb *coalesceBuffer) setErr(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	cb.CVar.L.Lock()
+	defer cb.CVar.L.Unlock()
+	if cb.Err == nil {
+		cb.Err = err
+	}
+	cb.FlushReady = true
+	cb.stopTimer()
+	cb.CVar.Broadcast()
+}
+
+// discard drops the buffered data and everything written from now on (the reader is gone), so
+// the writer never blocks.  pending and later Reads return err
+func (cb *coalesceBuffer) discard(err error) {
+	cb.CVar.L.Lock()
+	defer cb.CVar.L.Unlock()
+	if cb.Discard == nil {
+		cb.Discard = err
+	}
+	cb.DataBuf.Reset()
+	cb.stopTimer()
+	cb.CVar.Broadcast()
+}
+
+func (cb *coalesceBuffer) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	cb.CVar.L.Lock()
+	defer cb.CVar.L.Unlock()
+	for !(cb.FlushReady && cb.DataBuf.Len() > 0) {
+		if cb.Discard != nil {
+			return 0, cb.Discard
+		}
+		if cb.DataBuf.Len() == 0 && cb.Err != nil {
+			return 0, cb.Err
+		}
+		cb.CVar.Wait()
+	}
+	n, _ := cb.DataBuf.Read(p)
+	if cb.DataBuf.Len() == 0 && cb.Err == nil {
+		cb.FlushReady = false
+	}
+	cb.CVar.Broadcast()
+	return n, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/util/testutil"
+)
+
+func readAsync(r io.Reader, size int) chan []byte {
+	rtnCh := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, size)
+		nr, _ := r.Read(buf)
+		rtnCh <- buf[:nr]
+	}()
+	return rtnCh
+}
+
+func TestCoalesceSizeThreshold(t *testing.T) {
+	cb := makeCoalesceBuffer(realClock{}, 10, time.Hour)
+	cb.Write([]byte("12345"))
+	readCh := readAsync(cb, 100)
+	select {
+	case data := <-readCh:
+		t.Fatalf("read returned before flush threshold: %q", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cb.Write([]byte("67890"))
+	select {
+	case data := <-readCh:
+		if string(data) != "1234567890" {
+			t.Fatalf("bad data: %q", data)
+		}
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("read did not return after size threshold")
+	}
+}
+
+func TestCoalesceDelayThreshold(t *testing.T) {
+	fc := testutil.NewFakeClock()
+	cb := makeCoalesceBuffer(fc, 1024, 20*time.Millisecond)
+	cb.Write([]byte("a"))
+	cb.Write([]byte("b"))
+	if pending := fc.PendingDurations(); len(pending) != 1 || pending[0] != 20*time.Millisecond {
+		t.Fatalf("expected a single 20ms flush timer, got %v", pending)
+	}
+	readCh := readAsync(cb, 100)
+	fc.Advance(19 * time.Millisecond)
+	select {
+	case data := <-readCh:
+		t.Fatalf("read returned before flush delay: %q", data)
+	case <-time.After(20 * time.Millisecond):
+	}
+	fc.Advance(time.Millisecond)
+	select {
+	case data := <-readCh:
+		if string(data) != "ab" {
+			t.Fatalf("bad data: %q", data)
+		}
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("read did not return after the flush delay")
+	}
+	if fc.Pending() != 0 {
+		t.Errorf("flush timer still pending after it fired")
+	}
+}
+
+func TestCoalesceFlushOnExit(t *testing.T) {
+	cb := makeCoalesceBuffer(realClock{}, 1024, time.Hour)
+	cb.Write([]byte("final output"))
+	cb.setErr(io.EOF)
+	data, err := io.ReadAll(cb)
+	if err != nil || string(data) != "final output" {
+		t.Fatalf("bad final read: %q %v", data, err)
+	}
+}
+
+// chunkReader returns one chunk per Read call (like a pty delivering a line at a time).
+// if PauseEvery is set, it sleeps briefly every PauseEvery chunks to simulate a producer
+// that is slower than the consumer.
+type chunkReader struct {
+	Chunks     [][]byte
+	PauseEvery int
+	NumReads   int
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if len(cr.Chunks) == 0 {
+		return 0, io.EOF
+	}
+	cr.NumReads++
+	if cr.PauseEvery > 0 && cr.NumReads%cr.PauseEvery == 0 {
+		time.Sleep(20 * time.Microsecond)
+	}
+	n := copy(p, cr.Chunks[0])
+	cr.Chunks = cr.Chunks[1:]
+	return n, nil
+}
+
+func makeFindOutput(numLines int) [][]byte {
+	var rtn [][]byte
+	for idx := 0; idx < numLines; idx++ {
+		rtn = append(rtn, []byte(fmt.Sprintf("/usr/share/doc/package-%d/changelog.Debian.gz\r\n", idx)))
+	}
+	return rtn
+}
+
+func benchmarkOutputLoop(b *testing.B, flushDelay time.Duration) {
+	var totalMsgs int
+	for i := 0; i < b.N; i++ {
+		cb := makeCoalesceBuffer(realClock{}, DefaultOutputFlushSize, flushDelay)
+		go runOutputLoop(&chunkReader{Chunks: makeFindOutput(2000), PauseEvery: 10}, makeOutputPipeline(realClock{}, makeOutputHandler(makeEventHub()), cb, cb), cb.setErr)
+		buf := make([]byte, 64*1024)
+		for {
+			_, err := cb.Read(buf)
+			if err != nil {
+				break
+			}
+			totalMsgs++
+		}
+	}
+	b.ReportMetric(float64(totalMsgs)/float64(b.N), "msgs/op")
+}
+
+// compare msgs/op to see the reduction in downstream messages for a `find /` style workload
+func BenchmarkOutputNoCoalesce(b *testing.B) {
+	benchmarkOutputLoop(b, -1)
+}
+
+func BenchmarkOutputCoalesce(b *testing.B) {
+	benchmarkOutputLoop(b, DefaultOutputFlushDelay)
+}
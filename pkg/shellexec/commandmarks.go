@@ -0,0 +1,161 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OSC 133 (FinalTerm) shell integration marks: A the prompt starts, B the command line starts
+// (the prompt ends), C the command's output starts, D;exitcode the command ended.  our bash and
+// zsh integration also send E;commandline (escaped, see unescapeCommandLine) before C
+const oscCode_CommandMark = "133"
+
+// CommandMark is set on EventKind_PromptStart, EventKind_CommandStart and EventKind_CommandEnd events.
+// Offsets are output stream offsets (see OutputChunk.Offset), the command line the user typed is
+// between InputOffset and OutputOffset (as echoed, so it can include line editing sequences)
+type CommandMark struct {
+	PromptOffset int64 `json:"promptoffset"`        // where the prompt starts (A), -1 if the shell didn't mark it
+	InputOffset  int64 `json:"inputoffset"`         // where the command line starts (B), -1 if the shell didn't mark it
+	OutputOffset int64 `json:"outputoffset"`        // where the command's output starts (right after C)
+	EndOffset    int64 `json:"endoffset,omitempty"` // CommandEnd only, where the command's output ends (D)
+	ExitCode     *int  `json:"exitcode,omitempty"`  // CommandEnd only, if the shell reported it
+
+	CommandLine string `json:"commandline,omitempty"` // the command line from an E mark, if the shell sent one
+}
+
+// CommandResult is set on EventKind_CommandEnd events (see ShellProc.LastCommandResult).  The times
+// are when the marks were seen in the output, so the duration includes the time to print the output
+type CommandResult struct {
+	StartTs    int64  `json:"startts"` // unix millis
+	EndTs      int64  `json:"endts"`   // unix millis
+	DurationMs int64  `json:"durationms"`
+	ExitCode   *int   `json:"exitcode,omitempty"` // nil if the shell didn't report it
+	Cwd        string `json:"cwd,omitempty"`      // the last cwd the shell reported (OSC 7) before the command started
+}
+
+// commandMarkTracker turns the marks into events (used by the output handler, under its lock).
+// shells send D at every prompt, a D with no C since the last one is ignored (nothing ran, e.g.
+// an empty command line)
+type commandMarkTracker struct {
+	Clock      clock
+	Events     *eventHub
+	Cur        CommandMark
+	SawMarks   bool // any OSC 133 mark was seen
+	InCommand  bool // C was seen and D hasn't been
+	StartTime  time.Time
+	StartCwd   string
+	LastResult *CommandResult
+}
+
+func makeCommandMarkTracker(events *eventHub) *commandMarkTracker {
+	return &commandMarkTracker{Clock: realClock{}, Events: events, Cur: CommandMark{PromptOffset: -1, InputOffset: -1}}
+}
+
+// offset is where the marker starts, markLen is its length, cwd is the shell's current cwd.  extra
+// fields (e.g. "aid=..." or "cl=m") are ignored
+func (ct *commandMarkTracker) handleMark(arg string, offset int64, markLen int, cwd string) {
+	fields := strings.Split(arg, ";")
+	ct.SawMarks = true
+	switch fields[0] {
+	case "A":
+		if ct.InCommand {
+			// the command ended without a D (e.g. the shell only marks prompts)
+			ct.endCommand(offset, nil)
+		}
+		ct.Cur = CommandMark{PromptOffset: offset, InputOffset: -1}
+		mark := ct.Cur
+		ct.Events.publish(ShellEvent{Kind: EventKind_PromptStart, Command: &mark})
+	case "B":
+		ct.Cur.InputOffset = offset + int64(markLen)
+	case "E":
+		if len(fields) > 1 {
+			ct.Cur.CommandLine = unescapeCommandLine(fields[1])
+		}
+	case "C":
+		if ct.InCommand {
+			ct.endCommand(offset, nil)
+		}
+		ct.Cur.OutputOffset = offset + int64(markLen)
+		ct.InCommand = true
+		ct.StartTime = ct.Clock.Now()
+		ct.StartCwd = cwd
+		mark := ct.Cur
+		ct.Events.publish(ShellEvent{Kind: EventKind_CommandStart, Command: &mark})
+	case "D":
+		if !ct.InCommand {
+			return
+		}
+		var exitCode *int
+		if len(fields) > 1 {
+			if code, err := strconv.Atoi(fields[1]); err == nil {
+				exitCode = &code
+			}
+		}
+		ct.endCommand(offset, exitCode)
+	}
+}
+
+// the E mark's command line has \ as \\ and ; and control characters as \xNN (like vscode's 633;E).
+// anything else after a \ is kept as is
+func unescapeCommandLine(escaped string) string {
+	if !strings.Contains(escaped, `\`) {
+		return escaped
+	}
+	var buf strings.Builder
+	for idx := 0; idx < len(escaped); idx++ {
+		ch := escaped[idx]
+		if ch != '\\' || idx+1 >= len(escaped) {
+			buf.WriteByte(ch)
+			continue
+		}
+		if escaped[idx+1] == '\\' {
+			buf.WriteByte('\\')
+			idx++
+			continue
+		}
+		if escaped[idx+1] == 'x' && idx+3 < len(escaped) {
+			if val, err := strconv.ParseUint(escaped[idx+2:idx+4], 16, 8); err == nil {
+				buf.WriteByte(byte(val))
+				idx += 3
+				continue
+			}
+		}
+		buf.WriteByte(ch)
+	}
+	return buf.String()
+}
+
+func (ct *commandMarkTracker) endCommand(offset int64, exitCode *int) {
+	endTime := ct.Clock.Now()
+	mark := ct.Cur
+	mark.EndOffset = offset
+	mark.ExitCode = exitCode
+	result := &CommandResult{
+		StartTs:    ct.StartTime.UnixMilli(),
+		EndTs:      endTime.UnixMilli(),
+		DurationMs: endTime.Sub(ct.StartTime).Milliseconds(),
+		ExitCode:   exitCode,
+		Cwd:        ct.StartCwd,
+	}
+	ct.InCommand = false
+	ct.Cur = CommandMark{PromptOffset: -1, InputOffset: -1}
+	ct.LastResult = result
+	resultCopy := *result
+	ct.Events.publish(ShellEvent{Kind: EventKind_CommandEnd, Command: &mark, CommandResult: &resultCopy})
+}
+
+// LastCommandResult returns the result of the last command the shell marked as finished (OSC 133;D,
+// see EventKind_CommandEnd), nil if none has finished
+func (sp *ShellProc) LastCommandResult() *CommandResult {
+	sp.output.Lock.Lock()
+	defer sp.output.Lock.Unlock()
+	if sp.output.Commands.LastResult == nil {
+		return nil
+	}
+	rtn := *sp.output.Commands.LastResult
+	return &rtn
+}
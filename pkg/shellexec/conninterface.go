@@ -43,6 +43,17 @@ func MakeCmdWrap(cmd *exec.Cmd, cmdPty pty.Pty) CmdWrap {
 	}
 }
 
+// returns the CmdWrap of a local shell (the current run of a supervised command)
+func localCmdWrap(cmd ConnInterface) (CmdWrap, bool) {
+	switch cmd := cmd.(type) {
+	case CmdWrap:
+		return cmd, true
+	case *supervisedCmd:
+		return cmd.currentRun(), true
+	}
+	return CmdWrap{}, false
+}
+
 func (cw CmdWrap) Kill() {
 	cw.Cmd.Process.Kill()
 }
@@ -119,17 +130,22 @@ type SessionWrap struct {
 	Session  *ssh.Session
 	StartCmd string
 	Tty      pty.Tty
-	WaitOnce *sync.Once
-	WaitErr  error
+	wait     *sessionWait // shared by the copies (SessionWrap is passed by value)
 	pty.Pty
 }
 
+type sessionWait struct {
+	Once   *sync.Once
+	DoneCh chan struct{} // closed once the session's Wait has returned
+	Err    error         // an *ssh.ExitError carries the remote exit status
+}
+
 func MakeSessionWrap(session *ssh.Session, startCmd string, sessionPty pty.Pty) SessionWrap {
 	return SessionWrap{
 		Session:  session,
 		StartCmd: startCmd,
 		Tty:      sessionPty,
-		WaitOnce: &sync.Once{},
+		wait:     &sessionWait{Once: &sync.Once{}, DoneCh: make(chan struct{})},
 		Pty:      sessionPty,
 	}
 }
@@ -143,19 +159,22 @@ func (sw SessionWrap) KillGraceful(timeout time.Duration) {
 	sw.Kill()
 }
 
+// -1 until Wait has returned
 func (sw SessionWrap) ExitCode() int {
-	waitErr := sw.WaitErr
-	if waitErr == nil {
+	select {
+	case <-sw.wait.DoneCh:
+		return ExitCodeFromWaitErr(sw.wait.Err)
+	default:
 		return -1
 	}
-	return ExitCodeFromWaitErr(waitErr)
 }
 
 func (sw SessionWrap) Wait() error {
-	sw.WaitOnce.Do(func() {
-		sw.WaitErr = sw.Session.Wait()
+	sw.wait.Once.Do(func() {
+		sw.wait.Err = sw.Session.Wait()
+		close(sw.wait.DoneCh)
 	})
-	return sw.WaitErr
+	return sw.wait.Err
 }
 
 func (sw SessionWrap) Start() error {
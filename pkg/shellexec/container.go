@@ -0,0 +1,93 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+)
+
+const (
+	ContainerTool_Docker = "docker"
+	ContainerTool_Podman = "podman"
+)
+
+// docker (and podman) exec exit with 125 when the exec itself failed (no such container, it isn't running,
+// the daemon returned an error).  126 and 127 (can't run or can't find the shell) are the shell's codes
+const containerExecFailedExitCode = 125
+
+const containerDefaultShell = "/bin/sh"
+
+// container names and ids
+var containerNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// host paths (meaningless in a container or pod) and PATH (they have their own)
+var hostOnlyVarNames = map[string]bool{
+	"PATH":                true,
+	"WAVETERM":            true,
+	"WAVETERM_WSHBINDIR":  true,
+	WaveScratchDirVarName: true,
+}
+
+// resolves cmdOpts.ContainerTool (a name or a path) to its path
+func resolveContainer(cmdOpts CommandOptsType) (string, error) {
+	if cmdOpts.Container == "" {
+		if cmdOpts.ContainerTool != "" {
+			return "", fmt.Errorf("ContainerTool requires Container")
+		}
+		return "", nil
+	}
+	if !containerNameRe.MatchString(cmdOpts.Container) {
+		return "", fmt.Errorf("invalid container name %q", cmdOpts.Container)
+	}
+	if cmdOpts.WslDistro != "" || cmdOpts.Elevate || cmdOpts.Uid != nil || cmdOpts.Supervise != nil {
+		return "", fmt.Errorf("Container cannot be combined with WslDistro, Elevate, Uid or Supervise")
+	}
+	tool := cmdOpts.ContainerTool
+	if tool == "" {
+		tool = ContainerTool_Docker
+	}
+	kind := strings.TrimSuffix(filepath.Base(tool), ".exe")
+	if kind != ContainerTool_Docker && kind != ContainerTool_Podman {
+		return "", fmt.Errorf("unsupported container tool %q (only docker and podman are supported)", tool)
+	}
+	toolPath, err := exec.LookPath(tool)
+	if err != nil {
+		return "", fmt.Errorf("cannot run shell in container %q: %w", cmdOpts.Container, err)
+	}
+	return toolPath, nil
+}
+
+// containerExecCmd runs "docker exec -it" (the tool forwards pty resizes to the container and exits
+// with the shell's code, see containerExecFailedExitCode).  without a ShellPath the container's
+// /bin/sh is run, Cwd is a path in the container (empty uses the container's workdir).  the env
+// is added by addContainerEnvArgs
+func containerExecCmd(toolPath string, cmdOpts CommandOptsType, cmdStr string, argv []string) *exec.Cmd {
+	args := []string{"exec", "-it"}
+	if cmdOpts.Cwd != "" {
+		args = append(args, "-w", cmdOpts.Cwd)
+	}
+	args = append(args, cmdOpts.Container)
+	args = append(args, offHostArgv(cmdOpts, cmdStr, argv, containerDefaultShell)...)
+	return exec.Command(toolPath, args...)
+}
+
+// addContainerEnvArgs passes the vars from our layers (and Env) into the container.  "-e NAME" takes
+// the value from the tool's own env (ecmd.Env), so values like the jwt token are not on the command line
+func addContainerEnvArgs(ecmd *exec.Cmd, envReport shellutil.EnvReport) {
+	var envArgs []string
+	for _, name := range ourEnvNames(ecmd, envReport) {
+		if !hostOnlyVarNames[strings.ToUpper(name)] {
+			envArgs = append(envArgs, "-e", name)
+		}
+	}
+	// right after "exec", before the container name
+	ecmd.Args = slices.Insert(ecmd.Args, 2, envArgs...)
+}
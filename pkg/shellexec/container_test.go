@@ -0,0 +1,107 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+// a stand-in for docker: "exec" prints the vars it was asked to pass, changes to the -w dir
+// and runs the command (the "missing" container fails like docker does)
+const fakeDockerScript = `#!/bin/sh
+[ "$1" = "exec" ] || exit 2
+shift
+while [ "$#" -gt 0 ]; do
+	case "$1" in
+	-it) shift ;;
+	-e) echo "passed $2=$(printenv "$2")"; shift 2 ;;
+	-w) cd "$2" || exit 126; shift 2 ;;
+	*) break ;;
+	esac
+done
+if [ "$1" = "missing" ]; then
+	echo "Error response from daemon: No such container: missing" >&2
+	exit 125
+fi
+shift
+exec "$@"
+`
+
+func makeFakeDocker(t *testing.T) string {
+	t.Helper()
+	toolPath := filepath.Join(t.TempDir(), "docker")
+	if err := os.WriteFile(toolPath, []byte(fakeDockerScript), 0755); err != nil {
+		t.Fatalf("error writing fake docker: %v", err)
+	}
+	return toolPath
+}
+
+func TestContainerExecArgs(t *testing.T) {
+	cmdOpts := CommandOptsType{Container: "box", Cwd: "/app", ShellPath: "/bin/bash", ShellOpts: []string{"--norc"}, Login: true}
+	ecmd := containerExecCmd("/usr/bin/docker", cmdOpts, "make test", nil)
+	expected := []string{"/usr/bin/docker", "exec", "-it", "-w", "/app", "box", "/bin/bash", "--norc", "-l", "-c", "make test"}
+	if !slices.Equal(ecmd.Args, expected) {
+		t.Errorf("expected %q, got %q", expected, ecmd.Args)
+	}
+	ecmd = containerExecCmd("/usr/bin/docker", CommandOptsType{Container: "box"}, "", []string{"top"})
+	if !slices.Equal(ecmd.Args, []string{"/usr/bin/docker", "exec", "-it", "box", "top"}) {
+		t.Errorf("unexpected argv args %q", ecmd.Args)
+	}
+	if _, err := resolveContainer(CommandOptsType{Container: "box", ContainerTool: "/usr/bin/lxc"}); err == nil {
+		t.Errorf("expected an error for an unsupported tool")
+	}
+	if _, err := resolveContainer(CommandOptsType{Container: "-box"}); err == nil {
+		t.Errorf("expected an error for an invalid container name")
+	}
+}
+
+func TestContainerShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake docker is a shell script")
+	}
+	toolPath := makeFakeDocker(t)
+	cwd := t.TempDir()
+	cmdOpts := CommandOptsType{Container: "box", ContainerTool: toolPath, Cwd: cwd, Env: map[string]string{"MY_VAR": "my value"}}
+	sp, err := StartShellProc(waveobj.TermSize{Rows: 24, Cols: 80}, `echo "in $(pwd)"; exit 3`, cmdOpts)
+	if err != nil {
+		t.Fatalf("error starting container shell: %v", err)
+	}
+	t.Cleanup(sp.Close)
+	oc := collectOutput(sp)
+	oc.waitFor(t, "in "+cwd)
+	output := oc.String()
+	if !strings.Contains(output, "passed MY_VAR=my value") || !strings.Contains(output, "passed "+WaveSessionIdVarName+"="+sp.SessionID()) {
+		t.Errorf("expected Env and the session id to be passed, got %q", output)
+	}
+	if strings.Contains(output, "passed PATH=") || strings.Contains(output, "passed "+WaveScratchDirVarName) {
+		t.Errorf("host paths should not be passed, got %q", output)
+	}
+	select {
+	case <-oc.Done:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for the container shell to exit")
+	}
+	if status := waitExitStatus(t, sp); status.ExitCode != 3 || status.ContainerFailed {
+		t.Errorf("expected the shell's exit code, got %+v", status)
+	}
+
+	cmdOpts.Container = "missing"
+	sp, err = StartShellProc(waveobj.TermSize{Rows: 24, Cols: 80}, "true", cmdOpts)
+	if err != nil {
+		t.Fatalf("error starting container shell: %v", err)
+	}
+	t.Cleanup(sp.Close)
+	status := waitExitStatus(t, sp)
+	if status.ExitCode != containerExecFailedExitCode || !status.ContainerFailed || !strings.Contains(ExplainExit(status), "container") {
+		t.Errorf("expected ContainerFailed, got %+v (%s)", status, ExplainExit(status))
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrShellDetached = errors.New("shell is detached")
+var ErrSessionNotFound = errors.New("no such shell session")
+var ErrSessionAttached = errors.New("shell session is already attached")
+
+type AttachOpts struct {
+	ReplayBytes   int // max scrollback bytes replayed (see OutputSubOpts.ReplayBytes), zero replays all of it
+	OutputBufSize int // in chunks (see OutputSubOpts.BufSize), for FlowPolicy_Close only
+
+	// what happens when the consumer falls behind (see OutputSubOpts.FlowPolicy), empty is FlowPolicy_Block:
+	// like Read before the shell was detached, the output waits for the consumer (so a burst of output
+	// doesn't end the attachment).  the watermarks are passed along
+	FlowPolicy string
+	HighWater  int
+	LowWater   int
+}
+
+// Detach lets the shell outlive its consumer (e.g. a frontend that is reloading): the shell and
+// its pty keep running and the output keeps going to the scrollback (and to output subscribers
+// and observers), but Read stops returning it.  From then on Read returns ErrShellDetached (a
+// blocked Read is woken up) and the output is only delivered through subscriptions, so an
+// unread shell can't block on its pty.  Attach resumes streaming, detaching an attached shell
+// again closes the Attach subscription (Err returns ErrShellDetached).  Returns ErrShellExited
+// if the shell is gone
+func (sp *ShellProc) Detach() error {
+	if sp.shellGone() {
+		return ErrShellExited
+	}
+	sp.attachLock.Lock()
+	defer sp.attachLock.Unlock()
+	if sp.detached {
+		return nil
+	}
+	sp.detached = true
+	sp.outputBuf.discard(ErrShellDetached)
+	if sp.attachSub != nil {
+		sp.attachSub.closeWithErr(ErrShellDetached)
+		sp.attachSub = nil
+	}
+	sp.logf("detached\n")
+	return nil
+}
+
+// IsDetached returns true if the shell is detached (see Detach) and not attached again
+func (sp *ShellProc) IsDetached() bool {
+	sp.attachLock.Lock()
+	defer sp.attachLock.Unlock()
+	return sp.detached
+}
+
+// Attach resumes streaming a detached shell (see Detach) to a new consumer, found by its session
+// id (see CommandOptsType.SessionID).  The subscription starts with the replayed scrollback so the
+// consumer doesn't start from a blank terminal, the live output follows with no gap (see
+// SubscribeOutput).  Input and resizes go through the returned ShellProc as usual, and the new
+// consumer takes over waiting for it.  Returns ErrSessionNotFound if there is no such shell (or
+// it has been waited for) and ErrSessionAttached if it isn't detached
+func Attach(sessionId string, opts AttachOpts) (*ShellProc, *OutputSubscription, error) {
+	sp := GetBySessionID(sessionId)
+	if sp == nil {
+		return nil, nil, ErrSessionNotFound
+	}
+	sp.attachLock.Lock()
+	defer sp.attachLock.Unlock()
+	if !sp.detached {
+		return nil, nil, ErrSessionAttached
+	}
+	flowPolicy := opts.FlowPolicy
+	if flowPolicy == "" {
+		flowPolicy = FlowPolicy_Block
+	}
+	sub := sp.SubscribeOutput(OutputSubOpts{
+		BufSize:     opts.OutputBufSize,
+		Replay:      true,
+		ReplayBytes: opts.ReplayBytes,
+		FlowPolicy:  flowPolicy,
+		HighWater:   opts.HighWater,
+		LowWater:    opts.LowWater,
+	})
+	sp.detached = false
+	sp.attachSub = sub
+	sp.logf("attached\n")
+	return sp, sub, nil
+}
+
+// ListDetached returns the session ids of the detached shells
+func ListDetached() []string {
+	var rtn []string
+	for _, sp := range shellRegistry.procs() {
+		if sp.IsDetached() {
+			rtn = append(rtn, sp.sessionId)
+		}
+	}
+	sort.Strings(rtn)
+	return rtn
+}
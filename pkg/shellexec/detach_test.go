@@ -0,0 +1,104 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDetachAttach(t *testing.T) {
+	requireBinary(t, "head")
+	// more output than the read buffer holds is written while detached
+	sp := startTestShellProc(t, `echo "before-detach"; read x; head -c 1000000 /dev/zero | tr '\0' x; echo; echo "after-$x"; read y`, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "before-detach")
+	if _, _, err := Attach(sp.SessionID(), AttachOpts{}); !errors.Is(err, ErrSessionAttached) {
+		t.Errorf("expected ErrSessionAttached, got %v", err)
+	}
+	if err := sp.Detach(); err != nil {
+		t.Fatalf("error detaching: %v", err)
+	}
+	select {
+	case <-oc.Done:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("expected Read to stop once detached")
+	}
+	if _, err := sp.Read(make([]byte, 10)); !errors.Is(err, ErrShellDetached) {
+		t.Errorf("expected ErrShellDetached from Read, got %v", err)
+	}
+	if !sp.IsDetached() || !slices.Contains(ListDetached(), sp.SessionID()) {
+		t.Errorf("expected the shell to be detached")
+	}
+	sp.Write([]byte("detached\n"))
+	attached, sub, err := Attach(sp.SessionID(), AttachOpts{})
+	if err != nil {
+		t.Fatalf("error attaching: %v", err)
+	}
+	if attached != sp || sp.IsDetached() {
+		t.Errorf("expected the same shell, attached")
+	}
+	var output []byte
+	for deadline := time.After(testWaitTimeout); !bytes.Contains(output, []byte("after-detached")); {
+		select {
+		case chunk, ok := <-sub.Ch:
+			if !ok {
+				t.Fatalf("output ended early: %v", sub.Err())
+			}
+			output = append(output, chunk.Data...)
+		case <-deadline:
+			t.Fatalf("timeout waiting for the output after attaching, got %d bytes", len(output))
+		}
+	}
+	if _, _, err := Attach("no-such-session", AttachOpts{}); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+	// detaching again closes the attached consumer's subscription
+	if err := sp.Detach(); err != nil {
+		t.Fatalf("error detaching: %v", err)
+	}
+	for range sub.Ch {
+	}
+	if !errors.Is(sub.Err(), ErrShellDetached) {
+		t.Errorf("expected the subscription to be closed with ErrShellDetached, got %v", sub.Err())
+	}
+}
+
+// the attached consumer falls far behind a burst of output, the output waits for it (nothing is lost)
+func TestAttachOverflow(t *testing.T) {
+	requireBinary(t, "head")
+	const burstSize = 200000
+	sp := startTestShellProc(t, `read x; head -c 200000 /dev/zero | tr '\0' x; echo; echo "done-$x"; read y`, CommandOptsType{})
+	if err := sp.Detach(); err != nil {
+		t.Fatalf("error detaching: %v", err)
+	}
+	_, sub, err := Attach(sp.SessionID(), AttachOpts{OutputBufSize: 1, HighWater: 1024})
+	if err != nil {
+		t.Fatalf("error attaching: %v", err)
+	}
+	sp.Write([]byte("go\n"))
+	// let the shell get ahead before reading anything
+	time.Sleep(100 * time.Millisecond)
+	var output []byte
+	for deadline := time.After(2 * testWaitTimeout); !bytes.Contains(output, []byte("done-go")); {
+		select {
+		case chunk, ok := <-sub.Ch:
+			if !ok {
+				t.Fatalf("attachment ended on overflow: %v", sub.Err())
+			}
+			output = append(output, chunk.Data...)
+		case <-deadline:
+			t.Fatalf("timeout waiting for the burst, got %d bytes", len(output))
+		}
+	}
+	if count := bytes.Count(output, []byte("x")); count != burstSize {
+		t.Errorf("expected %d bytes of the burst, got %d", burstSize, count)
+	}
+	if sub.Dropped() != 0 {
+		t.Errorf("expected nothing to be dropped, got %d bytes", sub.Dropped())
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+const crashOutputSize = 64 * 1024
+
+// numbered 8 byte records (no newlines, so the tty doesn't translate anything), a missing or reordered byte shows up
+func crashOutput() string {
+	var buf strings.Builder
+	for i := 0; buf.Len() < crashOutputSize; i++ {
+		fmt.Fprintf(&buf, "%07d|", i)
+	}
+	return buf.String()
+}
+
+func checkCrashOutput(t *testing.T, what string, output string) {
+	t.Helper()
+	expected := crashOutput()
+	if strings.Contains(output, expected) {
+		return
+	}
+	idx := 0
+	for idx < len(output) && idx < len(expected) && output[idx] == expected[idx] {
+		idx++
+	}
+	t.Errorf("%s is missing output, got %d of %d bytes (first difference at %d)", what, len(output), len(expected), idx)
+}
+
+func TestFinalOutputAfterCrash(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no ptys on windows")
+	}
+	for i := 0; i < 5; i++ {
+		sp := startTestShellProc(t, testHelperCmdStr(t), CommandOptsType{Env: map[string]string{testHelperEnvVar: "crash64k"}})
+		eventCh, unsubFn := sp.SubscribeEvents(0)
+		sub := sp.SubscribeOutput(OutputSubOpts{BufSize: 4096})
+		oc := collectOutput(sp)
+		startWaitLoop(sp)
+		exitEvent := waitEventKind(t, eventCh, EventKind_Exit)
+		unsubFn()
+		if exitEvent.ExitStatus.ExitCode != 1 {
+			t.Errorf("expected exit code 1, got %+v", exitEvent.ExitStatus)
+		}
+		scrollback, _ := sp.scrollback.snapshot()
+		checkCrashOutput(t, "scrollback", string(scrollback))
+		var subOutput strings.Builder
+		for chunk := range sub.Ch {
+			subOutput.Write(chunk.Data)
+		}
+		checkCrashOutput(t, "output subscriber", subOutput.String())
+		<-oc.Done
+		checkCrashOutput(t, "Read", oc.String())
+		// what the block controller does once the output is done
+		sp.Close()
+		waitDone(t, sp)
+	}
+}
+
+func TestRunSimpleCmdInPtyAfterCrash(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no ptys on windows")
+	}
+	exePath, err := os.Executable()
+	if err != nil {
+		t.Fatalf("cannot get test executable: %v", err)
+	}
+	// RunSimpleCmdInPty builds the env from ours
+	t.Setenv(testHelperEnvVar, "crash64k")
+	output, err := RunSimpleCmdInPty(exec.Command(exePath), waveobj.TermSize{Rows: 24, Cols: 80})
+	if ExitCodeFromWaitErr(err) != 1 {
+		t.Errorf("expected exit code 1, got err %v", err)
+	}
+	checkCrashOutput(t, "RunSimpleCmdInPty", string(output))
+}
@@ -0,0 +1,236 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+	"github.com/wavetermdev/waveterm/pkg/wavebase"
+)
+
+const (
+	ElevateTool_Sudo = "sudo"
+	ElevateTool_Doas = "doas"
+)
+
+const (
+	ElevateReason_NotFound     = "notfound"     // the tool isn't installed (or isn't sudo/doas)
+	ElevateReason_NotAllowed   = "notallowed"   // the policy doesn't let this user run a root shell
+	ElevateReason_NeedsAskpass = "needsaskpass" // a password is required but nobody is there to type it
+	ElevateReason_Unsupported  = "unsupported"  // windows
+)
+
+const elevateCheckTimeout = 5 * time.Second
+const elevateEnvDir = "elevate"
+const sudoAskpassVarName = "SUDO_ASKPASS"
+
+// sources our env file (removing it marks the shell as started) and execs the shell.
+// if the file is still there when the shell exits, the tool never got as far as running us.
+const elevateInnerScript = `set -a; . "$0"; set +a; rm -f "$0"; exec "$@"`
+
+// ElevateError is returned by StartShellProc when an Elevate shell can't be started
+type ElevateError struct {
+	Tool   string
+	Reason string // ElevateReason_*
+	Msg    string
+}
+
+func (e *ElevateError) Error() string {
+	return fmt.Sprintf("cannot start elevated shell with %s: %s", e.Tool, e.Msg)
+}
+
+// elevateTarget is set on shellprocs started with Elevate
+type elevateTarget struct {
+	Tool    string // ElevateTool_*
+	EnvFile string
+	Askpass *askpassBridge // set for ElevateAskpass
+}
+
+// true if the tool exited without ever running the shell (auth failed, not allowed, ctrl-c at the password prompt)
+func (et *elevateTarget) toolFailed() bool {
+	_, err := os.Stat(et.EnvFile)
+	return err == nil
+}
+
+func (et *elevateTarget) cleanup() {
+	os.Remove(et.EnvFile)
+	if et.Askpass != nil {
+		et.Askpass.close()
+	}
+}
+
+// replaces name's value in ecmd.Env (or adds it)
+func setCmdEnvVar(ecmd *exec.Cmd, name string, val string) {
+	for idx, envStr := range ecmd.Env {
+		if envName, _, found := strings.Cut(envStr, "="); found && envName == name {
+			ecmd.Env[idx] = name + "=" + val
+			return
+		}
+	}
+	ecmd.Env = append(ecmd.Env, name+"="+val)
+}
+
+func cmdEnvLookup(ecmd *exec.Cmd, name string) (string, bool) {
+	for _, envStr := range ecmd.Env {
+		if envName, val, found := strings.Cut(envStr, "="); found && envName == name {
+			return val, true
+		}
+	}
+	return "", false
+}
+
+// resolves cmdOpts.ElevateTool (a name or a path) to (path, ElevateTool_*)
+func resolveElevateTool(tool string) (string, string, error) {
+	if tool == "" {
+		tool = ElevateTool_Sudo
+	}
+	kind := filepath.Base(tool)
+	if kind != ElevateTool_Sudo && kind != ElevateTool_Doas {
+		return "", kind, &ElevateError{Tool: kind, Reason: ElevateReason_NotFound, Msg: "only sudo and doas are supported"}
+	}
+	toolPath, err := exec.LookPath(tool)
+	if err != nil {
+		return "", kind, &ElevateError{Tool: kind, Reason: ElevateReason_NotFound, Msg: fmt.Sprintf("%s not found", tool)}
+	}
+	return toolPath, kind, nil
+}
+
+// classifyElevateCheck interprets a non-interactive "tool -n true" run.  returns
+// needsPassword (the tool will prompt on the pty), or the reason it can never work.
+func classifyElevateCheck(kind string, output string, runErr error) (bool, *ElevateError) {
+	if runErr == nil {
+		return false, nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(runErr, &exitErr) {
+		return false, &ElevateError{Tool: kind, Reason: ElevateReason_NotFound, Msg: runErr.Error()}
+	}
+	output = strings.TrimSpace(output)
+	lowerOutput := strings.ToLower(output)
+	switch {
+	case strings.Contains(lowerOutput, "password is required"), strings.Contains(lowerOutput, "authorization required"),
+		strings.Contains(lowerOutput, "authentication required"):
+		return true, nil
+	case strings.Contains(lowerOutput, "preserve the environment"):
+		return false, &ElevateError{Tool: kind, Reason: ElevateReason_NotAllowed, Msg: "the sudoers policy does not allow -E (needs SETENV): " + output}
+	}
+	if output == "" {
+		output = fmt.Sprintf("exit code %d", exitErr.ExitCode())
+	}
+	return false, &ElevateError{Tool: kind, Reason: ElevateReason_NotAllowed, Msg: output}
+}
+
+func elevateToolArgs(kind string, useAskpass bool) []string {
+	if kind == ElevateTool_Doas {
+		return []string{"-u", "root", "--"}
+	}
+	args := []string{"-E"}
+	if useAskpass {
+		args = append(args, "-A")
+	}
+	return append(args, "-u", "root", "--")
+}
+
+func checkElevation(toolPath string, kind string) (bool, *ElevateError) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), elevateCheckTimeout)
+	defer cancelFn()
+	args := append([]string{"-n"}, elevateToolArgs(kind, false)...)
+	output, err := exec.CommandContext(ctx, toolPath, append(args, "true")...).CombinedOutput()
+	return classifyElevateCheck(kind, string(output), err)
+}
+
+// writes passEnv to a file only we (and root) can read, values never go on the command line
+func writeElevateEnvFile(passEnv map[string]string) (string, error) {
+	dir := filepath.Join(wavebase.GetWaveDataDir(), elevateEnvDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("error creating elevate dir: %w", err)
+	}
+	names := make([]string, 0, len(passEnv))
+	for name := range passEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s=%s\n", name, shellutil.ShellFamily_PosixSh.Quote(passEnv[name]))
+	}
+	envFile, err := os.CreateTemp(dir, "env-*.sh")
+	if err != nil {
+		return "", fmt.Errorf("error creating elevate env file: %w", err)
+	}
+	defer envFile.Close()
+	if _, err := envFile.WriteString(buf.String()); err != nil {
+		os.Remove(envFile.Name())
+		return "", fmt.Errorf("error writing elevate env file: %w", err)
+	}
+	return envFile.Name(), nil
+}
+
+// elevateShellCmd rewrites ecmd to run the shell as root through sudo (or doas), the
+// tool runs on the pty so its password prompt shows up in the block like any other
+// output.  The environment the elevated shell sees is:
+//   - passEnv (the vars shellexec sets itself, see StartShellProc), always, through an env file
+//   - with sudo, the rest of our env as far as the sudoers policy allows -E (env_delete
+//     and secure_path still apply, HOME is reset unless the policy keeps it)
+//   - with doas, only what doas.conf allows (keepenv/setenv)
+//
+// A password prompt needs someone to answer it, so non-interactive commands get an
+// ElevateReason_NeedsAskpass error unless SUDO_ASKPASS is set (then sudo -A is used).
+// With ElevateAskpass sudo -A always runs our own helper (see askpassBridge).
+func elevateShellCmd(ecmd *exec.Cmd, cmdOpts CommandOptsType, passEnv map[string]string) (*elevateTarget, error) {
+	if runtime.GOOS == "windows" {
+		return nil, &ElevateError{Tool: cmdOpts.ElevateTool, Reason: ElevateReason_Unsupported, Msg: "not supported on windows"}
+	}
+	toolPath, kind, err := resolveElevateTool(cmdOpts.ElevateTool)
+	if err != nil {
+		return nil, err
+	}
+	needsPassword, checkErr := checkElevation(toolPath, kind)
+	if checkErr != nil {
+		return nil, checkErr
+	}
+	useAskpass := false
+	var askpass *askpassBridge
+	if cmdOpts.ElevateAskpass {
+		if kind != ElevateTool_Sudo {
+			return nil, &ElevateError{Tool: kind, Reason: ElevateReason_Unsupported, Msg: "ElevateAskpass needs sudo (doas has no askpass)"}
+		}
+		askpass, err = makeAskpassBridge(kind)
+		if err != nil {
+			return nil, err
+		}
+		setCmdEnvVar(ecmd, sudoAskpassVarName, askpass.ScriptPath)
+		useAskpass = true
+	}
+	_, hasAskpass := cmdEnvLookup(ecmd, sudoAskpassVarName)
+	if needsPassword && !cmdOpts.Interactive && askpass == nil {
+		if kind != ElevateTool_Sudo || !hasAskpass {
+			return nil, &ElevateError{Tool: kind, Reason: ElevateReason_NeedsAskpass, Msg: fmt.Sprintf("a password is required for a non-interactive command (configure NOPASSWD or set %s)", sudoAskpassVarName)}
+		}
+		useAskpass = true
+	}
+	envFile, err := writeElevateEnvFile(passEnv)
+	if err != nil {
+		if askpass != nil {
+			askpass.close()
+		}
+		return nil, err
+	}
+	args := append([]string{kind}, elevateToolArgs(kind, useAskpass)...)
+	args = append(args, trampolineShellPath, "-c", elevateInnerScript, envFile, ecmd.Path)
+	args = append(args, ecmd.Args[1:]...)
+	ecmd.Path = toolPath
+	ecmd.Args = args
+	return &elevateTarget{Tool: kind, EnvFile: envFile, Askpass: askpass}, nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+// set to run the live sudo test (needs a NOPASSWD sudoers entry for the test user, e.g. in a CI container)
+const testSudoEnvVar = "WAVETERM_TEST_SUDO"
+
+const fakeSudoModeEnvVar = "WAVE_FAKE_SUDO_MODE"
+
+// a stand-in for sudo: "-n ... true" is the preflight check, otherwise it runs
+// whatever follows "--" (as us, with the env reset like sudo's env_reset), or fails
+// like a bad password in "deny" mode.  "askpass" mode gets the password (hunter2,
+// two tries) from $SUDO_ASKPASS
+const fakeSudoScript = `#!/bin/sh
+mode="$WAVE_FAKE_SUDO_MODE"
+if [ "$1" = "-n" ]; then
+	if [ "$mode" = "password" ] || [ "$mode" = "askpass" ]; then
+		echo "sudo: a password is required" >&2
+		exit 1
+	fi
+	exit 0
+fi
+if [ "$mode" = "deny" ]; then
+	echo "sudo: 3 incorrect password attempts" >&2
+	exit 1
+fi
+if [ "$mode" = "askpass" ]; then
+	for try in 1 2; do
+		pw=$("$SUDO_ASKPASS" "[sudo] password for test: ") || { echo "sudo: no password was provided" >&2; exit 1; }
+		[ "$pw" = "hunter2" ] && break
+		[ "$try" = 2 ] && { echo "sudo: 2 incorrect password attempts" >&2; exit 1; }
+		echo "Sorry, try again." >&2
+	done
+fi
+while [ "$#" -gt 0 ] && [ "$1" != "--" ]; do
+	shift
+done
+shift
+exec env -i PATH="$PATH" "$@"
+`
+
+func makeFakeSudo(t *testing.T, mode string) string {
+	t.Helper()
+	toolPath := filepath.Join(t.TempDir(), "sudo")
+	if err := os.WriteFile(toolPath, []byte(fakeSudoScript), 0755); err != nil {
+		t.Fatalf("error writing fake sudo: %v", err)
+	}
+	t.Setenv(fakeSudoModeEnvVar, mode)
+	return toolPath
+}
+
+func TestClassifyElevateCheck(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 1").Run()
+	if needsPassword, err := classifyElevateCheck(ElevateTool_Sudo, "", nil); needsPassword || err != nil {
+		t.Errorf("a successful check should need nothing, got %v %v", needsPassword, err)
+	}
+	if needsPassword, err := classifyElevateCheck(ElevateTool_Sudo, "sudo: a password is required\n", exitErr); !needsPassword || err != nil {
+		t.Errorf("expected a password to be needed, got %v %v", needsPassword, err)
+	}
+	if needsPassword, err := classifyElevateCheck(ElevateTool_Doas, "doas: Authorization required\n", exitErr); !needsPassword || err != nil {
+		t.Errorf("expected a password to be needed for doas, got %v %v", needsPassword, err)
+	}
+	_, err := classifyElevateCheck(ElevateTool_Sudo, "Sorry, user mike is not allowed to execute '/usr/bin/true' as root\n", exitErr)
+	if err == nil || err.Reason != ElevateReason_NotAllowed || !strings.Contains(err.Msg, "not allowed") {
+		t.Errorf("expected a notallowed error, got %v", err)
+	}
+	_, err = classifyElevateCheck(ElevateTool_Sudo, "sudo: sorry, you are not allowed to preserve the environment\n", exitErr)
+	if err == nil || err.Reason != ElevateReason_NotAllowed || !strings.Contains(err.Msg, "SETENV") {
+		t.Errorf("expected a notallowed error for -E, got %v", err)
+	}
+}
+
+func TestElevatePassesEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no elevation on windows")
+	}
+	toolPath := makeFakeSudo(t, "nopasswd")
+	secret := `it's a "$secret"`
+	sp := startTestShellProc(t, `echo val=[$WAVE_ELEVATE_TEST]`, CommandOptsType{Elevate: true, ElevateTool: toolPath, Env: map[string]string{"WAVE_ELEVATE_TEST": secret}})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "val=["+secret+"]")
+	status := waitExitStatus(t, sp)
+	if status.ExitCode != 0 || status.ElevateFailed || status.ElevateTool != ElevateTool_Sudo {
+		t.Errorf("unexpected exit status %+v", status)
+	}
+	for _, arg := range sp.Cmd.(CmdWrap).Cmd.Args {
+		if strings.Contains(arg, "$secret") {
+			t.Errorf("env value was passed on the command line: %q", sp.Cmd.(CmdWrap).Cmd.Args)
+		}
+	}
+	if _, err := os.Stat(sp.elevate.EnvFile); !os.IsNotExist(err) {
+		t.Errorf("env file was not removed: %v", err)
+	}
+}
+
+func TestElevateToolFailed(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no elevation on windows")
+	}
+	toolPath := makeFakeSudo(t, "deny")
+	sp := startTestShellProc(t, "echo started", CommandOptsType{Elevate: true, ElevateTool: toolPath, Interactive: true})
+	collectOutput(sp)
+	status := waitExitStatus(t, sp)
+	if !status.ElevateFailed || status.ExitCode != 1 {
+		t.Errorf("expected a failed elevation, got %+v", status)
+	}
+	if explain := ExplainExit(status); explain != "sudo exited with code 1 before starting the shell" {
+		t.Errorf("unexpected explanation %q", explain)
+	}
+}
+
+func TestElevateNeedsAskpass(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no elevation on windows")
+	}
+	toolPath := makeFakeSudo(t, "password")
+	_, err := StartShellProc(waveobj.TermSize{Rows: 24, Cols: 80}, "true", CommandOptsType{Elevate: true, ElevateTool: toolPath, ShellPath: requireBinary(t, "bash")})
+	var elevateErr *ElevateError
+	if !errors.As(err, &elevateErr) || elevateErr.Reason != ElevateReason_NeedsAskpass {
+		t.Fatalf("expected a needsaskpass error, got %v", err)
+	}
+
+	// with an askpass helper sudo -A can get the password
+	sp := startTestShellProc(t, "echo asked", CommandOptsType{Elevate: true, ElevateTool: toolPath, Env: map[string]string{sudoAskpassVarName: "/bin/false"}})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "asked")
+	waitExitStatus(t, sp)
+	if args := sp.Cmd.(CmdWrap).Cmd.Args; args[1] != "-E" || args[2] != "-A" {
+		t.Errorf("expected sudo -E -A, got %q", args)
+	}
+}
+
+// polls, the AuthRequired event may be published before a test could subscribe
+func waitPendingAuth(t *testing.T, sp *ShellProc, attempt int) *AuthRequest {
+	t.Helper()
+	var req *AuthRequest
+	waitUntil(t, fmt.Sprintf("auth request %d", attempt), func() bool {
+		req = sp.PendingAuth()
+		return req != nil && req.Attempt == attempt
+	})
+	return req
+}
+
+func TestElevateAskpass(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no elevation on windows")
+	}
+	toolPath := makeFakeSudo(t, "askpass")
+	sp := startTestShellProc(t, "echo elevated-ok", CommandOptsType{Elevate: true, ElevateTool: toolPath, ElevateAskpass: true})
+	events, unsub := sp.SubscribeEvents(0)
+	defer unsub()
+	oc := collectOutput(sp)
+	req := waitPendingAuth(t, sp, 1)
+	if req.Tool != ElevateTool_Sudo || req.Prompt != "[sudo] password for test: " {
+		t.Errorf("unexpected auth request %+v", req)
+	}
+	if err := sp.RespondAuth("nosuch", "x"); err == nil {
+		t.Errorf("expected an error for an unknown request id")
+	}
+	if err := sp.RespondAuth(req.Id, "wrong"); err != nil {
+		t.Fatalf("error responding: %v", err)
+	}
+	req = waitPendingAuth(t, sp, 2)
+	if err := sp.RespondAuth(req.Id, "hunter2"); err != nil {
+		t.Fatalf("error responding: %v", err)
+	}
+	oc.waitFor(t, "elevated-ok")
+	if !strings.Contains(oc.String(), "Sorry, try again.") {
+		t.Errorf("expected the retry message, got %q", oc.String())
+	}
+	if status := waitExitStatus(t, sp); status.ExitCode != 0 || status.ElevateFailed {
+		t.Errorf("expected the elevated command to run, got %+v", status)
+	}
+	var sawAuth bool
+	for event := range events {
+		if event.Kind == EventKind_AuthRequired && event.AuthRequest.Attempt == 2 {
+			sawAuth = true
+		}
+	}
+	if !sawAuth {
+		t.Errorf("expected an AuthRequired event for the second prompt")
+	}
+	if _, err := os.Stat(sp.elevate.Askpass.Dir); !os.IsNotExist(err) {
+		t.Errorf("expected the askpass dir to be removed, got %v", err)
+	}
+}
+
+func TestElevateAskpassCancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no elevation on windows")
+	}
+	toolPath := makeFakeSudo(t, "askpass")
+	sp := startTestShellProc(t, "echo elevated-ok", CommandOptsType{Elevate: true, ElevateTool: toolPath, ElevateAskpass: true})
+	collectOutput(sp)
+	req := waitPendingAuth(t, sp, 1)
+	if err := sp.CancelAuth(req.Id); err != nil {
+		t.Fatalf("error cancelling: %v", err)
+	}
+	if status := waitExitStatus(t, sp); !status.ElevateFailed {
+		t.Errorf("expected a failed elevation, got %+v", status)
+	}
+}
+
+func TestElevateLiveSudo(t *testing.T) {
+	if os.Getenv(testSudoEnvVar) == "" {
+		t.Skipf("set %s to run against a NOPASSWD sudoers entry", testSudoEnvVar)
+	}
+	requireBinary(t, "sudo")
+	sp := startTestShellProc(t, "echo uid=[$(id -u)] val=[$WAVE_ELEVATE_TEST]", CommandOptsType{Elevate: true, Env: map[string]string{"WAVE_ELEVATE_TEST": "passed"}})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "uid=[0] val=[passed]")
+	if status := waitExitStatus(t, sp); status.ExitCode != 0 || status.ElevateFailed {
+		t.Errorf("unexpected exit status %+v", status)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"testing"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+)
+
+func TestStartShellProcEnvLayers(t *testing.T) {
+	t.Setenv("WAVE_TEST_INHERITED", "inherited")
+	t.Setenv("WAVE_TEST_REMOVED", "inherited")
+	sp := startTestShellProc(t, `echo "term=[$TERM] inh=[$WAVE_TEST_INHERITED] rm=[${WAVE_TEST_REMOVED-unset}] prog=[$TERM_PROGRAM]"`, CommandOptsType{
+		Env: map[string]string{"TERM": "vt100", "WAVE_TEST_REMOVED": ""},
+	})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "term=[vt100] inh=[inherited] rm=[unset] prog=[waveterm]")
+	report := sp.EnvReport()
+	expectedWinners := map[string]string{
+		"TERM":                shellutil.EnvLayer_CmdOpts,
+		"TERM_PROGRAM":        shellutil.EnvLayer_Waveshell,
+		"WAVE_TEST_INHERITED": shellutil.EnvLayer_Inherited,
+		"WAVE_TEST_REMOVED":   "",
+	}
+	for name, layer := range expectedWinners {
+		if got := report.WonBy(name); got != layer {
+			t.Errorf("%s: expected %q to win, got %q", name, layer, got)
+		}
+	}
+	if removedBy := report.Vars["WAVE_TEST_REMOVED"].RemovedBy; removedBy != shellutil.EnvLayer_CmdOpts {
+		t.Errorf("expected cmdopts to remove WAVE_TEST_REMOVED, got %q", removedBy)
+	}
+}
+
+func TestTermType(t *testing.T) {
+	sp := startTestShellProc(t, `echo "term=[$TERM]"`, CommandOptsType{TermType: "tmux-256color"})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "term=[tmux-256color]")
+	if got := sp.EnvReport().WonBy("TERM"); got != shellutil.EnvLayer_Waveshell {
+		t.Errorf("expected TERM from the waveshell layer, got %q", got)
+	}
+	for _, termType := range []string{"xterm 256", "-x", "a=b"} {
+		opts := CommandOptsType{TermType: termType}
+		if err := resolveTermType(&opts); err == nil {
+			t.Errorf("expected an error for term type %q", termType)
+		}
+	}
+	opts := CommandOptsType{}
+	if err := resolveTermType(&opts); err != nil || opts.TermType != shellutil.DefaultTermType {
+		t.Errorf("expected the default term type, got %q %v", opts.TermType, err)
+	}
+}
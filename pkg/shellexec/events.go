@@ -0,0 +1,275 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"sync"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+const DefaultEventBufferSize = 64
+
+const (
+	EventKind_Started       = "started"       // the shell was started (Started is set)
+	EventKind_Link          = "link"          // a new OSC 8 hyperlink was seen in the output
+	EventKind_PromptState   = "promptstate"   // ShellProc.LikelyAtPrompt changed (AtPrompt is set)
+	EventKind_Title         = "title"         // the window title was set (OSC 0 or 2), coalesced
+	EventKind_Cwd           = "cwd"           // the shell reported its cwd (OSC 7), coalesced
+	EventKind_Bell          = "bell"          // a BEL outside of an escape sequence
+	EventKind_PromptStart   = "promptstart"   // the shell marked the start of its prompt (OSC 133;A, Command is set)
+	EventKind_CommandStart  = "commandstart"  // the shell marked the start of a command's output (OSC 133;C, Command is set)
+	EventKind_CommandEnd    = "commandend"    // the shell marked the end of a command (OSC 133;D, Command and CommandResult are set)
+	EventKind_PtyControl    = "ptycontrol"    // flow control or a flush on the pty (PtyControl is set), see CommandOptsType.PacketMode
+	EventKind_Resize        = "resize"        // ShellProc.SetTermSize resized the pty (TermSize is set)
+	EventKind_Observers     = "observers"     // an observer attached or detached (Observers is set), coalesced
+	EventKind_Restart       = "restart"       // a supervised command exited and is being restarted (Restart is set)
+	EventKind_AuthRequired  = "authrequired"  // an ElevateAskpass tool is asking for the password (AuthRequest is set)
+	EventKind_Paste         = "paste"         // a WriteLarge paste wrote a chunk or finished (Paste is set), coalesced
+	EventKind_ShellFallback = "shellfallback" // a fallback shell was started, the shell to run didn't exist (ShellFallback is set)
+	EventKind_Signaled      = "signaled"      // a signal was sent to the shell or one of its processes (Signal is set)
+	EventKind_OutputStalled = "outputstalled" // the output loop is blocked by a FlowPolicy_Block subscriber, or is moving again (OutputStall is set)
+	EventKind_IOStats       = "iostats"       // the pty's byte counts changed (IOStats is set), coalesced, see CommandOptsType.IOStatsInterval
+	EventKind_Exit          = "exit"          // the shell has exited (ExitStatus is set), always the last event
+)
+
+// ShellEvent is a notification from a ShellProc, Kind says which of the other fields are set.
+//
+// Ordering: events are delivered to each subscriber in the order they were published.
+// Events that come from the output (Link, Title, Cwd, Bell and the command marks) are
+// published while that output is processed, before it is written to the scrollback and
+// output subscribers.
+// Exit is published once the output stream has ended (so all of the shell's output
+// is in the scrollback and has been written to the output subscribers), or
+// outputDrainTimeout after the shell was waited for if something else still holds
+// the pty open (see waitOutputDrained).  No events follow Exit, event channels are
+// closed after it.
+// Started and ShellFallback are published while the shell is started (before anyone could
+// subscribe), they are queued first for every subscriber (until Exit).
+type ShellEvent struct {
+	Kind          string            `json:"kind"`
+	Ts            int64             `json:"ts"`
+	SessionId     string            `json:"sessionid"`
+	Dropped       int               `json:"dropped,omitempty"` // events dropped for this subscriber (buffer full) right before this one
+	Started       *StartInfo        `json:"started,omitempty"`
+	Link          *LinkRecord       `json:"link,omitempty"`
+	AtPrompt      *bool             `json:"atprompt,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Cwd           string            `json:"cwd,omitempty"`
+	Command       *CommandMark      `json:"command,omitempty"`
+	CommandResult *CommandResult    `json:"commandresult,omitempty"`
+	PtyControl    *PtyControl       `json:"ptycontrol,omitempty"`
+	TermSize      *waveobj.TermSize `json:"termsize,omitempty"`
+	Observers     *int              `json:"observers,omitempty"` // the number of attached observers
+	Restart       *RestartInfo      `json:"restart,omitempty"`
+	AuthRequest   *AuthRequest      `json:"authrequest,omitempty"`
+	Paste         *PasteStatus      `json:"paste,omitempty"`
+	ShellFallback *ShellFallback    `json:"shellfallback,omitempty"`
+	Signal        *SignalInfo       `json:"signal,omitempty"`
+	OutputStall   *OutputStall      `json:"outputstall,omitempty"`
+	IOStats       *PtyIOStats       `json:"iostats,omitempty"`
+	ExitStatus    *ExitStatus       `json:"exitstatus,omitempty"`
+}
+
+// only the newest queued event of these kinds is kept
+func isCoalescedEvent(kind string) bool {
+	return kind == EventKind_Title || kind == EventKind_Cwd || kind == EventKind_Observers || kind == EventKind_Paste || kind == EventKind_IOStats
+}
+
+// published before StartShellProc returns, the hub keeps them for later subscribers
+func isStartupEvent(kind string) bool {
+	return kind == EventKind_Started || kind == EventKind_ShellFallback
+}
+
+// eventSub is one subscriber, events are queued (up to Max) and handed to OutCh by its own goroutine
+type eventSub struct {
+	Lock    *sync.Mutex
+	Max     int
+	Queue   []ShellEvent
+	Dropped int // since the last queued event
+	WakeCh  chan struct{}
+	StopCh  chan struct{}
+	OutCh   chan ShellEvent
+}
+
+// Drop policy when the queue is full: the new event is dropped, except that Exit is
+// always queued, and the coalesced kinds (Title, Cwd, Observers, Paste, IOStats, which replace a queued
+// event of their kind anyway) drop the oldest queued event instead so the newest
+// value always gets through.
+func (sub *eventSub) push(event ShellEvent) {
+	sub.Lock.Lock()
+	defer sub.Lock.Unlock()
+	if isCoalescedEvent(event.Kind) {
+		for idx, queued := range sub.Queue {
+			if queued.Kind == event.Kind {
+				event.Dropped += queued.Dropped
+				sub.Queue = append(sub.Queue[:idx], sub.Queue[idx+1:]...)
+				break
+			}
+		}
+	}
+	if len(sub.Queue) >= sub.Max {
+		switch {
+		case event.Kind == EventKind_Exit:
+		case isCoalescedEvent(event.Kind):
+			sub.Dropped += 1 + sub.Queue[0].Dropped
+			sub.Queue = sub.Queue[1:]
+		default:
+			sub.Dropped++
+			return
+		}
+	}
+	event.Dropped += sub.Dropped
+	sub.Dropped = 0
+	sub.Queue = append(sub.Queue, event)
+	select {
+	case sub.WakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *eventSub) pop() (ShellEvent, bool) {
+	sub.Lock.Lock()
+	defer sub.Lock.Unlock()
+	if len(sub.Queue) == 0 {
+		return ShellEvent{}, false
+	}
+	event := sub.Queue[0]
+	sub.Queue = sub.Queue[1:]
+	return event, true
+}
+
+// closes OutCh after Exit has been delivered, or when unsubscribed
+func (sub *eventSub) run() {
+	defer close(sub.OutCh)
+	for {
+		event, ok := sub.pop()
+		if !ok {
+			select {
+			case <-sub.WakeCh:
+				continue
+			case <-sub.StopCh:
+				return
+			}
+		}
+		select {
+		case sub.OutCh <- event:
+		case <-sub.StopCh:
+			return
+		}
+		if event.Kind == EventKind_Exit {
+			return
+		}
+	}
+}
+
+type eventHub struct {
+	Clock     clock // for the events' Ts
+	Lock      *sync.Mutex
+	NextId    int
+	Subs      map[int]*eventSub
+	ExitEvent *ShellEvent  // set once Exit has been published
+	Startup   []ShellEvent // see isStartupEvent, queued for each new subscriber
+	Default   <-chan ShellEvent
+	SessionId string // set on every event
+}
+
+func makeEventHub() *eventHub {
+	return &eventHub{Clock: realClock{}, Lock: &sync.Mutex{}, Subs: make(map[int]*eventSub)}
+}
+
+func (h *eventHub) subscribe(bufSize int) (<-chan ShellEvent, func()) {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	return h.subscribeLocked(bufSize)
+}
+
+func (h *eventHub) subscribeLocked(bufSize int) (<-chan ShellEvent, func()) {
+	if bufSize <= 0 {
+		bufSize = DefaultEventBufferSize
+	}
+	sub := &eventSub{
+		Lock:   &sync.Mutex{},
+		Max:    bufSize,
+		WakeCh: make(chan struct{}, 1),
+		StopCh: make(chan struct{}),
+		OutCh:  make(chan ShellEvent),
+	}
+	id := h.NextId
+	h.NextId++
+	if h.ExitEvent != nil {
+		// subscribed after the exit, only gets the Exit event
+		sub.push(*h.ExitEvent)
+	} else {
+		for _, event := range h.Startup {
+			sub.push(event)
+		}
+		h.Subs[id] = sub
+	}
+	go func() {
+		defer panichandler.PanicHandler("ShellProc:eventSub")
+		sub.run()
+	}()
+	stopOnce := &sync.Once{}
+	unsubFn := func() {
+		h.Lock.Lock()
+		delete(h.Subs, id)
+		h.Lock.Unlock()
+		stopOnce.Do(func() { close(sub.StopCh) })
+	}
+	return sub.OutCh, unsubFn
+}
+
+// publish never blocks, see eventSub.push for what happens when a subscriber falls behind.
+// nothing is published after Exit.
+func (h *eventHub) publish(event ShellEvent) {
+	if event.Ts == 0 {
+		event.Ts = h.Clock.Now().UnixMilli()
+	}
+	event.SessionId = h.SessionId
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	if h.ExitEvent != nil {
+		return
+	}
+	if event.Kind == EventKind_Exit {
+		h.ExitEvent = &event
+	}
+	if isStartupEvent(event.Kind) {
+		h.Startup = append(h.Startup, event)
+	}
+	for id, sub := range h.Subs {
+		sub.push(event)
+		if event.Kind == EventKind_Exit {
+			// the subscriber's goroutine closes its channel after delivering it
+			delete(h.Subs, id)
+		}
+	}
+}
+
+// the shared subscription returned by ShellProc.Events
+func (h *eventHub) defaultSub() <-chan ShellEvent {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	if h.Default == nil {
+		h.Default, _ = h.subscribeLocked(DefaultEventBufferSize)
+	}
+	return h.Default
+}
+
+// SubscribeEvents returns a channel of events for this shellproc and a function
+// to unsubscribe (which closes the channel).  Each subscriber has its own buffer
+// of bufSize events (see eventSub.push for the drop policy), the channel is closed
+// after the Exit event.
+func (sp *ShellProc) SubscribeEvents(bufSize int) (<-chan ShellEvent, func()) {
+	return sp.events.subscribe(bufSize)
+}
+
+// Events returns the shellproc's shared event channel (the same channel on every
+// call, with a DefaultEventBufferSize buffer), it is closed after the Exit event.
+// Use SubscribeEvents for an independent subscription.
+func (sp *ShellProc) Events() <-chan ShellEvent {
+	return sp.events.defaultSub()
+}
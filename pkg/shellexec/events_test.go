@@ -0,0 +1,317 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+// reads until the channel is closed
+func readAllEvents(t *testing.T, eventCh <-chan ShellEvent) []ShellEvent {
+	t.Helper()
+	var rtn []ShellEvent
+	timeoutCh := time.After(testWaitTimeout)
+	for {
+		select {
+		case event, ok := <-eventCh:
+			if !ok {
+				return rtn
+			}
+			rtn = append(rtn, event)
+		case <-timeoutCh:
+			t.Fatalf("timed out waiting for the event channel to close, got %d events", len(rtn))
+			return nil
+		}
+	}
+}
+
+func waitEventKind(t *testing.T, eventCh <-chan ShellEvent, kind string) ShellEvent {
+	t.Helper()
+	timeoutCh := time.After(testWaitTimeout)
+	for {
+		select {
+		case event, ok := <-eventCh:
+			if !ok {
+				t.Fatalf("event channel closed waiting for %s", kind)
+			}
+			if event.Kind == kind {
+				return event
+			}
+		case <-timeoutCh:
+			t.Fatalf("timed out waiting for a %s event", kind)
+		}
+	}
+}
+
+// the wait loop the block controller runs
+func startWaitLoop(sp *ShellProc) {
+	go func() {
+		sp.SetWaitErrorAndSignalDone(sp.Cmd.Wait())
+	}()
+}
+
+func TestEventDropPolicy(t *testing.T) {
+	hub := makeEventHub()
+	eventCh, unsubFn := hub.subscribe(4)
+	defer unsubFn()
+	// nothing is read until everything has been published
+	for i := 0; i < 10; i++ {
+		hub.publish(ShellEvent{Kind: EventKind_Bell})
+	}
+	for _, title := range []string{"t1", "t2", "t3"} {
+		hub.publish(ShellEvent{Kind: EventKind_Title, Title: title})
+	}
+	hub.publish(ShellEvent{Kind: EventKind_Cwd, Cwd: "/c1"})
+	hub.publish(ShellEvent{Kind: EventKind_Cwd, Cwd: "/c2"})
+	hub.publish(ShellEvent{Kind: EventKind_Exit, ExitStatus: &ExitStatus{ExitCode: 1}})
+	hub.publish(ShellEvent{Kind: EventKind_Bell}) // after Exit, never delivered
+
+	events := readAllEvents(t, eventCh)
+	var bells, dropped int
+	var titles, cwds []string
+	for _, event := range events {
+		dropped += event.Dropped
+		switch event.Kind {
+		case EventKind_Bell:
+			bells++
+		case EventKind_Title:
+			titles = append(titles, event.Title)
+		case EventKind_Cwd:
+			cwds = append(cwds, event.Cwd)
+		}
+	}
+	if len(events) > 4+2 {
+		t.Errorf("expected at most the buffer (plus the one being handed over, plus Exit), got %d events", len(events))
+	}
+	if last := events[len(events)-1]; last.Kind != EventKind_Exit || last.ExitStatus.ExitCode != 1 {
+		t.Errorf("expected Exit last, got %+v", last)
+	}
+	if len(titles) != 1 || titles[0] != "t3" || len(cwds) != 1 || cwds[0] != "/c2" {
+		t.Errorf("expected only the newest title and cwd, got %v %v", titles, cwds)
+	}
+	// titles and cwds are coalesced, not counted as dropped
+	if bells+dropped != 10 {
+		t.Errorf("every bell should be either delivered or counted as dropped, got %d delivered, %d dropped", bells, dropped)
+	}
+}
+
+func TestEventSubscribers(t *testing.T) {
+	hub := makeEventHub()
+	ch1, unsub1 := hub.subscribe(0)
+	ch2, unsub2 := hub.subscribe(0)
+	defer unsub2()
+	hub.publish(ShellEvent{Kind: EventKind_Title, Title: "both"})
+	if event := waitEventKind(t, ch1, EventKind_Title); event.Title != "both" {
+		t.Errorf("bad event %+v", event)
+	}
+	unsub1()
+	unsub1()
+	if events := readAllEvents(t, ch1); len(events) != 0 {
+		t.Errorf("expected no events after unsubscribing, got %+v", events)
+	}
+	hub.publish(ShellEvent{Kind: EventKind_Exit, ExitStatus: &ExitStatus{}})
+	events := readAllEvents(t, ch2)
+	if len(events) != 2 || events[0].Title != "both" || events[1].Kind != EventKind_Exit {
+		t.Errorf("unexpected events for the second subscriber %+v", events)
+	}
+	// subscribing after the exit only gets the Exit event
+	lateCh, lateUnsub := hub.subscribe(0)
+	defer lateUnsub()
+	if events := readAllEvents(t, lateCh); len(events) != 1 || events[0].Kind != EventKind_Exit {
+		t.Errorf("expected just the Exit event for a late subscriber, got %+v", events)
+	}
+}
+
+func TestEventsExitAfterOutput(t *testing.T) {
+	sp := startTestShellProc(t, `printf 'first\n'; sleep 0.1; printf 'final-%s' output; exit 3`, CommandOptsType{})
+	eventCh := sp.Events()
+	if sp.Events() != eventCh {
+		t.Errorf("Events should return the same channel every time")
+	}
+	sub := sp.SubscribeOutput(OutputSubOpts{})
+	startWaitLoop(sp)
+	exitEvent := waitEventKind(t, eventCh, EventKind_Exit)
+	if exitEvent.ExitStatus == nil || exitEvent.ExitStatus.ExitCode != 3 {
+		t.Errorf("unexpected exit status %+v", exitEvent.ExitStatus)
+	}
+	scrollback, _ := sp.scrollback.snapshot()
+	if !strings.Contains(string(scrollback), "final-output") {
+		t.Errorf("the final output should be in the scrollback before Exit, got %q", scrollback)
+	}
+	// the output subscription must already have everything (and be closed)
+	var subOutput strings.Builder
+	for done := false; !done; {
+		select {
+		case chunk, ok := <-sub.Ch:
+			if !ok {
+				done = true
+				break
+			}
+			subOutput.Write(chunk.Data)
+		default:
+			t.Fatalf("the output subscription was not closed before Exit")
+		}
+	}
+	if !strings.Contains(subOutput.String(), "final-output") {
+		t.Errorf("output subscriber is missing the final output, got %q", subOutput.String())
+	}
+	if _, ok := <-eventCh; ok {
+		t.Errorf("expected the event channel to be closed after Exit")
+	}
+}
+
+func TestEventsFromOutput(t *testing.T) {
+	cmdStr := `printf '\033]0;my title\007'; printf '\033]7;file://host/tmp/a%%20dir\007'; printf 'ding\007'; sleep 30`
+	sp := startTestShellProc(t, cmdStr, CommandOptsType{})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	if event := waitEventKind(t, eventCh, EventKind_Title); event.Title != "my title" {
+		t.Errorf("unexpected title event %+v", event)
+	}
+	if event := waitEventKind(t, eventCh, EventKind_Cwd); event.Cwd != "/tmp/a dir" {
+		t.Errorf("unexpected cwd event %+v", event)
+	}
+	if cwd := sp.Cwd(); cwd != "/tmp/a dir" {
+		t.Errorf("expected Cwd to be the reported cwd, got %q", cwd)
+	}
+	waitEventKind(t, eventCh, EventKind_Bell)
+
+	if err := sp.SetTermSize(waveobj.TermSize{Rows: 30, Cols: 100}); err != nil {
+		t.Fatalf("error resizing: %v", err)
+	}
+	if event := waitEventKind(t, eventCh, EventKind_Resize); event.TermSize == nil || event.TermSize.Rows != 30 || event.TermSize.Cols != 100 {
+		t.Errorf("unexpected resize event %+v", event)
+	}
+}
+
+func TestCommandMarkEvents(t *testing.T) {
+	// an empty command line (D with no C) is ignored, a second C ends the first command
+	cmdStr := `printf '\033]133;A\007$ \033]133;B\007ls\n\033]133;C\007out1\n\033]133;D;2;aid=x\007'` +
+		`; printf '\033]133;A\007$ \033]133;D;0\007\033]133;A\007$ \033]133;B\007\033]133;C\007\033]133;C\007\033]133;D\007'; sleep 30`
+	sp := startTestShellProc(t, cmdStr, CommandOptsType{})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	prompt := waitEventKind(t, eventCh, EventKind_PromptStart)
+	start := waitEventKind(t, eventCh, EventKind_CommandStart)
+	end := waitEventKind(t, eventCh, EventKind_CommandEnd)
+	if start.Command.PromptOffset != prompt.Command.PromptOffset || start.Command.InputOffset <= start.Command.PromptOffset || start.Command.OutputOffset <= start.Command.InputOffset {
+		t.Errorf("unexpected command start %+v (prompt %+v)", start.Command, prompt.Command)
+	}
+	if end.Command.ExitCode == nil || *end.Command.ExitCode != 2 || end.Command.EndOffset-end.Command.OutputOffset != int64(len("out1\r\n")) {
+		t.Errorf("unexpected command end %+v", end.Command)
+	}
+	var kinds []string
+	for len(kinds) < 6 {
+		select {
+		case event := <-eventCh:
+			if event.Command == nil {
+				continue
+			}
+			kinds = append(kinds, event.Kind)
+			if len(kinds) == 6 && (event.Command.ExitCode != nil || event.Command.InputOffset != -1) {
+				t.Errorf("expected the last command to have no exit code or command line, got %+v", event.Command)
+			}
+		case <-time.After(testWaitTimeout):
+			t.Fatalf("timeout waiting for the command marks, got %v", kinds)
+		}
+	}
+	expected := []string{EventKind_PromptStart, EventKind_PromptStart, EventKind_CommandStart, EventKind_CommandEnd, EventKind_CommandStart, EventKind_CommandEnd}
+	if !slices.Equal(kinds, expected) {
+		t.Errorf("expected %v, got %v", expected, kinds)
+	}
+}
+
+func TestCommandResult(t *testing.T) {
+	cmdStr := `printf '\033]7;file://host/tmp\007\033]133;C\007'; sleep 0.2; printf '\033]7;file://host/var\007\033]133;D;3\007'; sleep 30`
+	sp := startTestShellProc(t, cmdStr, CommandOptsType{})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	if sp.LastCommandResult() != nil {
+		t.Errorf("expected no result before the command ended")
+	}
+	event := waitEventKind(t, eventCh, EventKind_CommandEnd)
+	result := event.CommandResult
+	if result == nil || result.ExitCode == nil || *result.ExitCode != 3 || result.Cwd != "/tmp" {
+		t.Fatalf("unexpected command result %+v", result)
+	}
+	if result.DurationMs < 200 || result.EndTs-result.StartTs < result.DurationMs {
+		t.Errorf("unexpected duration %+v", result)
+	}
+	if last := sp.LastCommandResult(); last == nil || *last != *result {
+		t.Errorf("expected LastCommandResult to be %+v, got %+v", result, last)
+	}
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("posix signals")
+	}
+	sp := startTestShellProc(t, `sleep 30`, CommandOptsType{})
+	startWaitLoop(sp)
+	// subscribed after the start, Started is still the first event
+	eventCh, unsub := sp.SubscribeEvents(0)
+	defer unsub()
+	event := <-eventCh
+	if event.Kind != EventKind_Started || event.Started == nil || event.Started.Pid != sp.localPid() || event.Started.Pid == 0 {
+		t.Fatalf("expected Started with the shell's pid first, got %+v", event)
+	}
+	if err := sp.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("error signaling the shell: %v", err)
+	}
+	event = waitEventKind(t, eventCh, EventKind_Signaled)
+	if *event.Signal != (SignalInfo{Signal: "SIGTERM", Target: SignalTarget_Shell, Pid: sp.localPid()}) {
+		t.Errorf("bad signal event %+v", event.Signal)
+	}
+	event = waitEventKind(t, eventCh, EventKind_Exit)
+	if event.ExitStatus == nil || event.ExitStatus.Signal != "SIGTERM" {
+		t.Errorf("expected the exit to show SIGTERM, got %+v", event.ExitStatus)
+	}
+}
+
+func TestOutputStallEvents(t *testing.T) {
+	oldThreshold := OutputStallThreshold
+	OutputStallThreshold = 20 * time.Millisecond
+	defer func() { OutputStallThreshold = oldThreshold }()
+	hub := makeOutputHub()
+	stallCh := make(chan OutputStall, 4)
+	hub.OnStall = func(stall OutputStall) { stallCh <- stall }
+	sub := hub.subscribe(OutputSubOpts{FlowPolicy: FlowPolicy_Block, HighWater: 8})
+	writeDone := make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		hub.Write([]byte("aaaabbbbcccc"))
+	}()
+	select {
+	case stall := <-stallCh:
+		if !stall.Stalled || stall.Offset != 12 {
+			t.Errorf("bad stall %+v", stall)
+		}
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timed out waiting for the stall")
+	}
+	<-sub.Ch
+	<-writeDone
+	select {
+	case stall := <-stallCh:
+		if stall.Stalled || stall.DurationMs < 20 {
+			t.Errorf("bad resume %+v", stall)
+		}
+	default:
+		t.Fatalf("expected the resume to be reported before Write returned")
+	}
+	// a pause shorter than the threshold isn't reported
+	OutputStallThreshold = testWaitTimeout
+	go func() { <-sub.Ch }()
+	hub.Write([]byte("ddddeeeeffff"))
+	if len(stallCh) != 0 {
+		t.Errorf("unexpected stall %+v", <-stallCh)
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package shellexec
+
+import (
+	"os"
+	"syscall"
+)
+
+func exitStatusFromProcessState(state *os.ProcessState) ExitStatus {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return ExitStatus{ExitCode: 128 + int(status.Signal()), Signal: signalName(status.Signal())}
+	}
+	return ExitStatus{ExitCode: state.ExitCode()}
+}
+
+// -1 for a signaled process
+func exitCodeFromProcessState(state *os.ProcessState) int {
+	return state.ExitCode()
+}
@@ -0,0 +1,42 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package shellexec
+
+import (
+	"os"
+)
+
+// the NTSTATUS codes a crashed process exits with (its unhandled exception), the rest are shown as codes
+var ntStatusNames = map[uint32]string{
+	0x80000003: "STATUS_BREAKPOINT",
+	0xC0000005: "STATUS_ACCESS_VIOLATION",
+	0xC0000017: "STATUS_NO_MEMORY",
+	0xC000001D: "STATUS_ILLEGAL_INSTRUCTION",
+	0xC0000094: "STATUS_INTEGER_DIVIDE_BY_ZERO",
+	0xC00000FD: "STATUS_STACK_OVERFLOW",
+	0xC0000135: "STATUS_DLL_NOT_FOUND",
+	0xC000013A: "STATUS_CONTROL_C_EXIT",
+	0xC0000142: "STATUS_DLL_INIT_FAILED",
+	0xC0000374: "STATUS_HEAP_CORRUPTION",
+	0xC0000409: "STATUS_STACK_BUFFER_OVERRUN",
+}
+
+func exitCodeFromProcessState(state *os.ProcessState) int {
+	return windowsExitCode(uint32(state.ExitCode()))
+}
+
+func exitStatusFromProcessState(state *os.ProcessState) ExitStatus {
+	return exitStatusFromWindowsCode(uint32(state.ExitCode()))
+}
+
+// exit codes are uint32s, they are shown (and compared, e.g. by %ERRORLEVEL% and $LASTEXITCODE) as int32s
+func windowsExitCode(code uint32) int {
+	return int(int32(code))
+}
+
+func exitStatusFromWindowsCode(code uint32) ExitStatus {
+	return ExitStatus{ExitCode: windowsExitCode(code), Exception: ntStatusNames[code]}
+}
@@ -0,0 +1,32 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package shellexec
+
+import (
+	"testing"
+)
+
+func TestExitStatusFromWindowsCode(t *testing.T) {
+	if status := exitStatusFromWindowsCode(3); status.ExitCode != 3 || status.Exception != "" {
+		t.Errorf("expected a plain exit code, got %+v", status)
+	}
+	status := exitStatusFromWindowsCode(0xC0000005)
+	if status.ExitCode != -1073741819 || status.Exception != "STATUS_ACCESS_VIOLATION" {
+		t.Errorf("expected an access violation, got %+v", status)
+	}
+	if explain := ExplainExit(status); explain != "crashed (STATUS_ACCESS_VIOLATION)" {
+		t.Errorf("unexpected explanation %q", explain)
+	}
+
+	cmdPath := requireBinary(t, "cmd.exe")
+	sp := startTestShellProc(t, `exit /b -1073741819`, CommandOptsType{ShellPath: cmdPath})
+	if status := waitExitStatus(t, sp); status.Exception != "STATUS_ACCESS_VIOLATION" {
+		t.Errorf("expected the NTSTATUS exit to be decoded, got %+v", status)
+	}
+	if code := ExitCodeFromWaitErr(sp.Wait()); code != -1073741819 {
+		t.Errorf("expected a negative exit code, got %d", code)
+	}
+}
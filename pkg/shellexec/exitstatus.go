@@ -0,0 +1,193 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const sigKillExitCode = 128 + int(syscall.SIGKILL)
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGTERM: "SIGTERM",
+}
+
+// ExitStatus describes how a shell exited.  Signal is set when the shell itself
+// was killed by a signal, ExitCode is then 128+signal (like the shell reports
+// for its own commands).
+type ExitStatus struct {
+	ExitCode  int    `json:"exitcode"`
+	Signal    string `json:"signal,omitempty"`    // e.g. "SIGKILL"
+	OOMKilled bool   `json:"oomkilled,omitempty"` // confirmed by the kernel (linux only)
+	TimedOut  bool   `json:"timedout,omitempty"`  // closed after CommandOptsType.Timeout
+	Exception string `json:"exception,omitempty"` // windows, the NTSTATUS a crashed process exited with (e.g. "STATUS_ACCESS_VIOLATION")
+
+	// for Elevate shells, set if sudo/doas exited without starting the shell (bad password, not
+	// allowed, ^C at the prompt).  ExitCode is then the tool's own code, not the shell's.
+	ElevateFailed bool   `json:"elevatefailed,omitempty"`
+	ElevateTool   string `json:"elevatetool,omitempty"`
+
+	// for Container shells, set if docker exec failed to run the shell at all (the container doesn't
+	// exist or isn't running, a daemon error), the tool's message is in the output
+	ContainerFailed bool `json:"containerfailed,omitempty"`
+
+	// for remote (ssh) shells, set if the session closed without an exit status (the connection was
+	// lost or the server dropped the channel), ExitCode is then -1
+	ConnectionLost bool `json:"connectionlost,omitempty"`
+}
+
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	if name, ok := platformSignalNames[sig]; ok {
+		return name
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
+
+func signalByName(name string) (syscall.Signal, bool) {
+	for _, names := range []map[syscall.Signal]string{signalNames, platformSignalNames} {
+		for sig, sigName := range names {
+			if sigName == name {
+				return sig, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// returns the signal name for the 128+signal exit code convention, or "" if it isn't one we know
+func signalNameFromExitCode(exitCode int) string {
+	if exitCode <= 128 {
+		return ""
+	}
+	return signalNames[syscall.Signal(exitCode-128)]
+}
+
+func exitStatusFromWait(cmd ConnInterface, waitErr error) ExitStatus {
+	if sc, ok := cmd.(*supervisedCmd); ok {
+		cmd = sc.currentRun()
+	}
+	if cw, ok := cmd.(CmdWrap); ok && cw.Cmd.ProcessState != nil {
+		return exitStatusFromProcessState(cw.Cmd.ProcessState)
+	}
+	var sshExitErr *ssh.ExitError
+	if errors.As(waitErr, &sshExitErr) && sshExitErr.Signal() != "" {
+		rtn := ExitStatus{ExitCode: sshExitErr.ExitStatus(), Signal: "SIG" + sshExitErr.Signal()}
+		if rtn.ExitCode <= 0 {
+			// most servers only send the signal name (no exit-status, so it is -1)
+			for sig, name := range signalNames {
+				if name == rtn.Signal {
+					rtn.ExitCode = 128 + int(sig)
+				}
+			}
+		}
+		return rtn
+	}
+	var missingErr *ssh.ExitMissingError
+	if errors.As(waitErr, &missingErr) {
+		return ExitStatus{ExitCode: -1, ConnectionLost: true}
+	}
+	return ExitStatus{ExitCode: cmd.ExitCode()}
+}
+
+func (sp *ShellProc) makeExitStatus(waitErr error) ExitStatus {
+	status := exitStatusFromWait(sp.Cmd, waitErr)
+	if pid := sp.localPid(); pid > 0 && status.ExitCode == sigKillExitCode {
+		status.OOMKilled = detectOOMKill(pid, sp.cgroup)
+	}
+	if sp.elevate != nil {
+		status.ElevateTool = sp.elevate.Tool
+		status.ElevateFailed = sp.elevate.toolFailed()
+	}
+	if sp.container != "" && status.ExitCode == containerExecFailedExitCode {
+		status.ContainerFailed = true
+	}
+	return status
+}
+
+// ExitStatus returns how the shell exited, ok is false if it is still running
+func (sp *ShellProc) ExitStatus() (ExitStatus, bool) {
+	select {
+	case <-sp.DoneCh:
+		return sp.exitStatus, true
+	default:
+		return ExitStatus{}, false
+	}
+}
+
+// ExplainExit returns a short human readable description of an exit status
+// (e.g. "killed by the kernel: out of memory", "exited with code 2").
+func ExplainExit(status ExitStatus) string {
+	if status.OOMKilled {
+		return "killed by the kernel: out of memory"
+	}
+	if status.TimedOut {
+		return "timed out"
+	}
+	if status.Exception != "" {
+		return fmt.Sprintf("crashed (%s)", status.Exception)
+	}
+	if status.ElevateFailed {
+		return fmt.Sprintf("%s exited with code %d before starting the shell", status.ElevateTool, status.ExitCode)
+	}
+	if status.ContainerFailed {
+		return "could not exec in the container (not running or a daemon error)"
+	}
+	if status.ConnectionLost {
+		return "the connection was lost before the remote shell exited"
+	}
+	sigName := status.Signal
+	if sigName == "" {
+		// the shell exited normally but is reporting a command that was killed
+		sigName = signalNameFromExitCode(status.ExitCode)
+	}
+	if sigName == "SIGKILL" {
+		return "killed (SIGKILL), likely out of memory"
+	}
+	if status.Signal != "" {
+		return fmt.Sprintf("killed by %s", status.Signal)
+	}
+	if status.ExitCode == 0 {
+		return "exited normally"
+	}
+	if sigName != "" {
+		return fmt.Sprintf("exited with code %d (%s)", status.ExitCode, sigName)
+	}
+	return fmt.Sprintf("exited with code %d", status.ExitCode)
+}
+
+// ExitCodeFromWaitErr returns the exit code in a wait error (0 for nil, -1 if there is none or the process
+// was killed by a signal, see ExitStatus).  windows codes are int32s (so NTSTATUS codes are negative).
+// for ssh sessions it is the remote exit-status
+func ExitCodeFromWaitErr(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitCodeFromProcessState(exitErr.ProcessState)
+	}
+	var sshExitErr *ssh.ExitError
+	if errors.As(err, &sshExitErr) && sshExitErr.Signal() == "" {
+		return sshExitErr.ExitStatus()
+	}
+	return -1
+}
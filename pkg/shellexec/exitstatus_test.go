@@ -0,0 +1,92 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestExplainExit(t *testing.T) {
+	tests := []struct {
+		status ExitStatus
+		want   string
+	}{
+		{ExitStatus{ExitCode: 0}, "exited normally"},
+		{ExitStatus{ExitCode: 2}, "exited with code 2"},
+		{ExitStatus{ExitCode: 139}, "exited with code 139 (SIGSEGV)"},
+		{ExitStatus{ExitCode: 143, Signal: "SIGTERM"}, "killed by SIGTERM"},
+		{ExitStatus{ExitCode: 137, Signal: "SIGKILL"}, "killed (SIGKILL), likely out of memory"},
+		{ExitStatus{ExitCode: 137}, "killed (SIGKILL), likely out of memory"},
+		{ExitStatus{ExitCode: 137, Signal: "SIGKILL", OOMKilled: true}, "killed by the kernel: out of memory"},
+	}
+	for _, test := range tests {
+		if got := ExplainExit(test.status); got != test.want {
+			t.Errorf("ExplainExit(%+v) = %q, want %q", test.status, got, test.want)
+		}
+	}
+}
+
+// waits for a shell that exits on its own and returns its exit status
+func waitExitStatus(t *testing.T, sp *ShellProc) ExitStatus {
+	t.Helper()
+	oc := collectOutput(sp)
+	select {
+	case <-oc.Done:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for the shell to exit, output: %q", oc.String())
+	}
+	if _, ok := sp.ExitStatus(); ok {
+		t.Fatalf("exit status should not be set before wait returns")
+	}
+	sp.Close()
+	select {
+	case <-sp.DoneCh:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for DoneCh")
+	}
+	status, ok := sp.ExitStatus()
+	if !ok {
+		t.Fatalf("exit status should be set once done")
+	}
+	return status
+}
+
+func TestExitStatusSignaled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no signals on windows")
+	}
+	sp := startTestShellProc(t, "kill -KILL $$", CommandOptsType{})
+	status := waitExitStatus(t, sp)
+	if status.Signal != "SIGKILL" || status.ExitCode != 137 {
+		t.Errorf("expected a SIGKILL exit, got %+v", status)
+	}
+	if status.OOMKilled {
+		t.Errorf("a plain SIGKILL should not be reported as an OOM kill")
+	}
+}
+
+func TestExitStatusCode(t *testing.T) {
+	sp := startTestShellProc(t, "exit 3", CommandOptsType{})
+	status := waitExitStatus(t, sp)
+	if status.Signal != "" || status.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %+v", status)
+	}
+}
+
+func TestExitCodeFromWaitErr(t *testing.T) {
+	if code := ExitCodeFromWaitErr(nil); code != 0 {
+		t.Errorf("expected 0 for a nil error, got %d", code)
+	}
+	waitErr := exec.Command(requireBinary(t, "sh"), "-c", "exit 3").Run()
+	if code := ExitCodeFromWaitErr(waitErr); code != 3 {
+		t.Errorf("expected 3, got %d (%v)", code, waitErr)
+	}
+	if code := ExitCodeFromWaitErr(errors.New("not an exit")); code != -1 {
+		t.Errorf("expected -1 for other errors, got %d", code)
+	}
+}
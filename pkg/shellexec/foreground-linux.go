@@ -0,0 +1,40 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package shellexec
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// sid is the shell's pid (the session leader) so a recycled pgid in another session isn't picked up
+func readForegroundProc(pgid int, sid int) (ForegroundProc, error) {
+	pid := pgid
+	stat, err := readProcStat(pid)
+	if err != nil || stat.Pgrp != pgid {
+		stats, err := readAllProcStats()
+		if err != nil {
+			return ForegroundProc{}, err
+		}
+		pid = 0
+		for memberPid, memberStat := range stats {
+			if memberStat.Pgrp == pgid && memberStat.Sid == sid && (pid == 0 || memberPid < pid) {
+				pid, stat = memberPid, memberStat
+			}
+		}
+		if pid == 0 {
+			return ForegroundProc{}, fmt.Errorf("foreground process group %d has no processes", pgid)
+		}
+	}
+	rtn := ForegroundProc{Pid: pid, Name: stat.Comm}
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid)); err == nil && len(data) > 0 {
+		for _, arg := range bytes.Split(bytes.TrimSuffix(data, []byte{0}), []byte{0}) {
+			rtn.Cmdline = append(rtn.Cmdline, string(arg))
+		}
+	}
+	return rtn, nil
+}
@@ -0,0 +1,28 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package shellexec
+
+import (
+	"fmt"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// only the group leader is found here (there is no cheap way to list a process group's members)
+func readForegroundProc(pgid int, sid int) (ForegroundProc, error) {
+	proc, err := process.NewProcess(int32(pgid))
+	if err != nil {
+		return ForegroundProc{}, fmt.Errorf("foreground process %d not found: %w", pgid, err)
+	}
+	rtn := ForegroundProc{Pid: pgid}
+	if rtn.Name, err = proc.Name(); err != nil {
+		return ForegroundProc{}, fmt.Errorf("cannot get foreground process name: %w", err)
+	}
+	if cmdline, err := proc.CmdlineSlice(); err == nil {
+		rtn.Cmdline = cmdline
+	}
+	return rtn, nil
+}
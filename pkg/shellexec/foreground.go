@@ -0,0 +1,44 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+)
+
+// ForegroundProc is the process in the foreground of a shell's pty (see ShellProc.ForegroundProcess)
+type ForegroundProc struct {
+	Pid     int      `json:"pid"`
+	Pgid    int      `json:"pgid"`
+	Name    string   `json:"name"`              // the executable's name (truncated to 15 chars on linux)
+	Cmdline []string `json:"cmdline,omitempty"` // empty if it can't be read (e.g. another user's process)
+	IsShell bool     `json:"isshell,omitempty"` // the shell itself has the foreground (see Activity)
+}
+
+// ForegroundProcess returns the process controlling the pty (local shells only, not on windows).
+// That is the leader of the foreground process group, which for a pipeline is its first command
+// (the one the shell started first), or if the leader has already exited the lowest pid left
+// in the group
+func (sp *ShellProc) ForegroundProcess() (ForegroundProc, error) {
+	pid := sp.localPid()
+	if pid <= 0 {
+		return ForegroundProc{}, fmt.Errorf("the foreground process is only available for local shells")
+	}
+	var pgid int
+	err := sp.withPtyFd(func(fd uintptr) error {
+		var err error
+		pgid, err = getForegroundPgid(fd)
+		return err
+	})
+	if err != nil {
+		return ForegroundProc{}, fmt.Errorf("cannot get foreground process group: %w", err)
+	}
+	rtn, err := readForegroundProc(pgid, pid)
+	if err != nil {
+		return ForegroundProc{}, err
+	}
+	rtn.Pgid = pgid
+	rtn.IsShell = (rtn.Pid == pid)
+	return rtn, nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestForegroundProcess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no process groups on windows")
+	}
+	sp := startTestShellProc(t, "", CommandOptsType{})
+	oc := collectOutput(sp)
+	sp.Write([]byte("echo sta''rted\r"))
+	oc.waitFor(t, "started")
+	waitActivity(t, sp, Activity_Idle)
+	fgProc, err := sp.ForegroundProcess()
+	if err != nil {
+		t.Fatalf("error getting the foreground process: %v", err)
+	}
+	if !fgProc.IsShell || fgProc.Pid != sp.localPid() || fgProc.Name != "bash" {
+		t.Errorf("expected the shell in the foreground, got %+v", fgProc)
+	}
+	sp.Write([]byte("sleep 30 | cat\r"))
+	// the shell forks before sleep is exec'd, so the name can briefly still be bash
+	for deadline := time.Now().Add(testWaitTimeout); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if fgProc, err = sp.ForegroundProcess(); err == nil && fgProc.Name == "sleep" {
+			break
+		}
+	}
+	if fgProc.IsShell || fgProc.Pid != fgProc.Pgid || fgProc.Name != "sleep" || !slices.Equal(fgProc.Cmdline, []string{"sleep", "30"}) {
+		t.Errorf("expected sleep in the foreground, got %+v", fgProc)
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package shellexec
+
+// ConPTY handles can't be polled, the pty is writable until it's closed (withPtyFd checks that)
+func checkPtyWritable(fd uintptr) error {
+	return nil
+}
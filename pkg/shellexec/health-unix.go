@@ -0,0 +1,28 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// polls the pty (or the remote's stdin pipe) without waiting
+func checkPtyWritable(fd uintptr) error {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+	if _, err := unix.Poll(fds, 0); err != nil && err != unix.EINTR {
+		return fmt.Errorf("cannot poll the pty: %w", err)
+	}
+	revents := fds[0].Revents
+	switch {
+	case revents&(unix.POLLERR|unix.POLLHUP|unix.POLLNVAL) != 0:
+		return fmt.Errorf("the pty has an error or hung up (revents %#x)", revents)
+	case revents&unix.POLLOUT == 0:
+		return fmt.Errorf("writing to the pty would block")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+)
+
+// HealthStatus.State, the first check that failed
+const (
+	HealthState_Healthy     = "healthy"
+	HealthState_Exited      = "exited"      // the shell exited and was waited for (not a failure of the check)
+	HealthState_Restarting  = "restarting"  // a supervised command is in its backoff delay
+	HealthState_ProcessGone = "processgone" // the shell process is gone but hasn't been waited for
+	HealthState_Zombie      = "zombie"      // the shell exited but nobody reaped it (the owner's wait loop isn't running)
+	HealthState_PtyClosed   = "ptyclosed"   // the pty hung up or the output loop stopped while the shell is still running
+	HealthState_PtyBlocked  = "ptyblocked"  // input can't be written to the pty (its buffer is full or it has an error)
+)
+
+// HealthStatus is returned by ShellProc.HealthCheck, Healthy is false for every State but
+// HealthState_Healthy (Exited, Restarting and Healthy aren't disconnected, see Disconnected)
+type HealthStatus struct {
+	State       string `json:"state"` // HealthState_*
+	Healthy     bool   `json:"healthy"`
+	ProcAlive   bool   `json:"procalive"`
+	Zombie      bool   `json:"zombie,omitempty"`
+	PtyReadable bool   `json:"ptyreadable"`
+	PtyWritable bool   `json:"ptywritable"`
+	Error       string `json:"error,omitempty"` // what the failed check saw
+}
+
+// Disconnected is true if the shell is running (as far as we know) but can't be used, the
+// block controller shows these as "disconnected"
+func (hs HealthStatus) Disconnected() bool {
+	return !hs.Healthy && hs.State != HealthState_Exited && hs.State != HealthState_Restarting
+}
+
+// HealthCheck checks that the shell process is alive (and not a zombie, local shells
+// only, for remote and wsl shells they're alive until they have been waited for), that the
+// output loop is still reading the pty, and that the pty accepts input without blocking
+// (only checked on unix, elsewhere it's writable until closed).  It never blocks and
+// doesn't count as activity.
+func (sp *ShellProc) HealthCheck() HealthStatus {
+	select {
+	case <-sp.DoneCh:
+		return HealthStatus{State: HealthState_Exited}
+	default:
+	}
+	var rtn HealthStatus
+	if sc, ok := sp.Cmd.(*supervisedCmd); ok && sc.isWaiting() {
+		rtn.State = HealthState_Restarting
+		return rtn
+	}
+	if pid := sp.localPid(); pid > 0 {
+		proc, found := lookupAuditProc(pid)
+		switch {
+		case !found:
+			rtn.State, rtn.Error = HealthState_ProcessGone, fmt.Sprintf("shell process %d not found", pid)
+			return rtn
+		case proc.Zombie:
+			rtn.State, rtn.Zombie = HealthState_Zombie, true
+			rtn.Error = fmt.Sprintf("shell process %d is a zombie", pid)
+			return rtn
+		}
+	}
+	rtn.ProcAlive = true
+	select {
+	case <-sp.outputDone:
+		rtn.State, rtn.Error = HealthState_PtyClosed, "the output loop has stopped"
+		return rtn
+	default:
+	}
+	rtn.PtyReadable = true
+	if err := sp.withPtyFd(checkPtyWritable); err != nil {
+		rtn.State, rtn.Error = HealthState_PtyBlocked, err.Error()
+		return rtn
+	}
+	rtn.PtyWritable = true
+	rtn.State, rtn.Healthy = HealthState_Healthy, true
+	return rtn
+}
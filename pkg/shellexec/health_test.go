@@ -0,0 +1,49 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+func waitHealthState(t *testing.T, sp *ShellProc, state string) HealthStatus {
+	t.Helper()
+	var health HealthStatus
+	waitUntil(t, fmt.Sprintf("health state %q", state), func() bool {
+		health = sp.HealthCheck()
+		return health.State == state
+	})
+	return health
+}
+
+func TestHealthCheck(t *testing.T) {
+	sp := startTestShellProc(t, `echo ready; read y; exit 0`, CommandOptsType{})
+	oc := collectOutput(sp)
+	startWaitLoop(sp)
+	oc.waitFor(t, "ready")
+	health := sp.HealthCheck()
+	if !health.Healthy || !health.ProcAlive || !health.PtyReadable || !health.PtyWritable || health.Disconnected() {
+		t.Errorf("expected a healthy shell, got %+v", health)
+	}
+	sp.Write([]byte("\n"))
+	if health := waitHealthState(t, sp, HealthState_Exited); health.Healthy || health.Disconnected() {
+		t.Errorf("an exited shell is neither healthy nor disconnected: %+v", health)
+	}
+}
+
+func TestHealthCheckZombie(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("zombies are only checked with the linux process table here")
+	}
+	// no wait loop, the exited shell stays a zombie
+	sp := startTestShellProc(t, `exit 0`, CommandOptsType{})
+	health := waitHealthState(t, sp, HealthState_Zombie)
+	if health.Healthy || health.ProcAlive || !health.Zombie || !health.Disconnected() {
+		t.Errorf("unexpected zombie health %+v", health)
+	}
+	startWaitLoop(sp)
+	waitHealthState(t, sp, HealthState_Exited)
+}
@@ -0,0 +1,83 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+)
+
+// runs two interactive bash shells (through our bash integration) at the same time with per-block history
+func TestHistoryScopePerBlock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no bash on windows")
+	}
+	blockIds := []string{"history-block-a", "history-block-b"}
+	var wg sync.WaitGroup
+	var collectors []*outputCollector
+	for _, blockId := range blockIds {
+		sp := startTestShellProc(t, "", CommandOptsType{HistoryScope: shellutil.HistoryScope_PerBlock, BlockId: blockId})
+		oc := collectOutput(sp)
+		collectors = append(collectors, oc)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fmt.Fprintf(sp.Cmd, "echo ran-in-%s\r", blockId)
+			time.Sleep(100 * time.Millisecond)
+			sp.Cmd.Write([]byte("exit\r"))
+		}()
+		t.Cleanup(func() {
+			select {
+			case <-oc.Done:
+			case <-time.After(testWaitTimeout):
+				t.Errorf("timeout waiting for %s to exit, output: %q", blockId, oc.String())
+			}
+		})
+	}
+	wg.Wait()
+	// the history is written when the shells exit (which can take a while, they start with our integration)
+	for idx, oc := range collectors {
+		select {
+		case <-oc.Done:
+		case <-time.After(2 * testWaitTimeout):
+			t.Fatalf("timeout waiting for %s to exit, output: %q", blockIds[idx], oc.String())
+		}
+	}
+	histFiles := make(map[string]bool)
+	for _, blockId := range blockIds {
+		env, err := shellutil.HistoryEnvVars(shellutil.HistoryScope_PerBlock, shellutil.ShellFamily_Bash, blockId, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		histFile := env["HISTFILE"]
+		histFiles[histFile] = true
+		var histData []byte
+		deadline := time.Now().Add(testWaitTimeout)
+		for time.Now().Before(deadline) {
+			histData, err = os.ReadFile(histFile)
+			if err == nil && strings.Contains(string(histData), "exit") {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if !strings.Contains(string(histData), "echo ran-in-"+blockId) {
+			t.Errorf("history for %s is missing its command: %q", blockId, histData)
+		}
+		for _, otherId := range blockIds {
+			if otherId != blockId && strings.Contains(string(histData), otherId) {
+				t.Errorf("history for %s contains commands from %s: %q", blockId, otherId, histData)
+			}
+		}
+	}
+	if len(histFiles) != len(blockIds) {
+		t.Errorf("blocks should write distinct history files")
+	}
+}
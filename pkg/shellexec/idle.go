@@ -0,0 +1,106 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+)
+
+const DefaultIdleExitGrace = 30 * time.Second
+
+const (
+	CloseReason_IdleTimeout = "idletimeout"
+)
+
+const idleWarningFmt = "\r\n[waveterm] this session has been idle for %v and will exit in %v unless a key is pressed\r\n"
+
+// idleMonitor closes a shell that has had no activity for IdleExit.  Activity
+// is input (ShellProc.Write), and optionally output.  It runs a single timer
+// per shell.
+type idleMonitor struct {
+	Lock         *sync.Mutex
+	Clock        clock
+	IdleExit     time.Duration
+	Grace        time.Duration
+	LastActivity time.Time
+}
+
+func makeIdleMonitor(clk clock, idleExit time.Duration, grace time.Duration) *idleMonitor {
+	if grace <= 0 {
+		grace = DefaultIdleExitGrace
+	}
+	return &idleMonitor{Lock: &sync.Mutex{}, Clock: clk, IdleExit: idleExit, Grace: grace, LastActivity: clk.Now()}
+}
+
+func (im *idleMonitor) activity() {
+	now := im.Clock.Now()
+	im.Lock.Lock()
+	defer im.Lock.Unlock()
+	im.LastActivity = now
+}
+
+func (im *idleMonitor) lastActivity() time.Time {
+	im.Lock.Lock()
+	defer im.Lock.Unlock()
+	return im.LastActivity
+}
+
+// io.Writer so it can sit in the output pipeline (when output counts as activity)
+func (im *idleMonitor) Write(data []byte) (int, error) {
+	im.activity()
+	return len(data), nil
+}
+
+// returns false if doneCh closed first
+func (im *idleMonitor) sleep(d time.Duration, doneCh <-chan any) bool {
+	timerCh, stopFn := im.Clock.NewTimer(d)
+	defer stopFn()
+	select {
+	case <-timerCh:
+		return true
+	case <-doneCh:
+		return false
+	}
+}
+
+// run returns when doneCh is closed or after calling exitFn
+func (im *idleMonitor) run(doneCh <-chan any, warnFn func(string), exitFn func()) {
+	for {
+		idleFor := im.Clock.Now().Sub(im.lastActivity())
+		if idleFor < im.IdleExit {
+			if !im.sleep(im.IdleExit-idleFor, doneCh) {
+				return
+			}
+			continue
+		}
+		warnTs := im.lastActivity()
+		warnFn(fmt.Sprintf(idleWarningFmt, im.IdleExit, im.Grace))
+		if !im.sleep(im.Grace, doneCh) {
+			return
+		}
+		if im.lastActivity().After(warnTs) {
+			continue
+		}
+		exitFn()
+		return
+	}
+}
+
+func (sp *ShellProc) startIdleMonitor() {
+	if sp.idle == nil {
+		return
+	}
+	go func() {
+		defer panichandler.PanicHandler("ShellProc:idleMonitor")
+		sp.idle.run(sp.DoneCh, func(msg string) {
+			sp.injectOutput([]byte(msg))
+		}, func() {
+			sp.closeWithReason(CloseReason_IdleTimeout)
+		})
+	}()
+}
@@ -0,0 +1,126 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/util/testutil"
+)
+
+// note the fake clock drives every shellexec timer, so tests using it should
+// turn off output coalescing (OutputFlushDelay: -1) to see output without advancing it,
+// and the prompt detector (PromptIdleWindow: -1) so only their own timers are pending
+func useFakeClock(t *testing.T) *testutil.FakeClock {
+	fc := testutil.NewFakeClock()
+	oldClock := shellClock
+	shellClock = fc
+	t.Cleanup(func() { shellClock = oldClock })
+	return fc
+}
+
+func waitPendingTimers(t *testing.T, fc *testutil.FakeClock, n int) {
+	t.Helper()
+	if !fc.WaitPending(n, testWaitTimeout) {
+		t.Fatalf("timeout waiting for %d pending timers (have %d)", n, fc.Pending())
+	}
+}
+
+const idleWarningSubstr = "has been idle for"
+
+func TestIdleExit(t *testing.T) {
+	fc := useFakeClock(t)
+	sp := startTestShellProc(t, "echo ready; cat", CommandOptsType{IdleExit: 10 * time.Minute, IdleExitGrace: 30 * time.Second, OutputFlushDelay: -1, PromptIdleWindow: -1})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready\r\n")
+
+	waitPendingTimers(t, fc, 1)
+	fc.Advance(5 * time.Minute)
+	sp.Write([]byte("x"))
+	fc.Advance(5 * time.Minute)
+	// the timer fired, but there was input 5 minutes ago
+	waitPendingTimers(t, fc, 1)
+	if strings.Contains(oc.String(), idleWarningSubstr) {
+		t.Fatalf("warning shown too early: %q", oc.String())
+	}
+	fc.Advance(5 * time.Minute)
+	oc.waitFor(t, idleWarningSubstr)
+
+	// a keypress during the grace period keeps the session alive
+	waitPendingTimers(t, fc, 1)
+	sp.Write([]byte("y"))
+	fc.Advance(30 * time.Second)
+	waitPendingTimers(t, fc, 1)
+	if done, _ := sp.WaitNB(); done || sp.CloseReason() != "" {
+		t.Fatalf("session closed even though a key was pressed")
+	}
+
+	fc.Advance(9*time.Minute + 30*time.Second)
+	waitPendingTimers(t, fc, 1)
+	deadline := time.Now().Add(testWaitTimeout)
+	for strings.Count(oc.String(), idleWarningSubstr) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if strings.Count(oc.String(), idleWarningSubstr) != 2 {
+		t.Fatalf("expected a second warning, output: %q", oc.String())
+	}
+	fc.Advance(30 * time.Second)
+	select {
+	case <-oc.Done:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for the idle session to close")
+	}
+	if sp.CloseReason() != CloseReason_IdleTimeout {
+		t.Errorf("bad close reason %q", sp.CloseReason())
+	}
+}
+
+func TestIdleExitCountOutput(t *testing.T) {
+	fc := useFakeClock(t)
+	sp := startTestShellProc(t, "read x; echo tick; cat", CommandOptsType{IdleExit: 10 * time.Minute, IdleCountOutput: true, OutputFlushDelay: -1, PromptIdleWindow: -1})
+	oc := collectOutput(sp)
+	waitPendingTimers(t, fc, 1)
+	fc.Advance(9 * time.Minute)
+	// writing directly to Cmd is not tracked as input, so only the output counts
+	sp.Cmd.Write([]byte("\r"))
+	oc.waitFor(t, "tick\r\n")
+	fc.Advance(time.Minute)
+	waitPendingTimers(t, fc, 1)
+	if strings.Contains(oc.String(), idleWarningSubstr) {
+		t.Fatalf("output should count as activity: %q", oc.String())
+	}
+	fc.Advance(9 * time.Minute)
+	oc.waitFor(t, idleWarningSubstr)
+	// the warning itself isn't activity
+	waitPendingTimers(t, fc, 1)
+	fc.Advance(DefaultIdleExitGrace)
+	select {
+	case <-oc.Done:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for the idle session to close")
+	}
+	if sp.CloseReason() != CloseReason_IdleTimeout {
+		t.Errorf("bad close reason %q", sp.CloseReason())
+	}
+}
+
+func TestIdleExitCancelledOnExit(t *testing.T) {
+	fc := useFakeClock(t)
+	sp := startTestShellProc(t, "read x", CommandOptsType{IdleExit: time.Minute, OutputFlushDelay: -1, PromptIdleWindow: -1})
+	oc := collectOutput(sp)
+	waitPendingTimers(t, fc, 1)
+	sp.Write([]byte("\r"))
+	<-oc.Done
+	sp.Cmd.Wait()
+	sp.SetWaitErrorAndSignalDone(nil)
+	deadline := time.Now().Add(testWaitTimeout)
+	for fc.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fc.Pending() != 0 {
+		t.Errorf("idle timer was not stopped after exit")
+	}
+}
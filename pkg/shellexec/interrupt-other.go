@@ -0,0 +1,20 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package shellexec
+
+import (
+	"syscall"
+)
+
+var platformSignalNames = map[syscall.Signal]string{}
+
+func getForegroundPgid(fd uintptr) (int, error) {
+	return 0, ErrSignalNotSupported
+}
+
+func signalPgid(pgid int, sig syscall.Signal) error {
+	return ErrSignalNotSupported
+}
@@ -0,0 +1,31 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// the signals that only exist on unix (signalNames has the portable ones)
+var platformSignalNames = map[syscall.Signal]string{
+	syscall.SIGTSTP:  "SIGTSTP",
+	syscall.SIGCONT:  "SIGCONT",
+	syscall.SIGTTIN:  "SIGTTIN",
+	syscall.SIGTTOU:  "SIGTTOU",
+	syscall.SIGUSR1:  "SIGUSR1",
+	syscall.SIGUSR2:  "SIGUSR2",
+	syscall.SIGWINCH: "SIGWINCH",
+}
+
+func getForegroundPgid(fd uintptr) (int, error) {
+	return unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+}
+
+func signalPgid(pgid int, sig syscall.Signal) error {
+	return unix.Kill(-pgid, sig)
+}
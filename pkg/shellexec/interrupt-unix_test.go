@@ -0,0 +1,34 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSendSignalJobControl(t *testing.T) {
+	sp := startTestShellProc(t, "", CommandOptsType{})
+	oc := collectOutput(sp)
+	sp.Write([]byte("sleep 30\n"))
+	oc.waitFor(t, "sleep 30")
+	// ^Z, without the line discipline
+	deadline := time.Now().Add(testWaitTimeout)
+	for !strings.Contains(oc.String(), "Stopped") && time.Now().Before(deadline) {
+		if err := sp.SendSignal(syscall.SIGTSTP); err != nil {
+			t.Fatalf("error sending SIGTSTP: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	oc.waitFor(t, "Stopped")
+	sp.Write([]byte("echo \"jobs=$(jobs | wc -l)\"\n"))
+	oc.waitFor(t, "jobs=1")
+	if sig, err := ParseSignal("SIGTSTP"); err != nil || sig != syscall.SIGTSTP {
+		t.Errorf("expected SIGTSTP, got %v %v", sig, err)
+	}
+}
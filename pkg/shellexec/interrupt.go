@@ -0,0 +1,118 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+const (
+	InterruptMethod_Char   = "char"   // wrote the VINTR char (^C), the line discipline sends SIGINT
+	InterruptMethod_Signal = "signal" // sent SIGINT to the foreground process group
+)
+
+// SignalInfo.Target
+const (
+	SignalTarget_Shell      = "shell"      // the shell itself (Signal, SendSignal for remote shells)
+	SignalTarget_Foreground = "foreground" // the pty's foreground process group (SignalForeground), Pid is the pgid
+	SignalTarget_Process    = "process"    // a process of the shell's tree (SignalProcess)
+)
+
+// SignalInfo is published (EventKind_Signaled) after a signal was sent successfully
+type SignalInfo struct {
+	Signal string `json:"signal"` // e.g. "SIGINT"
+	Target string `json:"target"` // SignalTarget_*
+	Pid    int    `json:"pid,omitempty"`
+}
+
+func (sp *ShellProc) publishSignaled(sig syscall.Signal, target string, pid int) {
+	sp.events.publish(ShellEvent{Kind: EventKind_Signaled, Signal: &SignalInfo{Signal: signalName(sig), Target: target, Pid: pid}})
+}
+
+var ErrSignalNotSupported = errors.New("signaling the foreground process group is not supported for this shellproc")
+
+// chooseInterruptMethod picks how to interrupt an app with the given tty modes.  when
+// the line discipline generates signals we send ^C exactly like a keypress would
+// (so the app sees nothing unusual), otherwise the app would just receive a 0x03
+// byte, so we signal the foreground process group directly.
+func chooseInterruptMethod(modes TtyModes) string {
+	if modes.ISig && !modes.IsRaw() && modes.IntrChar != 0 {
+		return InterruptMethod_Char
+	}
+	return InterruptMethod_Signal
+}
+
+// WriteInterruptChar writes the pty's interrupt char (^C unless VINTR was changed or
+// disabled), what happens next is up to the line discipline and the app.
+func (sp *ShellProc) WriteInterruptChar() error {
+	intrChar := sp.ttyModesOrDefault().IntrChar
+	if intrChar == 0 {
+		intrChar = DefaultIntrChar
+	}
+	_, err := sp.Write([]byte{intrChar})
+	return err
+}
+
+// SignalForeground sends sig to the foreground process group of the pty (local shells only)
+func (sp *ShellProc) SignalForeground(sig syscall.Signal) error {
+	if _, ok := localCmdWrap(sp.Cmd); !ok {
+		return ErrSignalNotSupported
+	}
+	var pgid int
+	err := sp.withPtyFd(func(fd uintptr) error {
+		var err error
+		pgid, err = getForegroundPgid(fd)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("cannot get foreground process group: %w", err)
+	}
+	if err := signalPgid(pgid, sig); err != nil {
+		return err
+	}
+	sp.publishSignaled(sig, SignalTarget_Foreground, pgid)
+	return nil
+}
+
+// Interrupt interrupts whatever is running in the foreground of the pty (what the
+// "stop" button does), see chooseInterruptMethod.  Remote shells always get the
+// interrupt char.  Returns the method that was used (InterruptMethod_*).
+func (sp *ShellProc) Interrupt() (string, error) {
+	method := InterruptMethod_Char
+	if modes, err := sp.TtyModes(); err == nil {
+		method = chooseInterruptMethod(modes)
+	}
+	if method == InterruptMethod_Signal {
+		return method, sp.SignalForeground(syscall.SIGINT)
+	}
+	return method, sp.WriteInterruptChar()
+}
+
+// ParseSignal returns the signal for a name like "SIGTSTP" (as sent over rpc)
+func ParseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signalByName(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
+
+// SendSignal delivers sig to what is running in the pty: the foreground process group for
+// local shells (so SIGTSTP and SIGINT work like ^Z and ^C), the remote shell itself for ssh
+// shells (if the server allows it).  Use Interrupt for the stop button, it also works for
+// apps in raw mode that don't check for ^C themselves.
+func (sp *ShellProc) SendSignal(sig syscall.Signal) error {
+	if sp.shellGone() {
+		return ErrShellExited
+	}
+	if _, ok := localCmdWrap(sp.Cmd); ok {
+		return sp.SignalForeground(sig)
+	}
+	if _, ok := sp.Cmd.(signaler); ok {
+		return sp.Signal(sig)
+	}
+	return ErrSignalNotSupported
+}
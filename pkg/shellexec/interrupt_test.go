@@ -0,0 +1,98 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestChooseInterruptMethod(t *testing.T) {
+	if method := chooseInterruptMethod(DefaultTtyModes()); method != InterruptMethod_Char {
+		t.Errorf("canonical mode with ISIG should use the interrupt char, got %q", method)
+	}
+	if method := chooseInterruptMethod(TtyModes{IntrChar: DefaultIntrChar}); method != InterruptMethod_Signal {
+		t.Errorf("raw mode should use a signal, got %q", method)
+	}
+	if method := chooseInterruptMethod(TtyModes{ISig: true}); method != InterruptMethod_Signal {
+		t.Errorf("a disabled VINTR should use a signal, got %q", method)
+	}
+}
+
+func TestInterruptCanonical(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no ptys on windows")
+	}
+	sp := startTestShellProc(t, "echo sleep''ing; sleep 30; echo not-interrupted", CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "sleeping")
+	time.Sleep(50 * time.Millisecond)
+	method, err := sp.Interrupt()
+	if err != nil {
+		t.Fatalf("Interrupt error: %v", err)
+	}
+	if method != InterruptMethod_Char {
+		t.Errorf("expected the interrupt char for a canonical-mode sleep, got %q", method)
+	}
+	status := waitExitStatus(t, sp)
+	if strings.Contains(oc.String(), "not-interrupted") {
+		t.Errorf("sleep was not interrupted, output: %q", oc.String())
+	}
+	if status.Signal != "SIGINT" {
+		t.Errorf("expected the shell to exit from SIGINT, got %+v", status)
+	}
+}
+
+func TestInterruptRawModeTrap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no ptys on windows")
+	}
+	sp := startTestShellProc(t, testHelperCmdStr(t), CommandOptsType{Env: map[string]string{testHelperEnvVar: "rawtrap"}})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready")
+	// the raw-mode app just sees the byte
+	if err := sp.WriteInterruptChar(); err != nil {
+		t.Fatalf("WriteInterruptChar error: %v", err)
+	}
+	oc.waitFor(t, "got:03")
+	method, err := sp.Interrupt()
+	if err != nil {
+		t.Fatalf("Interrupt error: %v", err)
+	}
+	if method != InterruptMethod_Signal {
+		t.Errorf("expected a signal for a raw-mode app, got %q", method)
+	}
+	oc.waitFor(t, "got-sigint")
+	if status := waitExitStatus(t, sp); status.ExitCode != 0 {
+		t.Errorf("app should handle SIGINT and exit cleanly, got %+v", status)
+	}
+}
+
+func TestSendSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no ptys on windows")
+	}
+	sp := startTestShellProc(t, `trap 'echo got-quit' QUIT; echo ready; while :; do sleep 0.02; done`, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready")
+	if err := sp.SendSignal(syscall.SIGQUIT); err != nil {
+		t.Fatalf("error sending SIGQUIT: %v", err)
+	}
+	oc.waitFor(t, "got-quit")
+	sp.Close()
+	waitDone(t, sp)
+	if err := sp.SendSignal(syscall.SIGINT); err != ErrShellExited {
+		t.Errorf("expected ErrShellExited, got %v", err)
+	}
+
+	if _, err := ParseSignal("SIGNOPE"); err == nil {
+		t.Errorf("expected an error for an unknown signal")
+	}
+	if sig, err := ParseSignal("SIGTERM"); err != nil || sig != syscall.SIGTERM {
+		t.Errorf("expected SIGTERM, got %v %v", sig, err)
+	}
+}
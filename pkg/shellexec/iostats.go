@@ -0,0 +1,83 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+)
+
+const DefaultIOStatsInterval = time.Second
+
+// PtyIOStats is returned by ShellProc.IOStats and published (EventKind_IOStats), the rates are
+// only set on events
+type PtyIOStats struct {
+	BytesRead    int64 `json:"bytesread"`           // output read from the pty
+	BytesWritten int64 `json:"byteswritten"`        // input written to the pty (ShellProc.Write)
+	ReadRate     int64 `json:"readrate,omitempty"`  // bytes/sec read since the previous event
+	WriteRate    int64 `json:"writerate,omitempty"` // bytes/sec written since the previous event
+}
+
+// the pty's byte counts, the output loop counts what it reads and ShellProc.Write what it writes
+type ioCounters struct {
+	Read    atomic.Int64
+	Written atomic.Int64
+}
+
+type countingReader struct {
+	Src   io.Reader
+	Count *atomic.Int64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	nr, err := r.Src.Read(p)
+	r.Count.Add(int64(nr))
+	return nr, err
+}
+
+// IOStats returns the bytes read from and written to the pty so far (also after the shell exited)
+func (sp *ShellProc) IOStats() PtyIOStats {
+	return PtyIOStats{BytesRead: sp.ioCounts.Read.Load(), BytesWritten: sp.ioCounts.Written.Load()}
+}
+
+// publishes EventKind_IOStats every interval in which the counts changed, until the shell exits
+func (sp *ShellProc) startIOStatsMonitor(interval time.Duration) {
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = DefaultIOStatsInterval
+	}
+	// (counted from zero, the goroutine may only run once output has been read)
+	var last PtyIOStats
+	lastTime := sp.clock.Now()
+	go func() {
+		defer panichandler.PanicHandler("ShellProc:ioStatsMonitor")
+		for {
+			timerCh, stopFn := sp.clock.NewTimer(interval)
+			select {
+			case <-timerCh:
+			case <-sp.DoneCh:
+				stopFn()
+				return
+			}
+			stats := sp.IOStats()
+			now := sp.clock.Now()
+			if stats == last {
+				lastTime = now
+				continue
+			}
+			if elapsed := now.Sub(lastTime); elapsed > 0 {
+				stats.ReadRate = (stats.BytesRead - last.BytesRead) * int64(time.Second) / int64(elapsed)
+				stats.WriteRate = (stats.BytesWritten - last.BytesWritten) * int64(time.Second) / int64(elapsed)
+			}
+			sp.events.publish(ShellEvent{Kind: EventKind_IOStats, IOStats: &stats})
+			last = PtyIOStats{BytesRead: stats.BytesRead, BytesWritten: stats.BytesWritten}
+			lastTime = now
+		}
+	}()
+}
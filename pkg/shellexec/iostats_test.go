@@ -0,0 +1,40 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIOStats(t *testing.T) {
+	sp := startTestShellProc(t, `read x; printf '%s\n' "$x$x"; sleep 30`, CommandOptsType{IOStatsInterval: 20 * time.Millisecond})
+	eventCh, unsub := sp.SubscribeEvents(0)
+	defer unsub()
+	oc := collectOutput(sp)
+	sp.Write([]byte("0123456789\n"))
+	oc.waitFor(t, "01234567890123456789")
+	stats := sp.IOStats()
+	if stats.BytesWritten != 11 || stats.BytesRead < 20 {
+		t.Errorf("bad stats %+v", stats)
+	}
+	for {
+		event := waitEventKind(t, eventCh, EventKind_IOStats)
+		if event.IOStats.BytesRead < stats.BytesRead {
+			continue
+		}
+		if event.IOStats.BytesWritten != 11 || event.IOStats.ReadRate <= 0 {
+			t.Errorf("bad stats event %+v", event.IOStats)
+		}
+		break
+	}
+	// nothing is published while the counts don't change
+	select {
+	case event := <-eventCh:
+		if event.Kind == EventKind_IOStats && sp.IOStats() == stats {
+			t.Errorf("unexpected stats event %+v", event.IOStats)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package shellexec
+
+// only windows shells have a job object, elsewhere the tree is killed by killProcTree
+func terminateShellJob(cmd ConnInterface) bool {
+	return false
+}
@@ -0,0 +1,19 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package shellexec
+
+// terminates the job object of a local (conpty) shell, false if there is none (the shell is then killed as usual)
+func terminateShellJob(cmd ConnInterface) bool {
+	cw, ok := localCmdWrap(cmd)
+	if !ok {
+		return false
+	}
+	cp, ok := cw.Pty.(*conPty)
+	if !ok {
+		return false
+	}
+	return cp.terminateJob() == nil
+}
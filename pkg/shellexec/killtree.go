@@ -0,0 +1,76 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"os"
+	"sort"
+)
+
+// the shell's descendants, and on linux everything else in its session (rootPid itself is not included).
+// taken before the shell is shut down, once it is gone its orphans are reparented and can't be found from it
+func listProcTree(rootPid int) []auditProc {
+	if rootPid <= 0 {
+		return nil
+	}
+	procs, err := listAuditProcs()
+	if err != nil {
+		return nil
+	}
+	return procTreeOf(procs, rootPid)
+}
+
+func procTreeOf(procs []auditProc, rootPid int) []auditProc {
+	children := make(map[int][]int)
+	for _, proc := range procs {
+		children[proc.Ppid] = append(children[proc.Ppid], proc.Pid)
+	}
+	inTree := make(map[int]bool)
+	queue := []int{rootPid}
+	for idx := 0; idx < len(queue); idx++ {
+		for _, child := range children[queue[idx]] {
+			if !inTree[child] {
+				inTree[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	var rtn []auditProc
+	for _, proc := range procs {
+		if proc.Pid != rootPid && (inTree[proc.Pid] || proc.Sid == rootPid) {
+			rtn = append(rtn, proc)
+		}
+	}
+	sort.Slice(rtn, func(i, j int) bool {
+		return rtn[i].Pid < rtn[j].Pid
+	})
+	return rtn
+}
+
+// kills what is left of the shell's tree (tree, from listProcTree, and whatever has joined its session since),
+// each process is checked again first so a pid that has been reused is left alone.  returns the number killed
+func killProcTree(rootPid int, tree []auditProc) int {
+	if rootPid <= 0 {
+		return 0
+	}
+	procs := append(append([]auditProc(nil), tree...), listProcTree(rootPid)...)
+	killed := make(map[int]bool)
+	for _, proc := range procs {
+		if killed[proc.Pid] || proc.Zombie {
+			continue
+		}
+		current, ok := lookupAuditProc(proc.Pid)
+		if !ok || current.Zombie || !current.StartTime.Equal(proc.StartTime) {
+			continue
+		}
+		osProc, err := os.FindProcess(proc.Pid)
+		if err != nil {
+			continue
+		}
+		if osProc.Kill() == nil {
+			killed[proc.Pid] = true
+		}
+	}
+	return len(killed)
+}
@@ -0,0 +1,81 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestProcTreeOf(t *testing.T) {
+	procs := []auditProc{
+		{Pid: 1, Ppid: 0},
+		{Pid: 10, Ppid: 1, Sid: 10}, // the shell
+		{Pid: 11, Ppid: 10, Sid: 10},
+		{Pid: 12, Ppid: 11, Sid: 12}, // left the session, still a descendant
+		{Pid: 13, Ppid: 1, Sid: 10},  // reparented, still in the session
+		{Pid: 20, Ppid: 1, Sid: 20},
+	}
+	var pids []int
+	for _, proc := range procTreeOf(procs, 10) {
+		pids = append(pids, proc.Pid)
+	}
+	if !reflect.DeepEqual(pids, []int{11, 12, 13}) {
+		t.Errorf("unexpected tree %v", pids)
+	}
+}
+
+var nohupPidRe = regexp.MustCompile(`nohup=(\d+)`)
+
+// starts a shell with a nohup'd sleep (it survives the SIGHUP), returns the sleep's pid
+func startNohupShell(t *testing.T, cmdOpts CommandOptsType) (*ShellProc, int) {
+	t.Helper()
+	sp := startTestShellProc(t, `nohup sleep 300 >/dev/null 2>&1 & echo "nohup=$!"; echo ready; while :; do sleep 0.02; done`, cmdOpts)
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready")
+	match := nohupPidRe.FindStringSubmatch(oc.String())
+	if match == nil {
+		t.Fatalf("no pid in output %q", oc.String())
+	}
+	pid, _ := strconv.Atoi(match[1])
+	return sp, pid
+}
+
+func procRunning(pid int) bool {
+	proc, ok := lookupAuditProc(pid)
+	return ok && !proc.Zombie
+}
+
+func waitProcGone(t *testing.T, pid int) {
+	t.Helper()
+	waitUntil(t, fmt.Sprintf("process %d to exit", pid), func() bool { return !procRunning(pid) })
+}
+
+func TestKillTree(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no nohup on windows")
+	}
+	requireBinary(t, "nohup")
+	sp, pid := startNohupShell(t, CommandOptsType{ShutdownTimeout: 500 * time.Millisecond})
+	sp.Close()
+	waitClosed(t, sp)
+	time.Sleep(100 * time.Millisecond)
+	if !procRunning(pid) {
+		t.Fatalf("expected the nohup'd process to outlive a plain Close")
+	}
+	if osProc, err := os.FindProcess(pid); err == nil {
+		osProc.Kill()
+	}
+
+	sp, pid = startNohupShell(t, CommandOptsType{ShutdownTimeout: 500 * time.Millisecond, KillTree: true})
+	sp.Close()
+	waitClosed(t, sp)
+	waitProcGone(t, pid)
+}
@@ -0,0 +1,270 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+)
+
+func (sp *ShellProc) closeWithReason(reason string) {
+	sp.closeLock.Lock()
+	if sp.closeReason == "" {
+		sp.closeReason = reason
+	}
+	sp.closeLock.Unlock()
+	sp.logf("closing shellproc (%s)\n", reason)
+	sp.Close()
+}
+
+// closed because the context passed to StartShellProcCtx was done
+const CloseReason_ContextDone = "contextdone"
+
+func (sp *ShellProc) closeOnContextDone(ctx context.Context) {
+	go func() {
+		defer panichandler.PanicHandler("ShellProc:closeOnContextDone")
+		select {
+		case <-ctx.Done():
+			sp.closeWithReason(CloseReason_ContextDone)
+		case <-sp.DoneCh:
+		}
+	}()
+}
+
+// Close shuts the shell down gracefully (CommandOptsType.ShutdownSignals, then SIGKILL after
+// ShutdownTimeout), see CloseGraceful
+func (sp *ShellProc) Close() {
+	sp.CloseGraceful(sp.shutdownTimeout)
+}
+
+// once the shell has exited, waits for it and closes the pty after the output has drained
+func (sp *ShellProc) waitAndClosePty() {
+	go func() {
+		defer panichandler.PanicHandler("ShellProc.Close")
+		waitErr := sp.Cmd.Wait()
+		sp.SetWaitErrorAndSignalDone(waitErr)
+		sp.waitOutputDrained()
+
+		// on windows the pty (ConPTY) closes
+		// itself once the process exits
+		// (see startInPty)
+		if runtime.GOOS != "windows" {
+			sp.ptyLock.Lock()
+			defer sp.ptyLock.Unlock()
+			sp.ptyClosed = true
+			sp.Cmd.Close()
+		}
+	}()
+}
+
+// removes the per-shell resources that live outside of the process
+func (sp *ShellProc) cleanupAfterExit() {
+	shellRegistry.ended(sp)
+	if sp.cgroup != nil {
+		sp.cgroup.remove()
+	}
+	if sp.pushEnv != nil {
+		shellutil.RemovePushEnvFiles(sp.pushEnv.Path)
+	}
+	if sp.elevate != nil {
+		sp.elevate.cleanup()
+	}
+	sp.scratch.remove()
+}
+
+func (sp *ShellProc) SetWaitErrorAndSignalDone(waitErr error) {
+	sp.CloseOnce.Do(func() {
+		sp.exitStatus = sp.makeExitStatus(waitErr)
+		sp.exitStatus.TimedOut = sp.CloseReason() == CloseReason_Timeout
+		sp.WaitErr = sp.timeoutWaitErr(waitErr)
+		sp.cleanupAfterExit()
+		close(sp.DoneCh)
+		go func() {
+			defer panichandler.PanicHandler("ShellProc:publishExit")
+			sp.publishExit()
+		}()
+	})
+}
+
+// The exit sequence: once the shell has been waited for, the output loop keeps
+// reading the pty until EOF/EIO (all of the shell's output, including whatever was
+// still buffered in the pty, has then gone to the scrollback and the subscribers),
+// bounded by outputDrainTimeout.  Only then is Exit published and (by Close) the
+// pty closed.
+func (sp *ShellProc) waitOutputDrained() bool {
+	select {
+	case <-sp.outputDone:
+		return true
+	default:
+	}
+	timerCh, stopFn := sp.clock.NewTimer(outputDrainTimeout)
+	defer stopFn()
+	select {
+	case <-sp.outputDone:
+		return true
+	case <-timerCh:
+		sp.logf("warning: shellproc output not done %v after exit, the pty is still open\n", outputDrainTimeout)
+		return false
+	}
+}
+
+func (sp *ShellProc) publishExit() {
+	sp.waitOutputDrained()
+	exitStatus := sp.exitStatus
+	shellMetrics.exited(exitStatus)
+	sp.events.publish(ShellEvent{Kind: EventKind_Exit, ExitStatus: &exitStatus})
+	sp.exitHooks.run(WaitResult{ExitStatus: exitStatus, WaitErr: sp.WaitErr, CloseReason: sp.CloseReason()})
+}
+
+// WaitResult is passed to the OnExit hooks
+type WaitResult struct {
+	ExitStatus  ExitStatus
+	WaitErr     error  // what Wait returns
+	CloseReason string // see CloseReason
+}
+
+type exitHooks struct {
+	Lock   *sync.Mutex
+	Hooks  []func(WaitResult)
+	Result *WaitResult // set once the hooks have been run
+}
+
+func (eh *exitHooks) add(fn func(WaitResult)) {
+	eh.Lock.Lock()
+	if eh.Result == nil {
+		eh.Hooks = append(eh.Hooks, fn)
+		eh.Lock.Unlock()
+		return
+	}
+	result := *eh.Result
+	eh.Lock.Unlock()
+	go func() {
+		defer panichandler.PanicHandler("ShellProc:exitHook")
+		fn(result)
+	}()
+}
+
+func (eh *exitHooks) run(result WaitResult) {
+	eh.Lock.Lock()
+	hooks := eh.Hooks
+	eh.Hooks = nil
+	eh.Result = &result
+	eh.Lock.Unlock()
+	for _, fn := range hooks {
+		func() {
+			defer panichandler.PanicHandler("ShellProc:exitHook")
+			fn(result)
+		}()
+	}
+}
+
+// OnExit registers fn to be called once the shell has exited, after the Exit event (so all of the
+// output has been read, see waitOutputDrained).  The hooks run one after another in the order they
+// were registered, in a goroutine of the shellproc's, a hook registered after the exit is called
+// right away (in its own goroutine).  The owner still has to run WaitProcess
+func (sp *ShellProc) OnExit(fn func(WaitResult)) {
+	sp.exitHooks.add(fn)
+}
+
+// WaitProcess waits for the shell process and then does SetWaitErrorAndSignalDone, a
+// shellproc's owner runs it (once) in its wait loop
+func (sp *ShellProc) WaitProcess() error {
+	waitErr := sp.Cmd.Wait()
+	sp.SetWaitErrorAndSignalDone(waitErr)
+	return waitErr
+}
+
+// Done is closed once the shell has exited and been waited for (see WaitProcess)
+func (sp *ShellProc) Done() <-chan any {
+	return sp.DoneCh
+}
+
+// ExitCode is the exit code reported by the process, -1 while it is running or if it
+// was killed by a signal (ExitStatus has the details)
+func (sp *ShellProc) ExitCode() int {
+	select {
+	case <-sp.DoneCh:
+		return sp.Cmd.ExitCode()
+	default:
+		return -1
+	}
+}
+
+// Wait waits for the owner's WaitProcess to finish and returns the wait error
+func (sp *ShellProc) Wait() error {
+	<-sp.DoneCh
+	return sp.WaitErr
+}
+
+// ErrStillRunning matches (errors.Is) the *StillRunningError returned by WaitCtx and WaitWithTimeout
+var ErrStillRunning = errors.New("shell is still running")
+
+// StillRunningError is returned by WaitCtx (and WaitWithTimeout) when the wait was given up, the
+// shell keeps running (Close it to stop it).  Cause is the context's error
+type StillRunningError struct {
+	SessionId string
+	Cause     error
+}
+
+func (e *StillRunningError) Error() string {
+	return fmt.Sprintf("shell %s is still running: %v", e.SessionId, e.Cause)
+}
+
+func (e *StillRunningError) Unwrap() error {
+	return e.Cause
+}
+
+func (e *StillRunningError) Is(target error) bool {
+	return target == ErrStillRunning
+}
+
+// WaitCtx is Wait, giving up with a *StillRunningError once ctx is done
+func (sp *ShellProc) WaitCtx(ctx context.Context) error {
+	select {
+	case <-sp.DoneCh:
+		return sp.WaitErr
+	default:
+	}
+	select {
+	case <-sp.DoneCh:
+		return sp.WaitErr
+	case <-ctx.Done():
+		return &StillRunningError{SessionId: sp.sessionId, Cause: ctx.Err()}
+	}
+}
+
+// WaitWithTimeout is Wait, giving up with a *StillRunningError (Cause is context.DeadlineExceeded)
+// after timeout
+func (sp *ShellProc) WaitWithTimeout(timeout time.Duration) error {
+	select {
+	case <-sp.DoneCh:
+		return sp.WaitErr
+	default:
+	}
+	timerCh, stopFn := sp.clock.NewTimer(timeout)
+	defer stopFn()
+	select {
+	case <-sp.DoneCh:
+		return sp.WaitErr
+	case <-timerCh:
+		return &StillRunningError{SessionId: sp.sessionId, Cause: context.DeadlineExceeded}
+	}
+}
+
+// returns (done, waitError)
+func (sp *ShellProc) WaitNB() (bool, error) {
+	select {
+	case <-sp.DoneCh:
+		return true, sp.WaitErr
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+// the whole life of an interactive shell through the public API: start, resize,
+// run a command, interrupt one, exit
+func TestShellLifecycle(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no ptys on windows")
+	}
+	sp := startTestShellProc(t, "", CommandOptsType{})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	oc := collectOutput(sp)
+	startWaitLoop(sp)
+
+	if err := sp.SetTermSize(waveobj.TermSize{Rows: 33, Cols: 111}); err != nil {
+		t.Fatalf("error resizing: %v", err)
+	}
+	sp.Write([]byte("stty size; echo \"answer=$((6*7))\"\n"))
+	oc.waitFor(t, "33 111\r\n")
+	oc.waitFor(t, "answer=42\r\n")
+
+	sp.Write([]byte("sleep 30; echo not-''interrupted\n"))
+	oc.waitFor(t, "sleep 30")
+	if _, err := sp.Interrupt(); err != nil {
+		t.Fatalf("error interrupting: %v", err)
+	}
+	sp.Write([]byte("echo \"after-$((1+1))\"\n"))
+	oc.waitFor(t, "after-2\r\n")
+
+	sp.Write([]byte("exit 7\n"))
+	exitEvent := waitEventKind(t, eventCh, EventKind_Exit)
+	if exitEvent.ExitStatus.ExitCode != 7 {
+		t.Errorf("expected exit code 7, got %+v", exitEvent.ExitStatus)
+	}
+	if status, done := sp.ExitStatus(); !done || status.ExitCode != 7 {
+		t.Errorf("unexpected exit status %+v (done %v)", status, done)
+	}
+	<-oc.Done
+	if strings.Contains(oc.String(), "not-interrupted") {
+		t.Errorf("the sleep should have been interrupted")
+	}
+}
+
+func TestShellProcAccessors(t *testing.T) {
+	sp := startTestShellProc(t, `read line; exit 5`, CommandOptsType{})
+	if code := sp.ExitCode(); code != -1 {
+		t.Errorf("expected -1 while running, got %d", code)
+	}
+	if done, _ := sp.WaitNB(); done {
+		t.Errorf("the shell should still be running")
+	}
+	waitErrCh := make(chan error, 1)
+	go func() {
+		waitErrCh <- sp.WaitProcess()
+	}()
+	sp.Write([]byte("\n"))
+	waitDone(t, sp)
+	select {
+	case <-sp.Done():
+	default:
+		t.Errorf("Done should be closed along with DoneCh")
+	}
+	if code := sp.ExitCode(); code != 5 {
+		t.Errorf("expected exit code 5, got %d", code)
+	}
+	waitErr := <-waitErrCh
+	if done, err := sp.WaitNB(); !done || err != waitErr || sp.Wait() != waitErr {
+		t.Errorf("expected WaitNB and Wait to return the WaitProcess error %v, got %v", waitErr, err)
+	}
+	if code := ExitCodeFromWaitErr(waitErr); code != 5 {
+		t.Errorf("expected exit code 5 from the wait error, got %d", code)
+	}
+}
+
+func TestStartShellProcCtx(t *testing.T) {
+	cmdOpts := CommandOptsType{ShellPath: requireBinary(t, "bash")}
+	termSize := waveobj.TermSize{Rows: 24, Cols: 80}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	sp, err := StartShellProcCtx(ctx, termSize, "echo started; sleep 30", cmdOpts)
+	if err != nil {
+		t.Fatalf("error starting shellproc: %v", err)
+	}
+	t.Cleanup(sp.Close)
+	collectOutput(sp).waitFor(t, "started")
+	cancelFn()
+	waitErrCh := make(chan error, 1)
+	go func() {
+		waitErrCh <- sp.Wait()
+	}()
+	select {
+	case <-waitErrCh:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("Wait should return once the context is done")
+	}
+	if reason := sp.CloseReason(); reason != CloseReason_ContextDone {
+		t.Errorf("expected CloseReason_ContextDone, got %q", reason)
+	}
+
+	if _, err := StartShellProcCtx(ctx, termSize, "true", cmdOpts); err != context.Canceled {
+		t.Errorf("expected context.Canceled for a done context, got %v", err)
+	}
+}
+
+func TestOnExit(t *testing.T) {
+	sp := startTestShellProc(t, `read x; printf 'bye\n'; exit 4`, CommandOptsType{})
+	var order []string
+	resultCh := make(chan WaitResult, 1)
+	sp.OnExit(func(WaitResult) {
+		order = append(order, "first")
+	})
+	sp.OnExit(func(result WaitResult) {
+		scrollback, _ := sp.scrollback.snapshot()
+		if !strings.Contains(string(scrollback), "bye") {
+			t.Errorf("the final output should be in the scrollback before the hooks run, got %q", scrollback)
+		}
+		order = append(order, "second")
+		resultCh <- result
+	})
+	startWaitLoop(sp)
+	sp.Write([]byte("\n"))
+	var result WaitResult
+	select {
+	case result = <-resultCh:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timed out waiting for the exit hooks")
+	}
+	if result.ExitStatus.ExitCode != 4 || result.WaitErr == nil || len(order) != 2 || order[0] != "first" {
+		t.Errorf("bad result %+v (hooks ran %v)", result, order)
+	}
+	// registered after the exit, called right away
+	lateCh := make(chan WaitResult, 1)
+	sp.OnExit(func(result WaitResult) { lateCh <- result })
+	select {
+	case late := <-lateCh:
+		if late.ExitStatus != result.ExitStatus {
+			t.Errorf("expected the same result for a late hook, got %+v", late)
+		}
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("a hook registered after the exit was not called")
+	}
+}
+
+func TestWaitCtx(t *testing.T) {
+	sp := startTestShellProc(t, `read x; exit 2`, CommandOptsType{})
+	startWaitLoop(sp)
+	ctx, cancelFn := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFn()
+	err := sp.WaitCtx(ctx)
+	var stillRunning *StillRunningError
+	if !errors.Is(err, ErrStillRunning) || !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &stillRunning) || stillRunning.SessionId != sp.SessionID() {
+		t.Fatalf("expected a still running error, got %v", err)
+	}
+	if err := sp.WaitWithTimeout(20 * time.Millisecond); !errors.Is(err, ErrStillRunning) {
+		t.Fatalf("expected a still running error, got %v", err)
+	}
+	sp.Write([]byte("\n"))
+	if err := sp.WaitWithTimeout(testWaitTimeout); err == nil || errors.Is(err, ErrStillRunning) {
+		t.Errorf("expected the exit error, got %v", err)
+	}
+	if err := sp.WaitCtx(context.Background()); err == nil || errors.Is(err, ErrStillRunning) {
+		t.Errorf("expected the exit error after the exit, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	MaxLinkUriLen     = 2083 // same limit as VTE
+	MaxLinkTextLen    = 1024
+	MaxRecentLinks    = 256
+	oscLinkPrefix     = "8;"
+	linkParamIdPrefix = "id="
+)
+
+// LinkRecord is an OSC 8 hyperlink seen in the output stream.
+// Offsets are stream offsets (total bytes of output before the sequence).
+type LinkRecord struct {
+	Id           string `json:"id,omitempty"`
+	Uri          string `json:"uri"`
+	Text         string `json:"text"`
+	Offset       int64  `json:"offset"`    // offset of the opening OSC 8 sequence
+	EndOffset    int64  `json:"endoffset"` // offset just past the closing OSC 8 sequence
+	Unterminated bool   `json:"unterminated,omitempty"`
+	Ts           int64  `json:"ts"`
+}
+
+type linkTracker struct {
+	Clock   clock
+	Lock    *sync.Mutex
+	Open    *LinkRecord
+	TextBuf *bytes.Buffer
+	Recent  []LinkRecord
+	OnNewFn func(LinkRecord)
+}
+
+func makeLinkTracker(onNewFn func(LinkRecord)) *linkTracker {
+	return &linkTracker{Clock: realClock{}, Lock: &sync.Mutex{}, TextBuf: &bytes.Buffer{}, OnNewFn: onNewFn}
+}
+
+// parses an OSC 8 payload ("8;params;uri"), returns ok=false if this is not an OSC 8 sequence
+func parseOsc8(payload []byte) (id string, uri string, ok bool) {
+	if !bytes.HasPrefix(payload, []byte(oscLinkPrefix)) {
+		return "", "", false
+	}
+	rest := string(payload[len(oscLinkPrefix):])
+	params, uri, found := strings.Cut(rest, ";")
+	if !found {
+		return "", "", false
+	}
+	for _, param := range strings.Split(params, ":") {
+		if strings.HasPrefix(param, linkParamIdPrefix) {
+			id = param[len(linkParamIdPrefix):]
+		}
+	}
+	return id, uri, true
+}
+
+// called for every token, offset is the stream offset of the token
+func (lt *linkTracker) handleToken(tok seqToken, offset int64) {
+	if tok.Type == tokType_Text {
+		lt.Lock.Lock()
+		defer lt.Lock.Unlock()
+		if lt.Open == nil {
+			return
+		}
+		lt.appendText(tok.Raw)
+		if lt.TextBuf.Len() >= MaxLinkTextLen {
+			// most likely the application died mid-link, stop tracking it
+			lt.closeLink(offset+int64(len(tok.Raw)), true)
+		}
+		return
+	}
+	if tok.Type != tokType_OSC || tok.Overflow || tok.Unterminated {
+		return
+	}
+	id, uri, ok := parseOsc8(tok.Payload)
+	if !ok {
+		return
+	}
+	lt.Lock.Lock()
+	defer lt.Lock.Unlock()
+	if lt.Open != nil {
+		// a new OSC 8 (open or close) always ends the current link
+		endOffset := offset
+		if uri == "" {
+			endOffset = offset + int64(len(tok.Raw))
+		}
+		lt.closeLink(endOffset, false)
+	}
+	if uri == "" || len(uri) > MaxLinkUriLen {
+		return
+	}
+	lt.Open = &LinkRecord{Id: id, Uri: uri, Offset: offset, Ts: lt.Clock.Now().UnixMilli()}
+	lt.TextBuf.Reset()
+}
+
+// only keeps printable text (drops control characters like \r and \n)
+func (lt *linkTracker) appendText(data []byte) {
+	for _, ch := range data {
+		if lt.TextBuf.Len() >= MaxLinkTextLen {
+			return
+		}
+		if ch < 0x20 || ch == 0x7f {
+			continue
+		}
+		lt.TextBuf.WriteByte(ch)
+	}
+}
+
+// must hold Lock
+func (lt *linkTracker) closeLink(endOffset int64, unterminated bool) {
+	rec := *lt.Open
+	lt.Open = nil
+	rec.Text = strings.ToValidUTF8(lt.TextBuf.String(), string(utf8.RuneError))
+	rec.EndOffset = endOffset
+	rec.Unterminated = unterminated
+	lt.TextBuf.Reset()
+	if rec.Id != "" {
+		// cells with the same id and uri are the same link (e.g. a link that was wrapped across lines)
+		for idx := len(lt.Recent) - 1; idx >= 0; idx-- {
+			prev := &lt.Recent[idx]
+			if prev.Id == rec.Id && prev.Uri == rec.Uri {
+				prev.Text += rec.Text
+				prev.EndOffset = rec.EndOffset
+				prev.Unterminated = rec.Unterminated
+				return
+			}
+		}
+	}
+	lt.Recent = append(lt.Recent, rec)
+	if len(lt.Recent) > MaxRecentLinks {
+		lt.Recent = lt.Recent[len(lt.Recent)-MaxRecentLinks:]
+	}
+	if lt.OnNewFn != nil {
+		lt.OnNewFn(rec)
+	}
+}
+
+// called when the output stream ends, any open link is recorded as unterminated
+func (lt *linkTracker) flush(offset int64) {
+	lt.Lock.Lock()
+	defer lt.Lock.Unlock()
+	if lt.Open != nil {
+		lt.closeLink(offset, true)
+	}
+}
+
+func (lt *linkTracker) recentLinks(max int) []LinkRecord {
+	lt.Lock.Lock()
+	defer lt.Lock.Unlock()
+	start := 0
+	if max > 0 && len(lt.Recent) > max {
+		start = len(lt.Recent) - max
+	}
+	rtn := make([]LinkRecord, len(lt.Recent)-start)
+	copy(rtn, lt.Recent[start:])
+	return rtn
+}
+
+// RecentLinks returns up to max of the most recently completed OSC 8 hyperlinks
+// (oldest first).  max <= 0 returns all retained links.
+func (sp *ShellProc) RecentLinks(max int) []LinkRecord {
+	return sp.output.Links.recentLinks(max)
+}
@@ -0,0 +1,100 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func feedOutput(oh *outputHandler, chunks ...string) string {
+	var outBuf bytes.Buffer
+	for _, chunk := range chunks {
+		oh.processData([]byte(chunk), &outBuf)
+	}
+	return outBuf.String()
+}
+
+func osc8(params string, uri string) string {
+	return "\x1b]8;" + params + ";" + uri + "\x1b\\"
+}
+
+func TestLinksPassThrough(t *testing.T) {
+	oh := makeOutputHandler(makeEventHub())
+	input := "hello " + osc8("", "https://example.com") + "\x1b[1mexample\x1b[0m" + osc8("", "") + " world\a\r\n"
+	// feed one byte at a time to make sure partial sequences are handled
+	var chunks []string
+	for _, ch := range []byte(input) {
+		chunks = append(chunks, string([]byte{ch}))
+	}
+	output := feedOutput(oh, chunks...)
+	if output != input {
+		t.Fatalf("output was modified: %q", output)
+	}
+	links := oh.Links.recentLinks(0)
+	if len(links) != 1 {
+		t.Fatalf("expected 1 link, got %d", len(links))
+	}
+	link := links[0]
+	if link.Uri != "https://example.com" || link.Text != "example" {
+		t.Errorf("bad link: %#v", link)
+	}
+	if link.Offset != int64(len("hello ")) {
+		t.Errorf("bad offset: %d", link.Offset)
+	}
+	if link.EndOffset != int64(strings.Index(input, " world")) {
+		t.Errorf("bad end offset: %d", link.EndOffset)
+	}
+}
+
+func TestLinksIdMerge(t *testing.T) {
+	oh := makeOutputHandler(makeEventHub())
+	feedOutput(oh,
+		osc8("id=x1", "file:///tmp/a.txt"), "a.t", osc8("", ""), "\r\n",
+		osc8("id=x1", "file:///tmp/a.txt"), "xt", osc8("", ""),
+		osc8("id=x2", "file:///tmp/a.txt"), "other", osc8("", ""),
+	)
+	links := oh.Links.recentLinks(0)
+	if len(links) != 2 {
+		t.Fatalf("expected 2 links, got %d: %#v", len(links), links)
+	}
+	if links[0].Id != "x1" || links[0].Text != "a.txt" {
+		t.Errorf("bad merged link: %#v", links[0])
+	}
+	if links[1].Id != "x2" || links[1].Text != "other" {
+		t.Errorf("bad second link: %#v", links[1])
+	}
+}
+
+func TestLinksUnterminatedAndLimits(t *testing.T) {
+	events := makeEventHub()
+	eventCh, unsubFn := events.subscribe(10)
+	defer unsubFn()
+	oh := makeOutputHandler(events)
+	longUri := "https://example.com/" + strings.Repeat("x", MaxLinkUriLen)
+	feedOutput(oh, osc8("", longUri), "too long", osc8("", ""))
+	if len(oh.Links.recentLinks(0)) != 0 {
+		t.Fatalf("over-long uri should not be recorded")
+	}
+	feedOutput(oh, osc8("", "https://crash.example"), "panic: oh no")
+	var outBuf bytes.Buffer
+	oh.flush(&outBuf)
+	links := oh.Links.recentLinks(0)
+	if len(links) != 1 || !links[0].Unterminated || links[0].Text != "panic: oh no" {
+		t.Fatalf("expected one unterminated link, got %#v", links)
+	}
+	select {
+	case event := <-eventCh:
+		if event.Kind != EventKind_Link || event.Link.Uri != "https://crash.example" {
+			t.Errorf("bad event: %#v", event)
+		}
+	case <-time.After(testWaitTimeout):
+		t.Errorf("expected a link event")
+	}
+	if got := len(oh.Links.recentLinks(1)); got != 1 {
+		t.Errorf("recentLinks(1) returned %d links", got)
+	}
+}
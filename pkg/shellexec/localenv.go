@@ -0,0 +1,102 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+	"github.com/wavetermdev/waveterm/pkg/wavebase"
+)
+
+// buildLocalEnv sets ecmd.Env for a local shell from its layers (inherited, envfilter, runas, integration,
+// waveshell, history, cmdopts), ecmd.Dir must already be set (the history file can depend on it)
+// wenv is the warm pool's cached env, nil to build everything from scratch.  with CleanEnv there is
+// no inherited layer, LANG is then always set.  runAsEnv is from runAsEnvVars
+func buildLocalEnv(ecmd *exec.Cmd, cmdOpts CommandOptsType, family shellutil.ShellFamily, integrationEnv map[string]string, runAsEnv map[string]string, wenv *warmEnv) (shellutil.EnvReport, error) {
+	if err := cmdOpts.EnvFilter.Validate(); err != nil {
+		return shellutil.EnvReport{}, err
+	}
+	var waveshellEnv map[string]string
+	if wenv != nil {
+		waveshellEnv = wenv.Waveshell
+	} else {
+		waveshellEnv = shellutil.WaveshellLocalEnvVars(shellutil.DefaultTermType)
+		if os.Getenv("LANG") == "" {
+			waveshellEnv["LANG"] = wavebase.DetermineLang()
+		}
+	}
+	if cmdOpts.TermType != "" && waveshellEnv["TERM"] != cmdOpts.TermType {
+		waveshellEnv = maps.Clone(waveshellEnv) // (the warm env's is shared)
+		waveshellEnv["TERM"] = cmdOpts.TermType
+	}
+	if cmdOpts.CleanEnv && waveshellEnv["LANG"] == "" {
+		waveshellEnv = maps.Clone(waveshellEnv) // (the warm env's is shared)
+		waveshellEnv["LANG"] = cleanEnvLang()
+	}
+	var historyEnv map[string]string
+	// (elevated and run-as shells can't write our history files)
+	if !cmdOpts.Elevate && cmdOpts.Uid == nil {
+		var err error
+		historyEnv, err = shellutil.HistoryEnvVars(cmdOpts.HistoryScope, family, cmdOpts.BlockId, ecmd.Dir)
+		if err != nil {
+			return shellutil.EnvReport{}, err
+		}
+	}
+	envLayers := []shellutil.EnvLayer{
+		cmdOpts.EnvFilter.Layer(),
+		{Name: shellutil.EnvLayer_RunAs, Vars: runAsEnv},
+		{Name: shellutil.EnvLayer_Integration, Vars: integrationEnv},
+		{Name: shellutil.EnvLayer_Waveshell, Vars: waveshellEnv},
+		{Name: shellutil.EnvLayer_History, Vars: historyEnv},
+		{Name: shellutil.EnvLayer_CmdOpts, Vars: cmdOpts.Env},
+	}
+	var envReport shellutil.EnvReport
+	if cmdOpts.CleanEnv {
+		ecmd.Env, envReport = shellutil.BuildEnv(envLayers...)
+	} else if wenv != nil {
+		ecmd.Env, envReport = wenv.Inherited.Build(envLayers...)
+	} else {
+		inheritedLayer := shellutil.EnvLayer{Name: shellutil.EnvLayer_Inherited, Environ: os.Environ()}
+		ecmd.Env, envReport = shellutil.BuildEnv(append([]shellutil.EnvLayer{inheritedLayer}, envLayers...)...)
+	}
+	envCheckOpts := shellutil.EnvCheckOpts{MaxValueSize: cmdOpts.MaxEnvValueSize, DropOversized: cmdOpts.DropOversizedEnv}
+	if err := shellutil.ValidateCmdEnv(ecmd, envCheckOpts); err != nil {
+		return shellutil.EnvReport{}, fmt.Errorf("cannot start shell: %w", err)
+	}
+	return envReport, nil
+}
+
+// ourEnvNames returns the names (as they are in ecmd.Env) of the vars buildLocalEnv set from our own layers
+// (integration, waveshell, cmdopts), for the backends that pass them on (wsl, containers)
+func ourEnvNames(ecmd *exec.Cmd, envReport shellutil.EnvReport) []string {
+	ours := make(map[string]bool)
+	for _, name := range envReport.FromLayers(shellutil.EnvLayer_Integration, shellutil.EnvLayer_Waveshell, shellutil.EnvLayer_CmdOpts) {
+		ours[strings.ToUpper(name)] = true
+	}
+	var rtn []string
+	for _, envStr := range ecmd.Env {
+		if name, _, found := strings.Cut(envStr, "="); found && ours[strings.ToUpper(name)] {
+			rtn = append(rtn, name)
+		}
+	}
+	return rtn
+}
+
+// ours (a clean env doesn't inherit it), or the os default
+func cleanEnvLang() string {
+	if lang := os.Getenv("LANG"); lang != "" {
+		return lang
+	}
+	if lang := wavebase.DetermineLang(); lang != "" {
+		return lang
+	}
+	return cleanEnvDefaultLang
+}
+
+const cleanEnvDefaultLang = "C.UTF-8"
@@ -0,0 +1,97 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+)
+
+func TestBuildLocalEnv(t *testing.T) {
+	t.Setenv("WAVE_TEST_INHERITED", "inherited")
+	ecmd := exec.Command("true")
+	ecmd.Dir = t.TempDir()
+	cmdOpts := CommandOptsType{
+		HistoryScope: shellutil.HistoryScope_PerBlock,
+		BlockId:      "block-1",
+		Env:          map[string]string{"WAVE_TEST_INTEGRATION": "cmdopts"},
+	}
+	integrationEnv := map[string]string{"WAVE_TEST_INTEGRATION": "integration", WaveSessionIdVarName: "test-session"}
+	report, err := buildLocalEnv(ecmd, cmdOpts, shellutil.ShellFamily_Bash, integrationEnv, nil, nil)
+	if err != nil {
+		t.Fatalf("error building env: %v", err)
+	}
+	expectedWinners := map[string]string{
+		"WAVE_TEST_INHERITED":   shellutil.EnvLayer_Inherited,
+		WaveSessionIdVarName:    shellutil.EnvLayer_Integration,
+		"TERM_PROGRAM":          shellutil.EnvLayer_Waveshell,
+		"HISTFILE":              shellutil.EnvLayer_History,
+		"WAVE_TEST_INTEGRATION": shellutil.EnvLayer_CmdOpts,
+	}
+	for name, layer := range expectedWinners {
+		if got := report.WonBy(name); got != layer {
+			t.Errorf("%s: expected %q to win, got %q", name, layer, got)
+		}
+	}
+	if val, ok := cmdEnvLookup(ecmd, "WAVE_TEST_INTEGRATION"); !ok || val != "cmdopts" {
+		t.Errorf("expected ecmd.Env to have the cmdopts value, got %q", val)
+	}
+
+	// elevated shells keep their history where it is
+	ecmd = exec.Command("true")
+	cmdOpts.Elevate = true
+	report, err = buildLocalEnv(ecmd, cmdOpts, shellutil.ShellFamily_Bash, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("error building env: %v", err)
+	}
+	if len(report.FromLayers(shellutil.EnvLayer_History)) != 0 {
+		t.Errorf("expected no history vars for an elevated shell")
+	}
+
+	cmdOpts = CommandOptsType{HistoryScope: "nowhere"}
+	if _, err := buildLocalEnv(exec.Command("true"), cmdOpts, shellutil.ShellFamily_Bash, nil, nil, nil); err == nil {
+		t.Errorf("expected an error for an invalid history scope")
+	}
+	cmdOpts = CommandOptsType{Env: map[string]string{"WAVE_TEST_HUGE": strings.Repeat("x", 1024)}, MaxEnvValueSize: 100, DropOversizedEnv: true}
+	ecmd = exec.Command("true")
+	if _, err := buildLocalEnv(ecmd, cmdOpts, shellutil.ShellFamily_Bash, nil, nil, nil); err != nil {
+		t.Fatalf("error building env: %v", err)
+	}
+	if _, ok := cmdEnvLookup(ecmd, "WAVE_TEST_HUGE"); ok {
+		t.Errorf("expected the oversized value to be dropped")
+	}
+
+	t.Setenv("WAVE_TEST_SECRET_TOKEN", "secret")
+	cmdOpts = CommandOptsType{EnvFilter: &shellutil.EnvFilter{Deny: []string{"WAVE_TEST_*"}}, Env: map[string]string{"WAVE_TEST_MINE": "mine"}}
+	ecmd = exec.Command("true")
+	if report, err = buildLocalEnv(ecmd, cmdOpts, shellutil.ShellFamily_Bash, nil, nil, nil); err != nil {
+		t.Fatalf("error building env: %v", err)
+	}
+	if _, ok := cmdEnvLookup(ecmd, "WAVE_TEST_SECRET_TOKEN"); ok || report.Vars["WAVE_TEST_SECRET_TOKEN"].RemovedBy != shellutil.EnvLayer_Filter {
+		t.Errorf("expected the inherited var to be filtered out")
+	}
+	if _, ok := cmdEnvLookup(ecmd, "WAVE_TEST_MINE"); !ok {
+		t.Errorf("expected the cmdopts var to be kept")
+	}
+	cmdOpts = CommandOptsType{CleanEnv: true, Env: map[string]string{"WAVE_TEST_MINE": "mine"}}
+	ecmd = exec.Command("true")
+	if report, err = buildLocalEnv(ecmd, cmdOpts, shellutil.ShellFamily_Bash, integrationEnv, nil, nil); err != nil {
+		t.Fatalf("error building env: %v", err)
+	}
+	if len(report.FromLayers(shellutil.EnvLayer_Inherited)) != 0 || report.WonBy("PATH") != "" {
+		t.Errorf("expected nothing to be inherited, got %v", report.FromLayers(shellutil.EnvLayer_Inherited))
+	}
+	for _, name := range []string{"TERM", "LANG", "WAVE_TEST_MINE", WaveSessionIdVarName} {
+		if _, ok := cmdEnvLookup(ecmd, name); !ok {
+			t.Errorf("expected %s to be set in a clean env", name)
+		}
+	}
+	cmdOpts = CommandOptsType{EnvFilter: &shellutil.EnvFilter{Allow: []string{"[BAD"}}}
+	if _, err := buildLocalEnv(exec.Command("true"), cmdOpts, shellutil.ShellFamily_Bash, nil, nil, nil); err == nil {
+		t.Errorf("expected an error for an invalid filter pattern")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// why a shell couldn't be started (the stage label of shellexec_start_failures_total)
+const (
+	StartFailure_Pty   = "pty"   // the pty couldn't be opened (e.g. out of ptys), not detected on windows
+	StartFailure_Spawn = "spawn" // the shell process couldn't be started
+)
+
+// metricsRegistry holds the process-wide shellexec counters, WriteMetrics exports them (and the
+// per shellproc ones) in the prometheus text format
+type metricsRegistry struct {
+	Lock          *sync.Mutex
+	Spawned       map[string]int64 // by backend ("local" or "remote")
+	StartFailures map[string]int64 // by StartFailure_*
+	Exits         map[string]int64 // by exit code, see exitCodeLabel
+}
+
+var shellMetrics = &metricsRegistry{
+	Lock:          &sync.Mutex{},
+	Spawned:       make(map[string]int64),
+	StartFailures: make(map[string]int64),
+	Exits:         make(map[string]int64),
+}
+
+func (reg *metricsRegistry) inc(counts map[string]int64, label string) {
+	reg.Lock.Lock()
+	defer reg.Lock.Unlock()
+	counts[label]++
+}
+
+func (reg *metricsRegistry) get(counts map[string]int64, label string) int64 {
+	reg.Lock.Lock()
+	defer reg.Lock.Unlock()
+	return counts[label]
+}
+
+func (reg *metricsRegistry) spawned(connName string) {
+	backend := "local"
+	if connName != "" {
+		backend = "remote"
+	}
+	reg.inc(reg.Spawned, backend)
+}
+
+func (reg *metricsRegistry) startFailed(stage string) {
+	reg.inc(reg.StartFailures, stage)
+}
+
+func (reg *metricsRegistry) exited(status ExitStatus) {
+	reg.inc(reg.Exits, exitCodeLabel(status.ExitCode))
+}
+
+// keeps the exit code label's cardinality bounded (windows codes and NTSTATUS values are 32 bits)
+func exitCodeLabel(exitCode int) string {
+	if exitCode < 0 || exitCode > 255 {
+		return "other"
+	}
+	return strconv.Itoa(exitCode)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type metricSample struct {
+	LabelName  string // "" for no label
+	LabelValue string
+	Value      int64
+}
+
+func writeMetric(w io.Writer, name string, metricType string, help string, samples []metricSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	slices.SortFunc(samples, func(a, b metricSample) int { return strings.Compare(a.LabelValue, b.LabelValue) })
+	for _, sample := range samples {
+		if sample.LabelName == "" {
+			fmt.Fprintf(w, "%s %d\n", name, sample.Value)
+			continue
+		}
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, sample.LabelName, labelValueReplacer.Replace(sample.LabelValue), sample.Value)
+	}
+}
+
+func (reg *metricsRegistry) samples(counts map[string]int64, labelName string) []metricSample {
+	reg.Lock.Lock()
+	defer reg.Lock.Unlock()
+	rtn := make([]metricSample, 0, len(counts))
+	for label, count := range counts {
+		rtn = append(rtn, metricSample{LabelName: labelName, LabelValue: label, Value: count})
+	}
+	return rtn
+}
+
+// WriteMetrics writes the shellexec metrics in the prometheus text exposition format: the running
+// shellprocs, the shells started (rate() of it is the spawn rate) and the ones that failed to start,
+// the exit codes, and the bytes read from and written to each running shellproc's pty (labeled by
+// session id, so series end when the shell does)
+func WriteMetrics(w io.Writer) {
+	procs := shellRegistry.procs()
+	writeMetric(w, "shellexec_active_procs", "gauge", "The number of running shellprocs.",
+		[]metricSample{{Value: int64(len(procs))}})
+	writeMetric(w, "shellexec_spawned_total", "counter", "Shellprocs started, by backend.",
+		shellMetrics.samples(shellMetrics.Spawned, "backend"))
+	writeMetric(w, "shellexec_start_failures_total", "counter", "Shells that could not be started, by stage.",
+		shellMetrics.samples(shellMetrics.StartFailures, "stage"))
+	writeMetric(w, "shellexec_exits_total", "counter", "Shellprocs that exited, by exit code.",
+		shellMetrics.samples(shellMetrics.Exits, "code"))
+	var readSamples, writtenSamples []metricSample
+	for _, sp := range procs {
+		stats := sp.IOStats()
+		readSamples = append(readSamples, metricSample{LabelName: "sessionid", LabelValue: sp.sessionId, Value: stats.BytesRead})
+		writtenSamples = append(writtenSamples, metricSample{LabelName: "sessionid", LabelValue: sp.sessionId, Value: stats.BytesWritten})
+	}
+	writeMetric(w, "shellexec_proc_read_bytes_total", "counter", "Bytes read from a running shellproc's pty.", readSamples)
+	writeMetric(w, "shellexec_proc_written_bytes_total", "counter", "Bytes written to a running shellproc's pty.", writtenSamples)
+}
+
+// HandleMetrics serves WriteMetrics (for a prometheus scrape, see web.RunMetricsServer)
+func HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteMetrics(w)
+}
@@ -0,0 +1,65 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+func TestMetrics(t *testing.T) {
+	spawned := shellMetrics.get(shellMetrics.Spawned, "local")
+	exits := shellMetrics.get(shellMetrics.Exits, "7")
+	sp := startTestShellProc(t, `read x; printf 'got-%s\n' "$x"; read y; exit 7`, CommandOptsType{})
+	startWaitLoop(sp)
+	eventCh, unsub := sp.SubscribeEvents(0)
+	defer unsub()
+	oc := collectOutput(sp)
+	sp.Write([]byte("abc\n"))
+	oc.waitFor(t, "got-abc")
+
+	var buf strings.Builder
+	WriteMetrics(&buf)
+	metrics := buf.String()
+	for _, line := range []string{
+		"# TYPE shellexec_active_procs gauge\n",
+		fmt.Sprintf("shellexec_spawned_total{backend=\"local\"} %d\n", spawned+1),
+		fmt.Sprintf("shellexec_proc_written_bytes_total{sessionid=\"%s\"} 4\n", sp.sessionId),
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("expected %q in the metrics:\n%s", line, metrics)
+		}
+	}
+	sp.Write([]byte("\n"))
+	waitEventKind(t, eventCh, EventKind_Exit)
+	if got := shellMetrics.get(shellMetrics.Exits, "7"); got != exits+1 {
+		t.Errorf("expected %d exits with code 7, got %d", exits+1, got)
+	}
+
+	// exists, but can't be executed
+	failures := shellMetrics.get(shellMetrics.StartFailures, StartFailure_Spawn)
+	notExec := filepath.Join(t.TempDir(), "bash")
+	if err := os.WriteFile(notExec, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StartShellProc(waveobj.TermSize{Rows: 24, Cols: 80}, "true", CommandOptsType{ShellPath: notExec, FallbackShells: []string{}}); err == nil {
+		t.Fatalf("expected an error starting a shell that isn't executable")
+	}
+	if got := shellMetrics.get(shellMetrics.StartFailures, StartFailure_Spawn); got != failures+1 {
+		t.Errorf("expected the spawn failure to be counted, got %d (was %d)", got, failures)
+	}
+}
+
+func TestExitCodeLabel(t *testing.T) {
+	for code, expected := range map[int]string{0: "0", 130: "130", 255: "255", -1: "other", 256: "other", 0xC0000005: "other"} {
+		if label := exitCodeLabel(code); label != expected {
+			t.Errorf("exitCodeLabel(%d) = %q, expected %q", code, label, expected)
+		}
+	}
+}
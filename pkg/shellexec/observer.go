@@ -0,0 +1,92 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"sync"
+)
+
+type ObserverOpts struct {
+	ReplayBytes   int // max scrollback bytes replayed on attach, zero replays all of the retained scrollback, negative none
+	OutputBufSize int // in chunks (see OutputSubOpts.BufSize)
+	EventBufSize  int // see SubscribeEvents
+}
+
+// ObserverHandle is a read-only view of a ShellProc (e.g. a second frontend
+// watching someone's terminal), it has no way to write to the shell.
+//
+// Output first gets the replayed scrollback as a single chunk (starting at
+// ReplayOffset) and then the live output with no gap or overlap, it is closed like
+// an OutputSubscription (when the output ends, or with ErrOutputSubOverflow if the
+// observer falls behind).  Events gets the shell's events (resizes, observer
+// counts, the exit).  Detach must be called when the observer goes away.
+type ObserverHandle struct {
+	Output       <-chan OutputChunk
+	Events       <-chan ShellEvent
+	ReplayOffset int64 // stream offset of the first byte on Output
+
+	obs *observer
+}
+
+type observer struct {
+	Sp          *ShellProc
+	Sub         *OutputSubscription
+	UnsubEvents func()
+	DetachOnce  *sync.Once
+}
+
+// Err returns why Output was closed (see OutputSubscription.Err)
+func (h ObserverHandle) Err() error {
+	return h.obs.Sub.Err()
+}
+
+// Detach closes Output and Events and removes the observer from the count, safe to call more than once
+func (h ObserverHandle) Detach() {
+	h.obs.DetachOnce.Do(func() {
+		h.obs.Sub.Close()
+		h.obs.UnsubEvents()
+		h.obs.Sp.addObservers(-1)
+	})
+}
+
+// AttachObserver attaches a read-only observer (see ObserverHandle).  The number
+// of attached observers is in ObserverCount and EventKind_Observers events, so the
+// shell's user can see they are being watched.  Returns ErrShellExited if the shell
+// is gone.
+func (sp *ShellProc) AttachObserver(opts ObserverOpts) (ObserverHandle, error) {
+	if sp.shellGone() {
+		return ObserverHandle{}, ErrShellExited
+	}
+	// subscribed to events first so the observer sees its own count
+	eventCh, unsubEvents := sp.events.subscribe(opts.EventBufSize)
+	var replayOffset int64
+	sub := sp.outputSubs.subscribeWithReplay(OutputSubOpts{BufSize: opts.OutputBufSize}, func(startOffset int64) ([]byte, int64) {
+		if opts.ReplayBytes < 0 {
+			replayOffset = startOffset
+			return nil, startOffset
+		}
+		var data []byte
+		data, replayOffset = sp.scrollback.replayTo(startOffset, opts.ReplayBytes)
+		return data, replayOffset
+	})
+	sp.addObservers(1)
+	obs := &observer{Sp: sp, Sub: sub, UnsubEvents: unsubEvents, DetachOnce: &sync.Once{}}
+	return ObserverHandle{Output: sub.Ch, Events: eventCh, ReplayOffset: replayOffset, obs: obs}, nil
+}
+
+// ObserverCount returns the number of attached observers (see AttachObserver)
+func (sp *ShellProc) ObserverCount() int {
+	sp.observerLock.Lock()
+	defer sp.observerLock.Unlock()
+	return sp.observerCount
+}
+
+// published under the lock so the counts in the events are in order
+func (sp *ShellProc) addObservers(delta int) {
+	sp.observerLock.Lock()
+	defer sp.observerLock.Unlock()
+	sp.observerCount += delta
+	count := sp.observerCount
+	sp.events.publish(ShellEvent{Kind: EventKind_Observers, Observers: &count})
+}
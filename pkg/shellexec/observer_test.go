@@ -0,0 +1,138 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type observedOutput struct {
+	Data   []byte
+	Gaps   int // chunks that didn't start where the previous one ended
+	DoneCh chan struct{}
+}
+
+func collectObserver(h ObserverHandle) *observedOutput {
+	oo := &observedOutput{DoneCh: make(chan struct{})}
+	go func() {
+		defer close(oo.DoneCh)
+		next := h.ReplayOffset
+		for chunk := range h.Output {
+			if chunk.Offset != next {
+				oo.Gaps++
+			}
+			next = chunk.Offset + int64(chunk.Len)
+			oo.Data = append(oo.Data, chunk.Data...)
+		}
+	}()
+	return oo
+}
+
+func waitObserverCount(t *testing.T, eventCh <-chan ShellEvent, count int) {
+	t.Helper()
+	for {
+		event := waitEventKind(t, eventCh, EventKind_Observers)
+		if *event.Observers == count {
+			return
+		}
+	}
+}
+
+func TestObserversConverge(t *testing.T) {
+	cmdStr := `for i in $(seq 1 40); do for j in $(seq 1 50); do echo "line-$i-$j"; done; sleep 0.02; done`
+	sp := startTestShellProc(t, cmdStr, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "line-2-1\r\n")
+	obs1, err := sp.AttachObserver(ObserverOpts{ReplayBytes: 100, OutputBufSize: 4096})
+	if err != nil {
+		t.Fatalf("error attaching: %v", err)
+	}
+	defer obs1.Detach()
+	out1 := collectObserver(obs1)
+	oc.waitFor(t, "line-20-1\r\n")
+	obs2, err := sp.AttachObserver(ObserverOpts{ReplayBytes: 100, OutputBufSize: 4096})
+	if err != nil {
+		t.Fatalf("error attaching: %v", err)
+	}
+	defer obs2.Detach()
+	out2 := collectObserver(obs2)
+	if sp.ObserverCount() != 2 {
+		t.Errorf("expected 2 observers, got %d", sp.ObserverCount())
+	}
+	waitObserverCount(t, obs1.Events, 2)
+
+	startWaitLoop(sp)
+	waitEventKind(t, obs1.Events, EventKind_Exit)
+	waitEventKind(t, obs2.Events, EventKind_Exit)
+	<-out1.DoneCh
+	<-out2.DoneCh
+	if out1.Gaps != 0 || out2.Gaps != 0 {
+		t.Errorf("observer streams have gaps (%d, %d)", out1.Gaps, out2.Gaps)
+	}
+	if obs2.ReplayOffset <= obs1.ReplayOffset+100 {
+		t.Fatalf("expected the second observer to attach later, offsets %d and %d", obs1.ReplayOffset, obs2.ReplayOffset)
+	}
+	scrollback, scrollbackOffset := sp.scrollback.snapshot()
+	if scrollbackOffset != 0 {
+		t.Fatalf("scrollback is not complete")
+	}
+	for idx, oo := range []*observedOutput{out1, out2} {
+		start := []int64{obs1.ReplayOffset, obs2.ReplayOffset}[idx]
+		if !bytes.Equal(oo.Data, scrollback[start:]) {
+			t.Errorf("observer %d doesn't match the output stream from offset %d (got %d bytes, expected %d)", idx+1, start, len(oo.Data), len(scrollback)-int(start))
+		}
+	}
+	// where they overlap both saw the same bytes
+	if tail := out1.Data[obs2.ReplayOffset-obs1.ReplayOffset:]; !bytes.Equal(tail, out2.Data) {
+		t.Errorf("observer streams diverge after the second attach")
+	}
+	if !bytes.HasSuffix(out1.Data, []byte("line-40-50\r\n")) {
+		t.Errorf("observer is missing the final output")
+	}
+}
+
+func TestObserverDetach(t *testing.T) {
+	sp := startTestShellProc(t, `while true; do echo "busy output line"; done`, CommandOptsType{})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	collectOutput(sp)
+	var handles []ObserverHandle
+	for i := 0; i < 4; i++ {
+		h, err := sp.AttachObserver(ObserverOpts{ReplayBytes: -1})
+		if err != nil {
+			t.Fatalf("error attaching: %v", err)
+		}
+		handles = append(handles, h)
+	}
+	out := collectObserver(handles[3])
+	waitObserverCount(t, eventCh, 4)
+	// detach while output is still flowing, the first three never read at all
+	for _, h := range handles[:3] {
+		go h.Detach()
+	}
+	waitObserverCount(t, eventCh, 1)
+	for _, h := range handles[:3] {
+		h.Detach()
+		for range h.Output {
+		}
+		for range h.Events {
+		}
+	}
+	if sp.ObserverCount() != 1 {
+		t.Errorf("expected 1 observer after detaching, got %d", sp.ObserverCount())
+	}
+	handles[3].Detach()
+	<-out.DoneCh
+	if sp.ObserverCount() != 0 {
+		t.Errorf("expected no observers, got %d", sp.ObserverCount())
+	}
+
+	sp.Close()
+	waitDone(t, sp)
+	if _, err := sp.AttachObserver(ObserverOpts{}); !errors.Is(err, ErrShellExited) {
+		t.Errorf("expected ErrShellExited attaching to an exited shell, got %v", err)
+	}
+}
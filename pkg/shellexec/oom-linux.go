@@ -0,0 +1,117 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package shellexec
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	kmsgPath      = "/dev/kmsg"
+	kmsgOomWindow = 30 * time.Second // only records this recent are considered
+	kmsgMaxRecord = 8192             // reads of /dev/kmsg fail with EINVAL if the buffer can't hold a whole record
+)
+
+// "Out of memory: Killed process 123 (cmd) ..." (also "Memory cgroup out of memory: ...")
+var kmsgOomKilledRe = regexp.MustCompile(`[Oo]ut of memory: Killed process (\d+) `)
+
+// "oom-kill:constraint=...,task_memcg=/path,task=cmd,pid=123,uid=1000"
+var kmsgOomKillRe = regexp.MustCompile(`oom-kill:.*task_memcg=([^,]*),.*pid=(\d+)`)
+
+type kmsgRecord struct {
+	TsUsec int64 // CLOCK_MONOTONIC
+	Msg    string
+}
+
+// parses a /dev/kmsg record ("prio,seq,ts_usec,flags;message\n" followed by continuation lines)
+func parseKmsgRecord(data []byte) (kmsgRecord, bool) {
+	header, msg, found := bytes.Cut(data, []byte(";"))
+	if !found {
+		return kmsgRecord{}, false
+	}
+	fields := bytes.Split(header, []byte(","))
+	if len(fields) < 3 {
+		return kmsgRecord{}, false
+	}
+	tsUsec, err := strconv.ParseInt(string(fields[2]), 10, 64)
+	if err != nil {
+		return kmsgRecord{}, false
+	}
+	msg, _, _ = bytes.Cut(msg, []byte("\n"))
+	return kmsgRecord{TsUsec: tsUsec, Msg: string(msg)}, true
+}
+
+// reads the kernel log records from the last window (needs CAP_SYSLOG when kernel.dmesg_restrict is set)
+func readRecentKmsg(window time.Duration) ([]kmsgRecord, error) {
+	var now unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); err != nil {
+		return nil, err
+	}
+	sinceUsec := now.Nano()/1000 - window.Microseconds()
+	// raw fd, the go poller would block waiting for new records instead of returning EAGAIN
+	fd, err := unix.Open(kmsgPath, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+	var rtn []kmsgRecord
+	buf := make([]byte, kmsgMaxRecord)
+	for {
+		nr, err := unix.Read(fd, buf)
+		if err == unix.EPIPE || err == unix.EINTR {
+			// EPIPE means the record we were about to read was overwritten, keep going from the next one
+			continue
+		}
+		if err != nil || nr <= 0 {
+			// EAGAIN, no more records
+			break
+		}
+		rec, ok := parseKmsgRecord(buf[:nr])
+		if ok && rec.TsUsec >= sinceUsec {
+			rtn = append(rtn, rec)
+		}
+	}
+	return rtn, nil
+}
+
+// returns true if msg is an oom kill of pid or of any process in the cgroup at cgroupPath (if not "")
+func kmsgOomMatches(msg string, pid int, cgroupPath string) bool {
+	if m := kmsgOomKilledRe.FindStringSubmatch(msg); m != nil {
+		return m[1] == strconv.Itoa(pid)
+	}
+	if m := kmsgOomKillRe.FindStringSubmatch(msg); m != nil {
+		return m[2] == strconv.Itoa(pid) || (cgroupPath != "" && m[1] == cgroupPath)
+	}
+	return false
+}
+
+// detectOOMKill checks whether the kernel OOM killer killed the shell (pid) or,
+// if it was started in a scoped cgroup, any of its descendants.  memory.events
+// is authoritative when available, otherwise the recent kernel log is searched.
+func detectOOMKill(pid int, cg *shellCgroup) bool {
+	cgroupPath := ""
+	if cg != nil {
+		if oomKills, ok := cg.oomKills(); ok {
+			return oomKills > 0
+		}
+		cgroupPath = cg.kernelPath()
+	}
+	records, err := readRecentKmsg(kmsgOomWindow)
+	if err != nil {
+		return false
+	}
+	for _, rec := range records {
+		if kmsgOomMatches(rec.Msg, pid, cgroupPath) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package shellexec
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseKmsgRecord(t *testing.T) {
+	rec, ok := parseKmsgRecord([]byte("3,1234,5140900,-;Out of memory: Killed process 4321 (tail) total-vm:1234kB\n SUBSYSTEM=mem\n"))
+	if !ok {
+		t.Fatalf("record should parse")
+	}
+	if rec.TsUsec != 5140900 || rec.Msg != "Out of memory: Killed process 4321 (tail) total-vm:1234kB" {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+	if _, ok := parseKmsgRecord([]byte("garbage")); ok {
+		t.Errorf("garbage should not parse")
+	}
+}
+
+func TestKmsgOomMatches(t *testing.T) {
+	tests := []struct {
+		msg        string
+		pid        int
+		cgroupPath string
+		want       bool
+	}{
+		{"Out of memory: Killed process 4321 (tail) total-vm:1234kB", 4321, "", true},
+		{"Memory cgroup out of memory: Killed process 4321 (tail) total-vm:1234kB", 4321, "", true},
+		{"Out of memory: Killed process 43210 (tail) total-vm:1234kB", 4321, "", false},
+		{"oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,oom_memcg=/a/waveshell-x,task_memcg=/a/waveshell-x,task=tail,pid=999,uid=0", 4321, "/a/waveshell-x", true},
+		{"oom-kill:constraint=CONSTRAINT_NONE,nodemask=(null),cpuset=/,mems_allowed=0,global_oom,task_memcg=/other,task=tail,pid=999,uid=0", 4321, "/a/waveshell-x", false},
+		{"oom-kill:constraint=CONSTRAINT_NONE,nodemask=(null),cpuset=/,mems_allowed=0,global_oom,task_memcg=/other,task=bash,pid=4321,uid=0", 4321, "", true},
+		{"usb 1-1: new high-speed USB device number 4321", 4321, "", false},
+	}
+	for _, test := range tests {
+		if got := kmsgOomMatches(test.msg, test.pid, test.cgroupPath); got != test.want {
+			t.Errorf("kmsgOomMatches(%q, %d, %q) = %v, want %v", test.msg, test.pid, test.cgroupPath, got, test.want)
+		}
+	}
+}
+
+// needs a delegated cgroup v2 tree with the memory controller (see testCgroupParent)
+func TestScopedCgroupOOMKill(t *testing.T) {
+	parentDir := testCgroupParent(t)
+	requireBinary(t, "tail")
+	cmdOpts := CommandOptsType{CgroupParent: parentDir, MemoryLimitBytes: 16 * 1024 * 1024}
+	sp := startTestShellProc(t, "read x; exec tail /dev/zero", cmdOpts)
+	if sp.cgroup == nil {
+		t.Skipf("could not create a shell cgroup under %s", parentDir)
+	}
+	if _, ok := sp.cgroup.oomKills(); !ok {
+		t.Skipf("memory controller not available for %s", sp.cgroup.Path)
+	}
+	writeCgroupFile(sp.cgroup.Path, "memory.swap.max", "0")
+	sp.Write([]byte("\r"))
+	status := waitExitStatus(t, sp)
+	if !status.OOMKilled {
+		t.Errorf("expected an OOM kill, got %+v", status)
+	}
+	if ExplainExit(status) != "killed by the kernel: out of memory" {
+		t.Errorf("unexpected explanation: %q", ExplainExit(status))
+	}
+	if _, err := os.Stat(sp.cgroup.Path); err == nil && sp.cgroup.Unit == "" {
+		t.Errorf("shell cgroup should be removed after exit")
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package shellexec
+
+// OOM kills can only be confirmed on linux
+func detectOOMKill(pid int, cg *shellCgroup) bool {
+	return false
+}
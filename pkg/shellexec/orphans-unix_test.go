@@ -0,0 +1,79 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/wshrpc"
+)
+
+func TestOrphanedShells(t *testing.T) {
+	// a process that is gone, as the wavesrv that crashed
+	deadOwner := exec.Command(requireBinary(t, "true"))
+	if err := deadOwner.Run(); err != nil {
+		t.Fatalf("error running true: %v", err)
+	}
+	// its shell, still running in its own session
+	orphanCmd := exec.Command(requireBinary(t, "sleep"), "30")
+	orphanCmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := orphanCmd.Start(); err != nil {
+		t.Fatalf("error starting sleep: %v", err)
+	}
+	t.Cleanup(func() {
+		orphanCmd.Process.Kill()
+		orphanCmd.Wait()
+	})
+	var startTime time.Time
+	deadline := time.Now().Add(testWaitTimeout)
+	for startTime.IsZero() && time.Now().Before(deadline) {
+		if proc, ok := lookupAuditProc(orphanCmd.Process.Pid); ok {
+			startTime = proc.StartTime
+		}
+	}
+	initTestProcStore(t)
+	insertTestShellProc(t, wshrpc.ShellProcInfo{SessionId: "orphan-1", Pid: orphanCmd.Process.Pid, StartTs: startTime.UnixMilli(), OwnerPid: deadOwner.Process.Pid})
+	// the pid was reused (or the shell is gone), only the record is cleaned up
+	insertTestShellProc(t, wshrpc.ShellProcInfo{SessionId: "orphan-2", Pid: orphanCmd.Process.Pid, StartTs: startTime.Add(-time.Hour).UnixMilli(), OwnerPid: deadOwner.Process.Pid})
+
+	orphans, err := FindOrphanedShells(context.Background())
+	if err != nil {
+		t.Fatalf("error finding orphans: %v", err)
+	}
+	if len(orphans) != 1 || orphans[0].SessionId != "orphan-1" || !orphans[0].ShellAlive || len(orphans[0].Procs) != 1 || orphans[0].Procs[0].Pid != orphanCmd.Process.Pid {
+		t.Fatalf("unexpected orphans %+v", orphans)
+	}
+	if _, ok := findTestShellProcInfo(readTestShellProcs(t), "orphan-2"); ok {
+		t.Errorf("the stale record should be removed")
+	}
+
+	if result := HandleOrphanedShells(orphans, OrphanOpts{}); len(result.Signaled)+len(result.Adopted) != 0 {
+		t.Errorf("nothing should be done by default, got %+v", result)
+	}
+	result := HandleOrphanedShells(orphans, OrphanOpts{Adopt: true, Terminate: true})
+	if len(result.Signaled) != 1 || len(result.Adopted) != 1 || result.Adopted[0] != "orphan-1" {
+		t.Fatalf("expected the orphan to be signaled and adopted, got %+v", result)
+	}
+	if err := orphanCmd.Wait(); err == nil {
+		t.Errorf("expected the orphan to be terminated")
+	}
+	if _, ok := findTestShellProcInfo(readTestShellProcs(t), "orphan-1"); !ok {
+		t.Errorf("the record of a handled orphan should be kept until it is gone")
+	}
+	if _, err := FindOrphanedShells(context.Background()); err != nil {
+		t.Fatalf("error finding orphans: %v", err)
+	}
+	if _, ok := findTestShellProcInfo(readTestShellProcs(t), "orphan-1"); ok {
+		t.Errorf("the record of a terminated orphan should be removed")
+	}
+	if sess, ok := shellRegistry.snapshot()[orphanCmd.Process.Pid]; !ok || sess.SessionId != "orphan-1" || sess.Proc != nil {
+		t.Errorf("expected the orphan's session to be adopted as ended, got %+v", sess)
+	}
+}
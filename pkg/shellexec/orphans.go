@@ -0,0 +1,164 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/wshrpc"
+)
+
+// OrphanProc is a process of an OrphanedShell
+type OrphanProc struct {
+	Pid       int       `json:"pid"`
+	Comm      string    `json:"comm,omitempty"`
+	StartTime time.Time `json:"starttime"` // checked again before it's signaled
+}
+
+// OrphanedShell is a local shell started by a previous wavesrv (that crashed or was killed) that
+// is still running, or whose session still has processes in it (linux only, elsewhere only the
+// shell itself is found)
+type OrphanedShell struct {
+	SessionId  string       `json:"sessionid"`
+	BlockId    string       `json:"blockid,omitempty"`
+	Pid        int          `json:"pid"` // the shell's, also the sid of its session
+	StartTime  time.Time    `json:"starttime"`
+	OwnerPid   int          `json:"ownerpid"`
+	ShellAlive bool         `json:"shellalive"`
+	Procs      []OrphanProc `json:"procs"` // the shell (if it's alive) and the rest of its session, by pid
+}
+
+// the stored shell's processes: the shell if it's still the same process, and the processes left in its session
+func findOrphanProcs(info wshrpc.ShellProcInfo, procs []auditProc, ourPid int) OrphanedShell {
+	orphan := OrphanedShell{SessionId: info.SessionId, BlockId: info.BlockId, Pid: info.Pid, StartTime: time.UnixMilli(info.StartTs), OwnerPid: info.OwnerPid}
+	for _, proc := range procs {
+		if proc.Pid == info.Pid && proc.StartTime.UnixMilli() != info.StartTs {
+			// the pid was reused, so the session is the new process's (the shell's is gone with all of its processes)
+			return orphan
+		}
+	}
+	for _, proc := range procs {
+		if proc.Pid == ourPid || proc.Zombie {
+			continue
+		}
+		isShell := proc.Pid == info.Pid && proc.StartTime.UnixMilli() == info.StartTs
+		// (linux doesn't reuse a pid while it's a sid, so a session without its leader is still the shell's)
+		inSession := proc.Sid != 0 && proc.Sid == info.Pid && proc.StartTime.UnixMilli() >= info.StartTs
+		if !isShell && !inSession {
+			continue
+		}
+		orphan.ShellAlive = orphan.ShellAlive || isShell
+		orphan.Procs = append(orphan.Procs, OrphanProc{Pid: proc.Pid, Comm: proc.Comm, StartTime: proc.StartTime})
+	}
+	sort.Slice(orphan.Procs, func(i, j int) bool { return orphan.Procs[i].Pid < orphan.Procs[j].Pid })
+	return orphan
+}
+
+// the stored shells that aren't ours and still have processes (checked against the process table),
+// with their records.  the records of the ones that are gone (or whose pid was reused) are removed
+func reconcileStoredShellProcs(ctx context.Context) ([]wshrpc.ShellProcInfo, []OrphanedShell, error) {
+	stored, err := readStoredShellProcs(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading stored shellprocs: %w", err)
+	}
+	var candidates []wshrpc.ShellProcInfo
+	for _, info := range stored {
+		if shellRegistry.getById(info.SessionId) == nil {
+			candidates = append(candidates, info)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil, nil
+	}
+	procs, err := listAuditProcs()
+	if err != nil {
+		return nil, nil, err
+	}
+	var infos []wshrpc.ShellProcInfo
+	var orphans []OrphanedShell
+	var gone []string
+	for _, info := range candidates {
+		orphan := findOrphanProcs(info, procs, os.Getpid())
+		if len(orphan.Procs) == 0 {
+			gone = append(gone, info.SessionId)
+			continue
+		}
+		info.Orphaned = true
+		infos = append(infos, info)
+		orphans = append(orphans, orphan)
+	}
+	if len(gone) > 0 {
+		if err := deleteStoredShellProcs(ctx, gone); err != nil {
+			return nil, nil, fmt.Errorf("error removing stored shellprocs: %w", err)
+		}
+	}
+	return infos, orphans, nil
+}
+
+// FindOrphanedShells returns the shells (and what's left of their sessions) that a previous
+// wavesrv started and that are still running, from the store (wstore must be initialized).
+// Should be run at startup, the records of shells that are gone (or whose pid was reused) are removed.
+func FindOrphanedShells(ctx context.Context) ([]OrphanedShell, error) {
+	_, orphans, err := reconcileStoredShellProcs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].Pid < orphans[j].Pid })
+	return orphans, nil
+}
+
+// OrphanOpts says what HandleOrphanedShells does, nothing by default
+type OrphanOpts struct {
+	Terminate bool           // signal every process of the orphans
+	Signal    syscall.Signal // for Terminate, defaults to SIGTERM (not supported on windows)
+	Adopt     bool           // track them like the sessions of our shells that have ended, Audit then reports what is left (as AuditClass_Orphaned)
+}
+
+// OrphanResult lists what HandleOrphanedShells did
+type OrphanResult struct {
+	Signaled []int    `json:"signaled,omitempty"`
+	Adopted  []string `json:"adopted,omitempty"` // session ids
+	Skipped  []int    `json:"skipped,omitempty"` // gone, or the pid was reused since FindOrphanedShells
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// HandleOrphanedShells terminates and/or adopts the orphans (as allowed by opts).  Like AuditFix
+// every process is checked again before it's signaled.  Their records are kept until their
+// processes are gone, so they are still found if this wavesrv goes away too.
+func HandleOrphanedShells(orphans []OrphanedShell, opts OrphanOpts) OrphanResult {
+	var result OrphanResult
+	if !opts.Terminate && !opts.Adopt {
+		return result
+	}
+	sig := opts.Signal
+	if sig == 0 {
+		sig = syscall.SIGTERM
+	}
+	for _, orphan := range orphans {
+		if opts.Terminate {
+			for _, orphanProc := range orphan.Procs {
+				current, ok := lookupAuditProc(orphanProc.Pid)
+				if !ok || current.Zombie || !current.StartTime.Equal(orphanProc.StartTime) {
+					result.Skipped = append(result.Skipped, orphanProc.Pid)
+					continue
+				}
+				if err := signalPid(orphanProc.Pid, sig); err != nil {
+					result.Errors = append(result.Errors, fmt.Sprintf("pid %d: %v", orphanProc.Pid, err))
+					continue
+				}
+				result.Signaled = append(result.Signaled, orphanProc.Pid)
+			}
+		}
+		if opts.Adopt {
+			shellRegistry.adoptEnded(orphan.Pid, orphan.SessionId, orphan.StartTime, shellClock.Now())
+			result.Adopted = append(result.Adopted, orphan.SessionId)
+		}
+	}
+	return result
+}
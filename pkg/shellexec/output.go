@@ -0,0 +1,266 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+)
+
+const decModeBracketedPaste = "2004"
+
+// terminal state we track from the output stream (modes set by the running application)
+type termFlags struct {
+	BracketedPaste bool
+}
+
+// outputHandler runs the pty output through the sequence parser so we can track
+// things like OSC 8 hyperlinks.  The bytes themselves are passed through unchanged
+// (the only stages after it, the utf8 chunker and the coalescer, only move chunk
+// boundaries, so the output stream is always byte-for-byte what the pty produced),
+// except for sequences stripped by the Sanitizer (never with SanitizeProfile_Trusted).
+// Stripped sequences are dropped before anything else sees them (no modes, events or links).
+type outputHandler struct {
+	Lock      *sync.Mutex
+	Parser    *seqParser
+	Offset    int64 // number of bytes emitted downstream so far
+	Links     *linkTracker
+	Flags     termFlags
+	Cwd       string // the last cwd the shell reported (OSC 7)
+	Commands  *commandMarkTracker
+	Events    *eventHub
+	Sanitizer *outputSanitizer
+	// called (with the lock held) when the prompt ready marker is seen, see CommandOptsType.MeasurePromptReady
+	OnPromptReady func()
+}
+
+func makeOutputHandler(events *eventHub) *outputHandler {
+	return &outputHandler{
+		Lock:      &sync.Mutex{},
+		Parser:    makeSeqParser(),
+		Events:    events,
+		Commands:  makeCommandMarkTracker(events),
+		Sanitizer: makeOutputSanitizer(SanitizeProfile_Trusted, events.SessionId, false),
+		Links: makeLinkTracker(func(rec LinkRecord) {
+			events.publish(ShellEvent{Kind: EventKind_Link, Link: &rec})
+		}),
+	}
+}
+
+// tracks DEC private modes (CSI ? Pm h / CSI ? Pm l)
+func (oh *outputHandler) trackModes(tok seqToken) {
+	if tok.Type != tokType_CSI || tok.Overflow || tok.Unterminated || len(tok.Payload) < 3 || tok.Payload[0] != '?' {
+		return
+	}
+	final := tok.Payload[len(tok.Payload)-1]
+	if final != 'h' && final != 'l' {
+		return
+	}
+	for _, mode := range strings.Split(string(tok.Payload[1:len(tok.Payload)-1]), ";") {
+		if mode == decModeBracketedPaste {
+			oh.Flags.BracketedPaste = (final == 'h')
+		}
+	}
+}
+
+// publishes the title (OSC 0/2), cwd (OSC 7), command mark (OSC 133) and bell events
+func (oh *outputHandler) publishTokenEvents(tok seqToken) {
+	if tok.Type == tokType_Bell {
+		oh.Events.publish(ShellEvent{Kind: EventKind_Bell})
+		return
+	}
+	if tok.Type != tokType_OSC || tok.Overflow || tok.Unterminated {
+		return
+	}
+	code, arg, _ := strings.Cut(string(tok.Payload), ";")
+	switch code {
+	case "0", "2":
+		oh.Events.publish(ShellEvent{Kind: EventKind_Title, Title: arg})
+	case "7":
+		if cwd, ok := parseOsc7(arg); ok {
+			oh.Cwd = cwd
+			oh.Events.publish(ShellEvent{Kind: EventKind_Cwd, Cwd: cwd})
+		}
+	case oscCode_CommandMark:
+		oh.Commands.handleMark(arg, oh.Offset, len(tok.Raw), oh.Cwd)
+	}
+}
+
+// OSC 7 is file://host/path (percent-encoded), the host is not checked
+func parseOsc7(arg string) (string, bool) {
+	fileUrl, err := url.Parse(arg)
+	if err != nil || fileUrl.Scheme != "file" || fileUrl.Path == "" {
+		return "", false
+	}
+	return fileUrl.Path, true
+}
+
+func (oh *outputHandler) getTermFlags() termFlags {
+	oh.Lock.Lock()
+	defer oh.Lock.Unlock()
+	return oh.Flags
+}
+
+func (oh *outputHandler) getCwd() string {
+	oh.Lock.Lock()
+	defer oh.Lock.Unlock()
+	return oh.Cwd
+}
+
+func (oh *outputHandler) commandState() (bool, bool) {
+	oh.Lock.Lock()
+	defer oh.Lock.Unlock()
+	return oh.Commands.SawMarks, oh.Commands.InCommand
+}
+
+func (oh *outputHandler) handleToken(tok seqToken, outBuf *bytes.Buffer) {
+	// our own marker, seen even if it gets stripped
+	if oh.OnPromptReady != nil && isPromptReadyMarker(tok) {
+		oh.OnPromptReady()
+	}
+	if !oh.Sanitizer.allow(tok) {
+		return
+	}
+	oh.trackModes(tok)
+	oh.publishTokenEvents(tok)
+	oh.Links.handleToken(tok, oh.Offset)
+	outBuf.Write(tok.Raw)
+	oh.Offset += int64(len(tok.Raw))
+}
+
+// processData appends the output for data to outBuf.  Incomplete escape
+// sequences are held until the rest of the sequence arrives.
+func (oh *outputHandler) processData(data []byte, outBuf *bytes.Buffer) {
+	oh.Lock.Lock()
+	defer oh.Lock.Unlock()
+	oh.Parser.Feed(data, func(tok seqToken) {
+		oh.handleToken(tok, outBuf)
+	})
+}
+
+// counts injected output (see outputPipeline.inject) in Offset, the parser doesn't see it
+func (oh *outputHandler) addInjected(n int) {
+	oh.Lock.Lock()
+	defer oh.Lock.Unlock()
+	oh.Offset += int64(n)
+}
+
+// flush is called at the end of the output stream, any held bytes are appended to outBuf
+func (oh *outputHandler) flush(outBuf *bytes.Buffer) {
+	oh.Lock.Lock()
+	defer oh.Lock.Unlock()
+	oh.Parser.Flush(func(tok seqToken) {
+		oh.handleToken(tok, outBuf)
+	})
+	oh.Links.flush(oh.Offset)
+}
+
+const (
+	outputReadBufSize     = 4096
+	outputBulkReadBufSize = 64 * 1024
+)
+
+// outputPipeline is the output handler and utf8 chunker of a shell's output loop.  Lock
+// serializes the loop's writes with injected output, so injected text goes downstream whole,
+// between two of the loop's chunks (ahead of any escape sequence or utf8 sequence that is still
+// incomplete, those are held until the rest of it is read)
+type outputPipeline struct {
+	Lock      *sync.Mutex
+	Handler   *outputHandler
+	Chunker   *utf8Chunker
+	InjectDst io.Writer // where injected output goes (it doesn't count as the shell's output, e.g. for IdleCountOutput)
+	Done      bool      // set once the output loop has flushed everything, injected output is dropped then
+}
+
+func makeOutputPipeline(clk clock, oh *outputHandler, dst io.Writer, injectDst io.Writer) *outputPipeline {
+	return &outputPipeline{Lock: &sync.Mutex{}, Handler: oh, Chunker: makeUtf8Chunker(clk, dst, DefaultUtf8HoldTimeout), InjectDst: injectDst}
+}
+
+func (pl *outputPipeline) write(data []byte, outBuf *bytes.Buffer) {
+	pl.Lock.Lock()
+	defer pl.Lock.Unlock()
+	outBuf.Reset()
+	pl.Handler.processData(data, outBuf)
+	pl.Chunker.Write(outBuf.Bytes())
+}
+
+func (pl *outputPipeline) finish(outBuf *bytes.Buffer) {
+	pl.Lock.Lock()
+	defer pl.Lock.Unlock()
+	outBuf.Reset()
+	pl.Handler.flush(outBuf)
+	pl.Chunker.Write(outBuf.Bytes())
+	pl.Chunker.flush()
+	pl.Done = true
+}
+
+// injected output isn't parsed (it's our own text), it only moves the handler's offset
+func (pl *outputPipeline) inject(data []byte) {
+	pl.Lock.Lock()
+	defer pl.Lock.Unlock()
+	if pl.Done || len(data) == 0 {
+		return
+	}
+	pl.Handler.addInjected(len(data))
+	pl.Chunker.writeAround(data, pl.InjectDst)
+}
+
+// runOutputLoop reads src until it returns an error.  the pipeline is:
+// src -> output handler (tokenizer) -> utf8 chunker -> dst.
+// endFn is called with the read error once src is done and everything has been flushed.
+func runOutputLoop(src io.Reader, pl *outputPipeline, endFn func(error)) {
+	sizer := &readBufSizer{Buf: getReadBuf()}
+	defer sizer.release()
+	outBuf := getOutputBuf()
+	defer putOutputBuf(outBuf)
+	for {
+		buf := sizer.buf()
+		nr, err := src.Read(buf)
+		if nr > 0 {
+			pl.write(buf[:nr], outBuf)
+			sizer.update(nr)
+		}
+		if err != nil {
+			pl.finish(outBuf)
+			endFn(err)
+			return
+		}
+	}
+}
+
+func (sp *ShellProc) startOutputLoop() {
+	go func() {
+		defer panichandler.PanicHandler("ShellProc:outputLoop")
+		var src io.Reader = &firstReadRecorder{Src: sp.Cmd, Tracker: sp.startup}
+		if sp.packetMode {
+			src = &ptyPacketReader{Src: src, OnControl: sp.handlePtyControl}
+		}
+		// (without the packet mode headers)
+		src = countingReader{Src: src, Count: &sp.ioCounts.Read}
+		runOutputLoop(src, sp.outputPipe, func(err error) {
+			sp.outputSubs.setErr(err)
+			sp.outputBuf.setErr(err)
+			close(sp.outputDone)
+		})
+	}()
+}
+
+// injectOutput adds data (generated by shellexec, e.g. a warning) to the output stream, see outputPipeline
+func (sp *ShellProc) injectOutput(data []byte) {
+	sp.outputPipe.inject(data)
+}
+
+// Read reads the shell's output.  The pty (sp.Cmd) is read by the shellproc's
+// own read loop (which runs the output handler and coalesces small reads), so
+// all output consumers must read through the ShellProc rather than directly
+// from sp.Cmd.  Returns io.EOF (or the pty read error) once all output has
+// been read.
+func (sp *ShellProc) Read(p []byte) (int, error) {
+	return sp.outputBuf.Read(p)
+}
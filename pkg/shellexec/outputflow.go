@@ -0,0 +1,245 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+)
+
+// what happens to an output subscriber that falls behind (OutputSubOpts.FlowPolicy)
+const (
+	FlowPolicy_Close      = "close"      // the default, the subscription is closed with ErrOutputSubOverflow once BufSize chunks are queued
+	FlowPolicy_DropOldest = "dropoldest" // once HighWater bytes are queued the oldest chunks are dropped down to LowWater (see OutputSubscription.Dropped)
+	FlowPolicy_Block      = "block"      // once HighWater bytes are queued the output is paused until the subscriber drains down to LowWater
+)
+
+const DefaultFlowHighWater = 1024 * 1024 // bytes, LowWater defaults to half of HighWater
+
+// how long the output loop waits for FlowPolicy_Block subscribers before EventKind_OutputStalled is published
+// (short pauses are normal for a subscriber that's just a bit slower than the shell)
+var OutputStallThreshold = 2 * time.Second
+
+// OutputStall is published (EventKind_OutputStalled) when the output loop has been paused for
+// OutputStallThreshold, and again with Stalled false when it goes on
+type OutputStall struct {
+	Stalled    bool  `json:"stalled"`
+	Offset     int64 `json:"offset"`               // the stream offset of the output read so far
+	DurationMs int64 `json:"durationms,omitempty"` // how long it waited in total (only when it goes on)
+}
+
+// one wait of outputHub.Write, see OnStall
+type stallWatch struct {
+	Lock     *sync.Mutex
+	Hub      *outputHub
+	Offset   int64
+	Start    time.Time
+	Stalled  bool // OnStall was called for this wait
+	Done     bool
+	StopTime func() bool
+}
+
+func (h *outputHub) watchStall() *stallWatch {
+	h.Lock.Lock()
+	offset := h.Offset
+	h.Lock.Unlock()
+	sw := &stallWatch{Lock: &sync.Mutex{}, Hub: h, Offset: offset, Start: h.Clock.Now()}
+	if h.OnStall != nil {
+		sw.StopTime = h.Clock.AfterFunc(OutputStallThreshold, sw.onTimer)
+	}
+	return sw
+}
+
+func (sw *stallWatch) onTimer() {
+	sw.Lock.Lock()
+	defer sw.Lock.Unlock()
+	if sw.Done {
+		return
+	}
+	sw.Stalled = true
+	// under Lock, so it can't be reported after the resume
+	sw.Hub.OnStall(OutputStall{Stalled: true, Offset: sw.Offset})
+}
+
+func (sw *stallWatch) done() {
+	if sw.StopTime != nil {
+		sw.StopTime()
+	}
+	sw.Lock.Lock()
+	defer sw.Lock.Unlock()
+	sw.Done = true
+	if sw.Stalled {
+		sw.Hub.OnStall(OutputStall{Offset: sw.Offset, DurationMs: sw.Hub.Clock.Now().Sub(sw.Start).Milliseconds()})
+	}
+}
+
+func (sp *ShellProc) publishOutputStall(stall OutputStall) {
+	if stall.Stalled {
+		sp.logf("output stalled at offset %d (waiting for a blocking output subscriber)\n", stall.Offset)
+	}
+	sp.events.publish(ShellEvent{Kind: EventKind_OutputStalled, OutputStall: &stall})
+}
+
+// flowQueue is the queue of a subscriber with a FlowPolicy other than Close, chunks are handed to
+// OutCh (unbuffered, so Queued is everything the subscriber hasn't taken yet) by its own goroutine.
+//
+// FlowPolicy_Block pauses the hub's Write, which is the output loop, so the pty isn't read until the
+// subscriber catches up (the shell then blocks on its own writes once the pty buffer is full), and
+// every other consumer waits too.  the wait happens outside of the hub's lock (see waitUnpaused)
+type flowQueue struct {
+	CVar      *sync.Cond
+	Policy    string
+	HighWater int
+	LowWater  int
+	Queue     []OutputChunk
+	Queued    int   // bytes in Queue and InFlight
+	InFlight  int   // bytes of the chunk being handed to OutCh (already taken off Queue)
+	Dropped   int64 // bytes dropped (FlowPolicy_DropOldest)
+	Paused    bool  // (FlowPolicy_Block) Queued went over HighWater and hasn't drained to LowWater yet
+	Draining  bool  // the stream ended, OutCh is closed once Queue is empty
+	Stopped   bool  // unsubscribed (or the stream ended and everything was delivered)
+	OutCh     chan OutputChunk
+	StopCh    chan struct{} // closed when Stopped is set, unblocks the goroutine's send
+}
+
+// zero (or invalid) watermarks get the defaults, an unknown policy is FlowPolicy_Close (no queue)
+func makeFlowQueue(opts OutputSubOpts) *flowQueue {
+	if opts.FlowPolicy != FlowPolicy_DropOldest && opts.FlowPolicy != FlowPolicy_Block {
+		return nil
+	}
+	highWater := opts.HighWater
+	if highWater <= 0 {
+		highWater = DefaultFlowHighWater
+	}
+	lowWater := opts.LowWater
+	if lowWater <= 0 || lowWater > highWater {
+		lowWater = highWater / 2
+	}
+	fq := &flowQueue{
+		CVar:      sync.NewCond(&sync.Mutex{}),
+		Policy:    opts.FlowPolicy,
+		HighWater: highWater,
+		LowWater:  lowWater,
+		OutCh:     make(chan OutputChunk),
+		StopCh:    make(chan struct{}),
+	}
+	go func() {
+		defer panichandler.PanicHandler("outputHub:flowQueue")
+		fq.run()
+	}()
+	return fq
+}
+
+// never blocks (see waitUnpaused), returns true if the hub has to wait for the subscriber
+func (fq *flowQueue) push(chunk OutputChunk) bool {
+	fq.CVar.L.Lock()
+	defer fq.CVar.L.Unlock()
+	if fq.Stopped || fq.Draining {
+		return false
+	}
+	fq.Queue = append(fq.Queue, chunk)
+	fq.Queued += chunk.Len
+	fq.CVar.Broadcast()
+	if fq.Queued <= fq.HighWater {
+		return false
+	}
+	if fq.Policy == FlowPolicy_Block {
+		fq.Paused = true
+		return true
+	}
+	// the newest chunk is always kept (and the one in flight can't be taken back)
+	for fq.Queued > fq.LowWater && len(fq.Queue) > 1 {
+		fq.Queued -= fq.Queue[0].Len
+		fq.Dropped += int64(fq.Queue[0].Len)
+		fq.Queue = fq.Queue[1:]
+	}
+	return false
+}
+
+func (fq *flowQueue) waitUnpaused() {
+	fq.CVar.L.Lock()
+	defer fq.CVar.L.Unlock()
+	for fq.Paused && !fq.Stopped {
+		fq.CVar.Wait()
+	}
+}
+
+// drain delivers what is queued before OutCh is closed (the end of the stream), otherwise it is discarded
+func (fq *flowQueue) close(drain bool) {
+	fq.CVar.L.Lock()
+	defer fq.CVar.L.Unlock()
+	if drain && !fq.Stopped {
+		fq.Draining = true
+	} else {
+		fq.stopLocked()
+	}
+	fq.CVar.Broadcast()
+}
+
+// must hold CVar.L
+func (fq *flowQueue) stopLocked() {
+	if !fq.Stopped {
+		fq.Stopped = true
+		fq.Paused = false
+		fq.Queue = nil
+		fq.Queued = 0
+		fq.InFlight = 0
+		close(fq.StopCh)
+	}
+}
+
+func (fq *flowQueue) dropped() int64 {
+	fq.CVar.L.Lock()
+	defer fq.CVar.L.Unlock()
+	return fq.Dropped
+}
+
+func (fq *flowQueue) next() (OutputChunk, bool) {
+	fq.CVar.L.Lock()
+	defer fq.CVar.L.Unlock()
+	for len(fq.Queue) == 0 && !fq.Stopped && !fq.Draining {
+		fq.CVar.Wait()
+	}
+	if fq.Stopped || len(fq.Queue) == 0 {
+		fq.stopLocked()
+		return OutputChunk{}, false
+	}
+	chunk := fq.Queue[0]
+	fq.Queue = fq.Queue[1:]
+	fq.InFlight = chunk.Len
+	return chunk, true
+}
+
+// the chunk from next was taken by the subscriber (the pause can end)
+func (fq *flowQueue) delivered() {
+	fq.CVar.L.Lock()
+	defer fq.CVar.L.Unlock()
+	if fq.Stopped {
+		return
+	}
+	fq.Queued -= fq.InFlight
+	fq.InFlight = 0
+	if fq.Paused && fq.Queued <= fq.LowWater {
+		fq.Paused = false
+		fq.CVar.Broadcast()
+	}
+}
+
+func (fq *flowQueue) run() {
+	defer close(fq.OutCh)
+	for {
+		chunk, ok := fq.next()
+		if !ok {
+			return
+		}
+		select {
+		case fq.OutCh <- chunk:
+			fq.delivered()
+		case <-fq.StopCh:
+			return
+		}
+	}
+}
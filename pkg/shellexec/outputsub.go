@@ -0,0 +1,333 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const DefaultOutputSubBufferSize = 256 // in chunks
+
+var ErrOutputSubOverflow = errors.New("output subscriber fell behind, subscription closed")
+
+// OutputTransformer rewrites output for a single subscriber (e.g. to strip escape sequences).
+// It receives a private copy of the chunk and may modify it in place.
+type OutputTransformer func(data []byte) []byte
+
+// OutputChunk is an opaque slice of the output stream.  Data is never
+// interpreted or modified (NUL bytes and invalid UTF-8 are passed through
+// as-is) unless the subscriber installed a Transform.
+type OutputChunk struct {
+	Offset int64  // stream offset of the first byte (before any transform)
+	Len    int    // always len(Data)
+	Data   []byte // owned by the subscriber
+}
+
+// OutputChunkB64 is the JSON-safe encoding of an OutputChunk
+type OutputChunkB64 struct {
+	Offset int64  `json:"offset"`
+	Len    int    `json:"len"`
+	Data64 string `json:"data64"`
+}
+
+func (c OutputChunk) EncodeBase64() OutputChunkB64 {
+	return OutputChunkB64{Offset: c.Offset, Len: c.Len, Data64: base64.StdEncoding.EncodeToString(c.Data)}
+}
+
+// Decode decodes the chunk and verifies its length
+func (c OutputChunkB64) Decode() (OutputChunk, error) {
+	data, err := base64.StdEncoding.DecodeString(c.Data64)
+	if err != nil {
+		return OutputChunk{}, fmt.Errorf("error decoding output chunk: %w", err)
+	}
+	if len(data) != c.Len {
+		return OutputChunk{}, fmt.Errorf("output chunk length mismatch, expected %d bytes, got %d", c.Len, len(data))
+	}
+	return OutputChunk{Offset: c.Offset, Len: c.Len, Data: data}, nil
+}
+
+type OutputSubOpts struct {
+	BufSize   int               // channel buffer size in chunks (defaults to DefaultOutputSubBufferSize)
+	Transform OutputTransformer // nil delivers the raw bytes
+
+	// start with the retained scrollback (the last ReplayBytes of it, all of it if zero) as the first
+	// chunk, so a reconnecting consumer doesn't get a blank terminal.  see SubscribeOutput
+	Replay      bool
+	ReplayBytes int
+
+	// what happens when the subscriber falls behind (FlowPolicy_*, an empty or unknown policy is FlowPolicy_Close).
+	// the watermarks are in queued bytes, for FlowPolicy_DropOldest and FlowPolicy_Block only (BufSize is then
+	// unused), zero uses DefaultFlowHighWater and half of it
+	FlowPolicy string
+	HighWater  int
+	LowWater   int
+}
+
+// OutputSubscription delivers the output stream as OutputChunks.  Chunks are
+// never dropped silently: if the subscriber falls more than BufSize chunks
+// behind, the channel is closed and Err returns ErrOutputSubOverflow (unless
+// another FlowPolicy was asked for, with FlowPolicy_DropOldest the gaps show in
+// the Offsets and are counted by Dropped).  When the output stream ends the
+// channel is closed (once everything queued was delivered) and Err returns the
+// pty read error (io.EOF, or EIO on linux).
+type OutputSubscription struct {
+	Ch <-chan OutputChunk
+
+	hub  *outputHub
+	id   int
+	flow *flowQueue // nil for FlowPolicy_Close
+}
+
+// Err returns why the channel was closed (nil while it is still open)
+func (s *OutputSubscription) Err() error {
+	s.hub.Lock.Lock()
+	defer s.hub.Lock.Unlock()
+	return s.hub.Errs[s.id]
+}
+
+// Dropped returns the number of bytes dropped so far (FlowPolicy_DropOldest)
+func (s *OutputSubscription) Dropped() int64 {
+	if s.flow == nil {
+		return 0
+	}
+	return s.flow.dropped()
+}
+
+// Close unsubscribes and closes the channel (anything still queued is discarded)
+func (s *OutputSubscription) Close() {
+	s.hub.Lock.Lock()
+	defer s.hub.Lock.Unlock()
+	s.hub.closeSub(s.id, nil)
+}
+
+// Reader returns an io.ReadCloser over the subscription, for consumers that want a byte stream
+// (use either it or Ch, not both, and Read from one goroutine).  Read returns io.EOF once the
+// output has ended or the subscription was closed, and ErrOutputSubOverflow or ErrShellDetached
+// if it was closed for those.  Close unsubscribes
+func (s *OutputSubscription) Reader() io.ReadCloser {
+	return &outputSubReader{Sub: s}
+}
+
+type outputSubReader struct {
+	Sub     *OutputSubscription
+	Pending []byte // the rest of the last chunk
+}
+
+func (r *outputSubReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(r.Pending) == 0 {
+		chunk, ok := <-r.Sub.Ch
+		if !ok {
+			if err := r.Sub.Err(); errors.Is(err, ErrOutputSubOverflow) || errors.Is(err, ErrShellDetached) {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		r.Pending = chunk.Data
+	}
+	n := copy(p, r.Pending)
+	r.Pending = r.Pending[n:]
+	return n, nil
+}
+
+func (r *outputSubReader) Close() error {
+	r.Sub.Close()
+	return nil
+}
+
+// closes the channel, Err then returns err
+func (s *OutputSubscription) closeWithErr(err error) {
+	s.hub.Lock.Lock()
+	defer s.hub.Lock.Unlock()
+	s.hub.closeSub(s.id, err)
+}
+
+type outputSub struct {
+	Ch        chan OutputChunk // Flow.OutCh if Flow is set (closed by the flowQueue)
+	Transform OutputTransformer
+	Flow      *flowQueue
+}
+
+// outputHub fans the output stream out to subscribers, it is an io.Writer in
+// the output loop's pipeline so Offset tracks the stream offset.
+type outputHub struct {
+	Lock   *sync.Mutex
+	NextId int
+	Subs   map[int]*outputSub
+	Errs   map[int]error // set when a subscription is closed
+	Offset int64
+	EndErr error
+	Clock  clock
+	// called when Write has waited OutputStallThreshold for FlowPolicy_Block subscribers, and again
+	// once it goes on (only if it was called for the stall)
+	OnStall func(stall OutputStall)
+}
+
+func makeOutputHub() *outputHub {
+	return &outputHub{Lock: &sync.Mutex{}, Subs: make(map[int]*outputSub), Errs: make(map[int]error), Clock: shellClock}
+}
+
+// must hold Lock
+func (h *outputHub) closeSub(id int, err error) {
+	h.closeSubDrain(id, err, false)
+}
+
+// must hold Lock, drain is for the end of the stream (a flowQueue delivers what it has queued first)
+func (h *outputHub) closeSubDrain(id int, err error, drain bool) {
+	sub, ok := h.Subs[id]
+	if !ok {
+		return
+	}
+	delete(h.Subs, id)
+	if sub.Flow != nil {
+		sub.Flow.close(drain)
+	} else {
+		close(sub.Ch)
+	}
+	h.Errs[id] = err
+}
+
+func (h *outputHub) subscribe(opts OutputSubOpts) *OutputSubscription {
+	return h.subscribeWithReplay(opts, nil)
+}
+
+// replay (if set) is called with Lock held and the stream offset the subscription
+// starts at, the bytes it returns (which must end at that offset) are delivered as
+// the first chunk (through opts.Transform).  Nothing can be written in between, so
+// the replay and the live chunks line up with no gap or overlap.
+func (h *outputHub) subscribeWithReplay(opts OutputSubOpts, replay func(startOffset int64) ([]byte, int64)) *OutputSubscription {
+	bufSize := opts.BufSize
+	if bufSize <= 0 {
+		bufSize = DefaultOutputSubBufferSize
+	}
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	id := h.NextId
+	h.NextId++
+	if replay != nil {
+		bufSize++ // room for the replay chunk
+	}
+	flow := makeFlowQueue(opts)
+	var ch chan OutputChunk
+	if flow != nil {
+		ch = flow.OutCh
+	} else {
+		ch = make(chan OutputChunk, bufSize)
+	}
+	if replay != nil {
+		if data, offset := replay(h.Offset); len(data) > 0 {
+			if opts.Transform != nil {
+				data = opts.Transform(data)
+			}
+			chunk := OutputChunk{Offset: offset, Len: len(data), Data: data}
+			if flow != nil {
+				flow.push(chunk) // (a pause only starts with the next Write)
+			} else {
+				ch <- chunk
+			}
+		}
+	}
+	if h.EndErr != nil {
+		if flow != nil {
+			flow.close(true)
+		} else {
+			close(ch)
+		}
+		h.Errs[id] = h.EndErr
+	} else {
+		h.Subs[id] = &outputSub{Ch: ch, Transform: opts.Transform, Flow: flow}
+	}
+	return &OutputSubscription{Ch: ch, hub: h, id: id, flow: flow}
+}
+
+// subscribers that are full are closed with ErrOutputSubOverflow, this only blocks (after
+// the data has been queued for everyone) for FlowPolicy_Block subscribers over their HighWater
+func (h *outputHub) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	paused := h.write(data)
+	if len(paused) == 0 {
+		return len(data), nil
+	}
+	stall := h.watchStall()
+	for _, flow := range paused {
+		flow.waitUnpaused()
+	}
+	stall.done()
+	return len(data), nil
+}
+
+// returns the flowQueues that have to be waited for
+func (h *outputHub) write(data []byte) []*flowQueue {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	var paused []*flowQueue
+	offset := h.Offset
+	h.Offset += int64(len(data))
+	for id, sub := range h.Subs {
+		chunkData := append([]byte(nil), data...)
+		if sub.Transform != nil {
+			chunkData = sub.Transform(chunkData)
+		}
+		chunk := OutputChunk{Offset: offset, Len: len(chunkData), Data: chunkData}
+		if sub.Flow != nil {
+			if sub.Flow.push(chunk) {
+				paused = append(paused, sub.Flow)
+			}
+			continue
+		}
+		select {
+		case sub.Ch <- chunk:
+		default:
+			h.closeSub(id, ErrOutputSubOverflow)
+		}
+	}
+	return paused
+}
+
+// called once the output stream ends, closes all subscriptions
+func (h *outputHub) setErr(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	if h.EndErr == nil {
+		h.EndErr = err
+	}
+	for id := range h.Subs {
+		h.closeSubDrain(id, h.EndErr, true)
+	}
+}
+
+// SubscribeOutput returns a subscription to the shell's output from this
+// point on (or, with opts.Replay, from the start of the replayed scrollback).
+// The bytes are delivered exactly as they were read from the pty (the output
+// pipeline never performs lossy transformations, other than stripping sequences
+// for a SanitizeProfile other than Trusted) unless opts has a Transform.  Chunk
+// boundaries are not preserved across subscribers, only the byte stream (use
+// OutputChunk.Offset to line chunks up with the stream).
+//
+// Every subscriber gets all of the output (independently of Read and of each other), see
+// OutputSubscription for what happens to one that falls behind, and Reader for an io.Reader.
+//
+// The replay is cut from the scrollback (see CommandOptsType.ScrollbackSize) right
+// where the live chunks start.  It begins on a utf-8 boundary, but can begin in the
+// middle of a line or an escape sequence.  There is no replay chunk if the scrollback
+// is empty.
+func (sp *ShellProc) SubscribeOutput(opts OutputSubOpts) *OutputSubscription {
+	if !opts.Replay {
+		return sp.outputSubs.subscribe(opts)
+	}
+	return sp.outputSubs.subscribeWithReplay(opts, func(startOffset int64) ([]byte, int64) {
+		return sp.scrollback.replayTo(startOffset, opts.ReplayBytes)
+	})
+}
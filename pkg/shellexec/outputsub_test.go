@@ -0,0 +1,301 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/util/utilfn"
+)
+
+func TestOutputChunkBase64(t *testing.T) {
+	chunk := OutputChunk{Offset: 100, Len: 5, Data: []byte{0, 0xff, 0xc3, '\n', 0x1b}}
+	barr, err := json.Marshal(chunk.EncodeBase64())
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	var encoded OutputChunkB64
+	if err := json.Unmarshal(barr, &encoded); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	decoded, err := encoded.Decode()
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if decoded.Offset != chunk.Offset || !bytes.Equal(decoded.Data, chunk.Data) {
+		t.Errorf("round trip mismatch: %#v", decoded)
+	}
+	encoded.Len = 4
+	if _, err := encoded.Decode(); err == nil {
+		t.Errorf("expected a length mismatch error")
+	}
+}
+
+func TestOutputHubOverflow(t *testing.T) {
+	hub := makeOutputHub()
+	slowSub := hub.subscribe(OutputSubOpts{BufSize: 2})
+	fastSub := hub.subscribe(OutputSubOpts{BufSize: 10, Transform: bytes.ToUpper})
+	for _, str := range []string{"a", "b", "c"} {
+		hub.Write([]byte(str))
+	}
+	var slowData []byte
+	for chunk := range slowSub.Ch {
+		slowData = append(slowData, chunk.Data...)
+	}
+	if string(slowData) != "ab" || slowSub.Err() != ErrOutputSubOverflow {
+		t.Errorf("expected overflow after %q, got %q, err: %v", "ab", slowData, slowSub.Err())
+	}
+	hub.setErr(nil)
+	var fastData []byte
+	var lastOffset int64
+	for chunk := range fastSub.Ch {
+		fastData = append(fastData, chunk.Data...)
+		lastOffset = chunk.Offset
+	}
+	if string(fastData) != "ABC" || lastOffset != 2 || fastSub.Err() != io.EOF {
+		t.Errorf("bad transformed output %q (last offset %d), err: %v", fastData, lastOffset, fastSub.Err())
+	}
+	if lateSub := hub.subscribe(OutputSubOpts{}); lateSub.Err() != io.EOF {
+		t.Errorf("subscribing after the stream ended should return a closed subscription")
+	}
+}
+
+// round trips random bytes (NULs, invalid UTF-8, partial escape sequences...) from
+// the pty through the full output pipeline
+func TestOutputBinaryRoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no /dev/urandom on windows")
+	}
+	urandom, err := os.Open("/dev/urandom")
+	if err != nil {
+		t.Skipf("cannot open /dev/urandom: %v", err)
+	}
+	data := make([]byte, 512*1024)
+	_, err = io.ReadFull(urandom, data)
+	urandom.Close()
+	if err != nil {
+		t.Fatalf("error reading /dev/urandom: %v", err)
+	}
+	dataFile := filepath.Join(t.TempDir(), "random.bin")
+	if err := os.WriteFile(dataFile, data, 0600); err != nil {
+		t.Fatalf("error writing data file: %v", err)
+	}
+	// raw mode turns off output processing (e.g. \n -> \r\n) in the tty itself
+	cmdStr := fmt.Sprintf("read x; stty raw -echo; printf BEGIN:; cat %s; printf :END", utilfn.ShellQuote(dataFile, false, -1))
+	sp := startTestShellProc(t, cmdStr, CommandOptsType{})
+	sub := sp.SubscribeOutput(OutputSubOpts{BufSize: 4096})
+	defer sub.Close()
+	oc := collectOutput(sp)
+	if _, err := sp.Cmd.Write([]byte("\n")); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	var subData []byte
+	timeoutCh := time.After(testWaitTimeout)
+	for done := false; !done; {
+		select {
+		case chunk, ok := <-sub.Ch:
+			if !ok {
+				done = true
+				break
+			}
+			if chunk.Len != len(chunk.Data) || chunk.Offset < int64(len(subData)) {
+				t.Fatalf("bad chunk framing: offset %d, len %d (data %d)", chunk.Offset, chunk.Len, len(chunk.Data))
+			}
+			subData = append(subData, chunk.Data...)
+		case <-timeoutCh:
+			t.Fatalf("timeout waiting for output (got %d bytes)", len(subData))
+		}
+	}
+	if sub.Err() == nil || errors.Is(sub.Err(), ErrOutputSubOverflow) {
+		t.Fatalf("subscription closed with error: %v", sub.Err())
+	}
+	<-oc.Done
+	for name, output := range map[string][]byte{"subscription": subData, "reader": oc.Buf.Bytes()} {
+		startIdx := bytes.Index(output, []byte("BEGIN:"))
+		if startIdx < 0 || !bytes.HasSuffix(output, []byte(":END")) {
+			t.Fatalf("%s: missing markers in output (%d bytes)", name, len(output))
+		}
+		payload := output[startIdx+len("BEGIN:") : len(output)-len(":END")]
+		if !bytes.Equal(payload, data) {
+			t.Errorf("%s: output mismatch, got %d bytes, want %d", name, len(payload), len(data))
+		}
+	}
+}
+
+func TestRingBufferReplayTo(t *testing.T) {
+	rb := makeRingBuffer(7)
+	rb.Write([]byte("abé€z")) // 1+1+2+3+1 bytes, the ring keeps the last 7
+	data, offset := rb.replayTo(rb.Total, 0)
+	if string(data) != "bé€z" || offset != 1 {
+		t.Errorf("unexpected replay %q at %d", data, offset)
+	}
+	// the last 3 bytes start inside the euro sign
+	data, offset = rb.replayTo(rb.Total, 3)
+	if string(data) != "z" || offset != 7 {
+		t.Errorf("expected the partial character to be dropped, got %q at %d", data, offset)
+	}
+}
+
+func TestSubscribeOutputReplay(t *testing.T) {
+	sp := startTestShellProc(t, `echo "before-replay"; read x; echo "after-$x"`, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "before-replay")
+	sub := sp.SubscribeOutput(OutputSubOpts{Replay: true, Transform: bytes.ToUpper})
+	defer sub.Close()
+	var first OutputChunk
+	select {
+	case first = <-sub.Ch:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for the replay")
+	}
+	if first.Offset != 0 || !bytes.Contains(first.Data, []byte("BEFORE-REPLAY")) {
+		t.Errorf("expected the scrollback as the first chunk, got %q at %d", first.Data, first.Offset)
+	}
+	sp.Write([]byte("live\n"))
+	nextOffset := first.Offset + int64(first.Len)
+	var live []byte
+	for deadline := time.After(testWaitTimeout); !bytes.Contains(live, []byte("AFTER-LIVE")); {
+		select {
+		case chunk, ok := <-sub.Ch:
+			if !ok {
+				t.Fatalf("output ended before the live output, got %q", live)
+			}
+			if chunk.Offset != nextOffset {
+				t.Fatalf("expected the live output to continue at %d, got %d", nextOffset, chunk.Offset)
+			}
+			nextOffset += int64(chunk.Len)
+			live = append(live, chunk.Data...)
+		case <-deadline:
+			t.Fatalf("timeout waiting for the live output, got %q", live)
+		}
+	}
+	if bytes.Contains(live, []byte("BEFORE-REPLAY")) {
+		t.Errorf("the replay overlaps the live output: %q", live)
+	}
+}
+
+func TestOutputSubscriptionReaders(t *testing.T) {
+	sp := startTestShellProc(t, `read x; seq 1 20000`, CommandOptsType{})
+	readers := []io.ReadCloser{sp.SubscribeOutput(OutputSubOpts{}).Reader(), sp.SubscribeOutput(OutputSubOpts{BufSize: 4096}).Reader()}
+	oc := collectOutput(sp)
+	results := make([]chan []byte, len(readers))
+	for idx, reader := range readers {
+		results[idx] = make(chan []byte, 1)
+		go func() {
+			defer reader.Close()
+			data, err := io.ReadAll(reader)
+			if err != nil && !errors.Is(err, ErrOutputSubOverflow) {
+				t.Errorf("reader %d: unexpected error %v", idx, err)
+			}
+			results[idx] <- data
+		}()
+	}
+	sp.Write([]byte("\n"))
+	<-oc.Done
+	for idx, resultCh := range results {
+		select {
+		case data := <-resultCh:
+			// the default buffer can fall behind, what it got must still be a prefix of the stream
+			if !bytes.HasPrefix(oc.Buf.Bytes(), data) || (idx == 1 && !bytes.Equal(data, oc.Buf.Bytes())) {
+				t.Errorf("reader %d: got %d bytes that don't match the %d bytes read", idx, len(data), oc.Buf.Len())
+			}
+		case <-time.After(testWaitTimeout):
+			t.Fatalf("reader %d: timeout waiting for the output", idx)
+		}
+	}
+}
+
+func TestOutputFlowDropOldest(t *testing.T) {
+	hub := makeOutputHub()
+	sub := hub.subscribe(OutputSubOpts{FlowPolicy: FlowPolicy_DropOldest, HighWater: 10, LowWater: 4})
+	for _, str := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+		hub.Write([]byte(str))
+	}
+	hub.setErr(nil)
+	var got []byte
+	var nextOffset int64
+	for chunk := range sub.Ch {
+		if chunk.Offset < nextOffset {
+			t.Fatalf("chunk at %d is out of order", chunk.Offset)
+		}
+		nextOffset = chunk.Offset + int64(chunk.Len)
+		got = append(got, chunk.Data...)
+	}
+	if sub.Dropped() == 0 || int64(len(got))+sub.Dropped() != 16 || !bytes.HasSuffix(got, []byte("dddd")) {
+		t.Errorf("expected the oldest chunks to be dropped, got %q (%d dropped)", got, sub.Dropped())
+	}
+	if sub.Err() != io.EOF {
+		t.Errorf("expected io.EOF, got %v", sub.Err())
+	}
+}
+
+func TestOutputFlowBlock(t *testing.T) {
+	hub := makeOutputHub()
+	sub := hub.subscribe(OutputSubOpts{FlowPolicy: FlowPolicy_Block, HighWater: 8})
+	writeDone := make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		for _, str := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+			hub.Write([]byte(str))
+		}
+	}()
+	select {
+	case <-writeDone:
+		t.Fatalf("expected the writer to block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	var got []byte
+	for len(got) < 16 {
+		select {
+		case chunk := <-sub.Ch:
+			got = append(got, chunk.Data...)
+		case <-time.After(testWaitTimeout):
+			t.Fatalf("timeout reading, got %q", got)
+		}
+	}
+	if string(got) != "aaaabbbbccccdddd" {
+		t.Errorf("expected everything in order, got %q", got)
+	}
+	<-writeDone
+	// closing the subscription releases a blocked writer
+	writeDone = make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		for _, str := range []string{"eeee", "ffff", "gggg", "hhhh"} {
+			hub.Write([]byte(str))
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	sub.Close()
+	select {
+	case <-writeDone:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("expected the writer to be released by Close")
+	}
+}
+
+func TestOutputFlowBlockShell(t *testing.T) {
+	sp := startTestShellProc(t, `read x; seq 1 100000`, CommandOptsType{})
+	sub := sp.SubscribeOutput(OutputSubOpts{FlowPolicy: FlowPolicy_Block, HighWater: 16 * 1024})
+	oc := collectOutput(sp)
+	sp.Write([]byte("\n"))
+	var got []byte
+	for chunk := range sub.Ch {
+		got = append(got, chunk.Data...)
+		time.Sleep(time.Millisecond) // slower than the shell
+	}
+	<-oc.Done
+	if !bytes.Equal(got, oc.Buf.Bytes()) || sub.Dropped() != 0 {
+		t.Errorf("expected the blocking subscriber to get everything, got %d of %d bytes", len(got), oc.Buf.Len())
+	}
+}
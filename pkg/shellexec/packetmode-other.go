@@ -0,0 +1,16 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package shellexec
+
+import (
+	"errors"
+)
+
+const packetModeSupported = false
+
+func setPtyPacketMode(fd uintptr) error {
+	return errors.New("packet mode is not supported on this platform")
+}
@@ -0,0 +1,17 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const packetModeSupported = true
+
+// must be set before the pty is first read, every read then starts with a status byte (see ptyPacketReader)
+func setPtyPacketMode(fd uintptr) error {
+	return unix.IoctlSetPointerInt(int(fd), unix.TIOCPKT, 1)
+}
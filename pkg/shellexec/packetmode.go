@@ -0,0 +1,84 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"io"
+)
+
+// the status byte that starts every read from a pty in packet mode (TIOCPKT), the same values on linux and darwin
+const (
+	ptyPacket_Data       = 0
+	ptyPacket_FlushRead  = 1
+	ptyPacket_FlushWrite = 2
+	ptyPacket_Stop       = 4
+	ptyPacket_Start      = 8
+	ptyPacket_NoStop     = 16
+	ptyPacket_DoStop     = 32
+)
+
+// PtyControl is set on EventKind_PtyControl events (see CommandOptsType.PacketMode), it is what the
+// line discipline reports to the pty master, more than one flag can be set
+type PtyControl struct {
+	FlushRead  bool `json:"flushread,omitempty"`  // the tty's input queue was flushed (input that wasn't read yet is gone), e.g. tcflush or ^C
+	FlushWrite bool `json:"flushwrite,omitempty"` // the tty's output queue was flushed, some of the app's output was discarded
+	Stop       bool `json:"stop,omitempty"`       // output was stopped (^S with IXON, or tcflow TCOOFF), see ShellProc.IsOutputStopped
+	Start      bool `json:"start,omitempty"`      // output was restarted (^Q, or tcflow TCOON)
+	NoStop     bool `json:"nostop,omitempty"`     // flow control was turned off (-ixon), ^S and ^Q are plain input now
+	DoStop     bool `json:"dostop,omitempty"`     // flow control was turned on (ixon with the default ^S and ^Q)
+}
+
+func parsePtyControl(status byte) PtyControl {
+	return PtyControl{
+		FlushRead:  status&ptyPacket_FlushRead != 0,
+		FlushWrite: status&ptyPacket_FlushWrite != 0,
+		Stop:       status&ptyPacket_Stop != 0,
+		Start:      status&ptyPacket_Start != 0,
+		NoStop:     status&ptyPacket_NoStop != 0,
+		DoStop:     status&ptyPacket_DoStop != 0,
+	}
+}
+
+// ptyPacketReader strips the status byte from the reads of a pty in packet mode (each read is one
+// packet), control packets are handed to OnControl and read as zero bytes
+type ptyPacketReader struct {
+	Src       io.Reader
+	OnControl func(PtyControl)
+}
+
+func (r *ptyPacketReader) Read(p []byte) (int, error) {
+	if len(p) < 2 {
+		return 0, io.ErrShortBuffer
+	}
+	nr, err := r.Src.Read(p)
+	if nr == 0 {
+		return 0, err
+	}
+	if p[0] != ptyPacket_Data {
+		r.OnControl(parsePtyControl(p[0]))
+		return 0, err
+	}
+	return copy(p, p[1:nr]), err
+}
+
+func resolvePacketMode(cmdOpts CommandOptsType) error {
+	if cmdOpts.PacketMode && !packetModeSupported {
+		return fmt.Errorf("PacketMode is not supported on this platform")
+	}
+	return nil
+}
+
+func (sp *ShellProc) handlePtyControl(ctl PtyControl) {
+	if ctl.Stop || ctl.Start || ctl.NoStop {
+		sp.outputStopped.Store(ctl.Stop && !ctl.Start)
+	}
+	sp.events.publish(ShellEvent{Kind: EventKind_PtyControl, PtyControl: &ctl})
+}
+
+// IsOutputStopped returns true while the shell's output is stopped by flow control (^S, until ^Q),
+// only tracked for shells started with CommandOptsType.PacketMode
+func (sp *ShellProc) IsOutputStopped() bool {
+	return sp.outputStopped.Load()
+}
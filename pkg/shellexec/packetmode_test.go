@@ -0,0 +1,64 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestPacketMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no packet mode on windows")
+	}
+	requireBinary(t, "stty")
+	sp := startTestShellProc(t, `stty ixon; echo "ready"; read x; echo "got-$x"; stty -ixon; read y`, CommandOptsType{PacketMode: true})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready")
+	sp.Write([]byte{0x13})
+	if event := waitEventKind(t, eventCh, EventKind_PtyControl); !event.PtyControl.Stop {
+		t.Errorf("expected a stop event, got %+v", event.PtyControl)
+	}
+	if !sp.IsOutputStopped() {
+		t.Errorf("expected the output to be stopped after ^S")
+	}
+	sp.Write([]byte{0x11})
+	if event := waitEventKind(t, eventCh, EventKind_PtyControl); !event.PtyControl.Start {
+		t.Errorf("expected a start event, got %+v", event.PtyControl)
+	}
+	if sp.IsOutputStopped() {
+		t.Errorf("expected the output to be restarted after ^Q")
+	}
+	sp.Write([]byte("pkt\n"))
+	// the status bytes are stripped, the data is passed through
+	oc.waitFor(t, "got-pkt\r\n")
+	if event := waitEventKind(t, eventCh, EventKind_PtyControl); !event.PtyControl.NoStop {
+		t.Errorf("expected a nostop event for -ixon, got %+v", event.PtyControl)
+	}
+}
+
+func TestPtyPacketReader(t *testing.T) {
+	var controls []PtyControl
+	reader := &ptyPacketReader{
+		Src:       &chunkReader{Chunks: [][]byte{{ptyPacket_Data, 'h', 'i'}, {ptyPacket_FlushRead | ptyPacket_FlushWrite}, {ptyPacket_Data, '!'}}},
+		OnControl: func(ctl PtyControl) { controls = append(controls, ctl) },
+	}
+	var output []byte
+	buf := make([]byte, 16)
+	for {
+		nr, err := reader.Read(buf)
+		output = append(output, buf[:nr]...)
+		if err != nil {
+			break
+		}
+	}
+	if string(output) != "hi!" {
+		t.Errorf("expected the data without status bytes, got %q", output)
+	}
+	if len(controls) != 1 || !controls[0].FlushRead || !controls[0].FlushWrite || controls[0].Stop {
+		t.Errorf("unexpected controls %+v", controls)
+	}
+}
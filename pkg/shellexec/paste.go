@@ -0,0 +1,295 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+)
+
+const (
+	DefaultPasteChunkSize  = 1024
+	DefaultPasteChunkDelay = 2 * time.Millisecond
+	MaxPasteChunkDelay     = 500 * time.Millisecond // cap for latency-derived delays
+	pastePausePollInterval = 50 * time.Millisecond  // how often a paste into a paused shell checks for Resume
+)
+
+const (
+	PasteBracket_Auto   = "auto" // use bracketed paste if the application has enabled it
+	PasteBracket_Always = "always"
+	PasteBracket_Never  = "never"
+)
+
+type PasteOpts struct {
+	ChunkSize  int           `json:"chunksize,omitempty"`
+	ChunkDelay time.Duration `json:"chunkdelay,omitempty"` // delay between chunks (raised to the round trip time for remote connections)
+	Bracket    string        `json:"bracket,omitempty"`    // PasteBracket_* (defaults to auto)
+}
+
+// WriteInputOpts controls how WriteInput frames data
+type WriteInputOpts struct {
+	Bracket           string `json:"bracket,omitempty"`           // PasteBracket_* (defaults to never, data is written as typed)
+	NormalizeNewlines bool   `json:"normalizenewlines,omitempty"` // \r\n, \n and \r become the Enter the pty expects (\r inside a paste)
+	Sanitize          bool   `json:"sanitize,omitempty"`          // strip escape sequences and control characters (see SanitizePaste)
+
+	// with Sanitize, asked before a risky paste is written (nil writes the sanitized paste)
+	Policy PastePolicy `json:"-"`
+}
+
+// PasteStatus is the progress of a WriteLarge paste (ShellEvent.Paste)
+type PasteStatus struct {
+	Written int64  `json:"written"`
+	Total   int64  `json:"total"`
+	Done    bool   `json:"done,omitempty"`
+	Err     string `json:"err,omitempty"` // set with Done if the paste was cancelled or failed
+}
+
+// ProgressHandle tracks a paste started with WriteLarge
+type ProgressHandle interface {
+	// Progress returns the number of data bytes written so far and the total
+	Progress() (written int64, total int64)
+	// Done is closed when the paste finishes (successfully, cancelled, or failed)
+	Done() <-chan struct{}
+	// Err returns nil on success (or while still running), ctx.Err() if the paste was cancelled, or the write error
+	Err() error
+	Cancel()
+}
+
+type pasteProgress struct {
+	Sp       *ShellProc // publishes EventKind_Paste events if set
+	Lock     *sync.Mutex
+	Written  int64
+	Total    int64
+	DoneCh   chan struct{}
+	DoneErr  error
+	CancelFn context.CancelFunc
+}
+
+func (pp *pasteProgress) Progress() (int64, int64) {
+	pp.Lock.Lock()
+	defer pp.Lock.Unlock()
+	return pp.Written, pp.Total
+}
+
+func (pp *pasteProgress) Done() <-chan struct{} {
+	return pp.DoneCh
+}
+
+func (pp *pasteProgress) Err() error {
+	pp.Lock.Lock()
+	defer pp.Lock.Unlock()
+	return pp.DoneErr
+}
+
+func (pp *pasteProgress) Cancel() {
+	pp.CancelFn()
+}
+
+func (pp *pasteProgress) addWritten(n int) {
+	pp.Lock.Lock()
+	pp.Written += int64(n)
+	status := PasteStatus{Written: pp.Written, Total: pp.Total}
+	pp.Lock.Unlock()
+	pp.publish(status)
+}
+
+func (pp *pasteProgress) finish(err error) {
+	pp.Lock.Lock()
+	pp.DoneErr = err
+	status := PasteStatus{Written: pp.Written, Total: pp.Total, Done: true}
+	pp.Lock.Unlock()
+	if err != nil {
+		status.Err = err.Error()
+	}
+	pp.publish(status)
+	close(pp.DoneCh)
+}
+
+func (pp *pasteProgress) publish(status PasteStatus) {
+	if pp.Sp != nil {
+		pp.Sp.events.publish(ShellEvent{Kind: EventKind_Paste, Paste: &status})
+	}
+}
+
+// resolves a PasteBracket_* option
+func (sp *ShellProc) useBracketedPaste(bracket string) (bool, error) {
+	switch bracket {
+	case "", PasteBracket_Auto:
+		return sp.BracketedPasteEnabled(), nil
+	case PasteBracket_Always:
+		return true, nil
+	case PasteBracket_Never:
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid paste bracket option %q", bracket)
+	}
+}
+
+// the bytes a terminal sends for data inside a paste: newlines are \r and the paste markers are dropped
+// so the data can't end the paste early (and run what follows)
+func makeBracketedInput(data []byte, normalizeNewlines bool) []byte {
+	data = bytes.ReplaceAll(data, []byte(BracketedPasteEnd), nil)
+	data = bytes.ReplaceAll(data, []byte(BracketedPasteStart), nil)
+	if normalizeNewlines {
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\r"))
+		data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r"))
+	}
+	return data
+}
+
+// WriteInput writes data to the pty, as a bracketed paste if opts.Bracket picks one (the data is
+// then stripped of paste markers, see makeBracketedInput).  with opts.NormalizeNewlines line endings
+// are translated for the pty's current modes (see TranslateNewlines).  control characters are passed
+// through unless opts.Sanitize.  Returns ErrPasteRefused if opts.Policy refused the paste and
+// ErrShellExited if the shell is gone.
+func (sp *ShellProc) WriteInput(data []byte, opts WriteInputOpts) error {
+	bracket := opts.Bracket
+	if bracket == "" {
+		bracket = PasteBracket_Never
+	}
+	bracketed, err := sp.useBracketedPaste(bracket)
+	if err != nil {
+		return err
+	}
+	if sp.shellGone() {
+		return ErrShellExited
+	}
+	if opts.Sanitize {
+		check := SanitizePaste(data, bracketed)
+		if len(check.Risks) > 0 && opts.Policy != nil && !opts.Policy(check) {
+			return ErrPasteRefused
+		}
+		data = check.Data
+	}
+	if bracketed {
+		data = makeBracketedInput(data, opts.NormalizeNewlines)
+	} else if opts.NormalizeNewlines {
+		data = TranslateNewlines(data, sp.ttyModesOrDefault())
+	}
+	progress := &pasteProgress{Lock: &sync.Mutex{}, Total: int64(len(data))}
+	err = sp.writeChunks(context.Background(), data, DefaultPasteChunkSize, sp.pasteChunkDelay(PasteOpts{}), bracketed, progress)
+	if err != nil {
+		if sp.shellGone() {
+			return ErrShellExited
+		}
+		return err
+	}
+	return nil
+}
+
+func (sp *ShellProc) pasteChunkDelay(opts PasteOpts) time.Duration {
+	delay := opts.ChunkDelay
+	if delay <= 0 {
+		delay = DefaultPasteChunkDelay
+	}
+	if rtt, ok := sp.measureRoundTrip(); ok && rtt > delay {
+		delay = min(rtt, MaxPasteChunkDelay)
+	}
+	return delay
+}
+
+// WriteLarge writes data to the pty in the background, in chunks with a small
+// delay between them so large pastes don't overwhelm the pty (or the remote
+// connection).  The progress is on the handle and in EventKind_Paste events, and
+// the paste waits while the shell is paused (see Pause) instead of filling the
+// pty's buffer.  If bracketed paste is used, the closing bracket is always
+// written, even if the paste is cancelled (via ctx or the handle) or fails.
+// Returns ErrShellExited if the shell is gone.
+func (sp *ShellProc) WriteLarge(ctx context.Context, data []byte, opts PasteOpts) (ProgressHandle, error) {
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultPasteChunkSize
+	}
+	bracketed, err := sp.useBracketedPaste(opts.Bracket)
+	if err != nil {
+		return nil, err
+	}
+	if sp.shellGone() {
+		return nil, ErrShellExited
+	}
+	chunkDelay := sp.pasteChunkDelay(opts)
+	pasteCtx, cancelFn := context.WithCancel(ctx)
+	progress := &pasteProgress{
+		Sp:       sp,
+		Lock:     &sync.Mutex{},
+		Total:    int64(len(data)),
+		DoneCh:   make(chan struct{}),
+		CancelFn: cancelFn,
+	}
+	go func() {
+		defer panichandler.PanicHandler("ShellProc.WriteLarge")
+		defer cancelFn()
+		progress.finish(sp.writeChunks(pasteCtx, data, chunkSize, chunkDelay, bracketed, progress))
+	}()
+	return progress, nil
+}
+
+func (sp *ShellProc) writeChunks(ctx context.Context, data []byte, chunkSize int, chunkDelay time.Duration, bracketed bool, progress *pasteProgress) (rtnErr error) {
+	if bracketed {
+		if _, err := sp.Write([]byte(BracketedPasteStart)); err != nil {
+			return err
+		}
+		defer func() {
+			if _, err := sp.Write([]byte(BracketedPasteEnd)); err != nil && rtnErr == nil {
+				rtnErr = err
+			}
+		}()
+	}
+	for pos := 0; pos < len(data); pos += chunkSize {
+		if pos > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-sp.clock.After(chunkDelay):
+			}
+		}
+		if err := sp.waitUnpaused(ctx); err != nil {
+			return err
+		}
+		chunk := data[pos:min(pos+chunkSize, len(data))]
+		nw, err := sp.Write(chunk)
+		progress.addWritten(nw)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns ctx.Err() if ctx is done (or ErrShellExited if the shell went away) before the shell is resumed
+func (sp *ShellProc) waitUnpaused(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !sp.IsPaused() {
+			return nil
+		}
+		if sp.shellGone() {
+			return ErrShellExited
+		}
+		select {
+		case <-ctx.Done():
+		case <-sp.clock.After(pastePausePollInterval):
+		}
+	}
+}
+
+// measureRoundTrip times a keepalive request on the ssh connection, ok is false for local (and wsl) shellprocs
+func (sp *ShellProc) measureRoundTrip() (time.Duration, bool) {
+	if sp.sshClient == nil {
+		return 0, false
+	}
+	startTs := sp.clock.Now()
+	_, _, err := sp.sshClient.SendRequest("keepalive@openssh.com", true, nil)
+	if err != nil {
+		return 0, false
+	}
+	return sp.clock.Now().Sub(startTs), true
+}
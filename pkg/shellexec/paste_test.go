@@ -0,0 +1,187 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/util/utilfn"
+)
+
+func makePasteData(numLines int) []byte {
+	var buf bytes.Buffer
+	for idx := 0; idx < numLines; idx++ {
+		fmt.Fprintf(&buf, "line %05d: the quick brown fox jumps over the lazy dog\n", idx)
+	}
+	return buf.Bytes()
+}
+
+// starts "cat > file" (with echo off) and returns the shellproc, its output, and the file path
+func startPasteTarget(t *testing.T) (*ShellProc, *outputCollector, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no stty on windows")
+	}
+	outFile := filepath.Join(t.TempDir(), "paste.out")
+	cmdStr := fmt.Sprintf("stty -echo; echo ready; cat > %s; echo done", utilfn.ShellQuote(outFile, false, -1))
+	sp := startTestShellProc(t, cmdStr, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready\r\n")
+	return sp, oc, outFile
+}
+
+func waitPasteDone(t *testing.T, handle ProgressHandle) {
+	t.Helper()
+	select {
+	case <-handle.Done():
+	case <-time.After(testWaitTimeout):
+		written, total := handle.Progress()
+		t.Fatalf("timeout waiting for paste (%d/%d bytes written)", written, total)
+	}
+}
+
+// sends EOF to cat and returns what it wrote
+func finishPasteTarget(t *testing.T, sp *ShellProc, oc *outputCollector, outFile string) []byte {
+	t.Helper()
+	// the first ^D flushes any partial line, the second one is EOF
+	if _, err := sp.Cmd.Write([]byte{0x04, 0x04}); err != nil {
+		t.Fatalf("error writing EOF: %v", err)
+	}
+	oc.waitFor(t, "done\r\n")
+	rtn, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("error reading paste output: %v", err)
+	}
+	return rtn
+}
+
+func TestWriteLarge(t *testing.T) {
+	sp, oc, outFile := startPasteTarget(t)
+	data := makePasteData(2000)
+	handle, err := sp.WriteLarge(context.Background(), data, PasteOpts{ChunkSize: 512, ChunkDelay: time.Millisecond})
+	if err != nil {
+		t.Fatalf("WriteLarge error: %v", err)
+	}
+	waitPasteDone(t, handle)
+	if handle.Err() != nil {
+		t.Fatalf("paste error: %v", handle.Err())
+	}
+	written, total := handle.Progress()
+	if written != int64(len(data)) || total != int64(len(data)) {
+		t.Errorf("bad progress %d/%d, want %d", written, total, len(data))
+	}
+	result := finishPasteTarget(t, sp, oc, outFile)
+	if !bytes.Equal(result, data) {
+		t.Errorf("pasted data mismatch: got %d bytes, want %d", len(result), len(data))
+	}
+}
+
+func TestWriteLargeCancel(t *testing.T) {
+	sp, oc, outFile := startPasteTarget(t)
+	data := makePasteData(1000)
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	handle, err := sp.WriteLarge(ctx, data, PasteOpts{ChunkSize: 256, ChunkDelay: 10 * time.Millisecond, Bracket: PasteBracket_Always})
+	if err != nil {
+		t.Fatalf("WriteLarge error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancelFn()
+	waitPasteDone(t, handle)
+	if !errors.Is(handle.Err(), context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", handle.Err())
+	}
+	written, _ := handle.Progress()
+	if written == 0 || written >= int64(len(data)) {
+		t.Fatalf("expected a partial paste, wrote %d of %d bytes", written, len(data))
+	}
+	result := finishPasteTarget(t, sp, oc, outFile)
+	expected := BracketedPasteStart + string(data[:written]) + BracketedPasteEnd
+	if string(result) != expected {
+		t.Errorf("cancelled paste mismatch: got %d bytes, want %d (closing bracket present: %v)", len(result), len(expected), bytes.HasSuffix(result, []byte(BracketedPasteEnd)))
+	}
+}
+
+func TestWriteLargeBadOpts(t *testing.T) {
+	sp := startTestShellProc(t, "sleep 5", CommandOptsType{})
+	if _, err := sp.WriteLarge(context.Background(), []byte("x"), PasteOpts{Bracket: "sometimes"}); err == nil {
+		t.Errorf("expected an error for an invalid bracket option")
+	}
+}
+
+func TestWriteLargeExited(t *testing.T) {
+	sp := startTestShellProc(t, "true", CommandOptsType{})
+	waitExitStatus(t, sp)
+	if _, err := sp.WriteLarge(context.Background(), []byte("x"), PasteOpts{}); !errors.Is(err, ErrShellExited) {
+		t.Errorf("expected ErrShellExited, got %v", err)
+	}
+}
+
+func TestWriteInput(t *testing.T) {
+	sp, oc, outFile := startPasteTarget(t)
+	// cat's pty has ICRNL on, the \r a paste uses for newlines arrives as \n
+	if err := sp.WriteInput([]byte("one\r\ntwo"+BracketedPasteEnd+"; three\n"), WriteInputOpts{Bracket: PasteBracket_Always, NormalizeNewlines: true}); err != nil {
+		t.Fatalf("WriteInput error: %v", err)
+	}
+	if err := sp.WriteInput([]byte("four\r\nfive\n"), WriteInputOpts{NormalizeNewlines: true}); err != nil {
+		t.Fatalf("WriteInput error: %v", err)
+	}
+	result := finishPasteTarget(t, sp, oc, outFile)
+	expected := BracketedPasteStart + "one\ntwo; three\n" + BracketedPasteEnd + "four\nfive\n"
+	if string(result) != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+	if err := sp.WriteInput([]byte("x"), WriteInputOpts{Bracket: "sometimes"}); err == nil {
+		t.Errorf("expected an error for an invalid bracket option")
+	}
+}
+
+func TestWriteLargePaused(t *testing.T) {
+	sp, oc, outFile := startPasteTarget(t)
+	events, unsub := sp.SubscribeEvents(DefaultEventBufferSize)
+	defer unsub()
+	if err := sp.Pause(); err != nil {
+		t.Fatalf("Pause error: %v", err)
+	}
+	data := makePasteData(500)
+	handle, err := sp.WriteLarge(context.Background(), data, PasteOpts{ChunkSize: 512, ChunkDelay: time.Millisecond})
+	if err != nil {
+		t.Fatalf("WriteLarge error: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if written, _ := handle.Progress(); written != 0 {
+		t.Errorf("expected the paste to wait while paused, %d bytes written", written)
+	}
+	if err := sp.Resume(); err != nil {
+		t.Fatalf("Resume error: %v", err)
+	}
+	waitPasteDone(t, handle)
+	if handle.Err() != nil {
+		t.Fatalf("paste error: %v", handle.Err())
+	}
+	var last *PasteStatus
+	for last == nil || !last.Done {
+		select {
+		case event := <-events:
+			if event.Kind == EventKind_Paste {
+				last = event.Paste
+			}
+		case <-time.After(testWaitTimeout):
+			t.Fatalf("timeout waiting for the paste's done event, last %+v", last)
+		}
+	}
+	if last.Written != int64(len(data)) || last.Total != int64(len(data)) || last.Err != "" {
+		t.Errorf("unexpected done event %+v", last)
+	}
+	if result := finishPasteTarget(t, sp, oc, outFile); !bytes.Equal(result, data) {
+		t.Errorf("pasted data mismatch: got %d bytes, want %d", len(result), len(data))
+	}
+}
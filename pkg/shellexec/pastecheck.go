@@ -0,0 +1,98 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"unicode"
+	"unicode/utf8"
+)
+
+// what makes a paste risky (PasteCheck.Risks)
+const (
+	PasteRisk_Newline = "newline" // has a line ending and isn't bracketed, the shell runs each line as it arrives
+	PasteRisk_Escape  = "escape"  // has escape sequences (they can type keys, move the cursor or end a paste early)
+	PasteRisk_Control = "control" // has other control characters (^C, ^D, ^Z, ...)
+)
+
+var ErrPasteRefused = errors.New("paste was refused")
+
+// PastePolicy is asked before a risky paste is written (WriteInputOpts.Policy), false refuses it.  it may
+// block (e.g. while the user confirms), check.Data is what would be written
+type PastePolicy func(check PasteCheck) bool
+
+// PasteCheck is the result of SanitizePaste
+type PasteCheck struct {
+	Data     []byte   `json:"-"`               // the paste without escape sequences and control characters (\t, \n and \r are kept)
+	Risks    []string `json:"risks,omitempty"` // PasteRisk_*, empty for a safe paste
+	Stripped int      `json:"stripped"`        // escape sequences and control characters removed
+}
+
+func (pc PasteCheck) HasRisk(risk string) bool {
+	for _, r := range pc.Risks {
+		if r == risk {
+			return true
+		}
+	}
+	return false
+}
+
+func (pc *PasteCheck) addRisk(risk string) {
+	if !pc.HasRisk(risk) {
+		pc.Risks = append(pc.Risks, risk)
+	}
+}
+
+// SanitizePaste strips escape sequences (whole, using the output parser) and control characters other than
+// \t, \n and \r (C1 and DEL included) from data and reports what was risky about it.  bracketed is whether
+// the paste will be bracketed, line endings are only a risk without it
+func SanitizePaste(data []byte, bracketed bool) PasteCheck {
+	var check PasteCheck
+	check.Data = make([]byte, 0, len(data))
+	inOverflow := false // the last token was a chunk of an over-long sequence
+	handleTok := func(tok seqToken) {
+		wasOverflow := inOverflow
+		inOverflow = tok.Overflow
+		switch tok.Type {
+		case tokType_Text:
+			check.addText(tok.Raw)
+		case tokType_Bell:
+			check.Stripped++
+			check.addRisk(PasteRisk_Control)
+		default:
+			if tok.Overflow && wasOverflow {
+				// another chunk of the same sequence
+				return
+			}
+			check.Stripped++
+			check.addRisk(PasteRisk_Escape)
+		}
+	}
+	parser := makeSeqParser()
+	parser.Feed(data, handleTok)
+	parser.Flush(handleTok)
+	if !bracketed {
+		for _, ch := range check.Data {
+			if ch == '\n' || ch == '\r' {
+				check.addRisk(PasteRisk_Newline)
+				break
+			}
+		}
+	}
+	return check
+}
+
+func (pc *PasteCheck) addText(text []byte) {
+	for len(text) > 0 {
+		ch, size := utf8.DecodeRune(text)
+		if ch != '\n' && ch != '\t' && ch != '\r' && size > 0 && unicode.IsControl(ch) {
+			pc.Stripped++
+			pc.addRisk(PasteRisk_Control)
+		} else {
+			// invalid utf-8 is passed through, it's the shell's to deal with
+			pc.Data = append(pc.Data, text[:size]...)
+		}
+		text = text[size:]
+	}
+}
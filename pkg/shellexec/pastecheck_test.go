@@ -0,0 +1,63 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSanitizePaste(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Data      string
+		Bracketed bool
+		Expected  string
+		Risks     []string
+		Stripped  int
+	}{
+		{"safe", "ls -la\tfoo", false, "ls -la\tfoo", nil, 0},
+		{"newline", "ls\nrm -rf ~\n", false, "ls\nrm -rf ~\n", []string{PasteRisk_Newline}, 0},
+		{"bracketed newline", "ls\r\nrm -rf ~", true, "ls\r\nrm -rf ~", nil, 0},
+		{"paste end", "echo a" + BracketedPasteEnd + "rm -rf ~\r", true, "echo arm -rf ~\r", []string{PasteRisk_Escape}, 1},
+		{"osc and csi", "a\x1b]0;title\x07b\x1b[2Jc", false, "abc", []string{PasteRisk_Escape}, 2},
+		{"control", "a\x03b\x7fc\u0085d\x07", false, "abcd", []string{PasteRisk_Control}, 4},
+		{"utf-8", "héllo 世界 \xff", false, "héllo 世界 \xff", nil, 0},
+		{"trailing esc", "a\x1b", false, "a", []string{PasteRisk_Escape}, 1},
+		{"oversize", "a\x1b]0;" + strings.Repeat("x", MaxStringSeqLen+10) + "\x07b", false, "ab", []string{PasteRisk_Escape}, 1},
+	}
+	for _, test := range tests {
+		check := SanitizePaste([]byte(test.Data), test.Bracketed)
+		if string(check.Data) != test.Expected || !slices.Equal(check.Risks, test.Risks) || check.Stripped != test.Stripped {
+			t.Errorf("%s: expected %q %v (%d stripped), got %q %v (%d stripped)", test.Name, test.Expected, test.Risks, test.Stripped, check.Data, check.Risks, check.Stripped)
+		}
+	}
+}
+
+func TestWriteInputPolicy(t *testing.T) {
+	sp, oc, outFile := startPasteTarget(t)
+	var asked []PasteCheck
+	policy := func(check PasteCheck) bool {
+		asked = append(asked, check)
+		return !check.HasRisk(PasteRisk_Newline)
+	}
+	opts := WriteInputOpts{Sanitize: true, Policy: policy}
+	if err := sp.WriteInput([]byte("one\ntwo\n"), opts); !errors.Is(err, ErrPasteRefused) {
+		t.Fatalf("expected ErrPasteRefused, got %v", err)
+	}
+	if err := sp.WriteInput([]byte("th\x1b[Aree"), opts); err != nil {
+		t.Fatalf("WriteInput error: %v", err)
+	}
+	if err := sp.WriteInput([]byte("\n"), WriteInputOpts{}); err != nil {
+		t.Fatalf("WriteInput error: %v", err)
+	}
+	if len(asked) != 2 || !asked[1].HasRisk(PasteRisk_Escape) {
+		t.Errorf("expected the policy to be asked about both pastes, got %+v", asked)
+	}
+	if result := finishPasteTarget(t, sp, oc, outFile); string(result) != "three\n" {
+		t.Errorf("expected only the sanitized paste, got %q", result)
+	}
+}
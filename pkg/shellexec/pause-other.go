@@ -0,0 +1,10 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package shellexec
+
+func setProcStopped(pid int, stopped bool) error {
+	return ErrSignalNotSupported
+}
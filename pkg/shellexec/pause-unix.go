@@ -0,0 +1,17 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func setProcStopped(pid int, stopped bool) error {
+	if stopped {
+		return unix.Kill(pid, unix.SIGSTOP)
+	}
+	return unix.Kill(pid, unix.SIGCONT)
+}
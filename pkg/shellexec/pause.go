@@ -0,0 +1,62 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+// Pause freezes the shell and its whole process tree (SIGSTOP to the shell, its descendants and
+// on linux everything else in its session), e.g. to stop a resource-heavy block command without
+// killing it.  Output stops, input is buffered by the pty until Resume.  The shell is stopped
+// first so a job control shell doesn't see its foreground job stop (and take the terminal back).
+// Local shells only (not on windows), pausing a paused shell is a no-op.  Close resumes a paused
+// shell so it gets its shutdown signals
+func (sp *ShellProc) Pause() error {
+	pid := sp.localPid()
+	if pid <= 0 {
+		return ErrSignalNotSupported
+	}
+	if sp.shellGone() {
+		return ErrShellExited
+	}
+	sp.pauseLock.Lock()
+	defer sp.pauseLock.Unlock()
+	if sp.paused {
+		return nil
+	}
+	if err := setProcStopped(pid, true); err != nil {
+		return err
+	}
+	for _, proc := range listProcTree(pid) {
+		// exited (or not ours to stop), the rest are still stopped
+		setProcStopped(proc.Pid, true)
+	}
+	sp.paused = true
+	sp.logf("paused\n")
+	return nil
+}
+
+// Resume continues a shell stopped by Pause (SIGCONT, its descendants before the shell itself).
+// Resuming a shell that isn't paused is a no-op
+func (sp *ShellProc) Resume() error {
+	sp.pauseLock.Lock()
+	defer sp.pauseLock.Unlock()
+	if !sp.paused {
+		return nil
+	}
+	pid := sp.localPid()
+	if pid <= 0 {
+		return ErrSignalNotSupported
+	}
+	for _, proc := range listProcTree(pid) {
+		setProcStopped(proc.Pid, false)
+	}
+	sp.paused = false
+	sp.logf("resumed\n")
+	return setProcStopped(pid, false)
+}
+
+// IsPaused returns true between Pause and Resume
+func (sp *ShellProc) IsPaused() bool {
+	sp.pauseLock.Lock()
+	defer sp.pauseLock.Unlock()
+	return sp.paused
+}
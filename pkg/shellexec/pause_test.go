@@ -0,0 +1,58 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPauseResume(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("processes can't be stopped on windows")
+	}
+	sp := startTestShellProc(t, `i=0; while true; do echo "tick-$i"; i=$((i+1)); sleep 0.02; done`, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "tick-3")
+	if err := sp.Pause(); err != nil {
+		t.Fatalf("error pausing: %v", err)
+	}
+	if !sp.IsPaused() {
+		t.Errorf("expected IsPaused")
+	}
+	if err := sp.Pause(); err != nil {
+		t.Errorf("pausing again should be a no-op, got %v", err)
+	}
+	// whatever was already written can still arrive
+	time.Sleep(100 * time.Millisecond)
+	pausedLen := len(oc.String())
+	time.Sleep(200 * time.Millisecond)
+	if output := oc.String(); len(output) != pausedLen {
+		t.Errorf("expected no output while paused, got %q", output[pausedLen:])
+	}
+	if err := sp.Resume(); err != nil {
+		t.Fatalf("error resuming: %v", err)
+	}
+	if sp.IsPaused() {
+		t.Errorf("expected the shell not to be paused after Resume")
+	}
+	lastTick := oc.String()[strings.LastIndex(oc.String(), "tick-"):]
+	for deadline := time.Now().Add(testWaitTimeout); strings.HasSuffix(oc.String(), lastTick); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected output after Resume")
+		}
+	}
+	// a paused shell still gets its shutdown signals
+	if err := sp.Pause(); err != nil {
+		t.Fatalf("error pausing: %v", err)
+	}
+	sp.Close()
+	select {
+	case <-sp.DoneCh:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for the paused shell to close")
+	}
+}
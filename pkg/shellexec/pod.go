@@ -0,0 +1,109 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+)
+
+const kubectlToolName = "kubectl"
+
+const podDefaultShell = "/bin/sh"
+
+// kubectl exec has no workdir flag
+const podCwdScript = `cd "$0" && exec "$@"`
+
+// pod, namespace and container names (dns labels and subdomains)
+var kubeNameRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?$`)
+
+// PodTarget is CommandOptsType.Pod, the pod (and container) a shell runs in
+type PodTarget struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"` // the context's namespace if empty
+	Container string `json:"container,omitempty"` // the pod's default container if empty
+	Context   string `json:"context,omitempty"`   // the kubeconfig context, the current one if empty
+	Kubectl   string `json:"kubectl,omitempty"`   // kubectl's path, kubectl from PATH if empty
+}
+
+// resolves the kubectl path
+func resolvePod(cmdOpts CommandOptsType) (string, error) {
+	pod := cmdOpts.Pod
+	if pod == nil {
+		return "", nil
+	}
+	for _, name := range []string{pod.Name, pod.Namespace, pod.Container} {
+		if name != "" && !kubeNameRe.MatchString(name) {
+			return "", fmt.Errorf("invalid pod, namespace or container name %q", name)
+		}
+	}
+	if pod.Name == "" {
+		return "", fmt.Errorf("Pod requires a pod name")
+	}
+	if strings.HasPrefix(pod.Context, "-") {
+		return "", fmt.Errorf("invalid kubeconfig context %q", pod.Context)
+	}
+	if cmdOpts.WslDistro != "" || cmdOpts.Container != "" || cmdOpts.Elevate || cmdOpts.Uid != nil || cmdOpts.Supervise != nil {
+		return "", fmt.Errorf("Pod cannot be combined with WslDistro, Container, Elevate, Uid or Supervise")
+	}
+	tool := pod.Kubectl
+	if tool == "" {
+		tool = kubectlToolName
+	}
+	toolPath, err := exec.LookPath(tool)
+	if err != nil {
+		return "", fmt.Errorf("cannot run shell in pod %q: %w", pod.Name, err)
+	}
+	return toolPath, nil
+}
+
+// podExecCmd runs "kubectl exec -i -t" (with a tty on its stdin kubectl sends the pty's resizes
+// through the exec subresource, and it exits with the shell's code).  without a ShellPath the
+// container's /bin/sh is run, Cwd is a path in the container.  the env is added by addPodEnvArgs
+func podExecCmd(toolPath string, cmdOpts CommandOptsType, cmdStr string, argv []string) *exec.Cmd {
+	pod := cmdOpts.Pod
+	var args []string
+	if pod.Context != "" {
+		args = append(args, "--context", pod.Context)
+	}
+	args = append(args, "exec", "-i", "-t")
+	if pod.Namespace != "" {
+		args = append(args, "-n", pod.Namespace)
+	}
+	if pod.Container != "" {
+		args = append(args, "-c", pod.Container)
+	}
+	args = append(args, pod.Name, "--")
+	if cmdOpts.Cwd != "" {
+		args = append(args, podDefaultShell, "-c", podCwdScript, cmdOpts.Cwd)
+	}
+	args = append(args, offHostArgv(cmdOpts, cmdStr, argv, podDefaultShell)...)
+	return exec.Command(toolPath, args...)
+}
+
+// addPodEnvArgs passes the vars from our layers (and Env) into the pod by running the command
+// through env.  kubectl exec can't set env vars, so unlike Container the values are on the
+// command line (kubectl's and, in the pod, env's until it execs)
+func addPodEnvArgs(ecmd *exec.Cmd, envReport shellutil.EnvReport) {
+	envArgs := []string{"env"}
+	for _, name := range ourEnvNames(ecmd, envReport) {
+		if hostOnlyVarNames[strings.ToUpper(name)] {
+			continue
+		}
+		if val, ok := cmdEnvLookup(ecmd, name); ok {
+			envArgs = append(envArgs, name+"="+val)
+		}
+	}
+	if len(envArgs) == 1 {
+		return
+	}
+	// right after "--"
+	sepIdx := slices.Index(ecmd.Args, "--")
+	ecmd.Args = slices.Insert(ecmd.Args, sepIdx+1, envArgs...)
+}
@@ -0,0 +1,102 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+// a stand-in for kubectl: "exec" prints its flags and runs everything after "--" on the host
+const fakeKubectlScript = `#!/bin/sh
+if [ "$1" = "--context" ]; then echo "context $2"; shift 2; fi
+[ "$1" = "exec" ] || exit 2
+shift
+while [ "$#" -gt 0 ]; do
+	case "$1" in
+	-i|-t) shift ;;
+	-n) echo "namespace $2"; shift 2 ;;
+	--) shift; break ;;
+	*) echo "pod $1"; shift ;;
+	esac
+done
+exec "$@"
+`
+
+func makeFakeKubectl(t *testing.T) string {
+	t.Helper()
+	toolPath := filepath.Join(t.TempDir(), "kubectl")
+	if err := os.WriteFile(toolPath, []byte(fakeKubectlScript), 0755); err != nil {
+		t.Fatalf("error writing fake kubectl: %v", err)
+	}
+	return toolPath
+}
+
+func TestPodExecArgs(t *testing.T) {
+	pod := &PodTarget{Name: "web-0", Namespace: "prod", Container: "app", Context: "kind-dev"}
+	cmdOpts := CommandOptsType{Pod: pod, Cwd: "/srv", ShellPath: "/bin/bash", Login: true}
+	ecmd := podExecCmd("/usr/bin/kubectl", cmdOpts, "make test", nil)
+	expected := []string{"/usr/bin/kubectl", "--context", "kind-dev", "exec", "-i", "-t", "-n", "prod", "-c", "app", "web-0", "--",
+		"/bin/sh", "-c", podCwdScript, "/srv", "/bin/bash", "-l", "-c", "make test"}
+	if !slices.Equal(ecmd.Args, expected) {
+		t.Errorf("expected %q, got %q", expected, ecmd.Args)
+	}
+	ecmd = podExecCmd("/usr/bin/kubectl", CommandOptsType{Pod: &PodTarget{Name: "web-0"}}, "", []string{"top"})
+	if !slices.Equal(ecmd.Args, []string{"/usr/bin/kubectl", "exec", "-i", "-t", "web-0", "--", "top"}) {
+		t.Errorf("unexpected argv args %q", ecmd.Args)
+	}
+	badOpts := []CommandOptsType{
+		{Pod: &PodTarget{}},
+		{Pod: &PodTarget{Name: "Web_0"}},
+		{Pod: &PodTarget{Name: "web-0", Namespace: "-n"}},
+		{Pod: &PodTarget{Name: "web-0", Context: "--kubeconfig=x"}},
+		{Pod: &PodTarget{Name: "web-0"}, Container: "box"},
+	}
+	for _, opts := range badOpts {
+		if _, err := resolvePod(opts); err == nil {
+			t.Errorf("expected an error for %+v", *opts.Pod)
+		}
+	}
+}
+
+func TestPodShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake kubectl is a shell script")
+	}
+	toolPath := makeFakeKubectl(t)
+	cwd := t.TempDir()
+	cmdOpts := CommandOptsType{
+		Pod: &PodTarget{Name: "web-0", Namespace: "prod", Context: "kind-dev", Kubectl: toolPath},
+		Cwd: cwd,
+		Env: map[string]string{"MY_VAR": "my value"},
+	}
+	sp, err := StartShellProc(waveobj.TermSize{Rows: 24, Cols: 80}, `echo "in $(pwd) with $MY_VAR/$`+WaveSessionIdVarName+`"; exit 3`, cmdOpts)
+	if err != nil {
+		t.Fatalf("error starting pod shell: %v", err)
+	}
+	t.Cleanup(sp.Close)
+	oc := collectOutput(sp)
+	oc.waitFor(t, "in "+cwd+" with my value/"+sp.SessionID())
+	output := oc.String()
+	for _, expected := range []string{"context kind-dev", "namespace prod", "pod web-0"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in the output, got %q", expected, output)
+		}
+	}
+	select {
+	case <-oc.Done:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for the pod shell to exit")
+	}
+	if status := waitExitStatus(t, sp); status.ExitCode != 3 {
+		t.Errorf("expected the shell's exit code, got %+v", status)
+	}
+}
@@ -0,0 +1,10 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin && !windows
+
+package shellexec
+
+func setShellPriority(sessionId string, pid int, nice int) {
+	shellLogf(sessionId, "warning: nice is not supported on this platform, starting shell without it\n")
+}
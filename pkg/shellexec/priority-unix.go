@@ -0,0 +1,18 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// setShellPriority sets the nice value of a shell that was just started, what it starts inherits it.
+// (on linux this is per thread, the shell is still single threaded)
+func setShellPriority(sessionId string, pid int, nice int) {
+	if err := unix.Setpriority(unix.PRIO_PROCESS, pid, nice); err != nil {
+		shellLogf(sessionId, "warning: cannot set the shell's nice value to %d: %v\n", nice, err)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package shellexec
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// windows has priority classes instead of nice values (high and realtime are never used)
+func niceToPriorityClass(nice int) uint32 {
+	switch {
+	case nice < 0:
+		return windows.ABOVE_NORMAL_PRIORITY_CLASS
+	case nice == 0:
+		return windows.NORMAL_PRIORITY_CLASS
+	case nice < 15:
+		return windows.BELOW_NORMAL_PRIORITY_CLASS
+	default:
+		return windows.IDLE_PRIORITY_CLASS
+	}
+}
+
+// setShellPriority sets the priority class of a shell that was just started, the processes it creates
+// inherit the below normal and idle classes
+func setShellPriority(sessionId string, pid int, nice int) {
+	handle, err := windows.OpenProcess(windows.PROCESS_SET_INFORMATION, false, uint32(pid))
+	if err != nil {
+		shellLogf(sessionId, "warning: cannot set the shell's priority: %v\n", err)
+		return
+	}
+	defer windows.CloseHandle(handle)
+	if err := windows.SetPriorityClass(handle, niceToPriorityClass(nice)); err != nil {
+		shellLogf(sessionId, "warning: cannot set the shell's priority: %v\n", err)
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import "fmt"
+
+// the range of cmdOpts.Nice
+const (
+	MinNice = -20
+	MaxNice = 19
+)
+
+func resolveNice(cmdOpts CommandOptsType) error {
+	if cmdOpts.Nice < MinNice || cmdOpts.Nice > MaxNice {
+		return fmt.Errorf("invalid nice value %d (must be between %d and %d)", cmdOpts.Nice, MinNice, MaxNice)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestResolveNice(t *testing.T) {
+	for _, nice := range []int{MinNice, 0, MaxNice} {
+		if err := resolveNice(CommandOptsType{Nice: nice}); err != nil {
+			t.Errorf("%d: unexpected error %v", nice, err)
+		}
+	}
+	for _, nice := range []int{MinNice - 1, MaxNice + 1} {
+		if err := resolveNice(CommandOptsType{Nice: nice}); err == nil {
+			t.Errorf("%d: expected an error", nice)
+		}
+	}
+}
+
+func TestShellNice(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no nice on windows")
+	}
+	requireBinary(t, "nice")
+	// (the read makes sure nice runs after the shell's priority is set)
+	sp := startTestShellProc(t, `read x; echo "nice=$(nice)"`, CommandOptsType{Nice: 7})
+	oc := collectOutput(sp)
+	sp.Write([]byte("\n"))
+	oc.waitFor(t, "nice=7")
+}
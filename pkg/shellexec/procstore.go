@@ -0,0 +1,174 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"github.com/wavetermdev/waveterm/pkg/wshrpc"
+	"github.com/wavetermdev/waveterm/pkg/wstore"
+)
+
+const procStoreTimeout = 2 * time.Second
+const procStoreQueueSize = 64
+
+// local shells are recorded in the store (db_shellproc) once InitProcStore has been called, a record
+// is written when the shell starts (and for every run of a supervised command) and removed once it
+// exits.  One that is still there at startup was left by a wavesrv that didn't shut down (see FindOrphanedShells)
+var procStoreEnabled atomic.Bool
+
+// the store is written by a single goroutine (in order), so starting a shell doesn't wait for the db
+var procStoreOnce = &sync.Once{}
+var procStoreCh = make(chan func(ctx context.Context), procStoreQueueSize)
+
+func runProcStoreWriter() {
+	defer panichandler.PanicHandler("shellexec:procStoreWriter")
+	for fn := range procStoreCh {
+		ctx, cancelFn := context.WithTimeout(context.Background(), procStoreTimeout)
+		fn(ctx)
+		cancelFn()
+	}
+}
+
+// never blocks, if the writer is that far behind the op is dropped (a record that isn't removed is
+// cleaned up by reconcileStoredShellProcs, one that isn't written means the shell isn't found after a crash)
+func queueProcStoreOp(fn func(ctx context.Context)) {
+	if !procStoreEnabled.Load() {
+		return
+	}
+	select {
+	case procStoreCh <- fn:
+	default:
+		log.Printf("warning: shellproc store queue is full, dropping a write\n")
+	}
+}
+
+// Info returns what ListShellProcs reports for the shell (Cwd is the one it last reported, or the one it started in)
+func (sp *ShellProc) Info() wshrpc.ShellProcInfo {
+	info := wshrpc.ShellProcInfo{
+		SessionId: sp.sessionId,
+		BlockId:   sp.blockId,
+		ConnName:  sp.ConnName,
+		Cwd:       sp.Cwd(),
+		StartTs:   sp.StartupTimings().Start.UnixMilli(),
+		OwnerPid:  os.Getpid(),
+	}
+	if info.Cwd == "" {
+		info.Cwd = sp.startCwd
+	}
+	if pid := sp.localPid(); pid > 0 {
+		info.Pid = pid
+		info.PtyPath = lookupTtyPath(pid)
+		if proc, ok := lookupAuditProc(pid); ok && !proc.StartTime.IsZero() {
+			info.StartTs = proc.StartTime.UnixMilli()
+		}
+	}
+	return info
+}
+
+// best effort, failures are logged (the shell is then not found if wavesrv crashes)
+func storeShellProc(sp *ShellProc, pid int) {
+	if !procStoreEnabled.Load() {
+		return
+	}
+	proc, ok := lookupAuditProc(pid)
+	if !ok || proc.StartTime.IsZero() {
+		// exited already, or no start time to check the pid against later
+		return
+	}
+	info := wshrpc.ShellProcInfo{
+		SessionId: sp.sessionId,
+		BlockId:   sp.blockId,
+		Pid:       pid,
+		PtyPath:   lookupTtyPath(pid),
+		Cwd:       sp.startCwd,
+		StartTs:   proc.StartTime.UnixMilli(),
+		OwnerPid:  os.Getpid(),
+	}
+	queueProcStoreOp(func(ctx context.Context) {
+		err := wstore.WithTx(ctx, func(tx *wstore.TxWrap) error {
+			query := `INSERT OR REPLACE INTO db_shellproc (sessionid, blockid, pid, ptypath, cwd, startts, ownerpid)
+                                                   VALUES (        ?,       ?,   ?,       ?,   ?,       ?,        ?)`
+			tx.Exec(query, info.SessionId, info.BlockId, info.Pid, info.PtyPath, info.Cwd, info.StartTs, info.OwnerPid)
+			return nil
+		})
+		if err != nil {
+			shellLogf(info.SessionId, "warning: cannot store the shellproc: %v\n", err)
+		}
+	})
+}
+
+func removeStoredShellProc(sessionId string) {
+	queueProcStoreOp(func(ctx context.Context) {
+		if err := deleteStoredShellProcs(ctx, []string{sessionId}); err != nil {
+			shellLogf(sessionId, "warning: cannot remove the stored shellproc: %v\n", err)
+		}
+	})
+}
+
+func deleteStoredShellProcs(ctx context.Context, sessionIds []string) error {
+	return wstore.WithTx(ctx, func(tx *wstore.TxWrap) error {
+		query := `DELETE FROM db_shellproc WHERE sessionid = ?`
+		for _, sessionId := range sessionIds {
+			tx.Exec(query, sessionId)
+		}
+		return nil
+	})
+}
+
+func readStoredShellProcs(ctx context.Context) ([]wshrpc.ShellProcInfo, error) {
+	return wstore.WithTxRtn(ctx, func(tx *wstore.TxWrap) ([]wshrpc.ShellProcInfo, error) {
+		var rtn []wshrpc.ShellProcInfo
+		query := `SELECT sessionid, blockid, pid, ptypath, cwd, startts, ownerpid FROM db_shellproc`
+		tx.Select(&rtn, query)
+		return rtn, nil
+	})
+}
+
+// InitProcStore starts recording local shells in the store (wstore must be initialized), shells
+// started before it was called are recorded now.  What a previous wavesrv left there is found
+// with FindOrphanedShells
+func InitProcStore() {
+	procStoreOnce.Do(func() {
+		go runProcStoreWriter()
+	})
+	procStoreEnabled.Store(true)
+	for _, sp := range shellRegistry.procs() {
+		if pid := sp.localPid(); pid > 0 {
+			storeShellProc(sp, pid)
+		}
+	}
+	log.Printf("shellproc store initialized\n")
+}
+
+// ListShellProcs returns our running shellprocs (local and remote) and, once InitProcStore has
+// been called, the local shells of a previous wavesrv that are still running (as Orphaned, see
+// FindOrphanedShells).  Sorted by start time
+func ListShellProcs(ctx context.Context) ([]wshrpc.ShellProcInfo, error) {
+	var rtn []wshrpc.ShellProcInfo
+	for _, sp := range shellRegistry.procs() {
+		rtn = append(rtn, sp.Info())
+	}
+	if procStoreEnabled.Load() {
+		orphans, _, err := reconcileStoredShellProcs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rtn = append(rtn, orphans...)
+	}
+	sort.Slice(rtn, func(i, j int) bool {
+		if rtn[i].StartTs != rtn[j].StartTs {
+			return rtn[i].StartTs < rtn[j].StartTs
+		}
+		return rtn[i].SessionId < rtn[j].SessionId
+	})
+	return rtn, nil
+}
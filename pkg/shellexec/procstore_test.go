@@ -0,0 +1,163 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/wavebase"
+	"github.com/wavetermdev/waveterm/pkg/wshrpc"
+	"github.com/wavetermdev/waveterm/pkg/wstore"
+)
+
+// starts recording shells in a fresh wstore (in the test data dir), until the test is done
+func initTestProcStore(t *testing.T) {
+	t.Helper()
+	if err := wavebase.EnsureWaveDBDir(); err != nil {
+		t.Fatalf("error creating db dir: %v", err)
+	}
+	if err := wstore.InitWStore(); err != nil {
+		t.Fatalf("error initializing wstore: %v", err)
+	}
+	InitProcStore()
+	t.Cleanup(func() {
+		waitProcStoreWrites(t)
+		procStoreEnabled.Store(false)
+		wstore.WithTx(context.Background(), func(tx *wstore.TxWrap) error {
+			tx.Exec(`DELETE FROM db_shellproc`)
+			return nil
+		})
+	})
+}
+
+// waits for the queued writes to be done
+func waitProcStoreWrites(t *testing.T) {
+	t.Helper()
+	doneCh := make(chan struct{})
+	queueProcStoreOp(func(context.Context) { close(doneCh) })
+	select {
+	case <-doneCh:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for the proc store writes")
+	}
+}
+
+func insertTestShellProc(t *testing.T, info wshrpc.ShellProcInfo) {
+	t.Helper()
+	err := wstore.WithTx(context.Background(), func(tx *wstore.TxWrap) error {
+		query := `INSERT INTO db_shellproc (sessionid, blockid, pid, ptypath, cwd, startts, ownerpid) VALUES (?, ?, ?, ?, ?, ?, ?)`
+		tx.Exec(query, info.SessionId, info.BlockId, info.Pid, info.PtyPath, info.Cwd, info.StartTs, info.OwnerPid)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error storing shellproc: %v", err)
+	}
+}
+
+func readTestShellProcs(t *testing.T) []wshrpc.ShellProcInfo {
+	t.Helper()
+	waitProcStoreWrites(t)
+	stored, err := readStoredShellProcs(context.Background())
+	if err != nil {
+		t.Fatalf("error reading stored shellprocs: %v", err)
+	}
+	return stored
+}
+
+func findTestShellProcInfo(infos []wshrpc.ShellProcInfo, sessionId string) (wshrpc.ShellProcInfo, bool) {
+	for _, info := range infos {
+		if info.SessionId == sessionId {
+			return info, true
+		}
+	}
+	return wshrpc.ShellProcInfo{}, false
+}
+
+func TestProcStore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no stored shellprocs without the process table of audit")
+	}
+	initTestProcStore(t)
+	ctx, cancelFn := context.WithTimeout(context.Background(), testWaitTimeout)
+	defer cancelFn()
+
+	// left by a previous wavesrv: a shell that is still running and one that is gone
+	orphanCmd := exec.Command(requireBinary(t, "sleep"), "30")
+	if err := orphanCmd.Start(); err != nil {
+		t.Fatalf("error starting sleep: %v", err)
+	}
+	t.Cleanup(func() {
+		orphanCmd.Process.Kill()
+		orphanCmd.Wait()
+	})
+	goneCmd := exec.Command(requireBinary(t, "true"))
+	if err := goneCmd.Run(); err != nil {
+		t.Fatalf("error running true: %v", err)
+	}
+	orphanProc, ok := lookupAuditProc(orphanCmd.Process.Pid)
+	if !ok {
+		t.Fatalf("cannot find the sleep process")
+	}
+	insertTestShellProc(t, wshrpc.ShellProcInfo{SessionId: "stored-orphan", BlockId: "block-orphan", Pid: orphanCmd.Process.Pid, Cwd: "/tmp", StartTs: orphanProc.StartTime.UnixMilli(), OwnerPid: goneCmd.Process.Pid})
+	insertTestShellProc(t, wshrpc.ShellProcInfo{SessionId: "stored-gone", BlockId: "block-gone", Pid: goneCmd.Process.Pid, Cwd: "/tmp", StartTs: orphanProc.StartTime.UnixMilli(), OwnerPid: goneCmd.Process.Pid})
+
+	orphans, err := FindOrphanedShells(ctx)
+	if err != nil {
+		t.Fatalf("error finding orphans: %v", err)
+	}
+	if len(orphans) != 1 || orphans[0].SessionId != "stored-orphan" || !orphans[0].ShellAlive || orphans[0].BlockId != "block-orphan" {
+		t.Fatalf("unexpected orphans %+v", orphans)
+	}
+	if _, ok := findTestShellProcInfo(readTestShellProcs(t), "stored-gone"); ok {
+		t.Errorf("the record of a shell that is gone should be removed")
+	}
+
+	cwd := t.TempDir()
+	sp := startTestShellProc(t, `echo ready; read y`, CommandOptsType{BlockId: "block-ours", Cwd: cwd})
+	oc := collectOutput(sp)
+	startWaitLoop(sp)
+	oc.waitFor(t, "ready")
+	rec, ok := findTestShellProcInfo(readTestShellProcs(t), sp.SessionID())
+	if !ok || rec.Pid != sp.localPid() || rec.BlockId != "block-ours" || rec.Cwd != cwd || rec.OwnerPid != os.Getpid() {
+		t.Fatalf("unexpected stored shellproc %+v (shell pid %d)", rec, sp.localPid())
+	}
+	if runtime.GOOS == "linux" && rec.PtyPath == "" {
+		t.Errorf("expected the pty path to be stored")
+	}
+	list, err := ListShellProcs(ctx)
+	if err != nil {
+		t.Fatalf("error listing shellprocs: %v", err)
+	}
+	if info, ok := findTestShellProcInfo(list, sp.SessionID()); !ok || info.Orphaned || info.Pid != sp.localPid() || info.StartTs != rec.StartTs {
+		t.Errorf("unexpected listing of our shell %+v", info)
+	}
+	if info, ok := findTestShellProcInfo(list, "stored-orphan"); !ok || !info.Orphaned {
+		t.Errorf("expected the orphan to be listed, got %+v", list)
+	}
+
+	sp.Write([]byte("\n"))
+	select {
+	case <-sp.DoneCh:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for the shell to exit")
+	}
+	if _, ok := findTestShellProcInfo(readTestShellProcs(t), sp.SessionID()); ok {
+		t.Errorf("the stored shellproc should be removed after the shell exited")
+	}
+
+	orphanCmd.Process.Kill()
+	orphanCmd.Wait()
+	list, err = ListShellProcs(ctx)
+	if err != nil {
+		t.Fatalf("error listing shellprocs: %v", err)
+	}
+	if _, ok := findTestShellProcInfo(list, "stored-orphan"); ok {
+		t.Errorf("an orphan that has exited should not be listed")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"syscall"
+)
+
+// ProcTreeNode is a process in a shell's process tree (see ShellProc.GetProcessTree)
+type ProcTreeNode struct {
+	Pid        int             `json:"pid"`
+	Ppid       int             `json:"ppid"`
+	Name       string          `json:"name"`
+	CpuSeconds float64         `json:"cpuseconds"` // user + system time (on linux including reaped children)
+	MemRss     uint64          `json:"memrss"`
+	Children   []*ProcTreeNode `json:"children,omitempty"` // sorted by pid
+}
+
+// links the flat list (from sampleProcTreeNodes) into a tree under rootPid
+func buildProcTree(nodes []*ProcTreeNode, rootPid int) *ProcTreeNode {
+	var root *ProcTreeNode
+	byPid := make(map[int]*ProcTreeNode)
+	for _, node := range nodes {
+		byPid[node.Pid] = node
+		if node.Pid == rootPid {
+			root = node
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Pid < nodes[j].Pid
+	})
+	for _, node := range nodes {
+		if parent := byPid[node.Ppid]; parent != nil && node.Pid != rootPid {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+	return root
+}
+
+// GetProcessTree returns the shell's process and all of its descendants (local shells only).
+// Processes that exit while the tree is being walked are skipped.  Orphans that were reparented
+// away from the shell (e.g. daemonized) are not part of it
+func (sp *ShellProc) GetProcessTree() (*ProcTreeNode, error) {
+	pid := sp.localPid()
+	if pid <= 0 {
+		return nil, fmt.Errorf("the process tree is only available for local shells")
+	}
+	if sp.shellGone() {
+		return nil, ErrShellExited
+	}
+	nodes, err := sampleProcTreeNodes(pid)
+	if err != nil {
+		return nil, err
+	}
+	root := buildProcTree(nodes, pid)
+	if root == nil {
+		return nil, fmt.Errorf("shell process %d not found", pid)
+	}
+	return root, nil
+}
+
+// SignalProcess sends sig to one of the shell's descendants (see GetProcessTree), the pid is
+// checked against the current tree first so a pid that exited (and may have been reused) is
+// never signaled.  The shell itself can't be signaled this way, use SendSignal or Close
+func (sp *ShellProc) SignalProcess(pid int, sig syscall.Signal) error {
+	root, err := sp.GetProcessTree()
+	if err != nil {
+		return err
+	}
+	if pid == root.Pid {
+		return fmt.Errorf("pid %d is the shell itself", pid)
+	}
+	queue := slices.Clone(root.Children)
+	for idx := 0; idx < len(queue); idx++ {
+		if queue[idx].Pid == pid {
+			osProc, err := os.FindProcess(pid)
+			if err != nil {
+				return err
+			}
+			if err := osProc.Signal(sig); err != nil {
+				return err
+			}
+			sp.publishSignaled(sig, SignalTarget_Process, pid)
+			return nil
+		}
+		queue = append(queue, queue[idx].Children...)
+	}
+	return fmt.Errorf("pid %d is not part of the shell's process tree", pid)
+}
@@ -0,0 +1,61 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// returns the names of the shell's children
+func procTreeChildNames(t *testing.T, sp *ShellProc) (*ProcTreeNode, []string) {
+	t.Helper()
+	root, err := sp.GetProcessTree()
+	if err != nil {
+		t.Fatalf("error getting the process tree: %v", err)
+	}
+	var names []string
+	for _, child := range root.Children {
+		names = append(names, child.Name)
+	}
+	return root, names
+}
+
+func TestGetProcessTree(t *testing.T) {
+	requireBinary(t, "sleep")
+	sp := startTestShellProc(t, `sleep 30 & (sleep 31; true) & echo "started"; wait`, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "started")
+	var root *ProcTreeNode
+	var names []string
+	for deadline := time.Now().Add(testWaitTimeout); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if root, names = procTreeChildNames(t, sp); strings.Join(names, ",") == "sleep,bash" && len(root.Children[1].Children) == 1 {
+			break
+		}
+	}
+	if root.Pid != sp.localPid() || root.Name != "bash" || len(names) != 2 {
+		t.Fatalf("unexpected process tree %+v (children %v)", root, names)
+	}
+	subshell := root.Children[1]
+	if subshell.Ppid != root.Pid || len(subshell.Children) != 1 || subshell.Children[0].Name != "sleep" || subshell.Children[0].MemRss == 0 {
+		t.Errorf("expected sleep under the subshell, got %+v", subshell)
+	}
+	if err := sp.SignalProcess(root.Pid, syscall.SIGTERM); err == nil {
+		t.Errorf("expected an error signaling the shell itself")
+	}
+	if err := sp.SignalProcess(1, syscall.SIGTERM); err == nil {
+		t.Errorf("expected an error signaling a process outside the tree")
+	}
+	if err := sp.SignalProcess(root.Children[0].Pid, syscall.SIGTERM); err != nil {
+		t.Fatalf("error signaling sleep: %v", err)
+	}
+	for deadline := time.Now().Add(testWaitTimeout); len(names) != 1; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the signaled sleep to exit, got %v", names)
+		}
+		_, names = procTreeChildNames(t, sp)
+	}
+}
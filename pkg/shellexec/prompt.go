@@ -0,0 +1,154 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+)
+
+const DefaultPromptIdleWindow = 300 * time.Millisecond
+
+// promptDetector tracks the "likely at prompt" state (see ShellProc.LikelyAtPrompt).
+// It sits in the output pipeline to record when output was last seen, and runs a
+// single goroutine that re-checks the state once output has been idle for Window.
+type promptDetector struct {
+	Lock       *sync.Mutex
+	Clock      clock
+	Window     time.Duration
+	LastOutput time.Time
+	AtPrompt   bool
+	OutputCh   chan struct{} // buffered(1), signaled on output
+	OnChange   func(atPrompt bool)
+}
+
+func makePromptDetector(clk clock, window time.Duration, onChange func(bool)) *promptDetector {
+	if window == 0 {
+		window = DefaultPromptIdleWindow
+	}
+	return &promptDetector{Lock: &sync.Mutex{}, Clock: clk, Window: window, LastOutput: clk.Now(), OutputCh: make(chan struct{}, 1), OnChange: onChange}
+}
+
+// io.Writer so it can sit in the output pipeline.  output always means "not at the
+// prompt" (until it goes idle again).  changes are reported under the lock so they
+// are seen in order.
+func (pd *promptDetector) Write(data []byte) (int, error) {
+	now := pd.Clock.Now()
+	pd.Lock.Lock()
+	pd.LastOutput = now
+	if pd.AtPrompt {
+		pd.AtPrompt = false
+		pd.OnChange(false)
+	}
+	pd.Lock.Unlock()
+	select {
+	case pd.OutputCh <- struct{}{}:
+	default:
+	}
+	return len(data), nil
+}
+
+func (pd *promptDetector) idleFor() time.Duration {
+	pd.Lock.Lock()
+	defer pd.Lock.Unlock()
+	return pd.Clock.Now().Sub(pd.LastOutput)
+}
+
+// output may have arrived while checkFn ran, in that case the next pass sorts it out
+func (pd *promptDetector) setStateIfIdle(atPrompt bool) {
+	pd.Lock.Lock()
+	defer pd.Lock.Unlock()
+	if pd.Clock.Now().Sub(pd.LastOutput) < pd.Window || pd.AtPrompt == atPrompt {
+		return
+	}
+	pd.AtPrompt = atPrompt
+	pd.OnChange(atPrompt)
+}
+
+// run returns when doneCh is closed.  the shell can only get back to its prompt
+// by printing one, so after a check we wait for more output before checking again.
+func (pd *promptDetector) run(doneCh <-chan any, checkFn func() bool) {
+	for {
+		if idleFor := pd.idleFor(); idleFor < pd.Window {
+			timerCh, stopFn := pd.Clock.NewTimer(pd.Window - idleFor)
+			select {
+			case <-timerCh:
+			case <-doneCh:
+				stopFn()
+				return
+			}
+			continue
+		}
+		pd.setStateIfIdle(checkFn())
+		select {
+		case <-pd.OutputCh:
+		case <-doneCh:
+			return
+		}
+	}
+}
+
+func (sp *ShellProc) publishPromptState(atPrompt bool) {
+	sp.events.publish(ShellEvent{Kind: EventKind_PromptState, AtPrompt: &atPrompt})
+}
+
+// ttyLooksLikePrompt checks the tty side of the heuristic.  a plain prompt leaves the
+// tty in canonical mode with echo on, but line editors (readline, zle, fish) turn
+// off ICANON and ECHO while reading a line, so that (with ISIG still on) counts
+// too.  full screen apps turn off ISIG as well.  the foreground process group must
+// be the shell's own (it is the session leader, so its pgid is its pid).
+func (sp *ShellProc) ttyLooksLikePrompt() bool {
+	pid := sp.localPid()
+	if pid <= 0 {
+		// can't inspect a remote tty, only the idle window counts
+		return true
+	}
+	modes, err := sp.TtyModes()
+	if err != nil {
+		return false
+	}
+	plainPrompt := modes.Canonical && modes.Echo
+	lineEditor := !modes.Canonical && !modes.Echo && modes.ISig
+	if !plainPrompt && !lineEditor {
+		return false
+	}
+	var pgid int
+	err = sp.withPtyFd(func(fd uintptr) error {
+		var err error
+		pgid, err = getForegroundPgid(fd)
+		return err
+	})
+	if err != nil {
+		return false
+	}
+	return pgid == pid
+}
+
+// LikelyAtPrompt is a heuristic for "the shell is waiting at its prompt": output has
+// been idle for PromptIdleWindow, the tty modes look like a prompt and the shell
+// itself is in the foreground of the pty.  It can be fooled (e.g. by the shell's
+// `read` builtin, a slow rc file, or a prompt that is still being drawn), when shell
+// integration reports prompt marks those should be used instead.  Remote shells only use the
+// idle window.  An EventKind_PromptState event is published when the state changes.
+func (sp *ShellProc) LikelyAtPrompt() bool {
+	if sp.prompt == nil {
+		return false
+	}
+	if sp.prompt.idleFor() < sp.prompt.Window {
+		return false
+	}
+	return sp.ttyLooksLikePrompt()
+}
+
+func (sp *ShellProc) startPromptDetector() {
+	if sp.prompt == nil {
+		return
+	}
+	go func() {
+		defer panichandler.PanicHandler("ShellProc:promptDetector")
+		sp.prompt.run(sp.DoneCh, sp.ttyLooksLikePrompt)
+	}()
+}
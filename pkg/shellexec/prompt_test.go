@@ -0,0 +1,93 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// waits for the next prompt state event, skipping other events
+func waitPromptState(t *testing.T, eventCh <-chan ShellEvent, want bool) {
+	t.Helper()
+	timer := time.NewTimer(testWaitTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case event := <-eventCh:
+			if event.Kind != EventKind_PromptState || event.AtPrompt == nil {
+				continue
+			}
+			if *event.AtPrompt == want {
+				return
+			}
+		case <-timer.C:
+			t.Fatalf("timed out waiting for prompt state %v", want)
+		}
+	}
+}
+
+// reads events until none have arrived for a bit, returns them
+func drainEvents(eventCh <-chan ShellEvent) []ShellEvent {
+	var rtn []ShellEvent
+	for {
+		select {
+		case event, ok := <-eventCh:
+			if !ok {
+				return rtn
+			}
+			rtn = append(rtn, event)
+		case <-time.After(50 * time.Millisecond):
+			return rtn
+		}
+	}
+}
+
+func waitLikelyAtPrompt(t *testing.T, sp *ShellProc) {
+	t.Helper()
+	waitUntil(t, "the shell to be at its prompt", sp.LikelyAtPrompt)
+}
+
+func TestLikelyAtPrompt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no ptys on windows")
+	}
+	sp := startTestShellProc(t, "", CommandOptsType{PromptIdleWindow: 100 * time.Millisecond})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	oc := collectOutput(sp)
+	sp.Write([]byte("echo sta''rted\r"))
+	oc.waitFor(t, "started")
+	// (the shell is also idle while it reads its rc files, so there can be earlier transitions)
+	waitLikelyAtPrompt(t, sp)
+	drainEvents(eventCh)
+
+	// the echoed command line is output, then sleep is in the foreground (and silent)
+	sp.Write([]byte("echo sleep''ing; sleep 1; echo do''ne\r"))
+	waitPromptState(t, eventCh, false)
+	oc.waitFor(t, "sleeping")
+	time.Sleep(300 * time.Millisecond)
+	if sp.LikelyAtPrompt() {
+		t.Errorf("expected the shell not to be at its prompt while sleep is running")
+	}
+	for _, event := range drainEvents(eventCh) {
+		if event.Kind == EventKind_PromptState && *event.AtPrompt {
+			t.Errorf("unexpected prompt state event while sleep is running")
+		}
+	}
+
+	oc.waitFor(t, "done")
+	waitPromptState(t, eventCh, true)
+	if !sp.LikelyAtPrompt() {
+		t.Errorf("expected the shell to be back at its prompt")
+	}
+}
+
+func TestPromptDetectorDisabled(t *testing.T) {
+	sp := startTestShellProc(t, "read x", CommandOptsType{PromptIdleWindow: -1})
+	if sp.LikelyAtPrompt() {
+		t.Errorf("a disabled detector should never report a prompt")
+	}
+}
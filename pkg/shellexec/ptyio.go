@@ -0,0 +1,45 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"math"
+	"os"
+)
+
+// SetTermSize resizes the pty (the shell's foreground process group gets SIGWINCH from the
+// kernel, ssh sessions get a window-change request) and publishes an EventKind_Resize event (and
+// records the resize, see StartRecording)
+func (sp *ShellProc) SetTermSize(termSize TermSize) error {
+	if termSize.Rows <= 0 || termSize.Cols <= 0 || termSize.Rows > math.MaxUint16 || termSize.Cols > math.MaxUint16 {
+		return fmt.Errorf("invalid term size: %v", termSize)
+	}
+	if err := sp.Cmd.SetSize(termSize.Rows, termSize.Cols); err != nil {
+		return err
+	}
+	sp.events.publish(ShellEvent{Kind: EventKind_Resize, TermSize: &termSize})
+	sp.recordResize(termSize)
+	return nil
+}
+
+// Write writes input to the pty, all input should go through here (not sp.Cmd) so it counts as activity
+func (sp *ShellProc) Write(data []byte) (int, error) {
+	if sp.idle != nil {
+		sp.idle.activity()
+	}
+	nw, err := sp.Cmd.Write(data)
+	sp.ioCounts.Written.Add(int64(nw))
+	return nw, err
+}
+
+// withPtyFd calls fn with the pty's fd, returns os.ErrClosed once the pty has been closed
+func (sp *ShellProc) withPtyFd(fn func(fd uintptr) error) error {
+	sp.ptyLock.RLock()
+	defer sp.ptyLock.RUnlock()
+	if sp.ptyClosed {
+		return os.ErrClosed
+	}
+	return fn(sp.Cmd.Fd())
+}
@@ -0,0 +1,60 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestPtyIO(t *testing.T) {
+	sp := startTestShellProc(t, `read line; echo "got=$line"; sleep 30`, CommandOptsType{})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	oc := collectOutput(sp)
+	startWaitLoop(sp)
+	if err := sp.SetTermSize(TermSize{Rows: 40, Cols: 100}); err != nil {
+		t.Fatalf("error resizing: %v", err)
+	}
+	if event := waitEventKind(t, eventCh, EventKind_Resize); event.TermSize.Rows != 40 || event.TermSize.Cols != 100 {
+		t.Errorf("unexpected resize event %+v", event.TermSize)
+	}
+	if _, err := sp.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	oc.waitFor(t, "got=hello")
+	if err := sp.withPtyFd(func(fd uintptr) error { return nil }); err != nil {
+		t.Errorf("expected the pty fd while running, got %v", err)
+	}
+
+	sp.Close()
+	waitDone(t, sp)
+	// the pty is closed once the output has drained
+	for deadline := time.Now().Add(testWaitTimeout); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if err := sp.withPtyFd(func(fd uintptr) error { return nil }); err == os.ErrClosed {
+			return
+		}
+	}
+	t.Errorf("expected os.ErrClosed once the pty was closed")
+}
+
+func TestSetTermSize(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no SIGWINCH on windows")
+	}
+	sp := startTestShellProc(t, `trap 'echo "winch=$(stty size)"' WINCH; echo ready; while :; do sleep 0.02; done`, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready")
+	if err := sp.SetTermSize(TermSize{Rows: 50, Cols: 132}); err != nil {
+		t.Fatalf("error resizing: %v", err)
+	}
+	oc.waitFor(t, "winch=50 132")
+	for _, termSize := range []TermSize{{Rows: 0, Cols: 80}, {Rows: 24, Cols: -1}, {Rows: 70000, Cols: 80}} {
+		if err := sp.SetTermSize(termSize); err == nil {
+			t.Errorf("expected an error for %v", termSize)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin && !windows
+
+package shellexec
+
+import (
+	"os/exec"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// pty.StartWithSize allocates the pty as part of starting the process, there is nothing to pre-open
+const warmPtySupported = false
+
+// the pty can't be allocated separately here, so the allocation counts as part of the start syscall
+// (warm and ttyInit are always nil)
+func startInPty(clk clock, ecmd *exec.Cmd, size *pty.Winsize, warm *warmPty, ttyInit *TtyInitOpts) (pty.Pty, time.Time, error) {
+	ptyOpened := clk.Now()
+	cmdPty, err := pty.StartWithSize(ecmd, size)
+	return cmdPty, ptyOpened, err
+}
@@ -0,0 +1,74 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+const warmPtySupported = true
+
+// startInPty is pty.StartWithSize split in two so the pty allocation and the start syscall can be timed
+// separately.  returns when the pty was opened (the start syscall is timed until it returns).
+// warm is used instead of opening a pty if it is set (and still usable).  ttyInit (if set) is applied
+// to the tty before the start.  any SysProcAttr already set (e.g. the cgroup fd) is kept.
+func startInPty(clk clock, ecmd *exec.Cmd, size *pty.Winsize, warm *warmPty, ttyInit *TtyInitOpts) (pty.Pty, time.Time, error) {
+	var cmdPty pty.Pty
+	var cmdTty pty.Tty
+	if warm != nil {
+		if err := pty.Setsize(warm.Pty, size); err == nil {
+			cmdPty, cmdTty = warm.Pty, warm.Tty
+		} else {
+			// gone stale somehow, start cold
+			warm.close()
+		}
+	}
+	if cmdPty == nil {
+		var err error
+		cmdPty, cmdTty, err = pty.Open()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if err := pty.Setsize(cmdPty, size); err != nil {
+			cmdPty.Close()
+			cmdTty.Close()
+			return nil, time.Time{}, err
+		}
+	}
+	// the tty is the child's now (we keep the pty side to resize it)
+	defer cmdTty.Close()
+	ptyOpened := clk.Now()
+	if ttyInit != nil {
+		if err := setInitialTtyModes(cmdTty.Fd(), ttyInit); err != nil {
+			cmdPty.Close()
+			return nil, ptyOpened, fmt.Errorf("error setting initial tty modes: %w", err)
+		}
+	}
+	if ecmd.Stdin == nil {
+		ecmd.Stdin = cmdTty
+	}
+	if ecmd.Stdout == nil {
+		ecmd.Stdout = cmdTty
+	}
+	if ecmd.Stderr == nil {
+		ecmd.Stderr = cmdTty
+	}
+	if ecmd.SysProcAttr == nil {
+		ecmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	ecmd.SysProcAttr.Setsid = true
+	ecmd.SysProcAttr.Setctty = true
+	if err := ecmd.Start(); err != nil {
+		cmdPty.Close()
+		return nil, ptyOpened, err
+	}
+	return cmdPty, ptyOpened, nil
+}
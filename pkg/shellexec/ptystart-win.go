@@ -0,0 +1,269 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package shellexec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/creack/pty"
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+	"golang.org/x/sys/windows"
+)
+
+// windows allocates the ConPTY as part of starting the process, there is nothing to pre-open
+const warmPtySupported = false
+
+// conPty is the pty side of a windows pseudo console.  pty.StartWithSize also uses ConPTY,
+// but it reaps the process itself (racing with exec.Cmd.Wait, which loses the exit code),
+// here the process is left to ecmd.Wait.
+type conPty struct {
+	hpc       windows.Handle
+	in        *os.File // the console's input
+	out       *os.File // the console's output
+	closeOnce *sync.Once
+	closeErr  error
+	jobLock   *sync.Mutex
+	job       windows.Handle // the process's job object (0 if it couldn't be made), closed once the process exits
+}
+
+func openConPty(size *pty.Winsize) (*conPty, error) {
+	var inRead, inWrite, outRead, outWrite windows.Handle
+	if err := windows.CreatePipe(&inRead, &inWrite, nil, 0); err != nil {
+		return nil, fmt.Errorf("cannot create conpty input pipe: %w", err)
+	}
+	if err := windows.CreatePipe(&outRead, &outWrite, nil, 0); err != nil {
+		windows.CloseHandle(inRead)
+		windows.CloseHandle(inWrite)
+		return nil, fmt.Errorf("cannot create conpty output pipe: %w", err)
+	}
+	var hpc windows.Handle
+	err := windows.CreatePseudoConsole(windows.Coord{X: int16(size.Cols), Y: int16(size.Rows)}, inRead, outWrite, 0, &hpc)
+	// the console has its own copies of its ends
+	windows.CloseHandle(inRead)
+	windows.CloseHandle(outWrite)
+	if err != nil {
+		windows.CloseHandle(inWrite)
+		windows.CloseHandle(outRead)
+		return nil, fmt.Errorf("cannot create pseudo console: %w", err)
+	}
+	return &conPty{
+		hpc:       hpc,
+		in:        os.NewFile(uintptr(inWrite), "conpty-in"),
+		out:       os.NewFile(uintptr(outRead), "conpty-out"),
+		closeOnce: &sync.Once{},
+		jobLock:   &sync.Mutex{},
+	}, nil
+}
+
+// kills every process in the job (the shell and whatever it started that hasn't left the job)
+func (cp *conPty) terminateJob() error {
+	cp.jobLock.Lock()
+	defer cp.jobLock.Unlock()
+	if cp.job == 0 {
+		return fmt.Errorf("no job object")
+	}
+	return windows.TerminateJobObject(cp.job, 1)
+}
+
+func (cp *conPty) closeJob() {
+	cp.jobLock.Lock()
+	defer cp.jobLock.Unlock()
+	if cp.job != 0 {
+		windows.CloseHandle(cp.job)
+		cp.job = 0
+	}
+}
+
+// the process was started suspended, so it is in the job before it can start anything
+func (cp *conPty) assignJob(pi *windows.ProcessInformation) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return
+	}
+	if err := windows.AssignProcessToJobObject(job, pi.Process); err != nil {
+		windows.CloseHandle(job)
+		return
+	}
+	cp.jobLock.Lock()
+	defer cp.jobLock.Unlock()
+	cp.job = job
+}
+
+// Fd is the pseudo console handle (what pty.Setsize resizes)
+func (cp *conPty) Fd() uintptr {
+	return uintptr(cp.hpc)
+}
+
+func (cp *conPty) Name() string {
+	return "conpty"
+}
+
+func (cp *conPty) Read(p []byte) (int, error) {
+	return cp.out.Read(p)
+}
+
+func (cp *conPty) Write(p []byte) (int, error) {
+	return cp.in.Write(p)
+}
+
+func (cp *conPty) WriteString(s string) (int, error) {
+	return cp.in.Write([]byte(s))
+}
+
+// Close can be called more than once (the console is closed when the process exits)
+func (cp *conPty) Close() error {
+	cp.closeOnce.Do(func() {
+		// flushes the rest of the output, reads then get EOF
+		windows.ClosePseudoConsole(cp.hpc)
+		cp.in.Close()
+		cp.closeErr = cp.out.Close()
+	})
+	return cp.closeErr
+}
+
+// cmd.exe parses its own command line (and doesn't understand \" escapes), so the command
+// after /c or /k is passed as is
+func composeCmdLine(args []string) string {
+	if len(args) > 0 && shellutil.DetectFamily(args[0]) == shellutil.ShellFamily_Cmd {
+		for idx, arg := range args {
+			if strings.EqualFold(arg, "/c") || strings.EqualFold(arg, "/k") {
+				return windows.ComposeCommandLine(args[:idx+1]) + " " + strings.Join(args[idx+1:], " ")
+			}
+		}
+	}
+	return windows.ComposeCommandLine(args)
+}
+
+func makeEnvBlock(env []string) (*uint16, error) {
+	var block []uint16
+	hasSystemRoot := false
+	for _, kv := range env {
+		if len(kv) > len("SYSTEMROOT=") && strings.EqualFold(kv[:len("SYSTEMROOT=")], "SYSTEMROOT=") {
+			hasSystemRoot = true
+		}
+		encoded, err := windows.UTF16FromString(kv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid env var %q: %w", kv, err)
+		}
+		block = append(block, encoded...)
+	}
+	// like os/exec, a lot of things stop working without it
+	if !hasSystemRoot {
+		if systemRoot, ok := os.LookupEnv("SYSTEMROOT"); ok {
+			encoded, _ := windows.UTF16FromString("SYSTEMROOT=" + systemRoot)
+			block = append(block, encoded...)
+		}
+	}
+	if len(block) == 0 {
+		block = append(block, 0)
+	}
+	block = append(block, 0)
+	return &block[0], nil
+}
+
+// startInPty starts ecmd attached to a new ConPTY (warm and ttyInit are always nil).  the pty can't be
+// allocated separately from the start here, so the allocation counts as part of the start syscall.
+// ecmd.Process is set like ecmd.Start would, so ecmd.Wait works as usual.  the process is put in a
+// job object (for CommandOptsType.KillTree) while it is running.
+func startInPty(clk clock, ecmd *exec.Cmd, size *pty.Winsize, warm *warmPty, ttyInit *TtyInitOpts) (pty.Pty, time.Time, error) {
+	ptyOpened := clk.Now()
+	if ecmd.Err != nil {
+		return nil, ptyOpened, ecmd.Err
+	}
+	if ecmd.Process != nil {
+		return nil, ptyOpened, fmt.Errorf("exec: already started")
+	}
+	cp, err := openConPty(size)
+	if err != nil {
+		return nil, ptyOpened, err
+	}
+	pi, err := createConPtyProcess(ecmd, cp)
+	if err != nil {
+		cp.Close()
+		return nil, ptyOpened, err
+	}
+	// opened while we still hold pi.Process, so the pid can't have been reused
+	ecmd.Process, err = os.FindProcess(int(pi.ProcessId))
+	if err != nil {
+		windows.TerminateProcess(pi.Process, 1)
+		windows.CloseHandle(pi.Thread)
+		windows.CloseHandle(pi.Process)
+		cp.Close()
+		return nil, ptyOpened, err
+	}
+	cp.assignJob(pi)
+	windows.ResumeThread(pi.Thread)
+	windows.CloseHandle(pi.Thread)
+	go func() {
+		// the console output only ends once the console is closed
+		windows.WaitForSingleObject(pi.Process, windows.INFINITE)
+		windows.CloseHandle(pi.Process)
+		cp.closeJob()
+		cp.Close()
+	}()
+	return cp, ptyOpened, nil
+}
+
+func createConPtyProcess(ecmd *exec.Cmd, cp *conPty) (*windows.ProcessInformation, error) {
+	var creationFlags uint32
+	cmdLine := composeCmdLine(ecmd.Args)
+	if ecmd.SysProcAttr != nil {
+		creationFlags = ecmd.SysProcAttr.CreationFlags
+		if ecmd.SysProcAttr.CmdLine != "" {
+			cmdLine = ecmd.SysProcAttr.CmdLine
+		}
+	}
+	env := ecmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	envBlock, err := makeEnvBlock(env)
+	if err != nil {
+		return nil, err
+	}
+	argv0, err := windows.UTF16PtrFromString(ecmd.Path)
+	if err != nil {
+		return nil, err
+	}
+	cmdLinePtr, err := windows.UTF16PtrFromString(cmdLine)
+	if err != nil {
+		return nil, err
+	}
+	var dir *uint16
+	if ecmd.Dir != "" {
+		dir, err = windows.UTF16PtrFromString(ecmd.Dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	attrList, err := windows.NewProcThreadAttributeList(1)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the process attribute list: %w", err)
+	}
+	defer attrList.Delete()
+	// the attribute's value is the HPCON itself (not a pointer to it)
+	hpcValue := *(*unsafe.Pointer)(unsafe.Pointer(&cp.hpc))
+	if err := attrList.Update(windows.PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE, hpcValue, unsafe.Sizeof(cp.hpc)); err != nil {
+		return nil, fmt.Errorf("cannot attach the pseudo console: %w", err)
+	}
+	siEx := &windows.StartupInfoEx{ProcThreadAttributeList: attrList.List()}
+	siEx.Cb = uint32(unsafe.Sizeof(*siEx))
+	// no std handles, everything goes through the pseudo console
+	siEx.Flags = windows.STARTF_USESTDHANDLES
+	pi := &windows.ProcessInformation{}
+	creationFlags |= windows.EXTENDED_STARTUPINFO_PRESENT | windows.CREATE_UNICODE_ENVIRONMENT | windows.CREATE_SUSPENDED
+	err = windows.CreateProcess(argv0, cmdLinePtr, nil, nil, false, creationFlags, envBlock, dir, &siEx.StartupInfo, pi)
+	if err != nil {
+		return nil, &os.PathError{Op: "CreateProcess", Path: ecmd.Path, Err: err}
+	}
+	return pi, nil
+}
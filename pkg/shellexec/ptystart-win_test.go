@@ -0,0 +1,55 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package shellexec
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConPtyShellProc(t *testing.T) {
+	cmdPath := requireBinary(t, "cmd.exe")
+	sp := startTestShellProc(t, `echo "hello there" & exit /b 3`, CommandOptsType{ShellPath: cmdPath})
+	oc := collectOutput(sp)
+	startWaitLoop(sp)
+	if err := sp.SetTermSize(TermSize{Rows: 30, Cols: 100}); err != nil {
+		t.Errorf("error resizing: %v", err)
+	}
+	oc.waitFor(t, `"hello there"`)
+	waitDone(t, sp)
+	if code := sp.ExitCode(); code != 3 {
+		t.Errorf("expected exit code 3, got %d", code)
+	}
+	<-oc.Done
+}
+
+func TestConPtyInput(t *testing.T) {
+	pwshPath := requireBinary(t, "powershell.exe")
+	sp := startTestShellProc(t, `$line = Read-Host; Write-Output "got=$line"; exit 4`, CommandOptsType{ShellPath: pwshPath})
+	oc := collectOutput(sp)
+	startWaitLoop(sp)
+	sp.Write([]byte("hello\r"))
+	oc.waitFor(t, "got=hello")
+	waitDone(t, sp)
+	if code := sp.ExitCode(); code != 4 {
+		t.Errorf("expected exit code 4, got %d", code)
+	}
+}
+
+func TestComposeCmdLine(t *testing.T) {
+	for _, tc := range []struct {
+		Args     []string
+		Expected string
+	}{
+		{[]string{`C:\Windows\System32\cmd.exe`, "/c", `echo "a b" & exit /b 3`}, `C:\Windows\System32\cmd.exe /c echo "a b" & exit /b 3`},
+		{[]string{"pwsh.exe", "-c", `Write-Output "a b"`}, `pwsh.exe -c "Write-Output \"a b\""`},
+		{[]string{`C:\Program Files\cmd.exe`}, `"C:\Program Files\cmd.exe"`},
+	} {
+		if got := composeCmdLine(tc.Args); got != tc.Expected {
+			t.Errorf("%q: expected %s, got %s", strings.Join(tc.Args, " "), tc.Expected, got)
+		}
+	}
+}
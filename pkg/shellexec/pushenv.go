@@ -0,0 +1,58 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+)
+
+var ErrNotSupported = errors.New("not supported for this shell")
+
+// pushEnvTarget is the pending env file sourced by the shell integration hooks
+type pushEnvTarget struct {
+	Lock   *sync.Mutex
+	Path   string
+	Family shellutil.ShellFamily
+}
+
+// returns nil if the family's integration doesn't support pushed env
+func makePushEnvTarget(family shellutil.ShellFamily) (*pushEnvTarget, error) {
+	if !shellutil.SupportsPushEnv(family) {
+		return nil, nil
+	}
+	dir := shellutil.GetPushEnvDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating pushenv dir: %w", err)
+	}
+	path := filepath.Join(dir, "pushenv-"+uuid.NewString()+".sh")
+	return &pushEnvTarget{Lock: &sync.Mutex{}, Path: path, Family: family}, nil
+}
+
+// PushEnv sets (and unsets) env vars in a running shell.  The integration hooks
+// source the changes before the next command runs (or at the next prompt), so
+// nothing is written to the pty and nothing shows up in the scrollback or the
+// shell history.  Returns ErrNotSupported for shells without our integration
+// (remote shells, cmdStr shells, and shells other than bash, zsh and fish).
+func (sp *ShellProc) PushEnv(updates map[string]string, unsets []string) error {
+	if sp.pushEnv == nil {
+		return ErrNotSupported
+	}
+	if len(updates) == 0 && len(unsets) == 0 {
+		return nil
+	}
+	snippet, err := shellutil.MakeEnvSnippet(sp.pushEnv.Family, updates, unsets)
+	if err != nil {
+		return err
+	}
+	sp.pushEnv.Lock.Lock()
+	defer sp.pushEnv.Lock.Unlock()
+	return shellutil.AppendPushEnvFile(sp.pushEnv.Path, snippet)
+}
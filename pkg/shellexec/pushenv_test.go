@@ -0,0 +1,44 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// runs an interactive bash through our bash integration
+func TestPushEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no bash on windows")
+	}
+	sp := startTestShellProc(t, "", CommandOptsType{})
+	oc := collectOutput(sp)
+	sp.Write([]byte("echo sta''rted\r"))
+	oc.waitFor(t, "started")
+	pushedVal := `it's a "$value"`
+	if err := sp.PushEnv(map[string]string{"WAVE_PUSHED": pushedVal}, nil); err != nil {
+		t.Fatalf("PushEnv error: %v", err)
+	}
+	// the very next command sees it (no extra prompt needed)
+	sp.Write([]byte("echo pushed=[$WAVE_PUSHED]\r"))
+	oc.waitFor(t, "pushed=["+pushedVal+"]")
+	if err := sp.PushEnv(nil, []string{"WAVE_PUSHED"}); err != nil {
+		t.Fatalf("PushEnv error: %v", err)
+	}
+	sp.Write([]byte("echo after=[${WAVE_PUSHED-unset}]\r"))
+	oc.waitFor(t, "after=[unset]")
+	if output := oc.String(); strings.Contains(output, "export ") || strings.Contains(output, "unset WAVE_PUSHED") {
+		t.Errorf("pushed env leaked into the scrollback: %q", output)
+	}
+}
+
+func TestPushEnvNotSupported(t *testing.T) {
+	sp := startTestShellProc(t, "read x", CommandOptsType{})
+	if err := sp.PushEnv(map[string]string{"A": "1"}, nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported for a cmdStr shell, got %v", err)
+	}
+}
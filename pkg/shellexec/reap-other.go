@@ -0,0 +1,18 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package shellexec
+
+import (
+	"syscall"
+)
+
+func reapZombie(pid int) error {
+	return ErrSignalNotSupported
+}
+
+func signalPid(pid int, sig syscall.Signal) error {
+	return ErrSignalNotSupported
+}
@@ -0,0 +1,30 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reaps pid if it is a zombie child of ours (never blocks)
+func reapZombie(pid int) error {
+	var status unix.WaitStatus
+	wpid, err := unix.Wait4(pid, &status, unix.WNOHANG, nil)
+	if err != nil {
+		return err
+	}
+	if wpid != pid {
+		return fmt.Errorf("process has not exited")
+	}
+	return nil
+}
+
+func signalPid(pid int, sig syscall.Signal) error {
+	return unix.Kill(pid, sig)
+}
@@ -0,0 +1,80 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package shellexec
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"golang.org/x/sys/unix"
+)
+
+var subreaperOnce = &sync.Once{}
+var subreaperErr error
+
+// EnableSubreaper makes this process the child subreaper (PR_SET_CHILD_SUBREAPER) of everything it
+// starts, so the processes a shell double-forks are reparented to us instead of init when their
+// parent exits, and starts a goroutine that reaps (and logs) them once they exit. Only zombies in
+// a session one of our shells created (or an adopted one) are reaped, other zombie children of
+// ours are left to their owner, and a shell itself is left to its ShellProc. Call it once at
+// startup (later calls return the first call's error)
+func EnableSubreaper() error {
+	subreaperOnce.Do(func() {
+		if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+			subreaperErr = fmt.Errorf("cannot become a child subreaper: %w", err)
+			return
+		}
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGCHLD)
+		go func() {
+			defer panichandler.PanicHandler("shellexec:subreaper")
+			runSubreaper(sigCh)
+		}()
+	})
+	return subreaperErr
+}
+
+func runSubreaper(sigCh chan os.Signal) {
+	for {
+		timerCh, stopFn := shellClock.NewTimer(subreaperScanInterval)
+		select {
+		case <-sigCh:
+		case <-timerCh:
+		}
+		stopFn()
+		if err := reapOrphans(); err != nil {
+			log.Printf("warning: subreaper cannot scan for zombies: %v\n", err)
+		}
+	}
+}
+
+func reapOrphans() error {
+	procs, err := listAuditProcs()
+	if err != nil {
+		return err
+	}
+	sessions := shellRegistry.snapshot()
+	report := auditProcs(procs, sessions, os.Getpid(), shellClock.Now())
+	for _, finding := range subreaperCandidates(report, sessions) {
+		var status unix.WaitStatus
+		wpid, err := unix.Wait4(finding.Pid, &status, unix.WNOHANG, nil)
+		if err != nil || wpid != finding.Pid {
+			// waited for by its owner in the meantime
+			continue
+		}
+		exitStatus := ExitStatus{ExitCode: status.ExitStatus()}
+		if status.Signaled() {
+			exitStatus = ExitStatus{ExitCode: 128 + int(status.Signal()), Signal: signalName(status.Signal())}
+		}
+		log.Printf("subreaper: reaped orphan %d (%s, from shell session %s): %s\n", finding.Pid, finding.Comm, finding.SessionId, describeExit(exitStatus))
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package shellexec
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSubreaper(t *testing.T) {
+	// (for the rest of the test binary too, orphans are then reparented to it)
+	if err := EnableSubreaper(); err != nil {
+		t.Fatalf("error enabling the subreaper: %v", err)
+	}
+	// the subshell exits right away, its background sleep is reparented to us
+	sp := startTestShellProc(t, `(sleep 0.5 </dev/null >/dev/null 2>&1 & echo "bg=[$!]"); read y`, CommandOptsType{})
+	oc := collectOutput(sp)
+	startWaitLoop(sp)
+	oc.waitFor(t, "bg=[")
+	m := bgPidRe.FindStringSubmatch(oc.String())
+	if m == nil {
+		t.Fatalf("no background pid in output %q", oc.String())
+	}
+	bgPid, _ := strconv.Atoi(m[1])
+	t.Cleanup(func() { syscall.Kill(bgPid, syscall.SIGKILL) })
+	var reparented bool
+	deadline := time.Now().Add(testWaitTimeout)
+	for time.Now().Before(deadline) {
+		proc, ok := lookupAuditProc(bgPid)
+		if !ok {
+			break
+		}
+		reparented = reparented || proc.Ppid == os.Getpid()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !reparented {
+		t.Fatalf("orphan %d was not reparented to us", bgPid)
+	}
+	if _, ok := lookupAuditProc(bgPid); ok {
+		t.Errorf("orphan %d was not reaped after it exited", bgPid)
+	}
+	if health := sp.HealthCheck(); !health.Healthy {
+		t.Errorf("the shell itself should not be reaped, got %+v", health)
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package shellexec
+
+// EnableSubreaper is linux only (PR_SET_CHILD_SUBREAPER)
+func EnableSubreaper() error {
+	return ErrSubreaperNotSupported
+}
@@ -0,0 +1,31 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"time"
+)
+
+// the subreaper scans on SIGCHLD, and this often (signals coalesce)
+const subreaperScanInterval = 5 * time.Second
+
+var ErrSubreaperNotSupported = errors.New("the subreaper is only supported on linux")
+
+// the AuditAction_Reap zombies the subreaper reaps: only the ones whose sid is one of our sessions
+// (sessions, including the adopted ones), i.e. orphans of our shells that were reparented to us.
+// other zombie children of ours may have been started (with os/exec) by some other package that
+// hasn't waited for them yet, they are left alone
+func subreaperCandidates(report AuditReport, sessions map[int]shellSession) []AuditFinding {
+	var rtn []AuditFinding
+	for _, finding := range report.Findings {
+		if finding.Action != AuditAction_Reap || finding.Sid == 0 {
+			continue
+		}
+		if _, ok := sessions[finding.Sid]; ok {
+			rtn = append(rtn, finding)
+		}
+	}
+	return rtn
+}
@@ -0,0 +1,28 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubreaperCandidates(t *testing.T) {
+	sessions := map[int]shellSession{
+		100: {Sid: 100, SessionId: "sess1"},
+		200: {Sid: 200, SessionId: "adopted", EndedAt: time.Now()},
+	}
+	report := AuditReport{Findings: []AuditFinding{
+		{Pid: 10, Sid: 100, SessionId: "sess1", Action: AuditAction_Reap},   // an orphan from one of our shells
+		{Pid: 11, Sid: 11, Action: AuditAction_Reap},                        // some other zombie child of ours
+		{Pid: 12, Sid: 100, SessionId: "sess1", Action: AuditAction_Wait},   // a shell its wait loop reaps
+		{Pid: 13, Sid: 100, SessionId: "sess1", Action: AuditAction_Signal}, // still running
+		{Pid: 14, Sid: 200, SessionId: "adopted", Action: AuditAction_Reap}, // from an adopted orphan's session
+		{Pid: 15, Action: AuditAction_Reap},                                 // sid unknown
+	}}
+	candidates := subreaperCandidates(report, sessions)
+	if len(candidates) != 2 || candidates[0].Pid != 10 || candidates[1].Pid != 14 {
+		t.Fatalf("expected only the zombies in our sessions to be reaped, got %+v", candidates)
+	}
+}
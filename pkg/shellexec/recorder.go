@@ -0,0 +1,255 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+)
+
+// recordings are asciicast v2 files (https://docs.asciinema.org/manual/asciicast/v2/), a json header line
+// followed by one [time, code, data] line per event
+const asciicastVersion = 2
+
+const (
+	asciicastCode_Output = "o"
+	asciicastCode_Resize = "r"
+)
+
+const (
+	recordOutputBufSize = 1024 // in chunks (see OutputSubOpts.BufSize)
+	recordResizeBufSize = 16
+)
+
+var ErrAlreadyRecording = errors.New("shell is already being recorded")
+var ErrNotRecording = errors.New("shell is not being recorded")
+
+type RecordOpts struct {
+	TermSize      TermSize          // the size when recording starts (the header's width and height), zero uses the default
+	Title         string            // optional
+	Env           map[string]string // the header's env (asciinema records TERM and SHELL), optional
+	IdleTimeLimit float64           // seconds, players shorten pauses longer than this (zero for no limit)
+}
+
+type asciicastHeader struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+}
+
+// recorder writes the output stream (from its own subscription) and the resizes to an asciicast.
+// event times are when the recorder sees them, so they are always in order
+type recorder struct {
+	Clock    clock
+	Start    time.Time
+	Out      *bufio.Writer
+	Closer   io.Closer // the file, for StartRecordingFile
+	Sub      *OutputSubscription
+	ResizeCh chan TermSize
+	StopCh   chan struct{}
+	DoneCh   chan struct{}
+	Err      error // the first write error (or ErrOutputSubOverflow), synchronized by DoneCh
+}
+
+func (rec *recorder) writeLine(val any) {
+	if rec.Err != nil {
+		return
+	}
+	line, err := json.Marshal(val)
+	if err != nil {
+		rec.Err = err
+		return
+	}
+	if _, err := rec.Out.Write(append(line, '\n')); err != nil {
+		rec.Err = fmt.Errorf("error writing recording: %w", err)
+	}
+}
+
+// invalid utf-8 in data is written as U+FFFD (the output is already chunked on utf-8 boundaries)
+func (rec *recorder) writeEvent(code string, data string) {
+	elapsed := math.Round(rec.Clock.Now().Sub(rec.Start).Seconds()*1e6) / 1e6
+	rec.writeLine([]any{elapsed, code, data})
+}
+
+func (rec *recorder) writeResize(termSize TermSize) {
+	rec.writeEvent(asciicastCode_Resize, fmt.Sprintf("%dx%d", termSize.Cols, termSize.Rows))
+}
+
+// runs until the output stream ends or the recording is stopped, whatever was already queued is written first
+func (rec *recorder) run() {
+	defer close(rec.DoneCh)
+	outputCh := rec.Sub.Ch
+	for outputCh != nil {
+		select {
+		case chunk, ok := <-outputCh:
+			if !ok {
+				if errors.Is(rec.Sub.Err(), ErrOutputSubOverflow) && rec.Err == nil {
+					rec.Err = fmt.Errorf("recording is incomplete: %w", ErrOutputSubOverflow)
+				}
+				outputCh = nil
+				continue
+			}
+			rec.writeEvent(asciicastCode_Output, string(chunk.Data))
+		case termSize := <-rec.ResizeCh:
+			rec.writeResize(termSize)
+		case <-rec.StopCh:
+			rec.Sub.Close()
+			for chunk := range outputCh {
+				rec.writeEvent(asciicastCode_Output, string(chunk.Data))
+			}
+			outputCh = nil
+		}
+		if len(outputCh) == 0 && rec.Err == nil {
+			if err := rec.Out.Flush(); err != nil {
+				rec.Err = fmt.Errorf("error writing recording: %w", err)
+			}
+		}
+	}
+	for len(rec.ResizeCh) > 0 {
+		rec.writeResize(<-rec.ResizeCh)
+	}
+	if rec.Err == nil {
+		if err := rec.Out.Flush(); err != nil {
+			rec.Err = fmt.Errorf("error writing recording: %w", err)
+		}
+	}
+	if rec.Closer != nil {
+		if err := rec.Closer.Close(); err != nil && rec.Err == nil {
+			rec.Err = fmt.Errorf("error closing recording: %w", err)
+		}
+	}
+}
+
+func (rec *recorder) done() bool {
+	select {
+	case <-rec.DoneCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// StartRecording records the shell's output from now on (not the scrollback) to w as an asciicast v2,
+// along with its resizes (SetTermSize).  Input is never recorded.  The recording runs until StopRecording
+// or the end of the output, only one recording can be running at a time.  w is written from another
+// goroutine until StopRecording returns (or the output has ended)
+func (sp *ShellProc) StartRecording(w io.Writer, opts RecordOpts) error {
+	return sp.startRecording(w, nil, opts)
+}
+
+// StartRecordingFile is StartRecording to a new .cast file (truncated if it exists), closed when the recording stops
+func (sp *ShellProc) StartRecordingFile(path string, opts RecordOpts) error {
+	if sp.IsRecording() {
+		return ErrAlreadyRecording
+	}
+	castFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot create recording: %w", err)
+	}
+	if err := sp.startRecording(castFile, castFile, opts); err != nil {
+		castFile.Close()
+		return err
+	}
+	return nil
+}
+
+func (sp *ShellProc) startRecording(w io.Writer, closer io.Closer, opts RecordOpts) error {
+	termSize := opts.TermSize
+	if termSize.Rows <= 0 || termSize.Cols <= 0 {
+		termSize = TermSize{Rows: shellutil.DefaultTermRows, Cols: shellutil.DefaultTermCols}
+	}
+	if opts.IdleTimeLimit < 0 {
+		return fmt.Errorf("invalid idle time limit %v", opts.IdleTimeLimit)
+	}
+	sp.recordLock.Lock()
+	defer sp.recordLock.Unlock()
+	if sp.recorder != nil && !sp.recorder.done() {
+		return ErrAlreadyRecording
+	}
+	if sp.shellGone() {
+		return ErrShellExited
+	}
+	rec := &recorder{
+		Clock:    sp.clock,
+		Start:    sp.clock.Now(),
+		Out:      bufio.NewWriter(w),
+		Closer:   closer,
+		ResizeCh: make(chan TermSize, recordResizeBufSize),
+		StopCh:   make(chan struct{}),
+		DoneCh:   make(chan struct{}),
+	}
+	rec.writeLine(asciicastHeader{
+		Version:       asciicastVersion,
+		Width:         termSize.Cols,
+		Height:        termSize.Rows,
+		Timestamp:     rec.Start.Unix(),
+		IdleTimeLimit: opts.IdleTimeLimit,
+		Title:         opts.Title,
+		Env:           opts.Env,
+	})
+	if rec.Err == nil {
+		if err := rec.Out.Flush(); err != nil {
+			rec.Err = fmt.Errorf("error writing recording: %w", err)
+		}
+	}
+	if rec.Err != nil {
+		return rec.Err
+	}
+	rec.Sub = sp.outputSubs.subscribe(OutputSubOpts{BufSize: recordOutputBufSize})
+	sp.recorder = rec
+	go func() {
+		defer panichandler.PanicHandler("ShellProc:recorder")
+		rec.run()
+	}()
+	return nil
+}
+
+// StopRecording stops the recording and waits for it to be written, returns the write error (or
+// ErrOutputSubOverflow if the recorder fell behind and the recording is incomplete).  Returns
+// ErrNotRecording if nothing was recorded since the last StopRecording
+func (sp *ShellProc) StopRecording() error {
+	sp.recordLock.Lock()
+	rec := sp.recorder
+	sp.recorder = nil
+	sp.recordLock.Unlock()
+	if rec == nil {
+		return ErrNotRecording
+	}
+	close(rec.StopCh)
+	<-rec.DoneCh
+	return rec.Err
+}
+
+// IsRecording returns true while a recording is running (it stops by itself once the output ends)
+func (sp *ShellProc) IsRecording() bool {
+	sp.recordLock.Lock()
+	defer sp.recordLock.Unlock()
+	return sp.recorder != nil && !sp.recorder.done()
+}
+
+// called by SetTermSize, a resize is dropped if the recorder is that far behind
+func (sp *ShellProc) recordResize(termSize TermSize) {
+	sp.recordLock.Lock()
+	defer sp.recordLock.Unlock()
+	if sp.recorder == nil || sp.recorder.done() {
+		return
+	}
+	select {
+	case sp.recorder.ResizeCh <- termSize:
+	default:
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parses a recording into its header and events
+func parseAsciicast(t *testing.T, data []byte) (asciicastHeader, [][]any) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	var header asciicastHeader
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatalf("error parsing header %q: %v", lines[0], err)
+	}
+	var events [][]any
+	lastTime := 0.0
+	for _, line := range lines[1:] {
+		var event []any
+		if err := json.Unmarshal([]byte(line), &event); err != nil || len(event) != 3 {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		if eventTime := event[0].(float64); eventTime < lastTime {
+			t.Errorf("event %q is out of order", line)
+		} else {
+			lastTime = eventTime
+		}
+		events = append(events, event)
+	}
+	return header, events
+}
+
+func TestRecording(t *testing.T) {
+	sp := startTestShellProc(t, `read x; echo "recorded-$x"; read y`, CommandOptsType{})
+	oc := collectOutput(sp)
+	var castBuf bytes.Buffer
+	if err := sp.StartRecording(&castBuf, RecordOpts{TermSize: TermSize{Rows: 24, Cols: 80}, Title: "test"}); err != nil {
+		t.Fatalf("error starting recording: %v", err)
+	}
+	if !sp.IsRecording() {
+		t.Errorf("expected IsRecording")
+	}
+	if err := sp.StartRecording(&bytes.Buffer{}, RecordOpts{}); !errors.Is(err, ErrAlreadyRecording) {
+		t.Errorf("expected ErrAlreadyRecording, got %v", err)
+	}
+	if err := sp.SetTermSize(TermSize{Rows: 30, Cols: 100}); err != nil {
+		t.Fatalf("error resizing: %v", err)
+	}
+	sp.Write([]byte("one\n"))
+	oc.waitFor(t, "recorded-one")
+	if err := sp.StopRecording(); err != nil {
+		t.Fatalf("error stopping recording: %v", err)
+	}
+	if err := sp.StopRecording(); !errors.Is(err, ErrNotRecording) {
+		t.Errorf("expected ErrNotRecording, got %v", err)
+	}
+	header, events := parseAsciicast(t, castBuf.Bytes())
+	if header.Version != 2 || header.Width != 80 || header.Height != 24 || header.Title != "test" || header.Timestamp == 0 {
+		t.Errorf("unexpected header %+v", header)
+	}
+	var output strings.Builder
+	var resized bool
+	for _, event := range events {
+		switch event[1] {
+		case "o":
+			output.WriteString(event[2].(string))
+		case "r":
+			resized = resized || event[2] == "100x30"
+		}
+	}
+	if !resized {
+		t.Errorf("expected a resize event, got %v", events)
+	}
+	if !strings.Contains(output.String(), "recorded-one") {
+		t.Errorf("expected the output to be recorded, got %q", output.String())
+	}
+}
+
+func TestRecordingFile(t *testing.T) {
+	sp := startTestShellProc(t, `read x; echo "recorded-$x"`, CommandOptsType{})
+	oc := collectOutput(sp)
+	castPath := filepath.Join(t.TempDir(), "session.cast")
+	if err := sp.StartRecordingFile(castPath, RecordOpts{}); err != nil {
+		t.Fatalf("error starting recording: %v", err)
+	}
+	sp.Write([]byte("two\n"))
+	<-oc.Done
+	// the recording stops by itself at the end of the output
+	for deadline := time.Now().Add(testWaitTimeout); sp.IsRecording() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sp.IsRecording() {
+		t.Fatalf("expected the recording to stop with the output")
+	}
+	if err := sp.StopRecording(); err != nil {
+		t.Fatalf("error stopping recording: %v", err)
+	}
+	data, err := os.ReadFile(castPath)
+	if err != nil {
+		t.Fatalf("error reading recording: %v", err)
+	}
+	header, events := parseAsciicast(t, data)
+	if header.Width != 80 || header.Height != 24 {
+		t.Errorf("expected the default size, got %+v", header)
+	}
+	if len(events) == 0 || !strings.Contains(string(data), "recorded-two") {
+		t.Errorf("expected the output to be recorded, got %q", data)
+	}
+	if err := sp.StartRecording(&bytes.Buffer{}, RecordOpts{}); !errors.Is(err, ErrShellExited) {
+		t.Errorf("expected ErrShellExited, got %v", err)
+	}
+}
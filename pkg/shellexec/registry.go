@@ -0,0 +1,152 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"sync"
+	"time"
+)
+
+// ended sessions are kept (so processes left behind in them can be found) until an
+// audit sees them empty, or until there are more than this many
+const maxEndedSessions = 1000
+
+// shellSession is a session we created, local shells are started with Setsid so the
+// session id (Sid, not to be confused with our SessionId) is the shell's pid
+type shellSession struct {
+	Sid       int
+	SessionId string
+	StartedAt time.Time
+	EndedAt   time.Time  // zero while the shell is running
+	Proc      *ShellProc // nil once the shell has exited
+}
+
+type sessionRegistry struct {
+	Lock     *sync.Mutex
+	Sessions map[int]*shellSession
+	Ended    []int                 // sids of ended sessions, oldest first
+	ById     map[string]*ShellProc // every running shellproc (local or not), by SessionId
+}
+
+var shellRegistry = &sessionRegistry{Lock: &sync.Mutex{}, Sessions: make(map[int]*shellSession), ById: make(map[string]*ShellProc)}
+
+// called for every shellproc when it is made
+func (reg *sessionRegistry) addProc(sp *ShellProc) {
+	reg.Lock.Lock()
+	defer reg.Lock.Unlock()
+	reg.ById[sp.sessionId] = sp
+}
+
+func (reg *sessionRegistry) getById(id string) *ShellProc {
+	reg.Lock.Lock()
+	defer reg.Lock.Unlock()
+	return reg.ById[id]
+}
+
+// the running shellprocs
+func (reg *sessionRegistry) procs() []*ShellProc {
+	reg.Lock.Lock()
+	defer reg.Lock.Unlock()
+	rtn := make([]*ShellProc, 0, len(reg.ById))
+	for _, sp := range reg.ById {
+		rtn = append(rtn, sp)
+	}
+	return rtn
+}
+
+// called for local shells once they have started (the sid is known)
+func (reg *sessionRegistry) register(sp *ShellProc) {
+	sid := sp.localPid()
+	if sid <= 0 {
+		return
+	}
+	reg.Lock.Lock()
+	reg.Sessions[sid] = &shellSession{Sid: sid, SessionId: sp.sessionId, StartedAt: sp.clock.Now(), Proc: sp}
+	reg.Lock.Unlock()
+	storeShellProc(sp, sid)
+}
+
+func (reg *sessionRegistry) ended(sp *ShellProc) {
+	reg.Lock.Lock()
+	if reg.ById[sp.sessionId] == sp {
+		delete(reg.ById, sp.sessionId)
+	}
+	reg.sessionEndedLocked(sp, sp.localPid())
+	reg.Lock.Unlock()
+	if sp.localPid() > 0 {
+		removeStoredShellProc(sp.sessionId)
+	}
+}
+
+// an orphaned session of a previous wavesrv (see HandleOrphanedShells), kept like one of our
+// ended sessions so Audit reports (and AuditFix can signal) what is left in it
+func (reg *sessionRegistry) adoptEnded(sid int, sessionId string, startedAt time.Time, now time.Time) {
+	reg.Lock.Lock()
+	defer reg.Lock.Unlock()
+	if reg.Sessions[sid] != nil {
+		return
+	}
+	reg.Sessions[sid] = &shellSession{Sid: sid, SessionId: sessionId, StartedAt: startedAt, EndedAt: now}
+	reg.Ended = append(reg.Ended, sid)
+	for len(reg.Ended) > maxEndedSessions {
+		reg.removeEndedLocked(reg.Ended[0])
+	}
+}
+
+// every run of a supervised command is its own session, the shellproc keeps running
+func (reg *sessionRegistry) runEnded(sp *ShellProc, sid int) {
+	reg.Lock.Lock()
+	defer reg.Lock.Unlock()
+	reg.sessionEndedLocked(sp, sid)
+}
+
+func (reg *sessionRegistry) sessionEndedLocked(sp *ShellProc, sid int) {
+	if sid <= 0 {
+		return
+	}
+	sess := reg.Sessions[sid]
+	if sess == nil || sess.Proc != sp {
+		return
+	}
+	sess.Proc = nil
+	sess.EndedAt = sp.clock.Now()
+	reg.Ended = append(reg.Ended, sid)
+	for len(reg.Ended) > maxEndedSessions {
+		reg.removeEndedLocked(reg.Ended[0])
+	}
+}
+
+func (reg *sessionRegistry) removeEndedLocked(sid int) {
+	if sess := reg.Sessions[sid]; sess != nil && sess.Proc == nil {
+		delete(reg.Sessions, sid)
+	}
+	for idx, endedSid := range reg.Ended {
+		if endedSid == sid {
+			reg.Ended = append(reg.Ended[:idx], reg.Ended[idx+1:]...)
+			break
+		}
+	}
+}
+
+// returns copies of the sessions, keyed by sid
+func (reg *sessionRegistry) snapshot() map[int]shellSession {
+	reg.Lock.Lock()
+	defer reg.Lock.Unlock()
+	rtn := make(map[int]shellSession, len(reg.Sessions))
+	for sid, sess := range reg.Sessions {
+		rtn[sid] = *sess
+	}
+	return rtn
+}
+
+// forgets ended sessions that have no processes left
+func (reg *sessionRegistry) pruneEnded(inUse map[int]bool) {
+	reg.Lock.Lock()
+	defer reg.Lock.Unlock()
+	for _, sid := range append([]int(nil), reg.Ended...) {
+		if !inUse[sid] {
+			reg.removeEndedLocked(sid)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package shellexec
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// setShellRlimits limits a shell that couldn't get a cgroup, the limits are inherited by everything it starts
+// but apply per process: MemoryLimitBytes is RLIMIT_AS (address space), MaxProcs is RLIMIT_NPROC (which counts
+// all of the user's processes, not just the shell's).  there is no rlimit for CPUQuota.  pid is already
+// running, so (unless it is StartSuspended) the shell can start something before the limits are set
+func setShellRlimits(sessionId string, pid int, limits CgroupLimits) {
+	if limits.CPUQuota > 0 {
+		shellLogf(sessionId, "warning: cannot limit the shell's cpu without a cgroup\n")
+	}
+	for _, rl := range []struct {
+		name     string
+		resource int
+		value    int64
+	}{
+		{"RLIMIT_AS", unix.RLIMIT_AS, limits.MemoryLimitBytes},
+		{"RLIMIT_NPROC", unix.RLIMIT_NPROC, limits.MaxProcs},
+	} {
+		if rl.value <= 0 {
+			continue
+		}
+		rlimit := unix.Rlimit{Cur: uint64(rl.value), Max: uint64(rl.value)}
+		if err := unix.Prlimit(pid, rl.resource, &rlimit, nil); err != nil {
+			shellLogf(sessionId, "warning: cannot set %s for the shell: %v\n", rl.name, err)
+		}
+	}
+}
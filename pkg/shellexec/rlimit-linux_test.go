@@ -0,0 +1,45 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package shellexec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"testing"
+)
+
+func readProcLimit(t *testing.T, pid int, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/limits", pid))
+	if err != nil {
+		t.Fatalf("error reading limits: %v", err)
+	}
+	match := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(name) + `\s+(\S+)`).FindSubmatch(data)
+	if match == nil {
+		t.Fatalf("no %q in limits %q", name, data)
+	}
+	return string(match[1])
+}
+
+func TestSetShellRlimits(t *testing.T) {
+	ecmd := exec.Command(requireBinary(t, "sleep"), "30")
+	if err := ecmd.Start(); err != nil {
+		t.Fatalf("error starting sleep: %v", err)
+	}
+	t.Cleanup(func() {
+		ecmd.Process.Kill()
+		ecmd.Wait()
+	})
+	setShellRlimits("test", ecmd.Process.Pid, CgroupLimits{MemoryLimitBytes: 1 << 30, MaxProcs: 5000})
+	if val := readProcLimit(t, ecmd.Process.Pid, "Max address space"); val != "1073741824" {
+		t.Errorf("unexpected address space limit %q", val)
+	}
+	if val := readProcLimit(t, ecmd.Process.Pid, "Max processes"); val != "5000" {
+		t.Errorf("unexpected process limit %q", val)
+	}
+}
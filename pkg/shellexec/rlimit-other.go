@@ -0,0 +1,11 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package shellexec
+
+// only linux can set the rlimits of another process (prlimit)
+func setShellRlimits(sessionId string, pid int, limits CgroupLimits) {
+	shellLogf(sessionId, "warning: resource limits are only supported on linux, starting shell without them\n")
+}
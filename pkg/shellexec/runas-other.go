@@ -0,0 +1,12 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package shellexec
+
+import "os/exec"
+
+const runAsSupported = false
+
+func setRunAsCredential(ecmd *exec.Cmd, cmdOpts CommandOptsType) {}
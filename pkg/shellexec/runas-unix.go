@@ -0,0 +1,29 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+const runAsSupported = true
+
+// cmdOpts must have been resolved (resolveRunAs), startInPty keeps the Credential
+func setRunAsCredential(ecmd *exec.Cmd, cmdOpts CommandOptsType) {
+	if cmdOpts.Uid == nil {
+		return
+	}
+	if ecmd.SysProcAttr == nil {
+		ecmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	var gid uint32
+	if cmdOpts.Gid != nil {
+		gid = *cmdOpts.Gid
+	}
+	// a nil Groups clears the supplementary groups (ours are never passed on)
+	ecmd.SysProcAttr.Credential = &syscall.Credential{Uid: *cmdOpts.Uid, Gid: gid, Groups: cmdOpts.Groups}
+}
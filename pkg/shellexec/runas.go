@@ -0,0 +1,84 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// checks the run-as options (cmdOpts.Uid), a missing Gid or Groups is filled in from the user database
+func resolveRunAs(cmdOpts *CommandOptsType) error {
+	if cmdOpts.Uid == nil {
+		if cmdOpts.Gid != nil || cmdOpts.Groups != nil {
+			return fmt.Errorf("Gid and Groups require Uid")
+		}
+		return nil
+	}
+	if !runAsSupported {
+		return fmt.Errorf("Uid is not supported on this platform")
+	}
+	if cmdOpts.Elevate || cmdOpts.Supervise != nil {
+		return fmt.Errorf("Uid cannot be combined with Elevate or Supervise")
+	}
+	if cmdOpts.Gid != nil && cmdOpts.Groups != nil {
+		return nil
+	}
+	runAsUser, err := user.LookupId(strconv.FormatUint(uint64(*cmdOpts.Uid), 10))
+	if err != nil {
+		if cmdOpts.Gid == nil {
+			return fmt.Errorf("cannot find user %d (set Gid): %w", *cmdOpts.Uid, err)
+		}
+		// an unknown uid with an explicit Gid just gets no supplementary groups
+		return nil
+	}
+	if cmdOpts.Gid == nil {
+		gid, err := parseRunAsId(runAsUser.Gid)
+		if err != nil {
+			return err
+		}
+		cmdOpts.Gid = &gid
+	}
+	if cmdOpts.Groups == nil {
+		groupIds, err := runAsUser.GroupIds()
+		if err != nil {
+			return fmt.Errorf("cannot get the groups of user %q: %w", runAsUser.Username, err)
+		}
+		groups := make([]uint32, 0, len(groupIds))
+		for _, groupId := range groupIds {
+			gid, err := parseRunAsId(groupId)
+			if err != nil {
+				return err
+			}
+			groups = append(groups, gid)
+		}
+		cmdOpts.Groups = groups
+	}
+	return nil
+}
+
+func parseRunAsId(id string) (uint32, error) {
+	val, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q: %w", id, err)
+	}
+	return uint32(val), nil
+}
+
+// HOME, USER and LOGNAME of the user a run-as shell runs as (nil if Uid isn't set or the user is unknown)
+func runAsEnvVars(cmdOpts CommandOptsType) map[string]string {
+	if cmdOpts.Uid == nil {
+		return nil
+	}
+	runAsUser, err := user.LookupId(strconv.FormatUint(uint64(*cmdOpts.Uid), 10))
+	if err != nil {
+		return nil
+	}
+	return map[string]string{
+		"HOME":    runAsUser.HomeDir,
+		"USER":    runAsUser.Username,
+		"LOGNAME": runAsUser.Username,
+	}
+}
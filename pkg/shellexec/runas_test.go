@@ -0,0 +1,65 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"os"
+	"os/user"
+	"runtime"
+	"testing"
+)
+
+func TestResolveRunAs(t *testing.T) {
+	uid, gid := uint32(1234), uint32(5678)
+	for _, cmdOpts := range []CommandOptsType{
+		{Gid: &gid},
+		{Groups: []uint32{gid}},
+		{Uid: &uid, Gid: &gid, Elevate: true},
+		{Uid: &uid, Gid: &gid, Supervise: &SupervisorOpts{}},
+	} {
+		if err := resolveRunAs(&cmdOpts); err == nil {
+			t.Errorf("%+v: expected an error", cmdOpts)
+		}
+	}
+	cmdOpts := CommandOptsType{Uid: &uid, Gid: &gid, Groups: []uint32{}}
+	if err := resolveRunAs(&cmdOpts); err != nil && runAsSupported {
+		t.Errorf("expected explicit ids to be accepted, got %v", err)
+	}
+	if !runAsSupported {
+		return
+	}
+	self, err := user.Current()
+	if err != nil {
+		t.Skipf("cannot get the current user: %v", err)
+	}
+	selfUid := uint32(os.Getuid())
+	cmdOpts = CommandOptsType{Uid: &selfUid}
+	if err := resolveRunAs(&cmdOpts); err != nil {
+		t.Fatalf("error resolving the current user: %v", err)
+	}
+	if cmdOpts.Gid == nil || *cmdOpts.Gid != uint32(os.Getgid()) || cmdOpts.Groups == nil {
+		t.Errorf("expected the user's gid and groups to be filled in, got %v %v", cmdOpts.Gid, cmdOpts.Groups)
+	}
+	if env := runAsEnvVars(cmdOpts); env["USER"] != self.Username || env["HOME"] != self.HomeDir {
+		t.Errorf("unexpected run-as env %v", env)
+	}
+}
+
+func TestRunAsUser(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Getuid() != 0 {
+		t.Skip("needs root on linux")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skipf("no nobody user: %v", err)
+	}
+	uid, err := parseRunAsId(nobody.Uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := startTestShellProc(t, "echo ids=$(id -u):$(id -g):$(id -G) user=$USER", CommandOptsType{Uid: &uid, Cwd: "/"})
+	oc := collectOutput(sp)
+	expected := "ids=" + nobody.Uid + ":" + nobody.Gid + ":" + nobody.Gid + " user=" + nobody.Username
+	oc.waitFor(t, expected)
+}
@@ -0,0 +1,247 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"strings"
+)
+
+const (
+	SanitizeProfile_Trusted  = "trusted"  // everything is passed through (the default for local shells)
+	SanitizeProfile_Standard = "standard" // report requests, iTerm2 file transfers and oversized payloads are stripped (the default for remote and wsl shells)
+	SanitizeProfile_Paranoid = "paranoid" // only text, BEL and basic SGR, cursor and erase sequences are passed
+)
+
+// why a sequence was stripped (the keys of SanitizeStats.ByReason)
+const (
+	SanitizeReason_Report     = "report"     // asks the terminal for a response, which gets typed into the shell
+	SanitizeReason_FileXfer   = "filexfer"   // iTerm2 file transfer (OSC 1337 File=, RequestUpload, ...)
+	SanitizeReason_Oversize   = "oversize"   // payload over SanitizeMaxPayloadLen (or the parser's max length)
+	SanitizeReason_NotAllowed = "notallowed" // not on the Paranoid allow list
+)
+
+// Standard strips OSC and DCS/SOS/PM/APC sequences with longer payloads, a renderer
+// can stall on them
+const SanitizeMaxPayloadLen = 8 * 1024
+
+// CSI xterm window ops (CSI Ps t) that report back instead of doing something
+var windowOpReports = map[string]bool{"11": true, "13": true, "14": true, "15": true, "16": true, "18": true, "19": true, "20": true, "21": true}
+
+// paranoid CSI finals: cursor movement (CUU..CHA, CUP, HVP, VPA), erase (ED, EL) and SGR
+const paranoidCSIFinals = "ABCDEFGHJKdfm"
+
+func isValidSanitizeProfile(profile string) bool {
+	return profile == SanitizeProfile_Trusted || profile == SanitizeProfile_Standard || profile == SanitizeProfile_Paranoid
+}
+
+// sets cmdOpts.SanitizeProfile to defaultProfile (which depends on the backend) if it is empty
+func resolveSanitizeProfile(cmdOpts *CommandOptsType, defaultProfile string) error {
+	if cmdOpts.SanitizeProfile == "" {
+		cmdOpts.SanitizeProfile = defaultProfile
+		return nil
+	}
+	if !isValidSanitizeProfile(cmdOpts.SanitizeProfile) {
+		return fmt.Errorf("invalid sanitize profile %q", cmdOpts.SanitizeProfile)
+	}
+	return nil
+}
+
+// sanitizeDecision returns why tok should be stripped under profile, "" to pass it through
+func sanitizeDecision(profile string, tok seqToken) string {
+	switch profile {
+	case SanitizeProfile_Standard:
+		return standardDecision(tok)
+	case SanitizeProfile_Paranoid:
+		return paranoidDecision(tok)
+	}
+	return ""
+}
+
+func standardDecision(tok seqToken) string {
+	if tok.Type == tokType_Text || tok.Type == tokType_Bell {
+		return ""
+	}
+	if tok.Overflow {
+		return SanitizeReason_Oversize
+	}
+	switch tok.Type {
+	case tokType_Esc:
+		if bytes.Equal(tok.Payload, []byte("Z")) {
+			// DECID, answered like DA
+			return SanitizeReason_Report
+		}
+	case tokType_CSI:
+		if isCSIReportRequest(tok.Payload) {
+			return SanitizeReason_Report
+		}
+	case tokType_OSC:
+		if len(tok.Payload) > SanitizeMaxPayloadLen {
+			return SanitizeReason_Oversize
+		}
+		return oscDecision(string(tok.Payload))
+	case tokType_String:
+		if len(tok.Payload) > SanitizeMaxPayloadLen {
+			return SanitizeReason_Oversize
+		}
+		// DECRQSS (DCS $ q) and XTGETTCAP (DCS + q)
+		if tok.Intro == 'P' && (bytes.HasPrefix(tok.Payload, []byte("$q")) || bytes.HasPrefix(tok.Payload, []byte("+q"))) {
+			return SanitizeReason_Report
+		}
+	}
+	return ""
+}
+
+// payload is params + intermediates + final
+func isCSIReportRequest(payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	final := payload[len(payload)-1]
+	params := string(payload[:len(payload)-1])
+	switch final {
+	case 'n', 'c':
+		// DSR (CSI 5n, CSI 6n, CSI ? 6n, ...) and DA (CSI c, CSI > c, CSI = c)
+		return true
+	case 'p':
+		// DECRQM (CSI Ps $ p, CSI ? Ps $ p)
+		return strings.HasSuffix(params, "$")
+	case 'q':
+		// XTVERSION (CSI > q)
+		return strings.HasPrefix(params, ">")
+	case 'u':
+		// kitty keyboard protocol query (CSI ? u)
+		return params == "?"
+	case 'x':
+		// DECREQTPARM
+		return !strings.ContainsAny(params, "*$")
+	case 't':
+		op, _, _ := strings.Cut(params, ";")
+		return windowOpReports[op]
+	}
+	return false
+}
+
+func oscDecision(payload string) string {
+	code, arg, _ := strings.Cut(payload, ";")
+	if code == "1337" {
+		name, _, _ := strings.Cut(arg, "=")
+		switch name {
+		case "File", "MultipartFile", "FilePart", "FileEnd", "RequestUpload":
+			return SanitizeReason_FileXfer
+		case "ReportCellSize", "ReportVariable":
+			return SanitizeReason_Report
+		}
+		return ""
+	}
+	// queries (colors OSC 4/10/11/..., the clipboard OSC 52, fonts OSC 50) have a "?" argument
+	for _, field := range strings.Split(arg, ";") {
+		if field == "?" {
+			return SanitizeReason_Report
+		}
+	}
+	return ""
+}
+
+func paranoidDecision(tok seqToken) string {
+	if tok.Overflow {
+		return SanitizeReason_Oversize
+	}
+	switch tok.Type {
+	case tokType_Text, tokType_Bell:
+		return ""
+	case tokType_Esc:
+		// DECSC / DECRC (save and restore the cursor)
+		if !tok.Unterminated && (bytes.Equal(tok.Payload, []byte("7")) || bytes.Equal(tok.Payload, []byte("8"))) {
+			return ""
+		}
+	case tokType_CSI:
+		if !tok.Unterminated && isParanoidCSI(tok.Payload) {
+			return ""
+		}
+	}
+	return SanitizeReason_NotAllowed
+}
+
+// numeric params only (no private markers or intermediates) and an allowed final
+func isParanoidCSI(payload []byte) bool {
+	if len(payload) == 0 || strings.IndexByte(paranoidCSIFinals, payload[len(payload)-1]) < 0 {
+		return false
+	}
+	for _, ch := range payload[:len(payload)-1] {
+		if (ch < '0' || ch > '9') && ch != ';' {
+			return false
+		}
+	}
+	return true
+}
+
+// SanitizeStats counts the sequences stripped from a shell's output (see CommandOptsType.SanitizeProfile)
+type SanitizeStats struct {
+	Profile       string         `json:"profile"`
+	Stripped      int            `json:"stripped"`      // sequences (an over-long sequence counts once)
+	StrippedBytes int64          `json:"strippedbytes"` // bytes removed from the output stream
+	ByReason      map[string]int `json:"byreason,omitempty"`
+}
+
+// outputSanitizer is used by the output handler (under its lock)
+type outputSanitizer struct {
+	Profile    string
+	SessionId  string
+	Log        bool
+	Stats      SanitizeStats
+	InOverflow bool // the last token was a stripped chunk of an over-long sequence
+}
+
+func makeOutputSanitizer(profile string, sessionId string, logStripped bool) *outputSanitizer {
+	return &outputSanitizer{
+		Profile:   profile,
+		SessionId: sessionId,
+		Log:       logStripped,
+		Stats:     SanitizeStats{Profile: profile, ByReason: make(map[string]int)},
+	}
+}
+
+// returns false if tok should be stripped from the output
+func (s *outputSanitizer) allow(tok seqToken) bool {
+	if s.Profile == SanitizeProfile_Trusted {
+		return true
+	}
+	reason := sanitizeDecision(s.Profile, tok)
+	if reason == "" {
+		s.InOverflow = false
+		return true
+	}
+	s.Stats.StrippedBytes += int64(len(tok.Raw))
+	if tok.Overflow && s.InOverflow {
+		// the rest of a sequence that was already counted
+		return false
+	}
+	s.InOverflow = tok.Overflow
+	s.Stats.Stripped++
+	s.Stats.ByReason[reason]++
+	if s.Log {
+		raw := tok.Raw
+		if len(raw) > 64 {
+			raw = raw[:64]
+		}
+		shellLogf(s.SessionId, "sanitize (%s): stripped %s sequence (%s) %q\n", s.Profile, tok.Type, reason, raw)
+	}
+	return false
+}
+
+func (s *outputSanitizer) getStats() SanitizeStats {
+	rtn := s.Stats
+	rtn.ByReason = maps.Clone(s.Stats.ByReason)
+	return rtn
+}
+
+// SanitizeStats returns what has been stripped from the output so far (see CommandOptsType.SanitizeProfile)
+func (sp *ShellProc) SanitizeStats() SanitizeStats {
+	sp.output.Lock.Lock()
+	defer sp.output.Lock.Unlock()
+	return sp.output.Sanitizer.getStats()
+}
@@ -0,0 +1,132 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+type sanitizeCase struct {
+	Name     string
+	Seq      string
+	Standard string // expected reason, "" passes
+	Paranoid string
+}
+
+var hostileCorpus = []sanitizeCase{
+	{"sgr", "\x1b[1;31m", "", ""},
+	{"sgr reset", "\x1b[m", "", ""},
+	{"cursor up", "\x1b[3A", "", ""},
+	{"cursor position", "\x1b[10;20H", "", ""},
+	{"erase display", "\x1b[2J", "", ""},
+	{"erase line", "\x1b[K", "", ""},
+	{"save cursor", "\x1b7", "", ""},
+	{"bell", "\x07", "", ""},
+	{"alt screen", "\x1b[?1049h", "", SanitizeReason_NotAllowed},
+	{"bracketed paste", "\x1b[?2004h", "", SanitizeReason_NotAllowed},
+	{"charset", "\x1b(B", "", SanitizeReason_NotAllowed},
+	{"title", "\x1b]0;sudo password required\x07", "", SanitizeReason_NotAllowed},
+	{"title st", "\x1b]2;spoofed\x1b\\", "", SanitizeReason_NotAllowed},
+	{"hyperlink", "\x1b]8;;https://example.com\x07", "", SanitizeReason_NotAllowed},
+	{"clipboard set", "\x1b]52;c;aGVsbG8=\x07", "", SanitizeReason_NotAllowed},
+	{"dsr cursor", "\x1b[6n", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"dsr status", "\x1b[5n", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"dec dsr", "\x1b[?6n", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"primary da", "\x1b[c", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"secondary da", "\x1b[>c", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"tertiary da", "\x1b[=c", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"decid", "\x1bZ", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"decrqm", "\x1b[?2004$p", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"xtversion", "\x1b[>q", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"kitty keyboard query", "\x1b[?u", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"decreqtparm", "\x1b[1x", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"title report", "\x1b[21t", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"window size report", "\x1b[18t", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"window resize", "\x1b[8;24;80t", "", SanitizeReason_NotAllowed},
+	{"color query", "\x1b]11;?\x07", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"palette query", "\x1b]4;1;?\x1b\\", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"clipboard read", "\x1b]52;c;?\x07", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"decrqss", "\x1bP$qm\x1b\\", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"xtgettcap", "\x1bP+q544e\x1b\\", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"sixel", "\x1bPq#0;2;0;0;0#0~~\x1b\\", "", SanitizeReason_NotAllowed},
+	{"iterm2 file", "\x1b]1337;File=name=ZXZpbA==;inline=0:aGVsbG8=\x07", SanitizeReason_FileXfer, SanitizeReason_NotAllowed},
+	{"iterm2 multipart", "\x1b]1337;MultipartFile=name=eA==\x07", SanitizeReason_FileXfer, SanitizeReason_NotAllowed},
+	{"iterm2 upload", "\x1b]1337;RequestUpload=format=tgz\x07", SanitizeReason_FileXfer, SanitizeReason_NotAllowed},
+	{"iterm2 report", "\x1b]1337;ReportCellSize\x07", SanitizeReason_Report, SanitizeReason_NotAllowed},
+	{"iterm2 mark", "\x1b]1337;SetMark\x07", "", SanitizeReason_NotAllowed},
+	{"long dcs", "\x1bP" + strings.Repeat("x", SanitizeMaxPayloadLen+1) + "\x1b\\", SanitizeReason_Oversize, SanitizeReason_NotAllowed},
+	{"long osc", "\x1b]2;" + strings.Repeat("x", SanitizeMaxPayloadLen) + "\x07", SanitizeReason_Oversize, SanitizeReason_NotAllowed},
+	{"overflowing apc", "\x1b_" + strings.Repeat("x", 3*MaxStringSeqLen) + "\x1b\\", SanitizeReason_Oversize, SanitizeReason_Oversize},
+}
+
+func TestSanitizeCorpus(t *testing.T) {
+	for _, tc := range hostileCorpus {
+		for _, profile := range []string{SanitizeProfile_Trusted, SanitizeProfile_Standard, SanitizeProfile_Paranoid} {
+			expected := map[string]string{SanitizeProfile_Standard: tc.Standard, SanitizeProfile_Paranoid: tc.Paranoid}[profile]
+			oh := makeOutputHandler(makeEventHub())
+			oh.Sanitizer = makeOutputSanitizer(profile, "test", false)
+			var outBuf bytes.Buffer
+			input := "before" + tc.Seq + "after"
+			oh.processData([]byte(input), &outBuf)
+			oh.flush(&outBuf)
+			stats := oh.Sanitizer.getStats()
+			if expected == "" {
+				if outBuf.String() != input || stats.Stripped != 0 {
+					t.Errorf("%s (%s): expected the sequence to pass, got %q (%+v)", tc.Name, profile, outBuf.String(), stats)
+				}
+				continue
+			}
+			if outBuf.String() != "beforeafter" {
+				t.Errorf("%s (%s): expected the sequence to be stripped, got %d bytes", tc.Name, profile, outBuf.Len())
+			}
+			if stats.Stripped != 1 || stats.ByReason[expected] != 1 || stats.StrippedBytes != int64(len(tc.Seq)) {
+				t.Errorf("%s (%s): expected one %s strip of %d bytes, got %+v", tc.Name, profile, expected, len(tc.Seq), stats)
+			}
+		}
+	}
+}
+
+func TestSanitizeProfileDefaults(t *testing.T) {
+	var cmdOpts CommandOptsType
+	if err := resolveSanitizeProfile(&cmdOpts, SanitizeProfile_Standard); err != nil || cmdOpts.SanitizeProfile != SanitizeProfile_Standard {
+		t.Errorf("expected the backend default, got %q %v", cmdOpts.SanitizeProfile, err)
+	}
+	cmdOpts = CommandOptsType{SanitizeProfile: SanitizeProfile_Paranoid}
+	if err := resolveSanitizeProfile(&cmdOpts, SanitizeProfile_Trusted); err != nil || cmdOpts.SanitizeProfile != SanitizeProfile_Paranoid {
+		t.Errorf("expected the caller's profile, got %q %v", cmdOpts.SanitizeProfile, err)
+	}
+	if _, err := StartShellProc(waveobj.TermSize{Rows: 24, Cols: 80}, "true", CommandOptsType{SanitizeProfile: "lax"}); err == nil {
+		t.Errorf("expected an error for an unknown profile")
+	}
+}
+
+func TestSanitizeShellOutput(t *testing.T) {
+	cmdStr := `printf 'a\033]0;spoofed\007b\033[6nc\033[1md\n'; sleep 30`
+	local := startTestShellProc(t, cmdStr, CommandOptsType{})
+	if stats := local.SanitizeStats(); stats.Profile != SanitizeProfile_Trusted {
+		t.Errorf("local shells should default to trusted, got %q", stats.Profile)
+	}
+	sp := startTestShellProc(t, cmdStr, CommandOptsType{SanitizeProfile: SanitizeProfile_Paranoid})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	oc := collectOutput(sp)
+	oc.waitFor(t, "abc\x1b[1md")
+	scrollback, _ := sp.scrollback.snapshot()
+	if strings.Contains(string(scrollback), "spoofed") {
+		t.Errorf("the title should not be in the scrollback")
+	}
+	stats := sp.SanitizeStats()
+	if stats.Stripped != 2 || stats.ByReason[SanitizeReason_NotAllowed] != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	for _, event := range drainEvents(eventCh) {
+		if event.Kind == EventKind_Title {
+			t.Errorf("a stripped title should not publish an event")
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/wavebase"
+)
+
+const (
+	WaveScratchDir        = "scratch"
+	WaveScratchDirVarName = "WAVETERM_SCRATCH_DIR" // exported to local shells, the dir may not exist until wave puts something there
+)
+
+// something started by the shell can still be writing in the dir while it is
+// removed, RemoveAll is retried a few times before we give up on it (the next
+// SweepScratchDirs gets it)
+const scratchRemoveTries = 3
+const scratchRemoveRetryDelay = 50 * time.Millisecond
+
+// the scratch dirs of the shellprocs of this process (created or not), SweepScratchDirs skips them
+var liveScratchLock = &sync.Mutex{}
+var liveScratch = make(map[string]bool)
+
+func GetScratchRootDir() string {
+	return filepath.Join(wavebase.GetWaveDataDir(), WaveScratchDir)
+}
+
+func scratchDirPath(sessionId string) string {
+	return filepath.Join(GetScratchRootDir(), sessionId)
+}
+
+// scratchDir is a shellproc's temp dir, it is created on first use and removed
+// (with everything in it) once the shellproc is done
+type scratchDir struct {
+	Lock      *sync.Mutex
+	SessionId string
+	Path      string
+	Created   bool
+	Removed   bool
+}
+
+func makeScratchDir(sessionId string) *scratchDir {
+	sd := &scratchDir{Lock: &sync.Mutex{}, SessionId: sessionId, Path: scratchDirPath(sessionId)}
+	liveScratchLock.Lock()
+	defer liveScratchLock.Unlock()
+	liveScratch[sd.Path] = true
+	return sd
+}
+
+func (sd *scratchDir) get() (string, error) {
+	sd.Lock.Lock()
+	defer sd.Lock.Unlock()
+	if sd.Removed {
+		return "", ErrShellExited
+	}
+	if sd.Created {
+		return sd.Path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(sd.Path), 0700); err != nil {
+		return "", fmt.Errorf("error creating scratch root dir: %w", err)
+	}
+	if err := os.Mkdir(sd.Path, 0700); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("error creating scratch dir: %w", err)
+	}
+	sd.Created = true
+	return sd.Path, nil
+}
+
+// best effort, failures are logged
+func (sd *scratchDir) remove() {
+	sd.Lock.Lock()
+	defer sd.Lock.Unlock()
+	if sd.Removed {
+		return
+	}
+	sd.Removed = true
+	if sd.Created {
+		if err := removeScratchDir(sd.Path); err != nil {
+			shellLogf(sd.SessionId, "warning: cannot remove scratch dir: %v\n", err)
+		}
+	}
+	liveScratchLock.Lock()
+	defer liveScratchLock.Unlock()
+	delete(liveScratch, sd.Path)
+}
+
+func removeScratchDir(path string) error {
+	var err error
+	for try := 0; try < scratchRemoveTries; try++ {
+		if try > 0 {
+			<-shellClock.After(scratchRemoveRetryDelay)
+		}
+		if err = os.RemoveAll(path); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// ScratchDir returns the shellproc's scratch dir (creating it if needed), a 0700 dir
+// under the wave data dir for temp files that should live as long as the shellproc.
+// It is removed after the shell has exited.  Local shells get the path in
+// WAVETERM_SCRATCH_DIR, for remote shells it is still a local dir.
+func (sp *ShellProc) ScratchDir() (string, error) {
+	return sp.scratch.get()
+}
+
+// SweepScratchDirs removes the scratch dirs left behind by shellprocs that no
+// longer exist (wavesrv was killed, or a removal failed), should be run at startup.
+// The dirs of keepSessionIds (e.g. adopted orphans, see HandleOrphanedShells) are kept
+func SweepScratchDirs(keepSessionIds []string) error {
+	keep := make(map[string]bool)
+	for _, sessionId := range keepSessionIds {
+		keep[scratchDirPath(sessionId)] = true
+	}
+	entries, err := os.ReadDir(GetScratchRootDir())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading scratch root dir: %w", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(GetScratchRootDir(), entry.Name())
+		liveScratchLock.Lock()
+		live := liveScratch[path]
+		liveScratchLock.Unlock()
+		if live || keep[path] {
+			continue
+		}
+		if err := removeScratchDir(path); err != nil {
+			log.Printf("warning: cannot remove stale scratch dir: %v\n", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// makes the scratch dir and puts a file in it, returns the dir
+func fillScratchDir(t *testing.T, sp *ShellProc) string {
+	t.Helper()
+	dir, err := sp.ScratchDir()
+	if err != nil {
+		t.Fatalf("error getting scratch dir: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatalf("error filling scratch dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "file"), []byte("data"), 0600); err != nil {
+		t.Fatalf("error filling scratch dir: %v", err)
+	}
+	return dir
+}
+
+func waitDone(t *testing.T, sp *ShellProc) {
+	t.Helper()
+	select {
+	case <-sp.DoneCh:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for DoneCh")
+	}
+}
+
+func TestScratchDirOnDemand(t *testing.T) {
+	sp := startTestShellProc(t, "sleep 30", CommandOptsType{})
+	path := scratchDirPath(sp.sessionId)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("the scratch dir should not exist before it is asked for (err %v)", err)
+	}
+	dir, err := sp.ScratchDir()
+	if err != nil {
+		t.Fatalf("error getting scratch dir: %v", err)
+	}
+	if dir != path {
+		t.Errorf("expected scratch dir %q, got %q", path, dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		t.Fatalf("scratch dir was not created: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0700 {
+		t.Errorf("expected mode 0700, got %v", info.Mode().Perm())
+	}
+	if again, err := sp.ScratchDir(); err != nil || again != dir {
+		t.Errorf("expected the same dir again, got %q, %v", again, err)
+	}
+}
+
+func TestScratchDirEnv(t *testing.T) {
+	sp := startTestShellProc(t, `echo "scratch=[$WAVETERM_SCRATCH_DIR]"; sleep 30`, CommandOptsType{})
+	oc := collectOutput(sp)
+	dir, err := sp.ScratchDir()
+	if err != nil {
+		t.Fatalf("error getting scratch dir: %v", err)
+	}
+	oc.waitFor(t, "scratch=["+dir+"]")
+}
+
+func TestScratchDirRemovedAfterExit(t *testing.T) {
+	sp := startTestShellProc(t, "read line; exit 0", CommandOptsType{})
+	dir := fillScratchDir(t, sp)
+	if _, err := sp.Cmd.Write([]byte("\n")); err != nil {
+		t.Fatalf("error writing to pty: %v", err)
+	}
+	waitExitStatus(t, sp)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("scratch dir should be removed after a graceful exit (err %v)", err)
+	}
+	if _, err := sp.ScratchDir(); err != ErrShellExited {
+		t.Errorf("expected ErrShellExited after exit, got %v", err)
+	}
+}
+
+func TestScratchDirRemovedAfterKill(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no SIGKILL on windows")
+	}
+	sp := startTestShellProc(t, "sleep 30", CommandOptsType{})
+	dir := fillScratchDir(t, sp)
+	proc, err := os.FindProcess(sp.localPid())
+	if err != nil {
+		t.Fatalf("error finding shell: %v", err)
+	}
+	if err := proc.Kill(); err != nil {
+		t.Fatalf("error killing shell: %v", err)
+	}
+	sp.Close()
+	waitDone(t, sp)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("scratch dir should be removed after the shell is killed (err %v)", err)
+	}
+}
+
+func TestSweepScratchDirs(t *testing.T) {
+	sp := startTestShellProc(t, "sleep 30", CommandOptsType{})
+	live := fillScratchDir(t, sp)
+	stale := filepath.Join(GetScratchRootDir(), "stale-session")
+	if err := os.MkdirAll(filepath.Join(stale, "sub"), 0700); err != nil {
+		t.Fatalf("error creating stale dir: %v", err)
+	}
+	// an adopted orphan's
+	adopted := filepath.Join(GetScratchRootDir(), "adopted-session")
+	if err := os.MkdirAll(adopted, 0700); err != nil {
+		t.Fatalf("error creating adopted dir: %v", err)
+	}
+	if err := SweepScratchDirs([]string{"adopted-session"}); err != nil {
+		t.Fatalf("error sweeping: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale scratch dir should be removed (err %v)", err)
+	}
+	if _, err := os.Stat(live); err != nil {
+		t.Errorf("the scratch dir of a running shell should be kept: %v", err)
+	}
+	if _, err := os.Stat(adopted); err != nil {
+		t.Errorf("the scratch dir of an adopted shell should be kept: %v", err)
+	}
+}
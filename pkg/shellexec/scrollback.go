@@ -0,0 +1,92 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"sync"
+	"unicode/utf8"
+)
+
+const DefaultScrollbackSize = 1024 * 1024
+
+// ringBuffer retains the last Size bytes of the output stream.  Offsets are
+// stream offsets (total bytes written before a given byte).
+type ringBuffer struct {
+	Lock     *sync.Mutex
+	Buf      []byte
+	Size     int
+	WritePos int   // physical position of the next write
+	Total    int64 // total bytes ever written (stream offset of the next byte)
+}
+
+func makeRingBuffer(size int) *ringBuffer {
+	if size <= 0 {
+		size = DefaultScrollbackSize
+	}
+	return &ringBuffer{Lock: &sync.Mutex{}, Buf: make([]byte, size), Size: size}
+}
+
+func (rb *ringBuffer) Write(data []byte) (int, error) {
+	rb.Lock.Lock()
+	defer rb.Lock.Unlock()
+	n := len(data)
+	rb.Total += int64(n)
+	if len(data) > rb.Size {
+		data = data[len(data)-rb.Size:]
+	}
+	copied := copy(rb.Buf[rb.WritePos:], data)
+	if copied < len(data) {
+		copy(rb.Buf, data[copied:])
+	}
+	rb.WritePos = (rb.WritePos + len(data)) % rb.Size
+	return n, nil
+}
+
+// must hold Lock
+func (rb *ringBuffer) dataLen() int {
+	if rb.Total < int64(rb.Size) {
+		return int(rb.Total)
+	}
+	return rb.Size
+}
+
+// snapshot returns a linear copy of the retained bytes and the stream offset of the first byte
+func (rb *ringBuffer) snapshot() ([]byte, int64) {
+	rb.Lock.Lock()
+	defer rb.Lock.Unlock()
+	dataLen := rb.dataLen()
+	rtn := make([]byte, dataLen)
+	startPos := (rb.WritePos - dataLen + rb.Size) % rb.Size
+	copied := copy(rtn, rb.Buf[startPos:min(startPos+dataLen, rb.Size)])
+	copy(rtn[copied:], rb.Buf[:dataLen-copied])
+	return rtn, rb.Total - int64(dataLen)
+}
+
+// snapshotTo is snapshot cut off at the stream offset end (the buffer may already
+// be further along), keeping at most the last maxBytes bytes (all of them if zero)
+func (rb *ringBuffer) snapshotTo(end int64, maxBytes int) ([]byte, int64) {
+	data, offset := rb.snapshot()
+	if end < offset {
+		return nil, end
+	}
+	if end < offset+int64(len(data)) {
+		data = data[:end-offset]
+	}
+	if maxBytes > 0 && len(data) > maxBytes {
+		offset += int64(len(data) - maxBytes)
+		data = append([]byte(nil), data[len(data)-maxBytes:]...)
+	}
+	return data, offset
+}
+
+// replayTo is snapshotTo for replaying to a new consumer, a partial utf-8 character at the start
+// (the ring cut it) is dropped
+func (rb *ringBuffer) replayTo(end int64, maxBytes int) ([]byte, int64) {
+	data, offset := rb.snapshotTo(end, maxBytes)
+	skip := 0
+	for skip < len(data) && skip < utf8.UTFMax-1 && !utf8.RuneStart(data[skip]) {
+		skip++
+	}
+	return data[skip:], offset + int64(skip)
+}
@@ -0,0 +1,115 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+const (
+	DefaultSearchMaxResults   = 100
+	DefaultSearchContextBytes = 80
+)
+
+type SearchOpts struct {
+	Regex           bool `json:"regex,omitempty"` // default is a literal match
+	CaseInsensitive bool `json:"caseinsensitive,omitempty"`
+	MaxResults      int  `json:"maxresults,omitempty"`
+	ContextBytes    int  `json:"contextbytes,omitempty"` // bytes of context on each side of a match (-1 for none)
+	StripAnsi       bool `json:"stripansi,omitempty"`    // strip escape sequences from the context strings
+}
+
+type SearchMatch struct {
+	Offset        int64  `json:"offset"` // stream offset of the match
+	Length        int    `json:"length"`
+	Match         string `json:"match"`
+	ContextBefore string `json:"contextbefore,omitempty"`
+	ContextAfter  string `json:"contextafter,omitempty"`
+}
+
+// stripAnsi removes escape sequences (and BEL) from data, keeping only the text
+func stripAnsi(data []byte) []byte {
+	var buf bytes.Buffer
+	parser := makeSeqParser()
+	collectFn := func(tok seqToken) {
+		if tok.Type == tokType_Text {
+			buf.Write(tok.Raw)
+		}
+	}
+	parser.Feed(data, collectFn)
+	parser.Flush(collectFn)
+	return buf.Bytes()
+}
+
+func compileSearchQuery(query string, opts SearchOpts) (*regexp.Regexp, error) {
+	if query == "" {
+		return nil, fmt.Errorf("empty search query")
+	}
+	pattern := query
+	if !opts.Regex {
+		pattern = regexp.QuoteMeta(query)
+	}
+	if opts.CaseInsensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search regex: %w", err)
+	}
+	return re, nil
+}
+
+func searchBuffer(data []byte, startOffset int64, re *regexp.Regexp, opts SearchOpts) []SearchMatch {
+	maxResults := opts.MaxResults
+	if maxResults <= 0 {
+		maxResults = DefaultSearchMaxResults
+	}
+	contextBytes := opts.ContextBytes
+	if contextBytes == 0 {
+		contextBytes = DefaultSearchContextBytes
+	}
+	var rtn []SearchMatch
+	// (not limited to maxResults, that would count the empty matches we skip)
+	for _, loc := range re.FindAllIndex(data, -1) {
+		if len(rtn) >= maxResults {
+			break
+		}
+		if loc[1] == loc[0] {
+			// skip empty matches (e.g. regex "x*")
+			continue
+		}
+		match := SearchMatch{
+			Offset: startOffset + int64(loc[0]),
+			Length: loc[1] - loc[0],
+			Match:  string(data[loc[0]:loc[1]]),
+		}
+		if contextBytes > 0 {
+			before := data[max(0, loc[0]-contextBytes):loc[0]]
+			after := data[loc[1]:min(len(data), loc[1]+contextBytes)]
+			if opts.StripAnsi {
+				before = stripAnsi(before)
+				after = stripAnsi(after)
+			}
+			match.ContextBefore = string(before)
+			match.ContextAfter = string(after)
+		}
+		rtn = append(rtn, match)
+	}
+	return rtn
+}
+
+// SearchScrollback searches the retained scrollback for query.  The search runs
+// against a snapshot of the scrollback so it never blocks the output path.
+// Matches are against the raw output stream, so text interrupted by escape
+// sequences (e.g. color changes) will not match a literal query.
+func (sp *ShellProc) SearchScrollback(query string, opts SearchOpts) ([]SearchMatch, error) {
+	re, err := compileSearchQuery(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	data, startOffset := sp.scrollback.snapshot()
+	return searchBuffer(data, startOffset, re, opts), nil
+}
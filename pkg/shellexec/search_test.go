@@ -0,0 +1,83 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"strings"
+	"testing"
+)
+
+func searchRing(t *testing.T, rb *ringBuffer, query string, opts SearchOpts) []SearchMatch {
+	t.Helper()
+	re, err := compileSearchQuery(query, opts)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	data, startOffset := rb.snapshot()
+	return searchBuffer(data, startOffset, re, opts)
+}
+
+func TestRingBufferSnapshot(t *testing.T) {
+	rb := makeRingBuffer(8)
+	rb.Write([]byte("abc"))
+	data, offset := rb.snapshot()
+	if string(data) != "abc" || offset != 0 {
+		t.Fatalf("bad snapshot: %q %d", data, offset)
+	}
+	rb.Write([]byte("defghij"))
+	data, offset = rb.snapshot()
+	if string(data) != "cdefghij" || offset != 2 {
+		t.Fatalf("bad wrapped snapshot: %q %d", data, offset)
+	}
+	rb.Write([]byte("0123456789xyz"))
+	data, offset = rb.snapshot()
+	if string(data) != "56789xyz" || offset != 15 {
+		t.Fatalf("bad snapshot after large write: %q %d", data, offset)
+	}
+}
+
+func TestSearchAcrossWrapPoint(t *testing.T) {
+	rb := makeRingBuffer(64)
+	rb.Write([]byte(strings.Repeat("-", 100)))
+	// physical write position is now 36, so this match straddles the end of the buffer
+	rb.Write([]byte(strings.Repeat(".", 20) + "needle in a haystack"))
+	matches := searchRing(t, rb, "needle in", SearchOpts{ContextBytes: 4})
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 match, got %#v", matches)
+	}
+	match := matches[0]
+	if match.Offset != 120 || match.Length != 9 || match.ContextBefore != "...." || match.ContextAfter != " a h" {
+		t.Fatalf("bad match: %#v", match)
+	}
+}
+
+func TestSearchModes(t *testing.T) {
+	rb := makeRingBuffer(1024)
+	rb.Write([]byte("Error: one\r\n\x1b[31merror\x1b[0m: two\r\nERROR three\r\n"))
+	if got := len(searchRing(t, rb, "error", SearchOpts{})); got != 1 {
+		t.Errorf("literal case-sensitive search: got %d matches", got)
+	}
+	if got := len(searchRing(t, rb, "error", SearchOpts{CaseInsensitive: true})); got != 3 {
+		t.Errorf("case-insensitive search: got %d matches", got)
+	}
+	if got := len(searchRing(t, rb, "error", SearchOpts{CaseInsensitive: true, MaxResults: 2})); got != 2 {
+		t.Errorf("max results: got %d matches", got)
+	}
+	// context is measured in raw bytes, so the stripped context is shorter
+	matches := searchRing(t, rb, `t[a-z]o`, SearchOpts{Regex: true, StripAnsi: true, ContextBytes: 20})
+	if len(matches) != 1 || matches[0].Match != "two" || !strings.HasSuffix(matches[0].ContextBefore, "\r\nerror: ") {
+		t.Errorf("regex search with stripped context: %#v", matches)
+	}
+	// a regex that also matches empty, the empty matches don't count against MaxResults
+	matches = searchRing(t, rb, `o*`, SearchOpts{Regex: true, MaxResults: 3})
+	if len(matches) != 3 || matches[0].Match != "o" || matches[2].Match != "o" {
+		t.Errorf("regex search with empty matches: %#v", matches)
+	}
+	if _, err := compileSearchQuery("(", SearchOpts{Regex: true}); err == nil {
+		t.Errorf("expected an error for an invalid regex")
+	}
+	if got := len(searchRing(t, rb, "(", SearchOpts{})); got != 0 {
+		t.Errorf("literal search for a regex metachar: got %d matches", got)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+var ErrShellExited = errors.New("shell has exited")
+
+// SendTextOpts controls how SendText writes text to the pty
+type SendTextOpts struct {
+	AppendNewline bool   `json:"appendnewline,omitempty"` // press Enter after the text (run it)
+	Bracket       string `json:"bracket,omitempty"`       // PasteBracket_* (defaults to auto)
+	AllowControl  bool   `json:"allowcontrol,omitempty"`  // allow control characters other than \n and \t
+}
+
+// ControlCharError is returned by SendText for text with a control character in it (see SendTextOpts.AllowControl)
+type ControlCharError struct {
+	Offset int // byte offset in the text
+	Char   rune
+}
+
+func (e *ControlCharError) Error() string {
+	return fmt.Sprintf("text contains control character %U at offset %d", e.Char, e.Offset)
+}
+
+// \r counts as a newline (it is normalized like \r\n), escape sequences, DEL and
+// the C1 controls are rejected along with the rest of C0
+func findControlChar(text string) *ControlCharError {
+	for offset, ch := range text {
+		if ch == '\n' || ch == '\t' || ch == '\r' {
+			continue
+		}
+		if unicode.IsControl(ch) {
+			return &ControlCharError{Offset: offset, Char: ch}
+		}
+	}
+	return nil
+}
+
+// splits text into lines (any line ending), a single trailing line ending is dropped
+// (whether the text is run is up to SendTextOpts.AppendNewline)
+func splitSendText(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	return strings.Split(text, "\n")
+}
+
+// makeSendTextBody is the input for SendText without the paste brackets or the final Enter.  with bracketed
+// paste the lines are one paste (a shell prompt gets a single multi-line edit buffer, and the paste markers
+// are dropped, see makeBracketedInput), without it every line but the last is entered (like WriteLines)
+// since there is no other way to type a newline.
+func makeSendTextBody(lines []string, modes TtyModes, bracketed bool) []byte {
+	var buf bytes.Buffer
+	if bracketed {
+		for idx, line := range lines {
+			if idx > 0 {
+				buf.WriteByte('\r') // same as a terminal sends for newlines inside a paste
+			}
+			buf.WriteString(line)
+		}
+		return makeBracketedInput(buf.Bytes(), false)
+	}
+	buf.Write(makeLinesInput(lines[:len(lines)-1], modes, false))
+	buf.WriteString(lines[len(lines)-1])
+	return buf.Bytes()
+}
+
+// the shell has been waited for, or the other side of the pty has been closed (the shell exited but
+// hasn't been waited for yet)
+func (sp *ShellProc) shellGone() bool {
+	if done, _ := sp.WaitNB(); done {
+		return true
+	}
+	return sp.outputEnded()
+}
+
+// SendText types text into the shell (e.g. a snippet), checking it first.  Control
+// characters are rejected unless opts.AllowControl, and the text only runs if
+// opts.AppendNewline is set.  When the application has bracketed paste on (shells at
+// their prompt do), multi-line text is sent as a single paste so nothing runs until
+// Enter, without it every line but the last is entered as it is typed.  Returns
+// ErrShellExited if the shell is gone.
+func (sp *ShellProc) SendText(text string, opts SendTextOpts) error {
+	if !opts.AllowControl {
+		if err := findControlChar(text); err != nil {
+			return err
+		}
+	}
+	bracketed, err := sp.useBracketedPaste(opts.Bracket)
+	if err != nil {
+		return err
+	}
+	if sp.shellGone() {
+		return ErrShellExited
+	}
+	modes := sp.ttyModesOrDefault()
+	lines := splitSendText(text)
+	if len(lines) == 1 && opts.Bracket != PasteBracket_Always {
+		// nothing to protect in a single line
+		bracketed = false
+	}
+	var body []byte
+	if text != "" {
+		body = makeSendTextBody(lines, modes, bracketed)
+	}
+	progress := &pasteProgress{Lock: &sync.Mutex{}, Total: int64(len(body))}
+	err = sp.writeChunks(context.Background(), body, DefaultPasteChunkSize, sp.pasteChunkDelay(PasteOpts{}), bracketed && len(body) > 0, progress)
+	if err == nil && opts.AppendNewline {
+		_, err = sp.Write([]byte{modes.LineTerminator()})
+	}
+	if err != nil {
+		if sp.shellGone() {
+			return ErrShellExited
+		}
+		return err
+	}
+	return nil
+}
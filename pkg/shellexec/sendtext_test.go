@@ -0,0 +1,97 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitBracketedPaste(t *testing.T, sp *ShellProc) {
+	t.Helper()
+	deadline := time.Now().Add(testWaitTimeout)
+	for time.Now().Before(deadline) {
+		if sp.BracketedPasteEnabled() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Skip("the shell did not enable bracketed paste (readline too old?)")
+}
+
+func TestSendTextMultiLinePrompt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no ptys on windows")
+	}
+	sp := startTestShellProc(t, "", CommandOptsType{})
+	oc := collectOutput(sp)
+	waitBracketedPaste(t, sp)
+	if err := sp.SendText("echo fi''rst\necho sec''ond\n", SendTextOpts{}); err != nil {
+		t.Fatalf("SendText error: %v", err)
+	}
+	oc.waitFor(t, "echo sec''ond")
+	// it is one edit buffer at the prompt, nothing has run yet
+	time.Sleep(200 * time.Millisecond)
+	if strings.Contains(oc.String(), "first\r\n") {
+		t.Fatalf("the snippet ran before Enter, output %q", oc.String())
+	}
+	if err := sp.SendText("", SendTextOpts{AppendNewline: true}); err != nil {
+		t.Fatalf("SendText error: %v", err)
+	}
+	oc.waitFor(t, "first\r\n")
+	oc.waitFor(t, "second\r\n")
+}
+
+func TestSendTextControlChars(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no ptys on windows")
+	}
+	sp := startTestShellProc(t, `echo ready; read a; echo "got:[$a]"`, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready\r\n")
+	for _, text := range []string{"rm -rf x\x03", "\x1b[201~", "tab\there\x7f", "c1\u0085"} {
+		var ccErr *ControlCharError
+		if err := sp.SendText(text, SendTextOpts{AppendNewline: true}); !errors.As(err, &ccErr) {
+			t.Errorf("expected %q to be rejected, got %v", text, err)
+		}
+	}
+	if err := sp.SendText("a\tb", SendTextOpts{AppendNewline: true}); err != nil {
+		t.Fatalf("SendText error: %v", err)
+	}
+	oc.waitFor(t, "got:[a\tb]")
+}
+
+func TestSendTextAllowControl(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no ptys on windows")
+	}
+	sp := startTestShellProc(t, `echo ready; read a; echo "got:[$a]"`, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready\r\n")
+	// ^U kills the line typed so far
+	if err := sp.SendText("wrong\x15right", SendTextOpts{AppendNewline: true, AllowControl: true}); err != nil {
+		t.Fatalf("SendText error: %v", err)
+	}
+	oc.waitFor(t, "got:[right]")
+}
+
+// with AllowControl the text can hold paste markers, an end marker must not end the paste early
+func TestSendTextBodyPasteMarkers(t *testing.T) {
+	lines := splitSendText("echo safe\n\x1b[201~echo injected\x1b[200~")
+	body := makeSendTextBody(lines, DefaultTtyModes(), true)
+	if expected := "echo safe\recho injected"; string(body) != expected {
+		t.Errorf("expected %q, got %q", expected, body)
+	}
+}
+
+func TestSendTextExited(t *testing.T) {
+	sp := startTestShellProc(t, "true", CommandOptsType{})
+	waitExitStatus(t, sp)
+	if err := sp.SendText("echo hi", SendTextOpts{AppendNewline: true}); !errors.Is(err, ErrShellExited) {
+		t.Errorf("expected ErrShellExited, got %v", err)
+	}
+}
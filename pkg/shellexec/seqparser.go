@@ -0,0 +1,240 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import "bytes"
+
+const (
+	escByte = 0x1b
+	belByte = 0x07
+)
+
+// the bytes that end a text run in the ground state
+const groundControlBytes = "\x1b\x07"
+
+const (
+	tokType_Text   = "text"
+	tokType_Bell   = "bell"
+	tokType_Esc    = "esc" // ESC + optional intermediates + final (ESC 7, ESC ( B, etc.)
+	tokType_CSI    = "csi"
+	tokType_OSC    = "osc"
+	tokType_String = "string" // DCS, SOS, PM, APC
+)
+
+const (
+	parseState_Ground    = "ground"
+	parseState_Esc       = "esc"
+	parseState_CSI       = "csi"
+	parseState_OSC       = "osc"
+	parseState_OSCEsc    = "oscesc"
+	parseState_String    = "string"
+	parseState_StringEsc = "stringesc"
+)
+
+const (
+	MaxCSISeqLen    = 256
+	MaxStringSeqLen = 64 * 1024 // OSC and DCS/SOS/PM/APC payloads
+)
+
+// seqToken is a single token from the output stream.  Raw always holds the exact
+// bytes that were consumed, so concatenating the Raw bytes of every token
+// reproduces the input stream exactly.  Raw and Payload are only valid for the
+// duration of the callback.
+type seqToken struct {
+	Type    string
+	Raw     []byte
+	Payload []byte // OSC/String: bytes between introducer and terminator, CSI: params + final
+	Intro   byte   // the byte after ESC for Esc/CSI/OSC/String tokens
+	// Overflow is set on every chunk of a sequence that exceeded its max length.
+	// the sequence is passed through in pieces and its payload is not interpreted.
+	Overflow bool
+	// Unterminated is set when a sequence was cut off (by a new ESC or a flush)
+	Unterminated bool
+}
+
+// seqParser is an incremental tokenizer for terminal output.  Text is emitted
+// as soon as it is seen, escape sequences are held until they are complete (or
+// hit their max length).
+type seqParser struct {
+	State    string
+	SeqBuf   []byte
+	Intro    byte
+	Overflow bool
+}
+
+func makeSeqParser() *seqParser {
+	return &seqParser{State: parseState_Ground}
+}
+
+func (p *seqParser) emitSeq(tokType string, payloadStart int, payloadEnd int, unterminated bool, fn func(seqToken)) {
+	tok := seqToken{
+		Type:         tokType,
+		Raw:          p.SeqBuf,
+		Intro:        p.Intro,
+		Overflow:     p.Overflow,
+		Unterminated: unterminated,
+	}
+	if !p.Overflow && payloadStart <= payloadEnd && payloadEnd <= len(p.SeqBuf) {
+		tok.Payload = p.SeqBuf[payloadStart:payloadEnd]
+	}
+	fn(tok)
+	p.SeqBuf = p.SeqBuf[:0]
+	p.State = parseState_Ground
+	p.Overflow = false
+	p.Intro = 0
+}
+
+// passes through a partial chunk of an over-long sequence, the parser stays in its current state
+func (p *seqParser) emitOverflowChunk(tokType string, fn func(seqToken)) {
+	p.Overflow = true
+	fn(seqToken{Type: tokType, Raw: p.SeqBuf, Intro: p.Intro, Overflow: true})
+	p.SeqBuf = p.SeqBuf[:0]
+}
+
+func (p *seqParser) Feed(data []byte, fn func(seqToken)) {
+	textStart := -1
+	flushText := func(end int) {
+		if textStart >= 0 && end > textStart {
+			fn(seqToken{Type: tokType_Text, Raw: data[textStart:end]})
+		}
+		textStart = -1
+	}
+	for idx := 0; idx < len(data); idx++ {
+		ch := data[idx]
+		switch p.State {
+		case parseState_Ground:
+			if ch == escByte {
+				flushText(idx)
+				p.State = parseState_Esc
+				p.SeqBuf = append(p.SeqBuf[:0], ch)
+				continue
+			}
+			if ch == belByte {
+				flushText(idx)
+				fn(seqToken{Type: tokType_Bell, Raw: data[idx : idx+1]})
+				continue
+			}
+			if textStart == -1 {
+				textStart = idx
+			}
+			// fast path for bulk text, skip to the next byte that ends the text run
+			next := bytes.IndexAny(data[idx+1:], groundControlBytes)
+			if next < 0 {
+				idx = len(data) - 1
+			} else {
+				idx += next
+			}
+
+		case parseState_Esc:
+			if ch == escByte {
+				// lone ESC, start over
+				p.emitSeq(tokType_Esc, 1, 1, true, fn)
+				p.State = parseState_Esc
+				p.SeqBuf = append(p.SeqBuf[:0], ch)
+				continue
+			}
+			p.SeqBuf = append(p.SeqBuf, ch)
+			if len(p.SeqBuf) == 2 {
+				p.Intro = ch
+				switch ch {
+				case '[':
+					p.State = parseState_CSI
+					continue
+				case ']':
+					p.State = parseState_OSC
+					continue
+				case 'P', 'X', '^', '_':
+					p.State = parseState_String
+					continue
+				}
+			}
+			if ch >= 0x20 && ch <= 0x2f && len(p.SeqBuf) < MaxCSISeqLen {
+				// intermediate byte, keep going
+				continue
+			}
+			p.emitSeq(tokType_Esc, 1, len(p.SeqBuf), false, fn)
+
+		case parseState_CSI:
+			if ch == escByte {
+				p.emitSeq(tokType_CSI, 2, len(p.SeqBuf), true, fn)
+				p.State = parseState_Esc
+				p.SeqBuf = append(p.SeqBuf[:0], ch)
+				continue
+			}
+			p.SeqBuf = append(p.SeqBuf, ch)
+			if ch >= 0x40 && ch <= 0x7e {
+				p.emitSeq(tokType_CSI, 2, len(p.SeqBuf), false, fn)
+				continue
+			}
+			if len(p.SeqBuf) >= MaxCSISeqLen {
+				p.emitOverflowChunk(tokType_CSI, fn)
+			}
+
+		case parseState_OSC, parseState_String:
+			tokType := tokType_OSC
+			if p.State == parseState_String {
+				tokType = tokType_String
+			}
+			if ch == escByte {
+				p.SeqBuf = append(p.SeqBuf, ch)
+				if p.State == parseState_OSC {
+					p.State = parseState_OSCEsc
+				} else {
+					p.State = parseState_StringEsc
+				}
+				continue
+			}
+			p.SeqBuf = append(p.SeqBuf, ch)
+			if ch == belByte && tokType == tokType_OSC {
+				p.emitSeq(tokType, 2, len(p.SeqBuf)-1, false, fn)
+				continue
+			}
+			if len(p.SeqBuf) >= MaxStringSeqLen {
+				p.emitOverflowChunk(tokType, fn)
+			}
+
+		case parseState_OSCEsc, parseState_StringEsc:
+			tokType := tokType_OSC
+			if p.State == parseState_StringEsc {
+				tokType = tokType_String
+			}
+			if ch == '\\' {
+				p.SeqBuf = append(p.SeqBuf, ch)
+				p.emitSeq(tokType, 2, len(p.SeqBuf)-2, false, fn)
+				continue
+			}
+			// ESC followed by something else aborts the sequence, the ESC starts a new one
+			p.SeqBuf = p.SeqBuf[:len(p.SeqBuf)-1]
+			p.emitSeq(tokType, 2, len(p.SeqBuf), true, fn)
+			p.State = parseState_Esc
+			p.SeqBuf = append(p.SeqBuf[:0], escByte)
+			idx-- // reprocess ch in the esc state
+		}
+	}
+	flushText(len(data))
+}
+
+// Flush emits any partially parsed sequence (marked Unterminated) and resets the parser
+func (p *seqParser) Flush(fn func(seqToken)) {
+	if len(p.SeqBuf) == 0 {
+		p.State = parseState_Ground
+		p.Overflow = false
+		return
+	}
+	tokType := tokType_Esc
+	switch p.State {
+	case parseState_CSI:
+		tokType = tokType_CSI
+	case parseState_OSC, parseState_OSCEsc:
+		tokType = tokType_OSC
+	case parseState_String, parseState_StringEsc:
+		tokType = tokType_String
+	}
+	p.emitSeq(tokType, 2, len(p.SeqBuf), true, fn)
+}
+
+// IsHolding returns true if the parser is in the middle of an escape sequence
+func (p *seqParser) IsHolding() bool {
+	return len(p.SeqBuf) > 0
+}
@@ -0,0 +1,41 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSeqParserTextRuns(t *testing.T) {
+	input := []byte("plain text\x1b[1mbold\x1b[0m ding\a\x1b]0;title\athe end")
+	var types []string
+	var raws []string
+	parser := makeSeqParser()
+	parser.Feed(input, func(tok seqToken) {
+		types = append(types, tok.Type)
+		raws = append(raws, string(tok.Raw))
+	})
+	expected := []string{"plain text", "\x1b[1m", "bold", "\x1b[0m", " ding", "\a", "\x1b]0;title\a", "the end"}
+	if len(raws) != len(expected) {
+		t.Fatalf("expected tokens %q, got %q (%v)", expected, raws, types)
+	}
+	for idx := range expected {
+		if raws[idx] != expected[idx] {
+			t.Errorf("token %d: expected %q, got %q (%s)", idx, expected[idx], raws[idx], types[idx])
+		}
+	}
+	// split at every offset, the stream always comes back byte for byte
+	for split := 0; split <= len(input); split++ {
+		var output bytes.Buffer
+		parser := makeSeqParser()
+		collect := func(tok seqToken) { output.Write(tok.Raw) }
+		parser.Feed(input[:split], collect)
+		parser.Feed(input[split:], collect)
+		parser.Flush(collect)
+		if !bytes.Equal(output.Bytes(), input) {
+			t.Errorf("split at %d: got %q", split, output.Bytes())
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+const WaveSessionIdVarName = "WAVETERM_SESSION_ID"
+
+const MaxSessionIdLen = 128
+
+// session ids name directories (see ScratchDir), so no path separators, no leading dot
+var sessionIdRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateSessionId checks a caller supplied CommandOptsType.SessionID
+func ValidateSessionId(id string) error {
+	if len(id) > MaxSessionIdLen {
+		return fmt.Errorf("session id is too long (%d bytes, max %d)", len(id), MaxSessionIdLen)
+	}
+	if !sessionIdRe.MatchString(id) {
+		return fmt.Errorf("invalid session id %q (letters, digits, '.', '_' and '-' only, starting with a letter or digit)", id)
+	}
+	return nil
+}
+
+// sets cmdOpts.SessionID (minting one if the caller didn't supply it), everything
+// started for the session sees the same id through cmdOpts
+func resolveSessionId(cmdOpts *CommandOptsType) error {
+	if cmdOpts.SessionID == "" {
+		cmdOpts.SessionID = uuid.NewString()
+		return nil
+	}
+	if err := ValidateSessionId(cmdOpts.SessionID); err != nil {
+		return err
+	}
+	if GetBySessionID(cmdOpts.SessionID) != nil {
+		return fmt.Errorf("session id %q is already in use", cmdOpts.SessionID)
+	}
+	return nil
+}
+
+// SessionID returns the shellproc's session id (see CommandOptsType.SessionID), it is
+// the scratch dir name, on every event and log line, and the child's WAVETERM_SESSION_ID
+func (sp *ShellProc) SessionID() string {
+	return sp.sessionId
+}
+
+// GetBySessionID returns the running (not yet waited for) shellproc with id, nil if there is none
+func GetBySessionID(id string) *ShellProc {
+	return shellRegistry.getById(id)
+}
+
+func shellLogf(sessionId string, format string, args ...any) {
+	log.Printf("[shellproc %s] "+format, append([]any{sessionId}, args...)...)
+}
+
+func (sp *ShellProc) logf(format string, args ...any) {
+	shellLogf(sp.sessionId, format, args...)
+}
@@ -0,0 +1,114 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+// collects log output for the rest of the test
+type logCapture struct {
+	Lock *sync.Mutex
+	Buf  *bytes.Buffer
+}
+
+func (lc *logCapture) Write(p []byte) (int, error) {
+	lc.Lock.Lock()
+	defer lc.Lock.Unlock()
+	return lc.Buf.Write(p)
+}
+
+func (lc *logCapture) String() string {
+	lc.Lock.Lock()
+	defer lc.Lock.Unlock()
+	return lc.Buf.String()
+}
+
+func captureLog(t *testing.T) *logCapture {
+	lc := &logCapture{Lock: &sync.Mutex{}, Buf: &bytes.Buffer{}}
+	oldOutput := log.Writer()
+	log.SetOutput(lc)
+	t.Cleanup(func() { log.SetOutput(oldOutput) })
+	return lc
+}
+
+func TestSessionIdSurfaces(t *testing.T) {
+	sp := startTestShellProc(t, `echo "id=[$WAVETERM_SESSION_ID] scratch=[$WAVETERM_SCRATCH_DIR]"; sleep 30`, CommandOptsType{})
+	id := sp.SessionID()
+	if ValidateSessionId(id) != nil {
+		t.Fatalf("minted session id %q is not valid", id)
+	}
+	oc := collectOutput(sp)
+	oc.waitFor(t, "id=["+id+"] scratch=["+scratchDirPath(id)+"]")
+	if filepath.Base(scratchDirPath(id)) != id {
+		t.Errorf("the scratch dir should be named by the session id")
+	}
+	if GetBySessionID(id) != sp {
+		t.Errorf("GetBySessionID did not find the shellproc")
+	}
+	if sess := shellRegistry.snapshot()[sp.localPid()]; sess.SessionId != id {
+		t.Errorf("registry entry has session id %q, expected %q", sess.SessionId, id)
+	}
+	if timings := sp.StartupTimings(); timings.SessionId != id {
+		t.Errorf("startup timings have session id %q", timings.SessionId)
+	}
+	if runtime.GOOS != "windows" {
+		usage, err := sp.Usage()
+		if err != nil {
+			t.Fatalf("usage error: %v", err)
+		}
+		if usage.SessionId != id {
+			t.Errorf("usage has session id %q", usage.SessionId)
+		}
+	}
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	if err := sp.SetTermSize(waveobj.TermSize{Rows: 20, Cols: 70}); err != nil {
+		t.Fatalf("error resizing: %v", err)
+	}
+	if event := waitEventKind(t, eventCh, EventKind_Resize); event.SessionId != id {
+		t.Errorf("event has session id %q", event.SessionId)
+	}
+
+	logs := captureLog(t)
+	sp.closeWithReason("test")
+	exitEvent := waitEventKind(t, eventCh, EventKind_Exit)
+	if exitEvent.SessionId != id {
+		t.Errorf("exit event has session id %q", exitEvent.SessionId)
+	}
+	if !strings.Contains(logs.String(), "[shellproc "+id+"] closing shellproc (test)") {
+		t.Errorf("expected the session id in the log, got %q", logs.String())
+	}
+	if GetBySessionID(id) != nil {
+		t.Errorf("GetBySessionID should not return an exited shellproc")
+	}
+}
+
+func TestSessionIdSupplied(t *testing.T) {
+	sp := startTestShellProc(t, `echo "id=[$WAVETERM_SESSION_ID]"; sleep 30`, CommandOptsType{SessionID: "block-1.run_2"})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "id=[block-1.run_2]")
+	if sp.SessionID() != "block-1.run_2" || GetBySessionID("block-1.run_2") != sp {
+		t.Errorf("the supplied session id was not used")
+	}
+	if _, err := StartShellProc(waveobj.TermSize{Rows: 24, Cols: 80}, "true", CommandOptsType{SessionID: "block-1.run_2"}); err == nil {
+		t.Errorf("expected an error for a session id that is in use")
+	}
+	for _, badId := range []string{"../escape", "a/b", `a\b`, ".hidden", "-flag", "has space", "nul\x00", strings.Repeat("x", MaxSessionIdLen+1)} {
+		if ValidateSessionId(badId) == nil {
+			t.Errorf("session id %q should be rejected", badId)
+		}
+		if _, err := StartShellProc(waveobj.TermSize{Rows: 24, Cols: 80}, "true", CommandOptsType{SessionID: badId}); err == nil {
+			t.Errorf("StartShellProc should reject session id %q", badId)
+		}
+	}
+}
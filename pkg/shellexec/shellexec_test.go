@@ -0,0 +1,180 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/util/utilfn"
+	"github.com/wavetermdev/waveterm/pkg/wavebase"
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+	"golang.org/x/term"
+)
+
+// when set, the test binary runs as a helper program instead of running tests
+const testHelperEnvVar = "SHELLEXEC_TEST_HELPER"
+
+const testWaitTimeout = 5 * time.Second
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(testHelperEnvVar); mode != "" {
+		os.Exit(runTestHelper(mode))
+	}
+	dataDir, err := os.MkdirTemp("", "shellexec-test-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating temp data dir: %v\n", err)
+		os.Exit(1)
+	}
+	wavebase.DataHome_VarCache = dataDir
+	code := m.Run()
+	os.RemoveAll(dataDir)
+	os.Exit(code)
+}
+
+func runTestHelper(mode string) int {
+	switch mode {
+	case "rawmode":
+		// puts the tty in raw mode and reports every byte it receives (until 'q')
+		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			fmt.Printf("error: %v\r\n", err)
+			return 1
+		}
+		defer term.Restore(int(os.Stdin.Fd()), oldState)
+		fmt.Printf("ready\r\n")
+		buf := make([]byte, 256)
+		for {
+			nr, err := os.Stdin.Read(buf)
+			if nr > 0 {
+				fmt.Printf("got:%x\r\n", buf[:nr])
+				if bytes.IndexByte(buf[:nr], 'q') >= 0 {
+					return 0
+				}
+			}
+			if err != nil {
+				return 1
+			}
+		}
+	case "rawtrap":
+		// raw mode (no ISIG) app that handles SIGINT itself, reports input bytes and SIGINTs
+		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			fmt.Printf("error: %v\r\n", err)
+			return 1
+		}
+		defer term.Restore(int(os.Stdin.Fd()), oldState)
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT)
+		go func() {
+			buf := make([]byte, 256)
+			for {
+				nr, err := os.Stdin.Read(buf)
+				if nr > 0 {
+					fmt.Printf("got:%x\r\n", buf[:nr])
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+		fmt.Printf("ready\r\n")
+		<-sigCh
+		fmt.Printf("got-sigint\r\n")
+		return 0
+	case "crash64k":
+		// writes crashOutput and exits right away (no deferred cleanup, no flushing)
+		os.Stdout.WriteString(crashOutput())
+		syscall.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "unknown test helper mode %q\n", mode)
+	return 1
+}
+
+func testHelperCmdStr(t *testing.T) string {
+	exePath, err := os.Executable()
+	if err != nil {
+		t.Fatalf("cannot get test executable: %v", err)
+	}
+	return "exec " + utilfn.ShellQuote(exePath, false, -1)
+}
+
+func requireBinary(t testing.TB, name string) string {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		t.Skipf("%s not available: %v", name, err)
+	}
+	return path
+}
+
+// outputCollector continuously reads all output from a ShellProc
+type outputCollector struct {
+	Lock *sync.Mutex
+	Buf  *bytes.Buffer
+	Done chan struct{}
+}
+
+func collectOutput(sp *ShellProc) *outputCollector {
+	oc := &outputCollector{Lock: &sync.Mutex{}, Buf: &bytes.Buffer{}, Done: make(chan struct{})}
+	go func() {
+		defer close(oc.Done)
+		buf := make([]byte, 4096)
+		for {
+			nr, err := sp.Read(buf)
+			if nr > 0 {
+				oc.Lock.Lock()
+				oc.Buf.Write(buf[:nr])
+				oc.Lock.Unlock()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return oc
+}
+
+func (oc *outputCollector) String() string {
+	oc.Lock.Lock()
+	defer oc.Lock.Unlock()
+	return oc.Buf.String()
+}
+
+func (oc *outputCollector) waitFor(t *testing.T, substr string) {
+	t.Helper()
+	waitUntil(t, fmt.Sprintf("%q", substr), func() bool { return strings.Contains(oc.String(), substr) })
+}
+
+// polls cond until it's true, failing the test after testWaitTimeout
+func waitUntil(t *testing.T, desc string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testWaitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", desc)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func startTestShellProc(t *testing.T, cmdStr string, cmdOpts CommandOptsType) *ShellProc {
+	t.Helper()
+	if cmdOpts.ShellPath == "" {
+		cmdOpts.ShellPath = requireBinary(t, "bash")
+	}
+	sp, err := StartShellProc(waveobj.TermSize{Rows: 24, Cols: 80}, cmdStr, cmdOpts)
+	if err != nil {
+		t.Fatalf("error starting shellproc: %v", err)
+	}
+	t.Cleanup(sp.Close)
+	return sp
+}
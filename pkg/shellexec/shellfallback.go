@@ -0,0 +1,64 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// a CommandOptsType.FallbackShells entry for the user's $SHELL
+const FallbackShell_Env = "$SHELL"
+
+// ShellFallback is published (EventKind_ShellFallback) when the shell to run didn't exist and a
+// fallback shell was started instead
+type ShellFallback struct {
+	Requested string   `json:"requested"` // the configured (or detected) shell
+	ShellPath string   `json:"shellpath"` // the shell that was started
+	Reason    string   `json:"reason"`    // why Requested couldn't be used
+	Skipped   []string `json:"skipped,omitempty"`
+}
+
+func defaultFallbackShells(goos string) []string {
+	if goos == "windows" {
+		return []string{"pwsh.exe", "powershell.exe", "cmd.exe"}
+	}
+	return []string{FallbackShell_Env, "/bin/zsh", "/bin/bash", "/bin/sh"}
+}
+
+// returns the shell to run instead of shellPath if it can't be found (nil if it can, or if no fallback
+// can be found either, then starting it fails as before).  chain is CommandOptsType.FallbackShells
+func resolveShellFallback(shellPath string, chain []string) *ShellFallback {
+	_, err := exec.LookPath(shellPath)
+	if err == nil {
+		return nil
+	}
+	if chain == nil {
+		chain = defaultFallbackShells(runtime.GOOS)
+	}
+	fallback := &ShellFallback{Requested: shellPath, Reason: err.Error()}
+	tried := map[string]bool{shellPath: true}
+	for _, candidate := range chain {
+		if candidate == FallbackShell_Env {
+			candidate = os.Getenv("SHELL")
+		}
+		if candidate == "" || tried[candidate] {
+			continue
+		}
+		tried[candidate] = true
+		if _, err := exec.LookPath(candidate); err != nil {
+			fallback.Skipped = append(fallback.Skipped, candidate)
+			continue
+		}
+		fallback.ShellPath = candidate
+		return fallback
+	}
+	return nil
+}
+
+func (fb ShellFallback) String() string {
+	return fmt.Sprintf("cannot run shell %q (%s), using %q", fb.Requested, fb.Reason, fb.ShellPath)
+}
@@ -0,0 +1,68 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+func TestResolveShellFallback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("posix shell paths")
+	}
+	bashPath := requireBinary(t, "bash")
+	missing := filepath.Join(t.TempDir(), "zsh")
+	if fallback := resolveShellFallback(bashPath, nil); fallback != nil {
+		t.Errorf("expected no fallback for an existing shell, got %#v", fallback)
+	}
+	t.Setenv("SHELL", bashPath)
+	fallback := resolveShellFallback(missing, []string{"/nope/fish", FallbackShell_Env, "/bin/sh"})
+	if fallback == nil || fallback.ShellPath != bashPath || fallback.Requested != missing || fallback.Reason == "" {
+		t.Fatalf("expected $SHELL as the fallback, got %#v", fallback)
+	}
+	if !reflect.DeepEqual(fallback.Skipped, []string{"/nope/fish"}) {
+		t.Errorf("bad skipped shells %v", fallback.Skipped)
+	}
+	// $SHELL is the missing shell itself, it isn't tried again
+	t.Setenv("SHELL", missing)
+	if fallback := resolveShellFallback(missing, []string{FallbackShell_Env, "/bin/sh"}); fallback == nil || fallback.ShellPath != "/bin/sh" || len(fallback.Skipped) != 0 {
+		t.Errorf("expected /bin/sh as the fallback, got %#v", fallback)
+	}
+	if fallback := resolveShellFallback(missing, []string{}); fallback != nil {
+		t.Errorf("an empty chain should not fall back, got %#v", fallback)
+	}
+	if fallback := resolveShellFallback(missing, []string{"/nope/fish"}); fallback != nil {
+		t.Errorf("expected no fallback when none exist, got %#v", fallback)
+	}
+}
+
+func TestStartShellFallback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("posix shell paths")
+	}
+	bashPath := requireBinary(t, "bash")
+	missing := filepath.Join(t.TempDir(), "zsh")
+	sp := startTestShellProc(t, `echo "running=${BASH_VERSION:+bash}"`, CommandOptsType{ShellPath: missing, FallbackShells: []string{bashPath}})
+	// subscribed after the start, the fallback event is still delivered (right after Started)
+	events, unsub := sp.SubscribeEvents(0)
+	defer unsub()
+	if event := <-events; event.Kind != EventKind_Started {
+		t.Fatalf("expected the Started event first, got %#v", event)
+	}
+	event := <-events
+	if event.Kind != EventKind_ShellFallback || event.ShellFallback == nil || event.ShellFallback.ShellPath != bashPath || event.ShellFallback.Requested != missing {
+		t.Fatalf("expected the fallback event, got %#v", event)
+	}
+	oc := collectOutput(sp)
+	oc.waitFor(t, "running=bash")
+
+	if _, err := StartShellProc(waveobj.TermSize{Rows: 24, Cols: 80}, "true", CommandOptsType{ShellPath: missing, FallbackShells: []string{}}); err == nil {
+		t.Errorf("expected an error starting a missing shell without fallbacks")
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+// ShellSupervisor respawns a shell (with StartShellProc) when it exits, following its
+// SupervisorOpts restart policy and backoff.  Unlike CommandOptsType.Supervise every run is
+// a new ShellProc with its own pty (and output stream), so it works for interactive shells
+// and on every platform.  The supervisor owns the wait loop of its shellprocs, its own
+// events are EventKind_Restart before every respawn and EventKind_Exit (the last run's
+// status) once supervision has stopped.
+type ShellSupervisor struct {
+	Lock     *sync.Mutex
+	Clock    clock
+	Opts     SupervisorOpts
+	TermSize waveobj.TermSize // for the next run, see SetTermSize
+	CmdStr   string
+	CmdOpts  CommandOptsType
+	OnStart  func(*ShellProc) // called for every run before it can exit (e.g. to attach the output)
+	Proc     *ShellProc       // the current (or last) run
+	RunStart time.Time
+	restartState
+	Stopped bool
+	WakeCh  chan struct{}
+	ExitCh  chan WaitResult // the current run's OnExit hook
+	DoneCh  chan struct{}
+	events  *eventHub
+}
+
+// StartShellSupervisor starts cmdStr (like StartShellProc) and supervises it, the
+// caller's opts are left alone (zero values use the defaults).  An error starting the
+// first run is returned, a restart that fails to start counts as a failed run.  onStart
+// may be nil.
+func StartShellSupervisor(termSize waveobj.TermSize, cmdStr string, cmdOpts CommandOptsType, opts SupervisorOpts, onStart func(*ShellProc)) (*ShellSupervisor, error) {
+	opts, err := resolveRestartOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+	// every run uses this session id (the previous run is out of the registry by the time the next starts)
+	if err := resolveSessionId(&cmdOpts); err != nil {
+		return nil, err
+	}
+	ss := &ShellSupervisor{
+		Lock:     &sync.Mutex{},
+		Clock:    shellClock,
+		Opts:     opts,
+		TermSize: termSize,
+		CmdStr:   cmdStr,
+		CmdOpts:  cmdOpts,
+		OnStart:  onStart,
+		WakeCh:   make(chan struct{}, 1),
+		ExitCh:   make(chan WaitResult, 1),
+		DoneCh:   make(chan struct{}),
+		events:   makeEventHub(),
+	}
+	ss.events.Clock = ss.Clock
+	ss.events.SessionId = cmdOpts.SessionID
+	if err := ss.startRun(); err != nil {
+		return nil, err
+	}
+	go func() {
+		defer panichandler.PanicHandler("ShellSupervisor:runLoop")
+		ss.runLoop()
+	}()
+	return ss, nil
+}
+
+func (ss *ShellSupervisor) startRun() error {
+	ss.Lock.Lock()
+	termSize := ss.TermSize
+	ss.Lock.Unlock()
+	sp, err := StartShellProc(termSize, ss.CmdStr, ss.CmdOpts)
+	if err != nil {
+		return err
+	}
+	if ss.OnStart != nil {
+		ss.OnStart(sp)
+	}
+	sp.OnExit(func(result WaitResult) {
+		ss.ExitCh <- result
+	})
+	ss.Lock.Lock()
+	ss.Proc = sp
+	ss.RunStart = ss.Clock.Now()
+	stopped := ss.Stopped
+	ss.Lock.Unlock()
+	go func() {
+		defer panichandler.PanicHandler("ShellSupervisor:wait")
+		sp.WaitProcess()
+	}()
+	if stopped {
+		// stopped while starting, the new run didn't see the close
+		sp.Close()
+	}
+	return nil
+}
+
+func (ss *ShellSupervisor) runExited(status ExitStatus) restartDecision {
+	ss.Lock.Lock()
+	defer ss.Lock.Unlock()
+	if ss.Stopped {
+		return restartDecision{}
+	}
+	return ss.restartState.next(ss.Opts, status, ss.RunStart, ss.Clock.Now())
+}
+
+// waits out the backoff delay (cut short by Stop), returns false if supervision was stopped
+func (ss *ShellSupervisor) sleep(delay time.Duration) bool {
+	if delay > 0 {
+		timerCh, stopFn := ss.Clock.NewTimer(delay)
+		defer stopFn()
+		select {
+		case <-timerCh:
+		case <-ss.WakeCh:
+		}
+	}
+	ss.Lock.Lock()
+	defer ss.Lock.Unlock()
+	return !ss.Stopped
+}
+
+func (ss *ShellSupervisor) runLoop() {
+	status := (<-ss.ExitCh).ExitStatus
+	for {
+		decision := ss.runExited(status)
+		if decision.GaveUp {
+			shellLogf(ss.CmdOpts.SessionID, "supervised shell %s, giving up after %d restarts in %v\n", describeExit(status), ss.Opts.MaxRestarts, ss.Opts.RestartWindow)
+		}
+		if !decision.Restart {
+			ss.finish(status)
+			return
+		}
+		ss.events.publish(ShellEvent{Kind: EventKind_Restart, Restart: &decision.Info})
+		if !ss.sleep(time.Duration(decision.Info.DelayMs) * time.Millisecond) {
+			ss.finish(status)
+			return
+		}
+		if err := ss.startRun(); err != nil {
+			shellLogf(ss.CmdOpts.SessionID, "cannot restart supervised shell: %v\n", err)
+			ss.Lock.Lock()
+			ss.RunStart = ss.Clock.Now()
+			ss.Lock.Unlock()
+			status = ExitStatus{ExitCode: -1}
+			continue
+		}
+		status = (<-ss.ExitCh).ExitStatus
+	}
+}
+
+func (ss *ShellSupervisor) finish(status ExitStatus) {
+	ss.Lock.Lock()
+	ss.Stopped = true
+	ss.Lock.Unlock()
+	ss.events.publish(ShellEvent{Kind: EventKind_Exit, ExitStatus: &status})
+	close(ss.DoneCh)
+}
+
+// Current returns the current run's shellproc (the last run's once supervision has stopped)
+func (ss *ShellSupervisor) Current() *ShellProc {
+	ss.Lock.Lock()
+	defer ss.Lock.Unlock()
+	return ss.Proc
+}
+
+// Restarts returns how many times the shell has been respawned
+func (ss *ShellSupervisor) Restarts() int {
+	ss.Lock.Lock()
+	defer ss.Lock.Unlock()
+	return ss.restartState.Restarts
+}
+
+// SetTermSize resizes the current run's pty, later runs are started with termSize too
+func (ss *ShellSupervisor) SetTermSize(termSize waveobj.TermSize) error {
+	ss.Lock.Lock()
+	ss.TermSize = termSize
+	sp := ss.Proc
+	ss.Lock.Unlock()
+	select {
+	case <-sp.DoneCh:
+		return nil
+	default:
+	}
+	if err := sp.SetTermSize(termSize); err != nil {
+		return fmt.Errorf("error resizing supervised shell: %w", err)
+	}
+	return nil
+}
+
+// Stop ends supervision, the current run is closed (see ShellProc.Close) and not respawned.
+// Wait for Done to know it has exited
+func (ss *ShellSupervisor) Stop() {
+	ss.Lock.Lock()
+	ss.Stopped = true
+	sp := ss.Proc
+	ss.Lock.Unlock()
+	select {
+	case ss.WakeCh <- struct{}{}:
+	default:
+	}
+	sp.Close()
+}
+
+// Done is closed once supervision has stopped and the last run has exited
+func (ss *ShellSupervisor) Done() <-chan struct{} {
+	return ss.DoneCh
+}
+
+// SubscribeEvents subscribes to the supervisor's events (see ShellProc.SubscribeEvents), the
+// channel is closed after the Exit event.  A run's own events are on its ShellProc
+func (ss *ShellSupervisor) SubscribeEvents(bufSize int) (<-chan ShellEvent, func()) {
+	return ss.events.subscribe(bufSize)
+}
@@ -0,0 +1,83 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+func startTestShellSupervisor(t *testing.T, cmdStr string, opts SupervisorOpts, onStart func(*ShellProc)) *ShellSupervisor {
+	t.Helper()
+	ss, err := StartShellSupervisor(waveobj.TermSize{Rows: 24, Cols: 80}, cmdStr, CommandOptsType{ShellPath: requireBinary(t, "bash")}, opts, onStart)
+	if err != nil {
+		t.Fatalf("error starting shell supervisor: %v", err)
+	}
+	t.Cleanup(ss.Stop)
+	return ss
+}
+
+func TestShellSupervisor(t *testing.T) {
+	var procs []*ShellProc
+	ss := startTestShellSupervisor(t, `exit 3`, SupervisorOpts{Backoff: 20 * time.Millisecond, MaxRestarts: 2, RestartWindow: time.Minute}, func(sp *ShellProc) {
+		procs = append(procs, sp)
+	})
+	eventCh, unsubFn := ss.SubscribeEvents(0)
+	defer unsubFn()
+	for attempt, delay := range []int64{20, 40} {
+		info := waitRestart(t, eventCh)
+		if info.Attempt != attempt+1 || info.Restarts != attempt+1 || info.DelayMs != delay || info.LastExit.ExitCode != 3 {
+			t.Errorf("unexpected restart %+v", info)
+		}
+	}
+	exitEvent := waitEventKind(t, eventCh, EventKind_Exit)
+	if exitEvent.ExitStatus.ExitCode != 3 {
+		t.Errorf("expected the last run's exit code, got %+v", exitEvent.ExitStatus)
+	}
+	select {
+	case <-ss.Done():
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("supervision did not stop")
+	}
+	if len(procs) != 3 || ss.Restarts() != 2 || ss.Current() != procs[2] {
+		t.Fatalf("expected 3 runs and 2 restarts, got %d runs and %d restarts", len(procs), ss.Restarts())
+	}
+	if procs[0] == procs[1] || procs[0].SessionID() != procs[2].SessionID() {
+		t.Errorf("every run should be a new shellproc with the supervisor's session id")
+	}
+
+	// exit 0 isn't restarted by the default (onfailure) policy
+	ss = startTestShellSupervisor(t, `exit 0`, SupervisorOpts{Backoff: 10 * time.Millisecond}, nil)
+	eventCh, unsubFn = ss.SubscribeEvents(0)
+	defer unsubFn()
+	if event := waitEventKind(t, eventCh, EventKind_Exit); event.ExitStatus.ExitCode != 0 || ss.Restarts() != 0 {
+		t.Errorf("unexpected exit %+v after %d restarts", event.ExitStatus, ss.Restarts())
+	}
+
+	if _, err := StartShellSupervisor(waveobj.TermSize{Rows: 24, Cols: 80}, `exit 0`, CommandOptsType{}, SupervisorOpts{RestartPolicy: "sometimes"}, nil); err == nil {
+		t.Errorf("expected an error for an invalid restart policy")
+	}
+}
+
+func TestShellSupervisorStop(t *testing.T) {
+	ss := startTestShellSupervisor(t, `sleep 60`, SupervisorOpts{RestartPolicy: RestartPolicy_Always, Backoff: 10 * time.Millisecond}, nil)
+	eventCh, unsubFn := ss.SubscribeEvents(0)
+	defer unsubFn()
+	sp := ss.Current()
+	if err := ss.SetTermSize(waveobj.TermSize{Rows: 30, Cols: 100}); err != nil {
+		t.Fatalf("error resizing: %v", err)
+	}
+	ss.Stop()
+	waitEventKind(t, eventCh, EventKind_Exit)
+	select {
+	case <-ss.Done():
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("supervision did not stop")
+	}
+	if ss.Restarts() != 0 || ss.Current() != sp {
+		t.Errorf("a stopped shell should not be restarted (%d restarts)", ss.Restarts())
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"golang.org/x/crypto/ssh"
+)
+
+// what Close sends before SIGKILL: SIGHUP is what a shell gets when its terminal goes
+// away (bash and zsh save their history, and pass it on to their jobs), SIGTERM is for
+// whatever ignores it (interactive shells ignore SIGTERM though)
+var DefaultShutdownSignals = []string{"SIGHUP", "SIGTERM"}
+
+// implemented by the ConnInterfaces that can be sent a signal (local, supervised and ssh
+// shells), the others get KillGraceful
+type signaler interface {
+	sendSignal(sig syscall.Signal) error
+}
+
+// sets the shutdown defaults, ShutdownSignals are validated (a non-nil empty list kills right away)
+func resolveShutdownOpts(cmdOpts *CommandOptsType) error {
+	if cmdOpts.ShutdownSignals == nil {
+		cmdOpts.ShutdownSignals = DefaultShutdownSignals
+	}
+	if cmdOpts.ShutdownTimeout == 0 {
+		cmdOpts.ShutdownTimeout = DefaultGracefulKillWait
+	}
+	if cmdOpts.ShutdownTimeout < 0 {
+		return fmt.Errorf("invalid shutdown timeout %v", cmdOpts.ShutdownTimeout)
+	}
+	for _, name := range cmdOpts.ShutdownSignals {
+		if sig, ok := signalByName(name); !ok || sig == syscall.SIGKILL {
+			return fmt.Errorf("invalid shutdown signal %q", name)
+		}
+	}
+	return nil
+}
+
+// cmdOpts must have been resolved (see resolveShutdownOpts)
+func parseShutdownSignals(names []string) []syscall.Signal {
+	rtn := make([]syscall.Signal, 0, len(names))
+	for _, name := range names {
+		if sig, ok := signalByName(name); ok {
+			rtn = append(rtn, sig)
+		}
+	}
+	return rtn
+}
+
+func (cw CmdWrap) sendSignal(sig syscall.Signal) error {
+	if cw.Cmd.Process == nil {
+		return fmt.Errorf("process not started")
+	}
+	return cw.Cmd.Process.Signal(sig)
+}
+
+// the server has to allow it (OpenSSH does since 8.1)
+func (sw SessionWrap) sendSignal(sig syscall.Signal) error {
+	return sw.Session.Signal(ssh.Signal(strings.TrimPrefix(signalName(sig), "SIG")))
+}
+
+// also stops supervision (like Kill)
+func (sc *supervisedCmd) sendSignal(sig syscall.Signal) error {
+	return sc.stop().sendSignal(sig)
+}
+
+// CloseGraceful is Close with its own timeout: the shutdown signals (CommandOptsType.ShutdownSignals)
+// are sent one at a time, each one waiting its share of timeout for the shell to exit, then the
+// shell is killed.  Returns right away, use Wait (or Done) for the exit.
+func (sp *ShellProc) CloseGraceful(timeout time.Duration) {
+	sp.abortRelease()
+	sp.Resume()
+	if sig, ok := sp.Cmd.(signaler); ok {
+		go func() {
+			defer panichandler.PanicHandler("ShellProc.CloseGraceful")
+			sp.runShutdown(sig, timeout)
+		}()
+	} else {
+		sp.Cmd.KillGraceful(timeout)
+	}
+	sp.waitAndClosePty()
+}
+
+func (sp *ShellProc) runShutdown(sig signaler, timeout time.Duration) {
+	if !sp.killTree {
+		sp.shutdownShell(sig, timeout)
+		return
+	}
+	pid := sp.localPid()
+	tree := listProcTree(pid)
+	sp.shutdownShell(sig, timeout)
+	if killed := killProcTree(pid, tree); killed > 0 {
+		sp.logf("killed %d processes left in the shell's process tree\n", killed)
+	}
+}
+
+func (sp *ShellProc) shutdownShell(sig signaler, timeout time.Duration) {
+	var stepWait time.Duration
+	if len(sp.shutdownSignals) > 0 {
+		stepWait = timeout / time.Duration(len(sp.shutdownSignals))
+	}
+	for _, shutdownSig := range sp.shutdownSignals {
+		if err := sig.sendSignal(shutdownSig); err != nil {
+			// gone already, or can't be signaled (e.g. on windows), on to the next one
+			continue
+		}
+		timerCh, stopFn := sp.clock.NewTimer(stepWait)
+		select {
+		case <-sp.DoneCh:
+			stopFn()
+			return
+		case <-timerCh:
+		}
+		stopFn()
+	}
+	select {
+	case <-sp.DoneCh:
+		return
+	default:
+	}
+	if len(sp.shutdownSignals) > 0 {
+		sp.logf("shell still running %v after %s, killing it\n", timeout, strings.Join(signalNamesOf(sp.shutdownSignals), ", "))
+	}
+	if sp.killTree && terminateShellJob(sp.Cmd) {
+		return
+	}
+	sp.Cmd.Kill()
+}
+
+func signalNamesOf(sigs []syscall.Signal) []string {
+	rtn := make([]string, len(sigs))
+	for idx, sig := range sigs {
+		rtn[idx] = signalName(sig)
+	}
+	return rtn
+}
@@ -0,0 +1,95 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+func waitClosed(t *testing.T, sp *ShellProc) ExitStatus {
+	t.Helper()
+	waitDone(t, sp)
+	status, _ := sp.ExitStatus()
+	return status
+}
+
+const shutdownTrapsCmd = `trap 'echo got-hup' HUP; trap 'echo got-term' TERM; echo ready; while :; do sleep 0.02; done`
+
+func TestCloseSendsHup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no signals on windows")
+	}
+	sp := startTestShellProc(t, `trap 'echo got-hup; exit 0' HUP; echo ready; while :; do sleep 0.02; done`, CommandOptsType{ShutdownTimeout: 5 * time.Second})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready")
+	sp.Close()
+	status := waitClosed(t, sp)
+	if status.ExitCode != 0 || status.Signal != "" {
+		t.Errorf("expected the shell to exit on its own, got %+v", status)
+	}
+	oc.waitFor(t, "got-hup")
+}
+
+func TestCloseGracefulEscalates(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no signals on windows")
+	}
+	sp := startTestShellProc(t, shutdownTrapsCmd, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready")
+	closeTs := time.Now()
+	sp.CloseGraceful(400 * time.Millisecond)
+	status := waitClosed(t, sp)
+	if elapsed := time.Since(closeTs); elapsed < 400*time.Millisecond {
+		t.Errorf("expected each signal to get its share of the timeout, killed after %v", elapsed)
+	}
+	if status.Signal != "SIGKILL" {
+		t.Errorf("expected the shell to be killed, got %+v", status)
+	}
+	<-oc.Done
+	output := oc.String()
+	hupIdx, termIdx := strings.Index(output, "got-hup"), strings.Index(output, "got-term")
+	if hupIdx < 0 || termIdx < hupIdx {
+		t.Errorf("expected SIGHUP then SIGTERM, got %q", output)
+	}
+}
+
+func TestShutdownSignalsOpt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no signals on windows")
+	}
+	sp := startTestShellProc(t, shutdownTrapsCmd, CommandOptsType{ShutdownSignals: []string{"SIGTERM"}})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready")
+	sp.Close()
+	waitClosed(t, sp)
+	<-oc.Done
+	if output := oc.String(); strings.Contains(output, "got-hup") || !strings.Contains(output, "got-term") {
+		t.Errorf("expected only SIGTERM, got %q", output)
+	}
+
+	// an empty list kills right away
+	sp = startTestShellProc(t, shutdownTrapsCmd, CommandOptsType{ShutdownSignals: []string{}, ShutdownTimeout: time.Minute})
+	collectOutput(sp).waitFor(t, "ready")
+	sp.Close()
+	if status := waitClosed(t, sp); status.Signal != "SIGKILL" {
+		t.Errorf("expected the shell to be killed, got %+v", status)
+	}
+
+	termSize := waveobj.TermSize{Rows: 24, Cols: 80}
+	for _, cmdOpts := range []CommandOptsType{
+		{ShutdownSignals: []string{"SIGNOPE"}},
+		{ShutdownSignals: []string{"SIGHUP", "SIGKILL"}},
+		{ShutdownTimeout: -time.Second},
+	} {
+		if _, err := StartShellProc(termSize, "true", cmdOpts); err == nil {
+			t.Errorf("expected an error for %v %v", cmdOpts.ShutdownSignals, cmdOpts.ShutdownTimeout)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// put where the output was cut (after the head, or before the tail)
+const OutputTruncatedMarker = "\r\n[output truncated]\r\n"
+
+// SimpleCmdOpts bounds the output RunSimpleCmdInPtyOpts keeps in memory, the rest is read and discarded
+type SimpleCmdOpts struct {
+	MaxOutputBytes int  `json:"maxOutputBytes,omitempty"` // zero for no limit
+	KeepTail       bool `json:"keepTail,omitempty"`       // keep the last MaxOutputBytes instead of the first
+}
+
+type SimpleCmdResult struct {
+	Output     []byte `json:"output"`              // at most MaxOutputBytes of the output, plus OutputTruncatedMarker if Truncated
+	Truncated  bool   `json:"truncated,omitempty"` // the output was over MaxOutputBytes
+	TotalBytes int64  `json:"totalbytes"`          // what the command wrote
+}
+
+func resolveSimpleCmdOpts(opts SimpleCmdOpts) error {
+	if opts.MaxOutputBytes < 0 {
+		return fmt.Errorf("invalid max output bytes %d", opts.MaxOutputBytes)
+	}
+	return nil
+}
+
+// not thread safe (RunSimpleCmdInPtyOpts has its own lock)
+type simpleOutputBuffer struct {
+	Opts  SimpleCmdOpts
+	Head  bytes.Buffer
+	Tail  *ringBuffer // KeepTail only
+	Total int64
+}
+
+func makeSimpleOutputBuffer(opts SimpleCmdOpts) *simpleOutputBuffer {
+	rtn := &simpleOutputBuffer{Opts: opts}
+	if opts.MaxOutputBytes > 0 && opts.KeepTail {
+		rtn.Tail = makeRingBuffer(opts.MaxOutputBytes)
+	}
+	return rtn
+}
+
+func (sb *simpleOutputBuffer) Write(data []byte) (int, error) {
+	n := len(data)
+	sb.Total += int64(n)
+	if sb.Tail != nil {
+		return sb.Tail.Write(data)
+	}
+	if sb.Opts.MaxOutputBytes > 0 {
+		data = data[:min(n, max(sb.Opts.MaxOutputBytes-sb.Head.Len(), 0))]
+	}
+	sb.Head.Write(data)
+	return n, nil
+}
+
+func (sb *simpleOutputBuffer) result() SimpleCmdResult {
+	rtn := SimpleCmdResult{TotalBytes: sb.Total}
+	rtn.Truncated = sb.Opts.MaxOutputBytes > 0 && sb.Total > int64(sb.Opts.MaxOutputBytes)
+	var output []byte
+	if sb.Tail != nil {
+		output, _ = sb.Tail.snapshot()
+	} else {
+		output = bytes.Clone(sb.Head.Bytes())
+	}
+	switch {
+	case !rtn.Truncated:
+		rtn.Output = output
+	case sb.Tail != nil:
+		rtn.Output = append([]byte(OutputTruncatedMarker), output...)
+	default:
+		rtn.Output = append(output, OutputTruncatedMarker...)
+	}
+	return rtn
+}
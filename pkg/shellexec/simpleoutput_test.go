@@ -0,0 +1,59 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+func TestSimpleOutputBuffer(t *testing.T) {
+	for _, tc := range []struct {
+		Opts     SimpleCmdOpts
+		Expected string
+	}{
+		{SimpleCmdOpts{}, "0123456789"},
+		{SimpleCmdOpts{MaxOutputBytes: 10}, "0123456789"},
+		{SimpleCmdOpts{MaxOutputBytes: 4}, "0123" + OutputTruncatedMarker},
+		{SimpleCmdOpts{MaxOutputBytes: 4, KeepTail: true}, OutputTruncatedMarker + "6789"},
+	} {
+		sb := makeSimpleOutputBuffer(tc.Opts)
+		for _, chunk := range []string{"012", "345678", "9"} {
+			if n, err := sb.Write([]byte(chunk)); n != len(chunk) || err != nil {
+				t.Fatalf("expected the whole chunk to be written, got %d %v", n, err)
+			}
+		}
+		res := sb.result()
+		if string(res.Output) != tc.Expected || res.TotalBytes != 10 {
+			t.Errorf("%+v: unexpected result %q (%d bytes)", tc.Opts, res.Output, res.TotalBytes)
+		}
+		if res.Truncated != strings.Contains(tc.Expected, OutputTruncatedMarker) {
+			t.Errorf("%+v: unexpected Truncated %v", tc.Opts, res.Truncated)
+		}
+	}
+}
+
+func TestRunSimpleCmdInPtyOpts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no seq on windows")
+	}
+	termSize := waveobj.TermSize{Rows: 24, Cols: 80}
+	res, err := RunSimpleCmdInPtyOpts(exec.Command(requireBinary(t, "seq"), "100000"), termSize, SimpleCmdOpts{MaxOutputBytes: 1000, KeepTail: true})
+	if err != nil {
+		t.Fatalf("error running command: %v", err)
+	}
+	if !res.Truncated || res.TotalBytes <= 1000 || len(res.Output) != 1000+len(OutputTruncatedMarker) {
+		t.Errorf("expected the output to be truncated, got %d bytes of %d", len(res.Output), res.TotalBytes)
+	}
+	if !strings.HasSuffix(string(res.Output), "99999\r\n100000\r\n") {
+		t.Errorf("expected the tail of the output, got %q", res.Output)
+	}
+	if _, err := RunSimpleCmdInPtyOpts(exec.Command("true"), termSize, SimpleCmdOpts{MaxOutputBytes: -1}); err == nil {
+		t.Errorf("expected an error for a negative MaxOutputBytes")
+	}
+}
@@ -0,0 +1,515 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+	"github.com/wavetermdev/waveterm/pkg/wavebase"
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+func StartShellProc(termSize waveobj.TermSize, cmdStr string, cmdOpts CommandOptsType) (*ShellProc, error) {
+	return startLocalProc(termSize, cmdStr, nil, cmdOpts)
+}
+
+// StartArgvProc execs argv in a pty without a shell, argv[0] is looked up in PATH (like exec.Command) and
+// nothing is expanded or split, so programmatically built commands need no quoting.  cmdOpts work as for
+// StartShellProc with a command (there is no shell integration), ShellPath and ShellOpts are ignored
+func StartArgvProc(termSize waveobj.TermSize, argv []string, cmdOpts CommandOptsType) (*ShellProc, error) {
+	if len(argv) == 0 || argv[0] == "" {
+		return nil, fmt.Errorf("argv is empty")
+	}
+	return startLocalProc(termSize, "", argv, cmdOpts)
+}
+
+// argv is set for StartArgvProc (cmdStr is then empty)
+func startLocalProc(termSize waveobj.TermSize, cmdStr string, argv []string, cmdOpts CommandOptsType) (*ShellProc, error) {
+	startTime := shellClock.Now()
+	if err := resolveSessionId(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolveSanitizeProfile(&cmdOpts, SanitizeProfile_Trusted); err != nil {
+		return nil, err
+	}
+	if err := resolveShutdownOpts(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolveTermType(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolveSupervisorOpts(&cmdOpts, cmdStr != "" || argv != nil); err != nil {
+		return nil, err
+	}
+	if err := resolveRunAs(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolveNice(cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolveTtyInit(cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolvePacketMode(cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolveWslDistro(cmdOpts); err != nil {
+		return nil, err
+	}
+	containerTool, err := resolveContainer(cmdOpts)
+	if err != nil {
+		return nil, err
+	}
+	kubectlPath, err := resolvePod(cmdOpts)
+	if err != nil {
+		return nil, err
+	}
+	shellutil.InitCustomShellStartupFiles()
+	var ecmd *exec.Cmd
+	var shellOpts []string
+	var wenv *warmEnv // detection and the base env are cached when the warm pool is enabled
+	if useWarmPool(cmdOpts) {
+		wenv = globalWarmPool.getEnv()
+	}
+	// argv isn't run by a shell, so no shell specific flags or env (e.g. history).  neither are wsl.exe,
+	// docker exec and kubectl exec (offHostArgv picks the shell inside the distro, container or pod)
+	family := shellutil.ShellFamily_Unknown
+	var shellPath string
+	var shellFallback *ShellFallback
+	if argv == nil && !runsOffHost(cmdOpts) {
+		shellPath = cmdOpts.ShellPath
+		if shellPath == "" && wenv != nil {
+			shellPath = wenv.DefaultShellPath
+		} else if shellPath == "" {
+			shellPath = shellutil.DetectLocalShellPath()
+		}
+		if shellFallback = resolveShellFallback(shellPath, cmdOpts.FallbackShells); shellFallback != nil {
+			shellLogf(cmdOpts.SessionID, "warning: %s", shellFallback)
+			shellPath = shellFallback.ShellPath
+		}
+		// shellPath is still what we run (argv[0] matters), the resolved family only picks flags and quoting
+		var shellRes shellutil.ShellResolution
+		if wenv != nil {
+			shellRes = wenv.resolveShell(shellPath)
+		} else {
+			shellRes = shellutil.ResolveShell(shellPath)
+		}
+		if shellRes.Warning != "" {
+			shellLogf(cmdOpts.SessionID, "warning: %s", shellRes.Warning)
+		}
+		family = shellRes.Family
+		if family == shellutil.ShellFamily_Unknown {
+			// a shell we can't tell by its name (e.g. a wrapper script), ask it (cached per path)
+			probe := shellutil.ProbeShell(shellPath)
+			if probe.Err != "" {
+				shellLogf(cmdOpts.SessionID, "warning: %s", probe.Err)
+			}
+			family = probe.Family
+		}
+	}
+	shellCaps := family.Capabilities()
+	detectDone := shellClock.Now()
+	shellOpts = append(shellOpts, cmdOpts.ShellOpts...)
+	integrationEnv := map[string]string{
+		WaveSessionIdVarName:  cmdOpts.SessionID,
+		WaveScratchDirVarName: scratchDirPath(cmdOpts.SessionID),
+	}
+	if cmdOpts.WslDistro != "" {
+		ecmd = wslDistroCmd(cmdOpts, cmdStr, argv)
+	} else if cmdOpts.Container != "" {
+		ecmd = containerExecCmd(containerTool, cmdOpts, cmdStr, argv)
+	} else if cmdOpts.Pod != nil {
+		ecmd = podExecCmd(kubectlPath, cmdOpts, cmdStr, argv)
+	} else if argv != nil {
+		ecmd = exec.Command(argv[0], argv[1:]...)
+	} else if cmdStr == "" {
+		switch shellCaps.IntegrationMethod {
+		case shellutil.IntegrationMethod_RcFile:
+			// cant set -l or -i with --rcfile
+			shellOpts = append(shellOpts, "--rcfile", shellutil.GetBashRcFileOverride())
+		case shellutil.IntegrationMethod_DataDir:
+			// fish finds our startup file itself (see setFishDataDirs), so it keeps its own flags
+			shellOpts = append(shellOpts, family.ShellArgs(shellutil.ShellArgOpts{Login: cmdOpts.Login})...)
+		case shellutil.IntegrationMethod_Command:
+			// nu takes -l along with its init command (bash can't take it with --rcfile), nu is always
+			// interactive without -c and -e runs after its config files
+			wshBinDir := filepath.Join(wavebase.GetWaveDataDir(), shellutil.WaveHomeBinDir)
+			shellOpts = append(shellOpts, family.ShellArgs(shellutil.ShellArgOpts{Login: cmdOpts.Login})...)
+			shellOpts = append(shellOpts, "-e", shellutil.NuPathInit(wshBinDir))
+		case shellutil.IntegrationMethod_File:
+			shellOpts = appendShellArgs(shellOpts, family, shellutil.ShellArgOpts{Login: cmdOpts.Login, ShellPath: shellPath})
+			shellOpts = append(shellOpts, "-ExecutionPolicy", "Bypass", "-NoExit", "-File", shellutil.GetWavePowershellEnv())
+		default:
+			shellOpts = append(shellOpts, family.ShellArgs(shellutil.ShellArgOpts{Login: cmdOpts.Login, Interactive: cmdOpts.Interactive})...)
+		}
+		ecmd = exec.Command(shellPath, shellOpts...)
+		if shellCaps.IntegrationMethod == shellutil.IntegrationMethod_ZDotDir {
+			integrationEnv["ZDOTDIR"] = shellutil.GetZshZDotDir()
+		}
+	} else {
+		shellOpts = appendShellArgs(shellOpts, family, shellutil.ShellArgOpts{Login: cmdOpts.Login, Interactive: cmdOpts.Interactive, CmdStr: cmdStr, ShellPath: shellPath})
+		ecmd = exec.Command(shellPath, shellOpts...)
+	}
+	if cmdOpts.Cwd != "" && !runsOffHost(cmdOpts) {
+		ecmd.Dir = cmdOpts.Cwd
+	}
+	runAsEnv := runAsEnvVars(cmdOpts)
+	if cwdErr := checkCwd(ecmd.Dir); cwdErr != nil {
+		ecmd.Dir = wavebase.GetHomeDir()
+		if runAsEnv["HOME"] != "" && checkCwd(runAsEnv["HOME"]) == nil {
+			ecmd.Dir = runAsEnv["HOME"]
+		}
+	}
+	var pushEnv *pushEnvTarget
+	if cmdStr == "" && argv == nil {
+		pushEnv, err = makePushEnvTarget(family)
+		if err != nil {
+			return nil, err
+		}
+		if pushEnv != nil {
+			integrationEnv[shellutil.WavePushEnvFileVarName] = pushEnv.Path
+		}
+	}
+	envReport, err := buildLocalEnv(ecmd, cmdOpts, family, integrationEnv, runAsEnv, wenv)
+	if err != nil {
+		return nil, err
+	}
+	if cmdStr == "" && argv == nil && !runsOffHost(cmdOpts) && shellCaps.IntegrationMethod == shellutil.IntegrationMethod_DataDir {
+		setFishDataDirs(ecmd)
+	}
+	if cmdOpts.WslDistro != "" {
+		setWslEnv(ecmd, envReport)
+	}
+	if cmdOpts.Container != "" {
+		addContainerEnvArgs(ecmd, envReport)
+	}
+	if cmdOpts.Pod != nil {
+		addPodEnvArgs(ecmd, envReport)
+	}
+	if termSize.Rows == 0 || termSize.Cols == 0 {
+		termSize.Rows = shellutil.DefaultTermRows
+		termSize.Cols = shellutil.DefaultTermCols
+	}
+	if termSize.Rows <= 0 || termSize.Cols <= 0 {
+		return nil, fmt.Errorf("invalid term size: %v", termSize)
+	}
+	var elevate *elevateTarget
+	if cmdOpts.Elevate {
+		// the vars we set ourselves are always passed (whatever the sudoers policy does with -E)
+		passEnv := make(map[string]string)
+		for _, name := range envReport.FromLayers(shellutil.EnvLayer_Integration, shellutil.EnvLayer_Waveshell, shellutil.EnvLayer_CmdOpts) {
+			if val, ok := cmdEnvLookup(ecmd, name); ok {
+				passEnv[name] = val
+			}
+		}
+		elevate, err = elevateShellCmd(ecmd, cmdOpts, passEnv)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var releaseRead *os.File
+	var release *releaseGate
+	if cmdOpts.StartSuspended {
+		var err error
+		releaseRead, release, err = makeSuspendTrampoline(ecmd)
+		if err != nil {
+			if elevate != nil {
+				elevate.cleanup()
+			}
+			return nil, err
+		}
+	}
+	setRunAsCredential(ecmd, cmdOpts)
+	cgroup, rlimits := setupShellCgroup(cmdOpts, ecmd)
+	var warm *warmPty
+	if useWarmPool(cmdOpts) {
+		warm = globalWarmPool.takePty()
+	}
+	prepareDone := shellClock.Now()
+	ptySize := &pty.Winsize{Rows: uint16(termSize.Rows), Cols: uint16(termSize.Cols)}
+	var cmdPty pty.Pty
+	var supervised *supervisedCmd
+	var ptyOpened time.Time
+	if cmdOpts.Supervise != nil {
+		supervised, ptyOpened, err = startSupervised(shellClock, ecmd, ptySize, *cmdOpts.Supervise, cmdOpts.TtyInit)
+	} else {
+		cmdPty, ptyOpened, err = startInPty(shellClock, ecmd, ptySize, warm, cmdOpts.TtyInit)
+	}
+	started := shellClock.Now()
+	if releaseRead != nil {
+		releaseRead.Close()
+	}
+	if cgroup != nil && err == nil {
+		cgroup.started(ecmd.Process.Pid)
+	}
+	if rlimits.isSet() && err == nil {
+		setShellRlimits(cmdOpts.SessionID, ecmd.Process.Pid, rlimits)
+	}
+	if cmdOpts.Nice != 0 && err == nil {
+		setShellPriority(cmdOpts.SessionID, ecmd.Process.Pid, cmdOpts.Nice)
+		if supervised != nil {
+			supervised.SessionId, supervised.Nice = cmdOpts.SessionID, cmdOpts.Nice
+		}
+	}
+	if err != nil {
+		if ptyOpened.IsZero() {
+			shellMetrics.startFailed(StartFailure_Pty)
+		} else {
+			shellMetrics.startFailed(StartFailure_Spawn)
+		}
+		if release != nil {
+			release.File.Close()
+		}
+		if cgroup != nil {
+			cgroup.remove()
+		}
+		if elevate != nil {
+			elevate.cleanup()
+		}
+		return nil, err
+	}
+	var cmd ConnInterface = MakeCmdWrap(ecmd, cmdPty)
+	if supervised != nil {
+		cmd = supervised
+	}
+	if cmdOpts.PacketMode {
+		// (before the output loop's first read)
+		if err := setPtyPacketMode(cmd.Fd()); err != nil {
+			shellLogf(cmdOpts.SessionID, "warning: cannot set packet mode: %v\n", err)
+			cmdOpts.PacketMode = false
+		}
+	}
+	sp := makeShellProc(cmd, "", cmdOpts)
+	sp.release = release
+	sp.cgroup = cgroup
+	sp.pushEnv = pushEnv
+	sp.elevate = elevate
+	if elevate != nil && elevate.Askpass != nil {
+		go elevate.Askpass.run(sp)
+	}
+	sp.envReport = envReport
+	if shellFallback != nil {
+		sp.events.publish(ShellEvent{Kind: EventKind_ShellFallback, ShellFallback: shellFallback})
+	}
+	sp.startup.setPhases(startTime, detectDone, prepareDone, ptyOpened, started)
+	shellRegistry.register(sp)
+	sp.startSupervisor()
+	if cmdOpts.MeasurePromptReady && pushEnv != nil {
+		sp.startup.logWhenReady()
+		if err := queuePromptReadyMarker(pushEnv); err != nil {
+			sp.logf("warning: cannot measure prompt ready time: %v\n", err)
+		}
+	}
+	return sp, nil
+}
+
+// the shell runs in a wsl distro, a container or a pod (Cwd and ShellPath are paths there)
+func runsOffHost(cmdOpts CommandOptsType) bool {
+	return cmdOpts.WslDistro != "" || cmdOpts.Container != "" || cmdOpts.Pod != nil
+}
+
+// offHostArgv is what runs inside the wsl distro, container or pod: argv, or the shell (cmdOpts.ShellPath,
+// defaultShell if empty) with ShellOpts and its flags
+func offHostArgv(cmdOpts CommandOptsType, cmdStr string, argv []string, defaultShell string) []string {
+	if argv != nil {
+		return argv
+	}
+	shellPath := cmdOpts.ShellPath
+	if shellPath == "" {
+		shellPath = defaultShell
+	}
+	family := shellutil.DetectFamily(shellPath)
+	shellArgs := appendShellArgs(slices.Clone(cmdOpts.ShellOpts), family, shellutil.ShellArgOpts{Login: cmdOpts.Login, Interactive: cmdOpts.Interactive, CmdStr: cmdStr, ShellPath: shellPath})
+	return append([]string{shellPath}, shellArgs...)
+}
+
+// appends family's shell args to shellOpts (the user's ShellOpts so far), except that pwsh's -Login
+// goes in front of them
+func appendShellArgs(shellOpts []string, family shellutil.ShellFamily, argOpts shellutil.ShellArgOpts) []string {
+	args := family.ShellArgs(argOpts)
+	if len(args) > 0 && args[0] == shellutil.PwshLoginFlag {
+		return append(append([]string{args[0]}, shellOpts...), args[1:]...)
+	}
+	return append(shellOpts, args...)
+}
+
+// StartShellProcCtx is StartShellProc with the shell's lifetime tied to ctx: once ctx is done the
+// shell is closed (like Close, CloseReason is then CloseReason_ContextDone), which also unblocks Wait
+// (the shell is waited for by Close).  Returns ctx.Err() if ctx is already done.
+func StartShellProcCtx(ctx context.Context, termSize waveobj.TermSize, cmdStr string, cmdOpts CommandOptsType) (*ShellProc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sp, err := StartShellProc(termSize, cmdStr, cmdOpts)
+	if err != nil {
+		return nil, err
+	}
+	sp.closeOnContextDone(ctx)
+	return sp, nil
+}
+
+// RunSimpleCmdInPty runs ecmd in a pty and returns everything it wrote, along with
+// the wait error (the output is returned even if the command failed)
+func RunSimpleCmdInPty(ecmd *exec.Cmd, termSize waveobj.TermSize) ([]byte, error) {
+	res, err := RunSimpleCmdInPtyOpts(ecmd, termSize, SimpleCmdOpts{})
+	return res.Output, err
+}
+
+// RunSimpleCmdInPtyOpts is RunSimpleCmdInPty with the output bounded by opts.MaxOutputBytes (see SimpleCmdResult)
+func RunSimpleCmdInPtyOpts(ecmd *exec.Cmd, termSize waveobj.TermSize, opts SimpleCmdOpts) (SimpleCmdResult, error) {
+	if err := resolveSimpleCmdOpts(opts); err != nil {
+		return SimpleCmdResult{}, err
+	}
+	outputLock := &sync.Mutex{}
+	outputBuf := makeSimpleOutputBuffer(opts)
+	err := runCmdInPty(ecmd, termSize, func(data []byte) {
+		outputLock.Lock()
+		defer outputLock.Unlock()
+		outputBuf.Write(data)
+	})
+	// (after a drain timeout the read can still be blocked, closing the pty doesn't always wake it up)
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	return outputBuf.result(), err
+}
+
+// RunCmdInPtyStream is RunSimpleCmdInPty with the output written to w as it arrives (from another
+// goroutine, not after RunCmdInPtyStream returns).  If a write fails the rest of the output is
+// discarded (the command still runs to completion), the write error is returned if the command succeeded
+func RunCmdInPtyStream(ecmd *exec.Cmd, termSize waveobj.TermSize, w io.Writer) error {
+	outputLock := &sync.Mutex{}
+	var writeErr error
+	var returned bool
+	err := runCmdInPty(ecmd, termSize, func(data []byte) {
+		outputLock.Lock()
+		defer outputLock.Unlock()
+		if returned || writeErr != nil {
+			return
+		}
+		_, writeErr = w.Write(data)
+	})
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	returned = true
+	if err == nil && writeErr != nil {
+		return fmt.Errorf("error writing output: %w", writeErr)
+	}
+	return err
+}
+
+// runs ecmd in a pty, calling outputFn (from the io goroutine, data is only valid during the call) with
+// its output until it exits and the output is drained, returns the wait error
+func runCmdInPty(ecmd *exec.Cmd, termSize waveobj.TermSize, outputFn func(data []byte)) error {
+	if err := setSimpleCmdEnv(ecmd, shellutil.DefaultTermType); err != nil {
+		return err
+	}
+	if termSize.Rows == 0 || termSize.Cols == 0 {
+		termSize.Rows = shellutil.DefaultTermRows
+		termSize.Cols = shellutil.DefaultTermCols
+	}
+	if termSize.Rows <= 0 || termSize.Cols <= 0 {
+		return fmt.Errorf("invalid term size: %v", termSize)
+	}
+	cmdPty, _, err := startInPty(shellClock, ecmd, &pty.Winsize{Rows: uint16(termSize.Rows), Cols: uint16(termSize.Cols)}, nil, nil)
+	if err != nil {
+		return err
+	}
+	ioDone := make(chan bool)
+	go func() {
+		defer panichandler.PanicHandler("RunSimpleCmdInPty:ioCopy")
+		defer close(ioDone)
+		buf := make([]byte, 4096)
+		for {
+			nr, err := cmdPty.Read(buf)
+			if nr > 0 {
+				outputFn(buf[:nr])
+			}
+			if err != nil {
+				// ignore error (/dev/ptmx has read error when process is done)
+				return
+			}
+		}
+	}()
+	exitErr := ecmd.Wait()
+	// like a shellproc, the output is drained (bounded) before the pty is closed, whether or not the command failed
+	timerCh, stopFn := shellClock.NewTimer(outputDrainTimeout)
+	select {
+	case <-ioDone:
+	case <-timerCh:
+		log.Printf("warning: RunSimpleCmdInPty output not done %v after exit\n", outputDrainTimeout)
+	}
+	stopFn()
+	// on windows the ConPTY closes itself once the process exits
+	if runtime.GOOS != "windows" {
+		cmdPty.Close()
+	}
+	return exitErr
+}
+
+// RunSimpleCmdPipes runs ecmd without a pty (stdin is /dev/null unless set) and returns its stdout and
+// stderr separately, for tools whose output is parsed (TERM is "dumb" so nothing should be colored).
+// Like RunSimpleCmdInPty the output is returned along with the wait error
+func RunSimpleCmdPipes(ecmd *exec.Cmd) ([]byte, []byte, error) {
+	if ecmd.Stdout != nil || ecmd.Stderr != nil {
+		return nil, nil, fmt.Errorf("cannot run command: stdout or stderr already set")
+	}
+	if err := setSimpleCmdEnv(ecmd, simpleCmdPipesTermType); err != nil {
+		return nil, nil, err
+	}
+	var stdoutBuf, stderrBuf bytes.Buffer
+	ecmd.Stdout = &stdoutBuf
+	ecmd.Stderr = &stderrBuf
+	err := ecmd.Run()
+	return stdoutBuf.Bytes(), stderrBuf.Bytes(), err
+}
+
+const simpleCmdPipesTermType = "dumb"
+
+// adds our data dir to the XDG_DATA_DIRS fish gets (the one it would have inherited, or the default), see
+// shellutil.FishStartup_Waveterm
+func setFishDataDirs(ecmd *exec.Cmd) {
+	var existing *string
+	if val, ok := cmdEnvLookup(ecmd, shellutil.XdgDataDirsVarName); ok {
+		existing = &val
+	}
+	for name, val := range shellutil.FishDataDirsEnv(shellutil.GetFishDataDir(), existing) {
+		setCmdEnvVar(ecmd, name, val)
+	}
+}
+
+// our env (not the shell's), like a shellproc's it is validated first
+func setSimpleCmdEnv(ecmd *exec.Cmd, termType string) error {
+	ecmd.Env, _ = shellutil.BuildEnv(
+		shellutil.EnvLayer{Name: shellutil.EnvLayer_Inherited, Environ: os.Environ()},
+		shellutil.EnvLayer{Name: shellutil.EnvLayer_Waveshell, Vars: shellutil.WaveshellLocalEnvVars(termType)},
+	)
+	if err := shellutil.ValidateCmdEnv(ecmd, shellutil.EnvCheckOpts{}); err != nil {
+		return fmt.Errorf("cannot run command: %w", err)
+	}
+	return nil
+}
+
+func checkCwd(cwd string) error {
+	if cwd == "" {
+		return fmt.Errorf("cwd is empty")
+	}
+	if _, err := os.Stat(cwd); err != nil {
+		return fmt.Errorf("error statting cwd %q: %w", cwd, err)
+	}
+	return nil
+}
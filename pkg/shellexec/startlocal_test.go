@@ -0,0 +1,152 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+func TestShellPathOverride(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no posix shells on windows")
+	}
+	shPath := requireBinary(t, "sh")
+	sp := startTestShellProc(t, `echo "shell=$0"`, CommandOptsType{ShellPath: shPath})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "shell="+shPath)
+	waitExitStatus(t, sp)
+
+	// ShellOpts are passed before -c
+	sp = startTestShellProc(t, `echo "x${NOPE_UNSET_VAR}y"`, CommandOptsType{ShellPath: shPath, ShellOpts: []string{"-u"}})
+	if status := waitExitStatus(t, sp); status.ExitCode == 0 {
+		t.Errorf("expected -u to fail on the unset var, got %+v", status)
+	}
+}
+
+func TestStartArgvProc(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no printf on windows")
+	}
+	termSize := waveobj.TermSize{Rows: 24, Cols: 80}
+	argv := []string{requireBinary(t, "printf"), "[%s]", "a  b", "$HOME", "; echo injected", `"quoted"`}
+	sp, err := StartArgvProc(termSize, argv, CommandOptsType{})
+	if err != nil {
+		t.Fatalf("error starting argv proc: %v", err)
+	}
+	t.Cleanup(sp.Close)
+	oc := collectOutput(sp)
+	oc.waitFor(t, `[a  b][$HOME][; echo injected]["quoted"]`)
+	if status := waitExitStatus(t, sp); status.ExitCode != 0 {
+		t.Errorf("expected a clean exit, got %+v", status)
+	}
+	if strings.Contains(oc.String(), "injected\r\n") {
+		t.Errorf("argv was run by a shell, output: %q", oc.String())
+	}
+
+	if _, err := StartArgvProc(termSize, nil, CommandOptsType{}); err == nil {
+		t.Errorf("expected an error for an empty argv")
+	}
+	if _, err := StartArgvProc(termSize, []string{"wave-no-such-binary"}, CommandOptsType{}); err == nil {
+		t.Errorf("expected an error for a missing binary")
+	}
+}
+
+func TestRunSimpleCmdPipes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no posix shells on windows")
+	}
+	ecmd := exec.Command(requireBinary(t, "sh"), "-c", `echo "out $TERM"; echo err >&2; [ -t 1 ] && echo tty; exit 2`)
+	stdout, stderr, err := RunSimpleCmdPipes(ecmd)
+	if code := ExitCodeFromWaitErr(err); code != 2 {
+		t.Errorf("expected exit code 2, got %d (%v)", code, err)
+	}
+	if string(stdout) != "out dumb\n" {
+		t.Errorf("unexpected stdout %q", stdout)
+	}
+	if string(stderr) != "err\n" {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+
+	ecmd = exec.Command(requireBinary(t, "true"))
+	ecmd.Stdout = os.Stdout
+	if _, _, err := RunSimpleCmdPipes(ecmd); err == nil {
+		t.Errorf("expected an error when stdout is already set")
+	}
+}
+
+type streamTestWriter struct {
+	Lock    *sync.Mutex
+	Output  strings.Builder
+	FirstCh chan bool
+	Err     error
+}
+
+func (w *streamTestWriter) Write(data []byte) (int, error) {
+	w.Lock.Lock()
+	defer w.Lock.Unlock()
+	if w.Err != nil {
+		return 0, w.Err
+	}
+	w.Output.Write(data)
+	if w.FirstCh != nil && strings.Contains(w.Output.String(), "first") {
+		close(w.FirstCh)
+		w.FirstCh = nil
+	}
+	return len(data), nil
+}
+
+func TestRunCmdInPtyStream(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no posix shells on windows")
+	}
+	termSize := waveobj.TermSize{Rows: 24, Cols: 80}
+	firstCh := make(chan bool)
+	w := &streamTestWriter{Lock: &sync.Mutex{}, FirstCh: firstCh}
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- RunCmdInPtyStream(exec.Command(requireBinary(t, "sh"), "-c", "echo first; sleep 0.5; echo second"), termSize, w)
+	}()
+	select {
+	case <-firstCh:
+	case <-doneCh:
+		t.Fatalf("output was not streamed before the command exited")
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for the first output")
+	}
+	if err := <-doneCh; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if output := w.Output.String(); output != "first\r\nsecond\r\n" {
+		t.Errorf("unexpected output %q", output)
+	}
+
+	writeErr := errors.New("write failed")
+	w = &streamTestWriter{Lock: &sync.Mutex{}, Err: writeErr}
+	if err := RunCmdInPtyStream(exec.Command(requireBinary(t, "sh"), "-c", "echo hello"), termSize, w); !errors.Is(err, writeErr) {
+		t.Errorf("expected the write error, got %v", err)
+	}
+}
+
+func TestAppendShellArgsPwshLogin(t *testing.T) {
+	userOpts := []string{"-NoProfile"}
+	args := appendShellArgs(userOpts, shellutil.ShellFamily_Pwsh, shellutil.ShellArgOpts{Login: true, ShellPath: "/usr/bin/pwsh"})
+	if !slices.Equal(args, []string{"-Login", "-NoProfile", "-NoLogo"}) {
+		t.Errorf("expected -Login before the user's opts, got %q", args)
+	}
+	args = appendShellArgs(userOpts, shellutil.ShellFamily_Bash, shellutil.ShellArgOpts{Login: true})
+	if !slices.Equal(args, []string{"-NoProfile", "-l"}) {
+		t.Errorf("expected the shell args after the user's opts, got %q", args)
+	}
+}
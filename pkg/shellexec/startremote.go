@@ -0,0 +1,409 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/creack/pty"
+	"github.com/wavetermdev/waveterm/pkg/remote"
+	"github.com/wavetermdev/waveterm/pkg/remote/conncontroller"
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+	"github.com/wavetermdev/waveterm/pkg/wshutil"
+	"github.com/wavetermdev/waveterm/pkg/wsl"
+	"golang.org/x/crypto/ssh"
+)
+
+type PipePty struct {
+	remoteStdinWrite *os.File
+	remoteStdoutRead *os.File
+}
+
+func (pp *PipePty) Fd() uintptr {
+	return pp.remoteStdinWrite.Fd()
+}
+
+func (pp *PipePty) Name() string {
+	return "pipe-pty"
+}
+
+func (pp *PipePty) Read(p []byte) (n int, err error) {
+	return pp.remoteStdoutRead.Read(p)
+}
+
+func (pp *PipePty) Write(p []byte) (n int, err error) {
+	return pp.remoteStdinWrite.Write(p)
+}
+
+func (pp *PipePty) Close() error {
+	err1 := pp.remoteStdinWrite.Close()
+	err2 := pp.remoteStdoutRead.Close()
+
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+func (pp *PipePty) WriteString(s string) (n int, err error) {
+	return pp.Write([]byte(s))
+}
+
+func StartWslShellProc(ctx context.Context, termSize waveobj.TermSize, cmdStr string, cmdOpts CommandOptsType, conn *wsl.WslConn) (*ShellProc, error) {
+	if err := resolveSessionId(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolveSanitizeProfile(&cmdOpts, SanitizeProfile_Standard); err != nil {
+		return nil, err
+	}
+	if err := resolveShutdownOpts(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolveTermType(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if cmdOpts.StartSuspended {
+		return nil, fmt.Errorf("StartSuspended is only supported for local shells")
+	}
+	if cmdOpts.Supervise != nil {
+		return nil, fmt.Errorf("Supervise is only supported for local shells")
+	}
+	client := conn.GetClient()
+	shellPath := cmdOpts.ShellPath
+	if shellPath == "" {
+		remoteShellPath, err := wsl.DetectShell(conn.Context, client)
+		if err != nil {
+			return nil, err
+		}
+		shellPath = remoteShellPath
+	}
+	var shellOpts []string
+	shellLogf(cmdOpts.SessionID, "detected shell: %s", shellPath)
+
+	err := wsl.InstallClientRcFiles(conn.Context, client)
+	if err != nil {
+		shellLogf(cmdOpts.SessionID, "error installing rc files: %v", err)
+		return nil, err
+	}
+
+	homeDir := wsl.GetHomeDir(conn.Context, client)
+	shellOpts = append(shellOpts, "~", "-d", client.Name())
+
+	if isZshShell(shellPath) {
+		shellOpts = append(shellOpts, fmt.Sprintf(`ZDOTDIR="%s/.waveterm/%s"`, homeDir, shellutil.ZshIntegrationDir))
+	}
+	var subShellOpts []string
+
+	if cmdStr == "" {
+		/* transform command in order to inject environment vars */
+		if isBashShell(shellPath) {
+			shellLogf(cmdOpts.SessionID, "recognized as bash shell")
+			// add --rcfile
+			// cant set -l or -i with --rcfile
+			subShellOpts = append(subShellOpts, "--rcfile", fmt.Sprintf(`%s/.waveterm/%s/.bashrc`, homeDir, shellutil.BashIntegrationDir))
+		} else if isFishShell(shellPath) {
+			// our startup file (written with the rc files) sets PATH itself, -C runs it after config.fish
+			carg := fmt.Sprintf(`"source \"%s\"/.waveterm/%s/vendor_conf.d/waveterm.fish"`, homeDir, shellutil.FishIntegrationDir)
+			subShellOpts = append(subShellOpts, shellutil.ShellFamily_Fish.ShellArgs(shellutil.ShellArgOpts{Login: cmdOpts.Login})...)
+			subShellOpts = append(subShellOpts, "-C", carg)
+		} else if isNuShell(shellPath) {
+			// single quoted for the shell that runs the command line
+			earg := fmt.Sprintf("'%s'", shellutil.NuPathInit(fmt.Sprintf("%s/.waveterm/%s", homeDir, shellutil.WaveHomeBinDir)))
+			subShellOpts = append(subShellOpts, shellutil.ShellFamily_Nushell.ShellArgs(shellutil.ShellArgOpts{Login: cmdOpts.Login})...)
+			subShellOpts = append(subShellOpts, "-e", earg)
+		} else if wsl.IsPowershell(shellPath) {
+			// powershell is weird about quoted path executables and requires an ampersand first
+			shellPath = "& " + shellPath
+			subShellOpts = append(subShellOpts, "-ExecutionPolicy", "Bypass", "-NoExit", "-File", homeDir+fmt.Sprintf("/.waveterm/%s/wavepwsh.ps1", shellutil.PwshIntegrationDir))
+		} else {
+			family := shellutil.DetectFamily(shellPath)
+			subShellOpts = append(subShellOpts, family.ShellArgs(shellutil.ShellArgOpts{Login: cmdOpts.Login, Interactive: cmdOpts.Interactive})...)
+			// can't set environment vars this way
+			// will try to do later if possible
+		}
+	} else {
+		shellPath = cmdStr
+		if cmdOpts.Login {
+			subShellOpts = append(subShellOpts, "-l")
+		}
+		if cmdOpts.Interactive {
+			subShellOpts = append(subShellOpts, "-i")
+		}
+		subShellOpts = append(subShellOpts, "-c", cmdStr)
+	}
+
+	jwtToken, ok := cmdOpts.Env[wshutil.WaveJwtTokenVarName]
+	if !ok {
+		return nil, fmt.Errorf("no jwt token provided to connection")
+	}
+	if remote.IsPowershell(shellPath) {
+		shellOpts = append(shellOpts, "--", fmt.Sprintf(`$env:%s=%s;`, wshutil.WaveJwtTokenVarName, jwtToken), fmt.Sprintf(`$env:%s=%s;`, WaveSessionIdVarName, cmdOpts.SessionID), fmt.Sprintf(`$env:TERM=%s;`, cmdOpts.TermType))
+	} else {
+		shellOpts = append(shellOpts, "--", fmt.Sprintf(`%s=%s`, wshutil.WaveJwtTokenVarName, jwtToken), fmt.Sprintf(`%s=%s`, WaveSessionIdVarName, cmdOpts.SessionID), fmt.Sprintf(`TERM=%s`, cmdOpts.TermType))
+	}
+	shellOpts = append(shellOpts, shellPath)
+	shellOpts = append(shellOpts, subShellOpts...)
+	shellLogf(cmdOpts.SessionID, "full cmd is: %s %s", "wsl.exe", strings.Join(shellOpts, " "))
+
+	ecmd := exec.Command("wsl.exe", shellOpts...)
+	if termSize.Rows == 0 || termSize.Cols == 0 {
+		termSize.Rows = shellutil.DefaultTermRows
+		termSize.Cols = shellutil.DefaultTermCols
+	}
+	if termSize.Rows <= 0 || termSize.Cols <= 0 {
+		return nil, fmt.Errorf("invalid term size: %v", termSize)
+	}
+	cmdPty, err := pty.StartWithSize(ecmd, &pty.Winsize{Rows: uint16(termSize.Rows), Cols: uint16(termSize.Cols)})
+	if err != nil {
+		return nil, err
+	}
+	cmdWrap := MakeCmdWrap(ecmd, cmdPty)
+	return makeShellProc(cmdWrap, conn.GetName(), cmdOpts), nil
+}
+
+func StartRemoteShellProcNoWsh(termSize waveobj.TermSize, cmdStr string, cmdOpts CommandOptsType, conn *conncontroller.SSHConn) (*ShellProc, error) {
+	if err := resolveSessionId(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolveSanitizeProfile(&cmdOpts, SanitizeProfile_Standard); err != nil {
+		return nil, err
+	}
+	if err := resolveShutdownOpts(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolveTermType(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if cmdOpts.StartSuspended {
+		return nil, fmt.Errorf("StartSuspended is only supported for local shells")
+	}
+	if cmdOpts.Supervise != nil {
+		return nil, fmt.Errorf("Supervise is only supported for local shells")
+	}
+	client := conn.GetClient()
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	remoteStdinRead, remoteStdinWriteOurs, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	remoteStdoutReadOurs, remoteStdoutWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	pipePty := &PipePty{
+		remoteStdinWrite: remoteStdinWriteOurs,
+		remoteStdoutRead: remoteStdoutReadOurs,
+	}
+	if termSize.Rows == 0 || termSize.Cols == 0 {
+		termSize.Rows = shellutil.DefaultTermRows
+		termSize.Cols = shellutil.DefaultTermCols
+	}
+	if termSize.Rows <= 0 || termSize.Cols <= 0 {
+		return nil, fmt.Errorf("invalid term size: %v", termSize)
+	}
+	session.Stdin = remoteStdinRead
+	session.Stdout = remoteStdoutWrite
+	session.Stderr = remoteStdoutWrite
+
+	// (might fail depending on server settings)
+	session.Setenv(WaveSessionIdVarName, cmdOpts.SessionID)
+	if err := requestRemotePty(session, cmdOpts.TermType, termSize); err != nil {
+		pipePty.Close()
+		return nil, err
+	}
+	sessionWrap := MakeSessionWrap(session, "", pipePty)
+	err = session.Shell()
+	if err != nil {
+		pipePty.Close()
+		return nil, err
+	}
+	sp := makeShellProc(sessionWrap, conn.GetName(), cmdOpts)
+	sp.sshClient = client
+	return sp, nil
+}
+
+func StartRemoteShellProc(termSize waveobj.TermSize, cmdStr string, cmdOpts CommandOptsType, conn *conncontroller.SSHConn) (*ShellProc, error) {
+	if err := resolveSessionId(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolveSanitizeProfile(&cmdOpts, SanitizeProfile_Standard); err != nil {
+		return nil, err
+	}
+	if err := resolveShutdownOpts(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if err := resolveTermType(&cmdOpts); err != nil {
+		return nil, err
+	}
+	if cmdOpts.StartSuspended {
+		return nil, fmt.Errorf("StartSuspended is only supported for local shells")
+	}
+	if cmdOpts.Supervise != nil {
+		return nil, fmt.Errorf("Supervise is only supported for local shells")
+	}
+	client := conn.GetClient()
+	shellPath := cmdOpts.ShellPath
+	if shellPath == "" {
+		remoteShellPath, err := remote.DetectShell(client)
+		if err != nil {
+			return nil, err
+		}
+		shellPath = remoteShellPath
+	}
+	var shellOpts []string
+	var cmdCombined string
+	shellLogf(cmdOpts.SessionID, "detected shell: %s", shellPath)
+
+	err := remote.InstallClientRcFiles(client)
+	if err != nil {
+		shellLogf(cmdOpts.SessionID, "error installing rc files: %v", err)
+		return nil, err
+	}
+	shellOpts = append(shellOpts, cmdOpts.ShellOpts...)
+
+	homeDir := remote.GetHomeDir(client)
+
+	if cmdStr == "" {
+		/* transform command in order to inject environment vars */
+		if isBashShell(shellPath) {
+			shellLogf(cmdOpts.SessionID, "recognized as bash shell")
+			// add --rcfile
+			// cant set -l or -i with --rcfile
+			shellOpts = append(shellOpts, "--rcfile", fmt.Sprintf(`"%s"/.waveterm/%s/.bashrc`, homeDir, shellutil.BashIntegrationDir))
+		} else if isFishShell(shellPath) {
+			// our startup file (written with the rc files) sets PATH itself, -C runs it after config.fish
+			carg := fmt.Sprintf(`"source \"%s\"/.waveterm/%s/vendor_conf.d/waveterm.fish"`, homeDir, shellutil.FishIntegrationDir)
+			shellOpts = append(shellOpts, shellutil.ShellFamily_Fish.ShellArgs(shellutil.ShellArgOpts{Login: cmdOpts.Login})...)
+			shellOpts = append(shellOpts, "-C", carg)
+		} else if isNuShell(shellPath) {
+			// single quoted for the shell that runs the command line
+			earg := fmt.Sprintf("'%s'", shellutil.NuPathInit(fmt.Sprintf("%s/.waveterm/%s", homeDir, shellutil.WaveHomeBinDir)))
+			shellOpts = append(shellOpts, shellutil.ShellFamily_Nushell.ShellArgs(shellutil.ShellArgOpts{Login: cmdOpts.Login})...)
+			shellOpts = append(shellOpts, "-e", earg)
+		} else if remote.IsPowershell(shellPath) {
+			// powershell is weird about quoted path executables and requires an ampersand first
+			shellPath = "& " + shellPath
+			shellOpts = append(shellOpts, "-ExecutionPolicy", "Bypass", "-NoExit", "-File", homeDir+fmt.Sprintf("/.waveterm/%s/wavepwsh.ps1", shellutil.PwshIntegrationDir))
+		} else {
+			family := shellutil.DetectFamily(shellPath)
+			shellOpts = append(shellOpts, family.ShellArgs(shellutil.ShellArgOpts{Login: cmdOpts.Login, Interactive: cmdOpts.Interactive})...)
+			// zdotdir setting moved to after session is created
+		}
+		cmdCombined = fmt.Sprintf("%s %s", shellPath, strings.Join(shellOpts, " "))
+		shellLogf(cmdOpts.SessionID, "combined command is: %s", cmdCombined)
+	} else {
+		shellPath = cmdStr
+		shellOpts = append(shellOpts, "-c", cmdStr)
+		cmdCombined = fmt.Sprintf("%s %s", shellPath, strings.Join(shellOpts, " "))
+		shellLogf(cmdOpts.SessionID, "combined command is: %s", cmdCombined)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	remoteStdinRead, remoteStdinWriteOurs, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	remoteStdoutReadOurs, remoteStdoutWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	pipePty := &PipePty{
+		remoteStdinWrite: remoteStdinWriteOurs,
+		remoteStdoutRead: remoteStdoutReadOurs,
+	}
+	if termSize.Rows == 0 || termSize.Cols == 0 {
+		termSize.Rows = shellutil.DefaultTermRows
+		termSize.Cols = shellutil.DefaultTermCols
+	}
+	if termSize.Rows <= 0 || termSize.Cols <= 0 {
+		return nil, fmt.Errorf("invalid term size: %v", termSize)
+	}
+	session.Stdin = remoteStdinRead
+	session.Stdout = remoteStdoutWrite
+	session.Stderr = remoteStdoutWrite
+
+	// the remote side has its own environ (and sets TERM from the pty request), we only send cmdOpts.Env
+	remoteEnv, envReport := shellutil.BuildEnv(
+		shellutil.EnvLayer{Name: shellutil.EnvLayer_Integration, Vars: map[string]string{WaveSessionIdVarName: cmdOpts.SessionID}},
+		shellutil.EnvLayer{Name: shellutil.EnvLayer_CmdOpts, Vars: cmdOpts.Env},
+	)
+	for _, envStr := range remoteEnv {
+		// note these might fail depending on server settings, but we still try
+		envKey, envVal, _ := strings.Cut(envStr, "=")
+		session.Setenv(envKey, envVal)
+	}
+
+	if isZshShell(shellPath) {
+		cmdCombined = fmt.Sprintf(`ZDOTDIR="%s/.waveterm/%s" %s`, homeDir, shellutil.ZshIntegrationDir, cmdCombined)
+	}
+
+	jwtToken, ok := cmdOpts.Env[wshutil.WaveJwtTokenVarName]
+	if !ok {
+		return nil, fmt.Errorf("no jwt token provided to connection")
+	}
+
+	if remote.IsPowershell(shellPath) {
+		cmdCombined = fmt.Sprintf(`$env:%s="%s"; %s`, wshutil.WaveJwtTokenVarName, jwtToken, cmdCombined)
+	} else {
+		cmdCombined = fmt.Sprintf(`%s=%s %s`, wshutil.WaveJwtTokenVarName, jwtToken, cmdCombined)
+	}
+
+	if err := requestRemotePty(session, cmdOpts.TermType, termSize); err != nil {
+		pipePty.Close()
+		return nil, err
+	}
+	sessionWrap := MakeSessionWrap(session, cmdCombined, pipePty)
+	err = sessionWrap.Start()
+	if err != nil {
+		pipePty.Close()
+		return nil, err
+	}
+	sp := makeShellProc(sessionWrap, conn.GetName(), cmdOpts)
+	sp.sshClient = client
+	sp.envReport = envReport
+	return sp, nil
+}
+
+// the shell gets a pty (so job control and line editing work), SessionWrap.SetSize forwards resizes
+// as window-change requests.  the session is closed if the server refuses
+func requestRemotePty(session *ssh.Session, termType string, termSize waveobj.TermSize) error {
+	if err := session.RequestPty(termType, termSize.Rows, termSize.Cols, ssh.TerminalModes{}); err != nil {
+		session.Close()
+		return fmt.Errorf("error requesting remote pty: %w", err)
+	}
+	return nil
+}
+
+func isZshShell(shellPath string) bool {
+	return shellutil.DetectFamily(shellPath) == shellutil.ShellFamily_Zsh
+}
+
+func isBashShell(shellPath string) bool {
+	return shellutil.DetectFamily(shellPath) == shellutil.ShellFamily_Bash
+}
+
+func isFishShell(shellPath string) bool {
+	return shellutil.DetectFamily(shellPath) == shellutil.ShellFamily_Fish
+}
+
+func isNuShell(shellPath string) bool {
+	return shellutil.DetectFamily(shellPath) == shellutil.ShellFamily_Nushell
+}
@@ -0,0 +1,212 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPipePty(t *testing.T) {
+	stdinRead, stdinWrite, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("error creating pipe: %v", err)
+	}
+	defer stdinRead.Close()
+	stdoutRead, stdoutWrite, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("error creating pipe: %v", err)
+	}
+	pp := &PipePty{remoteStdinWrite: stdinWrite, remoteStdoutRead: stdoutRead}
+	if _, err := pp.WriteString("input"); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(stdinRead, buf); err != nil || string(buf) != "input" {
+		t.Errorf("expected the input on the remote's stdin, got %q %v", buf, err)
+	}
+	stdoutWrite.Write([]byte("output"))
+	stdoutWrite.Close()
+	output, err := io.ReadAll(pp)
+	if err != nil || string(output) != "output" {
+		t.Errorf("expected the remote's stdout, got %q %v", output, err)
+	}
+	if pp.Fd() != stdinWrite.Fd() {
+		t.Errorf("the fd should be the remote's stdin")
+	}
+	if err := pp.Close(); err != nil {
+		t.Errorf("error closing: %v", err)
+	}
+	if _, err := pp.Write([]byte("x")); err == nil {
+		t.Errorf("expected an error writing after close")
+	}
+}
+
+func TestRemoteShellFamilies(t *testing.T) {
+	for _, tc := range []struct {
+		ShellPath string
+		Zsh       bool
+		Bash      bool
+		Fish      bool
+	}{
+		{"/bin/zsh", true, false, false},
+		{"/usr/local/bin/bash", false, true, false},
+		{"fish", false, false, true},
+		{"/bin/sh", false, false, false},
+	} {
+		if isZshShell(tc.ShellPath) != tc.Zsh || isBashShell(tc.ShellPath) != tc.Bash || isFishShell(tc.ShellPath) != tc.Fish {
+			t.Errorf("unexpected shell family for %q", tc.ShellPath)
+		}
+	}
+}
+
+// an in-process ssh server for one connection: "pty-req" is refused for over 1000 rows, pty sizes are sent
+// to sizeCh, "exec" of "exit N" exits with N and anything else closes the channel without a status
+func startTestSSHServer(t *testing.T, sizeCh chan string) *ssh.Client {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("error making signer: %v", err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+	// not a net.Pipe, both sides write their version first
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		serverSide, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(serverSide, serverConfig)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newCh := range chans {
+			ch, chReqs, err := newCh.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSHSession(ch, chReqs, sizeCh)
+		}
+	}()
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	if err != nil {
+		t.Fatalf("error connecting to the test ssh server: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func serveTestSSHSession(ch ssh.Channel, reqs <-chan *ssh.Request, sizeCh chan string) {
+	defer ch.Close()
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			var ptyReq struct {
+				Term                      string
+				Cols, Rows, Width, Height uint32
+				Modes                     string
+			}
+			ssh.Unmarshal(req.Payload, &ptyReq)
+			sizeCh <- fmt.Sprintf("pty %s %dx%d", ptyReq.Term, ptyReq.Rows, ptyReq.Cols)
+			req.Reply(ptyReq.Rows <= 1000, nil)
+		case "window-change":
+			var winReq struct{ Cols, Rows, Width, Height uint32 }
+			ssh.Unmarshal(req.Payload, &winReq)
+			sizeCh <- fmt.Sprintf("window %dx%d", winReq.Rows, winReq.Cols)
+		case "exec":
+			var execReq struct{ Command string }
+			ssh.Unmarshal(req.Payload, &execReq)
+			req.Reply(true, nil)
+			codeStr, ok := strings.CutPrefix(execReq.Command, "exit ")
+			if code, err := strconv.Atoi(codeStr); ok && err == nil {
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(code)}))
+			}
+			return
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func TestSessionWrapExitStatus(t *testing.T) {
+	sizeCh := make(chan string, 10)
+	client := startTestSSHServer(t, sizeCh)
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("error creating session: %v", err)
+	}
+	if err := requestRemotePty(session, "tmux-256color", waveobj.TermSize{Rows: 24, Cols: 80}); err != nil {
+		t.Fatalf("error requesting pty: %v", err)
+	}
+	if size := <-sizeCh; size != "pty tmux-256color 24x80" {
+		t.Errorf("unexpected pty request %q", size)
+	}
+	sw := MakeSessionWrap(session, "exit 3", nil)
+	if err := sw.SetSize(30, 100); err != nil {
+		t.Fatalf("error resizing: %v", err)
+	}
+	if size := <-sizeCh; size != "window 30x100" {
+		t.Errorf("unexpected window change %q", size)
+	}
+	if code := sw.ExitCode(); code != -1 {
+		t.Errorf("expected -1 before the session exited, got %d", code)
+	}
+	if err := sw.Start(); err != nil {
+		t.Fatalf("error starting: %v", err)
+	}
+	waitErr := sw.Wait()
+	if code := sw.ExitCode(); code != 3 || ExitCodeFromWaitErr(waitErr) != 3 {
+		t.Errorf("expected the remote exit status, got %d (%v)", code, waitErr)
+	}
+	if status := exitStatusFromWait(sw, waitErr); status.ExitCode != 3 || status.ConnectionLost {
+		t.Errorf("unexpected exit status %+v", status)
+	}
+
+	session, err = client.NewSession()
+	if err != nil {
+		t.Fatalf("error creating session: %v", err)
+	}
+	sw = MakeSessionWrap(session, "hang up", nil)
+	if err := sw.Start(); err != nil {
+		t.Fatalf("error starting: %v", err)
+	}
+	waitErr = sw.Wait()
+	if status := exitStatusFromWait(sw, waitErr); !status.ConnectionLost || status.ExitCode != -1 {
+		t.Errorf("expected ConnectionLost, got %+v (%v)", status, waitErr)
+	}
+}
+
+func TestRequestRemotePtyRefused(t *testing.T) {
+	client := startTestSSHServer(t, make(chan string, 10))
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("error creating session: %v", err)
+	}
+	if err := requestRemotePty(session, shellutil.DefaultTermType, waveobj.TermSize{Rows: 5000, Cols: 80}); err == nil {
+		t.Errorf("expected an error when the server refuses the pty")
+	}
+}
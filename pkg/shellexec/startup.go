@@ -0,0 +1,183 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+)
+
+// an OSC our prompt ready marker prints (ignored by the terminal), see CommandOptsType.MeasurePromptReady
+const promptReadyMarkerPayload = "16162;waveterm-prompt-ready"
+
+// sourced by the integration hooks right before the first prompt (bash, zsh and fish all have printf)
+const promptReadyMarkerCmd = `printf '\033]` + promptReadyMarkerPayload + `\007'` + "\n"
+
+// telemetry bucket upper bounds (the last bucket is open ended)
+var startupBuckets = []time.Duration{10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second}
+
+// StartupTimings are the phases of starting a shell (each relative to the end of the one
+// before).  The phases are only measured for local shells (remote shells just have
+// Start and FirstOutput), fields are zero until the phase has happened.
+type StartupTimings struct {
+	SessionId   string        `json:"sessionid"`
+	Start       time.Time     `json:"start"`                 // when StartShellProc was called
+	Detect      time.Duration `json:"detect"`                // shell detection and resolution
+	Prepare     time.Duration `json:"prepare"`               // args, env, history/pushenv/elevation/cgroup setup
+	PtyOpen     time.Duration `json:"ptyopen"`               // pty allocation (0 where it can't be split out of Exec, e.g. windows)
+	Exec        time.Duration `json:"exec"`                  // the start syscall (fork/exec)
+	FirstOutput time.Duration `json:"firstoutput,omitempty"` // from exec to the first byte read from the pty (mostly rc file cost)
+	PromptReady time.Duration `json:"promptready,omitempty"` // from exec to the first prompt (MeasurePromptReady only)
+}
+
+// startupTracker records the absolute times, output can arrive before StartShellProc is done recording the phases
+type startupTracker struct {
+	Lock        *sync.Mutex
+	Clock       clock
+	SessionId   string
+	Start       time.Time
+	DetectDone  time.Time
+	PrepareDone time.Time
+	PtyOpened   time.Time
+	Started     time.Time
+	FirstOutput time.Time
+	PromptReady time.Time
+	LogReady    bool // log the timings when the prompt is ready (the debug option)
+}
+
+func makeStartupTracker(clk clock, sessionId string) *startupTracker {
+	return &startupTracker{Lock: &sync.Mutex{}, Clock: clk, SessionId: sessionId, Start: clk.Now()}
+}
+
+func (st *startupTracker) mark(field *time.Time) {
+	now := st.Clock.Now()
+	st.Lock.Lock()
+	defer st.Lock.Unlock()
+	if field.IsZero() {
+		*field = now
+	}
+}
+
+// setPhases is for StartShellProc (its phases come before the shellproc exists)
+func (st *startupTracker) setPhases(start, detectDone, prepareDone, ptyOpened, started time.Time) {
+	st.Lock.Lock()
+	defer st.Lock.Unlock()
+	st.Start, st.DetectDone, st.PrepareDone, st.PtyOpened, st.Started = start, detectDone, prepareDone, ptyOpened, started
+}
+
+func (st *startupTracker) logWhenReady() {
+	st.Lock.Lock()
+	defer st.Lock.Unlock()
+	st.LogReady = true
+}
+
+func (st *startupTracker) promptReady() {
+	st.mark(&st.PromptReady)
+	st.Lock.Lock()
+	logReady := st.LogReady
+	st.LogReady = false
+	st.Lock.Unlock()
+	if logReady {
+		shellLogf(st.SessionId, "startup timings: %s\n", st.timings())
+	}
+}
+
+// returns 0 if either time is missing
+func sinceIfSet(from time.Time, to time.Time) time.Duration {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+func (st *startupTracker) timings() StartupTimings {
+	st.Lock.Lock()
+	defer st.Lock.Unlock()
+	started := st.Started
+	if started.IsZero() {
+		// remote shells, the session was already started when the shellproc was made
+		started = st.Start
+	}
+	return StartupTimings{
+		SessionId:   st.SessionId,
+		Start:       st.Start,
+		Detect:      sinceIfSet(st.Start, st.DetectDone),
+		Prepare:     sinceIfSet(st.DetectDone, st.PrepareDone),
+		PtyOpen:     sinceIfSet(st.PrepareDone, st.PtyOpened),
+		Exec:        sinceIfSet(st.PtyOpened, st.Started),
+		FirstOutput: sinceIfSet(started, st.FirstOutput),
+		PromptReady: sinceIfSet(started, st.PromptReady),
+	}
+}
+
+func (t StartupTimings) String() string {
+	return fmt.Sprintf("detect=%v prepare=%v ptyopen=%v exec=%v firstoutput=%v promptready=%v",
+		t.Detect, t.Prepare, t.PtyOpen, t.Exec, t.FirstOutput, t.PromptReady)
+}
+
+func startupBucket(d time.Duration) string {
+	lower := time.Duration(0)
+	for _, upper := range startupBuckets {
+		if d < upper {
+			return fmt.Sprintf("%d-%dms", lower.Milliseconds(), upper.Milliseconds())
+		}
+		lower = upper
+	}
+	return fmt.Sprintf("%dms+", lower.Milliseconds())
+}
+
+// TelemetryBuckets returns a count for the bucket of each measured phase, keyed
+// like "firstoutput:250-500ms" (for wshrpc.ActivityUpdate.ShellStartup)
+func (t StartupTimings) TelemetryBuckets() map[string]int {
+	rtn := make(map[string]int)
+	phases := []struct {
+		Name string
+		Dur  time.Duration
+	}{{"detect", t.Detect}, {"prepare", t.Prepare}, {"ptyopen", t.PtyOpen}, {"exec", t.Exec}, {"firstoutput", t.FirstOutput}, {"promptready", t.PromptReady}}
+	for _, phase := range phases {
+		if phase.Dur <= 0 {
+			continue
+		}
+		rtn[phase.Name+":"+startupBucket(phase.Dur)]++
+	}
+	return rtn
+}
+
+// StartupTimings returns how long each phase of starting the shell took (see StartupTimings)
+func (sp *ShellProc) StartupTimings() StartupTimings {
+	return sp.startup.timings()
+}
+
+// firstReadRecorder marks the first output read from the pty
+type firstReadRecorder struct {
+	Src     io.Reader
+	Tracker *startupTracker
+	Seen    bool // only touched by the output loop
+}
+
+func (r *firstReadRecorder) Read(p []byte) (int, error) {
+	nr, err := r.Src.Read(p)
+	if nr > 0 && !r.Seen {
+		r.Seen = true
+		r.Tracker.mark(&r.Tracker.FirstOutput)
+	}
+	return nr, err
+}
+
+func isPromptReadyMarker(tok seqToken) bool {
+	return tok.Type == tokType_OSC && !tok.Overflow && !tok.Unterminated && bytes.Equal(tok.Payload, []byte(promptReadyMarkerPayload))
+}
+
+// queues the prompt ready marker through the pushenv file, which our hooks source right before the first prompt
+func queuePromptReadyMarker(target *pushEnvTarget) error {
+	target.Lock.Lock()
+	defer target.Lock.Unlock()
+	return shellutil.AppendPushEnvFile(target.Path, promptReadyMarkerCmd)
+}
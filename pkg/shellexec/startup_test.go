@@ -0,0 +1,61 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestStartupTimings(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no ptys on windows")
+	}
+	sp := startTestShellProc(t, "echo started", CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "started")
+	timings := sp.StartupTimings()
+	if timings.Start.IsZero() || time.Since(timings.Start) <= 0 {
+		t.Errorf("bad start time %v", timings.Start)
+	}
+	if timings.Detect <= 0 || timings.Prepare <= 0 || timings.PtyOpen <= 0 || timings.Exec <= 0 || timings.FirstOutput <= 0 {
+		t.Errorf("expected every phase to be measured, got %s", timings)
+	}
+	if timings.PromptReady != 0 {
+		t.Errorf("prompt ready should only be measured with MeasurePromptReady, got %v", timings.PromptReady)
+	}
+	total := timings.Detect + timings.Prepare + timings.PtyOpen + timings.Exec + timings.FirstOutput
+	if elapsed := time.Since(timings.Start); total > elapsed {
+		t.Errorf("phases (%v) add up to more than the time since start (%v)", total, elapsed)
+	}
+}
+
+func TestStartupTimingsPromptReady(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no ptys on windows")
+	}
+	sp := startTestShellProc(t, "", CommandOptsType{MeasurePromptReady: true})
+	oc := collectOutput(sp)
+	sp.Write([]byte("echo sta''rted\r"))
+	oc.waitFor(t, "started")
+	timings := sp.StartupTimings()
+	if timings.PromptReady <= 0 || timings.PromptReady < timings.FirstOutput {
+		t.Errorf("expected prompt ready after the first output, got %s", timings)
+	}
+}
+
+func TestStartupTelemetryBuckets(t *testing.T) {
+	timings := StartupTimings{Detect: 2 * time.Millisecond, Exec: 5 * time.Millisecond, FirstOutput: 300 * time.Millisecond, PromptReady: 10 * time.Second}
+	buckets := timings.TelemetryBuckets()
+	expected := map[string]int{"detect:0-10ms": 1, "exec:0-10ms": 1, "firstoutput:250-500ms": 1, "promptready:5000ms+": 1}
+	if len(buckets) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, buckets)
+	}
+	for key, count := range expected {
+		if buckets[key] != count {
+			t.Errorf("expected %v, got %v", expected, buckets)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package shellexec
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+// windows allocates the ConPTY as part of starting the process, so a new run can't
+// reuse the pty of the last one
+const supervisorSupported = false
+
+func openSupervisedPty(size *pty.Winsize) (pty.Pty, pty.Tty, error) {
+	return nil, nil, fmt.Errorf("supervised commands are not supported on this platform")
+}
+
+func startOnTty(ecmd *exec.Cmd, holdTty pty.Tty) error {
+	return fmt.Errorf("supervised commands are not supported on this platform")
+}
@@ -0,0 +1,44 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+const supervisorSupported = true
+
+func openSupervisedPty(size *pty.Winsize) (pty.Pty, pty.Tty, error) {
+	cmdPty, cmdTty, err := pty.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := pty.Setsize(cmdPty, size); err != nil {
+		cmdPty.Close()
+		cmdTty.Close()
+		return nil, nil, err
+	}
+	return cmdPty, cmdTty, nil
+}
+
+// starts ecmd as a session leader with the tty as its controlling terminal.  every run
+// gets its own fd for the tty (the one we hold stays ours)
+func startOnTty(ecmd *exec.Cmd, holdTty pty.Tty) error {
+	runTty, err := os.OpenFile(holdTty.Name(), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	defer runTty.Close()
+	ecmd.Stdin = runTty
+	ecmd.Stdout = runTty
+	ecmd.Stderr = runTty
+	ecmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	return ecmd.Start()
+}
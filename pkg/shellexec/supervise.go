@@ -0,0 +1,470 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+)
+
+const (
+	RestartPolicy_Always    = "always"
+	RestartPolicy_OnFailure = "onfailure" // restart unless the command exited with code 0 (the default)
+	RestartPolicy_Never     = "never"     // only restarted by ShellProc.Restart
+)
+
+const (
+	DefaultRestartBackoff    = time.Second
+	DefaultMaxRestartBackoff = 30 * time.Second
+	DefaultRestartResetAfter = 10 * time.Second
+	DefaultMaxRestarts       = 10
+	DefaultRestartWindow     = 5 * time.Minute
+)
+
+// the runs kept for ShellProc.RunHistory
+const maxRunHistory = 100
+
+const restartStatusFmt = "\r\n\x1b[2m[waveterm] %s, restarting in %v, attempt %d\x1b[0m\r\n"
+const restartGiveUpFmt = "\r\n\x1b[2m[waveterm] %s, %d restarts in %v, not restarting again\x1b[0m\r\n"
+
+var ErrNotSupervised = errors.New("shell is not supervised")
+
+// SupervisorOpts configures supervised mode (CommandOptsType.Supervise): the command
+// is relaunched in the same pty when it exits, the ShellProc stays running (and its
+// output stream continues) until supervision stops.  Zero values use the defaults.
+type SupervisorOpts struct {
+	RestartPolicy string `json:"restartPolicy,omitempty"` // RestartPolicy_*
+
+	// the delay before the first restart, doubled for every consecutive restart up to MaxBackoff.
+	// a run that lasts at least ResetAfter resets it
+	Backoff    time.Duration `json:"backoff,omitempty"`
+	MaxBackoff time.Duration `json:"maxBackoff,omitempty"`
+	ResetAfter time.Duration `json:"resetAfter,omitempty"`
+
+	// supervision stops (the ShellProc exits) if the command has been restarted MaxRestarts times within RestartWindow
+	MaxRestarts   int           `json:"maxRestarts,omitempty"`
+	RestartWindow time.Duration `json:"restartWindow,omitempty"`
+}
+
+// RunRecord is one run of a supervised command
+type RunRecord struct {
+	Run        int        `json:"run"` // 1 for the first run
+	StartTs    int64      `json:"startts"`
+	EndTs      int64      `json:"endts"`
+	ExitStatus ExitStatus `json:"exitstatus"`
+}
+
+// RestartInfo is set on EventKind_Restart events
+type RestartInfo struct {
+	Attempt  int        `json:"attempt"`  // consecutive restarts since the backoff was last reset
+	Restarts int        `json:"restarts"` // restarts of this shellproc so far (including this one)
+	DelayMs  int64      `json:"delayms"`
+	Manual   bool       `json:"manual,omitempty"` // requested with ShellProc.Restart
+	LastExit ExitStatus `json:"lastexit"`
+}
+
+// checks cmdOpts.Supervise (if set) and fills in its defaults
+func resolveSupervisorOpts(cmdOpts *CommandOptsType, hasCmd bool) error {
+	if cmdOpts.Supervise == nil {
+		return nil
+	}
+	if !supervisorSupported {
+		return fmt.Errorf("Supervise is not supported on this platform")
+	}
+	if !hasCmd {
+		return fmt.Errorf("Supervise requires a command")
+	}
+	if cmdOpts.StartSuspended || cmdOpts.Elevate || cmdOpts.ScopedCgroup || cmdOptsLimits(*cmdOpts).isSet() {
+		return fmt.Errorf("Supervise cannot be combined with StartSuspended, Elevate or cgroups")
+	}
+	opts, err := resolveRestartOpts(*cmdOpts.Supervise)
+	if err != nil {
+		return err
+	}
+	cmdOpts.Supervise = &opts // the caller's struct is left alone
+	return nil
+}
+
+// fills in the defaults of the restart policy and backoff (also used by ShellSupervisor)
+func resolveRestartOpts(opts SupervisorOpts) (SupervisorOpts, error) {
+	switch opts.RestartPolicy {
+	case "":
+		opts.RestartPolicy = RestartPolicy_OnFailure
+	case RestartPolicy_Always, RestartPolicy_OnFailure, RestartPolicy_Never:
+	default:
+		return opts, fmt.Errorf("invalid restart policy %q", opts.RestartPolicy)
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultRestartBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxRestartBackoff
+	}
+	if opts.MaxBackoff < opts.Backoff {
+		opts.MaxBackoff = opts.Backoff
+	}
+	if opts.ResetAfter <= 0 {
+		opts.ResetAfter = DefaultRestartResetAfter
+	}
+	if opts.MaxRestarts <= 0 {
+		opts.MaxRestarts = DefaultMaxRestarts
+	}
+	if opts.RestartWindow <= 0 {
+		opts.RestartWindow = DefaultRestartWindow
+	}
+	return opts, nil
+}
+
+func (opts SupervisorOpts) backoff(attempt int) time.Duration {
+	delay := opts.Backoff
+	for i := 1; i < attempt && delay < opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, opts.MaxBackoff)
+}
+
+// a fresh copy of a command that hasn't been started (an exec.Cmd can only run once)
+func cloneCmd(ecmd *exec.Cmd) *exec.Cmd {
+	return &exec.Cmd{Path: ecmd.Path, Args: append([]string(nil), ecmd.Args...), Env: append([]string(nil), ecmd.Env...), Dir: ecmd.Dir}
+}
+
+// supervisedCmd is the ConnInterface of a supervised shellproc.  Every run is its
+// own CmdWrap (and session), Wait only returns once supervision has stopped.  We
+// hold the tty open between runs so the pty doesn't hang up (which would end the
+// output stream).
+type supervisedCmd struct {
+	Lock     *sync.Mutex
+	Clock    clock
+	Opts     SupervisorOpts
+	Template *exec.Cmd // cloned for every run
+	Tty      pty.Tty   // held open until supervision stops
+	Run      CmdWrap   // the current (or last) run
+	RunStart time.Time
+	Runs     int
+	restartState
+	History   []RunRecord
+	Stopped   bool
+	Waiting   bool // in the backoff delay
+	Manual    bool // Restart was called while a run was active
+	SessionId string
+	Nice      int // set on every restarted run (startLocalProc sets the first run's), see setShellPriority
+	WakeCh    chan struct{}
+	DoneCh    chan struct{}
+	WaitErr   error // synchronized by DoneCh
+	pty.Pty
+}
+
+// starts the first run (with the template itself), returns when the pty was opened.  ttyInit is set
+// once, the later runs get the tty as the previous run left it
+func startSupervised(clk clock, ecmd *exec.Cmd, size *pty.Winsize, opts SupervisorOpts, ttyInit *TtyInitOpts) (*supervisedCmd, time.Time, error) {
+	template := cloneCmd(ecmd)
+	cmdPty, cmdTty, err := openSupervisedPty(size)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	ptyOpened := clk.Now()
+	if ttyInit != nil {
+		if err := setInitialTtyModes(cmdTty.Fd(), ttyInit); err != nil {
+			cmdPty.Close()
+			cmdTty.Close()
+			return nil, ptyOpened, fmt.Errorf("error setting initial tty modes: %w", err)
+		}
+	}
+	if err := startOnTty(ecmd, cmdTty); err != nil {
+		cmdPty.Close()
+		cmdTty.Close()
+		return nil, ptyOpened, err
+	}
+	sc := &supervisedCmd{
+		Lock:     &sync.Mutex{},
+		Clock:    clk,
+		Opts:     opts,
+		Template: template,
+		Tty:      cmdTty,
+		Run:      MakeCmdWrap(ecmd, cmdPty),
+		RunStart: clk.Now(),
+		Runs:     1,
+		WakeCh:   make(chan struct{}, 1),
+		DoneCh:   make(chan struct{}),
+		Pty:      cmdPty,
+	}
+	return sc, ptyOpened, nil
+}
+
+func (sc *supervisedCmd) currentRun() CmdWrap {
+	sc.Lock.Lock()
+	defer sc.Lock.Unlock()
+	return sc.Run
+}
+
+func (sc *supervisedCmd) isWaiting() bool {
+	sc.Lock.Lock()
+	defer sc.Lock.Unlock()
+	return sc.Waiting
+}
+
+func (sc *supervisedCmd) wake() {
+	select {
+	case sc.WakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (sc *supervisedCmd) stop() CmdWrap {
+	sc.Lock.Lock()
+	defer sc.Lock.Unlock()
+	sc.Stopped = true
+	sc.wake()
+	return sc.Run
+}
+
+func (sc *supervisedCmd) Kill() {
+	sc.stop().Kill()
+}
+
+func (sc *supervisedCmd) KillGraceful(timeout time.Duration) {
+	sc.stop().KillGraceful(timeout)
+}
+
+// returns once supervision has stopped, with the last run's wait error
+func (sc *supervisedCmd) Wait() error {
+	<-sc.DoneCh
+	return sc.WaitErr
+}
+
+func (sc *supervisedCmd) Start() error {
+	return fmt.Errorf("supervised command is already started")
+}
+
+// the last run's exit code
+func (sc *supervisedCmd) ExitCode() int {
+	return sc.currentRun().ExitCode()
+}
+
+func (sc *supervisedCmd) StdinPipe() (io.WriteCloser, error) {
+	return nil, fmt.Errorf("supervised command has no pipes")
+}
+
+func (sc *supervisedCmd) StdoutPipe() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("supervised command has no pipes")
+}
+
+func (sc *supervisedCmd) StderrPipe() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("supervised command has no pipes")
+}
+
+func (sc *supervisedCmd) SetSize(w int, h int) error {
+	return pty.Setsize(sc.Pty, &pty.Winsize{Rows: uint16(w), Cols: uint16(h)})
+}
+
+type restartDecision struct {
+	Restart bool
+	GaveUp  bool
+	Info    RestartInfo
+}
+
+// records the run that just exited and decides what happens next
+func (sc *supervisedCmd) runExited(status ExitStatus) restartDecision {
+	sc.Lock.Lock()
+	defer sc.Lock.Unlock()
+	now := sc.Clock.Now()
+	sc.History = append(sc.History, RunRecord{Run: sc.Runs, StartTs: sc.RunStart.UnixMilli(), EndTs: now.UnixMilli(), ExitStatus: status})
+	if len(sc.History) > maxRunHistory {
+		sc.History = sc.History[len(sc.History)-maxRunHistory:]
+	}
+	if sc.Stopped {
+		return restartDecision{}
+	}
+	if sc.Manual {
+		sc.Manual = false
+		sc.Attempt = 0
+		sc.RestartTimes = nil
+		sc.Restarts++
+		return restartDecision{Restart: true, Info: RestartInfo{Restarts: sc.Restarts, Manual: true, LastExit: status}}
+	}
+	decision := sc.restartState.next(sc.Opts, status, sc.RunStart, now)
+	sc.Waiting = decision.Restart
+	return decision
+}
+
+// the restart bookkeeping shared by supervisedCmd and ShellSupervisor
+type restartState struct {
+	Restarts     int
+	Attempt      int         // for the backoff
+	RestartTimes []time.Time // within RestartWindow, for MaxRestarts
+}
+
+// applies the restart policy to a run (started at runStart) that exited with status
+func (rs *restartState) next(opts SupervisorOpts, status ExitStatus, runStart time.Time, now time.Time) restartDecision {
+	if opts.RestartPolicy == RestartPolicy_Never || (opts.RestartPolicy == RestartPolicy_OnFailure && status.ExitCode == 0) {
+		return restartDecision{}
+	}
+	if now.Sub(runStart) >= opts.ResetAfter {
+		rs.Attempt = 0
+	}
+	for len(rs.RestartTimes) > 0 && now.Sub(rs.RestartTimes[0]) > opts.RestartWindow {
+		rs.RestartTimes = rs.RestartTimes[1:]
+	}
+	if len(rs.RestartTimes) >= opts.MaxRestarts {
+		return restartDecision{GaveUp: true}
+	}
+	rs.RestartTimes = append(rs.RestartTimes, now)
+	rs.Attempt++
+	rs.Restarts++
+	delay := opts.backoff(rs.Attempt)
+	return restartDecision{Restart: true, Info: RestartInfo{Attempt: rs.Attempt, Restarts: rs.Restarts, DelayMs: delay.Milliseconds(), LastExit: status}}
+}
+
+// waits out the backoff delay (cut short by Restart or a stop), returns false if supervision was stopped
+func (sc *supervisedCmd) sleep(delay time.Duration) bool {
+	if delay > 0 {
+		timerCh, stopFn := sc.Clock.NewTimer(delay)
+		defer stopFn()
+		select {
+		case <-timerCh:
+		case <-sc.WakeCh:
+		}
+	}
+	sc.Lock.Lock()
+	defer sc.Lock.Unlock()
+	sc.Waiting = false
+	return !sc.Stopped
+}
+
+func (sc *supervisedCmd) startRun() error {
+	ecmd := cloneCmd(sc.Template)
+	if err := startOnTty(ecmd, sc.Tty); err != nil {
+		return err
+	}
+	if sc.Nice != 0 {
+		setShellPriority(sc.SessionId, ecmd.Process.Pid, sc.Nice)
+	}
+	sc.Lock.Lock()
+	defer sc.Lock.Unlock()
+	sc.Run = MakeCmdWrap(ecmd, sc.Pty)
+	sc.RunStart = sc.Clock.Now()
+	sc.Runs++
+	if sc.Stopped {
+		// stopped while starting, the new run didn't see the kill
+		sc.Run.KillGraceful(DefaultGracefulKillWait)
+	}
+	return nil
+}
+
+func (sc *supervisedCmd) finish(waitErr error) {
+	sc.Lock.Lock()
+	sc.Stopped = true
+	sc.Lock.Unlock()
+	sc.WaitErr = waitErr
+	// the pty hangs up once the last run's processes are gone, which ends the output
+	sc.Tty.Close()
+	close(sc.DoneCh)
+}
+
+func describeExit(status ExitStatus) string {
+	if status.Signal != "" {
+		return "killed by " + status.Signal
+	}
+	return fmt.Sprintf("exited with code %d", status.ExitCode)
+}
+
+func (sc *supervisedCmd) runLoop(sp *ShellProc) {
+	for {
+		run := sc.currentRun()
+		waitErr := run.Wait()
+		status := exitStatusFromWait(run, waitErr)
+		shellRegistry.runEnded(sp, run.Cmd.Process.Pid)
+		decision := sc.runExited(status)
+		if decision.GaveUp {
+			sp.logf("supervised command %s, giving up after %d restarts in %v\n", describeExit(status), sc.Opts.MaxRestarts, sc.Opts.RestartWindow)
+			sp.injectOutput([]byte(fmt.Sprintf(restartGiveUpFmt, describeExit(status), sc.Opts.MaxRestarts, sc.Opts.RestartWindow)))
+		}
+		if !decision.Restart {
+			sc.finish(waitErr)
+			return
+		}
+		delay := time.Duration(decision.Info.DelayMs) * time.Millisecond
+		sp.events.publish(ShellEvent{Kind: EventKind_Restart, Restart: &decision.Info})
+		if !decision.Info.Manual {
+			sp.injectOutput([]byte(fmt.Sprintf(restartStatusFmt, describeExit(status), delay, decision.Info.Attempt)))
+		}
+		if !sc.sleep(delay) {
+			sc.finish(waitErr)
+			return
+		}
+		if err := sc.startRun(); err != nil {
+			sp.logf("cannot restart supervised command: %v\n", err)
+			sp.injectOutput([]byte(fmt.Sprintf("\r\n[waveterm] cannot restart the command: %v\r\n", err)))
+			sc.finish(waitErr)
+			return
+		}
+		shellRegistry.register(sp)
+	}
+}
+
+func (sc *supervisedCmd) restart() error {
+	sc.Lock.Lock()
+	defer sc.Lock.Unlock()
+	if sc.Stopped {
+		return ErrShellExited
+	}
+	if sc.Waiting {
+		// the decision has been made, just skip the delay (and forget the backoff)
+		sc.Attempt = 0
+		sc.RestartTimes = nil
+		sc.wake()
+		return nil
+	}
+	sc.Manual = true
+	sc.Run.KillGraceful(DefaultGracefulKillWait)
+	return nil
+}
+
+func (sc *supervisedCmd) runHistory() []RunRecord {
+	sc.Lock.Lock()
+	defer sc.Lock.Unlock()
+	return append([]RunRecord(nil), sc.History...)
+}
+
+func (sp *ShellProc) startSupervisor() {
+	sc, ok := sp.Cmd.(*supervisedCmd)
+	if !ok {
+		return
+	}
+	go func() {
+		defer panichandler.PanicHandler("ShellProc:supervisor")
+		sc.runLoop(sp)
+	}()
+}
+
+// Restart relaunches a supervised command now (see CommandOptsType.Supervise), the
+// current run is killed (gracefully) and the backoff is reset.  Works whatever the
+// RestartPolicy, returns ErrNotSupervised for other shells and ErrShellExited once
+// supervision has stopped.
+func (sp *ShellProc) Restart() error {
+	sc, ok := sp.Cmd.(*supervisedCmd)
+	if !ok {
+		return ErrNotSupervised
+	}
+	return sc.restart()
+}
+
+// RunHistory returns the completed runs of a supervised command (the last
+// maxRunHistory), oldest first.  nil for other shells, use ExitStatus for them.
+// A supervised ShellProc keeps running across restarts, its own ExitStatus is the
+// last run's once supervision has stopped.
+func (sp *ShellProc) RunHistory() []RunRecord {
+	sc, ok := sp.Cmd.(*supervisedCmd)
+	if !ok {
+		return nil
+	}
+	return sc.runHistory()
+}
@@ -0,0 +1,208 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+func startSupervisedShellProc(t *testing.T, cmdStr string, opts SupervisorOpts) *ShellProc {
+	t.Helper()
+	if !supervisorSupported {
+		t.Skip("supervised commands are not supported on this platform")
+	}
+	return startTestShellProc(t, cmdStr, CommandOptsType{Supervise: &opts})
+}
+
+func waitRestart(t *testing.T, eventCh <-chan ShellEvent) RestartInfo {
+	t.Helper()
+	event := waitEventKind(t, eventCh, EventKind_Restart)
+	return *event.Restart
+}
+
+func TestSupervisedBackoff(t *testing.T) {
+	sp := startSupervisedShellProc(t, `echo "run-$$"; exit 3`, SupervisorOpts{Backoff: 20 * time.Millisecond, MaxRestarts: 3, RestartWindow: time.Minute})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	oc := collectOutput(sp)
+	startWaitLoop(sp)
+	for attempt, delay := range []int64{20, 40, 80} {
+		info := waitRestart(t, eventCh)
+		if info.Attempt != attempt+1 || info.Restarts != attempt+1 || info.DelayMs != delay || info.LastExit.ExitCode != 3 || info.Manual {
+			t.Errorf("unexpected restart %+v", info)
+		}
+		if _, done := sp.ExitStatus(); done {
+			t.Errorf("the shellproc should keep running across restarts")
+		}
+	}
+	exitEvent := waitEventKind(t, eventCh, EventKind_Exit)
+	if exitEvent.ExitStatus.ExitCode != 3 {
+		t.Errorf("expected the last run's exit code, got %+v", exitEvent.ExitStatus)
+	}
+	<-oc.Done
+	output := oc.String()
+	if strings.Count(output, "run-") != 4 {
+		t.Errorf("expected 4 runs in the output, got %q", output)
+	}
+	for _, line := range []string{"exited with code 3, restarting in 20ms, attempt 1", "restarting in 80ms, attempt 3", "3 restarts in 1m0s, not restarting again"} {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected %q in the output, got %q", line, output)
+		}
+	}
+	history := sp.RunHistory()
+	if len(history) != 4 {
+		t.Fatalf("expected 4 runs, got %+v", history)
+	}
+	for idx, rec := range history {
+		if rec.Run != idx+1 || rec.ExitStatus.ExitCode != 3 || rec.EndTs < rec.StartTs {
+			t.Errorf("unexpected run record %+v", rec)
+		}
+	}
+}
+
+func TestSupervisedRestartPolicy(t *testing.T) {
+	sp := startSupervisedShellProc(t, `exit 0`, SupervisorOpts{Backoff: 10 * time.Millisecond})
+	startWaitLoop(sp)
+	waitDone(t, sp)
+	if history := sp.RunHistory(); len(history) != 1 {
+		t.Errorf("OnFailure should not restart a clean exit, got %+v", history)
+	}
+
+	sp = startSupervisedShellProc(t, `exit 0`, SupervisorOpts{RestartPolicy: RestartPolicy_Always, Backoff: 10 * time.Millisecond, MaxRestarts: 2})
+	startWaitLoop(sp)
+	waitDone(t, sp)
+	if history := sp.RunHistory(); len(history) != 3 {
+		t.Errorf("Always should restart a clean exit, got %+v", history)
+	}
+	if sp.Restart() != ErrShellExited {
+		t.Errorf("expected ErrShellExited restarting after supervision stopped")
+	}
+}
+
+func TestSupervisedClose(t *testing.T) {
+	// during a run
+	sp := startSupervisedShellProc(t, `echo started; sleep 30`, SupervisorOpts{RestartPolicy: RestartPolicy_Always})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	collectOutput(sp).waitFor(t, "started")
+	sp.Close()
+	for _, event := range readAllEvents(t, eventCh) {
+		if event.Kind == EventKind_Restart {
+			t.Errorf("Close should not restart the command")
+		}
+	}
+	waitDone(t, sp)
+
+	// during the backoff delay
+	sp = startSupervisedShellProc(t, `exit 1`, SupervisorOpts{Backoff: time.Minute})
+	eventCh, unsubFn = sp.SubscribeEvents(0)
+	defer unsubFn()
+	waitRestart(t, eventCh)
+	sp.Close()
+	waitEventKind(t, eventCh, EventKind_Exit)
+	if history := sp.RunHistory(); len(history) != 1 {
+		t.Errorf("expected a single run, got %+v", history)
+	}
+}
+
+func TestSupervisedManualRestart(t *testing.T) {
+	sp := startSupervisedShellProc(t, `echo "started-$$"; sleep 30`, SupervisorOpts{RestartPolicy: RestartPolicy_Never})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	oc := collectOutput(sp)
+	oc.waitFor(t, "started-")
+	firstPid := sp.localPid()
+	if err := sp.Restart(); err != nil {
+		t.Fatalf("error restarting: %v", err)
+	}
+	if info := waitRestart(t, eventCh); !info.Manual || info.DelayMs != 0 || info.LastExit.Signal != "SIGTERM" {
+		t.Errorf("unexpected manual restart %+v", info)
+	}
+	// the event is published before the new run is started
+	secondPid := sp.localPid()
+	for deadline := time.Now().Add(testWaitTimeout); secondPid == firstPid && time.Now().Before(deadline); secondPid = sp.localPid() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if secondPid == firstPid {
+		t.Fatalf("expected a new process for the new run")
+	}
+	oc.waitFor(t, "started-"+strconv.Itoa(secondPid))
+	sessions := shellRegistry.snapshot()
+	if sessions[firstPid].Proc != nil || sessions[secondPid].Proc != sp {
+		t.Errorf("every run should be its own registry session")
+	}
+	if GetBySessionID(sp.SessionID()) != sp {
+		t.Errorf("the shellproc should still be registered")
+	}
+	// the pty carries over (resizes reach the new run)
+	if err := sp.SetTermSize(waveobj.TermSize{Rows: 30, Cols: 90}); err != nil {
+		t.Errorf("error resizing: %v", err)
+	}
+
+	if (&ShellProc{Cmd: CmdWrap{}}).Restart() != ErrNotSupervised {
+		t.Errorf("expected ErrNotSupervised")
+	}
+}
+
+func TestSupervisedRestartResetsBackoff(t *testing.T) {
+	sp := startSupervisedShellProc(t, `exit 1`, SupervisorOpts{Backoff: 50 * time.Millisecond, MaxBackoff: time.Minute})
+	eventCh, unsubFn := sp.SubscribeEvents(0)
+	defer unsubFn()
+	for i := 0; i < 2; i++ {
+		waitRestart(t, eventCh)
+	}
+	if info := waitRestart(t, eventCh); info.Attempt != 3 || info.DelayMs != 200 {
+		t.Fatalf("unexpected restart %+v", info)
+	}
+	// skips the 200ms delay and starts the backoff over
+	restartTs := time.Now()
+	if err := sp.Restart(); err != nil {
+		t.Fatalf("error restarting: %v", err)
+	}
+	if info := waitRestart(t, eventCh); info.Attempt != 1 || info.DelayMs != 50 {
+		t.Errorf("expected the backoff to be reset, got %+v", info)
+	}
+	if elapsed := time.Since(restartTs); elapsed > 150*time.Millisecond {
+		t.Errorf("Restart should skip the delay, took %v", elapsed)
+	}
+}
+
+func TestSupervisedOpts(t *testing.T) {
+	if !supervisorSupported {
+		t.Skip("supervised commands are not supported on this platform")
+	}
+	termSize := waveobj.TermSize{Rows: 24, Cols: 80}
+	for _, tc := range []struct {
+		CmdStr  string
+		CmdOpts CommandOptsType
+	}{
+		{"", CommandOptsType{Supervise: &SupervisorOpts{}}},
+		{"true", CommandOptsType{Supervise: &SupervisorOpts{RestartPolicy: "sometimes"}}},
+		{"true", CommandOptsType{Supervise: &SupervisorOpts{}, StartSuspended: true}},
+		{"true", CommandOptsType{Supervise: &SupervisorOpts{}, ScopedCgroup: true}},
+	} {
+		if _, err := StartShellProc(termSize, tc.CmdStr, tc.CmdOpts); err == nil {
+			t.Errorf("expected an error for %q %+v", tc.CmdStr, tc.CmdOpts.Supervise)
+		}
+	}
+	opts := SupervisorOpts{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if delay := opts.backoff(attempt + 1); delay != expected {
+			t.Errorf("attempt %d: expected %v, got %v", attempt+1, expected, delay)
+		}
+	}
+	callerOpts := &SupervisorOpts{}
+	cmdOpts := CommandOptsType{Supervise: callerOpts}
+	if err := resolveSupervisorOpts(&cmdOpts, true); err != nil || cmdOpts.Supervise.RestartPolicy != RestartPolicy_OnFailure {
+		t.Errorf("expected the defaults, got %+v %v", cmdOpts.Supervise, err)
+	}
+	if callerOpts.RestartPolicy != "" {
+		t.Errorf("the caller's opts should not be modified")
+	}
+}
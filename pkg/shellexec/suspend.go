@@ -0,0 +1,95 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+)
+
+const trampolineShellPath = "/bin/sh"
+
+// waits for a line on the release fd before exec'ing the real shell.  if the
+// release fd is closed without a line (the shellproc was closed before being
+// released) the trampoline exits without ever running the shell.
+const trampolineScript = `IFS= read -r _ <&%d || exit 1; exec %d<&-; exec "$@"`
+
+// makeSuspendTrampoline rewrites ecmd to start through the trampoline, returns
+// the read end of the release pipe (to be closed after start) and the release gate for the shellproc
+func makeSuspendTrampoline(ecmd *exec.Cmd) (*os.File, *releaseGate, error) {
+	if runtime.GOOS == "windows" {
+		return nil, nil, fmt.Errorf("StartSuspended is not supported on windows")
+	}
+	releaseRead, releaseWrite, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating release pipe: %w", err)
+	}
+	ecmd.ExtraFiles = append(ecmd.ExtraFiles, releaseRead)
+	releaseFd := 2 + len(ecmd.ExtraFiles)
+	script := fmt.Sprintf(trampolineScript, releaseFd, releaseFd)
+	args := append([]string{"sh", "-c", script, "sh", ecmd.Path}, ecmd.Args[1:]...)
+	ecmd.Path = trampolineShellPath
+	ecmd.Args = args
+	return releaseRead, &releaseGate{Lock: &sync.Mutex{}, File: releaseWrite}, nil
+}
+
+// releaseGate holds the write end of the trampoline's release pipe
+type releaseGate struct {
+	Lock     *sync.Mutex
+	File     *os.File
+	Released bool
+	Closed   bool
+}
+
+// Release lets a shell started with StartSuspended run.  Releasing a shell
+// that is already running returns nil.
+func (sp *ShellProc) Release() error {
+	if sp.release == nil {
+		return nil
+	}
+	gate := sp.release
+	gate.Lock.Lock()
+	defer gate.Lock.Unlock()
+	if gate.Released {
+		return nil
+	}
+	if gate.Closed {
+		return fmt.Errorf("shell was closed before it was released")
+	}
+	gate.Released = true
+	gate.Closed = true
+	defer gate.File.Close()
+	if _, err := gate.File.Write([]byte("\n")); err != nil {
+		return fmt.Errorf("error releasing shell: %w", err)
+	}
+	return nil
+}
+
+// closes the release pipe without releasing the shell (the trampoline exits without running it)
+func (sp *ShellProc) abortRelease() {
+	if sp.release == nil {
+		return
+	}
+	gate := sp.release
+	gate.Lock.Lock()
+	defer gate.Lock.Unlock()
+	if gate.Closed {
+		return
+	}
+	gate.Closed = true
+	gate.File.Close()
+}
+
+// IsSuspended returns true if the shell was started with StartSuspended and has not been released
+func (sp *ShellProc) IsSuspended() bool {
+	if sp.release == nil {
+		return false
+	}
+	sp.release.Lock.Lock()
+	defer sp.release.Lock.Unlock()
+	return !sp.release.Released
+}
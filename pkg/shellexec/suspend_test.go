@@ -0,0 +1,75 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// starts a suspended interactive bash with an rc file that creates rcMarker
+func startSuspendedTestShell(t *testing.T, cmdStr string) (*ShellProc, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("StartSuspended is not supported on windows")
+	}
+	tmpDir := t.TempDir()
+	rcMarker := filepath.Join(tmpDir, "rc-ran")
+	rcFile := filepath.Join(tmpDir, "rcfile")
+	if err := os.WriteFile(rcFile, []byte(fmt.Sprintf("touch '%s'\necho rc-ran\n", rcMarker)), 0644); err != nil {
+		t.Fatalf("error writing rc file: %v", err)
+	}
+	cmdOpts := CommandOptsType{StartSuspended: true, ShellOpts: []string{"--rcfile", rcFile, "-i"}}
+	sp := startTestShellProc(t, cmdStr, cmdOpts)
+	return sp, rcMarker
+}
+
+func TestStartSuspended(t *testing.T) {
+	sp, rcMarker := startSuspendedTestShell(t, "echo shell-started; read x")
+	oc := collectOutput(sp)
+	if !sp.IsSuspended() {
+		t.Errorf("shell should be suspended")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := os.Stat(rcMarker); err == nil {
+		t.Fatalf("rc file ran before Release")
+	}
+	if strings.Contains(oc.String(), "shell-started") {
+		t.Fatalf("shell ran before Release, output: %q", oc.String())
+	}
+	if err := sp.Release(); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+	oc.waitFor(t, "shell-started")
+	if _, err := os.Stat(rcMarker); err != nil {
+		t.Errorf("rc file did not run after Release: %v", err)
+	}
+	if sp.IsSuspended() {
+		t.Errorf("shell should not be suspended after Release")
+	}
+	if err := sp.Release(); err != nil {
+		t.Errorf("second Release should be a no-op, got: %v", err)
+	}
+}
+
+func TestCloseUnreleased(t *testing.T) {
+	sp, rcMarker := startSuspendedTestShell(t, "echo shell-started")
+	oc := collectOutput(sp)
+	sp.Close()
+	select {
+	case <-sp.DoneCh:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting for an unreleased shell to close")
+	}
+	if err := sp.Release(); err == nil {
+		t.Errorf("Release after Close should fail")
+	}
+	if _, err := os.Stat(rcMarker); err == nil || strings.Contains(oc.String(), "shell-started") {
+		t.Errorf("shell ran even though it was never released")
+	}
+}
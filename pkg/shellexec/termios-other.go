@@ -0,0 +1,14 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package shellexec
+
+func getTtyModes(fd uintptr) (TtyModes, error) {
+	return TtyModes{}, ErrTermiosNotSupported
+}
+
+func setInitialTtyModes(fd uintptr, opts *TtyInitOpts) error {
+	return ErrTermiosNotSupported
+}
@@ -0,0 +1,63 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package shellexec
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func getTtyModes(fd uintptr) (TtyModes, error) {
+	termios, err := unix.IoctlGetTermios(int(fd), ioctlReadTermios)
+	if err != nil {
+		return TtyModes{}, err
+	}
+	intrChar := termios.Cc[unix.VINTR]
+	if intrChar == posixVDisable {
+		intrChar = 0
+	}
+	return TtyModes{
+		Canonical: termios.Lflag&unix.ICANON != 0,
+		Echo:      termios.Lflag&unix.ECHO != 0,
+		ISig:      termios.Lflag&unix.ISIG != 0,
+		ICRNL:     termios.Iflag&unix.ICRNL != 0,
+		IGNCR:     termios.Iflag&unix.IGNCR != 0,
+		IntrChar:  intrChar,
+	}, nil
+}
+
+func setInitialTtyModes(fd uintptr, opts *TtyInitOpts) error {
+	termios, err := unix.IoctlGetTermios(int(fd), ioctlReadTermios)
+	if err != nil {
+		return err
+	}
+	if opts.Raw {
+		termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		termios.Oflag &^= unix.OPOST
+		termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		termios.Cflag &^= unix.CSIZE | unix.PARENB
+		termios.Cflag |= unix.CS8
+		termios.Cc[unix.VMIN] = 1
+		termios.Cc[unix.VTIME] = 0
+	}
+	if opts.NoEcho {
+		termios.Lflag &^= unix.ECHO | unix.ECHONL
+	}
+	if opts.EraseChar != nil {
+		termios.Cc[unix.VERASE] = ttyControlChar(*opts.EraseChar)
+	}
+	if opts.IntrChar != nil {
+		termios.Cc[unix.VINTR] = ttyControlChar(*opts.IntrChar)
+	}
+	return unix.IoctlSetTermios(int(fd), ioctlWriteTermios, termios)
+}
+
+// 0 disables the char
+func ttyControlChar(ch byte) uint8 {
+	if ch == 0 {
+		return posixVDisable
+	}
+	return ch
+}
@@ -0,0 +1,26 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+)
+
+// terminfo names (e.g. "xterm-256color", "screen.xterm-256color", "vt100+fnkeys")
+var termTypeRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// sets the default TermType (shellutil.DefaultTermType)
+func resolveTermType(cmdOpts *CommandOptsType) error {
+	if cmdOpts.TermType == "" {
+		cmdOpts.TermType = shellutil.DefaultTermType
+		return nil
+	}
+	if len(cmdOpts.TermType) > 64 || !termTypeRe.MatchString(cmdOpts.TermType) {
+		return fmt.Errorf("invalid term type %q", cmdOpts.TermType)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+)
+
+// closed because it ran past CommandOptsType.Timeout
+const CloseReason_Timeout = "timeout"
+
+// errors.Is matches the *CommandTimeoutError returned by Wait
+var ErrCommandTimeout = errors.New("command timed out")
+
+// CommandTimeoutError is the wait error of a shell that was closed because it ran past
+// CommandOptsType.Timeout, it wraps the error from waiting for the (closed) process
+type CommandTimeoutError struct {
+	Timeout time.Duration
+	WaitErr error
+}
+
+func (e *CommandTimeoutError) Error() string {
+	return fmt.Sprintf("command timed out after %v", e.Timeout)
+}
+
+func (e *CommandTimeoutError) Is(target error) bool {
+	return target == ErrCommandTimeout
+}
+
+func (e *CommandTimeoutError) Unwrap() error {
+	return e.WaitErr
+}
+
+// closes the shell (see Close) once it has been running for sp.timeout
+func (sp *ShellProc) startTimeout() {
+	if sp.timeout <= 0 {
+		return
+	}
+	timerCh, stopFn := sp.clock.NewTimer(sp.timeout)
+	go func() {
+		defer panichandler.PanicHandler("ShellProc:timeout")
+		defer stopFn()
+		select {
+		case <-timerCh:
+			sp.logf("command timed out after %v\n", sp.timeout)
+			sp.closeWithReason(CloseReason_Timeout)
+		case <-sp.DoneCh:
+		}
+	}()
+}
+
+// wraps waitErr if the shell was closed because of its timeout
+func (sp *ShellProc) timeoutWaitErr(waitErr error) error {
+	if sp.timeout <= 0 || sp.CloseReason() != CloseReason_Timeout {
+		return waitErr
+	}
+	return &CommandTimeoutError{Timeout: sp.timeout, WaitErr: waitErr}
+}
@@ -0,0 +1,51 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCommandTimeout(t *testing.T) {
+	sp := startTestShellProc(t, "echo started; sleep 30", CommandOptsType{Timeout: 200 * time.Millisecond})
+	collectOutput(sp).waitFor(t, "started")
+	waitDone(t, sp)
+	waitErr := sp.Wait()
+	if !errors.Is(waitErr, ErrCommandTimeout) {
+		t.Fatalf("expected ErrCommandTimeout, got %v", waitErr)
+	}
+	var timeoutErr *CommandTimeoutError
+	if !errors.As(waitErr, &timeoutErr) || timeoutErr.Timeout != 200*time.Millisecond {
+		t.Errorf("expected a *CommandTimeoutError with the timeout, got %#v", waitErr)
+	}
+	if reason := sp.CloseReason(); reason != CloseReason_Timeout {
+		t.Errorf("expected CloseReason_Timeout, got %q", reason)
+	}
+	status, _ := sp.ExitStatus()
+	if !status.TimedOut || ExplainExit(status) != "timed out" {
+		t.Errorf("expected a timed out exit status, got %+v", status)
+	}
+}
+
+func TestCommandTimeoutNotReached(t *testing.T) {
+	sp := startTestShellProc(t, "exit 3", CommandOptsType{Timeout: time.Minute})
+	startWaitLoop(sp)
+	waitDone(t, sp)
+	waitErr := sp.Wait()
+	if errors.Is(waitErr, ErrCommandTimeout) {
+		t.Errorf("the command exited before its timeout, got %v", waitErr)
+	}
+	if code := ExitCodeFromWaitErr(waitErr); code != 3 {
+		t.Errorf("expected exit code 3, got %d", code)
+	}
+	if status, _ := sp.ExitStatus(); status.TimedOut {
+		t.Errorf("expected TimedOut to be unset, got %+v", status)
+	}
+	// also unwraps a timeout error
+	if code := ExitCodeFromWaitErr(&CommandTimeoutError{WaitErr: waitErr}); code != 3 {
+		t.Errorf("expected the wrapped exit code, got %d", code)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+const (
+	BracketedPasteStart = "\x1b[200~"
+	BracketedPasteEnd   = "\x1b[201~"
+	DefaultIntrChar     = 0x03 // ^C
+)
+
+var ErrTermiosNotSupported = errors.New("reading terminal modes is not supported for this shellproc")
+
+// TtyModes is the subset of the pty's termios state that matters for writing input
+type TtyModes struct {
+	Canonical bool `json:"canonical"` // ICANON
+	Echo      bool `json:"echo"`      // ECHO
+	ISig      bool `json:"isig"`      // ISIG (^C, ^Z, ^\ generate signals)
+	ICRNL     bool `json:"icrnl"`     // \r is translated to \n on input
+	IGNCR     bool `json:"igncr"`     // \r is ignored on input
+	IntrChar  byte `json:"intrchar"`  // VINTR, 0 if disabled
+}
+
+// TtyInitOpts is the termios state set on the pty before the process starts (CommandOptsType.TtyInit), for
+// programs that expect a configured tty (a REPL that does no line editing of its own wants NoEcho, one that
+// reads keys wants Raw).  unset fields keep the pty's defaults
+type TtyInitOpts struct {
+	Raw       bool  `json:"raw,omitempty"`       // like cfmakeraw: no line editing, echo, signal chars or output processing
+	NoEcho    bool  `json:"noecho,omitempty"`    // no echo, line editing stays
+	EraseChar *byte `json:"erasechar,omitempty"` // VERASE (the default is ^?, 0x08 for ^H), 0 disables it
+	IntrChar  *byte `json:"intrchar,omitempty"`  // VINTR (the default is ^C), 0 disables it
+}
+
+func resolveTtyInit(cmdOpts CommandOptsType) error {
+	if cmdOpts.TtyInit == nil {
+		return nil
+	}
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		return fmt.Errorf("TtyInit is only supported on linux and macos")
+	}
+	return nil
+}
+
+// DefaultTtyModes are the modes of a freshly opened pty (used when the real modes can't be read, e.g. ssh sessions)
+func DefaultTtyModes() TtyModes {
+	return TtyModes{Canonical: true, Echo: true, ISig: true, ICRNL: true, IntrChar: DefaultIntrChar}
+}
+
+func (m TtyModes) IsRaw() bool {
+	return !m.Canonical && !m.ISig
+}
+
+// LineTerminator returns the byte that acts as "Enter" for these modes.
+// \r is what a real keyboard sends, and it works for raw-mode apps and for
+// canonical mode with ICRNL.  We only fall back to \n when the line discipline
+// would not turn \r into a newline.
+func (m TtyModes) LineTerminator() byte {
+	if m.Canonical && (m.IGNCR || !m.ICRNL) {
+		return '\n'
+	}
+	return '\r'
+}
+
+// TranslateNewlines replaces every line ending in data (\r\n, \n, or \r) with
+// the correct line terminator for modes.
+func TranslateNewlines(data []byte, modes TtyModes) []byte {
+	term := modes.LineTerminator()
+	rtn := make([]byte, 0, len(data))
+	for idx := 0; idx < len(data); idx++ {
+		ch := data[idx]
+		if ch == '\r' {
+			if idx+1 < len(data) && data[idx+1] == '\n' {
+				idx++
+			}
+			rtn = append(rtn, term)
+			continue
+		}
+		if ch == '\n' {
+			rtn = append(rtn, term)
+			continue
+		}
+		rtn = append(rtn, ch)
+	}
+	return rtn
+}
+
+// TtyModes returns the current termios modes of the shell's pty
+func (sp *ShellProc) TtyModes() (TtyModes, error) {
+	if _, ok := localCmdWrap(sp.Cmd); !ok {
+		return TtyModes{}, ErrTermiosNotSupported
+	}
+	var modes TtyModes
+	err := sp.withPtyFd(func(fd uintptr) error {
+		var err error
+		modes, err = getTtyModes(fd)
+		return err
+	})
+	return modes, err
+}
+
+func (sp *ShellProc) ttyModesOrDefault() TtyModes {
+	modes, err := sp.TtyModes()
+	if err != nil {
+		return DefaultTtyModes()
+	}
+	return modes
+}
+
+// BracketedPasteEnabled returns true if the application running in the pty
+// has turned on bracketed paste mode (CSI ? 2004 h)
+func (sp *ShellProc) BracketedPasteEnabled() bool {
+	return sp.output.getTermFlags().BracketedPaste
+}
+
+// Cwd returns the directory the shell last reported with OSC 7 (our bash and zsh integration reports it
+// at every prompt, see EventKind_Cwd), empty if it hasn't reported one.  For remote shells this is
+// a path on the remote host
+func (sp *ShellProc) Cwd() string {
+	return sp.output.getCwd()
+}
+
+// makeLinesInput builds the input for WriteLines.  when bracketed paste is
+// on, multiple lines are sent as a single paste (so a shell prompt gets one
+// multi-line edit buffer instead of running each line as it arrives), followed
+// by a single line terminator.
+func makeLinesInput(lines []string, modes TtyModes, bracketedPaste bool) []byte {
+	var buf bytes.Buffer
+	term := modes.LineTerminator()
+	if len(lines) > 1 && bracketedPaste {
+		buf.WriteString(BracketedPasteStart)
+		for idx, line := range lines {
+			if idx > 0 {
+				buf.WriteByte('\r') // same as a terminal sends for newlines inside a paste
+			}
+			buf.WriteString(line)
+		}
+		buf.WriteString(BracketedPasteEnd)
+		buf.WriteByte(term)
+		return buf.Bytes()
+	}
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte(term)
+	}
+	return buf.Bytes()
+}
+
+// WriteLines writes each line to the pty followed by the correct line terminator
+// for the pty's current modes.  Lines must not contain newlines.
+func (sp *ShellProc) WriteLines(lines []string) error {
+	for idx, line := range lines {
+		if bytes.ContainsAny([]byte(line), "\r\n") {
+			return fmt.Errorf("line %d contains a newline", idx)
+		}
+	}
+	input := makeLinesInput(lines, sp.ttyModesOrDefault(), sp.BracketedPasteEnabled())
+	_, err := sp.Write(input)
+	return err
+}
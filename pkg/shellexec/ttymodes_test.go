@@ -0,0 +1,118 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestTranslateNewlines(t *testing.T) {
+	canonical := DefaultTtyModes()
+	noIcrnl := DefaultTtyModes()
+	noIcrnl.ICRNL = false
+	raw := TtyModes{}
+	tests := []struct {
+		input    string
+		modes    TtyModes
+		expected string
+	}{
+		{"a\nb\n", canonical, "a\rb\r"},
+		{"a\r\nb\r", canonical, "a\rb\r"},
+		{"a\nb\r\n", noIcrnl, "a\nb\n"},
+		{"a\n\nb", raw, "a\r\rb"},
+		{"no newlines", raw, "no newlines"},
+	}
+	for _, test := range tests {
+		result := string(TranslateNewlines([]byte(test.input), test.modes))
+		if result != test.expected {
+			t.Errorf("TranslateNewlines(%q, %#v) = %q; want %q", test.input, test.modes, result, test.expected)
+		}
+	}
+}
+
+func TestMakeLinesInput(t *testing.T) {
+	modes := DefaultTtyModes()
+	result := string(makeLinesInput([]string{"echo 1", "echo 2"}, modes, false))
+	if result != "echo 1\recho 2\r" {
+		t.Errorf("bad input without bracketed paste: %q", result)
+	}
+	result = string(makeLinesInput([]string{"echo 1", "echo 2"}, modes, true))
+	if result != BracketedPasteStart+"echo 1\recho 2"+BracketedPasteEnd+"\r" {
+		t.Errorf("bad input with bracketed paste: %q", result)
+	}
+	result = string(makeLinesInput([]string{"echo 1"}, modes, true))
+	if result != "echo 1\r" {
+		t.Errorf("single lines should not be bracketed: %q", result)
+	}
+}
+
+func TestWriteLinesCanonical(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no termios on windows")
+	}
+	sp := startTestShellProc(t, `echo ready; read a; read b; echo "got:[$a][$b]"; stty -icrnl; echo ready2; read c; echo "got:[$c]"`, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready\r\n")
+	if err := sp.WriteLines([]string{"one", "two"}); err != nil {
+		t.Fatalf("WriteLines error: %v", err)
+	}
+	oc.waitFor(t, "got:[one][two]")
+	oc.waitFor(t, "ready2")
+	modes, err := sp.TtyModes()
+	if err != nil {
+		t.Fatalf("TtyModes error: %v", err)
+	}
+	if !modes.Canonical || modes.ICRNL {
+		t.Fatalf("expected canonical mode without icrnl, got %#v", modes)
+	}
+	if err := sp.WriteLines([]string{"three"}); err != nil {
+		t.Fatalf("WriteLines error: %v", err)
+	}
+	oc.waitFor(t, "got:[three]")
+}
+
+func TestWriteLinesRawMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no termios on windows")
+	}
+	sp := startTestShellProc(t, testHelperCmdStr(t), CommandOptsType{Env: map[string]string{testHelperEnvVar: "rawmode"}})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "ready\r\n")
+	modes, err := sp.TtyModes()
+	if err != nil {
+		t.Fatalf("TtyModes error: %v", err)
+	}
+	if !modes.IsRaw() {
+		t.Fatalf("expected raw mode, got %#v", modes)
+	}
+	if err := sp.WriteLines([]string{"ab", "q"}); err != nil {
+		t.Fatalf("WriteLines error: %v", err)
+	}
+	oc.waitFor(t, "got:61620d")
+}
+
+func TestTtyInit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no termios on windows")
+	}
+	eraseChar, intrChar := byte(0x08), byte(0)
+	sp := startTestShellProc(t, "echo started; sleep 30", CommandOptsType{TtyInit: &TtyInitOpts{NoEcho: true, EraseChar: &eraseChar, IntrChar: &intrChar}})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "started")
+	modes, err := sp.TtyModes()
+	if err != nil {
+		t.Fatalf("TtyModes error: %v", err)
+	}
+	if modes.Echo || !modes.Canonical || modes.IntrChar != 0 {
+		t.Errorf("expected no echo, canonical mode and no intr char, got %#v", modes)
+	}
+
+	sp = startTestShellProc(t, "echo started; sleep 30", CommandOptsType{TtyInit: &TtyInitOpts{Raw: true}})
+	oc = collectOutput(sp)
+	oc.waitFor(t, "started")
+	if modes, err := sp.TtyModes(); err != nil || !modes.IsRaw() || modes.Echo {
+		t.Errorf("expected raw mode, got %#v %v", modes, err)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package shellexec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// USER_HZ, the unit of the times in /proc/<pid>/stat (fixed at 100 on every architecture we build for)
+const procClockTicks = 100
+
+type procStat struct {
+	Comm       string
+	State      byte // 'R', 'S', 'Z' (zombie)...
+	Ppid       int
+	Pgrp       int
+	Sid        int
+	CpuTicks   uint64 // utime + stime + cutime + cstime
+	StartTicks uint64 // since boot
+	RssPages   uint64
+}
+
+// parses /proc/<pid>/stat, comm (field 2) can contain spaces and parens so fields are counted from the last ')'
+func parseProcStat(data []byte) (procStat, error) {
+	commEnd := bytes.LastIndexByte(data, ')')
+	if commEnd < 0 {
+		return procStat{}, fmt.Errorf("invalid stat format")
+	}
+	// fields[0] is field 3 (state)
+	fields := strings.Fields(string(data[commEnd+1:]))
+	if len(fields) < 22 {
+		return procStat{}, fmt.Errorf("invalid stat format, only %d fields", len(fields))
+	}
+	var rtn procStat
+	var err error
+	if commStart := bytes.IndexByte(data, '('); commStart >= 0 && commStart < commEnd {
+		rtn.Comm = string(data[commStart+1 : commEnd])
+	}
+	rtn.State = fields[0][0]
+	if rtn.Ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return procStat{}, fmt.Errorf("invalid stat ppid: %w", err)
+	}
+	if rtn.Pgrp, err = strconv.Atoi(fields[2]); err != nil {
+		return procStat{}, fmt.Errorf("invalid stat pgrp: %w", err)
+	}
+	if rtn.Sid, err = strconv.Atoi(fields[3]); err != nil {
+		return procStat{}, fmt.Errorf("invalid stat session: %w", err)
+	}
+	if rtn.StartTicks, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return procStat{}, fmt.Errorf("invalid stat starttime: %w", err)
+	}
+	for _, idx := range []int{11, 12, 13, 14} {
+		ticks, err := strconv.ParseUint(fields[idx], 10, 64)
+		if err != nil {
+			return procStat{}, fmt.Errorf("invalid stat times: %w", err)
+		}
+		rtn.CpuTicks += ticks
+	}
+	if rtn.RssPages, err = strconv.ParseUint(fields[21], 10, 64); err != nil {
+		return procStat{}, fmt.Errorf("invalid stat rss: %w", err)
+	}
+	return rtn, nil
+}
+
+func readProcStat(pid int) (procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procStat{}, err
+	}
+	return parseProcStat(data)
+}
+
+// scans /proc for every process, processes that exit mid-scan are skipped
+func readAllProcStats() (map[int]procStat, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read /proc: %w", err)
+	}
+	rtn := make(map[int]procStat)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid <= 0 {
+			continue
+		}
+		stat, err := readProcStat(pid)
+		if err != nil {
+			continue
+		}
+		rtn[pid] = stat
+	}
+	return rtn, nil
+}
+
+// returns rootPid followed by all of its descendants
+func procTreePids(stats map[int]procStat, rootPid int) []int {
+	children := make(map[int][]int)
+	for pid, stat := range stats {
+		children[stat.Ppid] = append(children[stat.Ppid], pid)
+	}
+	rtn := []int{rootPid}
+	for idx := 0; idx < len(rtn); idx++ {
+		rtn = append(rtn, children[rtn[idx]]...)
+	}
+	return rtn
+}
+
+type procIo struct {
+	ReadChars  uint64
+	WriteChars uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+func parseProcIo(data []byte) (procIo, error) {
+	var rtn procIo
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		name, valStr, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		val, err := strconv.ParseUint(strings.TrimSpace(valStr), 10, 64)
+		if err != nil {
+			return procIo{}, fmt.Errorf("invalid io value for %q: %w", name, err)
+		}
+		switch name {
+		case "rchar":
+			rtn.ReadChars = val
+		case "wchar":
+			rtn.WriteChars = val
+		case "read_bytes":
+			rtn.ReadBytes = val
+		case "write_bytes":
+			rtn.WriteBytes = val
+		}
+	}
+	return rtn, nil
+}
+
+func sampleProcTree(rootPid int) (ProcUsage, error) {
+	stats, err := readAllProcStats()
+	if err != nil {
+		return ProcUsage{}, err
+	}
+	if _, ok := stats[rootPid]; !ok {
+		return ProcUsage{}, fmt.Errorf("shell process %d not found", rootPid)
+	}
+	pageSize := uint64(os.Getpagesize())
+	var usage ProcUsage
+	for _, pid := range procTreePids(stats, rootPid) {
+		stat := stats[pid]
+		usage.NumProcs++
+		usage.CpuSeconds += float64(stat.CpuTicks) / procClockTicks
+		usage.MemRss += stat.RssPages * pageSize
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/io", pid))
+		if err != nil {
+			// EACCES for setuid (or otherwise undumpable) children, anything else means it exited
+			if errors.Is(err, fs.ErrPermission) {
+				usage.SkippedProcs++
+			}
+			continue
+		}
+		pio, err := parseProcIo(data)
+		if err != nil {
+			continue
+		}
+		usage.ReadChars += pio.ReadChars
+		usage.WriteChars += pio.WriteChars
+		usage.ReadBytes += pio.ReadBytes
+		usage.WriteBytes += pio.WriteBytes
+	}
+	return usage, nil
+}
+
+func sampleProcTreeNodes(rootPid int) ([]*ProcTreeNode, error) {
+	stats, err := readAllProcStats()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := stats[rootPid]; !ok {
+		return nil, fmt.Errorf("shell process %d not found", rootPid)
+	}
+	pageSize := uint64(os.Getpagesize())
+	var rtn []*ProcTreeNode
+	for _, pid := range procTreePids(stats, rootPid) {
+		stat := stats[pid]
+		rtn = append(rtn, &ProcTreeNode{
+			Pid:        pid,
+			Ppid:       stat.Ppid,
+			Name:       stat.Comm,
+			CpuSeconds: float64(stat.CpuTicks) / procClockTicks,
+			MemRss:     stat.RssPages * pageSize,
+		})
+	}
+	return rtn, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package shellexec
+
+import (
+	"fmt"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// per-process io counters are not cheaply available outside of linux (IOUnsupported is set),
+// cpu and memory come from gopsutil and do not include children that have exited
+func sampleProcTree(rootPid int) (ProcUsage, error) {
+	root, err := process.NewProcess(int32(rootPid))
+	if err != nil {
+		return ProcUsage{}, fmt.Errorf("shell process %d not found: %w", rootPid, err)
+	}
+	usage := ProcUsage{IOUnsupported: true}
+	procs := []*process.Process{root}
+	for idx := 0; idx < len(procs); idx++ {
+		proc := procs[idx]
+		times, err := proc.Times()
+		if err != nil {
+			// exited mid-walk
+			continue
+		}
+		usage.NumProcs++
+		usage.CpuSeconds += times.User + times.System
+		if memInfo, err := proc.MemoryInfo(); err == nil {
+			usage.MemRss += memInfo.RSS
+		}
+		if children, err := proc.Children(); err == nil {
+			procs = append(procs, children...)
+		}
+	}
+	return usage, nil
+}
+
+func sampleProcTreeNodes(rootPid int) ([]*ProcTreeNode, error) {
+	root, err := process.NewProcess(int32(rootPid))
+	if err != nil {
+		return nil, fmt.Errorf("shell process %d not found: %w", rootPid, err)
+	}
+	var rtn []*ProcTreeNode
+	procs := []*process.Process{root}
+	ppids := []int{0}
+	for idx := 0; idx < len(procs); idx++ {
+		proc := procs[idx]
+		times, err := proc.Times()
+		if err != nil {
+			// exited mid-walk
+			continue
+		}
+		node := &ProcTreeNode{Pid: int(proc.Pid), Ppid: ppids[idx], CpuSeconds: times.User + times.System}
+		node.Name, _ = proc.Name()
+		if memInfo, err := proc.MemoryInfo(); err == nil {
+			node.MemRss = memInfo.RSS
+		}
+		rtn = append(rtn, node)
+		if children, err := proc.Children(); err == nil {
+			for _, child := range children {
+				procs = append(procs, child)
+				ppids = append(ppids, node.Pid)
+			}
+		}
+	}
+	return rtn, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+)
+
+// ProcUsage is a resource usage sample for a shell and all of its descendants.
+// Like the kernel's own accounting, the cpu and io counters include children that
+// have already exited (once they are reaped), so they only ever go up for a
+// given shell.  Network counters are not collected (they are per network
+// namespace, not per process).
+type ProcUsage struct {
+	SessionId     string  `json:"sessionid"`
+	Ts            int64   `json:"ts"`
+	NumProcs      int     `json:"numprocs"`
+	CpuSeconds    float64 `json:"cpuseconds"`              // user + system time
+	MemRss        uint64  `json:"memrss"`                  // sum of the resident set sizes (shared pages are counted more than once)
+	ReadBytes     uint64  `json:"readbytes"`               // bytes fetched from the storage layer
+	WriteBytes    uint64  `json:"writebytes"`              // bytes sent to the storage layer (not counted for tmpfs)
+	ReadChars     uint64  `json:"readchars"`               // bytes passed to read syscalls (includes ttys, pipes and cached reads)
+	WriteChars    uint64  `json:"writechars"`              // bytes passed to write syscalls
+	IOUnsupported bool    `json:"iounsupported,omitempty"` // io counters are not available on this platform (all zero)
+	SkippedProcs  int     `json:"skippedprocs,omitempty"`  // descendants whose io counters are not readable (e.g. setuid programs)
+}
+
+// returns the pid of a local shell (0 for remote and wsl shells), for a supervised command the current run's
+func (sp *ShellProc) localPid() int {
+	cw, ok := localCmdWrap(sp.Cmd)
+	if !ok || cw.Cmd.Process == nil {
+		return 0
+	}
+	return cw.Cmd.Process.Pid
+}
+
+// Usage samples the resource usage of the shell and its process tree (local shells only).
+// Processes that exit while the tree is being walked are skipped.
+func (sp *ShellProc) Usage() (ProcUsage, error) {
+	pid := sp.localPid()
+	if pid <= 0 {
+		return ProcUsage{}, fmt.Errorf("usage is only available for local shells")
+	}
+	select {
+	case <-sp.DoneCh:
+		return ProcUsage{}, fmt.Errorf("shell has exited")
+	default:
+	}
+	usage, err := sampleProcTree(pid)
+	if err != nil {
+		return ProcUsage{}, err
+	}
+	usage.SessionId = sp.sessionId
+	usage.Ts = sp.clock.Now().UnixMilli()
+	return usage, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestUsageIoCounters(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("io counters are only collected on linux")
+	}
+	if _, err := os.ReadFile("/proc/self/io"); err != nil {
+		t.Skipf("/proc/<pid>/io not readable: %v", err)
+	}
+	requireBinary(t, "dd")
+	const ddMegs = 8
+	outFile := filepath.Join(t.TempDir(), "dd-out")
+	// dd is reaped by the shell before we sample, so its counters are only seen through the shell's own
+	cmdStr := fmt.Sprintf("dd if=/dev/zero of='%s' bs=1M count=%d status=none; echo dd''-done; read x", outFile, ddMegs)
+	sp := startTestShellProc(t, cmdStr, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "dd-done")
+	usage, err := sp.Usage()
+	if err != nil {
+		t.Fatalf("Usage error: %v", err)
+	}
+	if usage.IOUnsupported {
+		t.Fatalf("io should be supported on linux")
+	}
+	if usage.NumProcs < 1 {
+		t.Errorf("expected at least the shell in the tree, got %d procs", usage.NumProcs)
+	}
+	const ddBytes = ddMegs * 1024 * 1024
+	const slack = 1024 * 1024
+	if usage.WriteChars < ddBytes || usage.WriteChars > ddBytes+slack {
+		t.Errorf("writechars %d out of range [%d, %d]", usage.WriteChars, ddBytes, ddBytes+slack)
+	}
+	if usage.ReadChars < ddBytes {
+		t.Errorf("readchars %d should be at least %d", usage.ReadChars, ddBytes)
+	}
+	// write_bytes depends on the filesystem (tmpfs never touches the storage layer)
+	t.Logf("usage: %+v", usage)
+	sp.Write([]byte("\r"))
+}
+
+func TestSampleProcTree(t *testing.T) {
+	usage, err := sampleProcTree(os.Getpid())
+	if err != nil {
+		t.Fatalf("sampleProcTree error: %v", err)
+	}
+	if usage.NumProcs < 1 || usage.MemRss == 0 {
+		t.Errorf("unexpected sample for the test process: %+v", usage)
+	}
+	if _, err := sampleProcTree(1 << 30); err == nil {
+		t.Errorf("expected an error for a missing pid")
+	}
+}
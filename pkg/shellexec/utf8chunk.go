@@ -0,0 +1,126 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DefaultUtf8HoldTimeout = 10 * time.Millisecond
+	maxUtf8Holdback        = 16 // enough for an incomplete rune or a trailing "rune + ZWJ"
+	zwjRune                = '\u200d'
+)
+
+// utf8Chunker makes sure chunks written to Dst never end in the middle of a
+// UTF-8 sequence (or right after a zero-width joiner, which would split an emoji
+// cluster).  The trailing bytes are held back and prepended to the next write.
+// Held bytes are written unconditionally after HoldTimeout (so an invalid byte is
+// never held forever) and on flush.  It sits after the output handler, whose
+// tokenizer already guarantees that chunks never end inside an escape sequence.
+type utf8Chunker struct {
+	Lock        *sync.Mutex
+	Dst         io.Writer
+	Held        []byte // a slice of HeldBuf
+	HeldBuf     [maxUtf8Holdback + utf8.UTFMax]byte
+	JoinBuf     []byte // reused to prepend Held to the next write (Dst doesn't keep what it's given)
+	HoldTimeout time.Duration
+	Clock       clock
+	StopTimer   func() bool // set while held bytes are waiting for the hold timeout
+}
+
+func makeUtf8Chunker(clk clock, dst io.Writer, holdTimeout time.Duration) *utf8Chunker {
+	if holdTimeout <= 0 {
+		holdTimeout = DefaultUtf8HoldTimeout
+	}
+	return &utf8Chunker{Lock: &sync.Mutex{}, Dst: dst, HoldTimeout: holdTimeout, Clock: clk}
+}
+
+// returns the number of trailing bytes of data that should be held back
+func utf8HoldbackLen(data []byte) int {
+	holdLen := 0
+	// look for an incomplete trailing rune
+	for back := 1; back <= utf8.UTFMax-1 && back <= len(data); back++ {
+		ch := data[len(data)-back]
+		if ch < utf8.RuneSelf {
+			break
+		}
+		if utf8.RuneStart(ch) {
+			if !utf8.FullRune(data[len(data)-back:]) {
+				holdLen = back
+			}
+			break
+		}
+	}
+	// don't split a ZWJ cluster ("rune ZWJ" | "rune")
+	rest := data[:len(data)-holdLen]
+	lastRune, lastSize := utf8.DecodeLastRune(rest)
+	if lastRune != zwjRune {
+		return holdLen
+	}
+	_, prevSize := utf8.DecodeLastRune(rest[:len(rest)-lastSize])
+	if holdLen+lastSize+prevSize > maxUtf8Holdback {
+		return holdLen
+	}
+	return holdLen + lastSize + prevSize
+}
+
+func (uc *utf8Chunker) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	inputLen := len(data)
+	uc.Lock.Lock()
+	defer uc.Lock.Unlock()
+	uc.stopTimer()
+	if len(uc.Held) > 0 {
+		uc.JoinBuf = append(append(uc.JoinBuf[:0], uc.Held...), data...)
+		data = uc.JoinBuf
+		uc.Held = nil
+	}
+	holdLen := utf8HoldbackLen(data)
+	if holdLen > 0 {
+		uc.Held = append(uc.HeldBuf[:0], data[len(data)-holdLen:]...)
+		data = data[:len(data)-holdLen]
+		uc.StopTimer = uc.Clock.AfterFunc(uc.HoldTimeout, uc.flush)
+	}
+	if len(data) > 0 {
+		if _, err := uc.Dst.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	return inputLen, nil
+}
+
+// must hold Lock
+func (uc *utf8Chunker) stopTimer() {
+	if uc.StopTimer != nil {
+		uc.StopTimer()
+		uc.StopTimer = nil
+	}
+}
+
+// writeAround writes data to dst (instead of Dst) before any held bytes, they stay held for
+// the rest of their sequence
+func (uc *utf8Chunker) writeAround(data []byte, dst io.Writer) {
+	uc.Lock.Lock()
+	defer uc.Lock.Unlock()
+	dst.Write(data)
+}
+
+// flush writes any held bytes to Dst
+func (uc *utf8Chunker) flush() {
+	uc.Lock.Lock()
+	defer uc.Lock.Unlock()
+	uc.stopTimer()
+	if len(uc.Held) == 0 {
+		return
+	}
+	held := uc.Held
+	uc.Held = nil
+	uc.Dst.Write(held)
+}
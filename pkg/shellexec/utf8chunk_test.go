@@ -0,0 +1,137 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wavetermdev/waveterm/pkg/util/testutil"
+)
+
+// records every write as a separate chunk
+type chunkRecorder struct {
+	Lock   *sync.Mutex
+	Chunks [][]byte
+}
+
+func (cr *chunkRecorder) Write(data []byte) (int, error) {
+	cr.Lock.Lock()
+	defer cr.Lock.Unlock()
+	cr.Chunks = append(cr.Chunks, append([]byte(nil), data...))
+	return len(data), nil
+}
+
+func (cr *chunkRecorder) joined() []byte {
+	cr.Lock.Lock()
+	defer cr.Lock.Unlock()
+	return bytes.Join(cr.Chunks, nil)
+}
+
+const multibyteOutput = "héllo wörld ✓ 日本語テキスト 👨‍👩‍👧 🎉🎉 ☃\r\n"
+
+func TestUtf8ChunkerOneByteWrites(t *testing.T) {
+	rec := &chunkRecorder{Lock: &sync.Mutex{}}
+	uc := makeUtf8Chunker(realClock{}, rec, time.Hour)
+	input := []byte(strings.Repeat(multibyteOutput, 10))
+	for idx := range input {
+		uc.Write(input[idx : idx+1])
+	}
+	uc.flush()
+	for _, chunk := range rec.Chunks {
+		if !utf8.Valid(chunk) {
+			t.Fatalf("chunk is not valid utf-8: %q", chunk)
+		}
+		if bytes.HasSuffix(chunk, []byte("‍")) {
+			t.Fatalf("chunk ends with a zwj: %q", chunk)
+		}
+	}
+	if !bytes.Equal(rec.joined(), input) {
+		t.Fatalf("output does not match input")
+	}
+}
+
+func TestUtf8ChunkerInvalidBytes(t *testing.T) {
+	rec := &chunkRecorder{Lock: &sync.Mutex{}}
+	fc := testutil.NewFakeClock()
+	uc := makeUtf8Chunker(fc, rec, 10*time.Millisecond)
+	uc.Write([]byte("abc\xe2\x9c"))
+	if got := string(rec.joined()); got != "abc" {
+		t.Fatalf("expected incomplete rune to be held, got %q", got)
+	}
+	// an invalid continuation is released with the next write
+	uc.Write([]byte("d"))
+	if got := string(rec.joined()); got != "abc\xe2\x9cd" {
+		t.Fatalf("bad output after invalid sequence: %q", got)
+	}
+	// a trailing partial rune is released after the hold timeout
+	uc.Write([]byte("\xf0"))
+	fc.Advance(9 * time.Millisecond)
+	if got := string(rec.joined()); got != "abc\xe2\x9cd" {
+		t.Fatalf("held byte released early: %q", got)
+	}
+	fc.Advance(time.Millisecond)
+	deadline := time.Now().Add(testWaitTimeout)
+	for string(rec.joined()) != "abc\xe2\x9cd\xf0" {
+		if time.Now().After(deadline) {
+			t.Fatalf("held byte was never released: %q", rec.joined())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOutputLoopMultibyte(t *testing.T) {
+	input := []byte(strings.Repeat("\x1b[1m"+multibyteOutput+"\x1b]0;títle\a", 20))
+	var chunks [][]byte
+	for idx := range input {
+		chunks = append(chunks, input[idx:idx+1])
+	}
+	cb := makeCoalesceBuffer(realClock{}, 0, -1)
+	go runOutputLoop(&chunkReader{Chunks: chunks}, makeOutputPipeline(realClock{}, makeOutputHandler(makeEventHub()), cb, cb), cb.setErr)
+	var output []byte
+	buf := make([]byte, 7)
+	for {
+		nr, err := cb.Read(buf)
+		output = append(output, buf[:nr]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read error: %v", err)
+		}
+	}
+	if !bytes.Equal(output, input) {
+		t.Fatalf("output does not match input")
+	}
+}
+
+func TestOutputPipelineInject(t *testing.T) {
+	rec := &chunkRecorder{Lock: &sync.Mutex{}}
+	oh := makeOutputHandler(makeEventHub())
+	pl := makeOutputPipeline(realClock{}, oh, rec, rec)
+	var outBuf bytes.Buffer
+	// an OSC title and a multibyte rune, both cut off mid-sequence
+	pl.write([]byte("abc\x1b]0;ti"), &outBuf)
+	pl.inject([]byte("[injected]"))
+	pl.write([]byte("tle\x07d\xe2\x9c"), &outBuf)
+	pl.inject([]byte("[again]"))
+	pl.write([]byte("\x93e"), &outBuf)
+	pl.finish(&outBuf)
+	want := "abc[injected]\x1b]0;title\x07d[again]✓e"
+	if got := string(rec.joined()); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if oh.Offset != int64(len(want)) {
+		t.Errorf("offset %d should count the injected output (want %d)", oh.Offset, len(want))
+	}
+	pl.inject([]byte("[late]"))
+	if strings.Contains(string(rec.joined()), "[late]") {
+		t.Errorf("output injected after the end should be dropped")
+	}
+}
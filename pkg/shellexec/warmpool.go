@@ -0,0 +1,258 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+	"github.com/wavetermdev/waveterm/pkg/wavebase"
+)
+
+const MaxWarmPoolSize = 8
+const DefaultWarmPoolMaxIdle = 5 * time.Minute
+
+// WarmPoolOpts configures the warm pool (see SetWarmPool)
+type WarmPoolOpts struct {
+	Size      int           // pre-opened ptys to keep ready (capped at MaxWarmPoolSize), zero disables the pool
+	MaxIdle   time.Duration // idle ptys older than this are replaced, zero uses the default
+	ConfigKey string        // the settings the cached env depends on, a different key invalidates it
+}
+
+// a pre-opened pty pair, both sides stay open until it is used (or discarded)
+type warmPty struct {
+	Pty      pty.Pty
+	Tty      pty.Tty
+	OpenedAt time.Time
+}
+
+func (wp *warmPty) close() {
+	wp.Pty.Close()
+	wp.Tty.Close()
+}
+
+// warmEnv is the part of StartShellProc's setup that only depends on wavesrv's own
+// environment (and the settings in ConfigKey)
+type warmEnv struct {
+	ConfigKey        string
+	DefaultShellPath string
+	Inherited        *shellutil.EnvBase // os.Environ, already parsed
+	Waveshell        map[string]string  // read only, includes LANG if wavesrv doesn't have one
+	Lock             *sync.Mutex
+	Resolutions      map[string]shellutil.ShellResolution
+}
+
+func makeWarmEnv(configKey string) *warmEnv {
+	waveshellEnv := shellutil.WaveshellLocalEnvVars(shellutil.DefaultTermType)
+	if os.Getenv("LANG") == "" {
+		waveshellEnv["LANG"] = wavebase.DetermineLang()
+	}
+	return &warmEnv{
+		ConfigKey:        configKey,
+		DefaultShellPath: shellutil.DetectLocalShellPath(),
+		Inherited:        shellutil.PrepareEnv(shellutil.EnvLayer{Name: shellutil.EnvLayer_Inherited, Environ: os.Environ()}),
+		Waveshell:        waveshellEnv,
+		Lock:             &sync.Mutex{},
+		Resolutions:      make(map[string]shellutil.ShellResolution),
+	}
+}
+
+func (we *warmEnv) resolveShell(shellPath string) shellutil.ShellResolution {
+	we.Lock.Lock()
+	defer we.Lock.Unlock()
+	if res, ok := we.Resolutions[shellPath]; ok {
+		return res
+	}
+	res := shellutil.ResolveShell(shellPath)
+	we.Resolutions[shellPath] = res
+	return res
+}
+
+// warmPool keeps a few ptys open (and the base env computed) so starting a local
+// shell is mostly just the exec.  Ptys are refilled in the background after one is
+// taken, and replaced once they have been idle for MaxIdle.
+type warmPool struct {
+	Lock     *sync.Mutex
+	Clock    clock
+	Opts     WarmPoolOpts
+	Ptys     []*warmPty
+	Env      *warmEnv
+	RefillCh chan struct{} // nil while the pool is disabled
+	StopCh   chan struct{}
+}
+
+var globalWarmPool = &warmPool{Lock: &sync.Mutex{}}
+
+// SetWarmPool enables (or reconfigures, or with a zero Size disables) the warm pool used by
+// StartShellProc.  Disabling it closes the idle ptys.  Called at startup and whenever the
+// settings change, it only does work when something changed.
+func SetWarmPool(opts WarmPoolOpts) {
+	globalWarmPool.configure(shellClock, opts)
+}
+
+func (p *warmPool) configure(clk clock, opts WarmPoolOpts) {
+	if !warmPtySupported {
+		return
+	}
+	if opts.Size > MaxWarmPoolSize {
+		opts.Size = MaxWarmPoolSize
+	}
+	if opts.Size < 0 {
+		opts.Size = 0
+	}
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = DefaultWarmPoolMaxIdle
+	}
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	if p.Env != nil && p.Env.ConfigKey != opts.ConfigKey {
+		p.Env = nil
+	}
+	p.Opts = opts
+	p.Clock = clk
+	if opts.Size == 0 {
+		p.Env = nil
+		p.discardLocked(0)
+		if p.StopCh != nil {
+			close(p.StopCh)
+			p.StopCh = nil
+			p.RefillCh = nil
+		}
+		return
+	}
+	p.discardLocked(opts.Size)
+	if p.RefillCh == nil {
+		p.RefillCh = make(chan struct{}, 1)
+		p.StopCh = make(chan struct{})
+		go func(refillCh chan struct{}, stopCh chan struct{}) {
+			defer panichandler.PanicHandler("warmPool:refill")
+			p.run(refillCh, stopCh)
+		}(p.RefillCh, p.StopCh)
+	}
+	p.signalRefillLocked()
+}
+
+// closes idle ptys past keep
+func (p *warmPool) discardLocked(keep int) {
+	for len(p.Ptys) > keep {
+		p.Ptys[len(p.Ptys)-1].close()
+		p.Ptys = p.Ptys[:len(p.Ptys)-1]
+	}
+}
+
+// (a no-op while the pool is disabled, RefillCh is nil)
+func (p *warmPool) signalRefillLocked() {
+	select {
+	case p.RefillCh <- struct{}{}:
+	default:
+	}
+}
+
+func (p *warmPool) run(refillCh chan struct{}, stopCh chan struct{}) {
+	for {
+		p.Lock.Lock()
+		clk, maxIdle := p.Clock, p.Opts.MaxIdle
+		p.Lock.Unlock()
+		timerCh, stopTimer := clk.NewTimer(maxIdle / 2)
+		select {
+		case <-stopCh:
+			stopTimer()
+			return
+		case <-refillCh:
+		case <-timerCh:
+		}
+		stopTimer()
+		p.refill(stopCh)
+	}
+}
+
+func (p *warmPool) isStaleLocked(wp *warmPty) bool {
+	return p.Clock.Now().Sub(wp.OpenedAt) >= p.Opts.MaxIdle
+}
+
+// replaces stale ptys and tops the pool up to Size.  ptys are opened without the
+// lock held, so a pty opened while the pool was shrunk (or disabled) is closed.
+func (p *warmPool) refill(stopCh chan struct{}) {
+	p.Lock.Lock()
+	fresh := p.Ptys[:0]
+	for _, wp := range p.Ptys {
+		if p.isStaleLocked(wp) {
+			wp.close()
+			continue
+		}
+		fresh = append(fresh, wp)
+	}
+	p.Ptys = fresh
+	need := p.Opts.Size - len(p.Ptys)
+	p.Lock.Unlock()
+	for ; need > 0; need-- {
+		ptyFile, ttyFile, err := pty.Open()
+		if err != nil {
+			log.Printf("warning: warm pool cannot open a pty: %v\n", err)
+			return
+		}
+		p.Lock.Lock()
+		wp := &warmPty{Pty: ptyFile, Tty: ttyFile, OpenedAt: p.Clock.Now()}
+		select {
+		case <-stopCh:
+			wp.close()
+			p.Lock.Unlock()
+			return
+		default:
+		}
+		if len(p.Ptys) >= p.Opts.Size {
+			wp.close()
+		} else {
+			p.Ptys = append(p.Ptys, wp)
+		}
+		p.Lock.Unlock()
+	}
+}
+
+// takePty returns an idle pty (nil if the pool is empty or disabled), the pool refills in the background
+func (p *warmPool) takePty() *warmPty {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	for len(p.Ptys) > 0 {
+		wp := p.Ptys[len(p.Ptys)-1]
+		p.Ptys = p.Ptys[:len(p.Ptys)-1]
+		if p.isStaleLocked(wp) {
+			wp.close()
+			continue
+		}
+		p.signalRefillLocked()
+		return wp
+	}
+	p.signalRefillLocked()
+	return nil
+}
+
+// getEnv returns the cached env (computing it if needed), nil if the pool is disabled
+func (p *warmPool) getEnv() *warmEnv {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	if p.Opts.Size == 0 {
+		return nil
+	}
+	if p.Env == nil {
+		p.Env = makeWarmEnv(p.Opts.ConfigKey)
+	}
+	return p.Env
+}
+
+func (p *warmPool) idleCount() int {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	return len(p.Ptys)
+}
+
+// options that set the pty up differently than a fresh pty.Open must start cold
+func useWarmPool(cmdOpts CommandOptsType) bool {
+	return warmPtySupported && !cmdOpts.NoWarmPool && cmdOpts.Supervise == nil
+}
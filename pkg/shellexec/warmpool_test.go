@@ -0,0 +1,170 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+func enableTestWarmPool(t testing.TB, opts WarmPoolOpts) {
+	t.Helper()
+	if !warmPtySupported {
+		t.Skip("no warm pool on this platform")
+	}
+	SetWarmPool(opts)
+	t.Cleanup(func() { SetWarmPool(WarmPoolOpts{}) })
+}
+
+func waitWarmIdle(t *testing.T, want int) {
+	t.Helper()
+	deadline := time.Now().Add(testWaitTimeout)
+	for time.Now().Before(deadline) {
+		if globalWarmPool.idleCount() == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d idle ptys (have %d)", want, globalWarmPool.idleCount())
+}
+
+// the pty takePty will return next
+func peekWarmPty() *warmPty {
+	globalWarmPool.Lock.Lock()
+	defer globalWarmPool.Lock.Unlock()
+	if len(globalWarmPool.Ptys) == 0 {
+		return nil
+	}
+	return globalWarmPool.Ptys[len(globalWarmPool.Ptys)-1]
+}
+
+// returns -1 where open fds can't be counted
+func countOpenFds() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+func TestWarmPoolStart(t *testing.T) {
+	enableTestWarmPool(t, WarmPoolOpts{Size: 2})
+	waitWarmIdle(t, 2)
+	taken := peekWarmPty().Pty
+	sp := startTestShellProc(t, `echo "term=[$TERM] tty=[$(tty)]"`, CommandOptsType{})
+	oc := collectOutput(sp)
+	oc.waitFor(t, "term=[xterm-256color] tty=[/dev/")
+	if sp.Cmd.(CmdWrap).Pty != taken {
+		t.Errorf("expected the shell to get the idle pty")
+	}
+	if report := sp.EnvReport(); report.WonBy("TERM_PROGRAM") == "" || report.Layers[0] != "inherited" {
+		t.Errorf("unexpected env report from the cached env: %+v", report.Layers)
+	}
+	waitWarmIdle(t, 2)
+
+	// the cached env is shared, a TermType of its own must not change it
+	sp = startTestShellProc(t, `echo "term=[$TERM]"`, CommandOptsType{TermType: "dumb"})
+	oc = collectOutput(sp)
+	oc.waitFor(t, "term=[dumb]")
+	if term := globalWarmPool.getEnv().Waveshell["TERM"]; term != shellutil.DefaultTermType {
+		t.Errorf("expected the cached env to keep TERM=%s, got %q", shellutil.DefaultTermType, term)
+	}
+	waitWarmIdle(t, 2)
+
+	// bypassed
+	taken = peekWarmPty().Pty
+	sp = startTestShellProc(t, "echo cold", CommandOptsType{NoWarmPool: true})
+	oc = collectOutput(sp)
+	oc.waitFor(t, "cold")
+	if sp.Cmd.(CmdWrap).Pty == taken || globalWarmPool.idleCount() != 2 {
+		t.Errorf("NoWarmPool should not take an idle pty")
+	}
+}
+
+func TestWarmPoolCapAndCleanup(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("counts fds through /proc")
+	}
+	enableTestWarmPool(t, WarmPoolOpts{Size: MaxWarmPoolSize + 5})
+	waitWarmIdle(t, MaxWarmPoolSize)
+	// (the fds are closed synchronously, compare right around the calls, earlier tests' shells may still be closing theirs)
+	before := countOpenFds()
+	SetWarmPool(WarmPoolOpts{Size: 1})
+	if closed := before - countOpenFds(); closed != 2*(MaxWarmPoolSize-1) {
+		t.Errorf("shrinking the pool should close %d fds, closed %d", 2*(MaxWarmPoolSize-1), closed)
+	}
+	before = countOpenFds()
+	SetWarmPool(WarmPoolOpts{})
+	if closed := before - countOpenFds(); closed != 2 {
+		t.Errorf("disabling the pool should close 2 fds, closed %d", closed)
+	}
+}
+
+// closed *os.Files return ^uintptr(0)
+func warmPtyClosed(wp *warmPty) bool {
+	return wp.Pty.Fd() == ^uintptr(0) && wp.Tty.Fd() == ^uintptr(0)
+}
+
+func TestWarmPoolReplacesStale(t *testing.T) {
+	enableTestWarmPool(t, WarmPoolOpts{Size: 1, MaxIdle: 50 * time.Millisecond})
+	waitWarmIdle(t, 1)
+	first := peekWarmPty()
+	time.Sleep(200 * time.Millisecond)
+	waitWarmIdle(t, 1)
+	if peekWarmPty() == first {
+		t.Errorf("expected the stale pty to be replaced")
+	}
+	if !warmPtyClosed(first) {
+		t.Errorf("the stale pty was not closed")
+	}
+}
+
+func TestWarmPoolConfigKeyInvalidatesEnv(t *testing.T) {
+	enableTestWarmPool(t, WarmPoolOpts{Size: 1, ConfigKey: "a"})
+	env := globalWarmPool.getEnv()
+	SetWarmPool(WarmPoolOpts{Size: 1, ConfigKey: "a"})
+	if globalWarmPool.getEnv() != env {
+		t.Errorf("the env should be kept when the settings haven't changed")
+	}
+	SetWarmPool(WarmPoolOpts{Size: 1, ConfigKey: "b"})
+	if globalWarmPool.getEnv() == env {
+		t.Errorf("expected a new settings key to invalidate the env")
+	}
+}
+
+// starts 50 shells one after the other, compare ms/start (only StartShellProc is timed)
+func benchmarkSequentialStarts(b *testing.B, cmdOpts CommandOptsType) {
+	const numShells = 50
+	cmdOpts.ShellPath = requireBinary(b, "bash")
+	var total time.Duration
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < numShells; j++ {
+			start := time.Now()
+			sp, err := StartShellProc(waveobj.TermSize{Rows: 24, Cols: 80}, "true", cmdOpts)
+			total += time.Since(start)
+			if err != nil {
+				b.Fatalf("error starting shell: %v", err)
+			}
+			b.StopTimer()
+			sp.Close()
+			<-sp.DoneCh
+			b.StartTimer()
+		}
+	}
+	b.ReportMetric(float64(total.Microseconds())/1000/float64(b.N*numShells), "ms/start")
+}
+
+func BenchmarkStartCold(b *testing.B) {
+	benchmarkSequentialStarts(b, CommandOptsType{NoWarmPool: true})
+}
+
+func BenchmarkStartWarm(b *testing.B) {
+	enableTestWarmPool(b, WarmPoolOpts{Size: 4})
+	benchmarkSequentialStarts(b, CommandOptsType{})
+}
@@ -0,0 +1,157 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+)
+
+const (
+	wslExeName      = "wsl.exe"
+	wslEnvVarName   = "WSLENV"
+	wslMountRoot    = "/mnt/" // the default automount root (wsl.conf can change it)
+	wslDefaultShell = "/bin/sh"
+)
+
+var wslDistroNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// windows paths, wsl translates them into the distro's (/mnt/c/...) with the /p flag
+var wslPathVarNames = map[string]bool{
+	"WAVETERM":            true,
+	"WAVETERM_WSHBINDIR":  true,
+	WaveScratchDirVarName: true,
+}
+
+// never passed: wsl builds the distro's PATH itself (appending the windows PATH if interop is on)
+var wslSkipVarNames = map[string]bool{
+	"PATH":        true,
+	wslEnvVarName: true,
+}
+
+func resolveWslDistro(cmdOpts CommandOptsType) error {
+	if cmdOpts.WslDistro == "" {
+		return nil
+	}
+	if runtime.GOOS != "windows" {
+		return fmt.Errorf("WslDistro is only supported on windows")
+	}
+	if !wslDistroNameRe.MatchString(cmdOpts.WslDistro) {
+		return fmt.Errorf("invalid wsl distribution name %q", cmdOpts.WslDistro)
+	}
+	if _, err := exec.LookPath(wslExeName); err != nil {
+		return fmt.Errorf("cannot run shell in wsl distribution %q: %w", cmdOpts.WslDistro, err)
+	}
+	return nil
+}
+
+// wslPath translates a windows path (C:\Users\me, or \\wsl$\distro\... for a path inside distro) to the
+// distro's path.  linux paths (and ~) are returned as is, false if the path has no equivalent in the distro
+func wslPath(winPath string, distro string) (string, bool) {
+	if winPath == "~" || strings.HasPrefix(winPath, "~/") || strings.HasPrefix(winPath, "/") {
+		return winPath, true
+	}
+	slashPath := strings.ReplaceAll(winPath, `\`, "/")
+	if len(slashPath) >= 2 && slashPath[1] == ':' && isAsciiLetter(slashPath[0]) {
+		rest := strings.TrimLeft(slashPath[2:], "/")
+		drive := wslMountRoot + strings.ToLower(slashPath[:1])
+		if rest == "" {
+			return drive, true
+		}
+		return drive + "/" + rest, true
+	}
+	for _, prefix := range []string{"//wsl$/", "//wsl.localhost/"} {
+		if len(slashPath) < len(prefix) || !strings.EqualFold(slashPath[:len(prefix)], prefix) {
+			continue
+		}
+		pathDistro, rest, _ := strings.Cut(slashPath[len(prefix):], "/")
+		if !strings.EqualFold(pathDistro, distro) {
+			return "", false
+		}
+		return "/" + rest, true
+	}
+	return "", false
+}
+
+func isAsciiLetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+// wslDistroArgs returns the wsl.exe args that run the shell (or argv) in cmdOpts.WslDistro, in cwd (a distro
+// path).  without a ShellPath (or cmdStr) wsl starts the distro user's default shell, as a login shell
+func wslDistroArgs(cmdOpts CommandOptsType, cwd string, cmdStr string, argv []string) []string {
+	rtn := []string{"-d", cmdOpts.WslDistro, "--cd", cwd}
+	if argv != nil || cmdStr != "" || cmdOpts.ShellPath != "" {
+		rtn = append(rtn, "--exec")
+		rtn = append(rtn, offHostArgv(cmdOpts, cmdStr, argv, wslDefaultShell)...)
+	}
+	return rtn
+}
+
+// wslDistroCmd runs wsl.exe (the process we wait for, its exit code is the shell's), cmdOpts.Cwd is
+// translated with wslPath (the distro user's home if it can't be)
+func wslDistroCmd(cmdOpts CommandOptsType, cmdStr string, argv []string) *exec.Cmd {
+	cwd := "~"
+	if cmdOpts.Cwd != "" {
+		if distroCwd, ok := wslPath(cmdOpts.Cwd, cmdOpts.WslDistro); ok {
+			cwd = distroCwd
+		} else {
+			shellLogf(cmdOpts.SessionID, "warning: cwd %q is not in wsl distribution %q, starting in home", cmdOpts.Cwd, cmdOpts.WslDistro)
+		}
+	}
+	return exec.Command(wslExeName, wslDistroArgs(cmdOpts, cwd, cmdStr, argv)...)
+}
+
+// wslEnvValue adds names (the vars we set for the shell) to WSLENV (existing is our own, if any), they are
+// only passed into the distro (/u) and windows paths are translated (/p).  names already listed keep
+// their flags
+func wslEnvValue(existing string, names []string) string {
+	listed := make(map[string]bool)
+	var entries []string
+	for _, entry := range strings.Split(existing, ":") {
+		if entry == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(entry, "/")
+		listed[strings.ToUpper(name)] = true
+		entries = append(entries, entry)
+	}
+	for _, name := range names {
+		upperName := strings.ToUpper(name)
+		if listed[upperName] || wslSkipVarNames[upperName] {
+			continue
+		}
+		listed[upperName] = true
+		if wslPathVarNames[upperName] {
+			entries = append(entries, name+"/up")
+		} else {
+			entries = append(entries, name+"/u")
+		}
+	}
+	return strings.Join(entries, ":")
+}
+
+// setWslEnv lists the vars from our layers (and Env) in ecmd's WSLENV so wsl.exe passes them into the
+// distro, the inherited ones stay on the windows side
+func setWslEnv(ecmd *exec.Cmd, envReport shellutil.EnvReport) {
+	existing := ""
+	wslEnvIdx := -1
+	for idx, envStr := range ecmd.Env {
+		if name, val, _ := strings.Cut(envStr, "="); strings.EqualFold(name, wslEnvVarName) {
+			existing = val
+			wslEnvIdx = idx
+		}
+	}
+	wslEnvStr := wslEnvVarName + "=" + wslEnvValue(existing, ourEnvNames(ecmd, envReport))
+	if wslEnvIdx >= 0 {
+		ecmd.Env[wslEnvIdx] = wslEnvStr
+	} else {
+		ecmd.Env = append(ecmd.Env, wslEnvStr)
+	}
+}
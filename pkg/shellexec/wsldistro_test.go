@@ -0,0 +1,88 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellexec
+
+import (
+	"os/exec"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
+	"github.com/wavetermdev/waveterm/pkg/waveobj"
+)
+
+func TestWslPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		ok       bool
+	}{
+		{`C:\Users\me\my project`, "/mnt/c/Users/me/my project", true},
+		{`d:/src/`, "/mnt/d/src/", true},
+		{`C:\`, "/mnt/c", true},
+		{"/home/me", "/home/me", true},
+		{"~", "~", true},
+		{`\\wsl$\Ubuntu\home\me`, "/home/me", true},
+		{`\\WSL.localhost\ubuntu\etc`, "/etc", true},
+		{`\\wsl$\Debian\home\me`, "", false},
+		{`\\fileserver\share`, "", false},
+		{"relative", "", false},
+	}
+	for _, test := range tests {
+		if result, ok := wslPath(test.path, "Ubuntu"); result != test.expected || ok != test.ok {
+			t.Errorf("wslPath(%q) = %q, %v; want %q, %v", test.path, result, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestWslDistroArgs(t *testing.T) {
+	tests := []struct {
+		cmdOpts  CommandOptsType
+		cmdStr   string
+		argv     []string
+		expected []string
+	}{
+		{CommandOptsType{WslDistro: "Ubuntu"}, "", nil, []string{"-d", "Ubuntu", "--cd", "~"}},
+		{CommandOptsType{WslDistro: "Ubuntu", ShellPath: "/usr/bin/zsh", Login: true}, "", nil, []string{"-d", "Ubuntu", "--cd", "~", "--exec", "/usr/bin/zsh", "-l"}},
+		{CommandOptsType{WslDistro: "Ubuntu", ShellOpts: []string{"-e"}}, "make", nil, []string{"-d", "Ubuntu", "--cd", "~", "--exec", "/bin/sh", "-e", "-c", "make"}},
+		{CommandOptsType{WslDistro: "Ubuntu", ShellPath: "/usr/bin/zsh"}, "", []string{"top", "-b"}, []string{"-d", "Ubuntu", "--cd", "~", "--exec", "top", "-b"}},
+	}
+	for _, test := range tests {
+		if args := wslDistroArgs(test.cmdOpts, "~", test.cmdStr, test.argv); !slices.Equal(args, test.expected) {
+			t.Errorf("%+v %q %q: expected %q, got %q", test.cmdOpts, test.cmdStr, test.argv, test.expected, args)
+		}
+	}
+}
+
+func TestWslEnv(t *testing.T) {
+	if value := wslEnvValue("", []string{"TERM", "PATH", WaveScratchDirVarName}); value != "TERM/u:"+WaveScratchDirVarName+"/up" {
+		t.Errorf("unexpected WSLENV %q", value)
+	}
+	// the user's own entries keep their flags
+	if value := wslEnvValue("USERPROFILE/p:TERM", []string{"TERM", "MYVAR"}); value != "USERPROFILE/p:TERM:MYVAR/u" {
+		t.Errorf("unexpected WSLENV %q", value)
+	}
+
+	ecmd := exec.Command("wsl.exe")
+	var envReport shellutil.EnvReport
+	ecmd.Env, envReport = shellutil.BuildEnv(
+		shellutil.EnvLayer{Name: shellutil.EnvLayer_Inherited, Environ: []string{"HOME=/home/me", "WSLENV=USERPROFILE/p"}},
+		shellutil.EnvLayer{Name: shellutil.EnvLayer_Waveshell, Vars: map[string]string{"TERM_PROGRAM": "waveterm"}},
+		shellutil.EnvLayer{Name: shellutil.EnvLayer_CmdOpts, Vars: map[string]string{"MyVar": "1"}},
+	)
+	setWslEnv(ecmd, envReport)
+	if val, _ := cmdEnvLookup(ecmd, wslEnvVarName); val != "USERPROFILE/p:TERM_PROGRAM/u:MyVar/u" {
+		t.Errorf("expected only our vars to be added to WSLENV, got %q", val)
+	}
+}
+
+func TestWslDistroNotWindows(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("wsl distributions are supported on windows")
+	}
+	if _, err := StartShellProc(waveobj.TermSize{Rows: 24, Cols: 80}, "", CommandOptsType{WslDistro: "Ubuntu"}); err == nil {
+		t.Errorf("expected WslDistro to fail on %s", runtime.GOOS)
+	}
+}
@@ -55,6 +55,7 @@ type TelemetryData struct {
 	Blocks        map[string]int               `json:"blocks,omitempty"`
 	WshCmds       map[string]int               `json:"wshcmds,omitempty"`
 	Conn          map[string]int               `json:"conn,omitempty"`
+	ShellStartup  map[string]int               `json:"shellstartup,omitempty"`
 }
 
 func (tdata TelemetryData) Value() (driver.Value, error) {
@@ -165,6 +166,14 @@ func UpdateActivity(ctx context.Context, update wshrpc.ActivityUpdate) error {
 				tdata.Conn[key] += val
 			}
 		}
+		if len(update.ShellStartup) > 0 {
+			if tdata.ShellStartup == nil {
+				tdata.ShellStartup = make(map[string]int)
+			}
+			for key, val := range update.ShellStartup {
+				tdata.ShellStartup[key] += val
+			}
+		}
 		if len(update.Displays) > 0 {
 			tdata.Displays = update.Displays
 		}
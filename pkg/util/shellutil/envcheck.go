@@ -0,0 +1,158 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultMaxEnvValueSize = 32 * 1024  // values larger than this get a warning (or are dropped)
+	LinuxMaxArgStrLen      = 128 * 1024 // MAX_ARG_STRLEN, the kernel rejects any single "key=val" (or arg) larger than this
+	envTopOffenders        = 5
+	envPtrSize             = 8 // the kernel also counts the argv/envp pointers
+)
+
+// EnvCheckOpts configures CheckEnv, the zero value uses the defaults
+type EnvCheckOpts struct {
+	MaxValueSize  int  // warning threshold for a single value (-1 to disable)
+	DropOversized bool // drop values over MaxValueSize instead of just warning
+	ArgMax        int  // limit for the total size of argv + env (defaults to DefaultArgMax())
+}
+
+type EnvCheckResult struct {
+	Env      []string // the env to use (oversized values removed if DropOversized)
+	Warnings []string
+	Dropped  []string // names of dropped variables
+}
+
+// DefaultArgMax returns the (typical) ARG_MAX for the current os
+func DefaultArgMax() int {
+	switch runtime.GOOS {
+	case "linux":
+		return 2 * 1024 * 1024 // stack rlimit / 4 with the default 8MB stack
+	case "darwin":
+		return 1024 * 1024
+	case "windows":
+		return 32 * 1024 // max command line length (the env block can be larger, but this is the limit that usually bites)
+	default:
+		return 256 * 1024
+	}
+}
+
+func execStrSize(str string) int {
+	return len(str) + 1 + envPtrSize
+}
+
+type envSizeEntry struct {
+	Name string
+	Size int
+}
+
+// CheckEnv validates the environment (and args) a command will be started
+// with.  It returns an error naming the offending variable for values the OS
+// would reject (NUL bytes, '=' in names, a total size over ARG_MAX), and
+// warnings for values over opts.MaxValueSize.
+func CheckEnv(env []string, args []string, opts EnvCheckOpts) (EnvCheckResult, error) {
+	maxValueSize := opts.MaxValueSize
+	if maxValueSize == 0 {
+		maxValueSize = DefaultMaxEnvValueSize
+	}
+	argMax := opts.ArgMax
+	if argMax <= 0 {
+		argMax = DefaultArgMax()
+	}
+	rtn := EnvCheckResult{Env: make([]string, 0, len(env))}
+	var sizes []envSizeEntry
+	totalSize := 0
+	for idx, arg := range args {
+		if strings.IndexByte(arg, 0) >= 0 {
+			return rtn, fmt.Errorf("command argument %d contains a NUL byte", idx)
+		}
+		totalSize += execStrSize(arg)
+	}
+	if len(args) > 0 {
+		sizes = append(sizes, envSizeEntry{Name: "(command arguments)", Size: totalSize})
+	}
+	for _, envStr := range env {
+		if runtime.GOOS == "windows" && strings.HasPrefix(envStr, "=") {
+			// per-drive cwd entries ("=C:=C:\dir")
+			rtn.Env = append(rtn.Env, envStr)
+			totalSize += execStrSize(envStr)
+			continue
+		}
+		name, val, found := strings.Cut(envStr, "=")
+		if !found {
+			return rtn, fmt.Errorf("invalid environment entry %q (no '=')", limitEnvStr(envStr))
+		}
+		if name == "" {
+			return rtn, fmt.Errorf("invalid environment entry %q (empty name)", limitEnvStr(envStr))
+		}
+		if strings.IndexByte(name, 0) >= 0 {
+			return rtn, fmt.Errorf("environment variable name %q contains a NUL byte", limitEnvStr(name))
+		}
+		if strings.IndexByte(val, 0) >= 0 {
+			return rtn, fmt.Errorf("environment variable %s contains a NUL byte", name)
+		}
+		if runtime.GOOS == "linux" && len(envStr) >= LinuxMaxArgStrLen {
+			if opts.DropOversized {
+				rtn.Dropped = append(rtn.Dropped, name)
+				rtn.Warnings = append(rtn.Warnings, fmt.Sprintf("dropped environment variable %s (%d bytes, over the %d byte kernel limit)", name, len(val), LinuxMaxArgStrLen))
+				continue
+			}
+			return rtn, fmt.Errorf("environment variable %s is %d bytes, over the %d byte kernel limit for a single variable", name, len(val), LinuxMaxArgStrLen)
+		}
+		if maxValueSize > 0 && len(val) > maxValueSize {
+			if opts.DropOversized {
+				rtn.Dropped = append(rtn.Dropped, name)
+				rtn.Warnings = append(rtn.Warnings, fmt.Sprintf("dropped environment variable %s (%d bytes, max is %d)", name, len(val), maxValueSize))
+				continue
+			}
+			rtn.Warnings = append(rtn.Warnings, fmt.Sprintf("environment variable %s is large (%d bytes)", name, len(val)))
+		}
+		rtn.Env = append(rtn.Env, envStr)
+		sizes = append(sizes, envSizeEntry{Name: name, Size: execStrSize(envStr)})
+		totalSize += execStrSize(envStr)
+	}
+	if totalSize > argMax {
+		sort.SliceStable(sizes, func(i, j int) bool { return sizes[i].Size > sizes[j].Size })
+		var offenders []string
+		for _, entry := range sizes[:min(len(sizes), envTopOffenders)] {
+			offenders = append(offenders, fmt.Sprintf("%s (%d bytes)", entry.Name, entry.Size))
+		}
+		return rtn, fmt.Errorf("environment and arguments are too large (%d bytes, limit is %d), largest: %s", totalSize, argMax, strings.Join(offenders, ", "))
+	}
+	return rtn, nil
+}
+
+func limitEnvStr(str string) string {
+	str = strings.ReplaceAll(str, "\x00", `\0`)
+	if len(str) > 40 {
+		return str[:37] + "..."
+	}
+	return str
+}
+
+// ValidateCmdEnv runs CheckEnv on cmd's env and args (logging any warnings)
+// and updates cmd.Env.  Call this right before starting the command.
+func ValidateCmdEnv(cmd *exec.Cmd, opts EnvCheckOpts) error {
+	env := cmd.Env
+	if env == nil {
+		env = cmd.Environ()
+	}
+	result, err := CheckEnv(env, cmd.Args, opts)
+	if err != nil {
+		return err
+	}
+	for _, warning := range result.Warnings {
+		log.Printf("warning: %s", warning)
+	}
+	cmd.Env = result.Env
+	return nil
+}
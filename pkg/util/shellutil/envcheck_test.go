@@ -0,0 +1,88 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func checkEnvError(t *testing.T, env []string, args []string, opts EnvCheckOpts, errSubstrs ...string) {
+	t.Helper()
+	_, err := CheckEnv(env, args, opts)
+	if err == nil {
+		t.Fatalf("expected an error for env %q", env)
+	}
+	for _, substr := range errSubstrs {
+		if !strings.Contains(err.Error(), substr) {
+			t.Errorf("error %q should mention %q", err.Error(), substr)
+		}
+	}
+}
+
+func TestCheckEnvRejects(t *testing.T) {
+	checkEnvError(t, []string{"HOME=/home/me", "BADVAR=foo\x00bar"}, nil, EnvCheckOpts{}, "BADVAR", "NUL")
+	checkEnvError(t, []string{"BAD\x00NAME=foo"}, nil, EnvCheckOpts{}, "BAD", "name", "NUL")
+	checkEnvError(t, []string{"NOEQUALS"}, nil, EnvCheckOpts{}, "NOEQUALS")
+	checkEnvError(t, []string{"=novalue"}, nil, EnvCheckOpts{}, "empty name")
+	checkEnvError(t, []string{"A=b"}, []string{"bash", "-c", "echo\x00"}, EnvCheckOpts{}, "argument 2")
+}
+
+func TestCheckEnvArgMax(t *testing.T) {
+	env := []string{"SMALL=1", "BIG1=" + strings.Repeat("x", 600), "BIG2=" + strings.Repeat("y", 500)}
+	checkEnvError(t, env, []string{"bash"}, EnvCheckOpts{ArgMax: 1000}, "too large", "BIG1 (", "BIG2 (")
+	if _, err := CheckEnv(env, []string{"bash"}, EnvCheckOpts{ArgMax: 2000}); err != nil {
+		t.Errorf("unexpected error under the limit: %v", err)
+	}
+	// dropping oversized values can bring the total back under the limit
+	result, err := CheckEnv(env, []string{"bash"}, EnvCheckOpts{ArgMax: 1000, MaxValueSize: 550, DropOversized: true})
+	if err != nil {
+		t.Fatalf("unexpected error after dropping: %v", err)
+	}
+	if !reflect.DeepEqual(result.Dropped, []string{"BIG1"}) || len(result.Env) != 2 {
+		t.Errorf("expected BIG1 to be dropped, got dropped=%v env=%d", result.Dropped, len(result.Env))
+	}
+}
+
+func TestCheckEnvOversized(t *testing.T) {
+	bigVal := "GIT_EXTERNAL_DIFF=" + strings.Repeat("z", 40*1024)
+	result, err := CheckEnv([]string{"A=1", bigVal}, nil, EnvCheckOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Env) != 2 || len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "GIT_EXTERNAL_DIFF") {
+		t.Errorf("expected a warning for GIT_EXTERNAL_DIFF, got %#v", result.Warnings)
+	}
+	result, err = CheckEnv([]string{"A=1", bigVal}, nil, EnvCheckOpts{MaxValueSize: -1})
+	if err != nil || len(result.Warnings) != 0 {
+		t.Errorf("no warnings expected with the size check disabled: %v %v", err, result.Warnings)
+	}
+	if runtime.GOOS != "linux" {
+		return
+	}
+	hugeVal := "GIT_EXTERNAL_DIFF=" + strings.Repeat("z", 2*1024*1024)
+	checkEnvError(t, []string{hugeVal}, nil, EnvCheckOpts{ArgMax: 8 * 1024 * 1024}, "GIT_EXTERNAL_DIFF", "kernel limit")
+	result, err = CheckEnv([]string{"A=1", hugeVal}, nil, EnvCheckOpts{DropOversized: true})
+	if err != nil || !reflect.DeepEqual(result.Env, []string{"A=1"}) {
+		t.Errorf("expected the huge value to be dropped: %v %v", err, result.Dropped)
+	}
+}
+
+func TestValidateCmdEnv(t *testing.T) {
+	cmd := exec.Command("true")
+	cmd.Env = []string{}
+	if err := ValidateCmdEnv(cmd, EnvCheckOpts{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cmd.Env == nil {
+		t.Errorf("an empty env must stay empty (nil would inherit the parent env)")
+	}
+	cmd.Env = []string{"OK=1", "BROKEN=a\x00b"}
+	if err := ValidateCmdEnv(cmd, EnvCheckOpts{}); err == nil || !strings.Contains(err.Error(), "BROKEN") {
+		t.Errorf("expected an error naming BROKEN, got %v", err)
+	}
+}
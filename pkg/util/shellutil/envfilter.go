@@ -0,0 +1,64 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"fmt"
+	"path"
+	"runtime"
+)
+
+// the layer (right after inherited) that applies an EnvFilter
+const EnvLayer_Filter = "envfilter"
+
+// EnvFilter limits which inherited variables are passed on.  Patterns are matched against the
+// whole name with path.Match ("AWS_*", "*_TOKEN"), case insensitively on windows.  If Allow is
+// set only matching names are kept, then names matching Deny are removed.
+type EnvFilter struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Validate checks the patterns
+func (f *EnvFilter) Validate() error {
+	if f == nil {
+		return nil
+	}
+	for _, pattern := range append(append([]string(nil), f.Allow...), f.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid env filter pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// Layer returns the EnvLayer_Filter layer (a no-op for a nil filter), it goes right after the
+// inherited layer so the vars we (and the user, cmdOpts.Env) set are never filtered
+func (f *EnvFilter) Layer() EnvLayer {
+	layer := EnvLayer{Name: EnvLayer_Filter}
+	if f != nil && (len(f.Allow) > 0 || len(f.Deny) > 0) {
+		layer.Keep = func(name string, _ string) bool {
+			return f.keep(name, runtime.GOOS == "windows")
+		}
+	}
+	return layer
+}
+
+// patterns must be valid (see Validate)
+func (f *EnvFilter) keep(name string, foldCase bool) bool {
+	if len(f.Allow) > 0 && !matchEnvPatterns(f.Allow, name, foldCase) {
+		return false
+	}
+	return !matchEnvPatterns(f.Deny, name, foldCase)
+}
+
+func matchEnvPatterns(patterns []string, name string, foldCase bool) bool {
+	name = envFoldName(name, foldCase)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(envFoldName(pattern, foldCase), name); matched {
+			return true
+		}
+	}
+	return false
+}
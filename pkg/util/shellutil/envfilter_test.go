@@ -0,0 +1,39 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"testing"
+)
+
+func TestEnvFilter(t *testing.T) {
+	inherited := EnvLayer{Name: EnvLayer_Inherited, Environ: []string{"PATH=/bin", "AWS_SECRET=x", "GH_TOKEN=y", "SSH_AUTH_SOCK=/tmp/s", "HOME=/home/me"}}
+	filter := &EnvFilter{Deny: []string{"AWS_*", "*_TOKEN", "SSH_AUTH_SOCK"}}
+	env, report := BuildEnv(inherited, filter.Layer(), EnvLayer{Name: EnvLayer_CmdOpts, Vars: map[string]string{"GH_TOKEN": "mine"}})
+	// vars set after the filter are kept
+	checkEnv(t, env, "PATH=/bin", "GH_TOKEN=mine", "HOME=/home/me")
+	checkVarReport(t, report, "AWS_SECRET", EnvVarReport{SetBy: []string{EnvLayer_Inherited}, RemovedBy: EnvLayer_Filter})
+
+	filter = &EnvFilter{Allow: []string{"PATH", "HOME", "AWS_*"}, Deny: []string{"AWS_SECRET"}}
+	env, _ = BuildEnv(inherited, filter.Layer())
+	checkEnv(t, env, "PATH=/bin", "HOME=/home/me")
+
+	// nil and empty filters keep everything
+	for _, filter := range []*EnvFilter{nil, {}} {
+		env, _ = BuildEnv(inherited, filter.Layer())
+		checkEnv(t, env, inherited.Environ...)
+	}
+
+	if !(&EnvFilter{Allow: []string{"path"}}).keep("Path", true) {
+		t.Errorf("expected patterns to be case insensitive when folding case")
+	}
+	for _, filter := range []*EnvFilter{{Allow: []string{"["}}, {Deny: []string{""}}} {
+		if err := filter.Validate(); err == nil {
+			t.Errorf("expected an error for %+v", filter)
+		}
+	}
+	if err := (*EnvFilter)(nil).Validate(); err != nil {
+		t.Errorf("a nil filter should be valid, got %v", err)
+	}
+}
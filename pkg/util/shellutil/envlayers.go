@@ -0,0 +1,227 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// the standard layers, in precedence order (lowest first).  BuildEnv applies layers
+// in the order they are passed, callers pass them in this order:
+//
+//	inherited     the wavesrv environment (os.Environ)
+//	envfilter     removes inherited vars (see EnvFilter), no vars of its own
+//	runas         HOME, USER and LOGNAME of the user a shell runs as (see shellexec's cmdOpts.Uid)
+//	integration   vars our shell integration needs (ZDOTDIR, the pushenv file)
+//	waveshell     TERM, TERM_PROGRAM, WAVETERM_*, and LANG if it isn't set
+//	history       the HistoryScope vars (HISTFILE, fish_history...)
+//	cmdopts       cmdOpts.Env (the block's cmd:env, the wsh jwt), the user always wins
+//
+// filters (EnvLayer.Keep) apply to everything set before them.
+const (
+	EnvLayer_Inherited   = "inherited"
+	EnvLayer_RunAs       = "runas"
+	EnvLayer_Integration = "integration"
+	EnvLayer_Waveshell   = "waveshell"
+	EnvLayer_History     = "history"
+	EnvLayer_CmdOpts     = "cmdopts"
+)
+
+// EnvLayer is one source of environment variables for BuildEnv.  Environ is
+// applied first, then Vars, then Unset, then Keep.
+type EnvLayer struct {
+	Name    string
+	Environ []string          // "NAME=value" entries (e.g. os.Environ()), later duplicates win
+	Vars    map[string]string // an empty value unsets the var
+	Unset   []string
+	Keep    func(name string, val string) bool // if set, vars that fail it are removed
+}
+
+// EnvVarReport is how a variable got its final value (values are not recorded, the env holds secrets)
+type EnvVarReport struct {
+	Layer     string   `json:"layer,omitempty"`     // the layer whose value won, "" if the var was removed
+	SetBy     []string `json:"setby,omitempty"`     // every layer that set it, in order (the last one won unless it was removed)
+	RemovedBy string   `json:"removedby,omitempty"` // the layer that removed it (after the last set)
+}
+
+// EnvReport records, per variable (including removed ones), which layer won
+type EnvReport struct {
+	Layers []string                `json:"layers"`
+	Vars   map[string]EnvVarReport `json:"vars"`
+}
+
+// WonBy returns the layer that set name's final value, "" if it isn't set
+func (r EnvReport) WonBy(name string) string {
+	return r.Vars[envFoldName(name, runtime.GOOS == "windows")].Layer
+}
+
+// FromLayers returns the names (sorted) whose final value came from one of layerNames
+func (r EnvReport) FromLayers(layerNames ...string) []string {
+	var rtn []string
+	for name, varReport := range r.Vars {
+		for _, layerName := range layerNames {
+			if varReport.Layer == layerName {
+				rtn = append(rtn, name)
+				break
+			}
+		}
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+// windows env names are case insensitive, we key (and report) them upper cased
+func envFoldName(name string, foldCase bool) string {
+	if foldCase {
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+type envBuilder struct {
+	FoldCase bool
+	Order    []string          // keys in first-set order (removed keys stay, they keep their place if set again)
+	Entries  map[string]string // key -> "NAME=value"
+	Raw      []string          // entries we don't interpret (no '=', windows "=C:" entries), passed through
+	Report   EnvReport
+}
+
+func (b *envBuilder) set(layerName string, name string, val string) {
+	key := envFoldName(name, b.FoldCase)
+	varReport, seen := b.Report.Vars[key]
+	if !seen {
+		b.Order = append(b.Order, key)
+	}
+	b.Entries[key] = name + "=" + val
+	varReport.Layer = layerName
+	varReport.RemovedBy = ""
+	if len(varReport.SetBy) == 0 || varReport.SetBy[len(varReport.SetBy)-1] != layerName {
+		varReport.SetBy = append(varReport.SetBy, layerName)
+	}
+	b.Report.Vars[key] = varReport
+}
+
+func (b *envBuilder) remove(layerName string, name string) {
+	key := envFoldName(name, b.FoldCase)
+	if _, ok := b.Entries[key]; !ok {
+		return
+	}
+	delete(b.Entries, key)
+	varReport := b.Report.Vars[key]
+	varReport.Layer = ""
+	varReport.RemovedBy = layerName
+	b.Report.Vars[key] = varReport
+}
+
+func (b *envBuilder) apply(layer EnvLayer) {
+	b.Report.Layers = append(b.Report.Layers, layer.Name)
+	for _, envStr := range layer.Environ {
+		name, val, found := strings.Cut(envStr, "=")
+		if !found || name == "" {
+			b.Raw = append(b.Raw, envStr)
+			continue
+		}
+		b.set(layer.Name, name, val)
+	}
+	names := make([]string, 0, len(layer.Vars))
+	for name := range layer.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if val := layer.Vars[name]; val != "" {
+			b.set(layer.Name, name, val)
+		} else {
+			b.remove(layer.Name, name)
+		}
+	}
+	for _, name := range layer.Unset {
+		b.remove(layer.Name, name)
+	}
+	if layer.Keep != nil {
+		for _, key := range b.Order {
+			envStr, ok := b.Entries[key]
+			if !ok {
+				continue
+			}
+			name, val, _ := strings.Cut(envStr, "=")
+			if !layer.Keep(name, val) {
+				b.remove(layer.Name, name)
+			}
+		}
+	}
+}
+
+func (b *envBuilder) environ() []string {
+	rtn := make([]string, 0, len(b.Raw)+len(b.Entries))
+	rtn = append(rtn, b.Raw...)
+	for _, key := range b.Order {
+		if envStr, ok := b.Entries[key]; ok {
+			rtn = append(rtn, envStr)
+		}
+	}
+	return rtn
+}
+
+func buildEnv(foldCase bool, layers []EnvLayer) ([]string, EnvReport) {
+	b := &envBuilder{FoldCase: foldCase, Entries: make(map[string]string), Report: EnvReport{Vars: make(map[string]EnvVarReport)}}
+	for _, layer := range layers {
+		b.apply(layer)
+	}
+	return b.environ(), b.Report
+}
+
+func (b *envBuilder) clone() *envBuilder {
+	rtn := &envBuilder{
+		FoldCase: b.FoldCase,
+		Order:    append([]string(nil), b.Order...),
+		Entries:  make(map[string]string, len(b.Entries)),
+		Raw:      append([]string(nil), b.Raw...),
+		Report:   EnvReport{Layers: append([]string(nil), b.Report.Layers...), Vars: make(map[string]EnvVarReport, len(b.Report.Vars))},
+	}
+	for key, envStr := range b.Entries {
+		rtn.Entries[key] = envStr
+	}
+	for key, varReport := range b.Report.Vars {
+		varReport.SetBy = append([]string(nil), varReport.SetBy...)
+		rtn.Report.Vars[key] = varReport
+	}
+	return rtn
+}
+
+// EnvBase is an environment with its lowest layers already applied (parsing
+// os.Environ is most of the work of BuildEnv).  It is immutable, Build can be
+// called concurrently.
+type EnvBase struct {
+	builder *envBuilder
+}
+
+// PrepareEnv applies the first layers of a BuildEnv call, base.Build(rest...) is
+// the same as BuildEnv(layers..., rest...)
+func PrepareEnv(layers ...EnvLayer) *EnvBase {
+	b := &envBuilder{FoldCase: runtime.GOOS == "windows", Entries: make(map[string]string), Report: EnvReport{Vars: make(map[string]EnvVarReport)}}
+	for _, layer := range layers {
+		b.apply(layer)
+	}
+	return &EnvBase{builder: b}
+}
+
+// Build applies layers on top of (a copy of) the base
+func (base *EnvBase) Build(layers ...EnvLayer) ([]string, EnvReport) {
+	b := base.builder.clone()
+	for _, layer := range layers {
+		b.apply(layer)
+	}
+	return b.environ(), b.Report
+}
+
+// BuildEnv merges layers (later layers win, see the EnvLayer_* precedence) into a
+// "NAME=value" list.  Variables keep the position where they were first set, so an
+// inherited var that is overridden stays where it was.  Names are case insensitive
+// on windows (the spelling of the last layer to set one is used).
+func BuildEnv(layers ...EnvLayer) ([]string, EnvReport) {
+	return buildEnv(runtime.GOOS == "windows", layers)
+}
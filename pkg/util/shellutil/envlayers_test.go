@@ -0,0 +1,246 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func checkEnv(t *testing.T, env []string, expected ...string) {
+	t.Helper()
+	if len(env) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("env mismatch\n got: %q\nwant: %q", env, expected)
+	}
+}
+
+func checkVarReport(t *testing.T, report EnvReport, name string, expected EnvVarReport) {
+	t.Helper()
+	if got := report.Vars[name]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("report for %s: got %+v, want %+v", name, got, expected)
+	}
+}
+
+func TestBuildEnvLaterLayersWin(t *testing.T) {
+	env, report := BuildEnv(
+		EnvLayer{Name: "a", Environ: []string{"A=1", "B=1", "C=1"}},
+		EnvLayer{Name: "b", Vars: map[string]string{"B": "2", "C": "2"}},
+		EnvLayer{Name: "c", Vars: map[string]string{"C": "3"}},
+	)
+	// overridden vars keep their original position
+	checkEnv(t, env, "A=1", "B=2", "C=3")
+	checkVarReport(t, report, "A", EnvVarReport{Layer: "a", SetBy: []string{"a"}})
+	checkVarReport(t, report, "B", EnvVarReport{Layer: "b", SetBy: []string{"a", "b"}})
+	checkVarReport(t, report, "C", EnvVarReport{Layer: "c", SetBy: []string{"a", "b", "c"}})
+	if !reflect.DeepEqual(report.Layers, []string{"a", "b", "c"}) {
+		t.Errorf("unexpected layers %q", report.Layers)
+	}
+}
+
+func TestBuildEnvOrderIsArgumentOrder(t *testing.T) {
+	low := EnvLayer{Name: "low", Vars: map[string]string{"X": "low"}}
+	high := EnvLayer{Name: "high", Vars: map[string]string{"X": "high"}}
+	env, report := BuildEnv(low, high)
+	checkEnv(t, env, "X=high")
+	env, report2 := BuildEnv(high, low)
+	checkEnv(t, env, "X=low")
+	if report.WonBy("X") != "high" || report2.WonBy("X") != "low" {
+		t.Errorf("unexpected winners %q %q", report.WonBy("X"), report2.WonBy("X"))
+	}
+}
+
+func TestBuildEnvNewVarsAreSorted(t *testing.T) {
+	env, _ := BuildEnv(
+		EnvLayer{Name: "inherited", Environ: []string{"Z=1"}},
+		EnvLayer{Name: "vars", Vars: map[string]string{"C": "1", "A": "1", "B": "1"}},
+	)
+	checkEnv(t, env, "Z=1", "A=1", "B=1", "C=1")
+}
+
+func TestBuildEnvEnvironDuplicates(t *testing.T) {
+	env, report := BuildEnv(EnvLayer{Name: "inherited", Environ: []string{"A=1", "B=1", "A=2"}})
+	checkEnv(t, env, "A=2", "B=1")
+	// a layer setting a var twice is only listed once
+	checkVarReport(t, report, "A", EnvVarReport{Layer: "inherited", SetBy: []string{"inherited"}})
+}
+
+func TestBuildEnvValuesWithEquals(t *testing.T) {
+	env, _ := BuildEnv(
+		EnvLayer{Name: "inherited", Environ: []string{"A=x=y", "B="}},
+		EnvLayer{Name: "vars", Vars: map[string]string{"C": "1=2"}},
+	)
+	// an inherited empty value is kept (only Vars use "" to unset)
+	checkEnv(t, env, "A=x=y", "B=", "C=1=2")
+}
+
+func TestBuildEnvEmptyValueUnsets(t *testing.T) {
+	env, report := BuildEnv(
+		EnvLayer{Name: "inherited", Environ: []string{"A=1", "B=1"}},
+		EnvLayer{Name: "cmdopts", Vars: map[string]string{"A": "", "NEVERSET": ""}},
+	)
+	checkEnv(t, env, "B=1")
+	checkVarReport(t, report, "A", EnvVarReport{SetBy: []string{"inherited"}, RemovedBy: "cmdopts"})
+	if _, ok := report.Vars["NEVERSET"]; ok {
+		t.Errorf("unsetting a var that was never set should not be reported")
+	}
+	if report.WonBy("A") != "" {
+		t.Errorf("a removed var should not have a winner")
+	}
+}
+
+func TestBuildEnvUnset(t *testing.T) {
+	env, report := BuildEnv(
+		EnvLayer{Name: "inherited", Environ: []string{"A=1", "B=1"}},
+		EnvLayer{Name: "deny", Unset: []string{"B"}},
+	)
+	checkEnv(t, env, "A=1")
+	checkVarReport(t, report, "B", EnvVarReport{SetBy: []string{"inherited"}, RemovedBy: "deny"})
+}
+
+func TestBuildEnvUnsetAfterVarsInSameLayer(t *testing.T) {
+	env, _ := BuildEnv(EnvLayer{Name: "layer", Vars: map[string]string{"A": "1", "B": "1"}, Unset: []string{"A"}})
+	checkEnv(t, env, "B=1")
+}
+
+func TestBuildEnvSetAfterRemove(t *testing.T) {
+	env, report := BuildEnv(
+		EnvLayer{Name: "inherited", Environ: []string{"A=1", "B=1", "C=1"}},
+		EnvLayer{Name: "deny", Unset: []string{"B"}},
+		EnvLayer{Name: "cmdopts", Vars: map[string]string{"B": "2"}},
+	)
+	// a var set again after being removed goes back to its place (and isn't duplicated)
+	checkEnv(t, env, "A=1", "B=2", "C=1")
+	checkVarReport(t, report, "B", EnvVarReport{Layer: "cmdopts", SetBy: []string{"inherited", "cmdopts"}})
+}
+
+func TestBuildEnvKeepFiltersEarlierLayers(t *testing.T) {
+	noSecrets := func(name string, val string) bool { return !strings.HasSuffix(name, "_TOKEN") }
+	env, report := BuildEnv(
+		EnvLayer{Name: "inherited", Environ: []string{"GH_TOKEN=abc", "HOME=/home/me"}},
+		EnvLayer{Name: "filter", Keep: noSecrets},
+		EnvLayer{Name: "cmdopts", Vars: map[string]string{"WAVE_TOKEN": "def"}},
+	)
+	// the filter applies to what came before it, a later layer can still set a matching var
+	checkEnv(t, env, "HOME=/home/me", "WAVE_TOKEN=def")
+	checkVarReport(t, report, "GH_TOKEN", EnvVarReport{SetBy: []string{"inherited"}, RemovedBy: "filter"})
+	checkVarReport(t, report, "WAVE_TOKEN", EnvVarReport{Layer: "cmdopts", SetBy: []string{"cmdopts"}})
+}
+
+func TestBuildEnvKeepSeesValues(t *testing.T) {
+	env, _ := BuildEnv(
+		EnvLayer{Name: "inherited", Environ: []string{"A=small", "B=" + strings.Repeat("x", 100)}},
+		EnvLayer{Name: "size", Keep: func(name string, val string) bool { return len(val) < 10 }},
+	)
+	checkEnv(t, env, "A=small")
+}
+
+func TestBuildEnvKeepAppliesAfterOwnVars(t *testing.T) {
+	env, _ := BuildEnv(EnvLayer{Name: "layer", Vars: map[string]string{"A_TMP": "1", "B": "1"}, Keep: func(name string, val string) bool { return !strings.HasSuffix(name, "_TMP") }})
+	checkEnv(t, env, "B=1")
+}
+
+func TestBuildEnvRawEntries(t *testing.T) {
+	env, report := BuildEnv(EnvLayer{Name: "inherited", Environ: []string{"A=1", "=C:=C:\\dir", "NOEQUALS"}})
+	// entries we can't interpret are passed through (first), CheckEnv decides about them
+	checkEnv(t, env, "=C:=C:\\dir", "NOEQUALS", "A=1")
+	if len(report.Vars) != 1 {
+		t.Errorf("raw entries should not be reported: %+v", report.Vars)
+	}
+}
+
+func TestBuildEnvEmptyLayers(t *testing.T) {
+	env, report := BuildEnv()
+	checkEnv(t, env)
+	if len(report.Vars) != 0 || len(report.Layers) != 0 {
+		t.Errorf("expected an empty report, got %+v", report)
+	}
+	env, report = BuildEnv(EnvLayer{Name: "nil"}, EnvLayer{Name: "inherited", Environ: []string{"A=1"}})
+	checkEnv(t, env, "A=1")
+	if !reflect.DeepEqual(report.Layers, []string{"nil", "inherited"}) {
+		t.Errorf("layers without vars should still be listed: %q", report.Layers)
+	}
+}
+
+func TestBuildEnvFoldCase(t *testing.T) {
+	env, report := buildEnv(true, []EnvLayer{
+		{Name: "inherited", Environ: []string{"Path=C:\\Windows", "TEMP=C:\\Temp"}},
+		{Name: "cmdopts", Vars: map[string]string{"PATH": "C:\\bin", "temp": ""}},
+	})
+	// one entry per name (in the spelling of the layer that won), in its original place
+	checkEnv(t, env, "PATH=C:\\bin")
+	checkVarReport(t, report, "PATH", EnvVarReport{Layer: "cmdopts", SetBy: []string{"inherited", "cmdopts"}})
+	checkVarReport(t, report, "TEMP", EnvVarReport{SetBy: []string{"inherited"}, RemovedBy: "cmdopts"})
+	// without folding these are different vars
+	env, _ = buildEnv(false, []EnvLayer{
+		{Name: "inherited", Environ: []string{"Path=a"}},
+		{Name: "cmdopts", Vars: map[string]string{"PATH": "b"}},
+	})
+	checkEnv(t, env, "Path=a", "PATH=b")
+}
+
+// the stack StartShellProc builds
+func TestBuildEnvStandardLayers(t *testing.T) {
+	env, report := BuildEnv(
+		EnvLayer{Name: EnvLayer_Inherited, Environ: []string{"TERM=dumb", "HOME=/home/me", "ZDOTDIR=/home/me/zsh", "HISTFILE=/home/me/.hist"}},
+		EnvLayer{Name: EnvLayer_Integration, Vars: map[string]string{"ZDOTDIR": "/wave/zsh", WavePushEnvFileVarName: "/wave/pushenv/x.sh"}},
+		EnvLayer{Name: EnvLayer_Waveshell, Vars: map[string]string{"TERM": DefaultTermType, "TERM_PROGRAM": "waveterm"}},
+		EnvLayer{Name: EnvLayer_History, Vars: map[string]string{"HISTFILE": "/wave/history/block.hist"}},
+		EnvLayer{Name: EnvLayer_CmdOpts, Vars: map[string]string{"TERM": "vt100", "MYVAR": "1"}},
+	)
+	checkEnv(t, env,
+		"TERM=vt100", "HOME=/home/me", "ZDOTDIR=/wave/zsh", "HISTFILE=/wave/history/block.hist",
+		WavePushEnvFileVarName+"=/wave/pushenv/x.sh", "TERM_PROGRAM=waveterm", "MYVAR=1")
+	expectedWinners := map[string]string{
+		"TERM":                 EnvLayer_CmdOpts, // the user always wins
+		"HOME":                 EnvLayer_Inherited,
+		"ZDOTDIR":              EnvLayer_Integration,
+		"HISTFILE":             EnvLayer_History,
+		WavePushEnvFileVarName: EnvLayer_Integration,
+		"TERM_PROGRAM":         EnvLayer_Waveshell,
+		"MYVAR":                EnvLayer_CmdOpts,
+	}
+	for name, layer := range expectedWinners {
+		if got := report.WonBy(name); got != layer {
+			t.Errorf("%s: expected %s to win, got %q", name, layer, got)
+		}
+	}
+	checkVarReport(t, report, "TERM", EnvVarReport{Layer: EnvLayer_CmdOpts, SetBy: []string{EnvLayer_Inherited, EnvLayer_Waveshell, EnvLayer_CmdOpts}})
+	ours := report.FromLayers(EnvLayer_Integration, EnvLayer_Waveshell)
+	if !reflect.DeepEqual(ours, []string{"TERM_PROGRAM", WavePushEnvFileVarName, "ZDOTDIR"}) {
+		t.Errorf("unexpected FromLayers result %q", ours)
+	}
+}
+
+// a history scope of "shared" sets no history vars, the inherited value survives
+func TestBuildEnvNilLayerKeepsLowerValues(t *testing.T) {
+	_, report := BuildEnv(
+		EnvLayer{Name: EnvLayer_Inherited, Environ: []string{"HISTFILE=/home/me/.hist"}},
+		EnvLayer{Name: EnvLayer_History, Vars: nil},
+	)
+	if report.WonBy("HISTFILE") != EnvLayer_Inherited {
+		t.Errorf("expected the inherited HISTFILE to win, got %q", report.WonBy("HISTFILE"))
+	}
+}
+
+func TestPrepareEnvMatchesBuildEnv(t *testing.T) {
+	inherited := EnvLayer{Name: EnvLayer_Inherited, Environ: []string{"HOME=/home/me", "TERM=xterm", "=C:=C:\\"}}
+	waveshell := EnvLayer{Name: EnvLayer_Waveshell, Vars: map[string]string{"TERM": "xterm-256color"}}
+	cmdOpts := EnvLayer{Name: EnvLayer_CmdOpts, Vars: map[string]string{"HOME": "", "MYVAR": "1"}}
+	expectedEnv, expectedReport := BuildEnv(inherited, waveshell, cmdOpts)
+	base := PrepareEnv(inherited)
+	for i := 0; i < 2; i++ {
+		// building must not change the base
+		env, report := base.Build(waveshell, cmdOpts)
+		if !reflect.DeepEqual(env, expectedEnv) || !reflect.DeepEqual(report, expectedReport) {
+			t.Errorf("build %d: expected %q %+v, got %q %+v", i, expectedEnv, expectedReport, env, report)
+		}
+	}
+	if env, _ := base.Build(); !reflect.DeepEqual(env, []string{"=C:=C:\\", "HOME=/home/me", "TERM=xterm"}) {
+		t.Errorf("base was modified: %q", env)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/wavebase"
+)
+
+const (
+	HistoryScope_Shared       = ""         // the shell's normal history file
+	HistoryScope_PerBlock     = "perblock" // one history file per block
+	HistoryScope_PerDirectory = "perdir"   // one history file per starting directory
+)
+
+const (
+	WaveHistoryDir          = "history"
+	WaveHistFileVarName     = "WAVETERM_HISTFILE"      // re-applied by our rc files (profiles often reset HISTFILE)
+	FishHistoryVarName      = "fish_history"           // fish only takes a session name (stored in ~/.local/share/fish)
+	WaveFishHistoryVarName  = "WAVETERM_FISH_HISTORY"  // re-applied by our fish startup file
+	WavePwshHistFileVarName = "WAVETERM_PWSH_HISTFILE" // applied by wavepwsh.ps1 (PSReadLine has no env var for it)
+	historyBlockPrefix      = "block-"
+	historyDirPrefix        = "dir-"
+	historyDirHashLen       = 16
+	fishHistoryKeyPrefix    = "wave_"
+)
+
+var historyKeySanitizeRe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+func IsValidHistoryScope(scope string) bool {
+	return scope == HistoryScope_Shared || scope == HistoryScope_PerBlock || scope == HistoryScope_PerDirectory
+}
+
+func GetHistoryDir() string {
+	return filepath.Join(wavebase.GetWaveDataDir(), WaveHistoryDir)
+}
+
+// returns the key identifying the history for the scope ("" for shared history)
+func historyKey(scope string, blockId string, cwd string) (string, error) {
+	switch scope {
+	case HistoryScope_Shared:
+		return "", nil
+	case HistoryScope_PerBlock:
+		if blockId == "" {
+			return "", fmt.Errorf("history scope %q requires a block id", scope)
+		}
+		return historyBlockPrefix + historyKeySanitizeRe.ReplaceAllString(blockId, "_"), nil
+	case HistoryScope_PerDirectory:
+		if cwd == "" {
+			cwd = wavebase.GetHomeDir()
+		}
+		hash := sha256.Sum256([]byte(filepath.Clean(cwd)))
+		return historyDirPrefix + hex.EncodeToString(hash[:])[:historyDirHashLen], nil
+	}
+	return "", fmt.Errorf("invalid history scope %q", scope)
+}
+
+// HistoryEnvVars returns the env vars that implement the history scope for a
+// shell family (nil for shared history).  For shells with a history file the
+// file's parent directory is created.
+func HistoryEnvVars(scope string, family ShellFamily, blockId string, cwd string) (map[string]string, error) {
+	key, err := historyKey(scope, blockId, cwd)
+	if err != nil || key == "" {
+		return nil, err
+	}
+	switch family {
+	case ShellFamily_Fish:
+		historyName := FishHistoryName(key)
+		return map[string]string{FishHistoryVarName: historyName, WaveFishHistoryVarName: historyName}, nil
+	case ShellFamily_Bash, ShellFamily_Zsh, ShellFamily_PosixSh:
+		// separate files per family, the formats are not compatible
+		histFile := filepath.Join(GetHistoryDir(), string(family), key)
+		if err := os.MkdirAll(filepath.Dir(histFile), 0700); err != nil {
+			return nil, fmt.Errorf("cannot create history dir: %w", err)
+		}
+		return map[string]string{"HISTFILE": histFile, WaveHistFileVarName: histFile}, nil
+	case ShellFamily_Pwsh:
+		histFile := filepath.Join(GetHistoryDir(), string(family), key)
+		if err := os.MkdirAll(filepath.Dir(histFile), 0700); err != nil {
+			return nil, fmt.Errorf("cannot create history dir: %w", err)
+		}
+		return map[string]string{WavePwshHistFileVarName: histFile}, nil
+	}
+	// no way to redirect history for other shells
+	return nil, nil
+}
+
+// fish session names can only contain letters, numbers and underscores
+func FishHistoryName(key string) string {
+	return fishHistoryKeyPrefix + strings.ReplaceAll(key, "-", "_")
+}
+
+// CleanupHistoryFiles removes per-block history files for blocks that no longer
+// exist (isActiveBlock returns false) and that have not been written for
+// olderThan.  Per-directory history is kept.  Returns the removed files.
+func CleanupHistoryFiles(isActiveBlock func(blockId string) bool, olderThan time.Duration) ([]string, error) {
+	familyDirs, err := os.ReadDir(GetHistoryDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, familyDir := range familyDirs {
+		if !familyDir.IsDir() {
+			continue
+		}
+		dirPath := filepath.Join(GetHistoryDir(), familyDir.Name())
+		entries, err := os.ReadDir(dirPath)
+		if err != nil {
+			return removed, err
+		}
+		for _, entry := range entries {
+			blockId, isBlock := strings.CutPrefix(entry.Name(), historyBlockPrefix)
+			if !isBlock || entry.IsDir() || isActiveBlock(blockId) {
+				continue
+			}
+			finfo, err := entry.Info()
+			if err != nil || time.Since(finfo.ModTime()) < olderThan {
+				continue
+			}
+			filePath := filepath.Join(dirPath, entry.Name())
+			if err := os.Remove(filePath); err != nil {
+				return removed, err
+			}
+			removed = append(removed, filePath)
+		}
+	}
+	return removed, nil
+}
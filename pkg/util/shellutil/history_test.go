@@ -0,0 +1,92 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/wavebase"
+)
+
+func setTestDataDir(t *testing.T) {
+	oldDataDir := wavebase.DataHome_VarCache
+	wavebase.DataHome_VarCache = t.TempDir()
+	t.Cleanup(func() { wavebase.DataHome_VarCache = oldDataDir })
+}
+
+func TestHistoryEnvVars(t *testing.T) {
+	setTestDataDir(t)
+	env, err := HistoryEnvVars(HistoryScope_Shared, ShellFamily_Bash, "block1", "/tmp")
+	if err != nil || env != nil {
+		t.Errorf("shared history should not set any vars: %v %v", env, err)
+	}
+	env, err = HistoryEnvVars(HistoryScope_PerBlock, ShellFamily_Bash, "block1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	histFile := env["HISTFILE"]
+	if histFile != filepath.Join(GetHistoryDir(), "bash", "block-block1") || env[WaveHistFileVarName] != histFile {
+		t.Errorf("bad per-block env: %v", env)
+	}
+	if finfo, err := os.Stat(filepath.Dir(histFile)); err != nil || !finfo.IsDir() {
+		t.Errorf("history dir was not created: %v", err)
+	}
+	zshEnv, _ := HistoryEnvVars(HistoryScope_PerBlock, ShellFamily_Zsh, "block1", "")
+	if zshEnv["HISTFILE"] == histFile {
+		t.Errorf("bash and zsh should not share history files")
+	}
+	dirEnv1, _ := HistoryEnvVars(HistoryScope_PerDirectory, ShellFamily_Bash, "block1", "/home/me/project")
+	dirEnv2, _ := HistoryEnvVars(HistoryScope_PerDirectory, ShellFamily_Bash, "block2", "/home/me/project/")
+	dirEnv3, _ := HistoryEnvVars(HistoryScope_PerDirectory, ShellFamily_Bash, "block1", "/home/me/other")
+	if dirEnv1["HISTFILE"] != dirEnv2["HISTFILE"] || dirEnv1["HISTFILE"] == dirEnv3["HISTFILE"] {
+		t.Errorf("per-directory history should depend only on the directory: %v %v %v", dirEnv1, dirEnv2, dirEnv3)
+	}
+	fishEnv, _ := HistoryEnvVars(HistoryScope_PerBlock, ShellFamily_Fish, "c0ffee-01", "")
+	if fishEnv[FishHistoryVarName] != "wave_block_c0ffee_01" || fishEnv["HISTFILE"] != "" {
+		t.Errorf("bad fish env: %v", fishEnv)
+	}
+	pwshEnv, _ := HistoryEnvVars(HistoryScope_PerBlock, ShellFamily_Pwsh, "block1", "")
+	if pwshEnv[WavePwshHistFileVarName] != filepath.Join(GetHistoryDir(), "pwsh", "block-block1") || pwshEnv["HISTFILE"] != "" {
+		t.Errorf("bad pwsh env: %v", pwshEnv)
+	}
+	if _, err := HistoryEnvVars(HistoryScope_PerBlock, ShellFamily_Bash, "", ""); err == nil {
+		t.Errorf("per-block history without a block id should fail")
+	}
+	if _, err := HistoryEnvVars("global", ShellFamily_Bash, "block1", ""); err == nil {
+		t.Errorf("invalid scopes should fail")
+	}
+}
+
+func TestCleanupHistoryFiles(t *testing.T) {
+	setTestDataDir(t)
+	var files []string
+	for _, key := range []string{"block1", "block2"} {
+		env, err := HistoryEnvVars(HistoryScope_PerBlock, ShellFamily_Bash, key, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		files = append(files, env["HISTFILE"])
+	}
+	dirEnv, _ := HistoryEnvVars(HistoryScope_PerDirectory, ShellFamily_Bash, "", "/tmp")
+	files = append(files, dirEnv["HISTFILE"])
+	oldTime := time.Now().Add(-48 * time.Hour)
+	for _, file := range files {
+		os.WriteFile(file, []byte("ls\n"), 0600)
+		os.Chtimes(file, oldTime, oldTime)
+	}
+	removed, err := CleanupHistoryFiles(func(blockId string) bool { return blockId == "block1" }, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("cleanup error: %v", err)
+	}
+	if len(removed) != 1 || !strings.HasSuffix(removed[0], "block-block2") {
+		t.Errorf("expected only block2's history to be removed, got %v", removed)
+	}
+	if _, err := os.Stat(files[2]); err != nil {
+		t.Errorf("per-directory history should be kept")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/pkg/wavebase"
+)
+
+const (
+	WavePushEnvDir         = "pushenv"
+	WavePushEnvFileVarName = "WAVETERM_PUSHENV_FILE" // sourced (and removed) by our rc file hooks before the next command
+)
+
+var envVarNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func GetPushEnvDir() string {
+	return filepath.Join(wavebase.GetWaveDataDir(), WavePushEnvDir)
+}
+
+// SupportsPushEnv returns true if our integration for the family sources pushed env files
+func SupportsPushEnv(family ShellFamily) bool {
+	return family == ShellFamily_Bash || family == ShellFamily_Zsh || family == ShellFamily_Fish
+}
+
+// MakeEnvSnippet returns the commands that set updates and unset unsets for the
+// family (values are quoted with Quote).  Names are applied in sorted order, unsets last.
+func MakeEnvSnippet(family ShellFamily, updates map[string]string, unsets []string) (string, error) {
+	if !SupportsPushEnv(family) {
+		return "", fmt.Errorf("env snippets are not supported for %s", family)
+	}
+	names := make([]string, 0, len(updates))
+	for name, val := range updates {
+		if !envVarNameRe.MatchString(name) {
+			return "", fmt.Errorf("invalid env var name %q", name)
+		}
+		if strings.IndexByte(val, 0) >= 0 {
+			return "", fmt.Errorf("env var %s contains a NUL byte", name)
+		}
+		names = append(names, name)
+	}
+	for _, name := range unsets {
+		if !envVarNameRe.MatchString(name) {
+			return "", fmt.Errorf("invalid env var name %q", name)
+		}
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for _, name := range names {
+		if family == ShellFamily_Fish {
+			fmt.Fprintf(&buf, "set -gx %s %s\n", name, family.Quote(updates[name]))
+		} else {
+			fmt.Fprintf(&buf, "export %s=%s\n", name, family.Quote(updates[name]))
+		}
+	}
+	for _, name := range unsets {
+		if family == ShellFamily_Fish {
+			fmt.Fprintf(&buf, "set -e %s\n", name)
+		} else {
+			fmt.Fprintf(&buf, "unset %s\n", name)
+		}
+	}
+	return buf.String(), nil
+}
+
+// AppendPushEnvFile adds snippet to the pending env file at path.  The file is
+// replaced atomically so the hook never sources a partial write.  If the hook
+// takes the file while we are appending, the older snippets are just applied
+// twice (they are idempotent and still come before the new ones).
+func AppendPushEnvFile(path string, snippet string) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading pending env file: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(existing, snippet...), 0600); err != nil {
+		return fmt.Errorf("error writing pending env file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error writing pending env file: %w", err)
+	}
+	return nil
+}
+
+// RemovePushEnvFiles removes the pending env file at path (and any leftovers from applying it)
+func RemovePushEnvFiles(path string) {
+	for _, suffix := range []string{"", ".tmp", ".applying"} {
+		os.Remove(path + suffix)
+	}
+}
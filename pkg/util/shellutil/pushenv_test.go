@@ -0,0 +1,84 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestMakeEnvSnippet(t *testing.T) {
+	snippet, err := MakeEnvSnippet(ShellFamily_Bash, map[string]string{"B": "it's $HOME", "A": "plain"}, []string{"OLD"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "export A=plain\nexport B='it'\"'\"'s $HOME'\nunset OLD\n"
+	if snippet != want {
+		t.Errorf("bash snippet = %q, want %q", snippet, want)
+	}
+	snippet, err = MakeEnvSnippet(ShellFamily_Fish, map[string]string{"A": `it's \`}, []string{"OLD"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "set -gx A 'it\\'s \\\\'\nset -e OLD\n"; snippet != want {
+		t.Errorf("fish snippet = %q, want %q", snippet, want)
+	}
+	badTests := []struct {
+		updates map[string]string
+		unsets  []string
+	}{
+		{map[string]string{"1BAD": "x"}, nil},
+		{map[string]string{"A;rm": "x"}, nil},
+		{map[string]string{"A": "nul\x00"}, nil},
+		{nil, []string{"A B"}},
+	}
+	for _, test := range badTests {
+		if _, err := MakeEnvSnippet(ShellFamily_Bash, test.updates, test.unsets); err == nil {
+			t.Errorf("expected an error for %v %v", test.updates, test.unsets)
+		}
+	}
+	if _, err := MakeEnvSnippet(ShellFamily_Pwsh, map[string]string{"A": "x"}, nil); err == nil {
+		t.Errorf("pwsh should not be supported")
+	}
+}
+
+// runs the generated snippet through a real shell to check the quoting
+func TestEnvSnippetRoundTrip(t *testing.T) {
+	shPath, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+	val := "a 'quoted' \"value\" with $dollar `tick` \\ and\nnewline"
+	snippet, err := MakeEnvSnippet(ShellFamily_Bash, map[string]string{"ROUNDTRIP": val}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	output, err := exec.Command(shPath, "-c", snippet+`printf %s "$ROUNDTRIP"`).Output()
+	if err != nil {
+		t.Fatalf("error running snippet: %v", err)
+	}
+	if string(output) != val {
+		t.Errorf("round trip = %q, want %q", output, val)
+	}
+}
+
+func TestAppendPushEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pushenv.sh")
+	if err := AppendPushEnvFile(path, "export A=1\n"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := AppendPushEnvFile(path, "export B=2\n"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "export A=1\nexport B=2\n" {
+		t.Errorf("unexpected pending file %q", data)
+	}
+	RemovePushEnvFiles(path)
+	if _, err := os.Stat(path); err == nil {
+		t.Errorf("pending file should be removed")
+	}
+}
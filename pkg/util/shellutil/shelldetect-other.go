@@ -0,0 +1,11 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package shellutil
+
+// only windows has a registry
+func lookupRegistryShell() string {
+	return ""
+}
@@ -0,0 +1,27 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package shellutil
+
+import (
+	"golang.org/x/sys/windows/registry"
+)
+
+// returns the DefaultShell an admin set for openssh (the machine's choice of shell), "" if there is none
+func lookupRegistryShell() string {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\OpenSSH`, registry.QUERY_VALUE)
+	if err != nil {
+		return ""
+	}
+	defer key.Close()
+	shellPath, _, err := key.GetStringValue("DefaultShell")
+	if err != nil || shellPath == "" {
+		return ""
+	}
+	if resolvedPath, skipReason := resolveShellCandidate(shellPath); skipReason == "" {
+		return resolvedPath
+	}
+	return ""
+}
@@ -0,0 +1,383 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ShellSource_MacUser         = "macuser"         // from the user's directory record (dscl)
+	ShellSource_Env             = "env"             // from $SHELL
+	ShellSource_Passwd          = "passwd"          // from the user's passwd entry (getpwuid)
+	ShellSource_TerminalProfile = "terminalprofile" // windows terminal's default profile
+	ShellSource_Registry        = "registry"        // the DefaultShell set for openssh on windows (HKLM\SOFTWARE\OpenSSH)
+	ShellSource_Candidate       = "candidate"       // first usable entry in ShellCandidates()
+	ShellSource_Default         = "default"         // nothing usable found
+)
+
+const defaultPasswdFilePath = "/etc/passwd"
+
+// read for the passwd entry, getent is only asked (for users from ldap etc.) when this is the default
+var passwdFilePath = defaultPasswdFilePath
+
+// the shells of accounts that can't log in
+var noLoginShells = map[string]bool{"nologin": true, "false": true}
+
+// windows terminal's dynamic profiles (no commandline) that run a local shell
+var terminalProfileSources = map[string]string{
+	"Windows.Terminal.PowershellCore": "pwsh.exe",
+}
+
+// searched (instead of $PATH) for bare candidate names when $PATH is empty
+var shellSearchDirs = []string{"/usr/local/bin", "/opt/homebrew/bin", "/usr/bin", "/bin"}
+
+var shellCandidatesLock = &sync.Mutex{}
+var shellCandidatesOverride []string
+
+type ShellCandidateResult struct {
+	Candidate  string `json:"candidate"`
+	Path       string `json:"path,omitempty"`       // resolved path (if found)
+	SkipReason string `json:"skipreason,omitempty"` // empty if the candidate was used
+}
+
+// ShellDetectionInfo records how DetectLocalShellPath picked the shell
+type ShellDetectionInfo struct {
+	ShellPath  string                 `json:"shellpath"`
+	Source     string                 `json:"source"`          // ShellSource_*
+	Tried      []ShellCandidateResult `json:"tried,omitempty"` // candidates checked (in order)
+	Resolution *ShellResolution       `json:"resolution,omitempty"`
+}
+
+func defaultShellCandidates(goos string) []string {
+	switch goos {
+	case "windows":
+		return []string{"pwsh.exe", "powershell.exe", "cmd.exe"}
+	case "darwin":
+		return []string{"zsh", "bash", "sh"}
+	default:
+		return []string{"bash", "sh"}
+	}
+}
+
+// ShellCandidates returns the ordered list of shells that are tried when
+// there is no user shell to use.  Candidates are either absolute paths or
+// names that are looked up in $PATH.
+func ShellCandidates() []string {
+	shellCandidatesLock.Lock()
+	defer shellCandidatesLock.Unlock()
+	if shellCandidatesOverride != nil {
+		return append([]string(nil), shellCandidatesOverride...)
+	}
+	return defaultShellCandidates(runtime.GOOS)
+}
+
+// SetShellCandidates overrides the fallback order (nil restores the platform default)
+func SetShellCandidates(candidates []string) {
+	shellCandidatesLock.Lock()
+	defer shellCandidatesLock.Unlock()
+	if candidates == nil {
+		shellCandidatesOverride = nil
+		return
+	}
+	shellCandidatesOverride = append([]string{}, candidates...)
+}
+
+// returns "" if path is usable, otherwise the reason it is not
+func checkShellExecutable(path string) string {
+	finfo, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "not found"
+		}
+		return err.Error()
+	}
+	if finfo.IsDir() {
+		return "is a directory"
+	}
+	if runtime.GOOS != "windows" && finfo.Mode().Perm()&0111 == 0 {
+		return "not executable"
+	}
+	return ""
+}
+
+func resolveShellCandidate(candidate string) (string, string) {
+	if candidate == "" {
+		return "", "empty candidate"
+	}
+	if runtime.GOOS == "windows" {
+		path, err := exec.LookPath(candidate)
+		if err != nil {
+			return "", err.Error()
+		}
+		return path, ""
+	}
+	if strings.ContainsRune(candidate, '/') {
+		return candidate, checkShellExecutable(candidate)
+	}
+	dirs := filepath.SplitList(os.Getenv("PATH"))
+	if len(dirs) == 0 {
+		dirs = shellSearchDirs
+	}
+	skipReason := "not found in path"
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, candidate)
+		reason := checkShellExecutable(path)
+		if reason == "" {
+			return path, ""
+		}
+		if reason != "not found" {
+			// report the most useful reason (e.g. found but not executable)
+			skipReason = path + ": " + reason
+		}
+	}
+	return "", skipReason
+}
+
+func detectCandidateShell() ShellDetectionInfo {
+	var info ShellDetectionInfo
+	for _, candidate := range ShellCandidates() {
+		path, skipReason := resolveShellCandidate(candidate)
+		info.Tried = append(info.Tried, ShellCandidateResult{Candidate: candidate, Path: path, SkipReason: skipReason})
+		if skipReason == "" {
+			info.ShellPath = path
+			info.Source = ShellSource_Candidate
+			return info
+		}
+	}
+	info.Source = ShellSource_Default
+	if runtime.GOOS == "windows" {
+		info.ShellPath = "powershell.exe"
+	} else {
+		info.ShellPath = DefaultShellPath
+	}
+	return info
+}
+
+// the shell field of uid's line in a passwd file ("" if there is none)
+func passwdShell(passwdData string, uid int) string {
+	uidStr := strconv.Itoa(uid)
+	for _, line := range strings.Split(passwdData, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if len(fields) == 7 && fields[2] == uidStr {
+			return fields[6]
+		}
+	}
+	return ""
+}
+
+// returns the login shell from uid's passwd entry, "" if there is none or the account can't log in
+func lookupPasswdShell(uid int) string {
+	var shellPath string
+	if passwdData, err := os.ReadFile(passwdFilePath); err == nil {
+		shellPath = passwdShell(string(passwdData), uid)
+	}
+	if shellPath == "" && passwdFilePath == defaultPasswdFilePath {
+		// getpwuid also finds users that aren't in the file (ldap, sssd, ...)
+		ctx, cancelFn := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancelFn()
+		if out, err := exec.CommandContext(ctx, "getent", "passwd", strconv.Itoa(uid)).Output(); err == nil {
+			shellPath = passwdShell(string(out), uid)
+		}
+	}
+	if shellPath == "" || noLoginShells[filepath.Base(shellPath)] || checkShellExecutable(shellPath) != "" {
+		return ""
+	}
+	return shellPath
+}
+
+// where windows terminal keeps its settings (the store package, its preview, then unpackaged installs)
+func terminalSettingsPaths() []string {
+	localAppData := os.Getenv("LOCALAPPDATA")
+	if localAppData == "" {
+		return nil
+	}
+	return []string{
+		filepath.Join(localAppData, "Packages", "Microsoft.WindowsTerminal_8wekyb3d8bbwe", "LocalState", "settings.json"),
+		filepath.Join(localAppData, "Packages", "Microsoft.WindowsTerminalPreview_8wekyb3d8bbwe", "LocalState", "settings.json"),
+		filepath.Join(localAppData, "Microsoft", "Windows Terminal", "settings.json"),
+	}
+}
+
+type terminalProfile struct {
+	Guid        string `json:"guid"`
+	Name        string `json:"name"`
+	Commandline string `json:"commandline"`
+	Source      string `json:"source"`
+}
+
+// returns the shell windows terminal's default profile runs (its first settings file that has one)
+func lookupTerminalProfileShell() string {
+	for _, settingsPath := range terminalSettingsPaths() {
+		settingsData, err := os.ReadFile(settingsPath)
+		if err != nil {
+			continue
+		}
+		commandline := terminalDefaultCommandline(settingsData)
+		if commandline == "" {
+			continue
+		}
+		if shellPath, skipReason := resolveShellCandidate(commandlineExecutable(commandline)); skipReason == "" {
+			return shellPath
+		}
+	}
+	return ""
+}
+
+// the command line of the default profile in windows terminal's settings, "" if it doesn't run a local
+// shell we know how to find (wsl and azure profiles)
+func terminalDefaultCommandline(settingsData []byte) string {
+	var settings struct {
+		DefaultProfile string          `json:"defaultProfile"`
+		Profiles       json.RawMessage `json:"profiles"`
+	}
+	if err := json.Unmarshal(stripJsonComments(settingsData), &settings); err != nil || settings.DefaultProfile == "" {
+		return ""
+	}
+	// "profiles" is either the list itself (older settings) or {"defaults": ..., "list": [...]}
+	var profiles []terminalProfile
+	if err := json.Unmarshal(settings.Profiles, &profiles); err != nil {
+		var profilesObj struct {
+			List []terminalProfile `json:"list"`
+		}
+		if err := json.Unmarshal(settings.Profiles, &profilesObj); err != nil {
+			return ""
+		}
+		profiles = profilesObj.List
+	}
+	for _, profile := range profiles {
+		// defaultProfile is a guid, or (in older settings) a profile name
+		if !strings.EqualFold(profile.Guid, settings.DefaultProfile) && profile.Name != settings.DefaultProfile {
+			continue
+		}
+		if profile.Commandline != "" {
+			return profile.Commandline
+		}
+		return terminalProfileSources[profile.Source]
+	}
+	return ""
+}
+
+// the program a windows command line runs (its first word, or the quoted part), with %VAR%s expanded
+func commandlineExecutable(commandline string) string {
+	commandline = strings.TrimSpace(commandline)
+	var exe string
+	if strings.HasPrefix(commandline, `"`) {
+		exe, _, _ = strings.Cut(commandline[1:], `"`)
+	} else {
+		exe, _, _ = strings.Cut(commandline, " ")
+	}
+	var buf strings.Builder
+	for {
+		start := strings.IndexByte(exe, '%')
+		end := -1
+		if start >= 0 {
+			end = strings.IndexByte(exe[start+1:], '%')
+		}
+		if end < 0 {
+			buf.WriteString(exe)
+			return buf.String()
+		}
+		name := exe[start+1 : start+1+end]
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			// left as is, like cmd does
+			val = "%" + name + "%"
+		}
+		buf.WriteString(exe[:start])
+		buf.WriteString(val)
+		exe = exe[start+2+end:]
+	}
+}
+
+// windows terminal's settings are json with comments and trailing commas, this removes both
+func stripJsonComments(data []byte) []byte {
+	var rtn bytes.Buffer
+	inString := false
+	for idx := 0; idx < len(data); idx++ {
+		ch := data[idx]
+		if inString {
+			rtn.WriteByte(ch)
+			if ch == '\\' && idx+1 < len(data) {
+				idx++
+				rtn.WriteByte(data[idx])
+			} else if ch == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case ch == '"':
+			inString = true
+			rtn.WriteByte(ch)
+		case ch == '/' && idx+1 < len(data) && data[idx+1] == '/':
+			for idx < len(data) && data[idx] != '\n' {
+				idx++
+			}
+			rtn.WriteByte('\n')
+		case ch == '/' && idx+1 < len(data) && data[idx+1] == '*':
+			end := bytes.Index(data[idx+2:], []byte("*/"))
+			if end < 0 {
+				return rtn.Bytes()
+			}
+			idx += end + 3
+			rtn.WriteByte(' ')
+		case ch == '}' || ch == ']':
+			// drop a trailing comma (and the space after it)
+			trimmed := bytes.TrimRight(rtn.Bytes(), " \t\r\n")
+			if len(trimmed) > 0 && trimmed[len(trimmed)-1] == ',' {
+				rtn.Truncate(len(trimmed) - 1)
+			}
+			rtn.WriteByte(ch)
+		default:
+			rtn.WriteByte(ch)
+		}
+	}
+	return rtn.Bytes()
+}
+
+// DetectLocalShellInfo is DetectLocalShellPath with diagnostics
+func DetectLocalShellInfo() ShellDetectionInfo {
+	info := detectLocalShellInfo()
+	res := ResolveShell(info.ShellPath)
+	info.Resolution = &res
+	return info
+}
+
+// the first of: (windows) windows terminal's default profile, then openssh's DefaultShell; (others) the
+// mac user record, $SHELL, then the passwd entry.  then ShellCandidates()
+func detectLocalShellInfo() ShellDetectionInfo {
+	if runtime.GOOS == "windows" {
+		if shellPath := lookupTerminalProfileShell(); shellPath != "" {
+			return ShellDetectionInfo{ShellPath: shellPath, Source: ShellSource_TerminalProfile}
+		}
+		if shellPath := lookupRegistryShell(); shellPath != "" {
+			return ShellDetectionInfo{ShellPath: shellPath, Source: ShellSource_Registry}
+		}
+		return detectCandidateShell()
+	}
+	if shellPath := lookupMacUserShell(); shellPath != "" {
+		return ShellDetectionInfo{ShellPath: shellPath, Source: ShellSource_MacUser}
+	}
+	if shellPath := os.Getenv("SHELL"); shellPath != "" {
+		return ShellDetectionInfo{ShellPath: shellPath, Source: ShellSource_Env}
+	}
+	if shellPath := lookupPasswdShell(os.Getuid()); shellPath != "" {
+		return ShellDetectionInfo{ShellPath: shellPath, Source: ShellSource_Passwd}
+	}
+	return detectCandidateShell()
+}
@@ -0,0 +1,166 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func writeFakeShell(t *testing.T, path string, mode os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), mode); err != nil {
+		t.Fatalf("error writing fake shell: %v", err)
+	}
+}
+
+// so the candidates are used (the passwd file is read only after $SHELL)
+func noPasswdFile(t *testing.T) {
+	oldPath := passwdFilePath
+	passwdFilePath = filepath.Join(t.TempDir(), "passwd")
+	t.Cleanup(func() { passwdFilePath = oldPath })
+}
+
+// simulates an empty environment (no $SHELL, no $PATH) with only /bin/sh present
+func TestDetectShellOnlySh(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("linux candidate list only")
+	}
+	binDir := t.TempDir()
+	writeFakeShell(t, filepath.Join(binDir, "sh"), 0755)
+	oldDirs := shellSearchDirs
+	shellSearchDirs = []string{binDir}
+	t.Cleanup(func() { shellSearchDirs = oldDirs })
+	noPasswdFile(t)
+	t.Setenv("SHELL", "")
+	t.Setenv("PATH", "")
+
+	info := DetectLocalShellInfo()
+	if info.ShellPath != filepath.Join(binDir, "sh") || info.Source != ShellSource_Candidate {
+		t.Fatalf("expected %s/sh from candidates, got %#v", binDir, info)
+	}
+	expectedTried := []ShellCandidateResult{
+		{Candidate: "bash", SkipReason: "not found in path"},
+		{Candidate: "sh", Path: filepath.Join(binDir, "sh")},
+	}
+	if !reflect.DeepEqual(info.Tried, expectedTried) {
+		t.Errorf("bad candidate diagnostics: %#v", info.Tried)
+	}
+	if DetectLocalShellPath() != info.ShellPath {
+		t.Errorf("DetectLocalShellPath does not match DetectLocalShellInfo")
+	}
+}
+
+func TestSetShellCandidates(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no executable bits on windows")
+	}
+	binDir := t.TempDir()
+	writeFakeShell(t, filepath.Join(binDir, "fish"), 0644)
+	writeFakeShell(t, filepath.Join(binDir, "zsh"), 0755)
+	t.Setenv("SHELL", "")
+	t.Setenv("PATH", binDir)
+	noPasswdFile(t)
+	SetShellCandidates([]string{"fish", filepath.Join(binDir, "nosuchshell"), "zsh"})
+	t.Cleanup(func() { SetShellCandidates(nil) })
+
+	info := DetectLocalShellInfo()
+	if info.ShellPath != filepath.Join(binDir, "zsh") {
+		t.Fatalf("expected zsh, got %#v", info)
+	}
+	if len(info.Tried) != 3 || info.Tried[0].SkipReason != filepath.Join(binDir, "fish")+": not executable" || info.Tried[1].SkipReason != "not found" {
+		t.Errorf("bad candidate diagnostics: %#v", info.Tried)
+	}
+
+	SetShellCandidates([]string{})
+	info = DetectLocalShellInfo()
+	if info.ShellPath != DefaultShellPath || info.Source != ShellSource_Default || len(info.Tried) != 0 {
+		t.Errorf("expected the default shell with no candidates, got %#v", info)
+	}
+	SetShellCandidates(nil)
+	if !reflect.DeepEqual(ShellCandidates(), defaultShellCandidates(runtime.GOOS)) {
+		t.Errorf("SetShellCandidates(nil) should restore the defaults, got %v", ShellCandidates())
+	}
+}
+
+func TestDetectShellFromEnv(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mac uses the user record first")
+	}
+	t.Setenv("SHELL", "/usr/bin/fish")
+	info := DetectLocalShellInfo()
+	if info.ShellPath != "/usr/bin/fish" || info.Source != ShellSource_Env {
+		t.Errorf("expected $SHELL to be used, got %#v", info)
+	}
+}
+
+func TestDetectShellFromPasswd(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mac uses the user record first, windows has no passwd file")
+	}
+	binDir := t.TempDir()
+	writeFakeShell(t, filepath.Join(binDir, "zsh"), 0755)
+	passwdPath := filepath.Join(binDir, "passwd")
+	uid := os.Getuid()
+	passwdData := fmt.Sprintf("root:x:0:0:root:/root:/bin/sh\nmike:x:%d:%d:Mike:/home/mike:%s\n", uid, uid, filepath.Join(binDir, "zsh"))
+	if uid == 0 {
+		passwdData = fmt.Sprintf("root:x:0:0:root:/root:%s\n", filepath.Join(binDir, "zsh"))
+	}
+	if err := os.WriteFile(passwdPath, []byte(passwdData), 0644); err != nil {
+		t.Fatalf("error writing passwd: %v", err)
+	}
+	oldPath := passwdFilePath
+	passwdFilePath = passwdPath
+	t.Cleanup(func() { passwdFilePath = oldPath })
+	t.Setenv("SHELL", "")
+	info := DetectLocalShellInfo()
+	if info.ShellPath != filepath.Join(binDir, "zsh") || info.Source != ShellSource_Passwd {
+		t.Errorf("expected the passwd shell, got %#v", info)
+	}
+	// accounts that can't log in fall through to the candidates
+	if err := os.WriteFile(passwdPath, []byte(fmt.Sprintf("svc:x:%d:%d::/:/usr/sbin/nologin\n", uid, uid)), 0644); err != nil {
+		t.Fatalf("error writing passwd: %v", err)
+	}
+	if info := DetectLocalShellInfo(); info.Source == ShellSource_Passwd {
+		t.Errorf("nologin should not be used, got %#v", info)
+	}
+}
+
+func TestTerminalDefaultCommandline(t *testing.T) {
+	settings := `{
+    // comments and trailing commas are allowed
+    "defaultProfile": "{574e775e-4f2a-5b96-ac1e-a2962a402336}",
+    "profiles": {
+        "defaults": {},
+        "list": [
+            {"guid": "{0caa0dad-35be-5f56-a8ff-afceeeaa6101}", "name": "Command Prompt", "commandline": "%SystemRoot%\\System32\\cmd.exe"},
+            /* a dynamic profile */
+            {"guid": "{574E775E-4F2A-5B96-AC1E-A2962A402336}", "name": "PowerShell", "source": "Windows.Terminal.PowershellCore",},
+        ],
+    },
+}`
+	if cmdline := terminalDefaultCommandline([]byte(settings)); cmdline != "pwsh.exe" {
+		t.Errorf("expected pwsh.exe for the dynamic profile, got %q", cmdline)
+	}
+	oldSettings := `{"defaultProfile": "Command Prompt", "profiles": [{"name": "Command Prompt", "commandline": "\"C:\\Program Files\\Git\\bin\\bash.exe\" --login -i"}]}`
+	cmdline := terminalDefaultCommandline([]byte(oldSettings))
+	if exe := commandlineExecutable(cmdline); exe != `C:\Program Files\Git\bin\bash.exe` {
+		t.Errorf("bad executable %q for %q", exe, cmdline)
+	}
+	wslSettings := `{"defaultProfile": "{a}", "profiles": {"list": [{"guid": "{a}", "source": "Windows.Terminal.Wsl"}]}}`
+	if cmdline := terminalDefaultCommandline([]byte(wslSettings)); cmdline != "" {
+		t.Errorf("expected no shell for a wsl profile, got %q", cmdline)
+	}
+	t.Setenv("WAVE_TEST_ROOT", `C:\Windows`)
+	if exe := commandlineExecutable(`%WAVE_TEST_ROOT%\System32\cmd.exe /k %NOPE_UNSET_VAR%`); exe != `C:\Windows\System32\cmd.exe` {
+		t.Errorf("bad expansion %q", exe)
+	}
+	if exe := commandlineExecutable(`%NOPE_UNSET_VAR%\pwsh.exe`); exe != `%NOPE_UNSET_VAR%\pwsh.exe` {
+		t.Errorf("unset vars should be kept, got %q", exe)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ShellOption.Id for the windows shells DiscoverWindowsShells finds
+const (
+	WindowsShell_Pwsh       = "pwsh"
+	WindowsShell_Powershell = "powershell"
+	WindowsShell_Cmd        = "cmd"
+	WindowsShell_GitBash    = "gitbash"
+	WindowsShell_Msys       = "msys"
+	WindowsShell_Cygwin     = "cygwin"
+)
+
+// ShellOption is an installed shell the user can pick, start it with its ShellPath and Env in the
+// CommandOptsType (shellexec.WithShellOption)
+type ShellOption struct {
+	Id        string            `json:"id"`   // WindowsShell_*
+	Name      string            `json:"name"` // for display (e.g. "Git Bash")
+	ShellPath string            `json:"shellpath"`
+	Env       map[string]string `json:"env,omitempty"` // what the shell needs to set itself up (e.g. MSYSTEM)
+	Family    ShellFamily       `json:"family"`
+}
+
+// how a shell is found: the first of its paths that exists
+type windowsShellSpec struct {
+	Id    string
+	Name  string
+	Env   map[string]string
+	Paths func(env windowsShellEnv) []string
+}
+
+// what discovery looks at, so it can be tested off windows
+type windowsShellEnv struct {
+	Getenv   func(string) string
+	LookPath func(string) (string, error)
+	Exists   func(string) bool
+}
+
+// returns "" for an unset var so joining it never makes a relative path
+func (env windowsShellEnv) join(varName string, elem ...string) string {
+	base := env.Getenv(varName)
+	if base == "" {
+		return ""
+	}
+	return filepath.Join(append([]string{base}, elem...)...)
+}
+
+func (env windowsShellEnv) lookPath(name string) string {
+	path, err := env.LookPath(name)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// the drive windows is on ("C:\"), msys2 and cygwin install at its root
+func (env windowsShellEnv) driveRoot() string {
+	drive := env.Getenv("SystemDrive")
+	if drive == "" {
+		drive = "C:"
+	}
+	return strings.TrimRight(drive, `\/`) + string(filepath.Separator)
+}
+
+var windowsShellSpecs = []windowsShellSpec{
+	{Id: WindowsShell_Pwsh, Name: "PowerShell", Paths: func(env windowsShellEnv) []string {
+		return []string{
+			env.lookPath("pwsh.exe"),
+			env.join("ProgramFiles", "PowerShell", "7", "pwsh.exe"),
+			env.join("ProgramFiles", "PowerShell", "7-preview", "pwsh.exe"),
+			env.join("LOCALAPPDATA", "Microsoft", "WindowsApps", "pwsh.exe"), // the store version
+		}
+	}},
+	{Id: WindowsShell_Powershell, Name: "Windows PowerShell", Paths: func(env windowsShellEnv) []string {
+		return []string{env.join("SystemRoot", "System32", "WindowsPowerShell", "v1.0", "powershell.exe")}
+	}},
+	{Id: WindowsShell_Cmd, Name: "Command Prompt", Paths: func(env windowsShellEnv) []string {
+		return []string{env.Getenv("ComSpec"), env.join("SystemRoot", "System32", "cmd.exe")}
+	}},
+	// bin\bash.exe (not usr\bin\bash.exe) sets up MSYSTEM and PATH like the Git Bash shortcut
+	{Id: WindowsShell_GitBash, Name: "Git Bash", Paths: func(env windowsShellEnv) []string {
+		paths := []string{
+			env.join("ProgramFiles", "Git", "bin", "bash.exe"),
+			env.join("ProgramFiles(x86)", "Git", "bin", "bash.exe"),
+			env.join("LOCALAPPDATA", "Programs", "Git", "bin", "bash.exe"),
+		}
+		if gitPath := env.lookPath("git.exe"); gitPath != "" && strings.EqualFold(filepath.Base(filepath.Dir(gitPath)), "cmd") {
+			// <git>\cmd\git.exe is what the installer puts in PATH
+			paths = append(paths, filepath.Join(filepath.Dir(filepath.Dir(gitPath)), "bin", "bash.exe"))
+		}
+		return paths
+	}},
+	// without CHERE_INVOKING their /etc/profile (sourced by our rc file) cds to the home dir
+	{Id: WindowsShell_Msys, Name: "MSYS2", Env: map[string]string{"MSYSTEM": "UCRT64", "CHERE_INVOKING": "1"}, Paths: func(env windowsShellEnv) []string {
+		return []string{
+			filepath.Join(env.driveRoot(), "msys64", "usr", "bin", "bash.exe"),
+			filepath.Join(env.driveRoot(), "msys32", "usr", "bin", "bash.exe"),
+		}
+	}},
+	{Id: WindowsShell_Cygwin, Name: "Cygwin", Env: map[string]string{"CHERE_INVOKING": "1"}, Paths: func(env windowsShellEnv) []string {
+		return []string{
+			filepath.Join(env.driveRoot(), "cygwin64", "bin", "bash.exe"),
+			filepath.Join(env.driveRoot(), "cygwin", "bin", "bash.exe"),
+		}
+	}},
+}
+
+// DiscoverWindowsShells returns the shells installed on this windows machine (pwsh, windows powershell,
+// cmd, Git Bash, MSYS2 and Cygwin), in that order.  nil on other platforms
+func DiscoverWindowsShells() []ShellOption {
+	if runtime.GOOS != "windows" {
+		return nil
+	}
+	return discoverWindowsShells(windowsShellEnv{
+		Getenv:   os.Getenv,
+		LookPath: exec.LookPath,
+		Exists:   func(path string) bool { return checkShellExecutable(path) == "" },
+	})
+}
+
+func discoverWindowsShells(env windowsShellEnv) []ShellOption {
+	var rtn []ShellOption
+	for _, spec := range windowsShellSpecs {
+		for _, path := range spec.Paths(env) {
+			if path == "" || !env.Exists(path) {
+				continue
+			}
+			rtn = append(rtn, ShellOption{Id: spec.Id, Name: spec.Name, ShellPath: path, Env: maps.Clone(spec.Env), Family: DetectFamily(path)})
+			break
+		}
+	}
+	return rtn
+}
@@ -0,0 +1,63 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiscoverWindowsShells(t *testing.T) {
+	root := t.TempDir()
+	vars := map[string]string{
+		"SystemDrive":  root,
+		"SystemRoot":   filepath.Join(root, "Windows"),
+		"ProgramFiles": filepath.Join(root, "Program Files"),
+		"LOCALAPPDATA": filepath.Join(root, "Users", "me", "AppData", "Local"),
+	}
+	installed := map[string]bool{
+		filepath.Join(root, "Windows", "System32", "WindowsPowerShell", "v1.0", "powershell.exe"): true,
+		filepath.Join(root, "Windows", "System32", "cmd.exe"):                                     true,
+		filepath.Join(root, "Program Files", "PowerShell", "7-preview", "pwsh.exe"):               true,
+		filepath.Join(root, "Tools", "Git", "bin", "bash.exe"):                                    true,
+		filepath.Join(root, "msys64", "usr", "bin", "bash.exe"):                                   true,
+	}
+	env := windowsShellEnv{
+		Getenv: func(name string) string { return vars[name] },
+		LookPath: func(name string) (string, error) {
+			if name == "git.exe" {
+				// a portable git (not in Program Files), found through PATH
+				return filepath.Join(root, "Tools", "Git", "cmd", "git.exe"), nil
+			}
+			return "", fmt.Errorf("%s not found", name)
+		},
+		Exists: func(path string) bool { return installed[path] },
+	}
+	shells := discoverWindowsShells(env)
+	var ids, paths []string
+	for _, shell := range shells {
+		ids = append(ids, shell.Id)
+		paths = append(paths, shell.ShellPath)
+	}
+	expectedIds := []string{WindowsShell_Pwsh, WindowsShell_Powershell, WindowsShell_Cmd, WindowsShell_GitBash, WindowsShell_Msys}
+	if !reflect.DeepEqual(ids, expectedIds) {
+		t.Fatalf("found %v, expected %v (%v)", ids, expectedIds, paths)
+	}
+	if shells[0].ShellPath != filepath.Join(root, "Program Files", "PowerShell", "7-preview", "pwsh.exe") || shells[0].Family != ShellFamily_Pwsh {
+		t.Errorf("bad pwsh %#v", shells[0])
+	}
+	if shells[3].ShellPath != filepath.Join(root, "Tools", "Git", "bin", "bash.exe") || shells[3].Family != ShellFamily_Bash {
+		t.Errorf("bad git bash %#v", shells[3])
+	}
+	if shells[4].Env["MSYSTEM"] == "" || shells[4].Env["CHERE_INVOKING"] != "1" {
+		t.Errorf("bad msys env %v", shells[4].Env)
+	}
+	// the options' envs are their own
+	shells[4].Env["MSYSTEM"] = "MINGW64"
+	if again := discoverWindowsShells(env); again[4].Env["MSYSTEM"] != "UCRT64" {
+		t.Errorf("spec env was changed through an option: %v", again[4].Env)
+	}
+}
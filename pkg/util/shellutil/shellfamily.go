@@ -0,0 +1,216 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/pkg/util/utilfn"
+)
+
+type ShellFamily string
+
+const (
+	ShellFamily_Bash    ShellFamily = "bash"
+	ShellFamily_Zsh     ShellFamily = "zsh"
+	ShellFamily_Fish    ShellFamily = "fish"
+	ShellFamily_Pwsh    ShellFamily = "pwsh" // powershell and pwsh (powershell core)
+	ShellFamily_Cmd     ShellFamily = "cmd"
+	ShellFamily_PosixSh ShellFamily = "sh" // sh, dash, ash, ksh, busybox...
+	ShellFamily_Nushell ShellFamily = "nu"
+	ShellFamily_Unknown ShellFamily = "unknown"
+)
+
+const (
+	QuotingStyle_Posix = "posix" // '...' with '"'"' for embedded quotes
+	QuotingStyle_Fish  = "fish"  // '...' with \' and \\ escapes
+	QuotingStyle_Pwsh  = "pwsh"  // '...' with '' for embedded quotes
+	QuotingStyle_Cmd   = "cmd"   // "..." (no escaping of embedded quotes is possible)
+	QuotingStyle_Nu    = "nu"    // "..." with \" and \\ escapes (nu's '...' can't contain a quote at all)
+)
+
+const (
+	IntegrationMethod_RcFile  = "rcfile"  // bash --rcfile
+	IntegrationMethod_ZDotDir = "zdotdir" // zsh, ZDOTDIR pointing to our startup files
+	IntegrationMethod_DataDir = "datadir" // fish, XDG_DATA_DIRS pointing to our vendor_conf.d
+	IntegrationMethod_Command = "command" // nu -e
+	IntegrationMethod_File    = "file"    // powershell -NoExit -File
+	IntegrationMethod_None    = "none"
+)
+
+const familyProbeTimeout = 2 * time.Second
+
+type ShellCapabilities struct {
+	SupportsLoginFlag  bool   `json:"supportsloginflag"` // -l
+	SupportsRcFileFlag bool   `json:"supportsrcfileflag"`
+	QuotingStyle       string `json:"quotingstyle"`      // QuotingStyle_*
+	IntegrationMethod  string `json:"integrationmethod"` // IntegrationMethod_*
+}
+
+var familyCapabilities = map[ShellFamily]ShellCapabilities{
+	ShellFamily_Bash:    {SupportsLoginFlag: true, SupportsRcFileFlag: true, QuotingStyle: QuotingStyle_Posix, IntegrationMethod: IntegrationMethod_RcFile},
+	ShellFamily_Zsh:     {SupportsLoginFlag: true, QuotingStyle: QuotingStyle_Posix, IntegrationMethod: IntegrationMethod_ZDotDir},
+	ShellFamily_Fish:    {SupportsLoginFlag: true, QuotingStyle: QuotingStyle_Fish, IntegrationMethod: IntegrationMethod_DataDir},
+	ShellFamily_Pwsh:    {QuotingStyle: QuotingStyle_Pwsh, IntegrationMethod: IntegrationMethod_File},
+	ShellFamily_Cmd:     {QuotingStyle: QuotingStyle_Cmd, IntegrationMethod: IntegrationMethod_None},
+	ShellFamily_PosixSh: {SupportsLoginFlag: true, QuotingStyle: QuotingStyle_Posix, IntegrationMethod: IntegrationMethod_None},
+	ShellFamily_Nushell: {SupportsLoginFlag: true, QuotingStyle: QuotingStyle_Nu, IntegrationMethod: IntegrationMethod_Command},
+	// unknown shells are treated as posix shells (what we've always done)
+	ShellFamily_Unknown: {SupportsLoginFlag: true, QuotingStyle: QuotingStyle_Posix, IntegrationMethod: IntegrationMethod_None},
+}
+
+// shell names (after stripping .exe and version suffixes) to families
+var shellNameFamilies = map[string]ShellFamily{
+	"bash":       ShellFamily_Bash,
+	"rbash":      ShellFamily_Bash,
+	"zsh":        ShellFamily_Zsh,
+	"fish":       ShellFamily_Fish,
+	"pwsh":       ShellFamily_Pwsh,
+	"powershell": ShellFamily_Pwsh,
+	"cmd":        ShellFamily_Cmd,
+	"sh":         ShellFamily_PosixSh,
+	"dash":       ShellFamily_PosixSh,
+	"ash":        ShellFamily_PosixSh,
+	"hush":       ShellFamily_PosixSh,
+	"ksh":        ShellFamily_PosixSh,
+	"mksh":       ShellFamily_PosixSh,
+	"busybox":    ShellFamily_PosixSh,
+	"nu":         ShellFamily_Nushell,
+	"nushell":    ShellFamily_Nushell,
+}
+
+// returns the base name of an executable path ("/usr/bin/bash-5.2" => "bash"), handles
+// both slash styles (windows paths may be checked on any os for remote/wsl shells)
+func shellBaseName(shellPath string) string {
+	shellPath = strings.TrimSpace(shellPath)
+	if idx := strings.LastIndexAny(shellPath, `/\`); idx >= 0 {
+		shellPath = shellPath[idx+1:]
+	}
+	name := strings.ToLower(shellPath)
+	name = strings.TrimSuffix(name, ".exe")
+	name = strings.TrimLeft(name, "-") // login shells have argv[0] like "-bash"
+	// strip version suffixes ("bash5", "bash-5.2", "zsh_5.9", "pwsh-preview")
+	end := strings.IndexFunc(name, func(ch rune) bool { return ch < 'a' || ch > 'z' })
+	if end >= 0 {
+		name = name[:end]
+	}
+	return name
+}
+
+// DetectFamily guesses the shell family from the name of the executable
+func DetectFamily(shellPath string) ShellFamily {
+	if family, ok := shellNameFamilies[shellBaseName(shellPath)]; ok {
+		return family
+	}
+	return ShellFamily_Unknown
+}
+
+// familyFromVersionOutput matches the output of "shell --version"
+func familyFromVersionOutput(output string) ShellFamily {
+	output = strings.ToLower(output)
+	switch {
+	case strings.Contains(output, "gnu bash"):
+		return ShellFamily_Bash
+	case strings.HasPrefix(output, "zsh "):
+		return ShellFamily_Zsh
+	case strings.HasPrefix(output, "fish"):
+		return ShellFamily_Fish
+	case strings.HasPrefix(output, "powershell"):
+		return ShellFamily_Pwsh
+	case strings.Contains(output, "busybox"):
+		return ShellFamily_PosixSh
+	}
+	return ShellFamily_Unknown
+}
+
+func (f ShellFamily) Capabilities() ShellCapabilities {
+	if caps, ok := familyCapabilities[f]; ok {
+		return caps
+	}
+	return familyCapabilities[ShellFamily_Unknown]
+}
+
+// Quote quotes val as a single argument for this shell family
+func (f ShellFamily) Quote(val string) string {
+	switch f.Capabilities().QuotingStyle {
+	case QuotingStyle_Fish:
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(val) + "'"
+	case QuotingStyle_Pwsh:
+		return "'" + strings.ReplaceAll(val, "'", "''") + "'"
+	case QuotingStyle_Cmd:
+		return `"` + val + `"`
+	case QuotingStyle_Nu:
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(val) + `"`
+	default:
+		return utilfn.ShellQuote(val, false, -1)
+	}
+}
+
+// ShellArgOpts is what ShellFamily.ShellArgs builds the flags for
+type ShellArgOpts struct {
+	Login       bool
+	Interactive bool
+	CmdStr      string // run this command and exit instead of starting an interactive session
+	ShellPath   string // the executable, powershell's flags depend on the version (see isPwshCore)
+}
+
+// PwshLoginFlag has to be pwsh's very first argument
+const PwshLoginFlag = "-Login"
+
+// isPwshCore is true for pwsh (powershell 6+), false for windows powershell (5.1, powershell.exe)
+func isPwshCore(shellPath string) bool {
+	return shellBaseName(shellPath) == "pwsh"
+}
+
+// pwshExitCodeSuffix is appended to a -Command script so pwsh exits with the exit code of a failed
+// native command.  Without it pwsh only exits 0 or 1 (1 if the last statement failed), except
+// for an explicit exit
+const pwshExitCodeSuffix = "\nif (-not $?) { if ((Test-Path -LiteralPath variable:\\LASTEXITCODE) -and $LASTEXITCODE -ne 0) { exit $LASTEXITCODE } else { exit 1 } }"
+
+// ShellArgs returns the flags that start this family's shell as a login and/or interactive
+// shell and run opts.CmdStr (if set), in the order the shell expects them.  flags the family
+// doesn't have are left out: powershell is interactive by default and its -Login has to be the
+// very first argument (and only exists in pwsh, it does nothing on windows), cmd has neither.
+// powershell always gets -NoLogo, and its command gets pwshExitCodeSuffix.  fish reads config.fish
+// even for -c (with `status is-interactive` false), unlike bash which reads no rc file for -c
+// unless -i or -l is given
+func (f ShellFamily) ShellArgs(opts ShellArgOpts) []string {
+	var rtn []string
+	switch f {
+	case ShellFamily_Cmd:
+		if opts.CmdStr != "" {
+			rtn = append(rtn, "/c", opts.CmdStr)
+		}
+		return rtn
+	case ShellFamily_Pwsh:
+		if opts.Login && isPwshCore(opts.ShellPath) {
+			rtn = append(rtn, PwshLoginFlag)
+		}
+		rtn = append(rtn, "-NoLogo")
+		if opts.CmdStr != "" {
+			rtn = append(rtn, "-Command", opts.CmdStr+pwshExitCodeSuffix)
+		}
+		return rtn
+	}
+	// bash, zsh, fish, nu and posix shells all take -l, -i and -c (fish also spells them --login,
+	// --interactive and --command, nu --login, --interactive and --commands)
+	if opts.Login && f.Capabilities().SupportsLoginFlag {
+		rtn = append(rtn, "-l")
+	}
+	if opts.Interactive {
+		rtn = append(rtn, "-i")
+	}
+	if opts.CmdStr != "" {
+		rtn = append(rtn, "-c", opts.CmdStr)
+	}
+	return rtn
+}
+
+// NuPathInit is the nu command (for nu -e) that puts dir at the front of PATH.  nu keeps PATH as
+// a list (converted from and back to the env string by ENV_CONVERSIONS)
+func NuPathInit(dir string) string {
+	return fmt.Sprintf("$env.PATH = ($env.PATH | prepend %s)", ShellFamily_Nushell.Quote(dir))
+}
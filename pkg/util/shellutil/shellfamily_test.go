@@ -0,0 +1,163 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestDetectFamily(t *testing.T) {
+	tests := []struct {
+		shellPath string
+		expected  ShellFamily
+	}{
+		{"/bin/bash", ShellFamily_Bash},
+		{"/usr/local/bin/bash-5.2", ShellFamily_Bash},
+		{"bash5", ShellFamily_Bash},
+		{"-bash", ShellFamily_Bash},
+		{"/bin/rbash", ShellFamily_Bash},
+		{"/bin/zsh", ShellFamily_Zsh},
+		{"/usr/bin/zsh-5.9", ShellFamily_Zsh},
+		{"/opt/homebrew/bin/fish", ShellFamily_Fish},
+		{"/usr/bin/pwsh", ShellFamily_Pwsh},
+		{"/usr/bin/pwsh-preview", ShellFamily_Pwsh},
+		{`C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`, ShellFamily_Pwsh},
+		{`C:\Program Files\PowerShell\7\pwsh.exe`, ShellFamily_Pwsh},
+		{`C:\Windows\System32\cmd.exe`, ShellFamily_Cmd},
+		{"CMD.EXE", ShellFamily_Cmd},
+		{"/bin/sh", ShellFamily_PosixSh},
+		{"/usr/bin/dash", ShellFamily_PosixSh},
+		{"/bin/ash", ShellFamily_PosixSh},
+		{"/bin/busybox", ShellFamily_PosixSh},
+		{"/bin/hush", ShellFamily_PosixSh},
+		{"/usr/bin/ksh93", ShellFamily_PosixSh},
+		{"/usr/bin/mksh", ShellFamily_PosixSh},
+		{"/usr/bin/nu", ShellFamily_Nushell},
+		{`C:\Users\me\.cargo\bin\nu.exe`, ShellFamily_Nushell},
+		{"/usr/bin/python3", ShellFamily_Unknown},
+		{"/usr/bin/tcsh", ShellFamily_Unknown},
+		{"", ShellFamily_Unknown},
+	}
+	for _, test := range tests {
+		if family := DetectFamily(test.shellPath); family != test.expected {
+			t.Errorf("DetectFamily(%q) = %q; want %q", test.shellPath, family, test.expected)
+		}
+	}
+}
+
+// a symlink is identified by its own name (sh -> bash runs bash in posix mode)
+func TestDetectFamilySymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+	binDir := t.TempDir()
+	target := filepath.Join(binDir, "bash-5.2")
+	if err := os.WriteFile(target, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("error writing target: %v", err)
+	}
+	if err := os.Symlink(target, filepath.Join(binDir, "sh")); err != nil {
+		t.Fatalf("error creating symlink: %v", err)
+	}
+	if family := DetectFamily(filepath.Join(binDir, "sh")); family != ShellFamily_PosixSh {
+		t.Errorf("symlinked sh detected as %q", family)
+	}
+	if family := DetectFamily(target); family != ShellFamily_Bash {
+		t.Errorf("versioned bash detected as %q", family)
+	}
+}
+
+func TestFamilyFromVersionOutput(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected ShellFamily
+	}{
+		{"GNU bash, version 5.2.21(1)-release (x86_64-pc-linux-gnu)", ShellFamily_Bash},
+		{"zsh 5.9 (x86_64-apple-darwin23.0)", ShellFamily_Zsh},
+		{"fish, version 3.7.0", ShellFamily_Fish},
+		{"PowerShell 7.4.1", ShellFamily_Pwsh},
+		{"BusyBox v1.36.1 (2023-11-07 18:53:09 UTC) multi-call binary.", ShellFamily_PosixSh},
+		{"Python 3.12.1", ShellFamily_Unknown},
+	}
+	for _, test := range tests {
+		if family := familyFromVersionOutput(test.output); family != test.expected {
+			t.Errorf("familyFromVersionOutput(%q) = %q; want %q", test.output, family, test.expected)
+		}
+	}
+}
+
+func TestFamilyCapabilities(t *testing.T) {
+	families := []ShellFamily{ShellFamily_Bash, ShellFamily_Zsh, ShellFamily_Fish, ShellFamily_Pwsh, ShellFamily_Cmd, ShellFamily_PosixSh, ShellFamily_Nushell, ShellFamily_Unknown}
+	for _, family := range families {
+		if _, ok := familyCapabilities[family]; !ok {
+			t.Errorf("no capabilities defined for %q", family)
+		}
+	}
+	if caps := ShellFamily_Bash.Capabilities(); !caps.SupportsRcFileFlag || caps.IntegrationMethod != IntegrationMethod_RcFile {
+		t.Errorf("bad bash capabilities: %#v", caps)
+	}
+	if caps := ShellFamily_Cmd.Capabilities(); caps.SupportsLoginFlag || caps.QuotingStyle != QuotingStyle_Cmd {
+		t.Errorf("bad cmd capabilities: %#v", caps)
+	}
+	if caps := ShellFamily("csh").Capabilities(); caps != ShellFamily_Unknown.Capabilities() {
+		t.Errorf("unrecognized families should get the unknown capabilities")
+	}
+}
+
+func TestFamilyQuote(t *testing.T) {
+	tests := []struct {
+		family   ShellFamily
+		val      string
+		expected string
+	}{
+		{ShellFamily_Bash, "plain", "plain"},
+		{ShellFamily_Bash, "it's", `'it'"'"'s'`},
+		{ShellFamily_Zsh, "/my dir", `'/my dir'`},
+		{ShellFamily_Fish, `it's a\b`, `'it\'s a\\b'`},
+		{ShellFamily_Pwsh, "it's", `'it''s'`},
+		{ShellFamily_Cmd, `C:\my dir`, `"C:\my dir"`},
+		{ShellFamily_Nushell, `it's "C:\dir"`, `"it's \"C:\\dir\""`},
+	}
+	for _, test := range tests {
+		if result := test.family.Quote(test.val); result != test.expected {
+			t.Errorf("%s.Quote(%q) = %s; want %s", test.family, test.val, result, test.expected)
+		}
+	}
+}
+
+func TestFamilyShellArgs(t *testing.T) {
+	tests := []struct {
+		family   ShellFamily
+		opts     ShellArgOpts
+		expected []string
+	}{
+		{ShellFamily_Bash, ShellArgOpts{Login: true, Interactive: true}, []string{"-l", "-i"}},
+		{ShellFamily_Bash, ShellArgOpts{CmdStr: "echo hi"}, []string{"-c", "echo hi"}},
+		{ShellFamily_Zsh, ShellArgOpts{Interactive: true}, []string{"-i"}},
+		{ShellFamily_Fish, ShellArgOpts{Login: true, CmdStr: "echo hi"}, []string{"-l", "-c", "echo hi"}},
+		{ShellFamily_Nushell, ShellArgOpts{Login: true}, []string{"-l"}},
+		{ShellFamily_Nushell, ShellArgOpts{Interactive: true, CmdStr: "ls"}, []string{"-i", "-c", "ls"}},
+		{ShellFamily_Pwsh, ShellArgOpts{Login: true, Interactive: true, ShellPath: "/usr/bin/pwsh"}, []string{"-Login", "-NoLogo"}},
+		{ShellFamily_Pwsh, ShellArgOpts{Login: true, ShellPath: `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`}, []string{"-NoLogo"}},
+		{ShellFamily_Pwsh, ShellArgOpts{Interactive: true, CmdStr: "Get-Date", ShellPath: "pwsh"}, []string{"-NoLogo", "-Command", "Get-Date" + pwshExitCodeSuffix}},
+		{ShellFamily_Cmd, ShellArgOpts{Login: true, Interactive: true}, nil},
+		{ShellFamily_Cmd, ShellArgOpts{CmdStr: "dir"}, []string{"/c", "dir"}},
+		{ShellFamily_Unknown, ShellArgOpts{Login: true, CmdStr: "true"}, []string{"-l", "-c", "true"}},
+	}
+	for _, test := range tests {
+		if args := test.family.ShellArgs(test.opts); !slices.Equal(args, test.expected) {
+			t.Errorf("%s %+v: expected %q, got %q", test.family, test.opts, test.expected, args)
+		}
+	}
+}
+
+func TestNuPathInit(t *testing.T) {
+	expected := `$env.PATH = ($env.PATH | prepend "/home/me/my \"wave\"/bin")`
+	if initCmd := NuPathInit(`/home/me/my "wave"/bin`); initCmd != expected {
+		t.Errorf("expected %s, got %s", expected, initCmd)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// features a probed shell has (ShellProbe.Features)
+const (
+	ShellFeature_Osc133        = "osc133"        // our integration (or fish 4+ itself) marks prompts and commands with OSC 133
+	ShellFeature_PromptCommand = "promptcommand" // bash's PROMPT_COMMAND
+	ShellFeature_PrecmdHooks   = "precmd"        // zsh's precmd/preexec hooks (add-zsh-hook), fish's fish_prompt/fish_preexec events
+)
+
+// the probe runs the shell (with its startup files for some shells), so it gets more time than familyProbeTimeout
+const shellProbeTimeout = 5 * time.Second
+
+// shellProbePrefix marks the probe script's lines, anything else (e.g. from a startup file) is ignored
+const shellProbePrefix = "__waveprobe:"
+
+// the posix script identifies bash and zsh too, it also runs for shells we can't name
+const posixProbeScript = `if [ -n "$BASH_VERSION" ]; then
+  echo "__waveprobe:family=bash"; echo "__waveprobe:version=$BASH_VERSION"; echo "__waveprobe:feature=promptcommand"
+  trap : DEBUG 2>/dev/null && echo "__waveprobe:feature=osc133"
+elif [ -n "$ZSH_VERSION" ]; then
+  echo "__waveprobe:family=zsh"; echo "__waveprobe:version=$ZSH_VERSION"
+  autoload -Uz +X add-zsh-hook 2>/dev/null && echo "__waveprobe:feature=precmd" && echo "__waveprobe:feature=osc133"
+else
+  echo "__waveprobe:family=sh"; echo "__waveprobe:version=${KSH_VERSION:-}"
+fi`
+
+var shellProbeScripts = map[ShellFamily]string{
+	ShellFamily_Bash:    posixProbeScript,
+	ShellFamily_Zsh:     posixProbeScript,
+	ShellFamily_PosixSh: posixProbeScript,
+	ShellFamily_Unknown: posixProbeScript,
+	ShellFamily_Fish: `echo "__waveprobe:family=fish"; echo "__waveprobe:version=$version"; echo "__waveprobe:feature=precmd"; ` +
+		`string match -qr -- '^([4-9]|[1-9][0-9])\.' $version; and echo "__waveprobe:feature=osc133"`,
+	ShellFamily_Pwsh:    `Write-Output "__waveprobe:family=pwsh"; Write-Output "__waveprobe:version=$($PSVersionTable.PSVersion)"`,
+	ShellFamily_Nushell: `print "__waveprobe:family=nu"; print $"__waveprobe:version=(version | get version)"`,
+}
+
+// ShellProbe is what ProbeShell found out by running a shell
+type ShellProbe struct {
+	ShellPath string      `json:"shellpath"`
+	Family    ShellFamily `json:"family"`             // from the shell itself, ResolveShell's family if the probe failed
+	Version   string      `json:"version,omitempty"`  // the shell's own version string (e.g. "5.2.15(1)-release")
+	Features  []string    `json:"features,omitempty"` // ShellFeature_*
+	Err       string      `json:"err,omitempty"`
+}
+
+func (sp ShellProbe) HasFeature(feature string) bool {
+	return slices.Contains(sp.Features, feature)
+}
+
+type shellProbeEntry struct {
+	Once    *sync.Once
+	ModTime time.Time // of the executable, a changed binary (e.g. an upgrade) is probed again
+	Size    int64
+	Probe   ShellProbe
+}
+
+var shellProbeLock = &sync.Mutex{}
+var shellProbeCache = make(map[string]*shellProbeEntry)
+
+// ProbeShell runs shellPath once with a probe script (for the family ResolveShell picks, or else the
+// one "shellPath --version" names, the posix one if neither does) to find out which shell it really
+// is, its version and the features our integration uses.  results are cached per path.  cmd isn't
+// run, it has none of the features
+func ProbeShell(shellPath string) ShellProbe {
+	var modTime time.Time
+	var size int64
+	if execPath, err := exec.LookPath(shellPath); err == nil {
+		if finfo, err := os.Stat(execPath); err == nil {
+			modTime, size = finfo.ModTime(), finfo.Size()
+		}
+	}
+	shellProbeLock.Lock()
+	entry := shellProbeCache[shellPath]
+	if entry == nil || !entry.ModTime.Equal(modTime) || entry.Size != size {
+		entry = &shellProbeEntry{Once: &sync.Once{}, ModTime: modTime, Size: size}
+		shellProbeCache[shellPath] = entry
+	}
+	shellProbeLock.Unlock()
+	entry.Once.Do(func() {
+		entry.Probe = runShellProbe(shellPath)
+	})
+	probe := entry.Probe
+	probe.Features = slices.Clone(probe.Features)
+	return probe
+}
+
+// runs "shellPath --version" to identify a shell we can't name (so e.g. a renamed fish gets the fish script)
+func familyFromVersionProbe(shellPath string) ShellFamily {
+	ctx, cancelFn := context.WithTimeout(context.Background(), familyProbeTimeout)
+	defer cancelFn()
+	output, err := exec.CommandContext(ctx, shellPath, "--version").CombinedOutput()
+	if err != nil && len(output) == 0 {
+		return ShellFamily_Unknown
+	}
+	return familyFromVersionOutput(strings.TrimSpace(string(output)))
+}
+
+func runShellProbe(shellPath string) ShellProbe {
+	res := ResolveShell(shellPath)
+	probe := ShellProbe{ShellPath: shellPath, Family: res.Family}
+	if probe.Family == ShellFamily_Unknown && res.Err == "" {
+		probe.Family = familyFromVersionProbe(shellPath)
+	}
+	script, ok := shellProbeScripts[probe.Family]
+	if !ok {
+		return probe
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), shellProbeTimeout)
+	defer cancelFn()
+	ecmd := exec.CommandContext(ctx, shellPath, probe.Family.ShellArgs(ShellArgOpts{CmdStr: script, ShellPath: shellPath})...)
+	// a background job the shell's startup files left running could hold stdout open
+	ecmd.WaitDelay = time.Second
+	output, err := ecmd.Output()
+	if !parseShellProbeOutput(string(output), &probe) {
+		if err == nil {
+			err = fmt.Errorf("no output from probe")
+		}
+		probe.Err = fmt.Sprintf("cannot probe shell %q: %v", shellPath, err)
+		return probe
+	}
+	if res.PosixMode {
+		// bash and zsh run as sh emulate sh and don't read our rc files
+		probe.Family = ShellFamily_PosixSh
+		probe.Features = slices.DeleteFunc(probe.Features, func(feature string) bool { return feature == ShellFeature_Osc133 })
+	}
+	return probe
+}
+
+// fills in probe from the probe script's lines, false if they didn't name a family we know
+func parseShellProbeOutput(output string, probe *ShellProbe) bool {
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		key, val, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || !strings.HasPrefix(key, shellProbePrefix) {
+			continue
+		}
+		switch strings.TrimPrefix(key, shellProbePrefix) {
+		case "family":
+			if _, known := familyCapabilities[ShellFamily(val)]; known {
+				probe.Family = ShellFamily(val)
+				found = true
+			}
+		case "version":
+			probe.Version = val
+		case "feature":
+			if !probe.HasFeature(val) {
+				probe.Features = append(probe.Features, val)
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,97 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseShellProbeOutput(t *testing.T) {
+	output := "welcome from .zshenv\n__waveprobe:family=zsh\n__waveprobe:version=5.9\n__waveprobe:feature=precmd\n" +
+		"__waveprobe:feature=osc133\n__waveprobe:feature=precmd\n"
+	var probe ShellProbe
+	if !parseShellProbeOutput(output, &probe) {
+		t.Fatalf("expected a family in %q", output)
+	}
+	if probe.Family != ShellFamily_Zsh || probe.Version != "5.9" || !reflect.DeepEqual(probe.Features, []string{ShellFeature_PrecmdHooks, ShellFeature_Osc133}) {
+		t.Errorf("bad probe %#v", probe)
+	}
+	for _, badOutput := range []string{"", "family=bash\n", "__waveprobe:family=csh\n"} {
+		var probe ShellProbe
+		if parseShellProbeOutput(badOutput, &probe) {
+			t.Errorf("expected no family in %q, got %#v", badOutput, probe)
+		}
+	}
+}
+
+func TestProbeShellBash(t *testing.T) {
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+	probe := ProbeShell(bashPath)
+	if probe.Family != ShellFamily_Bash || probe.Err != "" || probe.Version == "" {
+		t.Fatalf("bad bash probe %#v", probe)
+	}
+	if !probe.HasFeature(ShellFeature_PromptCommand) || !probe.HasFeature(ShellFeature_Osc133) || probe.HasFeature(ShellFeature_PrecmdHooks) {
+		t.Errorf("bad bash features %v", probe.Features)
+	}
+}
+
+// a wrapper script we can't name is probed with the posix script, and probed again once it changes
+func TestProbeShellWrapper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a posix sh")
+	}
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+	wrapperPath := filepath.Join(t.TempDir(), "mywrapper")
+	writeWrapper := func(target string) {
+		if err := os.WriteFile(wrapperPath, []byte("#!/bin/sh\nexec "+target+" \"$@\"\n"), 0755); err != nil {
+			t.Fatalf("error writing wrapper: %v", err)
+		}
+	}
+	writeWrapper("/bin/sh")
+	probe := ProbeShell(wrapperPath)
+	// /bin/sh may itself be bash (in posix mode, it still sets BASH_VERSION)
+	if probe.Err != "" || (probe.Family != ShellFamily_PosixSh && probe.Family != ShellFamily_Bash) {
+		t.Fatalf("bad sh wrapper probe %#v", probe)
+	}
+	writeWrapper(bashPath)
+	probe = ProbeShell(wrapperPath)
+	if probe.Family != ShellFamily_Bash || !strings.Contains(probe.ShellPath, "mywrapper") {
+		t.Errorf("changed wrapper not probed again: %#v", probe)
+	}
+}
+
+// a shell we can't name that only answers --version gets that family (its probe script then fails)
+func TestProbeShellVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no shell scripts on windows")
+	}
+	fakeShell := filepath.Join(t.TempDir(), "myshell")
+	script := "#!/bin/sh\nif [ \"$1\" = --version ]; then echo 'fish, version 3.7.0'; exit 0; fi\nexit 1\n"
+	if err := os.WriteFile(fakeShell, []byte(script), 0755); err != nil {
+		t.Fatalf("error writing fake shell: %v", err)
+	}
+	probe := ProbeShell(fakeShell)
+	if probe.Family != ShellFamily_Fish || probe.Err == "" {
+		t.Errorf("bad probe for a shell found by --version %#v", probe)
+	}
+}
+
+func TestProbeShellMissing(t *testing.T) {
+	probe := ProbeShell(filepath.Join(t.TempDir(), "bash"))
+	if probe.Err == "" || probe.Family != ShellFamily_Bash || len(probe.Features) != 0 {
+		t.Errorf("bad probe for a missing shell %#v", probe)
+	}
+}
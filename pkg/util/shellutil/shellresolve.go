@@ -0,0 +1,111 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const maxShellLinkHops = 40
+
+// shells that are not symlinks but exec another shell (macOS /bin/sh runs whatever /private/var/select/sh points to)
+var shellRedirects = map[string]string{}
+
+func init() {
+	if runtime.GOOS == "darwin" {
+		shellRedirects["/bin/sh"] = "/private/var/select/sh"
+	}
+}
+
+// ShellResolution records what a shell path actually runs
+type ShellResolution struct {
+	RequestedPath   string      `json:"requestedpath"`
+	RequestedFamily ShellFamily `json:"requestedfamily"`
+	ResolvedPath    string      `json:"resolvedpath"`
+	ResolvedFamily  ShellFamily `json:"resolvedfamily"`
+	Family          ShellFamily `json:"family"`              // the family to use for flags and quoting
+	Chain           []string    `json:"chain,omitempty"`     // each hop, starting with the requested path
+	PosixMode       bool        `json:"posixmode,omitempty"` // bash/zsh invoked as sh (emulates sh)
+	Warning         string      `json:"warning,omitempty"`   // set if the requested and resolved families differ
+	Err             string      `json:"err,omitempty"`       // resolution failed (Family falls back to the requested family)
+}
+
+// follows symlinks (and shellRedirects) one hop at a time, returns the chain of paths
+func resolveShellChain(shellPath string) ([]string, error) {
+	chain := []string{shellPath}
+	curPath := shellPath
+	if !strings.ContainsAny(curPath, `/\`) {
+		lookPath, err := exec.LookPath(curPath)
+		if err != nil {
+			return chain, err
+		}
+		curPath = lookPath
+		chain = append(chain, curPath)
+	}
+	for hop := 0; hop < maxShellLinkHops; hop++ {
+		finfo, err := os.Lstat(curPath)
+		if err != nil {
+			return chain, err
+		}
+		var nextPath string
+		if finfo.Mode()&os.ModeSymlink != 0 {
+			nextPath, err = os.Readlink(curPath)
+			if err != nil {
+				return chain, err
+			}
+			if !filepath.IsAbs(nextPath) {
+				nextPath = filepath.Join(filepath.Dir(curPath), nextPath)
+			}
+		} else if redirect, ok := shellRedirects[curPath]; ok {
+			nextPath = redirect
+		} else {
+			return chain, nil
+		}
+		curPath = nextPath
+		chain = append(chain, curPath)
+	}
+	return chain, fmt.Errorf("too many levels of symbolic links")
+}
+
+// ResolveShell resolves symlinks in shellPath before detecting its family.
+// The requested path should still be used to start the shell (shells look at
+// argv[0]), this only decides which flags and quoting rules apply.
+func ResolveShell(shellPath string) ShellResolution {
+	requestedFamily := DetectFamily(shellPath)
+	res := ShellResolution{
+		RequestedPath:   shellPath,
+		RequestedFamily: requestedFamily,
+		ResolvedPath:    shellPath,
+		ResolvedFamily:  requestedFamily,
+		Family:          requestedFamily,
+	}
+	chain, err := resolveShellChain(shellPath)
+	res.Chain = chain
+	if err != nil {
+		res.Err = err.Error()
+		return res
+	}
+	res.ResolvedPath = chain[len(chain)-1]
+	// busybox applets (busybox sh, and "bash" on some minimal systems) are all ash
+	res.ResolvedFamily = DetectFamily(res.ResolvedPath)
+	res.Family = res.ResolvedFamily
+	if shellBaseName(shellPath) == "sh" && (res.ResolvedFamily == ShellFamily_Bash || res.ResolvedFamily == ShellFamily_Zsh) {
+		// bash and zsh emulate sh when invoked as sh (and bash then ignores --rcfile)
+		res.PosixMode = true
+		res.Family = ShellFamily_PosixSh
+	}
+	if res.ResolvedFamily != ShellFamily_Unknown && res.ResolvedFamily != requestedFamily {
+		res.Warning = fmt.Sprintf("shell %q resolves to %q (%s), using %s rules", shellPath, res.ResolvedPath, res.ResolvedFamily, res.Family)
+	}
+	if res.ResolvedFamily == ShellFamily_Unknown {
+		// nothing better to go on
+		res.Family = requestedFamily
+	}
+	return res
+}
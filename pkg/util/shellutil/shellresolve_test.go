@@ -0,0 +1,99 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// makes a directory of fake shells and symlinks, links maps link name => target
+func makeSymlinkFarm(t *testing.T, shells []string, links map[string]string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+	dir := t.TempDir()
+	for _, name := range shells {
+		writeFakeShell(t, filepath.Join(dir, name), 0755)
+	}
+	for name, target := range links {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatalf("mkdir error: %v", err)
+		}
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatalf("symlink error: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestResolveShellShToDash(t *testing.T) {
+	dir := makeSymlinkFarm(t, []string{"dash"}, map[string]string{"sh": "dash"})
+	res := ResolveShell(filepath.Join(dir, "sh"))
+	if res.Family != ShellFamily_PosixSh || res.ResolvedFamily != ShellFamily_PosixSh || res.PosixMode || res.Warning != "" {
+		t.Errorf("bad sh -> dash resolution: %#v", res)
+	}
+	expectedChain := []string{filepath.Join(dir, "sh"), filepath.Join(dir, "dash")}
+	if !reflect.DeepEqual(res.Chain, expectedChain) || res.ResolvedPath != filepath.Join(dir, "dash") {
+		t.Errorf("bad chain %v", res.Chain)
+	}
+}
+
+func TestResolveShellShToBash(t *testing.T) {
+	dir := makeSymlinkFarm(t, []string{"bash"}, map[string]string{"sh": "bash"})
+	res := ResolveShell(filepath.Join(dir, "sh"))
+	// bash invoked as sh runs in posix mode, so we keep treating it as sh
+	if res.Family != ShellFamily_PosixSh || res.ResolvedFamily != ShellFamily_Bash || !res.PosixMode || res.Warning == "" {
+		t.Errorf("bad sh -> bash resolution: %#v", res)
+	}
+}
+
+func TestResolveShellChains(t *testing.T) {
+	dir := makeSymlinkFarm(t, []string{"bash-5.2", "busybox", "dash"}, map[string]string{
+		"bin/mybash": "../alt/bash",
+		"alt/bash":   "../bash-5.2",
+		"ash":        "busybox",
+		"bin/bash":   "../dash", // dash installed as bash, bashisms would break
+		"loop1":      "loop2",
+		"loop2":      "loop1",
+	})
+	tests := []struct {
+		name     string
+		family   ShellFamily
+		hops     int
+		hasError bool
+	}{
+		{"bin/mybash", ShellFamily_Bash, 3, false},
+		{"ash", ShellFamily_PosixSh, 2, false},
+		{"bin/bash", ShellFamily_PosixSh, 2, false},
+		{"bash-5.2", ShellFamily_Bash, 1, false},
+		{"loop1", ShellFamily_Unknown, maxShellLinkHops + 1, true},
+		{"nosuchshell", ShellFamily_Unknown, 1, true},
+	}
+	for _, test := range tests {
+		res := ResolveShell(filepath.Join(dir, test.name))
+		if res.Family != test.family || len(res.Chain) != test.hops || (res.Err != "") != test.hasError {
+			t.Errorf("%s: got family %q, chain %v, err %q", test.name, res.Family, res.Chain, res.Err)
+		}
+	}
+	if res := ResolveShell(filepath.Join(dir, "bin/bash")); res.RequestedFamily != ShellFamily_Bash || res.Warning == "" {
+		t.Errorf("expected a warning for bash -> dash: %#v", res)
+	}
+}
+
+func TestDetectShellInfoResolution(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("linux only")
+	}
+	dir := makeSymlinkFarm(t, []string{"dash"}, map[string]string{"sh": "dash"})
+	t.Setenv("SHELL", filepath.Join(dir, "sh"))
+	info := DetectLocalShellInfo()
+	if info.Resolution == nil || info.Resolution.ResolvedPath != filepath.Join(dir, "dash") {
+		t.Errorf("detection info is missing the resolution chain: %#v", info.Resolution)
+	}
+}
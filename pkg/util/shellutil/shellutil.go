@@ -36,8 +36,14 @@ const (
 	ZshIntegrationDir  = "shell/zsh"
 	BashIntegrationDir = "shell/bash"
 	PwshIntegrationDir = "shell/pwsh"
+	FishIntegrationDir = "shell/fish" // under an XDG_DATA_DIRS entry (GetFishDataDir), fish reads its vendor_conf.d
 	WaveHomeBinDir     = "bin"
 
+	XdgDataDirsVarName      = "XDG_DATA_DIRS"
+	XdgDataDirsDefault      = "/usr/local/share:/usr/share" // what an unset XDG_DATA_DIRS means
+	WaveFishDataDirVarName  = "WAVETERM_FISH_DATADIR"       // our XDG_DATA_DIRS entry, removed again by our fish startup file
+	WaveFishXdgUnsetVarName = "WAVETERM_FISH_XDG_UNSET"     // XDG_DATA_DIRS was unset before we added our entry
+
 	ZshStartup_Zprofile = `
 # Source the original zprofile
 [ -f ~/.zprofile ] && source ~/.zprofile
@@ -47,6 +53,69 @@ const (
 # Source the original zshrc
 [ -f ~/.zshrc ] && source ~/.zshrc
 
+# per-block/per-directory history (profiles often reset HISTFILE)
+[[ -n "$WAVETERM_HISTFILE" ]] && HISTFILE="$WAVETERM_HISTFILE"
+
+# env pushed by wave for a running shell, applied before the next command (and at each prompt)
+_waveterm_pushenv() {
+  if [[ -n "$WAVETERM_PUSHENV_FILE" && -s "$WAVETERM_PUSHENV_FILE" ]]; then
+    command mv -f "$WAVETERM_PUSHENV_FILE" "$WAVETERM_PUSHENV_FILE.applying" 2>/dev/null && . "$WAVETERM_PUSHENV_FILE.applying"
+    command rm -f "$WAVETERM_PUSHENV_FILE.applying"
+  fi
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook preexec _waveterm_pushenv
+add-zsh-hook precmd _waveterm_pushenv
+
+# report the cwd (OSC 7) at each prompt
+_waveterm_osc7() {
+  local url_path=''
+  {
+    local i ch hexch LC_CTYPE=C LC_COLLATE=C LC_ALL= LANG=
+    for ((i = 1; i <= ${#PWD}; ++i)); do
+      ch="$PWD[i]"
+      if [[ "$ch" =~ [/._~A-Za-z0-9-] ]]; then
+        url_path+="$ch"
+      else
+        printf -v hexch "%02X" "'$ch"
+        url_path+="%${hexch:(-2)}"
+      fi
+    done
+  }
+  printf '\e]7;%s\a' "file://$HOST$url_path"
+}
+add-zsh-hook precmd _waveterm_osc7
+
+# command marks (OSC 133) for wave's command history: D with the exit code and A at each prompt,
+# E with the command line (escaped, \ as \\ and ; and control chars as \xNN) and C before it runs.
+# commands starting with a space are marked without their command line
+_waveterm_cmdmark_precmd() {
+  local cmd_status=$?
+  if [[ -n "$_waveterm_cmdmark_running" ]]; then
+    _waveterm_cmdmark_running=
+    printf '\e]133;D;%s\a' "$cmd_status"
+  fi
+  printf '\e]133;A\a'
+  return $cmd_status
+}
+_waveterm_cmdmark_preexec() {
+  if [[ "$1" != " "* ]]; then
+    local cmd="${1//\\/\\\\}"
+    cmd="${cmd//;/\\x3b}"
+    cmd="${cmd//$'\n'/\\x0a}"
+    cmd="${cmd//$'\r'/\\x0d}"
+    cmd="${cmd//$'\t'/\\x09}"
+    cmd="${cmd//$'\e'/\\x1b}"
+    cmd="${cmd//$'\a'/\\x07}"
+    printf '\e]133;E;%s\a' "$cmd"
+  fi
+  _waveterm_cmdmark_running=1
+  printf '\e]133;C\a'
+}
+# first, so it sees the command's exit code
+precmd_functions=(_waveterm_cmdmark_precmd $precmd_functions)
+add-zsh-hook preexec _waveterm_cmdmark_preexec
+
 export PATH={{.WSHBINDIR}}:$PATH
 if [[ -n ${_comps+x} ]]; then
   source <(wsh completion zsh)
@@ -77,35 +146,155 @@ elif [ -f ~/.profile ]; then
     . ~/.profile
 fi
 
+# per-block/per-directory history (profiles often reset HISTFILE)
+if [ -n "$WAVETERM_HISTFILE" ]; then
+    HISTFILE="$WAVETERM_HISTFILE"
+fi
+
+# env pushed by wave for a running shell, applied at each prompt and (through a DEBUG
+# trap armed by the prompt, unless one is already set) before the next command
+_waveterm_pushenv() {
+    if [[ -n "$WAVETERM_PUSHENV_FILE" && -s "$WAVETERM_PUSHENV_FILE" ]]; then
+        command mv -f "$WAVETERM_PUSHENV_FILE" "$WAVETERM_PUSHENV_FILE.applying" 2>/dev/null && . "$WAVETERM_PUSHENV_FILE.applying"
+        command rm -f "$WAVETERM_PUSHENV_FILE.applying"
+    fi
+}
+PROMPT_COMMAND="${PROMPT_COMMAND:+$PROMPT_COMMAND$'\n'}_waveterm_pushenv"
+
+# command marks (OSC 133) for wave's command history: D with the exit code and A at each prompt,
+# E with the command line (escaped, \ as \\ and ; and control chars as \xNN) and C before it runs.
+# the command line is the newest history entry, HISTCMD only stays at the prompt's value if the
+# command was saved to the history (commands left out by HISTCONTROL are marked without one)
+_waveterm_cmdmark_end() {
+    local cmd_status=$?
+    if [[ -n "$_waveterm_cmdmark_running" ]]; then
+        _waveterm_cmdmark_running=
+        printf '\033]133;D;%s\007' "$cmd_status"
+    fi
+    _waveterm_cmdmark_histcmd=$HISTCMD
+    printf '\033]133;A\007'
+    return $cmd_status
+}
+_waveterm_cmdmark_start() {
+    if [[ "$HISTCMD" == "$_waveterm_cmdmark_histcmd" ]] && [[ "$(LC_ALL=C HISTTIMEFORMAT= builtin history 1)" =~ ^[[:space:]]*[0-9]+[*[:space:]][[:space:]](.*)$ ]]; then
+        local cmd="${BASH_REMATCH[1]}"
+        if [[ "$cmd" != " "* ]]; then
+            cmd="${cmd//\\/\\\\}"
+            cmd="${cmd//;/\\x3b}"
+            cmd="${cmd//$'\n'/\\x0a}"
+            cmd="${cmd//$'\r'/\\x0d}"
+            cmd="${cmd//$'\t'/\\x09}"
+            cmd="${cmd//$'\e'/\\x1b}"
+            cmd="${cmd//$'\a'/\\x07}"
+            printf '\033]133;E;%s\007' "$cmd"
+        fi
+    fi
+    _waveterm_cmdmark_running=1
+    printf '\033]133;C\007'
+}
+PROMPT_COMMAND="_waveterm_cmdmark_end${PROMPT_COMMAND:+$'\n'$PROMPT_COMMAND}"
+
+# runs once per prompt, before the first command of the command line.  an empty command line
+# runs the prompt again (the first thing in PROMPT_COMMAND is _waveterm_cmdmark_end)
+_waveterm_preexec() {
+    [[ -n "$_waveterm_preexec_armed" ]] || return 0
+    _waveterm_preexec_armed=
+    [[ "$BASH_COMMAND" != _waveterm_cmdmark_end ]] || return 0
+    _waveterm_pushenv
+    _waveterm_cmdmark_start
+}
+
+# report the cwd (OSC 7) at each prompt
+_waveterm_osc7() {
+    local LC_ALL=C url_path="" ch hexch i
+    for (( i = 0; i < ${#PWD}; i++ )); do
+        ch="${PWD:i:1}"
+        case "$ch" in
+            [-/._~A-Za-z0-9]) url_path+="$ch" ;;
+            *) printf -v hexch '%02X' "'$ch"; url_path+="%${hexch: -2}" ;;
+        esac
+    done
+    printf '\033]7;file://%s%s\007' "$HOSTNAME" "$url_path"
+}
+PROMPT_COMMAND="${PROMPT_COMMAND:+$PROMPT_COMMAND$'\n'}_waveterm_osc7"
+PROMPT_COMMAND="$PROMPT_COMMAND"$'\n''_waveterm_preexec_armed=1'
+if [[ -z "$(trap -p DEBUG)" ]]; then
+    trap '_waveterm_preexec' DEBUG
+fi
+
 export PATH={{.WSHBINDIR}}:$PATH
 if type _init_completion &>/dev/null; then
   source <(wsh completion bash)
 fi
 
 `
+	FishStartup_Waveterm = `
+# fish runs this (from vendor_conf.d) before the user's config.fish, our XDG_DATA_DIRS entry
+# is taken back out so the shell's children don't see it
+if set -q WAVETERM_FISH_DATADIR
+    if set -q WAVETERM_FISH_XDG_UNSET
+        set -e XDG_DATA_DIRS
+    else
+        set -gx XDG_DATA_DIRS (string join : (string match -v -- "$WAVETERM_FISH_DATADIR" (string split : -- "$XDG_DATA_DIRS")))
+    end
+    set -e WAVETERM_FISH_DATADIR
+    set -e WAVETERM_FISH_XDG_UNSET
+end
+
+# env pushed by wave for a running shell, applied before the next command (and at each prompt)
+function _waveterm_pushenv --on-event fish_preexec --on-event fish_prompt
+    if test -s "$WAVETERM_PUSHENV_FILE"; and command mv -f "$WAVETERM_PUSHENV_FILE" "$WAVETERM_PUSHENV_FILE.applying" 2>/dev/null
+        source "$WAVETERM_PUSHENV_FILE.applying"
+        command rm -f "$WAVETERM_PUSHENV_FILE.applying"
+    end
+end
+
+# the rest waits for the first prompt, so it runs after config.fish
+function _waveterm_init --on-event fish_prompt
+    functions -e _waveterm_init
+    set -gx PATH {{.WSHBINDIR}} $PATH
+    # per-block/per-directory history (config.fish may have set fish_history)
+    if set -q WAVETERM_FISH_HISTORY
+        set -g fish_history $WAVETERM_FISH_HISTORY
+        set -e WAVETERM_FISH_HISTORY
+    end
+end
+`
+
 	PwshStartup_wavepwsh = `
 # no need to source regular profiles since we cannot
 # overwrite those with powershell. Instead we will source
 # this file with -NoExit
 $env:PATH = "{{.WSHBINDIR}}" + "{{.PATHSEP}}" + $env:PATH
+
+# per-block/per-directory history (runs after the profiles, so it wins over their setting)
+if ($env:WAVETERM_PWSH_HISTFILE -and (Get-Module PSReadLine)) {
+    Set-PSReadLineOption -HistorySavePath $env:WAVETERM_PWSH_HISTFILE
+}
 `
 )
 
+// DetectLocalShellPath returns the user's shell (the mac user record, $SHELL or the passwd entry;
+// windows terminal's default profile or the registry on windows), falling back to the first usable
+// entry in ShellCandidates().  DetectLocalShellInfo also says where it came from
 func DetectLocalShellPath() string {
-	if runtime.GOOS == "windows" {
-		return "powershell.exe"
-	}
-	shellPath := GetMacUserShell()
-	if shellPath == "" {
-		shellPath = os.Getenv("SHELL")
+	return DetectLocalShellInfo().ShellPath
+}
+
+// returns the mac user's shell (defaults to /bin/bash)
+func GetMacUserShell() string {
+	if runtime.GOOS != "darwin" {
+		return ""
 	}
+	shellPath := lookupMacUserShell()
 	if shellPath == "" {
 		return DefaultShellPath
 	}
 	return shellPath
 }
 
-func GetMacUserShell() string {
+// returns "" if not on darwin or if the lookup fails
+func lookupMacUserShell() string {
 	if runtime.GOOS != "darwin" {
 		return ""
 	}
@@ -116,23 +305,22 @@ func GetMacUserShell() string {
 }
 
 // dscl . -read /Users/[username] UserShell
-// defaults to /bin/bash
 func internalMacUserShell() string {
 	osUser, err := user.Current()
 	if err != nil {
-		return DefaultShellPath
+		return ""
 	}
 	ctx, cancelFn := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancelFn()
 	userStr := "/Users/" + osUser.Username
 	out, err := exec.CommandContext(ctx, "dscl", ".", "-read", userStr, "UserShell").CombinedOutput()
 	if err != nil {
-		return DefaultShellPath
+		return ""
 	}
 	outStr := strings.TrimSpace(string(out))
 	m := userShellRegexp.FindStringSubmatch(outStr)
 	if m == nil {
-		return DefaultShellPath
+		return ""
 	}
 	return m[1]
 }
@@ -153,34 +341,6 @@ func WaveshellLocalEnvVars(termType string) map[string]string {
 	return rtn
 }
 
-func UpdateCmdEnv(cmd *exec.Cmd, envVars map[string]string) {
-	if len(envVars) == 0 {
-		return
-	}
-	found := make(map[string]bool)
-	var newEnv []string
-	for _, envStr := range cmd.Env {
-		envKey := GetEnvStrKey(envStr)
-		newEnvVal, ok := envVars[envKey]
-		if ok {
-			if newEnvVal == "" {
-				continue
-			}
-			newEnv = append(newEnv, envKey+"="+newEnvVal)
-			found[envKey] = true
-		} else {
-			newEnv = append(newEnv, envStr)
-		}
-	}
-	for envKey, envVal := range envVars {
-		if found[envKey] {
-			continue
-		}
-		newEnv = append(newEnv, envKey+"="+envVal)
-	}
-	cmd.Env = newEnv
-}
-
 func GetEnvStrKey(envStr string) string {
 	eqIdx := strings.Index(envStr, "=")
 	if eqIdx == -1 {
@@ -209,6 +369,27 @@ func GetWavePowershellEnv() string {
 	return filepath.Join(wavebase.GetWaveDataDir(), PwshIntegrationDir, "wavepwsh.ps1")
 }
 
+// GetFishDataDir returns the XDG_DATA_DIRS entry that has our fish startup file
+// (FishIntegrationDir/vendor_conf.d/waveterm.fish)
+func GetFishDataDir() string {
+	return filepath.Dir(filepath.Join(wavebase.GetWaveDataDir(), FishIntegrationDir))
+}
+
+// FishDataDirsEnv returns the env vars that make fish run our startup file, existing is the
+// XDG_DATA_DIRS the shell would get (nil if it is unset).  our entry goes first so the user's
+// vendor files can still override what we define
+func FishDataDirsEnv(dataDir string, existing *string) map[string]string {
+	rtn := map[string]string{WaveFishDataDirVarName: dataDir}
+	dataDirs := XdgDataDirsDefault
+	if existing == nil {
+		rtn[WaveFishXdgUnsetVarName] = "1"
+	} else if *existing != "" {
+		dataDirs = *existing
+	}
+	rtn[XdgDataDirsVarName] = dataDir + ":" + dataDirs
+	return rtn
+}
+
 func GetZshZDotDir() string {
 	return filepath.Join(wavebase.GetWaveDataDir(), ZshIntegrationDir)
 }
@@ -243,6 +424,11 @@ func InitRcFiles(waveHome string, wshBinDir string) error {
 	if err != nil {
 		return err
 	}
+	fishConfDir := filepath.Join(waveHome, FishIntegrationDir, "vendor_conf.d")
+	err = wavebase.CacheEnsureDir(fishConfDir, FishIntegrationDir, 0755, FishIntegrationDir)
+	if err != nil {
+		return err
+	}
 	pwshDir := filepath.Join(waveHome, PwshIntegrationDir)
 	err = wavebase.CacheEnsureDir(pwshDir, PwshIntegrationDir, 0755, PwshIntegrationDir)
 	if err != nil {
@@ -273,6 +459,10 @@ func InitRcFiles(waveHome string, wshBinDir string) error {
 	if err != nil {
 		return fmt.Errorf("error writing bash-integration .bashrc: %v", err)
 	}
+	err = utilfn.WriteTemplateToFile(filepath.Join(fishConfDir, "waveterm.fish"), FishStartup_Waveterm, map[string]string{"WSHBINDIR": fmt.Sprintf(`"%s"`, wshBinDir)})
+	if err != nil {
+		return fmt.Errorf("error writing fish-integration waveterm.fish: %v", err)
+	}
 	var pathSep string
 	if runtime.GOOS == "windows" {
 		pathSep = ";"
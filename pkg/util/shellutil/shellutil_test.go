@@ -0,0 +1,43 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFishDataDirsEnv(t *testing.T) {
+	unsetEnv := FishDataDirsEnv("/w/shell", nil)
+	if unsetEnv[XdgDataDirsVarName] != "/w/shell:"+XdgDataDirsDefault || unsetEnv[WaveFishXdgUnsetVarName] != "1" || unsetEnv[WaveFishDataDirVarName] != "/w/shell" {
+		t.Errorf("bad env for unset XDG_DATA_DIRS: %v", unsetEnv)
+	}
+	existing := "/opt/share:/usr/share"
+	setEnv := FishDataDirsEnv("/w/shell", &existing)
+	if setEnv[XdgDataDirsVarName] != "/w/shell:/opt/share:/usr/share" || setEnv[WaveFishXdgUnsetVarName] != "" {
+		t.Errorf("bad env for set XDG_DATA_DIRS: %v", setEnv)
+	}
+	empty := ""
+	if emptyEnv := FishDataDirsEnv("/w/shell", &empty); emptyEnv[XdgDataDirsVarName] != "/w/shell:"+XdgDataDirsDefault || emptyEnv[WaveFishXdgUnsetVarName] != "" {
+		t.Errorf("bad env for empty XDG_DATA_DIRS: %v", emptyEnv)
+	}
+}
+
+func TestInitRcFilesFish(t *testing.T) {
+	waveHome := t.TempDir()
+	if err := InitRcFiles(waveHome, "/w/bin"); err != nil {
+		t.Fatalf("InitRcFiles: %v", err)
+	}
+	// fish looks in <entry>/fish/vendor_conf.d for each XDG_DATA_DIRS entry (GetFishDataDir)
+	fishFile := filepath.Join(waveHome, "shell", "fish", "vendor_conf.d", "waveterm.fish")
+	data, err := os.ReadFile(fishFile)
+	if err != nil {
+		t.Fatalf("reading fish startup file: %v", err)
+	}
+	if !strings.Contains(string(data), `set -gx PATH "/w/bin" $PATH`) {
+		t.Errorf("fish startup file doesn't set PATH:\n%s", data)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package testutil has helpers shared by tests in other packages
+package testutil
+
+import (
+	"sync"
+	"time"
+)
+
+type fakeTimer struct {
+	Deadline time.Time
+	Ch       chan time.Time
+	Fn       func() // for AfterFunc timers (Ch is nil)
+}
+
+// FakeClock is a manually advanced clock.  Timers only fire from Advance
+// (AfterFunc callbacks run in their own goroutines, like time.AfterFunc).
+type FakeClock struct {
+	Lock   *sync.Mutex
+	Cond   *sync.Cond // broadcast when timers are added
+	Cur    time.Time
+	Timers []*fakeTimer
+}
+
+func NewFakeClock() *FakeClock {
+	lock := &sync.Mutex{}
+	return &FakeClock{Lock: lock, Cond: sync.NewCond(lock), Cur: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (fc *FakeClock) Now() time.Time {
+	fc.Lock.Lock()
+	defer fc.Lock.Unlock()
+	return fc.Cur
+}
+
+func (fc *FakeClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	fc.Lock.Lock()
+	defer fc.Lock.Unlock()
+	timer := &fakeTimer{Deadline: fc.Cur.Add(d), Ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		timer.Ch <- fc.Cur
+		return timer.Ch, func() bool { return false }
+	}
+	fc.Timers = append(fc.Timers, timer)
+	fc.Cond.Broadcast()
+	return timer.Ch, func() bool { return fc.removeTimer(timer) }
+}
+
+// AfterFunc runs fn in its own goroutine once the clock is advanced past d
+func (fc *FakeClock) AfterFunc(d time.Duration, fn func()) func() bool {
+	fc.Lock.Lock()
+	defer fc.Lock.Unlock()
+	if d <= 0 {
+		go fn()
+		return func() bool { return false }
+	}
+	timer := &fakeTimer{Deadline: fc.Cur.Add(d), Fn: fn}
+	fc.Timers = append(fc.Timers, timer)
+	fc.Cond.Broadcast()
+	return func() bool { return fc.removeTimer(timer) }
+}
+
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time {
+	ch, _ := fc.NewTimer(d)
+	return ch
+}
+
+func (fc *FakeClock) removeTimer(timer *fakeTimer) bool {
+	fc.Lock.Lock()
+	defer fc.Lock.Unlock()
+	for idx, t := range fc.Timers {
+		if t == timer {
+			fc.Timers = append(fc.Timers[:idx], fc.Timers[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward and fires every timer whose deadline has passed
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.Lock.Lock()
+	defer fc.Lock.Unlock()
+	fc.Cur = fc.Cur.Add(d)
+	var pending []*fakeTimer
+	for _, timer := range fc.Timers {
+		if timer.Deadline.After(fc.Cur) {
+			pending = append(pending, timer)
+			continue
+		}
+		if timer.Fn != nil {
+			go timer.Fn()
+		} else {
+			timer.Ch <- fc.Cur
+		}
+	}
+	fc.Timers = pending
+}
+
+// Pending returns the number of timers that have not fired or been stopped
+func (fc *FakeClock) Pending() int {
+	fc.Lock.Lock()
+	defer fc.Lock.Unlock()
+	return len(fc.Timers)
+}
+
+// PendingDurations returns the time left on each pending timer (in the order they were created)
+func (fc *FakeClock) PendingDurations() []time.Duration {
+	fc.Lock.Lock()
+	defer fc.Lock.Unlock()
+	var rtn []time.Duration
+	for _, timer := range fc.Timers {
+		rtn = append(rtn, timer.Deadline.Sub(fc.Cur))
+	}
+	return rtn
+}
+
+// WaitPending waits (in real time) until at least n timers are pending, returns false on timeout.
+// Use this before Advance to make sure the code under test has started its timers.
+func (fc *FakeClock) WaitPending(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	stopTimer := time.AfterFunc(timeout, func() {
+		fc.Lock.Lock()
+		defer fc.Lock.Unlock()
+		fc.Cond.Broadcast()
+	})
+	defer stopTimer.Stop()
+	fc.Lock.Lock()
+	defer fc.Lock.Unlock()
+	for len(fc.Timers) < n {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		fc.Cond.Wait()
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package testutil
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	fc := NewFakeClock()
+	startTs := fc.Now()
+	timerCh, stopFn := fc.NewTimer(time.Minute)
+	afterCh := fc.After(2 * time.Minute)
+	fnCh := make(chan struct{})
+	fc.AfterFunc(30*time.Second, func() { close(fnCh) })
+	if !reflect.DeepEqual(fc.PendingDurations(), []time.Duration{time.Minute, 2 * time.Minute, 30 * time.Second}) {
+		t.Fatalf("bad pending timers: %v", fc.PendingDurations())
+	}
+	fc.Advance(30 * time.Second)
+	select {
+	case <-fnCh:
+	case <-time.After(time.Second):
+		t.Fatalf("AfterFunc did not run")
+	}
+	select {
+	case <-timerCh:
+		t.Fatalf("timer fired early")
+	default:
+	}
+	fc.Advance(30 * time.Second)
+	if ts := <-timerCh; !ts.Equal(startTs.Add(time.Minute)) {
+		t.Errorf("bad timer time %v", ts)
+	}
+	if stopFn() {
+		t.Errorf("stopping a fired timer should return false")
+	}
+	_, stopFn = fc.NewTimer(time.Hour)
+	if !stopFn() || fc.Pending() != 1 {
+		t.Errorf("stop should remove the timer, pending: %v", fc.PendingDurations())
+	}
+	go fc.Advance(time.Minute)
+	<-afterCh
+	if !fc.WaitPending(0, time.Second) || fc.WaitPending(1, 10*time.Millisecond) {
+		t.Errorf("WaitPending returned the wrong result")
+	}
+}
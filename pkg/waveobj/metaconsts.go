@@ -52,6 +52,10 @@ const (
 	MetaKey_CmdNoWsh                         = "cmd:nowsh"
 	MetaKey_CmdArgs                          = "cmd:args"
 	MetaKey_CmdShell                         = "cmd:shell"
+	MetaKey_CmdHistoryScope                  = "cmd:historyscope"
+	MetaKey_CmdElevate                       = "cmd:elevate"
+	MetaKey_CmdElevateTool                   = "cmd:elevatetool"
+	MetaKey_CmdTermType                      = "cmd:termtype"
 
 	MetaKey_AiClear                          = "ai:*"
 	MetaKey_AiPresetKey                      = "ai:preset"
@@ -91,6 +95,7 @@ const (
 	MetaKey_TermLocalShellPath               = "term:localshellpath"
 	MetaKey_TermLocalShellOpts               = "term:localshellopts"
 	MetaKey_TermScrollback                   = "term:scrollback"
+	MetaKey_TermCmdHistory                   = "term:cmdhistory"
 	MetaKey_TermVDomSubBlockId               = "term:vdomblockid"
 	MetaKey_TermVDomToolbarBlockId           = "term:vdomtoolbarblockid"
 
@@ -49,8 +49,12 @@ type MetaTSType struct {
 	CmdEnv              map[string]string `json:"cmd:env,omitempty"`
 	CmdCwd              string            `json:"cmd:cwd,omitempty"`
 	CmdNoWsh            bool              `json:"cmd:nowsh,omitempty"`
-	CmdArgs             []string          `json:"cmd:args,omitempty"`  // args for cmd (only if cmd:shell is false)
-	CmdShell            bool              `json:"cmd:shell,omitempty"` // shell expansion for cmd+args (defaults to true)
+	CmdArgs             []string          `json:"cmd:args,omitempty"`         // args for cmd (only if cmd:shell is false)
+	CmdShell            bool              `json:"cmd:shell,omitempty"`        // shell expansion for cmd+args (defaults to true)
+	CmdHistoryScope     string            `json:"cmd:historyscope,omitempty"` // "", "perblock", or "perdir"
+	CmdElevate          bool              `json:"cmd:elevate,omitempty"`      // run as root through sudo/doas (local only)
+	CmdElevateTool      string            `json:"cmd:elevatetool,omitempty"`  // "sudo" (default) or "doas"
+	CmdTermType         string            `json:"cmd:termtype,omitempty"`     // TERM for the shell, "xterm-256color" by default
 
 	// AI options match settings
 	AiClear      bool    `json:"ai:*,omitempty"`
@@ -92,6 +96,7 @@ type MetaTSType struct {
 	TermLocalShellPath     string   `json:"term:localshellpath,omitempty"` // matches settings
 	TermLocalShellOpts     []string `json:"term:localshellopts,omitempty"` // matches settings
 	TermScrollback         *int     `json:"term:scrollback,omitempty"`
+	TermCmdHistory         *bool    `json:"term:cmdhistory,omitempty"` // overrides settings
 	TermVDomSubBlockId     string   `json:"term:vdomblockid,omitempty"`
 	TermVDomToolbarBlockId string   `json:"term:vdomtoolbarblockid,omitempty"`
 
@@ -19,10 +19,11 @@ var instance *Watcher
 var once sync.Once
 
 type Watcher struct {
-	initialized bool
-	watcher     *fsnotify.Watcher
-	mutex       sync.Mutex
-	fullConfig  FullConfigType
+	initialized    bool
+	watcher        *fsnotify.Watcher
+	mutex          sync.Mutex
+	fullConfig     FullConfigType
+	updateHandlers []func(FullConfigType)
 }
 
 type WatcherUpdate struct {
@@ -103,7 +104,19 @@ func (w *Watcher) Close() {
 	}
 }
 
+// RegisterUpdateHandler adds a handler called with the full config when the watcher starts and
+// every time the config changes (register it before Start to get the initial values).  handlers
+// are called with the watcher locked, so they must not call back into it (e.g. GetFullConfig)
+func (w *Watcher) RegisterUpdateHandler(fn func(FullConfigType)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.updateHandlers = append(w.updateHandlers, fn)
+}
+
 func (w *Watcher) broadcast(message WatcherUpdate) {
+	for _, fn := range w.updateHandlers {
+		fn(message.FullConfig)
+	}
 	// send to frontend
 	wps.Broker.Publish(wps.WaveEvent{
 		Event: wps.Event_Config,
@@ -27,6 +27,8 @@ const (
 	ConfigKey_TermLocalShellOpts             = "term:localshellopts"
 	ConfigKey_TermScrollback                 = "term:scrollback"
 	ConfigKey_TermCopyOnSelect               = "term:copyonselect"
+	ConfigKey_TermWarmPool                   = "term:warmpool"
+	ConfigKey_TermCmdHistory                 = "term:cmdhistory"
 
 	ConfigKey_EditorMinimapEnabled           = "editor:minimapenabled"
 	ConfigKey_EditorStickyScrollEnabled      = "editor:stickyscrollenabled"
@@ -72,6 +74,10 @@ const (
 	ConfigKey_TelemetryClear                 = "telemetry:*"
 	ConfigKey_TelemetryEnabled               = "telemetry:enabled"
 
+	ConfigKey_MetricsClear                   = "metrics:*"
+	ConfigKey_MetricsListenAddr              = "metrics:listenaddr"
+	ConfigKey_MetricsBearerToken             = "metrics:bearertoken"
+
 	ConfigKey_ConnClear                      = "conn:*"
 	ConfigKey_ConnAskBeforeWshInstall        = "conn:askbeforewshinstall"
 	ConfigKey_ConnWshEnabled                 = "conn:wshenabled"
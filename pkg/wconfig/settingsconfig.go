@@ -54,6 +54,8 @@ type SettingsType struct {
 	TermLocalShellOpts []string `json:"term:localshellopts,omitempty"`
 	TermScrollback     *int64   `json:"term:scrollback,omitempty"`
 	TermCopyOnSelect   *bool    `json:"term:copyonselect,omitempty"`
+	TermWarmPool       int      `json:"term:warmpool,omitempty"`
+	TermCmdHistory     bool     `json:"term:cmdhistory,omitempty"`
 
 	EditorMinimapEnabled      bool `json:"editor:minimapenabled,omitempty"`
 	EditorStickyScrollEnabled bool `json:"editor:stickyscrollenabled,omitempty"`
@@ -99,6 +101,10 @@ type SettingsType struct {
 	TelemetryClear   bool `json:"telemetry:*,omitempty"`
 	TelemetryEnabled bool `json:"telemetry:enabled,omitempty"`
 
+	MetricsClear       bool   `json:"metrics:*,omitempty"`
+	MetricsListenAddr  string `json:"metrics:listenaddr,omitempty"`
+	MetricsBearerToken string `json:"metrics:bearertoken,omitempty"`
+
 	ConnClear               bool `json:"conn:*,omitempty"`
 	ConnAskBeforeWshInstall bool `json:"conn:askbeforewshinstall,omitempty"`
 	ConnWshEnabled          bool `json:"conn:wshenabled,omitempty"`
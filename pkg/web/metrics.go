@@ -0,0 +1,64 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package web
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/wavetermdev/waveterm/pkg/shellexec"
+)
+
+const MetricsPath = "/metrics"
+
+// the metrics listener is separate from the web server (whose routes need the authkey, which a
+// prometheus scraper doesn't have) and only started when metrics:listenaddr is set.  it serves
+// nothing but MetricsPath: read-only counters (no output, commands or cwds, the only identifiers
+// are the shells' session ids).  it is protected by the address it's bound to (keep it on loopback
+// unless the network is trusted) and, if metrics:bearertoken is set, by that token (which
+// prometheus sends with its authorization/bearer_token config)
+func MakeMetricsListener(listenAddr string) (net.Listener, error) {
+	rtn, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error creating metrics listener at %v: %v", listenAddr, err)
+	}
+	log.Printf("Server [metrics] listening on %s\n", rtn.Addr())
+	return rtn, nil
+}
+
+func makeMetricsHandler(bearerToken string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(MetricsPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if bearerToken != "" {
+			expected := []byte("Bearer " + bearerToken)
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		shellexec.HandleMetrics(w, r)
+	})
+	return mux
+}
+
+// blocking
+func RunMetricsServer(listener net.Listener, bearerToken string) {
+	server := &http.Server{
+		ReadTimeout:    HttpReadTimeout,
+		WriteTimeout:   HttpWriteTimeout,
+		MaxHeaderBytes: HttpMaxHeaderBytes,
+		Handler:        http.TimeoutHandler(makeMetricsHandler(bearerToken), HttpTimeoutDuration, "Timeout"),
+	}
+	err := server.Serve(listener)
+	if err != nil {
+		log.Printf("ERROR: metrics server: %v\n", err)
+	}
+}
@@ -37,6 +37,12 @@ func BlockInfoCommand(w *wshutil.WshRpc, data string, opts *wshrpc.RpcOpts) (*ws
 	return resp, err
 }
 
+// command "cmdhistoryquery", wshserver.CmdHistoryQueryCommand
+func CmdHistoryQueryCommand(w *wshutil.WshRpc, data wshrpc.CmdHistoryQuery, opts *wshrpc.RpcOpts) ([]wshrpc.CmdHistoryEntry, error) {
+	resp, err := sendRpcRequestCallHelper[[]wshrpc.CmdHistoryEntry](w, "cmdhistoryquery", data, opts)
+	return resp, err
+}
+
 // command "connconnect", wshserver.ConnConnectCommand
 func ConnConnectCommand(w *wshutil.WshRpc, data wshrpc.ConnRequest, opts *wshrpc.RpcOpts) error {
 	_, err := sendRpcRequestCallHelper[any](w, "connconnect", data, opts)
@@ -347,6 +353,12 @@ func SetViewCommand(w *wshutil.WshRpc, data wshrpc.CommandBlockSetViewData, opts
 	return err
 }
 
+// command "shellproclist", wshserver.ShellProcListCommand
+func ShellProcListCommand(w *wshutil.WshRpc, opts *wshrpc.RpcOpts) ([]wshrpc.ShellProcInfo, error) {
+	resp, err := sendRpcRequestCallHelper[[]wshrpc.ShellProcInfo](w, "shellproclist", nil, opts)
+	return resp, err
+}
+
 // command "streamcpudata", wshserver.StreamCpuDataCommand
 func StreamCpuDataCommand(w *wshutil.WshRpc, data wshrpc.CpuDataRequest, opts *wshrpc.RpcOpts) chan wshrpc.RespOrErrorUnion[wshrpc.TimeSeriesData] {
 	return sendRpcRequestResponseStreamHelper[wshrpc.TimeSeriesData](w, "streamcpudata", data, opts)
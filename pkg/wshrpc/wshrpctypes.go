@@ -73,6 +73,8 @@ const (
 	Command_GetVar               = "getvar"
 	Command_SetVar               = "setvar"
 	Command_RemoteMkdir          = "remotemkdir"
+	Command_ShellProcList        = "shellproclist"
+	Command_CmdHistoryQuery      = "cmdhistoryquery"
 
 	Command_ConnStatus       = "connstatus"
 	Command_WslStatus        = "wslstatus"
@@ -145,6 +147,8 @@ type WshRpcInterface interface {
 	SetConnectionsConfigCommand(ctx context.Context, data ConnConfigRequest) error
 	BlockInfoCommand(ctx context.Context, blockId string) (*BlockInfoData, error)
 	WaveInfoCommand(ctx context.Context) (*WaveInfoData, error)
+	ShellProcListCommand(ctx context.Context) ([]ShellProcInfo, error)
+	CmdHistoryQueryCommand(ctx context.Context, data CmdHistoryQuery) ([]CmdHistoryEntry, error)
 	WshActivityCommand(ct context.Context, data map[string]int) error
 	ActivityCommand(ctx context.Context, data ActivityUpdate) error
 	GetVarCommand(ctx context.Context, data CommandVarData) (*CommandVarResponseData, error)
@@ -581,6 +585,42 @@ type WaveInfoData struct {
 	DataDir   string `json:"datadir"`
 }
 
+// ShellProcInfo is a running shell, either one of ours or (for local shells only) one a
+// previous wavesrv left running (Orphaned)
+type ShellProcInfo struct {
+	SessionId string `json:"sessionid"`
+	BlockId   string `json:"blockid,omitempty"`
+	ConnName  string `json:"connname,omitempty"`
+	Pid       int    `json:"pid,omitempty"`     // local shells only
+	PtyPath   string `json:"ptypath,omitempty"` // the shell's tty, where it can be found
+	Cwd       string `json:"cwd,omitempty"`
+	StartTs   int64  `json:"startts"`  // for local shells the process's start time (a different one means the pid was reused)
+	OwnerPid  int    `json:"ownerpid"` // the wavesrv that started it
+	Orphaned  bool   `json:"orphaned,omitempty"`
+}
+
+// CmdHistoryEntry is a command run in one of our shells (recorded when term:cmdhistory is set)
+type CmdHistoryEntry struct {
+	Id         int64  `json:"id"`
+	BlockId    string `json:"blockid,omitempty"`
+	ConnName   string `json:"connname,omitempty"` // "" for local shells
+	SessionId  string `json:"sessionid"`
+	Command    string `json:"command"`
+	Cwd        string `json:"cwd,omitempty"`
+	ExitCode   *int   `json:"exitcode,omitempty"`
+	StartTs    int64  `json:"startts"` // unix millis
+	DurationMs int64  `json:"durationms"`
+}
+
+// CmdHistoryQuery selects recorded commands, empty fields match every entry
+type CmdHistoryQuery struct {
+	BlockId  string  `json:"blockid,omitempty"`
+	ConnName *string `json:"connname,omitempty"` // "" for local shells
+	Contains string  `json:"contains,omitempty"` // a substring of the command
+	BeforeId int64   `json:"beforeid,omitempty"` // only entries older than this one (for paging)
+	Limit    int     `json:"limit,omitempty"`    // zero uses the default (100)
+}
+
 type WorkspaceInfoData struct {
 	WindowId      string             `json:"windowid"`
 	WorkspaceData *waveobj.Workspace `json:"workspacedata"`
@@ -635,4 +675,5 @@ type ActivityUpdate struct {
 	Blocks        map[string]int        `json:"blocks,omitempty"`
 	WshCmds       map[string]int        `json:"wshcmds,omitempty"`
 	Conn          map[string]int        `json:"conn,omitempty"`
+	ShellStartup  map[string]int        `json:"shellstartup,omitempty"`
 }
@@ -21,6 +21,7 @@ import (
 	"github.com/wavetermdev/waveterm/pkg/panichandler"
 	"github.com/wavetermdev/waveterm/pkg/remote"
 	"github.com/wavetermdev/waveterm/pkg/remote/conncontroller"
+	"github.com/wavetermdev/waveterm/pkg/shellexec"
 	"github.com/wavetermdev/waveterm/pkg/telemetry"
 	"github.com/wavetermdev/waveterm/pkg/util/envutil"
 	"github.com/wavetermdev/waveterm/pkg/util/utilfn"
@@ -708,6 +709,14 @@ func (ws *WshServer) DismissWshFailCommand(ctx context.Context, connName string)
 	return nil
 }
 
+func (ws *WshServer) ShellProcListCommand(ctx context.Context) ([]wshrpc.ShellProcInfo, error) {
+	return shellexec.ListShellProcs(ctx)
+}
+
+func (ws *WshServer) CmdHistoryQueryCommand(ctx context.Context, data wshrpc.CmdHistoryQuery) ([]wshrpc.CmdHistoryEntry, error) {
+	return shellexec.DefaultCommandHistory().Query(ctx, data)
+}
+
 func (ws *WshServer) BlockInfoCommand(ctx context.Context, blockId string) (*wshrpc.BlockInfoData, error) {
 	blockData, err := wstore.DBMustGet[*waveobj.Block](ctx, blockId)
 	if err != nil {
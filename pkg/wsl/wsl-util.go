@@ -17,6 +17,7 @@ import (
 	"time"
 
 	"github.com/wavetermdev/waveterm/pkg/panichandler"
+	"github.com/wavetermdev/waveterm/pkg/util/shellutil"
 )
 
 func DetectShell(ctx context.Context, client *Distro) (string, error) {
@@ -293,7 +294,5 @@ func GetHomeDir(ctx context.Context, client *Distro) string {
 }
 
 func IsPowershell(shellPath string) bool {
-	// get the base path, and then check contains
-	shellBase := filepath.Base(shellPath)
-	return strings.Contains(shellBase, "powershell") || strings.Contains(shellBase, "pwsh")
+	return shellutil.DetectFamily(shellPath) == shellutil.ShellFamily_Pwsh
 }